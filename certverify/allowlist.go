@@ -0,0 +1,64 @@
+package certverify
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AllowlistVerifier verifies that a certificate is in a set of allowed
+// certificates identified by SHA-256 hash.
+type AllowlistVerifier struct {
+	hashes map[string]struct{}
+}
+
+// NewAllowlistVerifier creates a new verifier from hex-encoded SHA-256
+// hashes of the raw (DER) certificates.
+func NewAllowlistVerifier(hashes ...string) *AllowlistVerifier {
+	v := &AllowlistVerifier{hashes: make(map[string]struct{})}
+	for _, hash := range hashes {
+		if hash = strings.ToLower(strings.TrimSpace(hash)); hash != "" {
+			v.hashes[hash] = struct{}{}
+		}
+	}
+	return v
+}
+
+// Verify checks that cert is in the allowlist.
+func (v *AllowlistVerifier) Verify(_ context.Context, cert *x509.Certificate) error {
+	if cert == nil {
+		return errors.New("missing MDM certificate")
+	}
+	hash := sha256.Sum256(cert.Raw)
+	hexHash := hex.EncodeToString(hash[:])
+	if _, ok := v.hashes[hexHash]; !ok {
+		return fmt.Errorf("certificate not in allowlist: %s", hexHash)
+	}
+	return nil
+}
+
+// AllVerifier verifies certificates using multiple verifiers.
+type AllVerifier struct {
+	verifiers []CertVerifier
+}
+
+// NewAllVerifier creates a new verifier using other verifiers.
+func NewAllVerifier(verifiers ...CertVerifier) *AllVerifier {
+	return &AllVerifier{verifiers: verifiers}
+}
+
+// Verify performs certificate verification.
+// Every verifier must pass (return nil). The error of the first
+// failing verifier is returned and no further verifiers are checked.
+func (v *AllVerifier) Verify(ctx context.Context, cert *x509.Certificate) error {
+	for i, verifier := range v.verifiers {
+		if err := verifier.Verify(ctx, cert); err != nil {
+			return fmt.Errorf("verifier error (%d): %w", i, err)
+		}
+	}
+	return nil
+}
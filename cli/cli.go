@@ -2,19 +2,25 @@
 package cli
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
+	"github.com/micromdm/nanomdm/cryptoutil/keyprovider"
 	"github.com/micromdm/nanomdm/storage"
 	"github.com/micromdm/nanomdm/storage/allmulti"
 	"github.com/micromdm/nanomdm/storage/file"
 	"github.com/micromdm/nanomdm/storage/mysql"
 	"github.com/micromdm/nanomdm/storage/pgsql"
+	"github.com/micromdm/nanomdm/storage/sqlite"
+	"github.com/micromdm/nanomdm/storage/sqlschema"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/lib/pq"
 	"github.com/micromdm/nanolib/log"
+	_ "modernc.org/sqlite"
 )
 
 type StringAccumulator []string
@@ -80,6 +86,12 @@ func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
 				return nil, err
 			}
 			mdmStorage = append(mdmStorage, pgsqlStorage)
+		case "sqlite":
+			sqliteStorage, err := sqliteStorageConfig(dsn, options, logger)
+			if err != nil {
+				return nil, err
+			}
+			mdmStorage = append(mdmStorage, sqliteStorage)
 		default:
 			return nil, fmt.Errorf("unknown storage: %s", storage)
 		}
@@ -97,6 +109,9 @@ func (s *Storage) Parse(logger log.Logger) (storage.AllStorage, error) {
 	), nil
 }
 
+// reportBatchMax is the maximum number of command reports written in a batch.
+const reportBatchMax = 100
+
 var NoStorageOptions = errors.New("storage backend does not support options, please specify no (or empty) options")
 
 func fileStorageConfig(dsn, options string) (*file.FileStorage, error) {
@@ -112,6 +127,9 @@ func mysqlStorageConfig(dsn, options string, logger log.Logger) (*mysql.MySQLSto
 		mysql.WithDSN(dsn),
 		mysql.WithLogger(logger),
 	}
+	var migrate bool
+	schemaMode := schemaCheck
+	collationMode := collationWarn
 	if options != "" {
 		for k, v := range splitOptions(options) {
 			switch k {
@@ -122,12 +140,131 @@ func mysqlStorageConfig(dsn, options string, logger log.Logger) (*mysql.MySQLSto
 				} else if v != "0" {
 					return nil, fmt.Errorf("invalid value for delete option: %q", v)
 				}
+			case "batch":
+				window, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for batch option: %w", err)
+				}
+				opts = append(opts, mysql.WithReportBatching(window, reportBatchMax))
+				logger.Debug("msg", "batching command reports", "window", window)
+			case "keyfile":
+				kp, err := keyprovider.LoadAESGCM(v)
+				if err != nil {
+					return nil, fmt.Errorf("loading key file: %w", err)
+				}
+				opts = append(opts, mysql.WithKeyProvider(kp))
+				migrate = true
+			case "serialize":
+				timeout, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for serialize option: %w", err)
+				}
+				opts = append(opts, mysql.WithSerializedQueue(timeout))
+				logger.Debug("msg", "serialized queue", "lock_timeout", timeout)
+			case "schema":
+				if v != schemaCheck && v != schemaMigrate && v != schemaSkip {
+					return nil, fmt.Errorf("invalid value for schema option: %q", v)
+				}
+				schemaMode = v
+			case "collation":
+				if v != collationWarn && v != collationStrict && v != collationSkip {
+					return nil, fmt.Errorf("invalid value for collation option: %q", v)
+				}
+				collationMode = v
 			default:
 				return nil, fmt.Errorf("invalid option: %q", k)
 			}
 		}
 	}
-	return mysql.New(opts...)
+	s, err := mysql.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchema(s, schemaMode, logger); err != nil {
+		return nil, err
+	}
+	if err = checkCollation(s, collationMode, logger); err != nil {
+		return nil, err
+	}
+	if !migrate {
+		return s, nil
+	}
+	n, err := s.MigratePushCertKeys(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("encrypting push certificate keys: %w", err)
+	}
+	if n > 0 {
+		logger.Info("msg", "encrypted push certificate keys", "count", n)
+	}
+	return s, nil
+}
+
+// Values of the schema storage option.
+const (
+	schemaCheck   = "check"
+	schemaMigrate = "migrate"
+	schemaSkip    = "skip"
+)
+
+// schemaChecker checks and migrates the schema of SQL storage.
+type schemaChecker interface {
+	CheckSchema(context.Context) (*sqlschema.Report, error)
+	MigrateSchema(context.Context) (*sqlschema.Report, error)
+}
+
+// checkSchema checks (or migrates) the schema of store according to
+// the schema storage option. An error describing the mismatch is
+// returned if the schema does not match.
+func checkSchema(store schemaChecker, mode string, logger log.Logger) error {
+	var report *sqlschema.Report
+	var err error
+	switch mode {
+	case schemaSkip:
+		return nil
+	case schemaMigrate:
+		report, err = store.MigrateSchema(context.Background())
+	default:
+		report, err = store.CheckSchema(context.Background())
+	}
+	if err != nil {
+		return fmt.Errorf("checking schema: %w", err)
+	}
+	if err = report.Err(); err != nil {
+		return err
+	}
+	logger.Debug("msg", "schema checked", "mode", mode)
+	return nil
+}
+
+// Values of the MySQL collation storage option.
+const (
+	collationWarn   = "warn"
+	collationStrict = "strict"
+	collationSkip   = "skip"
+)
+
+// checkCollation checks the character sets and collations of the MySQL
+// database according to the collation storage option. Problems are
+// logged as warnings or, in strict mode, returned as an error.
+func checkCollation(store *mysql.MySQLStorage, mode string, logger log.Logger) error {
+	if mode == collationSkip {
+		return nil
+	}
+	report, err := store.CheckCollation(context.Background())
+	if err != nil {
+		return fmt.Errorf("checking collation: %w", err)
+	}
+	if mode == collationStrict {
+		return report.Err()
+	}
+	for _, problem := range report.Problems {
+		logger.Info(
+			"msg", "WARNING: database character set or collation problem: enrollment IDs may be duplicated or mismatched",
+			"problem", problem,
+		)
+	}
+	logger.Debug("msg", "collation checked", "mode", mode, "problems", len(report.Problems))
+	return nil
 }
 
 func splitOptions(s string) map[string]string {
@@ -149,6 +286,8 @@ func pgsqlStorageConfig(dsn, options string, logger log.Logger) (*pgsql.PgSQLSto
 		pgsql.WithDSN(dsn),
 		pgsql.WithLogger(logger),
 	}
+	var migrate bool
+	schemaMode := schemaCheck
 	if options != "" {
 		for k, v := range splitOptions(options) {
 			switch k {
@@ -159,10 +298,115 @@ func pgsqlStorageConfig(dsn, options string, logger log.Logger) (*pgsql.PgSQLSto
 				} else if v != "0" {
 					return nil, fmt.Errorf("invalid value for delete option: %q", v)
 				}
+			case "batch":
+				window, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for batch option: %w", err)
+				}
+				opts = append(opts, pgsql.WithReportBatching(window, reportBatchMax))
+				logger.Debug("msg", "batching command reports", "window", window)
+			case "keyfile":
+				kp, err := keyprovider.LoadAESGCM(v)
+				if err != nil {
+					return nil, fmt.Errorf("loading key file: %w", err)
+				}
+				opts = append(opts, pgsql.WithKeyProvider(kp))
+				migrate = true
+			case "serialize":
+				timeout, err := time.ParseDuration(v)
+				if err != nil {
+					return nil, fmt.Errorf("invalid value for serialize option: %w", err)
+				}
+				opts = append(opts, pgsql.WithSerializedQueue(timeout))
+				logger.Debug("msg", "serialized queue", "lock_timeout", timeout)
+			case "schema":
+				if v != schemaCheck && v != schemaMigrate && v != schemaSkip {
+					return nil, fmt.Errorf("invalid value for schema option: %q", v)
+				}
+				schemaMode = v
+			case "partitioned":
+				if v == "1" {
+					opts = append(opts, pgsql.WithPartitionedTables())
+					logger.Debug("msg", "partitioned tables")
+				} else if v != "0" {
+					return nil, fmt.Errorf("invalid value for partitioned option: %q", v)
+				}
+			default:
+				return nil, fmt.Errorf("invalid option: %q", k)
+			}
+		}
+	}
+	s, err := pgsql.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchema(s, schemaMode, logger); err != nil {
+		return nil, err
+	}
+	if !migrate {
+		return s, nil
+	}
+	n, err := s.MigratePushCertKeys(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("encrypting push certificate keys: %w", err)
+	}
+	if n > 0 {
+		logger.Info("msg", "encrypted push certificate keys", "count", n)
+	}
+	return s, nil
+}
+
+func sqliteStorageConfig(dsn, options string, logger log.Logger) (*sqlite.SQLiteStorage, error) {
+	logger = logger.With("storage", "sqlite")
+	opts := []sqlite.Option{
+		sqlite.WithDSN(dsn),
+		sqlite.WithLogger(logger),
+	}
+	var migrate bool
+	schemaMode := schemaCheck
+	if options != "" {
+		for k, v := range splitOptions(options) {
+			switch k {
+			case "delete":
+				if v == "1" {
+					opts = append(opts, sqlite.WithDeleteCommands())
+					logger.Debug("msg", "deleting commands")
+				} else if v != "0" {
+					return nil, fmt.Errorf("invalid value for delete option: %q", v)
+				}
+			case "keyfile":
+				kp, err := keyprovider.LoadAESGCM(v)
+				if err != nil {
+					return nil, fmt.Errorf("loading key file: %w", err)
+				}
+				opts = append(opts, sqlite.WithKeyProvider(kp))
+				migrate = true
+			case "schema":
+				if v != schemaCheck && v != schemaMigrate && v != schemaSkip {
+					return nil, fmt.Errorf("invalid value for schema option: %q", v)
+				}
+				schemaMode = v
 			default:
 				return nil, fmt.Errorf("invalid option: %q", k)
 			}
 		}
 	}
-	return pgsql.New(opts...)
+	s, err := sqlite.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkSchema(s, schemaMode, logger); err != nil {
+		return nil, err
+	}
+	if !migrate {
+		return s, nil
+	}
+	n, err := s.MigratePushCertKeys(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("encrypting push certificate keys: %w", err)
+	}
+	if n > 0 {
+		logger.Info("msg", "encrypted push certificate keys", "count", n)
+	}
+	return s, nil
 }
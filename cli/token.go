@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/nanomdm"
+)
+
+// TokenProviders accumulates GetToken provider configurations.
+// Each is of the form "<TokenServiceType>=<provider>:<argument>".
+//
+// Supported providers:
+//
+//	static:<token>      responds with the static token string
+//	static-file:<path>  responds with the static contents of path
+//	http:<url>          calls out to an HTTP service for the token
+//	signed:<path>       issues tokens signed with the HMAC key in path
+type TokenProviders struct {
+	Providers StringAccumulator
+}
+
+// NewTokenProviders creates a new TokenProviders.
+func NewTokenProviders() *TokenProviders {
+	return &TokenProviders{}
+}
+
+// Register parses the configured GetToken providers and registers them with mux.
+func (t *TokenProviders) Register(mux *nanomdm.TokenMux, client *http.Client) error {
+	for _, spec := range t.Providers {
+		serviceType, handler, err := parseTokenProvider(spec, client)
+		if err != nil {
+			return fmt.Errorf("token provider %q: %w", spec, err)
+		}
+		mux.Handle(serviceType, handler)
+	}
+	return nil
+}
+
+func parseTokenProvider(spec string, client *http.Client) (string, service.GetToken, error) {
+	typeAndProvider := strings.SplitN(spec, "=", 2)
+	if len(typeAndProvider) < 2 || typeAndProvider[0] == "" {
+		return "", nil, fmt.Errorf("missing token service type")
+	}
+	providerAndArg := strings.SplitN(typeAndProvider[1], ":", 2)
+	if len(providerAndArg) < 2 || providerAndArg[1] == "" {
+		return "", nil, fmt.Errorf("missing provider argument")
+	}
+	var handler service.GetToken
+	switch arg := providerAndArg[1]; providerAndArg[0] {
+	case "static":
+		handler = nanomdm.NewStaticToken([]byte(arg))
+	case "static-file":
+		token, err := os.ReadFile(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		handler = nanomdm.NewStaticToken(token)
+	case "http":
+		handler = nanomdm.NewGetTokenHTTPCaller(arg, client)
+	case "signed":
+		key, err := os.ReadFile(arg)
+		if err != nil {
+			return "", nil, err
+		}
+		handler = nanomdm.NewSignedToken(key, nanomdm.WithSignedTokenIssuer("nanomdm"))
+	default:
+		return "", nil, fmt.Errorf("unknown provider: %q", providerAndArg[0])
+	}
+	return typeAndProvider[0], handler, nil
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	cryptorand "crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"errors"
+	"expvar"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -15,37 +21,83 @@ import (
 	"github.com/micromdm/nanomdm/certverify"
 	"github.com/micromdm/nanomdm/cli"
 	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/http/agent"
 	httpapi "github.com/micromdm/nanomdm/http/api"
+	"github.com/micromdm/nanomdm/http/audit"
 	"github.com/micromdm/nanomdm/http/authproxy"
+	"github.com/micromdm/nanomdm/http/client"
+	"github.com/micromdm/nanomdm/http/files"
+	"github.com/micromdm/nanomdm/http/ipfilter"
 	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/http/micromdm"
+	"github.com/micromdm/nanomdm/http/migration"
+	"github.com/micromdm/nanomdm/http/policy"
+	"github.com/micromdm/nanomdm/http/rbac"
+	"github.com/micromdm/nanomdm/http/stepup"
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	"github.com/micromdm/nanomdm/metrics"
+	"github.com/micromdm/nanomdm/metrics/prometheus"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/campaign"
+	"github.com/micromdm/nanomdm/push/certexpiry"
+	"github.com/micromdm/nanomdm/push/coalesce"
+	pushinstrument "github.com/micromdm/nanomdm/push/instrument"
+	"github.com/micromdm/nanomdm/push/longpoll"
 	"github.com/micromdm/nanomdm/push/nanopush"
+	"github.com/micromdm/nanomdm/push/pushstats"
+	"github.com/micromdm/nanomdm/push/repush"
+	"github.com/micromdm/nanomdm/push/schedule"
 	pushsvc "github.com/micromdm/nanomdm/push/service"
 	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/anomaly"
+	"github.com/micromdm/nanomdm/service/backoff"
 	"github.com/micromdm/nanomdm/service/certauth"
+	"github.com/micromdm/nanomdm/service/ddm"
+	"github.com/micromdm/nanomdm/service/debugtarget"
 	"github.com/micromdm/nanomdm/service/dump"
+	"github.com/micromdm/nanomdm/service/idle"
+	serviceinstrument "github.com/micromdm/nanomdm/service/instrument"
 	"github.com/micromdm/nanomdm/service/microwebhook"
 	"github.com/micromdm/nanomdm/service/multi"
 	"github.com/micromdm/nanomdm/service/nanomdm"
+	"github.com/micromdm/nanomdm/service/owner"
+	"github.com/micromdm/nanomdm/service/quiet"
+	"github.com/micromdm/nanomdm/service/replay"
+	"github.com/micromdm/nanomdm/service/resultroute"
+	"github.com/micromdm/nanomdm/service/retry"
+	"github.com/micromdm/nanomdm/service/smartgroup"
+	"github.com/micromdm/nanomdm/service/starvation"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/cache"
+	"github.com/micromdm/nanomdm/storage/circuit"
+	"github.com/micromdm/nanomdm/storage/extqueue"
+	"github.com/micromdm/nanomdm/storage/freeze"
+	storageinstrument "github.com/micromdm/nanomdm/storage/instrument"
+	"github.com/micromdm/nanomdm/storage/vault"
 
+	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/stdlogfmt"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 // overridden by -ldflags -X
 var version = "unknown"
 
 const (
-	endpointMDM     = "/mdm"
-	endpointCheckin = "/checkin"
-
 	endpointAuthProxy = "/authproxy/"
-
-	endpointAPIPushCert  = "/v1/pushcert"
-	endpointAPIPush      = "/v1/push/"
-	endpointAPIEnqueue   = "/v1/enqueue/"
-	endpointAPIMigration = "/migration"
-	endpointAPIVersion   = "/version"
+	endpointVersion   = "/version"
+	endpointMetrics   = "/metrics"
 )
 
+// eventsBuffer is the number of webhook events buffered for each
+// events API subscriber before events are dropped.
+const eventsBuffer = 100
+
+// webhookRetryInterval is how often failed webhook deliveries are
+// checked for being due for a retry (with -webhook-retries).
+const webhookRetryInterval = 15 * time.Second
+
 const (
 	EnrollmentIDHeader = "X-Enrollment-ID"
 	TraceIDHeader      = "X-Trace-ID"
@@ -57,6 +109,8 @@ func main() {
 	flag.Var(&cliStorage.DSN, "storage-dsn", "data source name (e.g. connection string or path)")
 	flag.Var(&cliStorage.DSN, "dsn", "data source name; deprecated: use -storage-dsn")
 	flag.Var(&cliStorage.Options, "storage-options", "storage backend options")
+	cliTokens := cli.NewTokenProviders()
+	flag.Var(&cliTokens.Providers, "token", "GetToken provider as \"<TokenServiceType>=<provider>:<arg>\" (repeatable)")
 	var (
 		flListen     = flag.String("listen", ":9000", "HTTP listen address")
 		flAPIKey     = flag.String("api", "", "API key for API endpoints")
@@ -64,17 +118,125 @@ func main() {
 		flRootsPath  = flag.String("ca", "", "path to PEM CA cert(s)")
 		flIntsPath   = flag.String("intermediate", "", "path to PEM intermediate cert(s)")
 		flWebhook    = flag.String("webhook-url", "", "URL to send requests to")
+		flWHCA       = flag.String("webhook-ca", "", "path to PEM CA cert(s) for validating the webhook server (default -http-ca)")
+		flWHCert     = flag.String("webhook-cert", "", "path to PEM client certificate for webhook requests (default -http-cert)")
+		flWHKey      = flag.String("webhook-key", "", "path to PEM client private key for webhook requests (default -http-key)")
+		flWHVersion  = flag.String("webhook-version", "1", "webhook event schema version")
 		flCertHeader = flag.String("cert-header", "", "HTTP header containing URL-escaped TLS client certificate")
 		flDebug      = flag.Bool("debug", false, "log debug messages")
 		flDump       = flag.Bool("dump", false, "dump MDM requests and responses to stdout")
 		flDisableMDM = flag.Bool("disable-mdm", false, "disable MDM HTTP endpoint")
 		flCheckin    = flag.Bool("checkin", false, "enable separate HTTP endpoint for MDM check-ins")
+		flMicroMDM   = flag.Bool("micromdm-api", false, "enable MicroMDM compatible command and push API endpoints")
+		flPathPrefix = flag.String("path-prefix", "", "URL path prefix for the MDM endpoints (e.g. /nanomdm)")
+		flMDMPath    = flag.String("mdm-path", httpmdm.EndpointMDM, "URL path of the MDM endpoint")
+		flChkinPath  = flag.String("checkin-path", httpmdm.EndpointCheckin, "URL path of the separate check-in endpoint")
+		flServerURL  = flag.String("server-url", "", "external base URL of the MDM endpoints for generated enrollment profiles (e.g. https://mdm.example.com)")
 		flMigration  = flag.Bool("migration", false, "HTTP endpoint for enrollment migrations")
 		flRetro      = flag.Bool("retro", false, "Allow retroactive certificate-authorization association")
 		flDMURLPfx   = flag.String("dm", "", "URL to send Declarative Management requests to")
 		flAuthProxy  = flag.String("auth-proxy-url", "", "Reverse proxy URL target for MDM-authenticated HTTP requests")
 		flUAZLChal   = flag.Bool("ua-zl-dc", false, "reply with zero-length DigestChallenge for UserAuthenticate")
+		flCertChain  = flag.String("cert-extract", "", "comma-separated ordered list of certificate extractors (tls, header, signature)")
+		flChainPol   = flag.String("cert-extract-policy", "first", "certificate extractor chain policy (first, consistent, all)")
+		flAllowlist  = flag.String("cert-allowlist", "", "path to file of allowed SHA-256 certificate hashes")
+		flMetadata   = flag.Bool("metadata", false, "load enrollment metadata into the request context for every request")
+		flJobs       = flag.Bool("jobs", false, "track API enqueue and push operations as jobs")
+		flEvents     = flag.Bool("events", false, "enable the webhook event stream API endpoint")
+		flEventLog   = flag.Bool("event-log", false, "record check-in and command events in the storage event log")
+		flUserSess   = flag.Bool("user-sessions", false, "track the current user session of devices from user channel TokenUpdates")
+		flDevPoll    = flag.Bool("dev-longpoll", false, "development only: replace APNs pushes with long-poll notifications")
+		flCertCheck  = flag.Duration("pushcert-check", 12*time.Hour, "interval for checking push certificate expiry (0 to disable)")
+		flHTTPTmout  = flag.Duration("http-timeout", 30*time.Second, "timeout for outbound HTTP requests")
+		flHTTPCA     = flag.String("http-ca", "", "path to PEM CA cert(s) for validating outbound HTTP servers")
+		flHTTPCert   = flag.String("http-cert", "", "path to PEM client certificate for outbound HTTP requests")
+		flHTTPKey    = flag.String("http-key", "", "path to PEM client private key for outbound HTTP requests")
+		flHTTPProxy  = flag.String("http-proxy", "", "proxy URL for outbound HTTP requests (default from environment)")
+		flHTTPRetry  = flag.Int("http-retries", 0, "number of retries for failed outbound HTTP requests")
+		flAccessLog  = flag.Bool("access-log", false, "log a single access line with MDM details for each MDM endpoint request")
+		flBackoff    = flag.Duration("backoff", 0, "Retry-After for HTTP 503 responses to failed command polls (0 to disable)")
+		flBOIdle     = flag.Bool("backoff-empty-idle", false, "respond to failed Idle command polls with an empty response instead of HTTP 503")
+		flMaxResult  = flag.Int("max-result-size", 0, "maximum size in bytes of stored command results (0 for unlimited)")
+		flResultPol  = flag.String("result-size-policy", nanomdm.ResultPolicyTruncate, "policy for oversized command results (truncate, spill, reject)")
+		flSpillDir   = flag.String("result-spill-dir", "", "directory to save oversized command results to for the spill policy")
+		flCacheTTL   = flag.Duration("cache-ttl", 0, "cache enrollment push info, cert hash, and metadata reads for this long (0 to disable)")
+		flCacheMax   = flag.Int("cache-size", 100000, "maximum number of cached entries for -cache-ttl")
+		flMaxBody    = flag.Int64("max-body-size", 0, "maximum size in bytes of MDM endpoint request bodies (0 for unlimited)")
+		flReplay     = flag.Duration("replay-window", 0, "detect check-in messages replayed within this window (0 to disable)")
+		flReplayRej  = flag.Bool("replay-reject", false, "reject replayed check-in messages with an HTTP 403")
+		flDevIPAllow = flag.String("device-ip-allow", "", "comma-separated CIDR networks allowed to access MDM endpoints")
+		flDevIPDeny  = flag.String("device-ip-deny", "", "comma-separated CIDR networks denied access to MDM endpoints")
+		flAPIIPAllow = flag.String("api-ip-allow", "", "comma-separated CIDR networks allowed to access API endpoints")
+		flAPIIPDeny  = flag.String("api-ip-deny", "", "comma-separated CIDR networks denied access to API endpoints")
+		flGeoIP      = flag.String("geoip-csv", "", "path to GeoIP country CSV database (network,country) for country filtering")
+		flCtryAllow  = flag.String("device-country-allow", "", "comma-separated country codes allowed to access MDM endpoints (requires -geoip-csv)")
+		flCtryDeny   = flag.String("device-country-deny", "", "comma-separated country codes denied access to MDM endpoints (requires -geoip-csv)")
+		flClientIPHd = flag.String("client-ip-header", "", "take the client IP address from the last address in this header (e.g. X-Forwarded-For)")
+		flCBRate     = flag.Float64("circuit-failure-rate", 0, "storage failure rate (0 to 1) that trips the storage circuit breaker (0 to disable)")
+		flCBSlow     = flag.Duration("circuit-slow", 0, "storage calls slower than this count as failures for the circuit breaker")
+		flCBCool     = flag.Duration("circuit-cooldown", 30*time.Second, "time the storage circuit breaker stays open before probing")
+		flSandbox    = flag.Bool("push-sandbox", false, "send pushes for all topics to the APNs development (sandbox) environment")
+		flSBTopics   = flag.String("push-sandbox-topics", "", "comma-separated push topics to send to the APNs development (sandbox) environment")
+		flQuiet      = flag.String("quiet-hours", "", "path to JSON file of quiet windows withholding non-urgent commands and pushes")
+		flQuietUrg   = flag.String("quiet-urgent", "", "comma-separated command request types exempt from quiet windows (default lock, erase, and lost mode commands)")
+		flAlertWin   = flag.Duration("alert-window", 10*time.Minute, "sliding window for the anomaly alert thresholds")
+		flAlertCO    = flag.Int("alert-checkouts", 0, "alert when this many CheckOuts happen within -alert-window (0 to disable)")
+		flAlertErr   = flag.Int("alert-errors", 0, "alert when this many Error command results for a request type happen within -alert-window (0 to disable)")
+		flAlertPush  = flag.Int("alert-push-failures", 0, "alert when this many pushes fail within -alert-window (0 to disable)")
+		flFilesDir   = flag.String("files-dir", "", "directory of static files (e.g. InstallApplication manifests) to serve with signed URLs")
+		flFilesKey   = flag.String("files-key", "", "secret key for signing file URLs (default random on startup)")
+		flFilesURL   = flag.String("files-url", "", "external base URL of the files endpoint for signed URLs (e.g. https://mdm.example.com/files)")
+		flFilesExp   = flag.Duration("files-expiry", 24*time.Hour, "default expiry of signed file URLs")
+		flVaultAddr  = flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "Vault server address for push certificates and secrets (token from VAULT_TOKEN)")
+		flVaultMount = flag.String("vault-mount", "secret", "mount path of the Vault KV version 2 secrets engine")
+		flVaultCerts = flag.String("vault-pushcerts", "", "Vault KV path below which push certificates are stored by topic")
+		flVaultSecr  = flag.String("vault-secrets", "", "Vault KV path of the api_key and files_key secrets")
+		flRollups    = flag.Duration("rollup-flush", 0, "interval to flush hourly and daily metrics rollups to storage (0 to disable)")
+		flAuditLog   = flag.String("audit-log", "", "path to tamper-evident audit log file of API requests that change state")
+		flAuditKey   = flag.String("audit-key", "", "path to PEM Ed25519 private key for signing audit log entries (or public key with -audit-verify)")
+		flAuditVer   = flag.Bool("audit-verify", false, "verify the -audit-log chain (and -audit-key signatures) and exit")
+		flRBAC       = flag.Bool("rbac", false, "enable role-based API keys managed with the API (the -api key is an admin)")
+		flRBACRoles  = flag.String("rbac-roles", "", "path to JSON file of role definitions replacing the built-in roles")
+		flFreeze     = flag.Bool("freeze", false, "enable enrollment freezes blocking new commands and pushes to frozen enrollments")
+		flPolicy     = flag.String("policy", "", "path to JSON enqueue policy rules")
+		flStepTypes  = flag.String("stepup-types", "", "comma-separated command RequestTypes requiring step-up (default EraseDevice,DeviceLock)")
+		flStepTOTP   = flag.String("stepup-totp", "", "path to base32 TOTP secret accepted for step-up of destructive commands")
+		flStepKey    = flag.String("stepup-approver", "", "path to PEM Ed25519 public key of a step-up approver")
+		flMigTokens  = flag.String("migration-tokens", "", "path to JSON file of tokens for the migration endpoint instead of the API key")
+		flIdleHooks  = flag.String("idle-hooks", "", "path to JSON file of hooks enqueueing commands to devices reporting Idle with an empty queue")
+		flInventory  = flag.Bool("inventory", false, "track inventory changes of DeviceInformation, SecurityInfo, and ProfileList results")
+		flSmartGroup = flag.String("smart-groups", "", "path to JSON file of smart groups evaluated on inventory (requires -inventory)")
+		flH2C        = flag.Bool("h2c", false, "serve HTTP/2 without TLS (h2c) for HTTP/2 reverse proxies")
+		flH2Streams  = flag.Uint("h2-streams", 1000, "maximum concurrent HTTP/2 streams per connection")
+		flCmdOwners  = flag.String("command-owners", "", "path to JSON file of command owners receiving the results of their commands")
+		flAgentKey   = flag.String("agent-key", "", "enable the experimental JSON agent endpoints with this agent secret derivation key")
+		flRetryErrs  = flag.Int("retry-errors", 0, "maximum automatic retries of commands that fail with retryable errors (0 to disable)")
+		flErrorCodes = flag.String("error-codes", "", "path to JSON file of error code entries extending the built-in error code knowledge base")
+		flDuplicates = flag.String("duplicates", "", "detect devices Authenticating as a new enrollment: \"detect\" or \"disable\" the previous enrollment")
+		flDbgTargets = flag.Duration("debug-targets", 0, "maximum duration of per-enrollment debug logging targets (0 disables the API)")
+		flPushCoal   = flag.Duration("push-coalesce", 0, "window to coalesce pushes to the same enrollments into one batched push (0 disables)")
+		flResultRts  = flag.String("result-routes", "", "path to JSON file of rules routing command results to webhook URLs by request type or status")
+		flStarveAge  = flag.Duration("starvation-age", 0, "report enrollments that check in this long after a command was queued without a result (0 disables)")
+		flPrometheus = flag.Bool("prometheus", false, "serve Prometheus metrics at "+endpointMetrics)
+		flEnforceSt  = flag.Bool("enforce-states", false, "reject check-ins invalid for the enrollment state (e.g. TokenUpdate before Authenticate)")
+		flUnknownEnr = flag.String("unknown-enrollments", "", "respond to command polls of unknown enrollments with \"empty\", \"unauthorized\" (401), or \"gone\" (410)")
+		flEvict      = flag.Bool("evict", false, "enable enrollment evictions answering check-ins of evicted enrollments with HTTP 410")
+		flTombstones = flag.Bool("tombstones", false, "enable the enrollment tombstone API which purges departed devices leaving a minimal audit record")
+		flWHRetries  = flag.Int("webhook-retries", 0, "persist failed webhook deliveries and retry them with backoff up to this many attempts before dead-lettering (0 disables)")
+		flTemplates  = flag.Bool("templates", false, "enable stored DeviceLock and EraseDevice message templates substituted at enqueue")
+		flDDM        = flag.Bool("ddm", false, "serve Declarative Management natively from stored declarations and enable the declarations API")
+		flUnlockTok  = flag.Bool("unlock-tokens", false, "enable approval-gated retrieval of escrowed UnlockTokens and the ClearPasscode API")
+		flSchedPush  = flag.Duration("schedule-interval", time.Minute, "interval for pushing to enrollments when their scheduled commands become due (0 to disable)")
+		flQueueURL   = flag.String("queue-url", "", "URL of an external command queue service")
+		flHeaderTO   = flag.Duration("header-timeout", 30*time.Second, "maximum duration for reading request headers")
+		flReadTO     = flag.Duration("read-timeout", 5*time.Minute, "maximum duration for reading entire requests including the body (0 for no timeout)")
+		flWriteTO    = flag.Duration("write-timeout", 0, "maximum duration for writing responses (0 for no timeout)")
+		flIdleTO     = flag.Duration("idle-timeout", 2*time.Minute, "maximum duration keep-alive connections are kept idle")
+		flMaxHeader  = flag.Int("max-header-bytes", http.DefaultMaxHeaderBytes, "maximum size of request headers")
+		flHSTS       = flag.Duration("hsts", 0, "Strict-Transport-Security max-age for MDM endpoint responses (0 to disable)")
+		flRespHdrs   headerFlag
 	)
+	flag.Var(&flRespHdrs, "response-header", "\"Name: value\" header to set on MDM endpoint responses; an empty value removes the header (can be repeated)")
 	flag.Parse()
 
 	if *flVersion {
@@ -82,11 +244,60 @@ func main() {
 		return
 	}
 
+	if *flAuditVer {
+		if err := verifyAuditLog(*flAuditLog, *flAuditKey); err != nil {
+			stdlog.Fatal(err)
+		}
+		return
+	}
+
+	var vaultClient *vault.Client
+	if *flVaultCerts != "" || *flVaultSecr != "" {
+		var err error
+		vaultClient, err = vault.NewClient(*flVaultAddr, os.Getenv("VAULT_TOKEN"),
+			vault.WithMount(*flVaultMount),
+			vault.WithNamespace(os.Getenv("VAULT_NAMESPACE")),
+			vault.WithHTTPClient(&http.Client{Timeout: *flHTTPTmout}),
+		)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+	}
+	if *flVaultSecr != "" {
+		secret, err := vaultClient.Read(context.Background(), *flVaultSecr)
+		if err != nil {
+			stdlog.Fatalf("reading Vault secrets: %v", err)
+		}
+		if v := secret.Data["api_key"]; v != "" {
+			*flAPIKey = v
+		}
+		if v := secret.Data["files_key"]; v != "" {
+			*flFilesKey = v
+		}
+	}
+
 	if *flDisableMDM && *flAPIKey == "" {
 		stdlog.Fatal("nothing for server to do")
 	}
 
-	logger := stdlogfmt.New(stdlogfmt.WithDebugFlag(*flDebug))
+	var logger log.Logger = stdlogfmt.New(stdlogfmt.WithDebugFlag(*flDebug))
+	var debugTargets *debugtarget.Targets
+	if *flDbgTargets > 0 {
+		// log debug messages of targeted enrollments (or all with -debug)
+		debugTargets = debugtarget.NewTargets(*flDbgTargets)
+		logger = debugtarget.NewLogger(
+			stdlogfmt.New(stdlogfmt.WithDebug(), stdlogfmt.WithCallerDepth(2)),
+			debugTargets,
+			*flDebug,
+		)
+	}
+
+	*flPathPrefix = strings.TrimSuffix(*flPathPrefix, "/")
+	for _, path := range []string{*flPathPrefix, *flMDMPath, *flChkinPath} {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			stdlog.Fatalf("URL path must start with a slash: %q", path)
+		}
+	}
 
 	if *flRootsPath == "" {
 		stdlog.Fatal("must supply CA cert path flag")
@@ -102,7 +313,44 @@ func main() {
 			stdlog.Fatal(err)
 		}
 	}
-	verifier, err := certverify.NewPoolVerifier(caPEM, intsPEM, x509.ExtKeyUsageClientAuth)
+	var verifier certverify.CertVerifier
+	verifier, err = certverify.NewPoolVerifier(caPEM, intsPEM, x509.ExtKeyUsageClientAuth)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if *flAllowlist != "" {
+		allowlist, err := os.ReadFile(*flAllowlist)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		verifier = certverify.NewAllVerifier(
+			verifier,
+			certverify.NewAllowlistVerifier(strings.Split(string(allowlist), "\n")...),
+		)
+	}
+	var certExtractors []httpmdm.CertExtractor
+	if *flCertChain != "" {
+		for _, name := range strings.Split(*flCertChain, ",") {
+			switch name {
+			case "tls":
+				certExtractors = append(certExtractors, httpmdm.TLSCertExtractor())
+			case "header":
+				if *flCertHeader == "" {
+					stdlog.Fatal("header certificate extractor requires -cert-header")
+				}
+				certExtractors = append(certExtractors, httpmdm.PEMHeaderCertExtractor(*flCertHeader))
+			case "signature":
+				certExtractors = append(certExtractors, httpmdm.MdmSignatureCertExtractor())
+			default:
+				stdlog.Fatalf("unknown certificate extractor: %q", name)
+			}
+		}
+	}
+	chainPolicy, err := httpmdm.ParseChainPolicy(*flChainPol)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	webhookVersion, err := microwebhook.ParseVersion(*flWHVersion)
 	if err != nil {
 		stdlog.Fatal(err)
 	}
@@ -111,8 +359,235 @@ func main() {
 	if err != nil {
 		stdlog.Fatal(err)
 	}
+	if *flVaultCerts != "" {
+		mdmStorage = vault.New(mdmStorage, vault.NewPushCertStore(vaultClient, *flVaultCerts))
+	}
+
+	var metricsRec metrics.Recorder = metrics.NopRecorder
+	var registry *prometheus.Registry
+	if *flPrometheus {
+		registry = prometheus.New(prometheus.WithLogger(logger.With("handler", "prometheus")))
+		registry.GaugeFunc(metrics.QueueDepth, func(ctx context.Context) ([]prometheus.Sample, error) {
+			stats, err := mdmStorage.RetrieveTopicStats(ctx)
+			if err != nil {
+				return nil, err
+			}
+			samples := make([]prometheus.Sample, 0, len(stats))
+			for _, s := range stats {
+				samples = append(samples, prometheus.Sample{
+					Labels: []string{"topic", s.Topic},
+					Value:  float64(s.PendingCommands),
+				})
+			}
+			return samples, nil
+		})
+		metricsRec = registry
+		mdmStorage = storageinstrument.New(mdmStorage, metricsRec)
+	}
+	// the webhook is setup later but may be used by the storage layer
+	var webhookService *microwebhook.MicroWebhook
+
+	if *flCBRate > 0 {
+		breaker := circuit.NewBreaker(*flCBRate,
+			circuit.WithSlowThreshold(*flCBSlow),
+			circuit.WithCooldown(*flCBCool),
+			circuit.WithStateChangeFunc(func(ctx context.Context, from, to circuit.State, stats circuit.Stats) {
+				logger.Info("msg", "storage circuit breaker", "from", from, "to", to, "requests", stats.Requests, "failures", stats.Failures)
+				if webhookService == nil {
+					return
+				}
+				err := webhookService.StorageCircuitChanged(ctx, &microwebhook.CircuitEvent{
+					State:         string(to),
+					PreviousState: string(from),
+					Requests:      stats.Requests,
+					Failures:      stats.Failures,
+				})
+				if err != nil {
+					logger.Info("msg", "storage circuit breaker webhook", "err", err)
+				}
+			}),
+		)
+		expvar.Publish("storage_circuit", expvar.Func(breaker.Metrics))
+		mdmStorage = circuit.New(mdmStorage, breaker)
+	}
+
+	if *flCacheTTL > 0 {
+		mdmStorage = cache.New(mdmStorage, cache.NewMemoryCache(*flCacheMax),
+			cache.WithTTL(*flCacheTTL),
+			cache.WithLogger(logger.With("storage", "cache")),
+		)
+	}
+
+	var freezeStore storage.EnrollmentFreezeStore
+	if *flFreeze {
+		if storage.DiscoverCapabilities(mdmStorage)[storage.CapabilityFreeze] && storage.As(mdmStorage, &freezeStore) {
+			freezeStorage := freeze.New(mdmStorage, freezeStore, logger.With("storage", "freeze"))
+			expvar.Publish("freeze_enqueues", expvar.Func(freezeStorage.Metrics))
+			mdmStorage = freezeStorage
+		} else {
+			logger.Info("msg", "storage does not support enrollment freezes: disabling -freeze")
+			*flFreeze = false
+		}
+	}
+
+	// setup the HTTP client for outbound integrations
+	clientOpts := []client.Option{client.WithTimeout(*flHTTPTmout)}
+	if *flHTTPProxy != "" {
+		clientOpts = append(clientOpts, client.WithProxy(*flHTTPProxy))
+	}
+	if *flHTTPRetry > 0 {
+		clientOpts = append(clientOpts, client.WithRetry(*flHTTPRetry, time.Second))
+	}
+	httpTLSOpts, err := clientTLSOptions(*flHTTPCA, *flHTTPCert, *flHTTPKey)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	httpClient, err := client.New(append(clientOpts, httpTLSOpts...)...)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+
+	if *flQueueURL != "" {
+		extQueue := extqueue.New(mdmStorage, extqueue.NewHTTPProvider(*flQueueURL, httpClient), logger.With("storage", "external-queue"))
+		expvar.Publish("external_queue", expvar.Func(extQueue.Metrics))
+		mdmStorage = extQueue
+	}
+
+	// features the storage backend does not support are disabled
+	storageCaps := storage.DiscoverCapabilities(mdmStorage)
+	logger.Debug("msg", "storage capabilities", "capabilities", fmt.Sprint(storageCaps))
+
+	// optional storage interfaces are only used with their capability
+	var (
+		metaStore      storage.EnrollmentMetadataStore
+		queueStore     storage.QueueRetriever
+		queueLocker    storage.QueueLocker
+		jobStore       storage.JobStore
+		eventLogStore  storage.EventLogStore
+		rollupStore    storage.MetricsRollupStore
+		ownerStore     storage.CommandOwnerStore
+		userSessStore  storage.UserSessionStore
+		inventoryStore storage.InventoryStore
+		apiKeyStore    storage.APIKeyStore
+		pendingStore   storage.PendingCommandStore
+		supersedeStore storage.EnrollmentSupersessionStore
+		evictStore     storage.EnrollmentEvictionStore
+		tombstoneStore storage.EnrollmentTombstoneStore
+		deliveryStore  storage.WebhookDeliveryStore
+		templateStore  storage.MessageTemplateStore
+		declStore      storage.DeclarationStore
+		unlockStore    storage.UnlockTokenStore
+		ddmStatusStore storage.DDMStatusStore
+		scheduleStore  storage.CommandScheduleStore
+		queuedEnrStore storage.QueuedEnrollmentRetriever
+		queuedCmdStore storage.QueuedCommandStore
+	)
+	optionalStore := func(c storage.Capability, target interface{}) bool {
+		return storageCaps[c] && storage.As(mdmStorage, target)
+	}
+	if !optionalStore(storage.CapabilityMetadata, &metaStore) && *flMetadata {
+		logger.Info("msg", "storage does not support enrollment metadata: disabling -metadata")
+		*flMetadata = false
+	}
+	if !optionalStore(storage.CapabilityResultRetention, &queueStore) {
+		if *flRetryErrs > 0 {
+			logger.Info("msg", "storage does not retain command results: disabling -retry-errors")
+			*flRetryErrs = 0
+		}
+		if *flResultRts != "" {
+			logger.Info("msg", "storage does not retain command results: disabling -result-routes")
+			*flResultRts = ""
+		}
+		if *flStarveAge > 0 {
+			logger.Info("msg", "storage does not retain command results: disabling -starvation-age")
+			*flStarveAge = 0
+		}
+	}
+	if *flJobs && !optionalStore(storage.CapabilityJobs, &jobStore) {
+		logger.Info("msg", "storage does not support jobs: disabling -jobs")
+		*flJobs = false
+	}
+	if !optionalStore(storage.CapabilityEventLog, &eventLogStore) && *flEventLog {
+		logger.Info("msg", "storage does not support the event log: disabling -event-log")
+		*flEventLog = false
+	}
+	if !optionalStore(storage.CapabilityMetricsRollups, &rollupStore) && *flRollups > 0 {
+		logger.Info("msg", "storage does not support metrics rollups: disabling -rollup-flush")
+		*flRollups = 0
+	}
+	if *flCmdOwners != "" && !optionalStore(storage.CapabilityCommandOwners, &ownerStore) {
+		logger.Info("msg", "storage does not support command owners: disabling -command-owners")
+		*flCmdOwners = ""
+	}
+	if !optionalStore(storage.CapabilityUserSessions, &userSessStore) && *flUserSess {
+		logger.Info("msg", "storage does not support user sessions: disabling -user-sessions")
+		*flUserSess = false
+	}
+	if !optionalStore(storage.CapabilityInventory, &inventoryStore) && *flInventory {
+		logger.Info("msg", "storage does not support inventory: disabling -inventory")
+		*flInventory = false
+	}
+	if *flRBAC && !optionalStore(storage.CapabilityAPIKeys, &apiKeyStore) {
+		stdlog.Fatal("storage does not support API keys required by -rbac")
+	}
+	if *flPolicy != "" && !optionalStore(storage.CapabilityPendingCommands, &pendingStore) {
+		stdlog.Fatal("storage does not support pending commands required by -policy")
+	}
+	if *flEvict && !optionalStore(storage.CapabilityEviction, &evictStore) {
+		logger.Info("msg", "storage does not support enrollment evictions: disabling -evict")
+		*flEvict = false
+	}
+	if *flTombstones && !optionalStore(storage.CapabilityTombstones, &tombstoneStore) {
+		logger.Info("msg", "storage does not support enrollment tombstones: disabling -tombstones")
+		*flTombstones = false
+	}
+	if *flWHRetries > 0 && !optionalStore(storage.CapabilityWebhookDeliveries, &deliveryStore) {
+		logger.Info("msg", "storage does not support webhook deliveries: disabling -webhook-retries")
+		*flWHRetries = 0
+	}
+	if *flTemplates && !optionalStore(storage.CapabilityMessageTemplates, &templateStore) {
+		logger.Info("msg", "storage does not support message templates: disabling -templates")
+		*flTemplates = false
+	}
+	if *flDDM && !optionalStore(storage.CapabilityDeclarations, &declStore) {
+		stdlog.Fatal("storage does not support declarations required by -ddm")
+	}
+	if *flUnlockTok && !optionalStore(storage.CapabilityUnlockTokens, &unlockStore) {
+		logger.Info("msg", "storage does not support unlock tokens: disabling -unlock-tokens")
+		*flUnlockTok = false
+	}
+	if *flSchedPush > 0 && !optionalStore(storage.CapabilityCommandScheduling, &scheduleStore) {
+		*flSchedPush = 0
+	}
+	// these only disable their API endpoints
+	optionalStore(storage.CapabilitySupersession, &supersedeStore)
+	optionalStore(storage.CapabilityDDMStatus, &ddmStatusStore)
+	optionalStore(storage.CapabilityQueuedEnrollments, &queuedEnrStore)
+	optionalStore(storage.CapabilityQueuedCommands, &queuedCmdStore)
+
+	// the webhook may use its own CA and client certificate (mutual TLS)
+	webhookClient := httpClient
+	if *flWHCA != "" || *flWHCert != "" || *flWHKey != "" {
+		webhookCA, webhookCert, webhookKey := *flHTTPCA, *flHTTPCert, *flHTTPKey
+		if *flWHCA != "" {
+			webhookCA = *flWHCA
+		}
+		if *flWHCert != "" || *flWHKey != "" {
+			webhookCert, webhookKey = *flWHCert, *flWHKey
+		}
+		webhookTLSOpts, err := clientTLSOptions(webhookCA, webhookCert, webhookKey)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		if webhookClient, err = client.New(append(clientOpts, webhookTLSOpts...)...); err != nil {
+			stdlog.Fatal(err)
+		}
+	}
 
 	tokenMux := nanomdm.NewTokenMux()
+	if err = cliTokens.Register(tokenMux, httpClient); err != nil {
+		stdlog.Fatal(err)
+	}
 
 	// create 'core' MDM service
 	nanoOpts := []nanomdm.Option{
@@ -120,41 +595,433 @@ func main() {
 		nanomdm.WithGetToken(tokenMux),
 		nanomdm.WithLogger(logger.With("service", "nanomdm")),
 	}
-	if *flDMURLPfx != "" {
-		var warningText string
-		if !strings.HasSuffix(*flDMURLPfx, "/") {
-			warningText = ": warning: URL has no trailing slash"
+	if optionalStore(storage.CapabilityQueueLocking, &queueLocker) {
+		// enabled with the storage options
+		nanoOpts = append(nanoOpts, nanomdm.WithQueueLocker(queueLocker))
+	}
+	if *flMetadata {
+		nanoOpts = append(nanoOpts, nanomdm.WithEnrollmentMetadata(metaStore))
+	}
+	if *flDMURLPfx != "" && *flDDM {
+		stdlog.Fatal("-dm and -ddm are mutually exclusive")
+	}
+	if *flDMURLPfx != "" || *flDDM {
+		var dm service.DeclarativeManagement
+		if *flDDM {
+			logger.Debug("msg", "native declarative management setup")
+			dm = ddm.New(declStore, ddm.WithLogger(logger.With("service", "ddm")))
+		} else {
+			var warningText string
+			if !strings.HasSuffix(*flDMURLPfx, "/") {
+				warningText = ": warning: URL has no trailing slash"
+			}
+			logger.Debug("msg", "declarative management setup"+warningText, "url", *flDMURLPfx)
+			dm, err = nanomdm.NewDeclarativeManagementHTTPCaller(*flDMURLPfx, httpClient)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
 		}
-		logger.Debug("msg", "declarative management setup"+warningText, "url", *flDMURLPfx)
-		dm, err := nanomdm.NewDeclarativeManagementHTTPCaller(*flDMURLPfx, http.DefaultClient)
-		if err != nil {
-			stdlog.Fatal(err)
+		// track which enrollments have activated Declarative Management
+		dmOpts := []nanomdm.DMTrackerOption{
+			nanomdm.WithDMTrackerLogger(logger.With("service", "dm-tracker")),
+			nanomdm.WithDMStatusFunc(func(ctx context.Context, id string, report []byte, declarations []*nanomdm.DeclarationStatus) error {
+				if webhookService == nil {
+					return nil
+				}
+				de := &microwebhook.DDMEvent{EnrollmentID: id}
+				for _, d := range declarations {
+					de.Declarations = append(de.Declarations, &microwebhook.DeclarationStatus{
+						Kind:        d.Kind,
+						Identifier:  d.Identifier,
+						Active:      d.Active,
+						Valid:       d.Valid,
+						ServerToken: d.ServerToken,
+						Reasons:     d.Reasons,
+					})
+				}
+				if len(de.Declarations) > 0 {
+					if err := webhookService.DDMDeclarationStatusChanged(ctx, de); err != nil {
+						return err
+					}
+				}
+				return webhookService.DDMStatusReported(ctx, &microwebhook.DDMEvent{EnrollmentID: id, StatusReport: report})
+			}),
+			nanomdm.WithDMTokenFunc(func(ctx context.Context, id, token, previous string) error {
+				if webhookService == nil {
+					return nil
+				}
+				return webhookService.DDMSyncTokenAdvanced(ctx, &microwebhook.DDMEvent{
+					EnrollmentID:              id,
+					DeclarationsToken:         token,
+					PreviousDeclarationsToken: previous,
+				})
+			}),
 		}
+		if ddmStatusStore != nil {
+			dmOpts = append(dmOpts, nanomdm.WithDMStatusStore(ddmStatusStore))
+		}
+		dm = nanomdm.NewDMTracker(dm, mdmStorage, dmOpts...)
 		nanoOpts = append(nanoOpts, nanomdm.WithDeclarativeManagement(dm))
 	}
 	nano := nanomdm.New(mdmStorage, nanoOpts...)
 
+	// do not disturb enrollments in quiet windows
+	var quietSchedule *quiet.Schedule
+	if *flQuiet != "" {
+		windows, err := quiet.LoadWindows(*flQuiet)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		var urgent []string
+		if *flQuietUrg != "" {
+			urgent = splitList(*flQuietUrg)
+		}
+		quietSchedule, err = quiet.NewSchedule(windows, metaStore, urgent)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+	}
+
 	mux := http.NewServeMux()
 
+	// in-process distribution of webhook events for the events API
+	var eventBroker *microwebhook.Broker
+	if *flEvents && *flAPIKey != "" {
+		eventBroker = microwebhook.NewBroker(eventsBuffer)
+	}
+
+	// alert on fleet-wide rate-of-change anomalies
+	var detector *anomaly.Detector
+	if *flAlertCO > 0 || *flAlertErr > 0 || *flAlertPush > 0 {
+		detector = anomaly.New(
+			anomaly.WithLogger(logger.With("service", "anomaly")),
+			anomaly.WithWindow(*flAlertWin),
+			anomaly.WithThreshold(anomaly.AlertCheckOuts, *flAlertCO),
+			anomaly.WithThreshold(anomaly.AlertErrors, *flAlertErr),
+			anomaly.WithThreshold(anomaly.AlertPushFailures, *flAlertPush),
+			anomaly.WithAlertFunc(func(ctx context.Context, a *anomaly.Alert) {
+				if webhookService == nil {
+					return
+				}
+				err := webhookService.AnomalyDetected(ctx, &microwebhook.AlertEvent{
+					Type:          a.Type,
+					RequestType:   a.RequestType,
+					Count:         a.Count,
+					Threshold:     a.Threshold,
+					WindowSeconds: int(a.Window.Seconds()),
+				})
+				if err != nil {
+					logger.Info("msg", "anomaly alert webhook", "err", err)
+				}
+			}),
+		)
+	}
+
+	var smartGroups *smartgroup.Evaluator
+	if *flSmartGroup != "" {
+		if !*flInventory {
+			stdlog.Fatal("-smart-groups requires -inventory")
+		}
+		if metaStore == nil {
+			stdlog.Fatal("storage does not support enrollment metadata required by -smart-groups")
+		}
+		groups, err := smartgroup.LoadGroups(*flSmartGroup)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		smartGroups, err = smartgroup.New(groups, struct {
+			storage.AllStorage
+			storage.InventoryStore
+			storage.EnrollmentMetadataStore
+		}{mdmStorage, inventoryStore, metaStore}, smartgroup.WithLogger(logger.With("service", "smart-groups")))
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+	}
+
+	errorKBEntries := errorkb.DefaultEntries()
+	if *flErrorCodes != "" {
+		entries, err := errorkb.LoadEntries(*flErrorCodes)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		errorKBEntries = append(errorKBEntries, entries...)
+	}
+	errorKB, err := errorkb.New(errorKBEntries)
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+
+	var backoffService *backoff.Backoff
+	var cmdOwners *owner.Service
+	var enrollProfile http.Handler
+
 	if !*flDisableMDM {
 		var mdmService service.CheckinAndCommandService = nano
-		if *flWebhook != "" {
-			webhookService := microwebhook.New(*flWebhook, mdmStorage)
+		if *flIdleHooks != "" {
+			hooks, err := idle.LoadHooks(*flIdleHooks)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			idleService, err := idle.New(
+				mdmService,
+				mdmStorage,
+				hooks,
+				idle.WithLogger(logger.With("service", "idle")),
+				idle.WithMetadata(metaStore),
+			)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			expvar.Publish("idle_hooks", expvar.Func(idleService.Metrics))
+			mdmService = idleService
+		}
+		if quietSchedule != nil {
+			quietService := quiet.New(mdmService, quietSchedule, quiet.WithLogger(logger.With("service", "quiet")))
+			expvar.Publish("quiet_commands", expvar.Func(quietService.Metrics))
+			mdmService = quietService
+		}
+		if *flMaxResult > 0 {
+			limitOpts := []nanomdm.ResultSizeLimiterOption{nanomdm.WithResultSizeLimiterLogger(logger.With("service", "result-limit"))}
+			if *flSpillDir != "" {
+				limitOpts = append(limitOpts, nanomdm.WithResultSpiller(nanomdm.DirResultSpiller(*flSpillDir)))
+			}
+			mdmService, err = nanomdm.NewResultSizeLimiter(mdmService, *flMaxResult, *flResultPol, limitOpts...)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		if *flEventLog {
+			mdmService = nanomdm.NewEventLogger(mdmService, eventLogStore, nanomdm.WithEventLoggerLogger(logger.With("service", "event-log")))
+		}
+		if *flRollups > 0 {
+			rollupRecorder := nanomdm.NewRollupRecorder(mdmService, rollupStore, nanomdm.WithRollupRecorderLogger(logger.With("service", "rollups")))
+			go rollupRecorder.Run(context.Background(), *flRollups)
+			mdmService = rollupRecorder
+		}
+		if *flPrometheus {
+			mdmService = serviceinstrument.New(mdmService, metricsRec)
+		}
+		if jobStore != nil {
+			mdmService = nanomdm.NewJobTracker(mdmService, jobStore, nanomdm.WithJobTrackerLogger(logger.With("service", "job-tracker")))
+		}
+		if *flWebhook != "" || eventBroker != nil {
+			webhookOpts := []microwebhook.Option{
+				microwebhook.WithClient(webhookClient),
+				microwebhook.WithVersion(webhookVersion),
+			}
+			if eventBroker != nil {
+				webhookOpts = append(webhookOpts, microwebhook.WithBroker(eventBroker))
+			}
+			if *flWHRetries > 0 && *flWebhook != "" {
+				webhookOpts = append(webhookOpts,
+					microwebhook.WithLogger(logger.With("service", "webhook")),
+					microwebhook.WithDeliveryStore(deliveryStore, *flWHRetries),
+				)
+			}
+			webhookService = microwebhook.New(*flWebhook, mdmStorage, webhookOpts...)
+			if *flWHRetries > 0 && *flWebhook != "" {
+				go webhookService.Run(context.Background(), webhookRetryInterval)
+			}
 			mdmService = multi.New(logger.With("service", "multi"), mdmService, webhookService)
 		}
+		if *flCmdOwners != "" {
+			owners, err := owner.LoadOwners(*flCmdOwners)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			cmdOwners, err = owner.New(
+				mdmService,
+				ownerStore,
+				owners,
+				owner.WithLogger(logger.With("service", "command-owners")),
+				owner.WithWebhookOptions(
+					microwebhook.WithClient(webhookClient),
+					microwebhook.WithVersion(webhookVersion),
+				),
+			)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			expvar.Publish("command_owners", expvar.Func(cmdOwners.Metrics))
+			mdmService = cmdOwners
+		}
+		if *flResultRts != "" {
+			rules, err := resultroute.LoadRules(*flResultRts)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			routeService, err := resultroute.New(
+				mdmService,
+				queueStore,
+				rules,
+				resultroute.WithLogger(logger.With("service", "result-routes")),
+				resultroute.WithWebhookOptions(
+					microwebhook.WithClient(webhookClient),
+					microwebhook.WithVersion(webhookVersion),
+				),
+			)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			expvar.Publish("result_routes", expvar.Func(routeService.Metrics))
+			mdmService = routeService
+		}
+		if *flRetryErrs > 0 {
+			retryService := retry.New(mdmService, struct {
+				storage.AllStorage
+				storage.QueueRetriever
+			}{mdmStorage, queueStore}, errorKB, *flRetryErrs, retry.WithLogger(logger.With("service", "retry")))
+			expvar.Publish("command_retries", expvar.Func(retryService.Metrics))
+			mdmService = retryService
+		}
+		if debugTargets != nil {
+			mdmService = debugtarget.NewCapture(mdmService, debugTargets, logger.With("service", "debug-capture"))
+		}
+		if *flUserSess {
+			userSessionOpts := []nanomdm.UserSessionTrackerOption{nanomdm.WithUserSessionTrackerLogger(logger.With("service", "user-sessions"))}
+			if webhookService != nil {
+				userSessionOpts = append(userSessionOpts, nanomdm.WithUserSessionChangeFunc(func(ctx context.Context, deviceID string, sessions *storage.UserSessions) error {
+					return webhookService.UserSessionChanged(ctx, &microwebhook.UserSessionEvent{
+						DeviceID: deviceID,
+						Current:  sessions.Current,
+						Previous: sessions.Previous,
+					})
+				}))
+			}
+			mdmService = nanomdm.NewUserSessionTracker(mdmService, userSessStore, userSessionOpts...)
+		}
+		if *flDuplicates != "" {
+			dupOpts := []nanomdm.DuplicateDetectorOption{nanomdm.WithDuplicateDetectorLogger(logger.With("service", "duplicates"))}
+			switch *flDuplicates {
+			case "detect":
+			case "disable":
+				dupOpts = append(dupOpts, nanomdm.WithDuplicateDisable())
+			default:
+				stdlog.Fatal("invalid -duplicates: " + *flDuplicates)
+			}
+			if webhookService != nil {
+				dupOpts = append(dupOpts, nanomdm.WithDuplicateFunc(func(ctx context.Context, d *nanomdm.DuplicateEnrollment) error {
+					return webhookService.EnrollmentDuplicated(ctx, &microwebhook.DuplicateEvent{
+						SerialNumber: d.SerialNumber,
+						EnrollmentID: d.EnrollmentID,
+						PreviousID:   d.PreviousID,
+						Disabled:     d.Disabled,
+					})
+				}))
+			}
+			mdmService = nanomdm.NewDuplicateDetector(mdmService, mdmStorage, dupOpts...)
+		}
+		if *flInventory {
+			inventoryOpts := []nanomdm.InventoryTrackerOption{nanomdm.WithInventoryTrackerLogger(logger.With("service", "inventory"))}
+			if webhookService != nil {
+				inventoryOpts = append(inventoryOpts, nanomdm.WithInventoryChangeFunc(func(ctx context.Context, id string, changes []*storage.InventoryChange) error {
+					return webhookService.InventoryChanged(ctx, &microwebhook.InventoryEvent{
+						EnrollmentID: id,
+						Changes:      changes,
+					})
+				}))
+			}
+			if smartGroups != nil {
+				inventoryOpts = append(inventoryOpts, nanomdm.WithInventorySnapshotFunc(func(ctx context.Context, id string) error {
+					_, err := smartGroups.Evaluate(ctx, id)
+					return err
+				}))
+			}
+			mdmService = nanomdm.NewInventoryTracker(mdmService, inventoryStore, inventoryOpts...)
+		}
+
+		if *flBackoff > 0 {
+			boOpts := []backoff.Option{
+				backoff.WithLogger(logger.With("service", "backoff")),
+				backoff.WithRetryAfter(*flBackoff),
+			}
+			if *flBOIdle {
+				boOpts = append(boOpts, backoff.WithEmptyIdle())
+			}
+			backoffService = backoff.New(mdmService, boOpts...)
+			mdmService = backoffService
+		}
+		if detector != nil {
+			mdmService = anomaly.NewService(mdmService, detector)
+		}
+		if *flEnforceSt {
+			stateOpts := []nanomdm.StateEnforcerOption{nanomdm.WithStateEnforcerLogger(logger.With("service", "states"))}
+			if webhookService != nil {
+				stateOpts = append(stateOpts, nanomdm.WithTransitionFunc(func(ctx context.Context, e *nanomdm.TransitionError) error {
+					return webhookService.InvalidTransition(ctx, &microwebhook.TransitionEvent{
+						EnrollmentID: e.EnrollmentID,
+						MessageType:  e.MessageType,
+						State:        e.State,
+					})
+				}))
+			}
+			mdmService = nanomdm.NewStateEnforcer(mdmService, mdmStorage, stateOpts...)
+		}
+		if *flUnknownEnr != "" {
+			mdmService, err = nanomdm.NewUnknownPollResponder(
+				mdmService,
+				mdmStorage,
+				*flUnknownEnr,
+				nanomdm.WithUnknownPollResponderLogger(logger.With("service", "unknown-enrollments")),
+			)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		if *flEvict {
+			evictOpts := []nanomdm.EvictorOption{nanomdm.WithEvictorLogger(logger.With("service", "evict"))}
+			if webhookService != nil {
+				evictOpts = append(evictOpts, nanomdm.WithEvictionFunc(func(ctx context.Context, e *storage.EnrollmentEviction) error {
+					return webhookService.EnrollmentEvicted(ctx, &microwebhook.EvictionEvent{
+						EnrollmentID: e.ID,
+						Reason:       e.Reason,
+						EvictedBy:    e.EvictedBy,
+						EvictedAt:    e.CreatedAt,
+						DeliveredAt:  *e.DeliveredAt,
+					})
+				}))
+			}
+			mdmService = nanomdm.NewEvictor(mdmService, evictStore, evictOpts...)
+		}
+		// agents authenticate with their own secrets, not certificates
+		agentService := mdmService
 		certAuthOpts := []certauth.Option{certauth.WithLogger(logger.With("service", "certauth"))}
 		if *flRetro {
 			certAuthOpts = append(certAuthOpts, certauth.WithAllowRetroactive())
 		}
 		mdmService = certauth.New(mdmService, mdmStorage, certAuthOpts...)
+		if *flReplay > 0 {
+			replayOpts := []replay.Option{
+				replay.WithLogger(logger.With("service", "replay")),
+				replay.WithWindow(*flReplay),
+			}
+			if *flReplayRej {
+				replayOpts = append(replayOpts, replay.WithReject())
+			}
+			replayService := replay.New(mdmService, replayOpts...)
+			expvar.Publish("checkin_replay", expvar.Func(replayService.Metrics))
+			mdmService = replayService
+		}
 		if *flDump {
 			mdmService = dump.New(mdmService, os.Stdout)
 		}
 
+		// MDM endpoint responses should never be cached
+		deviceHeaders := http.Header{"Cache-Control": []string{"no-store"}}
+		if *flHSTS > 0 {
+			deviceHeaders.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(flHSTS.Seconds())))
+		}
+		for k, v := range flRespHdrs.header {
+			deviceHeaders[k] = v
+		}
+
 		// helper for authorizing MDM clients requests
 		certAuthMiddleware := func(h http.Handler) http.Handler {
 			h = httpmdm.CertVerifyMiddleware(h, verifier, logger.With("handler", "cert-verify"))
-			if *flCertHeader != "" {
+			if len(certExtractors) > 0 {
+				h = httpmdm.CertExtractChainMiddleware(h, certExtractors, chainPolicy, logger.With("handler", "cert-extract"))
+			} else if *flCertHeader != "" {
 				h = httpmdm.CertExtractPEMHeaderMiddleware(h, *flCertHeader, logger.With("handler", "cert-extract"))
 			} else {
 				opts := []httpmdm.SigLogOption{httpmdm.SigLogWithLogger(logger.With("handler", "cert-extract"))}
@@ -163,27 +1030,57 @@ func main() {
 				}
 				h = httpmdm.CertExtractMdmSignatureMiddleware(h, opts...)
 			}
+			if *flMaxBody > 0 {
+				h = mdmhttp.MaxBodySizeMiddleware(h, *flMaxBody, logger.With("handler", "max-body-size"))
+			}
+			if *flAccessLog {
+				h = httpmdm.AccessLogMiddleware(h, logger.With("handler", "access-log"))
+			}
 			return h
 		}
 
-		// register 'core' MDM HTTP handler
-		var mdmHandler http.Handler
+		// register 'core' MDM HTTP handlers
+		mdmHandlers := &httpmdm.Handlers{
+			Service:         mdmService,
+			SeparateCheckin: *flCheckin,
+			MDMPath:         *flMDMPath,
+			CheckinPath:     *flChkinPath,
+			Logger:          logger,
+		}
+		logs := []interface{}{"msg", "MDM endpoints", "server_url_path", *flPathPrefix + *flMDMPath}
 		if *flCheckin {
-			// if we use the check-in handler then only handle commands
-			mdmHandler = httpmdm.CommandAndReportResultsHandler(mdmService, logger.With("handler", "command"))
-		} else {
-			// if we don't use a check-in handler then do both
-			mdmHandler = httpmdm.CheckinAndCommandHandler(mdmService, logger.With("handler", "checkin-command"))
+			logs = append(logs, "checkin_url_path", *flPathPrefix+*flChkinPath)
 		}
-		mdmHandler = certAuthMiddleware(mdmHandler)
-		mux.Handle(endpointMDM, mdmHandler)
+		logger.Debug(logs...)
+		if *flServerURL != "" {
+			serverURL, checkInURL := mdmHandlers.URLs(*flServerURL, *flPathPrefix)
+			// the Mdm-Signature header is used without other extractors
+			signMessage := *flCertHeader == ""
+			if *flCertChain != "" {
+				signMessage = strings.Contains(*flCertChain, "signature")
+			}
+			enrollProfile = httpmdm.EnrollmentProfileHandler(serverURL, checkInURL, signMessage, logger.With("handler", "enrollment-profile"))
+		}
+		mdmHandlers.Register(mux, *flPathPrefix,
+			func(h http.Handler) http.Handler { return mdmhttp.ResponseHeadersMiddleware(h, deviceHeaders) },
+			certAuthMiddleware,
+		)
 
-		if *flCheckin {
-			// if we specified a separate check-in handler, set it up
-			var checkinHandler http.Handler
-			checkinHandler = httpmdm.CheckinHandler(mdmService, logger.With("handler", "checkin"))
-			checkinHandler = certAuthMiddleware(checkinHandler)
-			mux.Handle(endpointCheckin, checkinHandler)
+		if *flAgentKey != "" {
+			agentHandlers := &agent.Handlers{
+				Service: agentService,
+				Key:     []byte(*flAgentKey),
+				Logger:  logger,
+			}
+			agentHandlers.Register(mux, *flPathPrefix,
+				func(h http.Handler) http.Handler { return mdmhttp.ResponseHeadersMiddleware(h, deviceHeaders) },
+				func(h http.Handler) http.Handler {
+					if *flMaxBody > 0 {
+						h = mdmhttp.MaxBodySizeMiddleware(h, *flMaxBody, logger.With("handler", "max-body-size"))
+					}
+					return h
+				},
+			)
 		}
 
 		if *flAuthProxy != "" {
@@ -197,42 +1094,228 @@ func main() {
 				stdlog.Fatal(err)
 			}
 			logger.Debug("msg", "authproxy setup", "url", *flAuthProxy)
-			authProxyHandler = http.StripPrefix(endpointAuthProxy, authProxyHandler)
+			authProxyHandler = http.StripPrefix(*flPathPrefix+endpointAuthProxy, authProxyHandler)
 			authProxyHandler = httpmdm.CertWithEnrollmentIDMiddleware(authProxyHandler, certauth.HashCert, mdmStorage, true, logger.With("handler", "with-enrollment-id"))
 			authProxyHandler = certAuthMiddleware(authProxyHandler)
-			mux.Handle(endpointAuthProxy, authProxyHandler)
+			mux.Handle(*flPathPrefix+endpointAuthProxy, authProxyHandler)
+		}
+	}
+
+	if *flCertCheck > 0 {
+		// monitor push certificate expiry, alerting via webhook events
+		monitorOpts := []certexpiry.Option{
+			certexpiry.WithLogger(logger.With("service", "pushcert-monitor")),
+			certexpiry.WithInterval(*flCertCheck),
+		}
+		if webhookService != nil {
+			monitorOpts = append(monitorOpts, certexpiry.WithAlertFunc(func(ctx context.Context, status *certexpiry.Status, threshold int) error {
+				return webhookService.PushCertExpiring(ctx, &microwebhook.PushCertEvent{
+					Topic:         status.Topic,
+					NotAfter:      status.NotAfter,
+					DaysRemaining: status.DaysRemaining,
+					ThresholdDays: threshold,
+				})
+			}))
+		}
+		certMonitor := certexpiry.New(mdmStorage, monitorOpts...)
+		expvar.Publish("push_cert_expiry_seconds", expvar.Func(certMonitor.Metrics))
+		go certMonitor.Run(context.Background())
+	}
+
+	if *flStarveAge > 0 {
+		// watch for queued commands not being delivered to enrollments
+		watchdogOpts := []starvation.Option{starvation.WithLogger(logger.With("service", "starvation-watchdog"))}
+		if webhookService != nil {
+			watchdogOpts = append(watchdogOpts, starvation.WithAlertFunc(func(ctx context.Context, s *starvation.Starved) error {
+				return webhookService.QueueStarved(ctx, &microwebhook.StarvationEvent{
+					EnrollmentID: s.EnrollmentID,
+					CommandUUID:  s.CommandUUID,
+					RequestType:  s.RequestType,
+					QueuedAt:     s.QueuedAt,
+					LastSeenAt:   s.LastSeenAt,
+					Pending:      s.Pending,
+					Answered:     s.Answered,
+				})
+			}))
 		}
+		watchdog := starvation.New(struct {
+			storage.AllStorage
+			storage.QueueRetriever
+		}{mdmStorage, queueStore}, *flStarveAge, watchdogOpts...)
+		expvar.Publish("starved_queues", expvar.Func(watchdog.Metrics))
+		go watchdog.Run(context.Background())
+	}
+
+	var fileSigner *files.Signer
+	if *flFilesDir != "" {
+		key := []byte(*flFilesKey)
+		if len(key) < 1 {
+			// signed URLs will not survive restarts
+			key = make([]byte, 32)
+			if _, err = cryptorand.Read(key); err != nil {
+				stdlog.Fatal(err)
+			}
+			logger.Info("msg", "no -files-key: using random file URL signing key")
+		}
+		fileSigner, err = files.NewSigner(key)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		if *flFilesURL == "" {
+			*flFilesURL = *flPathPrefix + strings.TrimSuffix(files.EndpointFiles, "/")
+		}
+		filesPath := *flPathPrefix + files.EndpointFiles
+		var filesHandler http.Handler = files.Handler(http.Dir(*flFilesDir), fileSigner, logger.With("handler", "files"))
+		mux.Handle(filesPath, http.StripPrefix(strings.TrimSuffix(filesPath, "/"), filesHandler))
 	}
 
 	if *flAPIKey != "" {
 		const apiUsername = "nanomdm"
 
+		// audit the API requests that change state
+		auditMiddleware := func(h http.Handler) http.Handler { return h }
+		if *flAuditLog != "" {
+			var auditOpts []audit.Option
+			if *flAuditKey != "" {
+				key, err := audit.LoadSigningKey(*flAuditKey)
+				if err != nil {
+					stdlog.Fatal(err)
+				}
+				auditOpts = append(auditOpts, audit.WithSigningKey(key))
+			}
+			auditLog, err := audit.Open(*flAuditLog, auditOpts...)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			auditMiddleware = func(h http.Handler) http.Handler {
+				return audit.Middleware(h, auditLog, logger.With("handler", "audit"))
+			}
+		}
+
 		// create our push provider and push service
-		pushProviderFactory := nanopush.NewFactory()
-		pushService := pushsvc.New(mdmStorage, mdmStorage, pushProviderFactory, logger.With("service", "push"))
-
-		// register API handler for push cert storage/upload.
-		var pushCertHandler http.Handler
-		pushCertHandler = httpapi.StorePushCertHandler(mdmStorage, logger.With("handler", "store-cert"))
-		pushCertHandler = mdmhttp.BasicAuthMiddleware(pushCertHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIPushCert, pushCertHandler)
-
-		// register API handler for push notifications.
-		// we strip the prefix to use the path as an id.
-		var pushHandler http.Handler
-		pushHandler = httpapi.PushHandler(pushService, logger.With("handler", "push"))
-		pushHandler = http.StripPrefix(endpointAPIPush, pushHandler)
-		pushHandler = mdmhttp.BasicAuthMiddleware(pushHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIPush, pushHandler)
-
-		// register API handler for new command queueing.
-		// we strip the prefix to use the path as an id.
-		var enqueueHandler http.Handler
-		enqueueHandler = httpapi.RawCommandEnqueueHandler(mdmStorage, pushService, logger.With("handler", "enqueue"))
-		enqueueHandler = http.StripPrefix(endpointAPIEnqueue, enqueueHandler)
-		enqueueHandler = mdmhttp.BasicAuthMiddleware(enqueueHandler, apiUsername, *flAPIKey, "nanomdm")
-		mux.Handle(endpointAPIEnqueue, enqueueHandler)
+		var pushOpts []nanopush.Option
+		if *flSandbox {
+			pushOpts = append(pushOpts, nanopush.WithBaseURL(nanopush.Development))
+		}
+		for _, topic := range splitList(*flSBTopics) {
+			pushOpts = append(pushOpts, nanopush.WithTopicBaseURL(topic, nanopush.Development))
+		}
+		pushProviderFactory := nanopush.NewFactory(pushOpts...)
+		pushStats := pushstats.New()
+		expvar.Publish("push_failure_rate", expvar.Func(pushStats.Metrics))
+		var pushService push.Pusher = pushsvc.New(mdmStorage, mdmStorage, pushProviderFactory, logger.With("service", "push"), pushsvc.WithStats(pushStats))
+		if *flPrometheus {
+			pushService = pushinstrument.NewPusher(pushService, metricsRec)
+		}
+
+		if detector != nil {
+			pushService = anomaly.NewPusher(pushService, detector)
+		}
 
+		var longPollNotifier *longpoll.Notifier
+		if *flDevPoll {
+			// replace APNs pushes with long-poll notifications for
+			// simulated devices and test harnesses.
+			logger.Info("msg", "development long-poll push enabled: APNs pushes will not be sent")
+			longPollNotifier = longpoll.New()
+			pushService = longPollNotifier
+		}
+		if quietSchedule != nil {
+			quietPusher := quiet.NewPusher(pushService, quietSchedule, mdmStorage, logger.With("service", "quiet-push"))
+			expvar.Publish("quiet_pushes", expvar.Func(quietPusher.Metrics))
+			pushService = quietPusher
+		}
+		if *flFreeze {
+			freezePusher := freeze.NewPusher(pushService, freezeStore, logger.With("service", "freeze-push"))
+			expvar.Publish("freeze_pushes", expvar.Func(freezePusher.Metrics))
+			pushService = freezePusher
+		}
+		if *flPushCoal > 0 {
+			coalescePusher := coalesce.NewPusher(pushService, *flPushCoal, coalesce.WithLogger(logger.With("service", "coalesce-push")))
+			expvar.Publish("coalesced_pushes", expvar.Func(coalescePusher.Metrics))
+			pushService = coalescePusher
+		}
+		if *flSchedPush > 0 {
+			scheduler := schedule.New(scheduleStore, pushService,
+				schedule.WithLogger(logger.With("service", "schedule-push")),
+				schedule.WithInterval(*flSchedPush),
+			)
+			expvar.Publish("scheduled_pushes", expvar.Func(scheduler.Metrics))
+			go scheduler.Run(context.Background())
+		}
+
+		campaignOpts := []campaign.Option{campaign.WithLogger(logger.With("service", "campaign"))}
+		if jobStore != nil {
+			campaignOpts = append(campaignOpts, campaign.WithJobStore(jobStore))
+		}
+
+		// register API handlers
+		apiHandlers := &httpapi.Handlers{
+			Store:         mdmStorage,
+			Pusher:        pushService,
+			PushStats:     pushStats,
+			Jobs:          jobStore,
+			Campaigns:     campaign.New(mdmStorage, pushService, campaignOpts...),
+			Events:        eventBroker,
+			Metadata:      metaStore,
+			Inventory:     inventoryStore,
+			Queue:         queueStore,
+			UserSessions:  userSessStore,
+			Rollups:       rollupStore,
+			Supersessions: supersedeStore,
+			APIKeys:       apiKeyStore,
+			LongPoll:      longPollNotifier,
+			ErrorKB:       errorKB,
+			EnrollProfile: enrollProfile,
+			Metrics:       true,
+			Logger:        logger,
+		}
+		repushOpts := []repush.Option{repush.WithLogger(logger.With("service", "repush"))}
+		if jobStore != nil {
+			repushOpts = append(repushOpts, repush.WithJobStore(jobStore))
+		}
+		if queuedEnrStore != nil {
+			apiHandlers.Repush = repush.New(queuedEnrStore, pushService, repushOpts...)
+		}
+		if *flEventLog {
+			apiHandlers.EventLog = eventLogStore
+		}
+		if *flFreeze {
+			apiHandlers.Freeze = freezeStore
+		}
+		if *flEvict {
+			apiHandlers.Evict = evictStore
+		}
+		if *flTombstones {
+			apiHandlers.Tombstones = tombstoneStore
+		}
+		if *flWHRetries > 0 {
+			apiHandlers.Webhooks = deliveryStore
+		}
+		if *flTemplates {
+			apiHandlers.Templates = templateStore
+		}
+		if *flDDM {
+			apiHandlers.Declarations = declStore
+		}
+		if *flUnlockTok {
+			apiHandlers.UnlockTokens = unlockStore
+		}
+		apiHandlers.DDMStatus = ddmStatusStore
+		apiHandlers.QueuedCommands = queuedCmdStore
+		if backoffService != nil {
+			apiHandlers.Maintenance = backoffService
+		}
+		if smartGroups != nil {
+			apiHandlers.SmartGroups = smartGroups
+		}
+		if debugTargets != nil {
+			apiHandlers.DebugTargets = debugTargets
+		}
+		if cmdOwners != nil {
+			apiHandlers.CommandOwners = cmdOwners.Owner
+			apiHandlers.CommandOwnerStore = ownerStore
+		}
 		if *flMigration {
 			// setup a "migration" handler that takes Check-In messages
 			// without bothering with certificate auth or other
@@ -242,19 +1325,244 @@ func main() {
 			// authenticate and tokenupdate message to effectively
 			// generate "enrollments" then this effively allows us to
 			// migrate MDM enrollments between servers.
-			var migHandler http.Handler
-			migHandler = httpmdm.CheckinHandler(nano, logger.With("handler", "migration"))
-			migHandler = mdmhttp.BasicAuthMiddleware(migHandler, apiUsername, *flAPIKey, "nanomdm")
-			mux.Handle(endpointAPIMigration, migHandler)
+			if *flMigTokens == "" {
+				apiHandlers.Migration = nano
+			}
+		}
+		apiAuth := func(h http.Handler) http.Handler {
+			return mdmhttp.BasicAuthMiddleware(h, apiUsername, *flAPIKey, "nanomdm")
+		}
+		microAuth := func(h http.Handler) http.Handler {
+			return mdmhttp.BasicAuthMiddleware(h, micromdm.APIUsername, *flAPIKey, "micromdm")
+		}
+		if *flRBAC {
+			rbacOpts := []rbac.Option{
+				rbac.WithAdmin(apiUsername, *flAPIKey),
+				rbac.WithLogger(logger.With("handler", "rbac")),
+			}
+			if *flMicroMDM {
+				// keep accepting the MicroMDM API username
+				rbacOpts = append(rbacOpts, rbac.WithAdmin(micromdm.APIUsername, *flAPIKey))
+			}
+			if *flRBACRoles != "" {
+				roles, err := rbac.LoadRoles(*flRBACRoles)
+				if err != nil {
+					stdlog.Fatal(err)
+				}
+				rbacOpts = append(rbacOpts, rbac.WithRoles(roles))
+			}
+			apiHandlers.RBAC = rbac.New(apiKeyStore, metaStore, rbacOpts...)
+			apiAuth = func(h http.Handler) http.Handler {
+				return apiHandlers.RBAC.Middleware(h, "nanomdm")
+			}
+			microAuth = func(h http.Handler) http.Handler {
+				return apiHandlers.RBAC.Middleware(h, "micromdm")
+			}
+		}
+		if *flPolicy != "" {
+			rules, err := policy.LoadRules(*flPolicy)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			// commands requiring approval are held as pending commands
+			// until approved with the pending commands API endpoint
+			pendingHandler := httpapi.PendingEnqueueHandler(pendingStore, logger.With("handler", "pending-enqueue"))
+			apiHandlers.Pending = pendingStore
+			policyOpts := []policy.Option{
+				policy.WithLogger(logger.With("handler", "policy")),
+				policy.WithApprovalHandler(pendingHandler),
+			}
+			if !*flRBAC {
+				// the single API user cannot approve their own commands
+				policyOpts = append(policyOpts, policy.WithSingleUser())
+			}
+			engine, err := policy.New(rules, metaStore, policyOpts...)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			// the enqueue middleware sees every command enqueued by the
+			// API (including the MicroMDM compatible API)
+			apiHandlers.EnqueueMiddleware = append(apiHandlers.EnqueueMiddleware, func(h http.Handler) http.Handler {
+				return engine.Middleware(h, "")
+			})
+		}
+		if *flStepTOTP != "" || *flStepKey != "" {
+			var stepOpts []stepup.Option
+			if *flStepTOTP != "" {
+				secret, err := stepup.LoadTOTPSecret(*flStepTOTP)
+				if err != nil {
+					stdlog.Fatal(err)
+				}
+				stepOpts = append(stepOpts, stepup.WithTOTPSecret(secret))
+			}
+			if *flStepKey != "" {
+				pub, err := stepup.LoadApprover(*flStepKey)
+				if err != nil {
+					stdlog.Fatal(err)
+				}
+				stepOpts = append(stepOpts, stepup.WithApprover(pub))
+			}
+			var stepTypes []string
+			if *flStepTypes != "" {
+				stepTypes = splitList(*flStepTypes)
+			}
+			guard, err := stepup.New(stepTypes, append(stepOpts, stepup.WithLogger(logger.With("handler", "step-up")))...)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			// checked after the policy
+			apiHandlers.EnqueueMiddleware = append(apiHandlers.EnqueueMiddleware, func(h http.Handler) http.Handler {
+				return guard.Middleware(h, "")
+			})
 		}
+		apiHandlers.Register(mux, "", func(h http.Handler) http.Handler {
+			return auditMiddleware(apiAuth(h))
+		})
+
+		if *flMigration && *flMigTokens != "" {
+			// authenticate the migration endpoint with its own tokens
+			// rather than the API key
+			tokens, err := migration.LoadTokens(*flMigTokens)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			migOpts := []migration.Option{
+				migration.WithLogger(logger.With("handler", "migration-auth")),
+			}
+			if metaStore != nil {
+				migOpts = append(migOpts, migration.WithTenants(struct {
+					storage.AllStorage
+					storage.EnrollmentMetadataStore
+				}{mdmStorage, metaStore}))
+			}
+			if *flClientIPHd != "" {
+				migOpts = append(migOpts, migration.WithClientIPHeader(*flClientIPHd))
+			}
+			migAuth, err := migration.New(tokens, migOpts...)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			var migHandler http.Handler = httpmdm.MigrationHandler(nano, logger.With("handler", "migration"))
+			migHandler = migAuth.Middleware(migHandler, "nanomdm-migration")
+			mux.Handle(httpapi.EndpointMigration, auditMiddleware(migHandler))
+		}
+
+		if *flMicroMDM {
+			// register MicroMDM compatible API handlers
+			microHandlers := &micromdm.Handlers{
+				Enqueue: apiHandlers.EnqueueHandler(),
+				Pusher:  pushService,
+				Logger:  logger,
+			}
+			microHandlers.Register(mux, "", func(h http.Handler) http.Handler {
+				return auditMiddleware(microAuth(h))
+			})
+		}
+
+		if fileSigner != nil {
+			var signHandler http.Handler = files.SignHandler(http.Dir(*flFilesDir), fileSigner, *flFilesURL, *flFilesExp, logger.With("handler", "files-sign"))
+			signHandler = http.StripPrefix(strings.TrimSuffix(files.EndpointSign, "/"), signHandler)
+			mux.Handle(files.EndpointSign, auditMiddleware(apiAuth(signHandler)))
+
+			var manifestHandler http.Handler = files.ManifestHandler(http.Dir(*flFilesDir), fileSigner, *flFilesURL, *flFilesExp, logger.With("handler", "manifest"))
+			manifestHandler = http.StripPrefix(strings.TrimSuffix(files.EndpointManifest, "/"), manifestHandler)
+			mux.Handle(files.EndpointManifest, auditMiddleware(apiAuth(manifestHandler)))
+		}
+	}
+
+	mux.HandleFunc(endpointVersion, mdmhttp.VersionHandler(version))
+
+	var handler http.Handler = mux
+	if *flDevIPAllow != "" || *flDevIPDeny != "" || *flAPIIPAllow != "" || *flAPIIPDeny != "" || *flCtryAllow != "" || *flCtryDeny != "" {
+		filterOpts := []ipfilter.Option{
+			ipfilter.WithLogger(logger.With("handler", "ip-filter")),
+			ipfilter.WithBlockFunc(func(ctx context.Context, b *ipfilter.Block) {
+				if webhookService == nil {
+					return
+				}
+				err := webhookService.RequestBlocked(ctx, &microwebhook.BlockedEvent{
+					IP:      b.IP,
+					Country: b.Country,
+					Path:    b.Path,
+					Reason:  b.Reason,
+				})
+				if err != nil {
+					logger.Info("msg", "blocked request webhook", "err", err)
+				}
+			}),
+		}
+		if *flClientIPHd != "" {
+			filterOpts = append(filterOpts, ipfilter.WithClientIPHeader(*flClientIPHd))
+		}
+		deviceOpts := append([]ipfilter.Option{}, filterOpts...)
+		if *flCtryAllow != "" || *flCtryDeny != "" {
+			if *flGeoIP == "" {
+				stdlog.Fatal("country filtering requires -geoip-csv")
+			}
+			db, err := ipfilter.LoadCSVCountryDB(*flGeoIP)
+			if err != nil {
+				stdlog.Fatal(err)
+			}
+			deviceOpts = append(deviceOpts, ipfilter.WithCountries(db, splitList(*flCtryAllow), splitList(*flCtryDeny)))
+		}
+		deviceFilter, err := newIPFilter(*flDevIPAllow, *flDevIPDeny, deviceOpts...)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		apiFilter, err := newIPFilter(*flAPIIPAllow, *flAPIIPDeny, filterOpts...)
+		if err != nil {
+			stdlog.Fatal(err)
+		}
+		deviceHandler := ipfilter.Middleware(mux, deviceFilter)
+		apiHandler := ipfilter.Middleware(mux, apiFilter)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isAPIPath(r.URL.Path) {
+				apiHandler.ServeHTTP(w, r)
+			} else {
+				deviceHandler.ServeHTTP(w, r)
+			}
+		})
 	}
 
-	mux.HandleFunc(endpointAPIVersion, mdmhttp.VersionHandler(version))
+	if registry != nil {
+		mux.Handle(endpointMetrics, registry)
+		mdmPath, checkinPath := *flPathPrefix+*flMDMPath, *flPathPrefix+*flChkinPath
+		handler = metrics.Handler(handler, metricsRec, func(r *http.Request) string {
+			switch {
+			case r.URL.Path == mdmPath:
+				return "mdm"
+			case *flCheckin && r.URL.Path == checkinPath:
+				return "checkin"
+			case isAPIPath(r.URL.Path):
+				return "api"
+			}
+			return "other"
+		})
+	}
 
 	rand.Seed(time.Now().UnixNano())
 
-	logger.Info("msg", "starting server", "listen", *flListen)
-	err = http.ListenAndServe(*flListen, mdmhttp.TraceLoggingMiddleware(mux, logger.With("handler", "log"), newTraceID))
+	handler = mdmhttp.TraceLoggingMiddleware(handler, logger.With("handler", "log"), newTraceID)
+	h2s := &http2.Server{MaxConcurrentStreams: uint32(*flH2Streams)}
+	if *flH2C {
+		// HTTP/2 with prior knowledge or an Upgrade from HTTP/1.1
+		handler = h2c.NewHandler(handler, h2s)
+	}
+	server := &http.Server{
+		Addr:              *flListen,
+		Handler:           handler,
+		ReadHeaderTimeout: *flHeaderTO,
+		ReadTimeout:       *flReadTO,
+		WriteTimeout:      *flWriteTO,
+		IdleTimeout:       *flIdleTO,
+		MaxHeaderBytes:    *flMaxHeader,
+	}
+	if err = http2.ConfigureServer(server, h2s); err != nil {
+		stdlog.Fatal(err)
+	}
+
+	logger.Info("msg", "starting server", "listen", *flListen, "h2c", *flH2C)
+	err = server.ListenAndServe()
 	logs := []interface{}{"msg", "server shutdown"}
 	if err != nil {
 		logs = append(logs, "err", err)
@@ -262,6 +1570,29 @@ func main() {
 	logger.Info(logs...)
 }
 
+// clientTLSOptions returns HTTP client options for validating servers
+// against the PEM CA certificates at caPath and presenting the PEM
+// client certificate and key at certPath and keyPath. Empty paths are
+// not configured.
+func clientTLSOptions(caPath, certPath, keyPath string) ([]client.Option, error) {
+	var opts []client.Option
+	if caPath != "" {
+		caPEM, err := os.ReadFile(caPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithRootCAsPEM(caPEM))
+	}
+	if certPath != "" || keyPath != "" {
+		cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithClientCertificate(cert))
+	}
+	return opts, nil
+}
+
 // newTraceID generates a new HTTP trace ID for context logging.
 // Currently this just makes a random string. This would be better
 // served by e.g. https://github.com/oklog/ulid or something like
@@ -271,3 +1602,103 @@ func newTraceID(_ *http.Request) string {
 	rand.Read(b)
 	return fmt.Sprintf("%x", b)
 }
+
+// newIPFilter creates an IP address filter from comma-separated allow
+// and deny CIDR network lists.
+func newIPFilter(allow, deny string, opts ...ipfilter.Option) (*ipfilter.Filter, error) {
+	allowNets, err := ipfilter.ParseCIDRs(allow)
+	if err != nil {
+		return nil, fmt.Errorf("parsing allowed networks: %w", err)
+	}
+	denyNets, err := ipfilter.ParseCIDRs(deny)
+	if err != nil {
+		return nil, fmt.Errorf("parsing denied networks: %w", err)
+	}
+	opts = append(opts, ipfilter.WithAllow(allowNets), ipfilter.WithDeny(denyNets))
+	return ipfilter.New(opts...)
+}
+
+// isAPIPath reports whether path is an API (rather than MDM) endpoint.
+func isAPIPath(path string) bool {
+	return strings.HasPrefix(path, "/v1/") ||
+		path == httpapi.EndpointMigration ||
+		path == httpapi.EndpointMetrics ||
+		path == endpointMetrics ||
+		strings.HasPrefix(path, micromdm.EndpointPush)
+}
+
+// verifyAuditLog verifies the audit log chain at path. Signatures are
+// verified if keyPath is set.
+func verifyAuditLog(path, keyPath string) error {
+	if path == "" {
+		return errors.New("no audit log to verify")
+	}
+	var pub ed25519.PublicKey
+	if keyPath != "" {
+		var err error
+		if pub, err = audit.LoadVerifyKey(keyPath); err != nil {
+			return err
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := audit.Verify(f, pub)
+	if err != nil {
+		return fmt.Errorf("audit log invalid after %d entries: %w", n, err)
+	}
+	fmt.Printf("audit log valid: %d entries\n", n)
+	return nil
+}
+
+// splitList splits a comma-separated list skipping empty items.
+func splitList(s string) []string {
+	var ret []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			ret = append(ret, item)
+		}
+	}
+	return ret
+}
+
+// headerFlag is a repeatable flag of "Name: value" HTTP headers.
+type headerFlag struct {
+	header http.Header
+}
+
+func (f *headerFlag) String() string {
+	if f == nil || f.header == nil {
+		return ""
+	}
+	var hdrs []string
+	for k, v := range f.header {
+		hdrs = append(hdrs, k+": "+strings.Join(v, ", "))
+	}
+	return strings.Join(hdrs, "; ")
+}
+
+func (f *headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header: %q", s)
+	}
+	if f.header == nil {
+		f.header = make(http.Header)
+	}
+	if v := f.header.Values(name); len(v) == 1 && v[0] == "" {
+		// a later value replaces a prior removal
+		f.header.Del(name)
+	}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		// an empty value removes the header
+		f.header[http.CanonicalHeaderKey(name)] = []string{""}
+		return nil
+	}
+	f.header.Add(name, value)
+	return nil
+}
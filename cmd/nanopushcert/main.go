@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/push/pushcert"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+const usage = `usage: nanopushcert <command> [flags]
+
+commands:
+  request   generate a push certificate key and vendor-signed request
+  finalize  upload the issued push certificate and key to NanoMDM
+  version   print version
+
+Use "nanopushcert <command> -h" for command flags.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "request":
+		err = request(os.Args[2:])
+	case "finalize":
+		err = finalize(os.Args[2:])
+	case "version", "-version", "--version":
+		fmt.Println(version)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+// request generates a new push certificate private key and a push
+// certificate request signed by the MDM vendor certificate.
+func request(args []string) error {
+	fs := flag.NewFlagSet("request", flag.ExitOnError)
+	var (
+		flCN         = fs.String("cn", "NanoMDM Push", "common name of the push certificate CSR")
+		flEmail      = fs.String("email", "", "email address of the push certificate CSR")
+		flVendorCert = fs.String("vendor-cert", "", "path to MDM vendor certificate (PEM or DER)")
+		flVendorKey  = fs.String("vendor-key", "", "path to MDM vendor PEM private key")
+		flChain      = fs.String("chain", "", "path to PEM Apple WWDR intermediate and root certificates")
+		flKeyOut     = fs.String("key-out", "push.key", "path to write the push certificate PEM private key")
+		flReqOut     = fs.String("request-out", "PushCertificateRequest", "path to write the push certificate request")
+		flCSROut     = fs.String("csr-out", "", "optional path to write the PEM push certificate CSR")
+	)
+	fs.Parse(args)
+	if *flVendorCert == "" || *flVendorKey == "" {
+		return errors.New("vendor certificate and key required")
+	}
+	b, err := ioutil.ReadFile(*flVendorCert)
+	if err != nil {
+		return err
+	}
+	chain, err := pushcert.ParseCertificates(b)
+	if err != nil {
+		return fmt.Errorf("parsing vendor certificate: %w", err)
+	}
+	if *flChain != "" {
+		if b, err = ioutil.ReadFile(*flChain); err != nil {
+			return err
+		}
+		certs, err := pushcert.ParseCertificates(b)
+		if err != nil {
+			return fmt.Errorf("parsing chain: %w", err)
+		}
+		chain = append(chain, certs...)
+	}
+	if len(chain) < 3 {
+		fmt.Fprintln(os.Stderr, "warning: certificate chain should include the vendor, Apple WWDR intermediate, and Apple root certificates")
+	}
+	if b, err = ioutil.ReadFile(*flVendorKey); err != nil {
+		return err
+	}
+	vendorKey, err := pushcert.ParsePrivateKey(b)
+	if err != nil {
+		return fmt.Errorf("parsing vendor key: %w", err)
+	}
+	if !vendorKey.PublicKey.Equal(chain[0].PublicKey) {
+		return errors.New("vendor key does not match vendor certificate")
+	}
+	// avoid overwriting an existing key that may still be needed
+	if _, err = os.Stat(*flKeyOut); err == nil {
+		return fmt.Errorf("key file already exists: %s", *flKeyOut)
+	}
+	key, csr, err := pushcert.NewKeyAndCSR(*flCN, *flEmail)
+	if err != nil {
+		return err
+	}
+	req, err := pushcert.SignRequest(csr, vendorKey, chain)
+	if err != nil {
+		return err
+	}
+	reqBytes, err := req.Encode()
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(*flKeyOut, pushcert.PEMPrivateKey(key), 0600); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(*flReqOut, reqBytes, 0644); err != nil {
+		return err
+	}
+	if *flCSROut != "" {
+		csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+		if err = ioutil.WriteFile(*flCSROut, csrPEM, 0644); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote private key to %s and request to %s\n", *flKeyOut, *flReqOut)
+	fmt.Println("upload the request to https://identity.apple.com/pushcert/ then run: nanopushcert finalize")
+	return nil
+}
+
+// finalize checks that the issued push certificate matches the private
+// key and uploads them to the NanoMDM push certificate API.
+func finalize(args []string) error {
+	fs := flag.NewFlagSet("finalize", flag.ExitOnError)
+	var (
+		flCert   = fs.String("cert", "", "path to push certificate issued by Apple (PEM or DER)")
+		flKey    = fs.String("key", "push.key", "path to the push certificate PEM private key")
+		flURL    = fs.String("url", "", "NanoMDM push certificate API URL (e.g. https://nanomdm.example.com/v1/pushcert)")
+		flAPIKey = fs.String("api-key", "", "NanoMDM API key")
+		flOut    = fs.String("out", "", "optional path to write the combined PEM certificate and key")
+	)
+	fs.Parse(args)
+	if *flCert == "" {
+		return errors.New("push certificate required")
+	}
+	if *flURL == "" && *flOut == "" {
+		return errors.New("one of URL or output path required")
+	}
+	b, err := ioutil.ReadFile(*flCert)
+	if err != nil {
+		return err
+	}
+	certs, err := pushcert.ParseCertificates(b)
+	if err != nil {
+		return fmt.Errorf("parsing push certificate: %w", err)
+	}
+	if b, err = ioutil.ReadFile(*flKey); err != nil {
+		return err
+	}
+	key, err := pushcert.ParsePrivateKey(b)
+	if err != nil {
+		return fmt.Errorf("parsing key: %w", err)
+	}
+	certPub, ok := certs[0].PublicKey.(*rsa.PublicKey)
+	if !ok || !key.PublicKey.Equal(certPub) {
+		return errors.New("push certificate does not match key")
+	}
+	topic, err := cryptoutil.TopicFromCert(certs[0])
+	if err != nil {
+		return err
+	}
+	combined := append(cryptoutil.PEMCertificate(certs[0].Raw), pushcert.PEMPrivateKey(key)...)
+	if *flOut != "" {
+		if err = ioutil.WriteFile(*flOut, combined, 0600); err != nil {
+			return err
+		}
+		fmt.Printf("wrote certificate and key for topic %s to %s\n", topic, *flOut)
+	}
+	if *flURL == "" {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPut, *flURL, bytes.NewBuffer(combined))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("nanomdm", *flAPIKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected HTTP status %s: %s", resp.Status, body)
+	}
+	fmt.Printf("uploaded push certificate for topic %s (expires %s)\n", topic, certs[0].NotAfter)
+	return nil
+}
@@ -0,0 +1,159 @@
+// Command nanoreplay replays a NanoMDM command queue snapshot against a
+// (test) NanoMDM instance to reproduce per-device command sequencing.
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	httpapi "github.com/micromdm/nanomdm/http/api"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log/stdlogfmt"
+)
+
+// overridden by -ldflags -X
+var version = "unknown"
+
+func main() {
+	var (
+		flVersion  = flag.Bool("version", false, "print version")
+		flDebug    = flag.Bool("debug", false, "log debug messages")
+		flURL      = flag.String("url", "", "NanoMDM server URL (e.g. http://127.0.0.1:9000)")
+		flAPIKey   = flag.String("key", "", "NanoMDM API Key")
+		flID       = flag.String("id", "", "enrollment ID to replay to (default the snapshot enrollment ID)")
+		flNewUUIDs = flag.Bool("new-uuids", false, "replace command UUIDs so that a snapshot can be replayed more than once")
+		flInactive = flag.Bool("inactive", false, "also replay commands of cleared (inactive) queues")
+		flPush     = flag.Bool("push", false, "send a push notification after enqueueing the last command")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <snapshot.json>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *flVersion {
+		fmt.Println(version)
+		return
+	}
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	logger := stdlogfmt.New(stdlogfmt.WithDebugFlag(*flDebug))
+
+	var skipServer bool
+	if *flURL == "" || *flAPIKey == "" {
+		logger.Info("msg", "URL or API key not set; not sending server requests")
+		skipServer = true
+	}
+	client := http.DefaultClient
+
+	b, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	snapshot := new(httpapi.QueueSnapshot)
+	if err = json.Unmarshal(b, snapshot); err != nil {
+		stdlog.Fatal(err)
+	}
+	id := *flID
+	if id == "" {
+		id = snapshot.EnrollmentID
+	}
+
+	var cmds []*storage.QueuedCommand
+	for _, cmd := range snapshot.Commands {
+		if cmd.Active || *flInactive {
+			cmds = append(cmds, cmd.QueuedCommand)
+		}
+	}
+
+	// order matters so commands are enqueued one at a time in the
+	// queue order of the snapshot.
+	for i, cmd := range cmds {
+		raw := cmd.Command
+		uuid := cmd.CommandUUID
+		if *flNewUUIDs {
+			if raw, uuid, err = newUUID(raw); err != nil {
+				stdlog.Fatal(err)
+			}
+		}
+		logs := []interface{}{
+			"msg", "replaying command",
+			"id", id,
+			"command_uuid", uuid,
+			"request_type", cmd.RequestType,
+		}
+		if uuid != cmd.CommandUUID {
+			logs = append(logs, "original_command_uuid", cmd.CommandUUID)
+		}
+		if cmd.Status != "" {
+			logs = append(logs, "original_status", cmd.Status)
+		}
+		logger.Info(logs...)
+		if !skipServer {
+			push := *flPush && i == len(cmds)-1
+			if err := enqueue(client, *flURL, *flAPIKey, id, push, raw); err != nil {
+				logger.Info("msg", "sending to enqueue endpoint", "err", err)
+			}
+		}
+	}
+}
+
+// newUUID replaces the CommandUUID of the raw command with a new one.
+func newUUID(raw []byte) ([]byte, string, error) {
+	var cmd map[string]interface{}
+	if err := plist.Unmarshal(raw, &cmd); err != nil {
+		return nil, "", err
+	}
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	uuid := strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+	cmd["CommandUUID"] = uuid
+	raw, err := plist.MarshalIndent(cmd, "\t")
+	return raw, uuid, err
+}
+
+func enqueue(client *http.Client, serverURL, key, id string, push bool, sendBytes []byte) error {
+	if serverURL == "" || key == "" {
+		return errors.New("no URL or API key")
+	}
+	u := strings.TrimSuffix(serverURL, "/") + httpapi.EndpointEnqueue + url.PathEscape(id)
+	if !push {
+		u += "?nopush=1"
+	}
+	req, err := http.NewRequest("PUT", u, bytes.NewReader(sendBytes))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("nanomdm", key)
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	_, err = io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != 200 {
+		return fmt.Errorf("enqueue request failed with HTTP status: %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package keyprovider encrypts and decrypts key material (such as push
+// certificate private keys) at rest.
+//
+// Sealed key material is a PEM block of type PEMType containing the
+// provider ciphertext. This makes it easy to tell sealed key material
+// apart from existing unencrypted PEM keys which supports migrating
+// existing data.
+package keyprovider
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// PEMType is the PEM block type of sealed key material.
+const PEMType = "NANOMDM ENCRYPTED KEY"
+
+// KeyProvider encrypts and decrypts key material.
+type KeyProvider interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+}
+
+// AESGCM is a KeyProvider using AES-GCM with a static key.
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM creates a new AES-GCM KeyProvider. The key must be 16, 24,
+// or 32 bytes long to select AES-128, AES-192, or AES-256.
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+// LoadAESGCM creates a new AES-GCM KeyProvider from the key file at
+// path. The file contains the key in hex or base64 encoding.
+func LoadAESGCM(path string) (*AESGCM, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSpace(b)
+	key, err := hex.DecodeString(string(b))
+	if err != nil {
+		if key, err = base64.StdEncoding.DecodeString(string(b)); err != nil {
+			return nil, errors.New("key is not hex or base64 encoded")
+		}
+	}
+	return NewAESGCM(key)
+}
+
+// Encrypt encrypts plaintext. The random nonce is prepended to the
+// returned ciphertext.
+func (p *AESGCM) Encrypt(_ context.Context, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, p.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt decrypts ciphertext created with Encrypt.
+func (p *AESGCM) Decrypt(_ context.Context, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < p.aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:p.aead.NonceSize()], ciphertext[p.aead.NonceSize():]
+	return p.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// Sealed reports whether data is sealed key material.
+func Sealed(data []byte) bool {
+	block, _ := pem.Decode(data)
+	return block != nil && block.Type == PEMType
+}
+
+// Seal encrypts plaintext with kp and returns it PEM encoded.
+func Seal(ctx context.Context, kp KeyProvider, plaintext []byte) ([]byte, error) {
+	ciphertext, err := kp.Encrypt(ctx, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PEMType, Bytes: ciphertext}), nil
+}
+
+// Open decrypts sealed key material with kp. Unsealed data is returned
+// as-is to support data stored before encryption was enabled. Sealed
+// data with a nil kp is an error.
+func Open(ctx context.Context, kp KeyProvider, data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != PEMType {
+		return data, nil
+	}
+	if kp == nil {
+		return nil, errors.New("encrypted key without key provider")
+	}
+	plaintext, err := kp.Decrypt(ctx, block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting key: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,220 @@
+// Package embed wires together the core NanoMDM components for
+// applications that embed NanoMDM.
+//
+// It sets up what the nanomdm server command does for a typical
+// deployment: certificate verification and extraction, the core MDM
+// service with certificate authorization, an optional webhook, the APNs
+// push service, and the MDM and API HTTP handlers. Applications that
+// need more control can instead assemble the individual packages
+// themselves using this package as a guide.
+package embed
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/certverify"
+	"github.com/micromdm/nanomdm/enqueue"
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	httpapi "github.com/micromdm/nanomdm/http/api"
+	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/nanopush"
+	pushsvc "github.com/micromdm/nanomdm/push/service"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/certauth"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	"github.com/micromdm/nanomdm/service/multi"
+	"github.com/micromdm/nanomdm/service/nanomdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// APIUsername is the HTTP Basic authentication username of the API.
+const APIUsername = "nanomdm"
+
+// EndpointVersion is the path of the version endpoint.
+const EndpointVersion = "/version"
+
+// Config configures an embedded NanoMDM.
+type Config struct {
+	// Storage is the storage backend. Required.
+	Storage storage.AllStorage
+
+	// Verifier verifies MDM client identity certificates. If nil a
+	// verifier is created from CAPEM and IntermediatePEM.
+	Verifier httpmdm.CertVerifier
+	// CAPEM and IntermediatePEM are the PEM CA and intermediate
+	// certificates that issued MDM client identity certificates.
+	CAPEM           []byte
+	IntermediatePEM []byte
+
+	// CertHeader is the HTTP header containing the URL-escaped TLS
+	// client certificate. If empty the Mdm-Signature header is used.
+	CertHeader string
+
+	// SeparateCheckin serves check-ins on a separate endpoint.
+	SeparateCheckin bool
+
+	// AllowRetroactive allows retroactive certificate-authorization
+	// association of existing enrollments.
+	AllowRetroactive bool
+
+	// DMURLPrefix is the URL to send Declarative Management requests to.
+	DMURLPrefix string
+
+	// WebhookURL is the URL to send webhook events to.
+	WebhookURL string
+
+	// APIKey is the API HTTP Basic authentication password. The API
+	// endpoints are not registered if empty.
+	APIKey string
+
+	// Version is reported by the version endpoint.
+	Version string
+
+	// HTTPClient is used for outbound requests (Declarative Management
+	// and webhooks). Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+
+	// Logger is the logger. Defaults to a no-op logger.
+	Logger log.Logger
+}
+
+// NanoMDM is an embedded NanoMDM.
+type NanoMDM struct {
+	// Service is the complete MDM service including middleware.
+	Service service.CheckinAndCommandService
+	// Pusher sends APNs push notifications. Nil without an APIKey.
+	Pusher push.Pusher
+	// Webhook is the webhook service. Nil without a WebhookURL.
+	Webhook *microwebhook.MicroWebhook
+	// Enqueuer enqueues commands with options. Its Run method must be
+	// running for commands with a NotBefore time to be enqueued. It
+	// can only push with an APIKey (i.e. with a Pusher). Nil if the
+	// storage does not retain command results.
+	Enqueuer *enqueue.Enqueuer
+
+	mux *http.ServeMux
+}
+
+// New creates a new embedded NanoMDM from cfg.
+func New(cfg *Config) (*NanoMDM, error) {
+	if cfg == nil || cfg.Storage == nil {
+		return nil, errors.New("embed: storage is required")
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	verifier := cfg.Verifier
+	if verifier == nil {
+		if len(cfg.CAPEM) < 1 {
+			return nil, errors.New("embed: verifier or CA certificates required")
+		}
+		var err error
+		verifier, err = certverify.NewPoolVerifier(cfg.CAPEM, cfg.IntermediatePEM, x509.ExtKeyUsageClientAuth)
+		if err != nil {
+			return nil, fmt.Errorf("embed: creating verifier: %w", err)
+		}
+	}
+
+	// create 'core' MDM service
+	nanoOpts := []nanomdm.Option{
+		nanomdm.WithUserAuthenticate(nanomdm.NewUAService(cfg.Storage, false)),
+		nanomdm.WithLogger(logger.With("service", "nanomdm")),
+	}
+	if cfg.DMURLPrefix != "" {
+		var dm service.DeclarativeManagement
+		dm, err := nanomdm.NewDeclarativeManagementHTTPCaller(cfg.DMURLPrefix, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("embed: creating declarative management caller: %w", err)
+		}
+		dm = nanomdm.NewDMTracker(dm, cfg.Storage, nanomdm.WithDMTrackerLogger(logger.With("service", "dm-tracker")))
+		nanoOpts = append(nanoOpts, nanomdm.WithDeclarativeManagement(dm))
+	}
+	nano := nanomdm.New(cfg.Storage, nanoOpts...)
+
+	n := &NanoMDM{mux: http.NewServeMux()}
+
+	var mdmService service.CheckinAndCommandService = nano
+	if cfg.WebhookURL != "" {
+		n.Webhook = microwebhook.New(cfg.WebhookURL, cfg.Storage, microwebhook.WithClient(httpClient))
+		mdmService = multi.New(logger.With("service", "multi"), mdmService, n.Webhook)
+	}
+	certAuthOpts := []certauth.Option{certauth.WithLogger(logger.With("service", "certauth"))}
+	if cfg.AllowRetroactive {
+		certAuthOpts = append(certAuthOpts, certauth.WithAllowRetroactive())
+	}
+	mdmService = certauth.New(mdmService, cfg.Storage, certAuthOpts...)
+	n.Service = mdmService
+
+	// helper for authorizing MDM clients requests
+	certAuthMiddleware := func(h http.Handler) http.Handler {
+		h = httpmdm.CertVerifyMiddleware(h, verifier, logger.With("handler", "cert-verify"))
+		if cfg.CertHeader != "" {
+			return httpmdm.CertExtractPEMHeaderMiddleware(h, cfg.CertHeader, logger.With("handler", "cert-extract"))
+		}
+		return httpmdm.CertExtractMdmSignatureMiddleware(h, httpmdm.SigLogWithLogger(logger.With("handler", "cert-extract")))
+	}
+
+	mdmHandlers := &httpmdm.Handlers{
+		Service:         mdmService,
+		SeparateCheckin: cfg.SeparateCheckin,
+		Logger:          logger,
+	}
+	mdmHandlers.Register(n.mux, "", certAuthMiddleware)
+
+	// optional storage interfaces are only used with their capability
+	caps := storage.DiscoverCapabilities(cfg.Storage)
+	optionalStore := func(c storage.Capability, target interface{}) bool {
+		return caps[c] && storage.As(cfg.Storage, target)
+	}
+	var queue storage.QueueRetriever
+	optionalStore(storage.CapabilityResultRetention, &queue)
+
+	if cfg.APIKey != "" {
+		n.Pusher = pushsvc.New(cfg.Storage, cfg.Storage, nanopush.NewFactory(), logger.With("service", "push"))
+		apiHandlers := &httpapi.Handlers{
+			Store:  cfg.Storage,
+			Pusher: n.Pusher,
+			Queue:  queue,
+			Logger: logger,
+		}
+		optionalStore(storage.CapabilityMetadata, &apiHandlers.Metadata)
+		optionalStore(storage.CapabilityInventory, &apiHandlers.Inventory)
+		optionalStore(storage.CapabilityUserSessions, &apiHandlers.UserSessions)
+		optionalStore(storage.CapabilityMetricsRollups, &apiHandlers.Rollups)
+		optionalStore(storage.CapabilitySupersession, &apiHandlers.Supersessions)
+		apiHandlers.Register(n.mux, "", func(h http.Handler) http.Handler {
+			return mdmhttp.BasicAuthMiddleware(h, APIUsername, cfg.APIKey, "nanomdm")
+		})
+	}
+
+	enqueueOpts := []enqueue.Option{enqueue.WithLogger(logger.With("service", "enqueue"))}
+	if n.Pusher != nil {
+		enqueueOpts = append(enqueueOpts, enqueue.WithPusher(n.Pusher))
+	}
+	if queue != nil {
+		n.Enqueuer = enqueue.New(struct {
+			storage.AllStorage
+			storage.QueueRetriever
+		}{cfg.Storage, queue}, enqueueOpts...)
+	}
+
+	n.mux.HandleFunc(EndpointVersion, mdmhttp.VersionHandler(cfg.Version))
+
+	return n, nil
+}
+
+// ServeHTTP serves the MDM and API endpoints.
+func (n *NanoMDM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n.mux.ServeHTTP(w, r)
+}
@@ -0,0 +1,64 @@
+package embed
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httpapi "github.com/micromdm/nanomdm/http/api"
+	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+type nopVerifier struct{}
+
+func (nopVerifier) Verify(context.Context, *x509.Certificate) error { return nil }
+
+func TestNew(t *testing.T) {
+	if _, err := New(&Config{}); err == nil {
+		t.Error("expected error without storage")
+	}
+
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(&Config{Storage: store}); err == nil {
+		t.Error("expected error without verifier or CA")
+	}
+
+	n, err := New(&Config{
+		Storage:  store,
+		Verifier: nopVerifier{},
+		APIKey:   "secret",
+		Version:  "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.Service == nil || n.Pusher == nil {
+		t.Fatal("expected service and pusher")
+	}
+
+	rec := httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest("GET", EndpointVersion, nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Errorf("version status: have %d, want %d", have, want)
+	}
+
+	// API requires authentication
+	rec = httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest("GET", httpapi.EndpointPush+"ID1", nil))
+	if have, want := rec.Code, http.StatusUnauthorized; have != want {
+		t.Errorf("push status: have %d, want %d", have, want)
+	}
+
+	// MDM requests require a client certificate
+	rec = httptest.NewRecorder()
+	n.ServeHTTP(rec, httptest.NewRequest("PUT", httpmdm.EndpointMDM, nil))
+	if rec.Code == http.StatusOK {
+		t.Error("expected MDM request without certificate to fail")
+	}
+}
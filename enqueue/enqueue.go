@@ -0,0 +1,345 @@
+// Package enqueue enqueues commands with options for applications that
+// embed NanoMDM or otherwise use its storage directly rather than the
+// HTTP API.
+//
+// Commands with a NotBefore time are held by the Enqueuer, in memory,
+// until they are due so that they work with any storage backend. Run
+// must be running for held commands to be enqueued. Priority orders
+// held commands that become due at the same time and is passed to the
+// storage backend which, if it has CapabilityCommandPriority, sends
+// higher priority commands first.
+package enqueue
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+// ErrExpired is returned when enqueueing a command that has expired.
+var ErrExpired = errors.New("command expired")
+
+// ErrNoPusher is returned when a push is requested without a Pusher.
+var ErrNoPusher = errors.New("no pusher")
+
+// ErrInvalidPriority is returned when enqueueing a command with a
+// priority outside of mdm.MinPriority and mdm.MaxPriority.
+var ErrInvalidPriority = errors.New("invalid priority")
+
+// Store enqueues commands and retrieves enrollment command queues.
+type Store interface {
+	storage.CommandEnqueuer
+	storage.QueueRetriever
+}
+
+// Options are the options of an enqueued command.
+type Options struct {
+	// Priority orders commands that are due at the same time, highest
+	// first. If not zero it sets the Priority of the enqueued command.
+	// Otherwise the Priority of the command is used.
+	Priority int
+
+	// NotBefore holds the command until this time.
+	NotBefore time.Time
+
+	// Expiry drops the command if it is not enqueued by this time.
+	// Commands that have been enqueued (and sent) are not affected.
+	Expiry time.Time
+
+	// IdempotencyKey derives the command UUID from the key so that
+	// enqueueing the same key again does not queue the command for
+	// enrollments that already have it queued (or held).
+	IdempotencyKey string
+
+	// Push sends APNs pushes to the enrollments once the command is
+	// enqueued.
+	Push bool
+}
+
+// Result is the result of enqueueing a command.
+type Result struct {
+	CommandUUID string
+
+	// Scheduled is true if the command is held until its NotBefore time.
+	Scheduled bool
+
+	// Existing are the enrollments that already had the command of the
+	// idempotency key queued and were skipped.
+	Existing []string
+
+	// Errors are the enqueue errors keyed by enrollment ID.
+	Errors map[string]error
+
+	// PushResponses are the push responses keyed by enrollment ID.
+	PushResponses map[string]*push.Response
+}
+
+// held is a command held until it is due.
+type held struct {
+	ids  []string
+	cmd  *mdm.Command
+	opts Options
+}
+
+// Enqueuer enqueues commands with options.
+type Enqueuer struct {
+	store  Store
+	pusher push.Pusher
+	logger log.Logger
+
+	mu   sync.Mutex
+	held []*held
+}
+
+// Option configures an Enqueuer.
+type Option func(*Enqueuer)
+
+// WithLogger configures a logger on the Enqueuer.
+func WithLogger(logger log.Logger) Option {
+	return func(e *Enqueuer) {
+		e.logger = logger
+	}
+}
+
+// WithPusher sets the Pusher used to send pushes for the Push option.
+func WithPusher(pusher push.Pusher) Option {
+	return func(e *Enqueuer) {
+		e.pusher = pusher
+	}
+}
+
+// New creates a new Enqueuer that enqueues commands to store.
+func New(store Store, opts ...Option) *Enqueuer {
+	e := &Enqueuer{
+		store:  store,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// idempotentUUID derives a command UUID from key.
+func idempotentUUID(key string) string {
+	b := sha256.Sum256([]byte("nanomdm-enqueue:" + key))
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]))
+}
+
+// withCommandUUID returns cmd re-encoded with the command UUID uuid.
+func withCommandUUID(cmd *mdm.Command, uuid string) (*mdm.Command, error) {
+	var m map[string]interface{}
+	if err := plist.Unmarshal(cmd.Raw, &m); err != nil {
+		return nil, err
+	}
+	m["CommandUUID"] = uuid
+	raw, err := plist.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	newCmd, err := mdm.DecodeCommand(raw)
+	if err != nil {
+		return nil, err
+	}
+	newCmd.Priority = cmd.Priority
+	return newCmd, nil
+}
+
+// existing returns the ids that have the command uuid queued.
+func (e *Enqueuer) existing(ctx context.Context, ids []string, uuid string) (map[string]bool, error) {
+	ret := make(map[string]bool)
+	for _, id := range ids {
+		cmds, err := e.store.RetrieveQueue(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving queue of %s: %w", id, err)
+		}
+		for _, cmd := range cmds {
+			if cmd.CommandUUID == uuid {
+				ret[id] = true
+				break
+			}
+		}
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, h := range e.held {
+		if h.cmd.CommandUUID != uuid {
+			continue
+		}
+		for _, id := range h.ids {
+			ret[id] = true
+		}
+	}
+	return ret, nil
+}
+
+// EnqueueWithOptions enqueues cmd for ids with opts. A nil opts enqueues
+// cmd without options. An error is returned if cmd can not be enqueued
+// at all or pushing fails. Errors enqueueing cmd and pushing to
+// individual enrollments are returned in the result.
+func (e *Enqueuer) EnqueueWithOptions(ctx context.Context, ids []string, cmd *mdm.Command, opts *Options) (*Result, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if cmd == nil {
+		return nil, errors.New("nil command")
+	}
+	if opts.Push && e.pusher == nil {
+		return nil, ErrNoPusher
+	}
+	now := time.Now()
+	if !opts.Expiry.IsZero() && !now.Before(opts.Expiry) {
+		return nil, ErrExpired
+	}
+	if opts.Priority != 0 && cmd.Priority != opts.Priority {
+		prioCmd := *cmd
+		prioCmd.Priority = opts.Priority
+		cmd = &prioCmd
+	}
+	if cmd.Priority < mdm.MinPriority || cmd.Priority > mdm.MaxPriority {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidPriority, cmd.Priority)
+	}
+	res := &Result{CommandUUID: cmd.CommandUUID}
+	if opts.IdempotencyKey != "" {
+		var err error
+		if cmd, err = withCommandUUID(cmd, idempotentUUID(opts.IdempotencyKey)); err != nil {
+			return nil, fmt.Errorf("setting command UUID: %w", err)
+		}
+		res.CommandUUID = cmd.CommandUUID
+		existing, err := e.existing(ctx, ids, cmd.CommandUUID)
+		if err != nil {
+			return nil, err
+		}
+		var newIDs []string
+		for _, id := range ids {
+			if existing[id] {
+				res.Existing = append(res.Existing, id)
+			} else {
+				newIDs = append(newIDs, id)
+			}
+		}
+		ids = newIDs
+	}
+	if len(ids) < 1 {
+		return res, nil
+	}
+	if opts.NotBefore.After(now) {
+		e.mu.Lock()
+		e.held = append(e.held, &held{ids: ids, cmd: cmd, opts: *opts})
+		e.mu.Unlock()
+		res.Scheduled = true
+		return res, nil
+	}
+	return res, e.enqueue(ctx, ids, cmd, opts.Push, res)
+}
+
+// enqueue enqueues cmd for ids, optionally pushes, and records the
+// outcome in res.
+func (e *Enqueuer) enqueue(ctx context.Context, ids []string, cmd *mdm.Command, doPush bool, res *Result) error {
+	idErrs, err := e.store.EnqueueCommand(ctx, ids, cmd)
+	if err != nil && len(idErrs) < 1 {
+		return err
+	}
+	res.Errors = idErrs
+	if !doPush {
+		return nil
+	}
+	var pushIDs []string
+	for _, id := range ids {
+		if idErrs[id] == nil {
+			pushIDs = append(pushIDs, id)
+		}
+	}
+	if len(pushIDs) < 1 {
+		return nil
+	}
+	res.PushResponses, err = e.pusher.Push(ctx, pushIDs)
+	if err != nil {
+		return fmt.Errorf("pushing: %w", err)
+	}
+	return nil
+}
+
+// due removes and returns the held commands due at now in priority
+// order. Expired held commands are dropped.
+func (e *Enqueuer) due(now time.Time) (due []*held, expired []*held) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var keep []*held
+	for _, h := range e.held {
+		switch {
+		case !h.opts.Expiry.IsZero() && !now.Before(h.opts.Expiry):
+			expired = append(expired, h)
+		case !now.Before(h.opts.NotBefore):
+			due = append(due, h)
+		default:
+			keep = append(keep, h)
+		}
+	}
+	e.held = keep
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].cmd.Priority > due[j].cmd.Priority
+	})
+	return
+}
+
+// Held returns the number of commands held until they are due.
+func (e *Enqueuer) Held() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.held)
+}
+
+// EnqueueDue enqueues the held commands that are due. Errors are
+// logged.
+func (e *Enqueuer) EnqueueDue(ctx context.Context) {
+	due, expired := e.due(time.Now())
+	for _, h := range expired {
+		e.logger.Info("msg", "dropping expired command", "command_uuid", h.cmd.CommandUUID, "count", len(h.ids))
+	}
+	for _, h := range due {
+		res := new(Result)
+		logs := []interface{}{"msg", "enqueued held command", "command_uuid", h.cmd.CommandUUID, "count", len(h.ids)}
+		err := e.enqueue(ctx, h.ids, h.cmd, h.opts.Push, res)
+		if err != nil {
+			logs = append(logs, "err", err)
+		}
+		if len(res.Errors) > 0 {
+			logs = append(logs, "errs", len(res.Errors))
+		}
+		if err != nil || len(res.Errors) > 0 {
+			e.logger.Info(logs...)
+		} else {
+			e.logger.Debug(logs...)
+		}
+	}
+}
+
+// Run enqueues held commands when they are due, checking every
+// interval, until ctx is done.
+func (e *Enqueuer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			e.EnqueueDue(ctx)
+		}
+	}
+}
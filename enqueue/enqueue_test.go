@@ -0,0 +1,193 @@
+package enqueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+const testCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>ProfileList</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>0001</string>
+</dict>
+</plist>`
+
+type fauxPusher struct {
+	pushed []string
+}
+
+func (p *fauxPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	p.pushed = append(p.pushed, ids...)
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		ret[id] = &push.Response{Id: "push-" + id}
+	}
+	return ret, nil
+}
+
+// newStore returns a mock store that records enqueued command UUIDs by
+// enrollment ID in queues.
+func newStore(queues map[string][]string) *mock.Storage {
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+		for _, id := range ids {
+			queues[id] = append(queues[id], cmd.CommandUUID)
+		}
+		return nil, nil
+	}
+	store.RetrieveQueueFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		var cmds []*storage.QueuedCommand
+		for _, uuid := range queues[id] {
+			cmds = append(cmds, &storage.QueuedCommand{CommandUUID: uuid})
+		}
+		return cmds, nil
+	}
+	return store
+}
+
+func TestEnqueueWithOptions(t *testing.T) {
+	ctx := context.Background()
+	cmd, err := mdm.DecodeCommand([]byte(testCommand))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues := make(map[string][]string)
+	pusher := &fauxPusher{}
+	e := New(newStore(queues), WithPusher(pusher))
+
+	res, err := e.EnqueueWithOptions(ctx, []string{"ID1"}, cmd, &Options{Push: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CommandUUID != "0001" || len(queues["ID1"]) != 1 || res.PushResponses["ID1"] == nil {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	// idempotent enqueues skip enrollments that have the command
+	opts := &Options{IdempotencyKey: "key1"}
+	if res, err = e.EnqueueWithOptions(ctx, []string{"ID1"}, cmd, opts); err != nil {
+		t.Fatal(err)
+	}
+	uuid := res.CommandUUID
+	if uuid == "0001" || len(queues["ID1"]) != 2 || queues["ID1"][1] != uuid {
+		t.Errorf("unexpected idempotent enqueue: %+v, %v", res, queues)
+	}
+	if res, err = e.EnqueueWithOptions(ctx, []string{"ID1", "ID2"}, cmd, opts); err != nil {
+		t.Fatal(err)
+	}
+	if res.CommandUUID != uuid || len(res.Existing) != 1 || res.Existing[0] != "ID1" {
+		t.Errorf("unexpected existing: %+v", res)
+	}
+	if len(queues["ID1"]) != 2 || len(queues["ID2"]) != 1 {
+		t.Errorf("unexpected queues: %v", queues)
+	}
+
+	if _, err = e.EnqueueWithOptions(ctx, []string{"ID1"}, cmd, &Options{Expiry: time.Now().Add(-time.Second)}); err != ErrExpired {
+		t.Errorf("have %v, want %v", err, ErrExpired)
+	}
+	if _, err = New(newStore(queues)).EnqueueWithOptions(ctx, []string{"ID1"}, cmd, &Options{Push: true}); err != ErrNoPusher {
+		t.Errorf("have %v, want %v", err, ErrNoPusher)
+	}
+}
+
+func TestEnqueuePriority(t *testing.T) {
+	ctx := context.Background()
+	cmd, err := mdm.DecodeCommand([]byte(testCommand))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var priority int
+	store := newStore(make(map[string][]string))
+	store.EnqueueCommandFunc = func(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+		priority = cmd.Priority
+		return nil, nil
+	}
+	opts := &Options{Priority: mdm.PriorityUrgent, IdempotencyKey: "urgent"}
+	if _, err = New(store).EnqueueWithOptions(ctx, []string{"ID1"}, cmd, opts); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := priority, mdm.PriorityUrgent; have != want {
+		t.Errorf("priority: have %d, want %d", have, want)
+	}
+	if cmd.Priority != mdm.PriorityNormal {
+		t.Errorf("command modified: priority %d", cmd.Priority)
+	}
+
+	// without a priority option the command keeps its own priority
+	highCmd := *cmd
+	highCmd.Priority = mdm.PriorityHigh
+	if _, err = New(store).EnqueueWithOptions(ctx, []string{"ID1"}, &highCmd, nil); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := priority, mdm.PriorityHigh; have != want {
+		t.Errorf("priority: have %d, want %d", have, want)
+	}
+
+	for _, p := range []int{mdm.MinPriority - 1, mdm.MaxPriority + 1} {
+		if _, err = New(store).EnqueueWithOptions(ctx, []string{"ID1"}, cmd, &Options{Priority: p}); !errors.Is(err, ErrInvalidPriority) {
+			t.Errorf("priority %d: have %v, want %v", p, err, ErrInvalidPriority)
+		}
+	}
+}
+
+func TestEnqueueHeld(t *testing.T) {
+	ctx := context.Background()
+	cmd, err := mdm.DecodeCommand([]byte(testCommand))
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues := make(map[string][]string)
+	e := New(newStore(queues))
+
+	soon := time.Now().Add(20 * time.Millisecond)
+	for _, opts := range []*Options{
+		{NotBefore: soon, IdempotencyKey: "low", Priority: 1},
+		{NotBefore: soon, IdempotencyKey: "high", Priority: 5},
+		{NotBefore: soon, IdempotencyKey: "expiring", Expiry: soon},
+		{NotBefore: time.Now().Add(time.Hour), IdempotencyKey: "later"},
+	} {
+		res, err := e.EnqueueWithOptions(ctx, []string{"ID1"}, cmd, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Scheduled {
+			t.Errorf("expected scheduled: %+v", res)
+		}
+	}
+	// held commands are idempotent, too
+	res, err := e.EnqueueWithOptions(ctx, []string{"ID1"}, cmd, &Options{NotBefore: soon, IdempotencyKey: "low"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Scheduled || len(res.Existing) != 1 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+
+	e.EnqueueDue(ctx)
+	if len(queues["ID1"]) != 0 || e.Held() != 4 {
+		t.Fatalf("enqueued before due: %v", queues)
+	}
+	time.Sleep(30 * time.Millisecond)
+	e.EnqueueDue(ctx)
+	want := []string{idempotentUUID("high"), idempotentUUID("low")}
+	if have := queues["ID1"]; len(have) != 2 || have[0] != want[0] || have[1] != want[1] {
+		t.Errorf("queue: have %v, want %v", have, want)
+	}
+	if have, want := e.Held(), 1; have != want {
+		t.Errorf("held: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,349 @@
+// Package agent is an experimental adapter of a minimal JSON agent
+// protocol to the MDM service.
+//
+// Non-Apple device management agents (e.g. on Windows or Linux) enroll
+// and poll for commands over HTTP with JSON messages. The adapter maps
+// these to MDM check-in messages and command reports of the MDM service
+// so that agents share the NanoMDM storage, command queue, and eventing
+// (webhooks, event log, etc.) with Apple devices. Commands are enqueued
+// for agents as plists with the usual enqueue API and are delivered to
+// agents as JSON. Agents have no APNs push tokens and poll instead.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Agent endpoint paths.
+const (
+	EndpointEnroll  = "/agent/v1/enroll"
+	EndpointConnect = "/agent/v1/connect"
+)
+
+// DefaultTopic is the push topic of agent enrollments. Agents have no
+// push tokens so pushes to this topic fail.
+const DefaultTopic = "nanomdm.agent"
+
+// maxIDLength is the maximum length of an agent ID.
+const maxIDLength = 128
+
+// Secret returns the HTTP basic auth password of agent id derived from
+// key: the hex-encoded HMAC-SHA256 of id.
+func Secret(key []byte, id string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validID checks that id is usable as an enrollment ID.
+func validID(id string) error {
+	if id == "" {
+		return errors.New("empty agent ID")
+	}
+	if len(id) > maxIDLength {
+		return errors.New("agent ID too long")
+	}
+	// a colon separates the user channel of enrollment IDs
+	if strings.ContainsAny(id, ":/,") {
+		return errors.New("invalid character in agent ID")
+	}
+	return nil
+}
+
+type contextKeyID struct{}
+
+// GetID returns the authenticated agent ID from ctx.
+func GetID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKeyID{}).(string)
+	return id
+}
+
+// AuthMiddleware authenticates agents with HTTP basic auth. The
+// username is the agent ID and the password its Secret derived from
+// key. The agent ID is set in the request context (see GetID).
+func AuthMiddleware(next http.Handler, key []byte, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, password, ok := r.BasicAuth()
+		if !ok || validID(id) != nil || subtle.ConstantTimeCompare([]byte(password), []byte(Secret(key, id))) != 1 {
+			ctxlog.Logger(r.Context(), logger).Info("msg", "agent authentication failed", "agent_id", id)
+			w.Header().Set("WWW-Authenticate", `Basic realm="nanomdm-agent"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), contextKeyID{}, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// Enrollment is the agent enrollment message.
+type Enrollment struct {
+	SerialNumber string `json:"serial_number,omitempty"`
+	ProductName  string `json:"product_name,omitempty"`
+	Model        string `json:"model,omitempty"`
+	OSVersion    string `json:"os_version,omitempty"`
+}
+
+// checkinMessages returns the check-in message plists of the agent
+// enrollment of id: Authenticate and TokenUpdate.
+func checkinMessages(id, topic string, e *Enrollment) ([][]byte, error) {
+	authenticate := map[string]interface{}{
+		"MessageType": "Authenticate",
+		"UDID":        id,
+		"Topic":       topic,
+	}
+	for k, v := range map[string]string{
+		"SerialNumber": e.SerialNumber,
+		"ProductName":  e.ProductName,
+		"Model":        e.Model,
+		"OSVersion":    e.OSVersion,
+	} {
+		if v != "" {
+			authenticate[k] = v
+		}
+	}
+	// the push token is derived from the ID as it needs to be unique
+	token := sha256.Sum256([]byte("agent:" + id))
+	tokenUpdate := map[string]interface{}{
+		"MessageType": "TokenUpdate",
+		"UDID":        id,
+		"Topic":       topic,
+		"Token":       token[:],
+		"PushMagic":   "agent",
+	}
+	var msgs [][]byte
+	for _, msg := range []map[string]interface{}{authenticate, tokenUpdate} {
+		b, err := plist.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, b)
+	}
+	return msgs, nil
+}
+
+// plistValue converts the JSON value v (decoded with UseNumber) to a
+// value that encodes as a plist. JSON nulls are dropped.
+func plistValue(v interface{}) (interface{}, bool) {
+	switch v := v.(type) {
+	case nil:
+		return nil, false
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		return f, err == nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			if e, ok := plistValue(e); ok {
+				m[k] = e
+			}
+		}
+		return m, true
+	case []interface{}:
+		a := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			if e, ok := plistValue(e); ok {
+				a = append(a, e)
+			}
+		}
+		return a, true
+	}
+	return v, true
+}
+
+// resultsPlist converts the JSON command report b of agent id to a
+// command report plist. The report uses the same keys as MDM command
+// reports (e.g. "Status", "CommandUUID", and result keys).
+func resultsPlist(id string, b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var report map[string]interface{}
+	if err := dec.Decode(&report); err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, errors.New("empty report")
+	}
+	if _, ok := report["Status"].(string); !ok {
+		return nil, errors.New("missing Status")
+	}
+	v, _ := plistValue(report)
+	report = v.(map[string]interface{})
+	// the report is always of the authenticated agent
+	report["UDID"] = id
+	delete(report, "UserID")
+	delete(report, "EnrollmentID")
+	return plist.Marshal(report)
+}
+
+// commandJSON converts the raw command plist b to JSON.
+func commandJSON(b []byte) ([]byte, error) {
+	var command map[string]interface{}
+	if err := plist.Unmarshal(b, &command); err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(command, "", "\t")
+}
+
+// mdmRequest creates an MDM request of agent r.
+func mdmRequest(r *http.Request) *mdm.Request {
+	return &mdm.Request{
+		Context: r.Context(),
+		Params:  map[string]string{"agent": "1"},
+	}
+}
+
+// writeError logs err and writes its HTTP status.
+func writeError(w http.ResponseWriter, err error, logger log.Logger, msg string) {
+	status := http.StatusInternalServerError
+	var statusErr *service.HTTPStatusError
+	if errors.As(err, &statusErr) {
+		status = statusErr.Status
+		for k, v := range statusErr.Header {
+			w.Header()[k] = v
+		}
+	}
+	logger.Info("msg", msg, "http_status", status, "err", err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// EnrollHandler enrolls (POST) and unenrolls (DELETE) the authenticated
+// agent with svc. Enrolling maps the JSON Enrollment in the request body
+// to Authenticate and TokenUpdate check-in messages. Unenrolling is a
+// CheckOut check-in message.
+func EnrollHandler(svc service.Checkin, topic string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := GetID(r.Context())
+		logger := ctxlog.Logger(r.Context(), logger).With("agent_id", id)
+		var msgs [][]byte
+		switch r.Method {
+		case http.MethodPost:
+			e := new(Enrollment)
+			if err := json.NewDecoder(r.Body).Decode(e); err != nil && !errors.Is(err, io.EOF) {
+				logger.Info("msg", "decoding enrollment", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var err error
+			if msgs, err = checkinMessages(id, topic, e); err != nil {
+				writeError(w, err, logger, "enrollment messages")
+				return
+			}
+		case http.MethodDelete:
+			b, err := plist.Marshal(map[string]interface{}{"MessageType": "CheckOut", "UDID": id})
+			if err != nil {
+				writeError(w, err, logger, "checkout message")
+				return
+			}
+			msgs = [][]byte{b}
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		for _, msg := range msgs {
+			if _, err := service.CheckinRequest(svc, mdmRequest(r), msg); err != nil {
+				writeError(w, err, logger, "check-in request")
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ConnectHandler reports the JSON command report in the request body of
+// the authenticated agent to svc and responds with the next command as
+// JSON. With no next command the response is HTTP 204 (No Content).
+func ConnectHandler(svc service.CommandAndReportResults, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := GetID(r.Context())
+		logger := ctxlog.Logger(r.Context(), logger).With("agent_id", id)
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if b, err = resultsPlist(id, b); err != nil {
+			logger.Info("msg", "decoding report", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		respBytes, err := service.CommandAndReportResultsRequest(svc, mdmRequest(r), b)
+		if err != nil {
+			writeError(w, err, logger, "command report results")
+			return
+		}
+		if len(respBytes) < 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if respBytes, err = commandJSON(respBytes); err != nil {
+			writeError(w, fmt.Errorf("converting command: %w", err), logger, "command report results")
+			return
+		}
+		w.Header().Set("Content-type", "application/json")
+		if _, err = w.Write(respBytes); err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// Handlers are the agent endpoints.
+type Handlers struct {
+	Service service.CheckinAndCommandService
+
+	// Key derives the agent secrets (see Secret).
+	Key []byte
+
+	// Topic overrides the DefaultTopic of agent enrollments.
+	Topic string
+
+	Logger log.Logger
+}
+
+// Register registers the agent endpoints on mux. Endpoint paths are
+// prefixed with prefix (which should not have a trailing slash) and
+// every handler is wrapped with agent authentication and middleware.
+func (h *Handlers) Register(mux mdmhttp.Mux, prefix string, middleware ...mdmhttp.Middleware) {
+	logger := h.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	topic := h.Topic
+	if topic == "" {
+		topic = DefaultTopic
+	}
+	handle := func(endpoint string, handler http.Handler) {
+		handler = AuthMiddleware(handler, h.Key, logger.With("handler", "agent-auth"))
+		mux.Handle(prefix+endpoint, mdmhttp.Chain(handler, middleware...))
+	}
+	handle(EndpointEnroll, EnrollHandler(h.Service, topic, logger.With("handler", "agent-enroll")))
+	handle(EndpointConnect, ConnectHandler(h.Service, logger.With("handler", "agent-connect")))
+}
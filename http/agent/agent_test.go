@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service/nanomdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+const agentCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>RunScript</string>
+		<key>Script</key>
+		<string>hostname</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>script-1</string>
+</dict>
+</plist>`
+
+func TestAgent(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("agent-key")
+	mux := http.NewServeMux()
+	(&Handlers{Service: nanomdm.New(store), Key: key}).Register(mux, "")
+
+	do := func(method, path, id, secret, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.SetBasicAuth(id, secret)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodPost, EndpointEnroll, "WIN-1", "wrong", `{}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("enroll with wrong secret: have %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	secret := Secret(key, "WIN-1")
+	if w := do(http.MethodPost, EndpointEnroll, "WIN-1", secret, `{"serial_number":"SN1","product_name":"Windows"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("enroll: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	enabled := true
+	enrollments, err := store.RetrieveEnrollments(context.Background(), &storage.EnrollmentFilter{Enabled: &enabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].ID != "WIN-1" || enrollments[0].Topic != DefaultTopic {
+		t.Fatalf("unexpected enrollments: %+v", enrollments)
+	}
+
+	if w := do(http.MethodPost, EndpointConnect, "WIN-1", secret, `{"Status":"Idle"}`); w.Code != http.StatusNoContent {
+		t.Errorf("connect with empty queue: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	cmd, err := mdm.DecodeCommand([]byte(agentCommand))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.EnqueueCommand(context.Background(), []string{"WIN-1"}, cmd); err != nil {
+		t.Fatal(err)
+	}
+	w := do(http.MethodPost, EndpointConnect, "WIN-1", secret, `{"Status":"Idle"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("connect: have %d, want %d", w.Code, http.StatusOK)
+	}
+	var command struct {
+		CommandUUID string
+		Command     map[string]interface{}
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &command); err != nil {
+		t.Fatal(err)
+	}
+	if command.CommandUUID != "script-1" || command.Command["Script"] != "hostname" {
+		t.Errorf("unexpected command: %s", w.Body.String())
+	}
+	report := `{"CommandUUID":"script-1","Status":"Acknowledged","ExitCode":0,"Output":"win-1","Extra":null}`
+	if w = do(http.MethodPost, EndpointConnect, "WIN-1", secret, report); w.Code != http.StatusNoContent {
+		t.Errorf("report: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w = do(http.MethodPost, EndpointConnect, "WIN-1", secret, `{"CommandUUID":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("report without status: have %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if w = do(http.MethodDelete, EndpointEnroll, "WIN-1", secret, ""); w.Code != http.StatusNoContent {
+		t.Errorf("unenroll: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if enrollments, err = store.RetrieveEnrollments(context.Background(), &storage.EnrollmentFilter{Enabled: &enabled}); err != nil {
+		t.Fatal(err)
+	} else if len(enrollments) != 0 {
+		t.Errorf("expected no enabled enrollments, have %d", len(enrollments))
+	}
+}
+
+func TestResultsPlist(t *testing.T) {
+	b, err := resultsPlist("WIN-1", []byte(`{"Status":"Acknowledged","CommandUUID":"c1","UDID":"OTHER","Count":3,"Ratio":0.5}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := mdm.DecodeCommandResults(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results.UDID != "WIN-1" || results.CommandUUID != "c1" || results.Status != "Acknowledged" {
+		t.Errorf("unexpected results: %+v", results)
+	}
+	if !strings.Contains(string(b), "<integer>3</integer>") || !strings.Contains(string(b), "<real>0.5</real>") {
+		t.Errorf("unexpected plist: %s", b)
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// resolveIDs resolves aliases to enrollment IDs using resolver.
+// Aliases that do not resolve are returned as-is.
+func resolveIDs(ctx context.Context, resolver storage.EnrollmentAliasResolver, aliases []string) ([]string, error) {
+	resolved, err := resolver.ResolveEnrollmentIDs(ctx, aliases)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, len(aliases))
+	for i, alias := range aliases {
+		if id, ok := resolved[alias]; ok {
+			ids[i] = id
+		} else {
+			ids[i] = alias
+		}
+	}
+	return ids, nil
+}
+
+// ResolveHandler resolves serial numbers, UDIDs, EnrollmentIDs, or
+// enrollment IDs to enrollment IDs. It returns a JSON map of each alias
+// to its enrollment ID. Aliases that do not resolve map to an empty
+// string.
+//
+// Note the whole URL path is used as the alias(es) to resolve. This
+// probably necessitates stripping the URL prefix before using.
+func ResolveHandler(store storage.EnrollmentAliasResolver, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		aliases := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), aliases, logger)
+		resolved, err := store.ResolveEnrollmentIDs(ctx, aliases)
+		if err != nil {
+			logger.Info("msg", "resolving enrollment ids", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		output := make(map[string]string)
+		for _, alias := range aliases {
+			output[alias] = resolved[alias]
+		}
+		logger.Debug("msg", "resolved enrollment ids", "count", len(resolved))
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
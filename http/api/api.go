@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
@@ -10,6 +11,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -41,6 +43,7 @@ type apiResult struct {
 	CommandError string             `json:"command_error,omitempty"`
 	CommandUUID  string             `json:"command_uuid,omitempty"`
 	RequestType  string             `json:"request_type,omitempty"`
+	JobID        string             `json:"job_id,omitempty"`
 }
 
 type (
@@ -73,18 +76,63 @@ func setupCtxLog(ctx context.Context, ids []string, logger log.Logger) (context.
 	return ctx, ctxlog.Logger(ctx, logger)
 }
 
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// storeJob creates a new job for ids in jobs (if not nil) using the
+// "job" query parameter as the job name. The job ID is returned or an
+// empty string if the job was not created.
+func storeJob(ctx context.Context, jobs storage.JobStore, r *http.Request, ids []string, cmd *mdm.Command, logger log.Logger) string {
+	if jobs == nil {
+		return ""
+	}
+	job := &storage.Job{
+		ID:   newJobID(),
+		Name: r.URL.Query().Get("job"),
+	}
+	if cmd != nil {
+		job.CommandUUID = cmd.CommandUUID
+		job.RequestType = cmd.Command.RequestType
+	}
+	if err := jobs.StoreJob(ctx, job, ids); err != nil {
+		logger.Info("msg", "storing job", "err", err)
+		return ""
+	}
+	return job.ID
+}
+
+// updateJobTargets sets the status of ids in job jobID, if any.
+func updateJobTargets(ctx context.Context, jobs storage.JobStore, jobID, status string, ids []string, logger log.Logger) {
+	if jobs == nil || jobID == "" || len(ids) < 1 {
+		return
+	}
+	if err := jobs.UpdateJobTargets(ctx, jobID, status, ids); err != nil {
+		logger.Info("msg", "updating job targets", "job_id", jobID, "status", status, "err", err)
+	}
+}
+
 // PushHandler sends APNs push notifications to MDM enrollments.
 //
 // Note the whole URL path is used as the identifier to push to. This
 // probably necessitates stripping the URL prefix before using. Also
 // note we expose Go errors to the output as this is meant for "API"
-// users.
-func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+// users. If jobs is not nil then the push is tracked as a job. Only PUT
+// and POST requests are accepted.
+func PushHandler(pusher push.Pusher, jobs storage.JobStore, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
 		ids := strings.Split(r.URL.Path, ",")
 		ctx, logger := setupCtxLog(r.Context(), ids, logger)
 		output := apiResult{
 			Status: make(enrolledAPIResults),
+			JobID:  storeJob(ctx, jobs, r, ids, nil, logger),
 		}
 		logs := []interface{}{"msg", "push"}
 		pushResp, err := pusher.Push(ctx, ids)
@@ -93,6 +141,7 @@ func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 			output.PushError = err.Error()
 		}
 		var ct, errCt int
+		var delivered, errored []string
 		for id, resp := range pushResp {
 			output.Status[id] = &enrolledAPIResult{
 				PushResult: resp.Id,
@@ -100,10 +149,17 @@ func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 			if resp.Err != nil {
 				output.Status[id].PushError = resp.Err.Error()
 				errCt += 1
+				errored = append(errored, id)
 			} else {
 				ct += 1
+				delivered = append(delivered, id)
 			}
 		}
+		if err != nil && len(pushResp) == 0 {
+			errored = ids
+		}
+		updateJobTargets(ctx, jobs, output.JobID, storage.JobStatusDelivered, delivered, logger)
+		updateJobTargets(ctx, jobs, output.JobID, storage.JobStatusErrored, errored, logger)
 		logs = append(logs, "count", ct)
 		if errCt > 0 {
 			logs = append(logs, "errs", errCt)
@@ -133,17 +189,68 @@ func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
 	}
 }
 
+// parsePriority parses a command priority level name or integer.
+func parsePriority(s string) (int, error) {
+	switch s {
+	case "low":
+		return mdm.PriorityLow, nil
+	case "normal":
+		return mdm.PriorityNormal, nil
+	case "high":
+		return mdm.PriorityHigh, nil
+	case "urgent":
+		return mdm.PriorityUrgent, nil
+	}
+	priority, err := strconv.Atoi(s)
+	if err != nil || priority < mdm.MinPriority || priority > mdm.MaxPriority {
+		return 0, fmt.Errorf("invalid priority: %s", s)
+	}
+	return priority, nil
+}
+
 // RawCommandEnqueueHandler enqueues a raw MDM command plist and sends
 // push notifications to MDM enrollments.
 //
 // Note the whole URL path is used as the identifier to enqueue (and
 // push to. This probably necessitates stripping the URL prefix before
 // using. Also note we expose Go errors to the output as this is meant
-// for "API" users.
-func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+// for "API" users. If jobs is not nil then the enqueued command is
+// tracked as a job. If targets is not nil then friendly channel targets
+// like "DEVICE:*" (all user channels) and "DEVICE:user=<Managed Apple
+// ID>" are expanded to their enrollment IDs. Bulk enqueue requests (see
+// BulkEnqueueMiddleware) are enqueued in batches with a result for
+// every enrollment. The "expires" query parameter is an RFC 3339 time
+// after which the command is no longer sent to enrollments, if the
+// storage backend supports command expiration. The "not_before" query
+// parameter is an RFC 3339 time before which the command is not sent
+// to enrollments, if the storage backend supports command scheduling.
+// Commands scheduled in the future are not pushed when enqueued: see
+// the schedule package for pushing them when they are due. The
+// "priority" query parameter is an integer or one of "low", "normal",
+// "high", or "urgent" and orders the command ahead of lower priority
+// commands, if the storage backend supports command priority. Only PUT
+// and POST requests are accepted.
+func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Pusher, jobs storage.JobStore, targets storage.EnrollmentRetriever, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
 		ids := strings.Split(r.URL.Path, ",")
 		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		if targets != nil {
+			var err error
+			if ids, err = expandTargets(ctx, targets, ids); errors.Is(err, errInvalidTarget) {
+				logger.Info("msg", "expanding targets", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				logger.Info("msg", "expanding targets", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
 		b, err := mdmhttp.ReadAllAndReplaceBody(r)
 		if err != nil {
 			logger.Info("msg", "reading body", "err", err)
@@ -156,7 +263,65 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
 		}
+		if expires := r.URL.Query().Get("expires"); expires != "" {
+			if !storage.DiscoverCapabilities(enqueuer)[storage.CapabilityCommandExpiration] {
+				http.Error(w, "command expiration not supported by storage", http.StatusBadRequest)
+				return
+			}
+			command.ExpiresAt, err = time.Parse(time.RFC3339, expires)
+			if err != nil {
+				logger.Info("msg", "parsing expires", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if !command.ExpiresAt.After(time.Now()) {
+				http.Error(w, "command expires in the past", http.StatusBadRequest)
+				return
+			}
+		}
 		nopush := r.URL.Query().Get("nopush") != ""
+		if notBefore := r.URL.Query().Get("not_before"); notBefore != "" {
+			if !storage.DiscoverCapabilities(enqueuer)[storage.CapabilityCommandScheduling] {
+				http.Error(w, "command scheduling not supported by storage", http.StatusBadRequest)
+				return
+			}
+			command.NotBefore, err = time.Parse(time.RFC3339, notBefore)
+			if err != nil {
+				logger.Info("msg", "parsing not_before", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if !command.ExpiresAt.IsZero() && !command.ExpiresAt.After(command.NotBefore) {
+				http.Error(w, "command expires before it is scheduled", http.StatusBadRequest)
+				return
+			}
+			// the command is pushed when it is due
+			nopush = nopush || !command.Due(time.Now())
+		}
+		if priority := r.URL.Query().Get("priority"); priority != "" {
+			if !storage.DiscoverCapabilities(enqueuer)[storage.CapabilityCommandPriority] {
+				http.Error(w, "command priority not supported by storage", http.StatusBadRequest)
+				return
+			}
+			if command.Priority, err = parsePriority(priority); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if isBulk(r.Context()) {
+			bulkOutput := &bulkEnqueueOutput{
+				CommandUUID: command.CommandUUID,
+				RequestType: command.Command.RequestType,
+				NoPush:      nopush,
+			}
+			logger = logger.With(
+				"command_uuid", command.CommandUUID,
+				"request_type", command.Command.RequestType,
+			)
+			bulkOutput.JobID = storeJob(ctx, jobs, r, ids, command, logger)
+			bulkEnqueue(ctx, w, enqueuer, pusher, jobs, bulkOutput, ids, command, logger)
+			return
+		}
 		output := apiResult{
 			Status:      make(enrolledAPIResults),
 			NoPush:      nopush,
@@ -170,8 +335,15 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 		logs := []interface{}{
 			"msg", "enqueue",
 		}
+		// create the job before enqueueing so that deliveries to
+		// enrollments that check-in right away are tracked
+		output.JobID = storeJob(ctx, jobs, r, ids, command, logger)
 		idErrs, err := enqueuer.EnqueueCommand(ctx, ids, command)
 		ct := len(ids) - len(idErrs)
+		var errored []string
+		for id := range idErrs {
+			errored = append(errored, id)
+		}
 		if err != nil {
 			logs = append(logs, "err", err)
 			output.CommandError = err.Error()
@@ -179,8 +351,10 @@ func RawCommandEnqueueHandler(enqueuer storage.CommandEnqueuer, pusher push.Push
 				// we assume if there were no ID-specific errors but
 				// there was a general error then all IDs failed
 				ct = 0
+				errored = ids
 			}
 		}
+		updateJobTargets(ctx, jobs, output.JobID, storage.JobStatusErrored, errored, logger)
 		logs = append(logs, "count", ct)
 		if len(idErrs) > 0 {
 			logs = append(logs, "errs", len(idErrs))
@@ -294,9 +468,14 @@ func readPEMCertAndKey(input []byte) (cert []byte, key []byte, err error) {
 // key from the HTTP body and saves it to storage. This effectively
 // enables us to do something like:
 // "% cat push.pem push.key | curl -T - http://api.example.com/" to
-// upload our push certs.
+// upload our push certs. Only PUT and POST requests are accepted.
 func StorePushCertHandler(storage storage.PushCertStore, logger log.Logger) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
 		logger := ctxlog.Logger(r.Context(), logger)
 		b, err := mdmhttp.ReadAllAndReplaceBody(r)
 		if err != nil {
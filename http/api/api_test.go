@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// expiringStorage is a mock storage that supports command expiration.
+type expiringStorage struct {
+	*mock.Storage
+}
+
+func (s expiringStorage) Capabilities() storage.Capabilities {
+	return storage.Capabilities{storage.CapabilityCommandExpiration: true}
+}
+
+// schedulingStorage is a mock storage that supports command
+// expiration, scheduling, and priority.
+type schedulingStorage struct {
+	*mock.Storage
+}
+
+func (s schedulingStorage) Capabilities() storage.Capabilities {
+	return storage.Capabilities{
+		storage.CapabilityCommandExpiration: true,
+		storage.CapabilityCommandScheduling: true,
+		storage.CapabilityCommandPriority:   true,
+	}
+}
+
+func TestEnqueueExpires(t *testing.T) {
+	var expiresAt time.Time
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+		expiresAt = cmd.ExpiresAt
+		return nil, nil
+	}
+	pusher := pushFunc(func(context.Context, []string) (map[string]*push.Response, error) {
+		return nil, nil
+	})
+	enqueue := func(enqueuer storage.CommandEnqueuer, expires string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/DEV1?nopush=1&expires="+expires, strings.NewReader(bulkCommand))
+		RawCommandEnqueueHandler(enqueuer, pusher, nil, nil, log.NopLogger).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if have, want := enqueue(store, future.Format(time.RFC3339)), http.StatusBadRequest; have != want {
+		t.Errorf("unsupported storage: have %d, want %d", have, want)
+	}
+	for _, expires := range []string{"tomorrow", time.Now().Add(-time.Hour).Format(time.RFC3339)} {
+		if have, want := enqueue(expiringStorage{store}, expires), http.StatusBadRequest; have != want {
+			t.Errorf("%s: have %d, want %d", expires, have, want)
+		}
+	}
+	if have, want := len(store.Calls("EnqueueCommand")), 0; have != want {
+		t.Fatalf("enqueues: have %d, want %d", have, want)
+	}
+	if have, want := enqueue(expiringStorage{store}, future.Format(time.RFC3339)), http.StatusOK; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if !expiresAt.Equal(future) {
+		t.Errorf("expires at: have %v, want %v", expiresAt, future)
+	}
+}
+
+func TestEnqueueNotBefore(t *testing.T) {
+	var notBefore time.Time
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+		notBefore = cmd.NotBefore
+		return nil, nil
+	}
+	var pushes int
+	pusher := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		pushes++
+		resps := make(map[string]*push.Response)
+		for _, id := range ids {
+			resps[id] = &push.Response{Id: "push-" + id}
+		}
+		return resps, nil
+	})
+	enqueue := func(enqueuer storage.CommandEnqueuer, query string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/DEV1?"+query, strings.NewReader(bulkCommand))
+		RawCommandEnqueueHandler(enqueuer, pusher, nil, nil, log.NopLogger).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if have, want := enqueue(store, "not_before="+future.Format(time.RFC3339)), http.StatusBadRequest; have != want {
+		t.Errorf("unsupported storage: have %d, want %d", have, want)
+	}
+	for _, query := range []string{
+		"not_before=tomorrow",
+		"not_before=" + future.Format(time.RFC3339) + "&expires=" + future.Add(-time.Minute).Format(time.RFC3339),
+	} {
+		if have, want := enqueue(schedulingStorage{store}, query), http.StatusBadRequest; have != want {
+			t.Errorf("%s: have %d, want %d", query, have, want)
+		}
+	}
+	if have, want := len(store.Calls("EnqueueCommand")), 0; have != want {
+		t.Fatalf("enqueues: have %d, want %d", have, want)
+	}
+
+	// scheduled in the future: not pushed
+	if have, want := enqueue(schedulingStorage{store}, "not_before="+future.Format(time.RFC3339)), http.StatusOK; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if !notBefore.Equal(future) {
+		t.Errorf("not before: have %v, want %v", notBefore, future)
+	}
+	if have, want := pushes, 0; have != want {
+		t.Errorf("pushes: have %d, want %d", have, want)
+	}
+
+	// scheduled in the past: pushed
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if have, want := enqueue(schedulingStorage{store}, "not_before="+past), http.StatusOK; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+	if have, want := pushes, 1; have != want {
+		t.Errorf("pushes: have %d, want %d", have, want)
+	}
+}
+
+func TestEnqueuePriority(t *testing.T) {
+	var priority int
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+		priority = cmd.Priority
+		return nil, nil
+	}
+	enqueue := func(enqueuer storage.CommandEnqueuer, query string) int {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("PUT", "/DEV1?nopush=1&"+query, strings.NewReader(bulkCommand))
+		RawCommandEnqueueHandler(enqueuer, nil, nil, nil, log.NopLogger).ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if have, want := enqueue(store, "priority=high"), http.StatusBadRequest; have != want {
+		t.Errorf("unsupported storage: have %d, want %d", have, want)
+	}
+	for _, query := range []string{"priority=highest", "priority=128"} {
+		if have, want := enqueue(schedulingStorage{store}, query), http.StatusBadRequest; have != want {
+			t.Errorf("%s: have %d, want %d", query, have, want)
+		}
+	}
+	for query, want := range map[string]int{
+		"priority=urgent": mdm.PriorityUrgent,
+		"priority=-5":     -5,
+	} {
+		if have, want := enqueue(schedulingStorage{store}, query), http.StatusOK; have != want {
+			t.Errorf("%s: have %d, want %d", query, have, want)
+		}
+		if have := priority; have != want {
+			t.Errorf("%s: priority: have %d, want %d", query, have, want)
+		}
+	}
+}
+
+func TestWriteMethods(t *testing.T) {
+	store := new(mock.Storage)
+	var pushes int
+	pusher := pushFunc(func(context.Context, []string) (map[string]*push.Response, error) {
+		pushes++
+		return nil, nil
+	})
+	for name, h := range map[string]http.Handler{
+		"enqueue":  RawCommandEnqueueHandler(store, pusher, nil, nil, log.NopLogger),
+		"push":     PushHandler(pusher, nil, log.NopLogger),
+		"pushcert": StorePushCertHandler(store, log.NopLogger),
+	} {
+		for _, method := range []string{"GET", "HEAD", "DELETE"} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(method, "/DEV1", strings.NewReader(bulkCommand)))
+			if have, want := rec.Code, http.StatusMethodNotAllowed; have != want {
+				t.Errorf("%s %s: have %d, want %d", name, method, have, want)
+			}
+		}
+	}
+	if have, want := len(store.Calls("EnqueueCommand"))+len(store.Calls("StorePushCert")), 0; have != want {
+		t.Errorf("store calls: have %d, want %d", have, want)
+	}
+	if have, want := pushes, 0; have != want {
+		t.Errorf("pushes: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/http/rbac"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// apiKeyResult is an API key without its secret hash. The secret is
+// only returned when the key is created.
+type apiKeyResult struct {
+	*storage.APIKey
+	SecretHash string `json:"secret_hash,omitempty"`
+	Secret     string `json:"secret,omitempty"`
+}
+
+func writeAPIKeysJSON(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// APIKeysHandler manages API keys. The URL path is the API key name
+// which probably necessitates stripping the URL prefix before using.
+// A GET without a name lists the API keys. A PUT of a JSON object with
+// the "role" (and "tenant" for tenant-scoped roles) creates or replaces
+// the API key with a new secret which is returned only once.
+func APIKeysHandler(store storage.APIKeyStore, authorizer *rbac.Authorizer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if name != "" {
+			logger = logger.With("name", name)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if name == "" {
+				keys, err := store.RetrieveAPIKeys(ctx)
+				if err != nil {
+					logger.Info("msg", "retrieving API keys", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				output := make([]*apiKeyResult, len(keys))
+				for i, key := range keys {
+					output[i] = &apiKeyResult{APIKey: key}
+				}
+				writeAPIKeysJSON(w, output, logger)
+				return
+			}
+			key, err := store.RetrieveAPIKey(ctx, name)
+			if err != nil {
+				logger.Info("msg", "retrieving API key", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if key == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			writeAPIKeysJSON(w, &apiKeyResult{APIKey: key}, logger)
+		case http.MethodPut:
+			if name == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			key := new(storage.APIKey)
+			if err = json.Unmarshal(b, key); err != nil {
+				logger.Info("msg", "decoding API key", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			role := authorizer.Role(key.Role)
+			if role == nil || (role.Tenant && key.Tenant == "") {
+				logger.Info("msg", "invalid role or tenant", "role", key.Role)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			secret, err := rbac.NewSecret()
+			if err != nil {
+				logger.Info("msg", "generating secret", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			key.Name = name
+			key.SecretHash = rbac.HashSecret(secret)
+			key.CreatedAt = time.Now().UTC().Truncate(time.Second)
+			if err = store.StoreAPIKey(ctx, key); err != nil {
+				logger.Info("msg", "storing API key", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			logger.Info("msg", "stored API key", "role", key.Role)
+			writeAPIKeysJSON(w, &apiKeyResult{APIKey: key, Secret: secret}, logger)
+		case http.MethodDelete:
+			if name == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if err := store.DeleteAPIKey(ctx, name); err != nil {
+				logger.Info("msg", "deleting API key", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			logger.Info("msg", "deleted API key")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// RolesHandler returns the role definitions as JSON.
+func RolesHandler(authorizer *rbac.Authorizer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, logger := setupCtxLog(r.Context(), nil, logger)
+		output := make(map[string]*rbac.Role)
+		for _, name := range authorizer.Roles() {
+			output[name] = authorizer.Role(name)
+		}
+		writeAPIKeysJSON(w, output, logger)
+	}
+}
@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// bulkBatchSize is the number of enrollments a bulk enqueue enqueues
+// and pushes at a time. A failed batch does not fail the others.
+const bulkBatchSize = 1000
+
+// Push statuses of bulk enqueue results.
+const (
+	bulkPushSent    = "sent"
+	bulkPushFailed  = "failed"
+	bulkPushSkipped = "skipped"
+)
+
+// bulkEnqueueRequest is the JSON body of a bulk enqueue request.
+type bulkEnqueueRequest struct {
+	IDs []string `json:"ids"`
+	// Command is the raw command plist.
+	Command string `json:"command"`
+}
+
+// bulkEnqueueResult is the result of a bulk enqueue for an enrollment.
+type bulkEnqueueResult struct {
+	ID       string `json:"id"`
+	Enqueued bool   `json:"enqueued"`
+	Error    string `json:"error,omitempty"`
+
+	// Push is one of "sent", "failed", or "skipped" (with nopush or if
+	// the command was not enqueued).
+	Push      string `json:"push"`
+	PushID    string `json:"push_id,omitempty"`
+	PushError string `json:"push_error,omitempty"`
+}
+
+// bulkEnqueueOutput is the JSON reply of a bulk enqueue.
+type bulkEnqueueOutput struct {
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type"`
+	JobID       string `json:"job_id,omitempty"`
+	NoPush      bool   `json:"no_push,omitempty"`
+
+	Enqueued   int `json:"enqueued"`
+	Failed     int `json:"failed"`
+	Pushed     int `json:"pushed"`
+	PushFailed int `json:"push_failed"`
+
+	Results []*bulkEnqueueResult `json:"results"`
+}
+
+type ctxKeyBulk struct{}
+
+func isBulk(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKeyBulk{}).(bool)
+	return v
+}
+
+// BulkEnqueueMiddleware turns bulk enqueue requests into enqueue
+// requests for next. Bulk enqueue requests are HTTP PUT or POST
+// requests to path (typically the enqueue endpoint) without enrollment
+// IDs and with a JSON body of the "ids" to target and the "command"
+// plist. The request passed to next has the IDs in its URL path and
+// the command as its body as if it were an ordinary enqueue request
+// so that middleware (e.g. authorization and policies) sees the same
+// targets and command. The enqueue handler then replies with a result
+// for every enrollment.
+func BulkEnqueueMiddleware(next http.Handler, path string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if (r.Method != http.MethodPut && r.Method != http.MethodPost) || r.URL.Path != path || mediaType != "application/json" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), logger)
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		req := new(bulkEnqueueRequest)
+		if err = json.Unmarshal(b, req); err != nil {
+			logger.Info("msg", "decoding bulk enqueue", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		var ids []string
+		seen := make(map[string]bool)
+		for _, id := range req.IDs {
+			if id = strings.TrimSpace(id); id != "" && !seen[id] {
+				ids = append(ids, id)
+				seen[id] = true
+			}
+		}
+		if len(ids) < 1 || req.Command == "" {
+			http.Error(w, "no ids or command", http.StatusBadRequest)
+			return
+		}
+		r = r.Clone(context.WithValue(r.Context(), ctxKeyBulk{}, true))
+		r.URL.Path = path + strings.Join(ids, ",")
+		r.URL.RawPath = ""
+		r.Header.Set("Content-Type", "application/xml")
+		r.Body = io.NopCloser(strings.NewReader(req.Command))
+		r.ContentLength = int64(len(req.Command))
+		next.ServeHTTP(w, r)
+	}
+}
+
+// bulkEnqueue enqueues command to ids in batches and pushes the
+// enrollments it was enqueued to (unless nopush) replying with the
+// result for every enrollment.
+func bulkEnqueue(ctx context.Context, w http.ResponseWriter, enqueuer storage.CommandEnqueuer, pusher push.Pusher, jobs storage.JobStore, output *bulkEnqueueOutput, ids []string, command *mdm.Command, logger log.Logger) {
+	results := make(map[string]*bulkEnqueueResult, len(ids))
+	output.Results = make([]*bulkEnqueueResult, len(ids))
+	for i, id := range ids {
+		results[id] = &bulkEnqueueResult{ID: id, Push: bulkPushSkipped}
+		output.Results[i] = results[id]
+	}
+	for start := 0; start < len(ids); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+		idErrs, err := enqueuer.EnqueueCommand(ctx, batch, command)
+		if err != nil {
+			logger.Info("msg", "bulk enqueue", "batch", start/bulkBatchSize, "err", err)
+		}
+		var enqueued, errored []string
+		for _, id := range batch {
+			idErr := idErrs[id]
+			if idErr == nil && err != nil && len(idErrs) == 0 {
+				// a general error without ID-specific errors fails
+				// the whole batch
+				idErr = err
+			}
+			if idErr != nil {
+				results[id].Error = idErr.Error()
+				errored = append(errored, id)
+				continue
+			}
+			results[id].Enqueued = true
+			enqueued = append(enqueued, id)
+		}
+		output.Enqueued += len(enqueued)
+		output.Failed += len(errored)
+		updateJobTargets(ctx, jobs, output.JobID, storage.JobStatusErrored, errored, logger)
+		if output.NoPush || len(enqueued) < 1 {
+			continue
+		}
+		pushResp, err := pusher.Push(ctx, enqueued)
+		if err != nil {
+			logger.Info("msg", "bulk push", "batch", start/bulkBatchSize, "err", err)
+		}
+		for _, id := range enqueued {
+			result := results[id]
+			resp := pushResp[id]
+			switch {
+			case resp != nil && resp.Err == nil:
+				result.Push, result.PushID = bulkPushSent, resp.Id
+				output.Pushed++
+				continue
+			case resp != nil:
+				result.PushID, result.PushError = resp.Id, resp.Err.Error()
+			case err != nil:
+				result.PushError = err.Error()
+			default:
+				result.PushError = "no push response"
+			}
+			result.Push = bulkPushFailed
+			output.PushFailed++
+		}
+	}
+	logs := []interface{}{
+		"msg", "bulk enqueue",
+		"count", output.Enqueued,
+		"pushed", output.Pushed,
+	}
+	if output.Failed > 0 || output.PushFailed > 0 {
+		logs = append(logs, "errs", output.Failed, "push_errs", output.PushFailed)
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+	header := http.StatusOK
+	if output.Enqueued < 1 {
+		header = http.StatusInternalServerError
+	} else if output.Failed > 0 || output.PushFailed > 0 {
+		header = http.StatusMultiStatus
+	}
+	json, err := json.MarshalIndent(output, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(header)
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
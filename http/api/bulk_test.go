@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const bulkCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>ProfileList</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>CMD1</string>
+</dict>
+</plist>
+`
+
+type pushFunc func(context.Context, []string) (map[string]*push.Response, error)
+
+func (f pushFunc) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	return f(ctx, ids)
+}
+
+func TestBulkEnqueue(t *testing.T) {
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, ids []string, _ *mdm.Command) (map[string]error, error) {
+		idErrs := make(map[string]error)
+		for _, id := range ids {
+			if id == "BAD" {
+				idErrs[id] = errors.New("unknown enrollment")
+			}
+		}
+		return idErrs, nil
+	}
+	pusher := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		resps := make(map[string]*push.Response)
+		for _, id := range ids {
+			resps[id] = &push.Response{Id: "apns-" + id}
+			if id == "NOTOKEN" {
+				resps[id].Err = errors.New("no push token")
+			}
+		}
+		return resps, nil
+	})
+	var paths []string
+	middleware := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			paths = append(paths, r.URL.Path)
+			next.ServeHTTP(w, r)
+		})
+	}
+	h := BulkEnqueueMiddleware(
+		middleware(http.StripPrefix(EndpointEnqueue, RawCommandEnqueueHandler(store, pusher, nil, nil, log.NopLogger))),
+		EndpointEnqueue,
+		log.NopLogger,
+	)
+
+	b, err := json.Marshal(&bulkEnqueueRequest{IDs: []string{"DEV1", "BAD", "NOTOKEN", "DEV1"}, Command: bulkCommand})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPut, EndpointEnqueue, strings.NewReader(string(b)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if have, want := rec.Code, http.StatusMultiStatus; have != want {
+		t.Fatalf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	// middleware sees the targets in the path
+	if have, want := strings.Join(paths, " "), EndpointEnqueue+"DEV1,BAD,NOTOKEN"; have != want {
+		t.Errorf("path: have %q, want %q", have, want)
+	}
+	output := new(bulkEnqueueOutput)
+	if err = json.Unmarshal(rec.Body.Bytes(), output); err != nil {
+		t.Fatal(err)
+	}
+	if output.CommandUUID != "CMD1" || output.Enqueued != 2 || output.Failed != 1 || output.Pushed != 1 || output.PushFailed != 1 {
+		t.Errorf("unexpected output: %+v", output)
+	}
+	if have, want := len(output.Results), 3; have != want {
+		t.Fatalf("results: have %d, want %d", have, want)
+	}
+	for i, want := range []bulkEnqueueResult{
+		{ID: "DEV1", Enqueued: true, Push: bulkPushSent, PushID: "apns-DEV1"},
+		{ID: "BAD", Error: "unknown enrollment", Push: bulkPushSkipped},
+		{ID: "NOTOKEN", Enqueued: true, Push: bulkPushFailed, PushID: "apns-NOTOKEN", PushError: "no push token"},
+	} {
+		if have := *output.Results[i]; have != want {
+			t.Errorf("result %d: have %+v, want %+v", i, have, want)
+		}
+	}
+
+	// ordinary enqueues are unchanged
+	paths = nil
+	req = httptest.NewRequest(http.MethodPut, EndpointEnqueue+"DEV1", strings.NewReader(bulkCommand))
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Errorf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "results") {
+		t.Errorf("unexpected bulk reply: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/push/campaign"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// CampaignHandler starts (HTTP POST), retrieves (HTTP GET), and
+// cancels (HTTP DELETE) campaigns of MDM APNs pushes and optional
+// command enqueueing that are sent in waves.
+//
+// Note the whole URL path is used as the campaign ID. An empty path
+// with HTTP POST starts a new campaign from the JSON campaign spec in
+// the body. An empty path with HTTP GET lists all campaigns. store may
+// be nil if enrollment metadata is not supported; campaigns targeting a
+// group are then rejected.
+func CampaignHandler(mgr *campaign.Manager, store campaign.GroupStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		id := r.URL.Path
+		var output interface{}
+		var err error
+		switch {
+		case r.Method == http.MethodPost && id == "":
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			spec := new(campaign.Spec)
+			if err = json.Unmarshal(b, spec); err != nil {
+				logger.Info("msg", "decoding campaign spec", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			ids, err := campaign.ResolveIDs(r.Context(), store, spec)
+			if errors.Is(err, campaign.ErrNoGroups) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			} else if err != nil {
+				logger.Info("msg", "resolving campaign ids", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			status, err := mgr.Start(ids, spec)
+			if err != nil {
+				logger.Info("msg", "starting campaign", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			logger.Debug("msg", "started campaign", "campaign_id", status.ID, "count", len(ids))
+			output = status
+		case r.Method == http.MethodGet && id == "":
+			output = mgr.List()
+		case r.Method == http.MethodGet:
+			output, err = mgr.Status(id)
+		case r.Method == http.MethodDelete && id != "":
+			if err = mgr.Cancel(id); err == nil {
+				logger.Debug("msg", "canceled campaign", "campaign_id", id)
+				output, err = mgr.Status(id)
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if errors.Is(err, campaign.ErrNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		} else if errors.Is(err, campaign.ErrNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			logger.Info("msg", "campaign", "campaign_id", id, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
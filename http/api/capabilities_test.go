@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestCapabilitiesHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	CapabilitiesHandler(new(mock.Storage), log.NopLogger).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	var caps storage.Capabilities
+	if err := json.Unmarshal(rec.Body.Bytes(), &caps); err != nil {
+		t.Fatal(err)
+	}
+	// discovered from the interfaces of a store that does not report
+	// its own capabilities
+	if !caps[storage.CapabilityMetadata] || !caps[storage.CapabilityResultRetention] || caps[storage.CapabilityQueueLocking] {
+		t.Errorf("unexpected capabilities: %v", caps)
+	}
+}
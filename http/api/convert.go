@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// convertHandler converts HTTP POST bodies with convert and responds
+// with the result as contentType.
+func convertHandler(convert func([]byte) ([]byte, error), contentType string, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if b, err = convert(b); err != nil {
+			logger.Info("msg", "converting body", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-type", contentType)
+		_, err = w.Write(b)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// PlistToJSONHandler converts a plist (such as a command or command
+// result) in the HTTP POST body to JSON. See mdm.PlistToJSON.
+func PlistToJSONHandler(logger log.Logger) http.HandlerFunc {
+	return convertHandler(mdm.PlistToJSON, "application/json", logger)
+}
+
+// JSONToPlistHandler converts JSON in the HTTP POST body to a plist
+// (such as a command). See mdm.JSONToPlist.
+func JSONToPlistHandler(logger log.Logger) http.HandlerFunc {
+	return convertHandler(mdm.JSONToPlist, "application/xml", logger)
+}
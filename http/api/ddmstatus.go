@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// DDMStatusHandler reports the latest Declarative Management status
+// reports of MDM enrollments keyed by enrollment ID. Enrollments that
+// have not sent a status report are omitted.
+//
+// Note the whole URL path is used as the identifier(s) to report on.
+// This probably necessitates stripping the URL prefix before using. An
+// empty path reports on all enrollments with a status report.
+func DDMStatusHandler(store storage.DDMStatusStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		reports, err := store.RetrieveDDMStatusReports(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving DDM status reports", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if reports == nil {
+			reports = make(map[string]*storage.DDMStatusReport)
+		}
+		logger.Debug("msg", "retrieved DDM status reports", "count", len(reports))
+		json, err := json.MarshalIndent(reports, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
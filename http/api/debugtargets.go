@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// DebugTargets targets enrollments for debug logging for a limited time.
+type DebugTargets interface {
+	Enable(id string, d time.Duration) (time.Time, error)
+	Disable(id string)
+	Targets() map[string]time.Time
+}
+
+// DebugTargetsHandler targets (HTTP PUT) or stops targeting (HTTP
+// DELETE) enrollments for debug logging. The URL path is the
+// comma-separated enrollment IDs which probably necessitates stripping
+// the URL prefix before using. A PUT may have a "duration" query
+// parameter (e.g. "15m") which is limited to (and defaults to) the
+// maximum duration of targets. The expiry of all targeted enrollments
+// (HTTP GET) is returned as a JSON object keyed by enrollment ID.
+func DebugTargetsHandler(targets DebugTargets, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		_, logger := setupCtxLog(r.Context(), ids, logger)
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var d time.Duration
+			if v := r.URL.Query().Get("duration"); v != "" {
+				var err error
+				if d, err = time.ParseDuration(v); err != nil || d < 0 {
+					logger.Info("msg", "parsing duration", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			for _, id := range ids {
+				if _, err := targets.Enable(id, d); err != nil {
+					logger.Info("msg", "enabling debug target", "id", id, "err", err)
+					http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "enabled debug targets", "duration", d, "user", user)
+		case http.MethodDelete:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				targets.Disable(id)
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "disabled debug targets", "user", user)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(targets.Targets(), "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/service/ddm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func writeDeclarationsJSON(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// DeclarationsHandler retrieves (HTTP GET), stores (HTTP PUT), or
+// deletes (HTTP DELETE) Declarative Management declarations. The URL
+// path is the declaration identifier which probably necessitates
+// stripping the URL prefix before using. A GET without an identifier
+// retrieves all declarations. A PUT stores the declaration in the JSON
+// body which must have a "Type" of a known declaration kind and may
+// have a "Payload". The server token of the declaration is computed
+// from its type and payload. Deleting a declaration removes it from
+// any declaration sets. The declarations are returned as a JSON array.
+func DeclarationsHandler(store storage.DeclarationStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identifier := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if identifier != "" {
+			logger = logger.With("identifier", identifier)
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if identifier == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			declaration := new(storage.Declaration)
+			if err := json.NewDecoder(r.Body).Decode(declaration); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if declaration.Identifier != "" && declaration.Identifier != identifier {
+				http.Error(w, "identifier does not match URL", http.StatusBadRequest)
+				return
+			}
+			if ddm.Kind(declaration.Type) == "" {
+				http.Error(w, "unknown declaration type", http.StatusBadRequest)
+				return
+			}
+			if len(declaration.Payload) < 1 || string(declaration.Payload) == "null" {
+				declaration.Payload = json.RawMessage("{}")
+			}
+			declaration.Identifier = identifier
+			declaration.ServerToken = ddm.ServerToken(declaration)
+			if err := store.StoreDeclaration(ctx, declaration); err != nil {
+				logger.Info("msg", "storing declaration", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "stored declaration", "type", declaration.Type, "server_token", declaration.ServerToken, "user", user)
+		case http.MethodDelete:
+			if identifier == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if err := store.DeleteDeclaration(ctx, identifier); err != nil {
+				logger.Info("msg", "deleting declaration", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "deleted declaration", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var identifiers []string
+		if identifier != "" {
+			identifiers = []string{identifier}
+		}
+		declarations, err := store.RetrieveDeclarations(ctx, identifiers)
+		if err != nil {
+			logger.Info("msg", "retrieving declarations", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if identifier != "" && len(declarations) < 1 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if declarations == nil {
+			declarations = []*storage.Declaration{}
+		}
+		writeDeclarationsJSON(w, declarations, logger)
+	}
+}
+
+// DeclarationSetsHandler retrieves (HTTP GET), stores (HTTP PUT), or
+// deletes (HTTP DELETE) declaration sets. The URL path is the set name
+// which probably necessitates stripping the URL prefix before using.
+// A GET without a name retrieves all sets. A PUT replaces the
+// declarations of the set with the JSON array of declaration
+// identifiers in the body. Unknown identifiers are rejected with an
+// HTTP 400. The sets are returned as a JSON object of set names to
+// declaration identifiers.
+func DeclarationSetsHandler(store storage.DeclarationStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if name != "" {
+			logger = logger.With("set", name)
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodDelete:
+			if name == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var identifiers []string
+			if r.Method == http.MethodPut {
+				if err := json.NewDecoder(r.Body).Decode(&identifiers); err != nil {
+					logger.Info("msg", "decoding body", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+				if len(identifiers) < 1 {
+					http.Error(w, "no declarations", http.StatusBadRequest)
+					return
+				}
+				declarations, err := store.RetrieveDeclarations(ctx, identifiers)
+				if err != nil {
+					logger.Info("msg", "retrieving declarations", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				found := make(map[string]bool)
+				for _, d := range declarations {
+					found[d.Identifier] = true
+				}
+				for _, identifier := range identifiers {
+					if !found[identifier] {
+						http.Error(w, fmt.Sprintf("declaration not found: %s", identifier), http.StatusBadRequest)
+						return
+					}
+				}
+			}
+			// an empty set deletes the set
+			if err := store.StoreDeclarationSet(ctx, name, identifiers); err != nil {
+				logger.Info("msg", "storing declaration set", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			user, _, _ := r.BasicAuth()
+			if r.Method == http.MethodDelete {
+				logger.Info("msg", "deleted declaration set", "user", user)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			logger.Info("msg", "stored declaration set", "declarations", len(identifiers), "user", user)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		var names []string
+		if name != "" {
+			names = []string{name}
+		}
+		sets, err := store.RetrieveDeclarationSets(ctx, names)
+		if err != nil {
+			logger.Info("msg", "retrieving declaration sets", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if name != "" && len(sets) < 1 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if sets == nil {
+			sets = map[string][]string{}
+		}
+		writeDeclarationsJSON(w, sets, logger)
+	}
+}
+
+// EnrollmentSetsHandler retrieves (HTTP GET), assigns (HTTP PUT), or
+// unassigns (HTTP DELETE) the declaration sets of enrollments. The URL
+// path is the comma-separated enrollment IDs which probably
+// necessitates stripping the URL prefix before using. A PUT replaces
+// the sets assigned to the enrollments with the JSON array of set names
+// in the body. A DELETE unassigns all sets. The assigned sets are
+// returned as a JSON object of enrollment IDs to set names.
+// Enrollments sync their declarations on their next Declarative
+// Management check-in which can be prompted by enqueuing a
+// DeclarativeManagement command.
+func EnrollmentSetsHandler(store storage.DeclarationStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodDelete:
+			var sets []string
+			if r.Method == http.MethodPut {
+				if err := json.NewDecoder(r.Body).Decode(&sets); err != nil {
+					logger.Info("msg", "decoding body", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			for _, id := range ids {
+				if err := store.StoreEnrollmentDeclarationSets(ctx, id, sets); err != nil {
+					logger.Info("msg", "storing enrollment declaration sets", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			if r.Method == http.MethodDelete {
+				logger.Info("msg", "unassigned declaration sets", "user", user)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			logger.Info("msg", "assigned declaration sets", "sets", strings.Join(sets, ","), "user", user)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		assigned := make(map[string][]string)
+		for _, id := range ids {
+			sets, err := store.RetrieveEnrollmentDeclarationSets(ctx, id)
+			if err != nil {
+				logger.Info("msg", "retrieving enrollment declaration sets", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if sets == nil {
+				sets = []string{}
+			}
+			assigned[id] = sets
+		}
+		writeDeclarationsJSON(w, assigned, logger)
+	}
+}
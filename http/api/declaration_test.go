@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestDeclarationsHandlers(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	declarations := http.StripPrefix(EndpointDeclarations, DeclarationsHandler(store, log.NopLogger))
+	sets := http.StripPrefix(EndpointDeclSets, DeclarationSetsHandler(store, log.NopLogger))
+	enrollments := http.StripPrefix(EndpointEnrollSets, EnrollmentSetsHandler(store, log.NopLogger))
+
+	serve := func(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w
+	}
+
+	for _, tc := range []struct {
+		identifier string
+		body       string
+		status     int
+	}{
+		{"test.passcode", `{"Type":"com.apple.configuration.passcode.settings","Payload":{"RequirePasscode":true}}`, http.StatusOK},
+		{"test.activation", `{"Type":"com.apple.activation.simple","Payload":{"StandardConfigurations":["test.passcode"]}}`, http.StatusOK},
+		{"test.unknown", `{"Type":"com.example.unknown"}`, http.StatusBadRequest},
+		{"test.mismatch", `{"Identifier":"test.other","Type":"com.apple.activation.simple"}`, http.StatusBadRequest},
+	} {
+		w := serve(declarations, http.MethodPut, EndpointDeclarations+tc.identifier, tc.body)
+		if have, want := w.Code, tc.status; have != want {
+			t.Errorf("%s: status: have %d, want %d", tc.identifier, have, want)
+		}
+	}
+
+	w := serve(declarations, http.MethodGet, EndpointDeclarations+"test.passcode", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status: have %d, want %d", w.Code, http.StatusOK)
+	}
+	var stored []*storage.Declaration
+	if err = json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].ServerToken == "" || stored[0].Type != "com.apple.configuration.passcode.settings" {
+		t.Fatalf("unexpected declarations: %s", w.Body)
+	}
+	if w = serve(declarations, http.MethodGet, EndpointDeclarations+"test.missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("missing declaration: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w = serve(sets, http.MethodPut, EndpointDeclSets+"baseline", `["test.passcode","test.missing"]`); w.Code != http.StatusBadRequest {
+		t.Errorf("set with missing declaration: have %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w = serve(sets, http.MethodPut, EndpointDeclSets+"baseline", `["test.passcode","test.activation"]`); w.Code != http.StatusOK {
+		t.Fatalf("storing set: have %d, want %d", w.Code, http.StatusOK)
+	}
+
+	w = serve(enrollments, http.MethodPut, EndpointEnrollSets+"DEV1,DEV2", `["baseline"]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("assigning sets: have %d, want %d", w.Code, http.StatusOK)
+	}
+	var assigned map[string][]string
+	if err = json.Unmarshal(w.Body.Bytes(), &assigned); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := assigned, map[string][]string{"DEV1": {"baseline"}, "DEV2": {"baseline"}}; !reflect.DeepEqual(have, want) {
+		t.Errorf("assigned sets: have %v, want %v", have, want)
+	}
+
+	// deleting a declaration removes it from its sets
+	if w = serve(declarations, http.MethodDelete, EndpointDeclarations+"test.passcode", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting declaration: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	w = serve(sets, http.MethodGet, EndpointDeclSets+"baseline", "")
+	var set map[string][]string
+	if err = json.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := set["baseline"], []string{"test.activation"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("set after delete: have %v, want %v", have, want)
+	}
+
+	if w = serve(enrollments, http.MethodDelete, EndpointEnrollSets+"DEV1", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("unassigning sets: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if names, err := store.RetrieveEnrollmentDeclarationSets(context.Background(), "DEV1"); err != nil || len(names) > 0 {
+		t.Errorf("sets after unassigning: %v, %v", names, err)
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// dmEnablementResult is the per-enrollment Declarative Management status.
+type dmEnablementResult struct {
+	DMEnabled bool `json:"dm_enabled"`
+	*storage.DMEnablement
+}
+
+// DMEnablementHandler reports the Declarative Management activity of
+// MDM enrollments. Enrollments which have issued DeclarativeManagement
+// check-ins are considered to have activated Declarative Management.
+//
+// Note the whole URL path is used as the identifier(s) to report on.
+// This probably necessitates stripping the URL prefix before using. An
+// empty path reports on all enrollments with Declarative Management
+// activity.
+func DMEnablementHandler(store storage.DMEnablementStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		dms, err := store.RetrieveDMEnablements(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving DM enablements", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		output := make(map[string]*dmEnablementResult)
+		for _, id := range ids {
+			output[id] = &dmEnablementResult{}
+		}
+		for id, dm := range dms {
+			output[id] = &dmEnablementResult{DMEnabled: true, DMEnablement: dm}
+		}
+		logger.Debug("msg", "retrieved DM enablements", "count", len(dms))
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// enrollmentFilterFromRequest assembles an enrollment filter from the
+// URL path (as comma-separated IDs) and query parameters.
+func enrollmentFilterFromRequest(r *http.Request) (*storage.EnrollmentFilter, error) {
+	filter := &storage.EnrollmentFilter{
+		DeviceID: r.URL.Query().Get("device_id"),
+	}
+	if r.URL.Path != "" {
+		filter.IDs = strings.Split(r.URL.Path, ",")
+	}
+	if v := r.URL.Query().Get("enabled"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, err
+		}
+		filter.Enabled = &enabled
+	}
+	var err error
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
+
+// EnrollmentsHandler returns a JSON list of MDM enrollments.
+//
+// Note the whole URL path is used as the identifier(s) to retrieve. An
+// empty path retrieves all enrollments. The "device_id", "enabled",
+// "limit", and "offset" query parameters further filter the results.
+// If resolver is not nil then the identifiers may also be serial
+// numbers, UDIDs, or EnrollmentIDs.
+func EnrollmentsHandler(store storage.EnrollmentRetriever, resolver storage.EnrollmentAliasResolver, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := enrollmentFilterFromRequest(r)
+		if err != nil {
+			logger.Info("msg", "parsing filter", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), filter.IDs, logger)
+		if resolver != nil && len(filter.IDs) > 0 {
+			if filter.IDs, err = resolveIDs(ctx, resolver, filter.IDs); err != nil {
+				logger.Info("msg", "resolving enrollment ids", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		enrollments, err := store.RetrieveEnrollments(ctx, filter)
+		if err != nil {
+			logger.Info("msg", "retrieving enrollments", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if enrollments == nil {
+			enrollments = []*storage.Enrollment{}
+		}
+		logger.Debug("msg", "retrieved enrollments", "count", len(enrollments))
+		json, err := json.MarshalIndent(enrollments, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
+
+// DisableHandler disables MDM enrollments (and their user channel
+// enrollments) as an administrative action. Devices will need to
+// re-enroll (or send a TokenUpdate) to be re-enabled.
+//
+// Note the whole URL path is used as the device enrollment ID(s) to
+// disable. This probably necessitates stripping the URL prefix before
+// using.
+func DisableHandler(store storage.CheckinStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			w.Header().Set("Allow", "POST, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		output := make(map[string]string)
+		var errCt int
+		for _, id := range ids {
+			mdmReq := &mdm.Request{
+				Context:  ctx,
+				EnrollID: &mdm.EnrollID{ID: id},
+			}
+			if err := storage.DisableWithReason(store, mdmReq, storage.DisableReasonAdmin); err != nil {
+				output[id] = err.Error()
+				errCt++
+			} else {
+				output[id] = ""
+			}
+		}
+		logs := []interface{}{"msg", "disable", "count", len(ids) - errCt}
+		header := http.StatusOK
+		if errCt > 0 {
+			logs = append(logs, "errs", errCt)
+			logger.Info(logs...)
+			header = http.StatusMultiStatus
+			if errCt == len(ids) {
+				header = http.StatusInternalServerError
+			}
+		} else {
+			logger.Debug(logs...)
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		w.WriteHeader(header)
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const (
+	eventLogDefaultLimit = 100
+	eventLogMaxLimit     = 1000
+)
+
+// eventLogResult is a page of the event log. Cursor is the sequence
+// number to resume reading from (i.e. the "after" parameter).
+type eventLogResult struct {
+	Events []*storage.LogEvent `json:"events"`
+	Cursor int64               `json:"cursor"`
+}
+
+// EventLogHandler returns events from the event log as JSON. Events are
+// returned after the sequence number in the "after" query parameter
+// (default 0) up to the number in the "limit" query parameter.
+func EventLogHandler(store storage.EventLogStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		var after int64
+		limit := eventLogDefaultLimit
+		var err error
+		if v := r.URL.Query().Get("after"); v != "" {
+			after, err = strconv.ParseInt(v, 10, 64)
+		}
+		if v := r.URL.Query().Get("limit"); err == nil && v != "" {
+			limit, err = strconv.Atoi(v)
+		}
+		if err != nil || after < 0 || limit < 1 {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if limit > eventLogMaxLimit {
+			limit = eventLogMaxLimit
+		}
+		output := &eventLogResult{Cursor: after}
+		output.Events, err = store.RetrieveLogEvents(ctx, after, limit)
+		if err != nil {
+			logger.Info("msg", "retrieving log events", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if output.Events == nil {
+			output.Events = []*storage.LogEvent{}
+		}
+		if len(output.Events) > 0 {
+			output.Cursor = output.Events[len(output.Events)-1].Seq
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/service/microwebhook"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// eventsKeepAlive is how often an SSE comment is sent to keep idle
+// connections (and any intermediate proxies) open.
+const eventsKeepAlive = 30 * time.Second
+
+// EventsHandler streams webhook events published to broker as
+// Server-Sent Events (SSE). The SSE event type is the webhook event
+// topic and the data is the JSON webhook event. The event schema
+// version is selected with the "version" query parameter (default 1).
+func EventsHandler(broker *microwebhook.Broker, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		version, err := microwebhook.ParseVersion(r.URL.Query().Get("version"))
+		if err != nil {
+			logger.Info("msg", "parsing version", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			logger.Info("msg", "response writer does not support flushing")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		events, unsubscribe := broker.Subscribe()
+		defer unsubscribe()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set(microwebhook.VersionHeader, strconv.Itoa(version))
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		logger.Debug("msg", "events subscribed")
+		ticker := time.NewTicker(eventsKeepAlive)
+		defer ticker.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				logger.Debug("msg", "events unsubscribed")
+				return
+			case <-ticker.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case ev := <-events:
+				var encoded interface{}
+				var evJSON []byte
+				encoded, err = microwebhook.EncodeVersion(ev, version)
+				if err == nil {
+					evJSON, err = json.Marshal(encoded)
+				}
+				if err != nil {
+					logger.Info("msg", "marshal json", "err", err)
+					continue
+				}
+				_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Topic, evJSON)
+			}
+			if err != nil {
+				logger.Info("msg", "writing event", "err", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
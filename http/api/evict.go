@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// EvictHandler retrieves (HTTP GET), evicts (HTTP PUT), or removes the
+// eviction of (HTTP DELETE) enrollments. The URL path is the
+// comma-separated enrollment IDs which probably necessitates stripping
+// the URL prefix before using. A GET without IDs retrieves all evicted
+// enrollments. A PUT may have a JSON object with a "reason". The
+// evictions are returned as a JSON object keyed by enrollment ID.
+func EvictHandler(store storage.EnrollmentEvictionStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var evictions map[string]*storage.EnrollmentEviction
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			evictions, err = store.RetrieveEnrollmentEvictions(ctx, ids)
+			if err != nil {
+				logger.Info("msg", "retrieving evictions", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPut:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			eviction := new(storage.EnrollmentEviction)
+			if len(b) > 0 {
+				if err = json.Unmarshal(b, eviction); err != nil {
+					logger.Info("msg", "decoding eviction", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			eviction.EvictedBy, _, _ = r.BasicAuth()
+			for _, id := range ids {
+				e := storage.EnrollmentEviction{ID: id, Reason: eviction.Reason, EvictedBy: eviction.EvictedBy}
+				if err = store.StoreEnrollmentEviction(ctx, &e); err != nil {
+					logger.Info("msg", "storing eviction", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			logger.Info("msg", "evicted enrollments", "reason", eviction.Reason, "user", eviction.EvictedBy)
+			if evictions, err = store.RetrieveEnrollmentEvictions(ctx, ids); err != nil {
+				logger.Info("msg", "retrieving evictions", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodDelete:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				if err := store.DeleteEnrollmentEviction(ctx, id); err != nil {
+					logger.Info("msg", "deleting eviction", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "removed evictions", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(evictions, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
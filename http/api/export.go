@@ -0,0 +1,317 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// exportPageSize is the number of enrollments or log events retrieved
+// from storage at a time while exporting.
+const exportPageSize = 500
+
+// exportInventorySources are the inventory sources exported by default.
+// These are the sources stored by the inventory tracker.
+var exportInventorySources = []string{"DeviceInformation", "SecurityInfo", "ProfileList"}
+
+// exporter writes export records as CSV or as newline-delimited JSON.
+type exporter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	enc     *json.Encoder
+	flusher http.Flusher
+	n       int
+}
+
+// newExporter starts a response of kind in format with the CSV header.
+func newExporter(w http.ResponseWriter, kind, format string, header []string) (*exporter, error) {
+	e := &exporter{w: w}
+	e.flusher, _ = w.(http.Flusher)
+	switch format {
+	case "", "csv":
+		w.Header().Set("Content-type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+kind+`.csv"`)
+		e.csv = csv.NewWriter(w)
+		return e, e.csv.Write(header)
+	case "ndjson":
+		w.Header().Set("Content-type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+kind+`.ndjson"`)
+		e.enc = json.NewEncoder(w)
+		return e, nil
+	}
+	return nil, errors.New("invalid format")
+}
+
+// write writes a record: v as JSON or record as CSV.
+func (e *exporter) write(v interface{}, record []string) error {
+	e.n++
+	if e.csv != nil {
+		return e.csv.Write(record)
+	}
+	return e.enc.Encode(v)
+}
+
+// flush sends the records written so far to the client.
+func (e *exporter) flush() error {
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+var exportEnrollmentsHeader = []string{
+	"id", "device_id", "user_id", "user_short_name", "type", "topic",
+	"enabled", "last_seen_at", "disable_reason", "disabled_at",
+}
+
+// forEachEnrollment calls f with pages of the enrollments selected by
+// the "device_id" and "enabled" query parameters.
+func forEachEnrollment(r *http.Request, store storage.EnrollmentRetriever, f func([]*storage.Enrollment) error) error {
+	filter := &storage.EnrollmentFilter{
+		DeviceID: r.URL.Query().Get("device_id"),
+		Limit:    exportPageSize,
+	}
+	if v := r.URL.Query().Get("enabled"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		filter.Enabled = &enabled
+	}
+	for {
+		enrollments, err := store.RetrieveEnrollments(r.Context(), filter)
+		if err != nil {
+			return err
+		}
+		if err = f(enrollments); err != nil {
+			return err
+		}
+		if len(enrollments) < exportPageSize {
+			return nil
+		}
+		filter.Offset += len(enrollments)
+	}
+}
+
+func exportEnrollments(e *exporter, r *http.Request, store storage.EnrollmentRetriever) error {
+	return forEachEnrollment(r, store, func(enrollments []*storage.Enrollment) error {
+		for _, enrollment := range enrollments {
+			err := e.write(enrollment, []string{
+				enrollment.ID,
+				enrollment.DeviceID,
+				enrollment.UserID,
+				enrollment.UserShortName,
+				enrollment.Type,
+				enrollment.Topic,
+				strconv.FormatBool(enrollment.Enabled),
+				formatTime(&enrollment.LastSeenAt),
+				enrollment.DisableReason,
+				formatTime(enrollment.DisabledAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return e.flush()
+	})
+}
+
+// inventoryRecord is an exported inventory value.
+type inventoryRecord struct {
+	EnrollmentID string `json:"enrollment_id"`
+	Source       string `json:"source"`
+	Key          string `json:"key"`
+	Value        string `json:"value"`
+}
+
+var exportInventoryHeader = []string{"enrollment_id", "source", "key", "value"}
+
+func exportInventory(e *exporter, r *http.Request, store storage.EnrollmentRetriever, inventory storage.InventoryStore) error {
+	sources := exportInventorySources
+	if v := r.URL.Query().Get("source"); v != "" {
+		sources = []string{v}
+	}
+	return forEachEnrollment(r, store, func(enrollments []*storage.Enrollment) error {
+		for _, enrollment := range enrollments {
+			for _, source := range sources {
+				values, err := inventory.RetrieveInventory(r.Context(), enrollment.ID, source)
+				if err != nil {
+					return err
+				}
+				keys := make([]string, 0, len(values))
+				for k := range values {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+				for _, k := range keys {
+					rec := &inventoryRecord{EnrollmentID: enrollment.ID, Source: source, Key: k, Value: values[k]}
+					if err = e.write(rec, []string{rec.EnrollmentID, rec.Source, rec.Key, rec.Value}); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return e.flush()
+	})
+}
+
+// commandRecord is an exported command report from the event log.
+type commandRecord struct {
+	Seq          int64     `json:"seq"`
+	EnrollmentID string    `json:"enrollment_id"`
+	CommandUUID  string    `json:"command_uuid"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+var exportCommandsHeader = []string{"seq", "enrollment_id", "command_uuid", "status", "created_at"}
+
+// exportCommands exports the command reports of the event log after the
+// sequence number in the "after" query parameter, optionally only of the
+// enrollment in the "enrollment_id" query parameter.
+func exportCommands(e *exporter, r *http.Request, store storage.EventLogStore) error {
+	var after int64
+	if v := r.URL.Query().Get("after"); v != "" {
+		var err error
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return err
+		}
+	}
+	id := r.URL.Query().Get("enrollment_id")
+	for {
+		events, err := store.RetrieveLogEvents(r.Context(), after, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			after = event.Seq
+			if event.CommandUUID == "" || (id != "" && event.EnrollmentID != id) {
+				continue
+			}
+			rec := &commandRecord{
+				Seq:          event.Seq,
+				EnrollmentID: event.EnrollmentID,
+				CommandUUID:  event.CommandUUID,
+				Status:       event.Status,
+				CreatedAt:    event.CreatedAt,
+			}
+			err = e.write(rec, []string{
+				strconv.FormatInt(rec.Seq, 10),
+				rec.EnrollmentID,
+				rec.CommandUUID,
+				rec.Status,
+				formatTime(&rec.CreatedAt),
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err = e.flush(); err != nil {
+			return err
+		}
+		if len(events) < exportPageSize {
+			return nil
+		}
+	}
+}
+
+// ExportHandler streams bulk exports of enrollments, inventory, or
+// command history as CSV (the default) or newline-delimited JSON per
+// the "format" query parameter ("csv" or "ndjson").
+//
+// Note the whole URL path is used as the kind of export ("enrollments",
+// "inventory", or "commands"). This probably necessitates stripping the
+// URL prefix before using. Inventory and command history (exported from
+// the event log) are optional so inventory and eventLog may be nil if
+// they are not supported or enabled. As records are
+// streamed errors after the first records are written can only be
+// logged and truncate the export.
+func ExportHandler(store storage.EnrollmentRetriever, inventory storage.InventoryStore, eventLog storage.EventLogStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		_, logger := setupCtxLog(r.Context(), nil, logger)
+		kind := r.URL.Path
+		logger = logger.With("export", kind)
+		var header []string
+		var export func(*exporter) error
+		switch kind {
+		case "enrollments":
+			header = exportEnrollmentsHeader
+			export = func(e *exporter) error { return exportEnrollments(e, r, store) }
+		case "inventory":
+			if inventory == nil {
+				http.Error(w, "inventory not supported", http.StatusNotFound)
+				return
+			}
+			header = exportInventoryHeader
+			export = func(e *exporter) error { return exportInventory(e, r, store, inventory) }
+		case "commands":
+			if eventLog == nil {
+				http.Error(w, "event log not enabled", http.StatusNotFound)
+				return
+			}
+			header = exportCommandsHeader
+			export = func(e *exporter) error { return exportCommands(e, r, eventLog) }
+		default:
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		// validate the query parameters before any records are written
+		var err error
+		if v := r.URL.Query().Get("enabled"); v != "" {
+			_, err = strconv.ParseBool(v)
+		}
+		if v := r.URL.Query().Get("after"); err == nil && v != "" {
+			_, err = strconv.ParseInt(v, 10, 64)
+		}
+		switch r.URL.Query().Get("format") {
+		case "", "csv", "ndjson":
+		default:
+			err = errors.New("invalid format")
+		}
+		if err != nil {
+			logger.Info("msg", "parsing query", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		e, err := newExporter(w, kind, r.URL.Query().Get("format"), header)
+		if err == nil {
+			err = export(e)
+		}
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Info("msg", "exporting", "records", e.n, "err", err)
+			return
+		}
+		if err = e.flush(); err != nil {
+			logger.Info("msg", "exporting", "records", e.n, "err", err)
+			return
+		}
+		logger.Debug("msg", "exported", "records", e.n)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestExportHandler(t *testing.T) {
+	store := new(mock.Storage)
+	store.RetrieveEnrollmentsFunc = func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		if filter.Offset > 0 {
+			return nil, nil
+		}
+		return []*storage.Enrollment{
+			{ID: "DEV1", DeviceID: "DEV1", Type: "Device", Topic: "com.example", Enabled: true, LastSeenAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			{ID: "DEV2", DeviceID: "DEV2", Type: "Device", Topic: "com.example"},
+		}, nil
+	}
+	store.RetrieveInventoryFunc = func(_ context.Context, id, source string) (map[string]string, error) {
+		if id == "DEV1" && source == "DeviceInformation" {
+			return map[string]string{"Model": "Mac14,2", "OSVersion": "14.4"}, nil
+		}
+		return nil, nil
+	}
+	store.RetrieveLogEventsFunc = func(_ context.Context, after int64, _ int) ([]*storage.LogEvent, error) {
+		if after > 0 {
+			return nil, nil
+		}
+		return []*storage.LogEvent{
+			{Seq: 1, Topic: "mdm.Authenticate", EnrollmentID: "DEV1"},
+			{Seq: 2, Topic: "mdm.Connect", EnrollmentID: "DEV1", CommandUUID: "UUID1", Status: "Acknowledged"},
+		}, nil
+	}
+	h := http.StripPrefix(EndpointExport, ExportHandler(store, store, store, log.NopLogger))
+
+	export := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", EndpointExport+target, nil))
+		return rec
+	}
+
+	rec := export("enrollments")
+	want := "id,device_id,user_id,user_short_name,type,topic,enabled,last_seen_at,disable_reason,disabled_at\n" +
+		"DEV1,DEV1,,,Device,com.example,true,2024-01-02T03:04:05Z,,\n" +
+		"DEV2,DEV2,,,Device,com.example,false,,,\n"
+	if have := rec.Body.String(); have != want {
+		t.Errorf("enrollments: have %q, want %q", have, want)
+	}
+	if have, want := rec.Header().Get("Content-type"), "text/csv"; have != want {
+		t.Errorf("content type: have %q, want %q", have, want)
+	}
+
+	rec = export("inventory?format=ndjson")
+	want = `{"enrollment_id":"DEV1","source":"DeviceInformation","key":"Model","value":"Mac14,2"}` + "\n" +
+		`{"enrollment_id":"DEV1","source":"DeviceInformation","key":"OSVersion","value":"14.4"}` + "\n"
+	if have := rec.Body.String(); have != want {
+		t.Errorf("inventory: have %q, want %q", have, want)
+	}
+
+	rec = export("commands")
+	if have, want := strings.Count(rec.Body.String(), "\n"), 2; have != want {
+		t.Errorf("commands: have %d lines, want %d", have, want)
+	}
+
+	for target, code := range map[string]int{
+		"bogus":                   http.StatusNotFound,
+		"enrollments?format=xlsx": http.StatusBadRequest,
+		"enrollments?enabled=x":   http.StatusBadRequest,
+	} {
+		if have := export(target).Code; have != code {
+			t.Errorf("%s: have %d, want %d", target, have, code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h = http.StripPrefix(EndpointExport, ExportHandler(store, store, nil, log.NopLogger))
+	h.ServeHTTP(rec, httptest.NewRequest("GET", EndpointExport+"commands", nil))
+	if have, want := rec.Code, http.StatusNotFound; have != want {
+		t.Errorf("commands without event log: have %d, want %d", have, want)
+	}
+	rec = httptest.NewRecorder()
+	h = http.StripPrefix(EndpointExport, ExportHandler(store, nil, nil, log.NopLogger))
+	h.ServeHTTP(rec, httptest.NewRequest("GET", EndpointExport+"inventory", nil))
+	if have, want := rec.Code, http.StatusNotFound; have != want {
+		t.Errorf("inventory without inventory store: have %d, want %d", have, want)
+	}
+}
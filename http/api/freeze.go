@@ -0,0 +1,103 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// FreezeHandler retrieves (HTTP GET), freezes (HTTP PUT), or unfreezes
+// (HTTP DELETE) enrollments. The URL path is the comma-separated
+// enrollment IDs which probably necessitates stripping the URL prefix
+// before using. A GET without IDs retrieves all frozen enrollments. A
+// PUT may have a JSON object with a "reason". The freezes are returned
+// as a JSON object keyed by enrollment ID.
+func FreezeHandler(store storage.EnrollmentFreezeStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var freezes map[string]*storage.EnrollmentFreeze
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			freezes, err = store.RetrieveEnrollmentFreezes(ctx, ids)
+			if err != nil {
+				logger.Info("msg", "retrieving freezes", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPut:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			freeze := new(storage.EnrollmentFreeze)
+			if len(b) > 0 {
+				if err = json.Unmarshal(b, freeze); err != nil {
+					logger.Info("msg", "decoding freeze", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			freeze.FrozenBy, _, _ = r.BasicAuth()
+			for _, id := range ids {
+				f := *freeze
+				f.ID = id
+				if err = store.StoreEnrollmentFreeze(ctx, &f); err != nil {
+					logger.Info("msg", "storing freeze", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			logger.Info("msg", "froze enrollments", "reason", freeze.Reason, "user", freeze.FrozenBy)
+			if freezes, err = store.RetrieveEnrollmentFreezes(ctx, ids); err != nil {
+				logger.Info("msg", "retrieving freezes", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodDelete:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				if err := store.DeleteEnrollmentFreeze(ctx, id); err != nil {
+					logger.Info("msg", "deleting freeze", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "unfroze enrollments", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(freezes, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/push/campaign"
+	"github.com/micromdm/nanomdm/service/smartgroup"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// groupResult is the membership of an enrollment metadata group.
+type groupResult struct {
+	Name    string   `json:"name"`
+	Smart   bool     `json:"smart"`
+	Members []string `json:"members"`
+}
+
+func writeGroupsJSON(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// GroupsHandler retrieves enrollment metadata groups and smart groups.
+// The URL path is the group name which probably necessitates stripping
+// the URL prefix before using. A GET without a name returns the smart
+// group definitions and a GET with a name returns the enabled
+// enrollments that are members of the group. A POST without a name
+// re-evaluates the smart group membership of all enabled enrollments.
+// The smart groups evaluator may be nil.
+func GroupsHandler(store campaign.GroupStore, smart *smartgroup.Evaluator, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if name != "" {
+			logger = logger.With("group", name)
+		}
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			groups := []*smartgroup.Group{}
+			if smart != nil {
+				groups = smart.Groups()
+			}
+			writeGroupsJSON(w, groups, logger)
+		case r.Method == http.MethodGet:
+			members, err := campaign.ResolveGroup(ctx, store, name)
+			if err != nil {
+				logger.Info("msg", "resolving group", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if members == nil {
+				members = []string{}
+			}
+			writeGroupsJSON(w, &groupResult{
+				Name:    name,
+				Smart:   smart != nil && smart.Smart(name),
+				Members: members,
+			}, logger)
+		case r.Method == http.MethodPost && name == "":
+			if smart == nil {
+				http.Error(w, "no smart groups", http.StatusNotFound)
+				return
+			}
+			n, err := smart.EvaluateAll(ctx)
+			if err != nil {
+				logger.Info("msg", "evaluating smart groups", "evaluated", n, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			logger.Info("msg", "evaluated smart groups", "evaluated", n)
+			writeGroupsJSON(w, map[string]int{"evaluated": n}, logger)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// QueueState is a queued command of an enrollment at a point in time.
+type QueueState struct {
+	CommandUUID string `json:"command_uuid"`
+
+	// RequestType is empty for commands no longer in storage.
+	RequestType string `json:"request_type,omitempty"`
+
+	// Status is the status of the latest result (e.g. "NotNow") before
+	// the point in time, if any.
+	Status    string     `json:"status,omitempty"`
+	Delivered bool       `json:"delivered"`
+	QueuedAt  *time.Time `json:"queued_at,omitempty"`
+}
+
+// EnrollmentState is the state of an enrollment at a point in time
+// reconstructed from the event log.
+type EnrollmentState struct {
+	EnrollmentID string    `json:"enrollment_id"`
+	At           time.Time `json:"at"`
+
+	// Enrolled is true if the enrollment had sent an Authenticate (or,
+	// for user channels, a TokenUpdate) check-in message.
+	Enrolled      bool   `json:"enrolled"`
+	Enabled       bool   `json:"enabled"`
+	DisableReason string `json:"disable_reason,omitempty"`
+
+	SerialNumber string `json:"serial_number,omitempty"`
+	Topic        string `json:"topic,omitempty"`
+	PushToken    string `json:"push_token,omitempty"`
+	PushMagic    string `json:"push_magic,omitempty"`
+	CertHash     string `json:"cert_hash,omitempty"`
+
+	AuthenticateAt *time.Time `json:"authenticate_at,omitempty"`
+	TokenUpdateAt  *time.Time `json:"token_update_at,omitempty"`
+	LastSeenAt     *time.Time `json:"last_seen_at,omitempty"`
+
+	// LastEventSeq is the sequence number of the last event of the
+	// enrollment before the point in time.
+	LastEventSeq int64 `json:"last_event_seq,omitempty"`
+
+	Queue []*QueueState `json:"queue"`
+}
+
+// isFinalStatus reports whether a command with a result of status is
+// no longer queued.
+func isFinalStatus(status string) bool {
+	return status != "" && status != "NotNow" && status != "Idle"
+}
+
+// stateReplayer reconstructs enrollment state by replaying log events.
+type stateReplayer struct {
+	state    *EnrollmentState
+	parentID string
+
+	// clearedAt is when the queue was last cleared by an Authenticate.
+	clearedAt time.Time
+
+	cmds  map[string]*QueueState
+	order []string
+	done  map[string]bool
+}
+
+func newStateReplayer(id string, at time.Time) *stateReplayer {
+	p := &stateReplayer{
+		state: &EnrollmentState{EnrollmentID: id, At: at},
+		cmds:  make(map[string]*QueueState),
+		done:  make(map[string]bool),
+	}
+	if i := strings.Index(id, ":"); i >= 0 {
+		p.parentID = id[:i]
+	}
+	return p
+}
+
+// clearQueue forgets the queued commands as of t.
+func (p *stateReplayer) clearQueue(t time.Time) {
+	p.clearedAt = t
+	p.cmds = make(map[string]*QueueState)
+	p.order = nil
+}
+
+// cmd returns the queue state of the command uuid.
+func (p *stateReplayer) cmd(uuid string) *QueueState {
+	cmd, ok := p.cmds[uuid]
+	if !ok {
+		cmd = &QueueState{CommandUUID: uuid}
+		p.cmds[uuid] = cmd
+		p.order = append(p.order, uuid)
+	}
+	return cmd
+}
+
+// apply applies event to the enrollment state.
+func (p *stateReplayer) apply(event *storage.LogEvent) {
+	s := p.state
+	if p.parentID != "" && event.EnrollmentID == p.parentID {
+		// the device channel being re-enrolled or unenrolled also
+		// disables (and clears the queue of) its user channels
+		switch event.Topic {
+		case "mdm.Authenticate":
+			s.Enabled, s.DisableReason = false, storage.DisableReasonAuthenticate
+			p.clearQueue(event.CreatedAt)
+		case "mdm.CheckOut":
+			s.Enabled, s.DisableReason = false, storage.DisableReasonCheckOut
+		}
+		return
+	}
+	if event.EnrollmentID != s.EnrollmentID {
+		return
+	}
+	createdAt := event.CreatedAt
+	s.LastSeenAt = &createdAt
+	s.LastEventSeq = event.Seq
+	switch event.Topic {
+	case "mdm.Authenticate":
+		s.Enrolled = true
+		s.Enabled, s.DisableReason = false, storage.DisableReasonAuthenticate
+		s.AuthenticateAt = &createdAt
+		s.CertHash = event.CertHash
+		if msg, err := mdm.DecodeCheckin(event.RawPayload); err == nil {
+			if m, ok := msg.(*mdm.Authenticate); ok {
+				s.SerialNumber = m.SerialNumber
+				s.Topic = m.Topic
+			}
+		}
+		p.clearQueue(event.CreatedAt)
+	case "mdm.TokenUpdate":
+		s.Enrolled = true
+		s.Enabled, s.DisableReason = true, ""
+		s.TokenUpdateAt = &createdAt
+		if event.CertHash != "" {
+			s.CertHash = event.CertHash
+		}
+		if msg, err := mdm.DecodeCheckin(event.RawPayload); err == nil {
+			if m, ok := msg.(*mdm.TokenUpdate); ok {
+				s.PushToken = hex.EncodeToString(m.Token)
+				s.PushMagic = m.PushMagic
+				s.Topic = m.Topic
+			}
+		}
+	case "mdm.CheckOut":
+		s.Enabled, s.DisableReason = false, storage.DisableReasonCheckOut
+	case "nanomdm.CommandDelivered":
+		p.cmd(event.CommandUUID).Delivered = true
+	case "mdm.Connect":
+		if event.CommandUUID == "" {
+			break
+		}
+		if isFinalStatus(event.Status) {
+			p.done[event.CommandUUID] = true
+			delete(p.cmds, event.CommandUUID)
+			break
+		}
+		p.cmd(event.CommandUUID).Status = event.Status
+	}
+}
+
+// queue returns the queued commands as of the point in time. Commands
+// in queue (the current queue from storage) are in queue order followed
+// by any commands only known from the event log.
+func (p *stateReplayer) queue(queue []*storage.QueuedCommand) []*QueueState {
+	at := p.state.At
+	ret := []*QueueState{}
+	seen := make(map[string]bool)
+	for _, qc := range queue {
+		if qc.CreatedAt.IsZero() || qc.CreatedAt.After(at) || qc.CreatedAt.Before(p.clearedAt) || p.done[qc.CommandUUID] {
+			continue
+		}
+		createdAt := qc.CreatedAt
+		cmd := &QueueState{CommandUUID: qc.CommandUUID, RequestType: qc.RequestType, QueuedAt: &createdAt}
+		if c, ok := p.cmds[qc.CommandUUID]; ok {
+			cmd.Status, cmd.Delivered = c.Status, c.Delivered
+		}
+		ret = append(ret, cmd)
+		seen[qc.CommandUUID] = true
+	}
+	for _, uuid := range p.order {
+		if c, ok := p.cmds[uuid]; ok && !seen[uuid] {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// EnrollmentStateStore retrieves the event log and command queues.
+type EnrollmentStateStore interface {
+	storage.EventLogStore
+	storage.QueueRetriever
+}
+
+// replayEnrollmentState reconstructs the state of enrollment id as of
+// at from the event log and the current command queue.
+func replayEnrollmentState(ctx context.Context, store EnrollmentStateStore, id string, at time.Time) (*EnrollmentState, error) {
+	p := newStateReplayer(id, at)
+	var after int64
+	for more := true; more; {
+		events, err := store.RetrieveLogEvents(ctx, after, exportPageSize)
+		if err != nil {
+			return nil, err
+		}
+		more = len(events) == exportPageSize
+		for _, event := range events {
+			if event.CreatedAt.After(at) {
+				// events are appended in time order
+				more = false
+				break
+			}
+			after = event.Seq
+			p.apply(event)
+		}
+	}
+	cmds, err := store.RetrieveQueue(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	p.state.Queue = p.queue(cmds)
+	return p.state, nil
+}
+
+// EnrollmentStateHandler returns the state of an enrollment (enablement,
+// push token, identity certificate hash, and queued commands) as of the
+// RFC 3339 timestamp in the "at" query parameter as JSON. The state is
+// reconstructed from the event log so only changes made by check-in
+// messages and command reports while the event log was enabled are
+// reflected (e.g. administrative disables are not).
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func EnrollmentStateHandler(store EnrollmentStateStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{r.URL.Path}, logger)
+		at, err := time.Parse(time.RFC3339, r.URL.Query().Get("at"))
+		if err != nil {
+			logger.Info("msg", "parsing at", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		state, err := replayEnrollmentState(ctx, store, r.URL.Path, at.UTC())
+		if err != nil {
+			logger.Info("msg", "replaying enrollment state", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("msg", "enrollment state", "at", at, "last_event_seq", state.LastEventSeq)
+		json, err := json.MarshalIndent(state, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestEnrollmentStateHandler(t *testing.T) {
+	t0 := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	at := func(m int) time.Time { return t0.Add(time.Duration(m) * time.Minute) }
+	checkin := func(msg map[string]interface{}) []byte {
+		msg["UDID"] = "DEV1"
+		msg["Topic"] = "com.example"
+		b, err := plist.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	events := []*storage.LogEvent{
+		{Seq: 1, Topic: "mdm.Authenticate", EnrollmentID: "DEV1", CertHash: "aaaa", CreatedAt: at(0),
+			RawPayload: checkin(map[string]interface{}{"MessageType": "Authenticate", "SerialNumber": "C02XX"})},
+		{Seq: 2, Topic: "mdm.TokenUpdate", EnrollmentID: "DEV1", CertHash: "aaaa", CreatedAt: at(1),
+			RawPayload: checkin(map[string]interface{}{"MessageType": "TokenUpdate", "Token": []byte{0xab, 0xcd}, "PushMagic": "magic"})},
+		{Seq: 3, Topic: "mdm.Authenticate", EnrollmentID: "DEV2", CreatedAt: at(2)},
+		{Seq: 4, Topic: "nanomdm.CommandDelivered", EnrollmentID: "DEV1", CommandUUID: "UUID1", CreatedAt: at(3)},
+		{Seq: 5, Topic: "mdm.Connect", EnrollmentID: "DEV1", CommandUUID: "UUID1", Status: "NotNow", CreatedAt: at(4)},
+		{Seq: 6, Topic: "mdm.Connect", EnrollmentID: "DEV1", CommandUUID: "UUID1", Status: "Acknowledged", CreatedAt: at(6)},
+		{Seq: 7, Topic: "mdm.CheckOut", EnrollmentID: "DEV1", CreatedAt: at(8)},
+	}
+	store := new(mock.Storage)
+	store.RetrieveLogEventsFunc = func(_ context.Context, after int64, _ int) ([]*storage.LogEvent, error) {
+		if after > 0 {
+			return nil, nil
+		}
+		return events, nil
+	}
+	store.RetrieveQueueFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		return []*storage.QueuedCommand{
+			{CommandUUID: "UUID2", RequestType: "ProfileList", CreatedAt: at(5)},
+		}, nil
+	}
+	h := http.StripPrefix(EndpointHistory, EnrollmentStateHandler(store, log.NopLogger))
+
+	state := func(target string) (*EnrollmentState, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", EndpointHistory+target, nil))
+		if rec.Code != http.StatusOK {
+			return nil, rec.Code
+		}
+		s := new(EnrollmentState)
+		if err := json.Unmarshal(rec.Body.Bytes(), s); err != nil {
+			t.Fatal(err)
+		}
+		return s, rec.Code
+	}
+
+	s, _ := state("DEV1?at=2024-01-02T03:05:30Z")
+	if s == nil {
+		t.Fatal("nil state")
+	}
+	if !s.Enrolled || !s.Enabled || s.SerialNumber != "C02XX" || s.PushToken != "abcd" || s.PushMagic != "magic" || s.CertHash != "aaaa" {
+		t.Errorf("state: %+v", s)
+	}
+	if have, want := s.LastEventSeq, int64(5); have != want {
+		t.Errorf("last event seq: have %d, want %d", have, want)
+	}
+	if have, want := len(s.Queue), 2; have != want {
+		t.Fatalf("queue: have %d, want %d", have, want)
+	}
+	// queued commands from storage come first
+	if q := s.Queue[0]; q.CommandUUID != "UUID2" || q.RequestType != "ProfileList" || q.Delivered {
+		t.Errorf("queue[0]: %+v", q)
+	}
+	if q := s.Queue[1]; q.CommandUUID != "UUID1" || q.Status != "NotNow" || !q.Delivered {
+		t.Errorf("queue[1]: %+v", q)
+	}
+
+	s, _ = state("DEV1?at=2024-01-02T03:09:00Z")
+	if s == nil {
+		t.Fatal("nil state")
+	}
+	if s.Enabled || s.DisableReason != storage.DisableReasonCheckOut {
+		t.Errorf("enabled: %v, disable reason: %q", s.Enabled, s.DisableReason)
+	}
+	if have, want := len(s.Queue), 1; have != want {
+		t.Errorf("queue: have %d, want %d", have, want)
+	}
+
+	s, _ = state("DEV1?at=2024-01-01T00:00:00Z")
+	if s == nil || s.Enrolled || len(s.Queue) != 0 {
+		t.Errorf("before enrollment: %+v", s)
+	}
+
+	for target, code := range map[string]int{
+		"DEV1":            http.StatusBadRequest,
+		"DEV1?at=bogus":   http.StatusBadRequest,
+		"?at=2024-01-02Z": http.StatusBadRequest,
+	} {
+		if _, have := state(target); have != code {
+			t.Errorf("%s: have %d, want %d", target, have, code)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// jobResult is a job and, optionally, the status of each of its targets.
+type jobResult struct {
+	*storage.Job
+	Targets map[string]string `json:"targets,omitempty"`
+}
+
+// JobHandler returns the progress of a bulk enqueue or push job as JSON.
+// The status of each targeted enrollment is included if the "targets"
+// query parameter is set.
+//
+// Note the whole URL path is used as the job ID. This probably
+// necessitates stripping the URL prefix before using.
+func JobHandler(store storage.JobStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		jobID := r.URL.Path
+		if jobID == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		logger = logger.With("job_id", jobID)
+		job, err := store.RetrieveJob(ctx, jobID)
+		if err != nil {
+			logger.Info("msg", "retrieving job", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if job == nil {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		output := &jobResult{Job: job}
+		if r.URL.Query().Get("targets") != "" {
+			if output.Targets, err = store.RetrieveJobTargets(ctx, jobID); err != nil {
+				logger.Info("msg", "retrieving job targets", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/longpoll"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const (
+	longPollDefaultTimeout = 30 * time.Second
+	longPollMaxTimeout     = 5 * time.Minute
+)
+
+// LongPollHandler waits for a (development) push notification to an
+// enrollment. It responds with HTTP 200 and a JSON object when the
+// enrollment is pushed to or with HTTP 204 (No Content) if the timeout
+// elapses. The "timeout" query parameter is a Go duration string.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func LongPollHandler(notifier *longpoll.Notifier, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		if id == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		timeout := longPollDefaultTimeout
+		if v := r.URL.Query().Get("timeout"); v != "" {
+			var err error
+			if timeout, err = time.ParseDuration(v); err != nil || timeout <= 0 {
+				logger.Info("msg", "parsing timeout", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if timeout > longPollMaxTimeout {
+				timeout = longPollMaxTimeout
+			}
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		if !notifier.Wait(ctx, id) {
+			logger.Debug("msg", "long poll timeout")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		logger.Debug("msg", "long poll notified")
+		json, err := json.MarshalIndent(&struct {
+			ID       string `json:"id"`
+			Notified bool   `json:"notified"`
+		}{ID: id, Notified: true}, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// MaintenanceSwitch turns maintenance mode on and off.
+type MaintenanceSwitch interface {
+	SetMaintenance(on bool)
+	Maintenance() bool
+}
+
+// MaintenanceHandler reports (GET), turns on (PUT), and turns off
+// (DELETE) maintenance mode as JSON.
+func MaintenanceHandler(sw MaintenanceSwitch, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			sw.SetMaintenance(true)
+			logger.Info("msg", "maintenance mode on")
+		case http.MethodDelete:
+			sw.SetMaintenance(false)
+			logger.Info("msg", "maintenance mode off")
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(struct {
+			Maintenance bool `json:"maintenance"`
+		}{sw.Maintenance()}, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// EnrollmentMetadataHandler retrieves (HTTP GET) or replaces (HTTP PUT)
+// the metadata of an enrollment as JSON.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func EnrollmentMetadataHandler(store storage.EnrollmentMetadataStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		if id == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		var meta *storage.EnrollmentMetadata
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			meta, err = store.RetrieveEnrollmentMetadata(ctx, id)
+			if err != nil {
+				logger.Info("msg", "retrieving enrollment metadata", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if meta == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+		case http.MethodPut:
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			meta = new(storage.EnrollmentMetadata)
+			if err = json.Unmarshal(b, meta); err != nil {
+				logger.Info("msg", "decoding enrollment metadata", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if err = store.StoreEnrollmentMetadata(ctx, id, meta); err != nil {
+				logger.Info("msg", "storing enrollment metadata", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			logger.Debug("msg", "stored enrollment metadata")
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(meta, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
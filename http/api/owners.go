@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// CommandOwnerHandler stores the owner in the "owner" query parameter
+// of the enqueued command in the request body before calling next
+// (typically the enqueue handler). Owners are checked with valid and
+// unknown owners are rejected. Requests without an owner are passed
+// to next as-is.
+func CommandOwnerHandler(next http.Handler, store storage.CommandOwnerStore, valid func(string) bool, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		owner := r.URL.Query().Get("owner")
+		if owner == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		logger = logger.With("owner", owner)
+		if !valid(owner) {
+			logger.Info("msg", "unknown command owner")
+			http.Error(w, "unknown owner", http.StatusBadRequest)
+			return
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		command, err := mdm.DecodeCommand(b)
+		if err != nil {
+			logger.Info("msg", "decoding command", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		// store the owner before enqueueing so that results of
+		// commands that are quickly answered find their owner
+		if err = store.StoreCommandOwner(ctx, command.CommandUUID, owner); err != nil {
+			logger.Info("msg", "storing command owner", "command_uuid", command.CommandUUID, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
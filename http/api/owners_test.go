@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestCommandOwnerHandler(t *testing.T) {
+	owners := make(map[string]string)
+	store := &mock.Storage{
+		StoreCommandOwnerFunc: func(_ context.Context, uuid, owner string) error {
+			owners[uuid] = owner
+			return nil
+		},
+	}
+	var body string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	})
+	h := CommandOwnerHandler(next, store, func(owner string) bool { return owner == "automation" }, log.NopLogger)
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		owner  string
+	}{
+		{"", http.StatusOK, ""},
+		{"?owner=other", http.StatusBadRequest, ""},
+		{"?owner=automation", http.StatusOK, "automation"},
+	} {
+		body = ""
+		delete(owners, "lock-1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/DEV1"+tc.query, strings.NewReader(lockCommand)))
+		if w.Code != tc.status {
+			t.Errorf("%q: status: have %d, want %d", tc.query, w.Code, tc.status)
+		}
+		if have := owners["lock-1"]; have != tc.owner {
+			t.Errorf("%q: owner: have %q, want %q", tc.query, have, tc.owner)
+		}
+		if tc.status == http.StatusOK && body != lockCommand {
+			t.Errorf("%q: body not passed to next handler", tc.query)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/http/audit"
+	"github.com/micromdm/nanomdm/http/policy"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// pendingResult is a pending command with its command plist as a string.
+type pendingResult struct {
+	*storage.PendingCommand
+	Command string `json:"command,omitempty"`
+}
+
+func newPendingResult(cmd *storage.PendingCommand) *pendingResult {
+	return &pendingResult{PendingCommand: cmd, Command: string(cmd.Command)}
+}
+
+// parsePendingOptions sets the enqueue options of cmd from the query
+// parameters of the enqueue request r.
+func parsePendingOptions(r *http.Request, cmd *storage.PendingCommand) error {
+	q := r.URL.Query()
+	cmd.NoPush = q.Get("nopush") != ""
+	cmd.Template, cmd.Locale, cmd.Owner = q.Get("template"), q.Get("locale"), q.Get("owner")
+	for _, o := range []struct {
+		name string
+		t    **time.Time
+	}{
+		{"expires", &cmd.ExpiresAt},
+		{"not_before", &cmd.NotBefore},
+	} {
+		if v := q.Get(o.name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return fmt.Errorf("parsing %s: %w", o.name, err)
+			}
+			*o.t = &t
+		}
+	}
+	if v := q.Get("priority"); v != "" {
+		var err error
+		if cmd.Priority, err = parsePriority(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingQuery returns the enqueue query parameters of the options of cmd.
+func pendingQuery(cmd *storage.PendingCommand) url.Values {
+	q := url.Values{}
+	if cmd.NoPush {
+		q.Set("nopush", "1")
+	}
+	if cmd.ExpiresAt != nil {
+		q.Set("expires", cmd.ExpiresAt.Format(time.RFC3339))
+	}
+	if cmd.NotBefore != nil {
+		q.Set("not_before", cmd.NotBefore.Format(time.RFC3339))
+	}
+	if cmd.Priority != 0 {
+		q.Set("priority", strconv.Itoa(cmd.Priority))
+	}
+	for k, v := range map[string]string{"template": cmd.Template, "locale": cmd.Locale, "owner": cmd.Owner} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	return q
+}
+
+func writePendingJSON(w http.ResponseWriter, status int, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// PendingEnqueueHandler stores the enqueued command in the request body
+// as a pending command awaiting approval instead of enqueueing it. The
+// URL path is the comma-separated enqueue targets which probably
+// necessitates stripping the URL prefix before using. The requester is
+// the HTTP basic auth username. The enqueue options in the query
+// parameters are stored with the command to be used once approved. Only
+// PUT and POST requests are accepted.
+func PendingEnqueueHandler(store storage.PendingCommandStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		command, err := mdm.DecodeCommand(b)
+		if err != nil {
+			logger.Info("msg", "decoding command", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		cmd := &storage.PendingCommand{
+			CommandUUID: command.CommandUUID,
+			RequestType: command.Command.RequestType,
+			Targets:     ids,
+			Command:     command.Raw,
+			CreatedAt:   time.Now().UTC().Truncate(time.Second),
+		}
+		if err = parsePendingOptions(r, cmd); err != nil {
+			logger.Info("msg", "parsing enqueue options", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cmd.RequestedBy, _, _ = r.BasicAuth()
+		if err = store.StorePendingCommand(ctx, cmd); err != nil {
+			logger.Info("msg", "storing pending command", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Info(
+			"msg", "command pending approval",
+			"command_uuid", cmd.CommandUUID,
+			"request_type", cmd.RequestType,
+			"requested_by", cmd.RequestedBy,
+		)
+		writePendingJSON(w, http.StatusAccepted, newPendingResult(cmd), logger)
+	}
+}
+
+// PendingCommandsHandler lists, approves, and rejects pending commands.
+// The URL path is the command UUID which probably necessitates stripping
+// the URL prefix before using. A POST approves the pending command and
+// passes it with its stored enqueue options to enqueue (typically the
+// enqueue handler) whose response is returned. The approver (the HTTP basic auth username) must differ from
+// the requester. A DELETE rejects the pending command.
+func PendingCommandsHandler(store storage.PendingCommandStore, enqueue http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if uuid != "" {
+			logger = logger.With("command_uuid", uuid)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if uuid == "" {
+				cmds, err := store.RetrievePendingCommands(ctx)
+				if err != nil {
+					logger.Info("msg", "retrieving pending commands", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				output := make([]*pendingResult, len(cmds))
+				for i, cmd := range cmds {
+					output[i] = newPendingResult(cmd)
+				}
+				writePendingJSON(w, http.StatusOK, output, logger)
+				return
+			}
+			cmd, err := store.RetrievePendingCommand(ctx, uuid)
+			if err != nil {
+				logger.Info("msg", "retrieving pending command", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if cmd == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			writePendingJSON(w, http.StatusOK, newPendingResult(cmd), logger)
+		case http.MethodPost, http.MethodDelete:
+			if uuid == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			cmd, err := store.RetrievePendingCommand(ctx, uuid)
+			if err != nil {
+				logger.Info("msg", "retrieving pending command", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if cmd == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			if e := audit.EntryFromContext(ctx); e != nil {
+				e.RequestType, e.CommandUUID = cmd.RequestType, cmd.CommandUUID
+			}
+			user, _, _ := r.BasicAuth()
+			if r.Method == http.MethodPost && (user == "" || user == cmd.RequestedBy) {
+				logger.Info("msg", "approving own command", "user", user)
+				http.Error(w, "command must be approved by another user", http.StatusForbidden)
+				return
+			}
+			// claim the command so that it is only approved or rejected once
+			if deleted, err := store.DeletePendingCommand(ctx, uuid); err != nil {
+				logger.Info("msg", "deleting pending command", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			} else if !deleted {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			if r.Method == http.MethodDelete {
+				logger.Info("msg", "rejected pending command", "user", user)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			logger.Info("msg", "approved pending command", "user", user, "requested_by", cmd.RequestedBy)
+			// mark the command approved so that it is not held again
+			r2 := r.Clone(policy.NewContextWithApproval(ctx))
+			r2.Method = http.MethodPut
+			r2.URL.Path = strings.Join(cmd.Targets, ",")
+			r2.URL.RawQuery = pendingQuery(cmd).Encode()
+			r2.Body = io.NopCloser(bytes.NewReader(cmd.Command))
+			r2.ContentLength = int64(len(cmd.Command))
+			enqueue.ServeHTTP(w, r2)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const lockCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>lock-1</string>
+</dict>
+</plist>`
+
+func TestPendingCommands(t *testing.T) {
+	pending := make(map[string]*storage.PendingCommand)
+	store := &mock.Storage{
+		StorePendingCommandFunc: func(_ context.Context, cmd *storage.PendingCommand) error {
+			pending[cmd.CommandUUID] = cmd
+			return nil
+		},
+		RetrievePendingCommandFunc: func(_ context.Context, uuid string) (*storage.PendingCommand, error) {
+			return pending[uuid], nil
+		},
+		DeletePendingCommandFunc: func(_ context.Context, uuid string) (bool, error) {
+			_, ok := pending[uuid]
+			delete(pending, uuid)
+			return ok, nil
+		},
+	}
+
+	r := httptest.NewRequest("GET", "/ID1,ID2", strings.NewReader(lockCommand))
+	r.URL.Path = "ID1,ID2" // as stripped
+	rec := httptest.NewRecorder()
+	PendingEnqueueHandler(store, log.NopLogger).ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusMethodNotAllowed; have != want {
+		t.Errorf("get status: have %d, want %d", have, want)
+	}
+	if have, want := len(pending), 0; have != want {
+		t.Fatalf("pending: have %d, want %d", have, want)
+	}
+
+	r = httptest.NewRequest("PUT", "/ID1,ID2?priority=invalid", strings.NewReader(lockCommand))
+	r.URL.Path = "ID1,ID2" // as stripped
+	rec = httptest.NewRecorder()
+	PendingEnqueueHandler(store, log.NopLogger).ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusBadRequest; have != want {
+		t.Errorf("invalid priority status: have %d, want %d", have, want)
+	}
+
+	r = httptest.NewRequest("PUT", "/ID1,ID2?nopush=1&priority=high&expires=2030-01-02T03:04:05Z&owner=helpdesk", strings.NewReader(lockCommand))
+	r.URL.Path = "ID1,ID2" // as stripped
+	r.SetBasicAuth("alice", "secret")
+	rec = httptest.NewRecorder()
+	PendingEnqueueHandler(store, log.NopLogger).ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusAccepted; have != want {
+		t.Fatalf("status: have %d, want %d", have, want)
+	}
+
+	var enqueued string
+	var query url.Values
+	enqueue := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		enqueued = r.URL.Path + " " + string(b)
+		query = r.URL.Query()
+	})
+	handler := PendingCommandsHandler(store, enqueue, log.NopLogger)
+	for _, test := range []struct {
+		user, method string
+		code         int
+	}{
+		{"alice", "POST", http.StatusForbidden},
+		{"bob", "POST", http.StatusOK},
+		{"bob", "POST", http.StatusNotFound},
+		{"bob", "DELETE", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(test.method, "/lock-1", nil)
+		r.URL.Path = "lock-1"
+		r.SetBasicAuth(test.user, "secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != test.code {
+			t.Errorf("%s %s: have %d, want %d", test.user, test.method, rec.Code, test.code)
+		}
+	}
+	if !strings.HasPrefix(enqueued, "ID1,ID2 ") || !strings.Contains(enqueued, "DeviceLock") {
+		t.Errorf("unexpected enqueue: %s", enqueued)
+	}
+	// the approved command keeps its enqueue options
+	for k, want := range map[string]string{
+		"nopush":   "1",
+		"priority": "10",
+		"expires":  "2030-01-02T03:04:05Z",
+		"owner":    "helpdesk",
+	} {
+		if have := query.Get(k); have != want {
+			t.Errorf("enqueue %s: have %q, want %q", k, have, want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/certexpiry"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// PushCertsHandler returns the expiry status of all stored push
+// certificates as JSON.
+func PushCertsHandler(store certexpiry.Store, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		statuses, err := certexpiry.Check(ctx, store, time.Now())
+		if err != nil {
+			logger.Info("msg", "checking push certs", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		json, err := json.MarshalIndent(statuses, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
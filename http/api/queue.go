@@ -0,0 +1,138 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// queueSnapshotDefaultResults is the default number of commands with
+// results (other than NotNow) included in a queue snapshot.
+const queueSnapshotDefaultResults = 50
+
+// QueueSnapshot is a portable snapshot of the command queue of an
+// enrollment for debugging. Commands are in queue order.
+type QueueSnapshot struct {
+	EnrollmentID string              `json:"enrollment_id"`
+	Enrollment   *storage.Enrollment `json:"enrollment,omitempty"`
+	Commands     []*SnapshotCommand  `json:"commands"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+// SnapshotCommand is a queued command of a queue snapshot.
+type SnapshotCommand struct {
+	*storage.QueuedCommand
+
+	// ErrorCategory is the error code knowledge base category of the
+	// error chain of an Error result, if known.
+	ErrorCategory errorkb.Category `json:"error_category,omitempty"`
+}
+
+// snapshotCommands returns cmds as snapshot commands with the Error
+// results classified by kb (if not nil).
+func snapshotCommands(cmds []*storage.QueuedCommand, kb *errorkb.KB) []*SnapshotCommand {
+	ret := make([]*SnapshotCommand, len(cmds))
+	for i, cmd := range cmds {
+		ret[i] = &SnapshotCommand{QueuedCommand: cmd}
+		if kb == nil || cmd.Status != "Error" || len(cmd.Result) < 1 {
+			continue
+		}
+		if results, err := mdm.DecodeCommandResults(cmd.Result); err == nil {
+			ret[i].ErrorCategory = kb.Classify(results.ErrorChain)
+		}
+	}
+	return ret
+}
+
+// QueueSnapshotStore retrieves enrollments and their command queues.
+type QueueSnapshotStore interface {
+	storage.EnrollmentRetriever
+	storage.QueueRetriever
+}
+
+// recentResults returns cmds with only the last n commands that have
+// results other than NotNow.
+func recentResults(cmds []*storage.QueuedCommand, n int) []*storage.QueuedCommand {
+	var completed int
+	for _, cmd := range cmds {
+		if cmd.Status != "" && cmd.Status != "NotNow" {
+			completed++
+		}
+	}
+	ret := make([]*storage.QueuedCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		if cmd.Status != "" && cmd.Status != "NotNow" {
+			completed--
+			if completed >= n {
+				continue
+			}
+		}
+		ret = append(ret, cmd)
+	}
+	return ret
+}
+
+// QueueSnapshotHandler returns a JSON snapshot of the command queue of
+// an enrollment including the raw commands and results. The number of
+// commands with results (which are retained depending on the storage
+// backend) is limited by the "results" query parameter. Error results
+// are classified by kb if it is not nil.
+//
+// Note the whole URL path is used as the enrollment ID. This probably
+// necessitates stripping the URL prefix before using.
+func QueueSnapshotHandler(store QueueSnapshotStore, kb *errorkb.KB, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{r.URL.Path}, logger)
+		results := queueSnapshotDefaultResults
+		if v := r.URL.Query().Get("results"); v != "" {
+			var err error
+			if results, err = strconv.Atoi(v); err != nil || results < 0 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+		snapshot := &QueueSnapshot{EnrollmentID: r.URL.Path, CreatedAt: time.Now().UTC()}
+		enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{snapshot.EnrollmentID}})
+		if err != nil {
+			logger.Info("msg", "retrieving enrollment", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if len(enrollments) > 0 {
+			snapshot.Enrollment = enrollments[0]
+		}
+		cmds, err := store.RetrieveQueue(ctx, snapshot.EnrollmentID)
+		if err != nil {
+			logger.Info("msg", "retrieving queue", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		snapshot.Commands = snapshotCommands(recentResults(cmds, results), kb)
+		logger.Debug("msg", "queue snapshot", "commands", len(snapshot.Commands))
+		json, err := json.MarshalIndent(snapshot, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
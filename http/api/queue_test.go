@@ -0,0 +1,88 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const errorResult = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>CMD2</string>
+	<key>ErrorChain</key>
+	<array>
+		<dict>
+			<key>ErrorCode</key>
+			<integer>-1009</integer>
+			<key>ErrorDomain</key>
+			<string>NSURLErrorDomain</string>
+		</dict>
+	</array>
+	<key>Status</key>
+	<string>Error</string>
+	<key>UDID</key>
+	<string>DEV1</string>
+</dict>
+</plist>
+`
+
+func TestQueueSnapshotHandler(t *testing.T) {
+	store := new(mock.Storage)
+	store.RetrieveEnrollmentsFunc = func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		return []*storage.Enrollment{{ID: filter.IDs[0], Enabled: true}}, nil
+	}
+	store.RetrieveQueueFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		return []*storage.QueuedCommand{
+			{CommandUUID: "CMD1", Status: "Acknowledged", Active: true},
+			{CommandUUID: "CMD2", Status: "Error", Active: true, Result: []byte(errorResult)},
+			{CommandUUID: "CMD3", Status: "NotNow", Active: true},
+			{CommandUUID: "CMD4", Active: true},
+		}, nil
+	}
+	h := http.StripPrefix(EndpointQueue, QueueSnapshotHandler(store, errorkb.Default(), log.NopLogger))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", EndpointQueue+"DEV1?results=1", nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("status: have %d, want %d", have, want)
+	}
+	snapshot := new(QueueSnapshot)
+	if err := json.Unmarshal(rec.Body.Bytes(), snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.EnrollmentID != "DEV1" || snapshot.Enrollment == nil {
+		t.Errorf("unexpected snapshot enrollment: %+v", snapshot)
+	}
+	var uuids []string
+	for _, cmd := range snapshot.Commands {
+		uuids = append(uuids, cmd.CommandUUID)
+	}
+	if have, want := len(uuids), 3; have != want || uuids[0] != "CMD2" {
+		t.Fatalf("commands: have %v, want CMD2, CMD3, CMD4", uuids)
+	}
+	if have, want := snapshot.Commands[0].ErrorCategory, errorkb.Retryable; have != want {
+		t.Errorf("error category: have %q, want %q", have, want)
+	}
+
+	for target, code := range map[string]int{
+		EndpointQueue:                    http.StatusBadRequest,
+		EndpointQueue + "DEV1?results=x": http.StatusBadRequest,
+	} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if have := rec.Code; have != code {
+			t.Errorf("%s: have %d, want %d", target, have, code)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// QueuedCommandsHandler retrieves (HTTP GET) the commands waiting in
+// the queue of an enrollment or cancels (HTTP DELETE) one of them
+// before the enrollment retrieves it. The URL path is the enrollment ID
+// for a GET and the enrollment ID and command UUID separated by a slash
+// for a DELETE which probably necessitates stripping the URL prefix
+// before using. The queued commands are returned as a JSON array in
+// queue order. A DELETE of a command that is not waiting in the queue
+// (e.g. it already has a result) returns a 404.
+func QueuedCommandsHandler(store storage.QueuedCommandStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, uuid, _ := strings.Cut(r.URL.Path, "/")
+		if id == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		switch r.Method {
+		case http.MethodGet:
+			if uuid != "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			if uuid == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			logger = logger.With("command_uuid", uuid)
+			canceled, err := store.CancelQueuedCommand(ctx, id, uuid)
+			if err != nil {
+				logger.Info("msg", "canceling queued command", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !canceled {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "canceled queued command", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		cmds, err := store.RetrieveQueuedCommands(ctx, id)
+		if err != nil {
+			logger.Info("msg", "retrieving queued commands", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if cmds == nil {
+			cmds = []*storage.QueuedCommand{}
+		}
+		json, err := json.MarshalIndent(cmds, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
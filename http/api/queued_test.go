@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestQueuedCommandsHandler(t *testing.T) {
+	store := new(mock.Storage)
+	store.RetrieveQueuedCommandsFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		return []*storage.QueuedCommand{
+			{CommandUUID: "CMD1", Active: true, Priority: 10},
+			{CommandUUID: "CMD2", Active: true, Status: "NotNow"},
+		}, nil
+	}
+	store.CancelQueuedCommandFunc = func(_ context.Context, id, uuid string) (bool, error) {
+		return id == "DEV1" && uuid == "CMD1", nil
+	}
+	h := http.StripPrefix(EndpointQueued, QueuedCommandsHandler(store, log.NopLogger))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(method, EndpointQueued+path, nil))
+		return rec
+	}
+
+	rec := serve(http.MethodGet, "DEV1")
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("status: have %d, want %d", have, want)
+	}
+	var cmds []*storage.QueuedCommand
+	if err := json.Unmarshal(rec.Body.Bytes(), &cmds); err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 || cmds[0].CommandUUID != "CMD1" || cmds[1].Status != "NotNow" {
+		t.Errorf("unexpected commands: %v", cmds)
+	}
+
+	for _, tc := range []struct {
+		method, path string
+		code         int
+	}{
+		{http.MethodGet, "", http.StatusBadRequest},
+		{http.MethodGet, "DEV1/CMD1", http.StatusBadRequest},
+		{http.MethodDelete, "DEV1", http.StatusBadRequest},
+		{http.MethodDelete, "DEV1/CMD2", http.StatusNotFound},
+		{http.MethodDelete, "DEV1/CMD1", http.StatusNoContent},
+		{http.MethodPut, "DEV1/CMD1", http.StatusMethodNotAllowed},
+	} {
+		if have, want := serve(tc.method, tc.path).Code, tc.code; have != want {
+			t.Errorf("%s %s: have %d, want %d", tc.method, tc.path, have, want)
+		}
+	}
+}
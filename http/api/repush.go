@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/repush"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// RepushHandler starts (HTTP POST), retrieves (HTTP GET), and cancels
+// (HTTP DELETE) the repush of every enrollment with queued commands.
+// A POST pushes in batches of the "batch" query parameter (default
+// repush.DefaultBatchSize) waiting the "interval" query parameter (a Go
+// duration, default repush.DefaultInterval) between batches. The status
+// of the running or latest repush is returned as JSON.
+func RepushHandler(repusher *repush.Repusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		var status *repush.Status
+		var err error
+		switch r.Method {
+		case http.MethodPost:
+			batch := repush.DefaultBatchSize
+			if v := r.URL.Query().Get("batch"); v != "" {
+				if batch, err = strconv.Atoi(v); err != nil {
+					http.Error(w, "invalid batch", http.StatusBadRequest)
+					return
+				}
+			}
+			interval := repush.DefaultInterval
+			if v := r.URL.Query().Get("interval"); v != "" {
+				if interval, err = time.ParseDuration(v); err != nil {
+					http.Error(w, "invalid interval", http.StatusBadRequest)
+					return
+				}
+			}
+			if status, err = repusher.Start(r.Context(), batch, interval); err == nil {
+				user, _, _ := r.BasicAuth()
+				logger.Info("msg", "started repush", "repush_id", status.ID, "count", status.Total, "user", user)
+			}
+		case http.MethodGet:
+			if status = repusher.Status(); status == nil {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+		case http.MethodDelete:
+			if err = repusher.Cancel(); err == nil {
+				logger.Debug("msg", "canceled repush")
+				status = repusher.Status()
+			}
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if errors.Is(err, repush.ErrInvalidRate) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, repush.ErrRunning) || errors.Is(err, repush.ErrNotRunning) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			logger.Info("msg", "repush", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		json, err := json.MarshalIndent(status, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
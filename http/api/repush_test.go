@@ -0,0 +1,52 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/repush"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestRepushHandler(t *testing.T) {
+	store := new(mock.Storage)
+	store.RetrieveQueuedEnrollmentsFunc = func(context.Context) ([]string, error) {
+		return []string{"DEV1", "DEV2"}, nil
+	}
+	pusher := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		resps := make(map[string]*push.Response)
+		for _, id := range ids {
+			resps[id] = &push.Response{Id: "push-" + id}
+		}
+		return resps, nil
+	})
+	h := RepushHandler(repush.New(store, pusher), log.NopLogger)
+
+	for _, tc := range []struct {
+		method string
+		query  string
+		status int
+	}{
+		{http.MethodGet, "", http.StatusNotFound},
+		{http.MethodDelete, "", http.StatusConflict},
+		{http.MethodPost, "?batch=x", http.StatusBadRequest},
+		{http.MethodPost, "?batch=0", http.StatusBadRequest},
+		{http.MethodPost, "?interval=-1s", http.StatusBadRequest},
+		{http.MethodPost, "?batch=1&interval=1h", http.StatusOK},
+		{http.MethodPost, "", http.StatusConflict},
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodDelete, "", http.StatusOK},
+		{http.MethodPut, "", http.StatusMethodNotAllowed},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(tc.method, EndpointRepush+tc.query, nil))
+		if have, want := w.Code, tc.status; have != want {
+			t.Errorf("%s %q: status: have %d, want %d", tc.method, tc.query, have, want)
+		}
+	}
+}
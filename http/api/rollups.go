@@ -0,0 +1,77 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// rollupsDefaultPeriods is the number of periods returned by default.
+const rollupsDefaultPeriods = 48
+
+// rollupResult is a metrics rollup with its computed error rate.
+type rollupResult struct {
+	*storage.MetricsRollup
+	ErrorRate float64 `json:"error_rate"`
+}
+
+// RollupsHandler returns the stored metrics rollups as JSON. The
+// "period" query parameter selects "hour" (the default) or "day"
+// rollups. Rollups starting at or after the RFC 3339 "since" query
+// parameter are returned, defaulting to the last 48 periods.
+func RollupsHandler(store storage.MetricsRollupStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		period := r.URL.Query().Get("period")
+		var periodLen time.Duration
+		switch period {
+		case "", storage.RollupHour:
+			period = storage.RollupHour
+			periodLen = time.Hour
+		case storage.RollupDay:
+			periodLen = 24 * time.Hour
+		default:
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		since := time.Now().UTC().Truncate(periodLen).Add(-(rollupsDefaultPeriods - 1) * periodLen)
+		if v := r.URL.Query().Get("since"); v != "" {
+			var err error
+			if since, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+		}
+		rollups, err := store.RetrieveMetricsRollups(ctx, period, since)
+		if err != nil {
+			logger.Info("msg", "retrieving metrics rollups", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		output := make([]*rollupResult, len(rollups))
+		for i, rollup := range rollups {
+			output[i] = &rollupResult{MetricsRollup: rollup}
+			if rollup.Commands > 0 {
+				output[i].ErrorRate = float64(rollup.Errors) / float64(rollup.Commands)
+			}
+		}
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package api
+
+import (
+	"expvar"
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/http/rbac"
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/campaign"
+	"github.com/micromdm/nanomdm/push/longpoll"
+	"github.com/micromdm/nanomdm/push/pushstats"
+	"github.com/micromdm/nanomdm/push/repush"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	"github.com/micromdm/nanomdm/service/smartgroup"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// API endpoint paths.
+const (
+	EndpointPushCert     = "/v1/pushcert"
+	EndpointPushCerts    = "/v1/pushcerts"
+	EndpointTopicStats   = "/v1/topicstats"
+	EndpointSummary      = "/v1/summary"
+	EndpointPush         = "/v1/push/"
+	EndpointEnqueue      = "/v1/enqueue/"
+	EndpointDMEnablement = "/v1/dmenablement/"
+	EndpointDDMStatus    = "/v1/ddmstatus/"
+	EndpointMetadata     = "/v1/metadata/"
+	EndpointEnrollments  = "/v1/enrollments/"
+	EndpointResolve      = "/v1/resolve/"
+	EndpointUserChannels = "/v1/userchannels/"
+	EndpointUserSessions = "/v1/usersessions/"
+	EndpointDisable      = "/v1/disable/"
+	EndpointMaintenance  = "/v1/maintenance"
+	EndpointCampaigns    = "/v1/campaigns/"
+	EndpointRepush       = "/v1/repush"
+	EndpointJobs         = "/v1/jobs/"
+	EndpointEvents       = "/v1/events"
+	EndpointEventLog     = "/v1/eventlog"
+	EndpointHistory      = "/v1/history/"
+	EndpointRollups      = "/v1/rollups"
+	EndpointAPIKeys      = "/v1/apikeys/"
+	EndpointRoles        = "/v1/roles"
+	EndpointPending      = "/v1/pending/"
+	EndpointFreeze       = "/v1/freeze/"
+	EndpointEvict        = "/v1/evict/"
+	EndpointTombstones   = "/v1/tombstones/"
+	EndpointWebhooks     = "/v1/webhookdeliveries/"
+	EndpointTemplates    = "/v1/templates/"
+	EndpointDeclarations = "/v1/declarations/"
+	EndpointDeclSets     = "/v1/declarationsets/"
+	EndpointEnrollSets   = "/v1/enrollmentsets/"
+	EndpointUnlockTokens = "/v1/unlocktokens/"
+	EndpointUnlockReqs   = "/v1/unlocktokenrequests/"
+	EndpointClearPass    = "/v1/clearpasscode/"
+	EndpointSupersede    = "/v1/supersede/"
+	EndpointGroups       = "/v1/groups/"
+	EndpointExport       = "/v1/export/"
+	EndpointQueue        = "/v1/queue/"
+	EndpointQueued       = "/v1/queuedcommands/"
+	EndpointDevWait      = "/v1/dev/wait/"
+	EndpointDebugTargets = "/v1/debugtargets/"
+	EndpointPlistToJSON  = "/v1/convert/json"
+	EndpointJSONToPlist  = "/v1/convert/plist"
+	EndpointCapabilities = "/v1/capabilities"
+	EndpointEnrollProf   = "/v1/enrollmentprofile"
+	EndpointMigration    = "/migration"
+	EndpointMetrics      = "/debug/vars"
+)
+
+// Handlers are the dependencies of the API endpoints. Store and Pusher
+// are required. Endpoints of optional dependencies that are not set
+// are not registered.
+type Handlers struct {
+	Store  storage.AllStorage
+	Pusher push.Pusher
+
+	// PushStats adds push statistics to the topic stats and fleet
+	// summary endpoints.
+	PushStats *pushstats.Recorder
+
+	// Jobs tracks enqueue and push operations as jobs and enables the
+	// job progress endpoint.
+	Jobs storage.JobStore
+
+	// Metadata enables the enrollment metadata and groups endpoints,
+	// targeting campaigns to groups, and the group variants of message
+	// templates.
+	Metadata storage.EnrollmentMetadataStore
+
+	// Inventory enables the fleet summary and supersession endpoints
+	// and the inventory export.
+	Inventory storage.InventoryStore
+
+	// Queue enables the queue snapshot endpoint.
+	Queue storage.QueueRetriever
+
+	// UserSessions enables the user sessions endpoint.
+	UserSessions storage.UserSessionStore
+
+	// Rollups enables the metrics rollups endpoint.
+	Rollups storage.MetricsRollupStore
+
+	// Supersessions enables, with Metadata and Inventory, the
+	// enrollment supersession endpoint.
+	Supersessions storage.EnrollmentSupersessionStore
+
+	// Maintenance enables the command poll maintenance mode endpoint.
+	Maintenance MaintenanceSwitch
+
+	// Campaigns enables the push campaigns endpoint.
+	Campaigns *campaign.Manager
+
+	// Repush enables the endpoint that pushes to every enrollment with
+	// queued commands.
+	Repush *repush.Repusher
+
+	// Events enables the webhook event stream endpoint.
+	Events *microwebhook.Broker
+
+	// EventLog enables the event log endpoint, the enrollment history
+	// endpoint (with Queue), and the command history export.
+	EventLog storage.EventLogStore
+
+	// LongPoll enables the development long-poll endpoint.
+	LongPoll *longpoll.Notifier
+
+	// Migration enables the migration endpoint which takes check-in
+	// messages without certificate authentication.
+	Migration service.Checkin
+
+	// RBAC enables the role endpoint and, with APIKeys, the API key
+	// endpoint.
+	RBAC    *rbac.Authorizer
+	APIKeys storage.APIKeyStore
+
+	// Pending enables the pending-approval commands endpoint.
+	Pending storage.PendingCommandStore
+
+	// Freeze enables the enrollment freeze endpoint.
+	Freeze storage.EnrollmentFreezeStore
+
+	// Evict enables the enrollment eviction endpoint.
+	Evict storage.EnrollmentEvictionStore
+
+	// Tombstones enables the enrollment tombstone endpoint which
+	// departs (purges) enrollments.
+	Tombstones storage.EnrollmentTombstoneStore
+
+	// Webhooks enables the webhook deliveries (dead-letter) endpoint.
+	Webhooks storage.WebhookDeliveryStore
+
+	// Templates enables the message templates endpoint and the
+	// "template" query parameter of the enqueue endpoint.
+	Templates storage.MessageTemplateStore
+
+	// Declarations enables the Declarative Management declarations,
+	// declaration sets, and enrollment sets endpoints.
+	Declarations storage.DeclarationStore
+
+	// UnlockTokens enables the approval-gated UnlockToken retrieval
+	// endpoints and the ClearPasscode endpoint.
+	UnlockTokens storage.UnlockTokenStore
+
+	// DDMStatus enables the Declarative Management status reports
+	// endpoint.
+	DDMStatus storage.DDMStatusStore
+
+	// QueuedCommands enables the queued commands endpoint.
+	QueuedCommands storage.QueuedCommandStore
+
+	// SmartGroups adds smart groups to the groups endpoint.
+	SmartGroups *smartgroup.Evaluator
+
+	// CommandOwners enables, with CommandOwnerStore, the "owner" query
+	// parameter of the enqueue endpoint for the owners it reports as
+	// valid.
+	CommandOwners     func(owner string) bool
+	CommandOwnerStore storage.CommandOwnerStore
+
+	// EnqueueMiddleware wraps the handler that every command is
+	// enqueued through (including by the ClearPasscode and pending
+	// commands endpoints and EnqueueHandler), for example to check
+	// commands against a policy. The URL path it sees is the
+	// comma-separated enqueue targets.
+	EnqueueMiddleware []mdmhttp.Middleware
+
+	// ErrorKB classifies the Error results of the queue snapshot
+	// endpoint.
+	ErrorKB *errorkb.KB
+
+	// EnrollProfile serves enrollment profiles for the MDM endpoints
+	// (see the http/mdm EnrollmentProfileHandler).
+	EnrollProfile http.Handler
+
+	// DebugTargets enables the per-enrollment debug logging endpoint.
+	DebugTargets DebugTargets
+
+	// Metrics enables the expvar metrics endpoint.
+	Metrics bool
+
+	Logger log.Logger
+}
+
+// EnqueueHandler returns the handler that every command is enqueued
+// through for use by other APIs (such as the MicroMDM compatible API).
+// The URL path is the comma-separated enqueue targets.
+func (h *Handlers) EnqueueHandler() http.Handler {
+	logger := h.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	var handler http.Handler = RawCommandEnqueueHandler(h.Store, h.Pusher, h.Jobs, h.Store, logger.With("handler", "enqueue"))
+	handler = mdmhttp.Chain(handler, h.EnqueueMiddleware...)
+	if h.CommandOwners != nil && h.CommandOwnerStore != nil {
+		handler = CommandOwnerHandler(handler, h.CommandOwnerStore, h.CommandOwners, logger.With("handler", "command-owner"))
+	}
+	if h.Templates != nil {
+		handler = MessageTemplateHandler(handler, h.Templates, h.Metadata, logger.With("handler", "message-template"))
+	}
+	return handler
+}
+
+// Register registers the API endpoints on mux. Endpoint paths are
+// prefixed with prefix (which should not have a trailing slash) and
+// every handler is wrapped with middleware (typically authentication).
+func (h *Handlers) Register(mux mdmhttp.Mux, prefix string, middleware ...mdmhttp.Middleware) {
+	logger := h.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+
+	// handle registers handler at endpoint. With strip the endpoint is
+	// stripped from the path to use the remainder as an id.
+	handle := func(endpoint string, strip bool, handler http.Handler) {
+		if strip {
+			handler = http.StripPrefix(prefix+endpoint, handler)
+		}
+		mux.Handle(prefix+endpoint, mdmhttp.Chain(handler, middleware...))
+	}
+
+	if h.LongPoll != nil {
+		handle(EndpointDevWait, true, LongPollHandler(h.LongPoll, logger.With("handler", "long-poll")))
+	}
+
+	handle(EndpointPushCert, false, StorePushCertHandler(h.Store, logger.With("handler", "store-cert")))
+	handle(EndpointPushCerts, false, PushCertsHandler(h.Store, logger.With("handler", "push-certs")))
+	handle(EndpointTopicStats, false, TopicStatsHandler(h.Store, h.PushStats, logger.With("handler", "topic-stats")))
+	if h.Inventory != nil {
+		summaryStore := struct {
+			storage.AllStorage
+			storage.InventoryStore
+		}{h.Store, h.Inventory}
+		handle(EndpointSummary, false, FleetSummaryHandler(summaryStore, h.PushStats, logger.With("handler", "summary")))
+	}
+	if h.Rollups != nil {
+		handle(EndpointRollups, false, RollupsHandler(h.Rollups, logger.With("handler", "rollups")))
+	}
+	handle(EndpointPlistToJSON, false, PlistToJSONHandler(logger.With("handler", "plist-to-json")))
+	handle(EndpointJSONToPlist, false, JSONToPlistHandler(logger.With("handler", "json-to-plist")))
+	handle(EndpointCapabilities, false, CapabilitiesHandler(h.Store, logger.With("handler", "capabilities")))
+	if h.EnrollProfile != nil {
+		handle(EndpointEnrollProf, false, h.EnrollProfile)
+	}
+
+	if h.Metrics {
+		handle(EndpointMetrics, false, expvar.Handler())
+	}
+	if h.DebugTargets != nil {
+		handle(EndpointDebugTargets, true, DebugTargetsHandler(h.DebugTargets, logger.With("handler", "debug-targets")))
+	}
+
+	handle(EndpointPush, true, PushHandler(h.Pusher, h.Jobs, logger.With("handler", "push")))
+	enqueueHandler := h.EnqueueHandler()
+	// bulk enqueues are turned into ordinary enqueues before any
+	// middleware so that it sees their targets and command
+	mux.Handle(prefix+EndpointEnqueue, BulkEnqueueMiddleware(
+		mdmhttp.Chain(http.StripPrefix(prefix+EndpointEnqueue, enqueueHandler), middleware...),
+		prefix+EndpointEnqueue,
+		logger.With("handler", "bulk-enqueue"),
+	))
+
+	if h.Maintenance != nil {
+		handle(EndpointMaintenance, false, MaintenanceHandler(h.Maintenance, logger.With("handler", "maintenance")))
+	}
+
+	handle(EndpointDMEnablement, true, DMEnablementHandler(h.Store, logger.With("handler", "dm-enablement")))
+	var groupStore campaign.GroupStore
+	if h.Metadata != nil {
+		groupStore = struct {
+			storage.AllStorage
+			storage.EnrollmentMetadataStore
+		}{h.Store, h.Metadata}
+		handle(EndpointMetadata, true, EnrollmentMetadataHandler(h.Metadata, logger.With("handler", "metadata")))
+		handle(EndpointGroups, true, GroupsHandler(groupStore, h.SmartGroups, logger.With("handler", "groups")))
+	}
+	handle(EndpointEnrollments, true, EnrollmentsHandler(h.Store, h.Store, logger.With("handler", "enrollments")))
+	handle(EndpointResolve, true, ResolveHandler(h.Store, logger.With("handler", "resolve")))
+	handle(EndpointUserChannels, true, UserChannelsHandler(h.Store, h.Store, logger.With("handler", "user-channels")))
+	if h.UserSessions != nil {
+		handle(EndpointUserSessions, true, UserSessionsHandler(h.UserSessions, h.Store, logger.With("handler", "user-sessions")))
+	}
+	handle(EndpointDisable, true, DisableHandler(h.Store, logger.With("handler", "disable")))
+	if h.Supersessions != nil && h.Metadata != nil && h.Inventory != nil {
+		supersedeStore := struct {
+			storage.EnrollmentSupersessionStore
+			storage.EnrollmentMetadataStore
+			storage.InventoryStore
+		}{h.Supersessions, h.Metadata, h.Inventory}
+		handle(EndpointSupersede, true, SupersedeHandler(supersedeStore, logger.With("handler", "supersede")))
+	}
+	if h.Queue != nil {
+		queueStore := struct {
+			storage.AllStorage
+			storage.QueueRetriever
+		}{h.Store, h.Queue}
+		handle(EndpointQueue, true, QueueSnapshotHandler(queueStore, h.ErrorKB, logger.With("handler", "queue")))
+	}
+	if h.DDMStatus != nil {
+		handle(EndpointDDMStatus, true, DDMStatusHandler(h.DDMStatus, logger.With("handler", "ddm-status")))
+	}
+	if h.QueuedCommands != nil {
+		handle(EndpointQueued, true, QueuedCommandsHandler(h.QueuedCommands, logger.With("handler", "queued-commands")))
+	}
+
+	if h.Campaigns != nil {
+		handle(EndpointCampaigns, true, CampaignHandler(h.Campaigns, groupStore, logger.With("handler", "campaigns")))
+	}
+	if h.Repush != nil {
+		handle(EndpointRepush, false, RepushHandler(h.Repush, logger.With("handler", "repush")))
+	}
+	if h.Events != nil {
+		handle(EndpointEvents, false, EventsHandler(h.Events, logger.With("handler", "events")))
+	}
+	if h.Jobs != nil {
+		handle(EndpointJobs, true, JobHandler(h.Jobs, logger.With("handler", "jobs")))
+	}
+	if h.EventLog != nil {
+		handle(EndpointEventLog, false, EventLogHandler(h.EventLog, logger.With("handler", "event-log")))
+		if h.Queue != nil {
+			historyStore := struct {
+				storage.EventLogStore
+				storage.QueueRetriever
+			}{h.EventLog, h.Queue}
+			handle(EndpointHistory, true, EnrollmentStateHandler(historyStore, logger.With("handler", "history")))
+		}
+	}
+	handle(EndpointExport, true, ExportHandler(h.Store, h.Inventory, h.EventLog, logger.With("handler", "export")))
+	if h.RBAC != nil {
+		if h.APIKeys != nil {
+			handle(EndpointAPIKeys, true, APIKeysHandler(h.APIKeys, h.RBAC, logger.With("handler", "api-keys")))
+		}
+		handle(EndpointRoles, false, RolesHandler(h.RBAC, logger.With("handler", "roles")))
+	}
+	if h.Pending != nil {
+		handle(EndpointPending, true, PendingCommandsHandler(h.Pending, enqueueHandler, logger.With("handler", "pending")))
+	}
+	if h.Freeze != nil {
+		handle(EndpointFreeze, true, FreezeHandler(h.Freeze, logger.With("handler", "freeze")))
+	}
+	if h.Evict != nil {
+		handle(EndpointEvict, true, EvictHandler(h.Evict, logger.With("handler", "evict")))
+	}
+	if h.Tombstones != nil {
+		handle(EndpointTombstones, true, TombstonesHandler(h.Tombstones, logger.With("handler", "tombstones")))
+	}
+	if h.Webhooks != nil {
+		handle(EndpointWebhooks, true, WebhookDeliveriesHandler(h.Webhooks, logger.With("handler", "webhook-deliveries")))
+	}
+	if h.Templates != nil {
+		handle(EndpointTemplates, true, MessageTemplatesHandler(h.Templates, logger.With("handler", "templates")))
+	}
+	if h.Declarations != nil {
+		handle(EndpointDeclarations, true, DeclarationsHandler(h.Declarations, logger.With("handler", "declarations")))
+		handle(EndpointDeclSets, true, DeclarationSetsHandler(h.Declarations, logger.With("handler", "declaration-sets")))
+		handle(EndpointEnrollSets, true, EnrollmentSetsHandler(h.Declarations, logger.With("handler", "enrollment-sets")))
+	}
+	if h.UnlockTokens != nil {
+		handle(EndpointUnlockTokens, true, UnlockTokensHandler(h.UnlockTokens, logger.With("handler", "unlock-tokens")))
+		handle(EndpointUnlockReqs, true, UnlockTokenRequestsHandler(h.UnlockTokens, logger.With("handler", "unlock-token-requests")))
+		handle(EndpointClearPass, true, ClearPasscodeHandler(h.UnlockTokens, enqueueHandler, logger.With("handler", "clear-passcode")))
+	}
+	if h.Migration != nil {
+		handle(EndpointMigration, false, httpmdm.MigrationHandler(h.Migration, logger.With("handler", "migration")))
+	}
+}
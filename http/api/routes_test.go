@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestHandlersRegister(t *testing.T) {
+	patterns := make(map[string]http.Handler)
+	mux := mdmhttp.MuxFunc(func(pattern string, handler http.Handler) {
+		patterns[pattern] = handler
+	})
+	var wrapped int
+	h := &Handlers{Store: new(mock.Storage)}
+	h.Register(mux, "/prefix", func(next http.Handler) http.Handler {
+		wrapped++
+		return next
+	})
+
+	if _, ok := patterns["/prefix"+EndpointEnrollments]; !ok {
+		t.Error("expected prefixed enrollments endpoint")
+	}
+	if _, ok := patterns["/prefix"+EndpointJobs]; ok {
+		t.Error("expected no jobs endpoint without a job store")
+	}
+	if _, ok := patterns["/prefix"+EndpointMetadata]; ok {
+		t.Error("expected no metadata endpoint without a metadata store")
+	}
+	if have, want := wrapped, len(patterns); have != want {
+		t.Errorf("wrapped handlers: have %d, want %d", have, want)
+	}
+
+	// the prefix is stripped for id endpoints
+	rec := httptest.NewRecorder()
+	patterns["/prefix"+EndpointEnrollments].ServeHTTP(rec, httptest.NewRequest("GET", "/prefix"+EndpointEnrollments+"ID1", nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Errorf("status: have %d, want %d", have, want)
+	}
+
+	// optional stores register their endpoints
+	patterns = make(map[string]http.Handler)
+	store := new(mock.Storage)
+	h = &Handlers{Store: store, Metadata: store}
+	h.Register(mux, "")
+	if _, ok := patterns[EndpointMetadata]; !ok {
+		t.Error("expected metadata endpoint with a metadata store")
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push/pushstats"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// summaryActiveWindow is how recently an enrollment must have been seen
+// to be counted as active in the fleet summary.
+const summaryActiveWindow = 24 * time.Hour
+
+// FleetSummary is the aggregate state of all enrollments.
+type FleetSummary struct {
+	Enrollments        int            `json:"enrollments"`
+	EnabledEnrollments int            `json:"enabled_enrollments"`
+	ByType             map[string]int `json:"by_type"`
+
+	// ByOSVersion counts enabled device enrollments by the OSVersion of
+	// their stored DeviceInformation inventory ("unknown" if none).
+	ByOSVersion map[string]int `json:"by_os_version"`
+
+	// Active24h is the number of enabled enrollments seen in the last
+	// 24 hours.
+	Active24h int `json:"active_24h"`
+
+	// PendingCommands is the number of queued commands that have not
+	// received a result (or have received a NotNow result).
+	PendingCommands int `json:"pending_commands"`
+
+	// Push is the push outcomes of all topics over the push statistics
+	// window. Omitted if push statistics are not enabled.
+	Push *pushstats.Rate `json:"push,omitempty"`
+
+	// DMEnrollments is the number of enabled device enrollments that
+	// have issued a DeclarativeManagement check-in and DMAdoption their
+	// share of enabled device enrollments.
+	DMEnrollments int     `json:"dm_enrollments"`
+	DMAdoption    float64 `json:"dm_adoption"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SummaryStore retrieves the storage sources of the fleet summary.
+type SummaryStore interface {
+	storage.EnrollmentRetriever
+	storage.InventoryStore
+	storage.TopicStatsRetriever
+	storage.DMEnablementStore
+}
+
+// isDeviceType reports whether the enrollment type is a device channel.
+func isDeviceType(t string) bool {
+	switch t {
+	case mdm.EnrollType(mdm.Device).String(), mdm.EnrollType(mdm.UserEnrollmentDevice).String():
+		return true
+	}
+	return false
+}
+
+// summarize aggregates the fleet summary from store as of now.
+func summarize(ctx context.Context, store SummaryStore, pushStats *pushstats.Recorder, now time.Time) (*FleetSummary, error) {
+	s := &FleetSummary{
+		ByType:      make(map[string]int),
+		ByOSVersion: make(map[string]int),
+		CreatedAt:   now,
+	}
+	dmEnablements, err := store.RetrieveDMEnablements(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	var devices int
+	filter := &storage.EnrollmentFilter{Limit: exportPageSize}
+	for {
+		enrollments, err := store.RetrieveEnrollments(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range enrollments {
+			s.Enrollments++
+			s.ByType[e.Type]++
+			if !e.Enabled {
+				continue
+			}
+			s.EnabledEnrollments++
+			if now.Sub(e.LastSeenAt) <= summaryActiveWindow {
+				s.Active24h++
+			}
+			if !isDeviceType(e.Type) {
+				continue
+			}
+			devices++
+			if _, ok := dmEnablements[e.ID]; ok {
+				s.DMEnrollments++
+			}
+			values, err := store.RetrieveInventory(ctx, e.ID, "DeviceInformation")
+			if err != nil {
+				return nil, err
+			}
+			if v, ok := values["OSVersion"]; ok && v != "" {
+				s.ByOSVersion[v]++
+			} else {
+				s.ByOSVersion["unknown"]++
+			}
+		}
+		if len(enrollments) < exportPageSize {
+			break
+		}
+		filter.Offset += len(enrollments)
+	}
+	if devices > 0 {
+		s.DMAdoption = float64(s.DMEnrollments) / float64(devices)
+	}
+	stats, err := store.RetrieveTopicStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, ts := range stats {
+		s.PendingCommands += ts.PendingCommands
+	}
+	if pushStats != nil {
+		s.Push = new(pushstats.Rate)
+		for _, rate := range pushStats.Rates() {
+			s.Push.Pushes += rate.Pushes
+			s.Push.Failures += rate.Failures
+		}
+		if s.Push.Pushes > 0 {
+			s.Push.FailureRate = float64(s.Push.Failures) / float64(s.Push.Pushes)
+		}
+	}
+	return s, nil
+}
+
+// FleetSummaryHandler returns the aggregate enrollment counts, pending
+// command total, push failure rate, and Declarative Management adoption
+// of all enrollments as JSON. Push statistics are omitted if pushStats
+// is nil. Note the summary reads every enrollment (and the inventory of
+// every enabled device) so is relatively expensive with large fleets.
+func FleetSummaryHandler(store SummaryStore, pushStats *pushstats.Recorder, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		summary, err := summarize(ctx, store, pushStats, time.Now().UTC().Truncate(time.Second))
+		if err != nil {
+			logger.Info("msg", "summarizing fleet", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		json, err := json.MarshalIndent(summary, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
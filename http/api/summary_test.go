@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push/pushstats"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestSummarize(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := new(mock.Storage)
+	store.RetrieveEnrollmentsFunc = func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		if filter.Offset > 0 {
+			return nil, nil
+		}
+		return []*storage.Enrollment{
+			{ID: "DEV1", Type: "Device", Enabled: true, LastSeenAt: now.Add(-time.Hour)},
+			{ID: "DEV2", Type: "Device", Enabled: true, LastSeenAt: now.Add(-48 * time.Hour)},
+			{ID: "DEV1:USER1", Type: "User", Enabled: true, LastSeenAt: now},
+			{ID: "DEV3", Type: "Device"},
+		}, nil
+	}
+	store.RetrieveInventoryFunc = func(_ context.Context, id, source string) (map[string]string, error) {
+		if id == "DEV1" {
+			return map[string]string{"OSVersion": "14.4"}, nil
+		}
+		return nil, nil
+	}
+	store.RetrieveDMEnablementsFunc = func(context.Context, []string) (map[string]*storage.DMEnablement, error) {
+		return map[string]*storage.DMEnablement{"DEV1": {}, "DEV3": {}}, nil
+	}
+	store.RetrieveTopicStatsFunc = func(context.Context) ([]*storage.TopicStats, error) {
+		return []*storage.TopicStats{{Topic: "a", PendingCommands: 3}, {Topic: "b", PendingCommands: 4}}, nil
+	}
+	pushStats := pushstats.New()
+	pushStats.Record("a", 8, 1)
+	pushStats.Record("b", 2, 1)
+
+	s, err := summarize(context.Background(), store, pushStats, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Enrollments != 4 || s.EnabledEnrollments != 3 || s.ByType["Device"] != 3 || s.ByType["User"] != 1 {
+		t.Errorf("enrollment counts: %+v", s)
+	}
+	if have, want := s.Active24h, 2; have != want {
+		t.Errorf("active: have %d, want %d", have, want)
+	}
+	if s.ByOSVersion["14.4"] != 1 || s.ByOSVersion["unknown"] != 1 {
+		t.Errorf("os versions: %v", s.ByOSVersion)
+	}
+	if have, want := s.PendingCommands, 7; have != want {
+		t.Errorf("pending commands: have %d, want %d", have, want)
+	}
+	if s.Push == nil || s.Push.Pushes != 10 || s.Push.Failures != 2 || s.Push.FailureRate != 0.2 {
+		t.Errorf("push: %+v", s.Push)
+	}
+	// the disabled DEV3 is not counted
+	if s.DMEnrollments != 1 || s.DMAdoption != 0.5 {
+		t.Errorf("dm: %d, %v", s.DMEnrollments, s.DMAdoption)
+	}
+}
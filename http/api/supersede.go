@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// supersedeInventorySources are the inventory snapshot sources carried
+// over to superseding enrollments.
+var supersedeInventorySources = []string{"DeviceInformation", "SecurityInfo", "ProfileList"}
+
+// SupersedeStore stores enrollment supersessions and carries metadata
+// and inventory over to superseding enrollments.
+type SupersedeStore interface {
+	storage.EnrollmentSupersessionStore
+	storage.EnrollmentMetadataStore
+	storage.InventoryStore
+}
+
+// mergeStrings appends the values of b not in a to a.
+func mergeStrings(a, b []string) []string {
+	seen := make(map[string]bool, len(a))
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			a = append(a, v)
+			seen[v] = true
+		}
+	}
+	return a
+}
+
+// supersede marks the enrollment id as superseded by the enrollment by.
+// The tags and groups of id are added to those of by and the tenant of
+// id is kept if by has none. Inventory snapshots of id are copied to by
+// for sources by has no snapshot of yet.
+func supersede(ctx context.Context, store SupersedeStore, id, by string) error {
+	if id == by {
+		return errors.New("enrollment cannot supersede itself")
+	}
+	oldMeta, err := store.RetrieveEnrollmentMetadata(ctx, id)
+	if err != nil {
+		return fmt.Errorf("retrieving metadata of %s: %w", id, err)
+	}
+	if oldMeta != nil {
+		newMeta, err := store.RetrieveEnrollmentMetadata(ctx, by)
+		if err != nil {
+			return fmt.Errorf("retrieving metadata of %s: %w", by, err)
+		}
+		if newMeta == nil {
+			newMeta = new(storage.EnrollmentMetadata)
+		}
+		if newMeta.Tenant == "" {
+			newMeta.Tenant = oldMeta.Tenant
+		}
+		newMeta.Tags = mergeStrings(newMeta.Tags, oldMeta.Tags)
+		newMeta.Groups = mergeStrings(newMeta.Groups, oldMeta.Groups)
+		if err = store.StoreEnrollmentMetadata(ctx, by, newMeta); err != nil {
+			return fmt.Errorf("storing metadata of %s: %w", by, err)
+		}
+	}
+	for _, source := range supersedeInventorySources {
+		values, err := store.RetrieveInventory(ctx, by, source)
+		if err != nil {
+			return fmt.Errorf("retrieving %s inventory of %s: %w", source, by, err)
+		}
+		if values != nil {
+			continue
+		}
+		if values, err = store.RetrieveInventory(ctx, id, source); err != nil {
+			return fmt.Errorf("retrieving %s inventory of %s: %w", source, id, err)
+		}
+		if values == nil {
+			continue
+		}
+		if err = store.StoreInventory(ctx, by, source, values); err != nil {
+			return fmt.Errorf("storing %s inventory of %s: %w", source, by, err)
+		}
+	}
+	return store.StoreEnrollmentSupersession(ctx, &storage.EnrollmentSupersession{ID: id, SupersededBy: by})
+}
+
+// SupersedeHandler retrieves (HTTP GET) or stores (HTTP PUT) enrollment
+// supersessions. The URL path is the comma-separated enrollment IDs of
+// superseded enrollments which probably necessitates stripping the URL
+// prefix before using. A GET without IDs retrieves all supersessions.
+// A PUT requires a JSON object with the "superseded_by" enrollment ID
+// and carries the tags, groups, and inventory of the enrollments over
+// to it (see supersede). The supersessions are returned as a JSON
+// object keyed by superseded enrollment ID.
+func SupersedeHandler(store SupersedeStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			ss := new(storage.EnrollmentSupersession)
+			if err = json.Unmarshal(b, ss); err != nil || ss.SupersededBy == "" {
+				logger.Info("msg", "decoding supersession", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				if id == ss.SupersededBy {
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			for _, id := range ids {
+				if err = supersede(ctx, store, id, ss.SupersededBy); err != nil {
+					logger.Info("msg", "superseding enrollment", "id", id, "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "superseded enrollments", "superseded_by", ss.SupersededBy, "user", user)
+		default:
+			w.Header().Set("Allow", "GET, PUT")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		supersessions, err := store.RetrieveEnrollmentSupersessions(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving supersessions", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		json, err := json.MarshalIndent(supersessions, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
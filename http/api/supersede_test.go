@@ -0,0 +1,65 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestSupersede(t *testing.T) {
+	ctx := context.Background()
+	store := new(mock.Storage)
+	meta := map[string]*storage.EnrollmentMetadata{
+		"OLD": {Tenant: "acme", Tags: []string{"a", "b"}, Groups: []string{"lab"}},
+		"NEW": {Tags: []string{"b", "c"}},
+	}
+	store.RetrieveEnrollmentMetadataFunc = func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+		return meta[id], nil
+	}
+	store.StoreEnrollmentMetadataFunc = func(_ context.Context, id string, m *storage.EnrollmentMetadata) error {
+		meta[id] = m
+		return nil
+	}
+	inventory := map[string]map[string]string{
+		"OLD/DeviceInformation": {"OSVersion": "14.4"},
+		"OLD/SecurityInfo":      {"PasscodePresent": "true"},
+		"NEW/SecurityInfo":      {"PasscodePresent": "false"},
+	}
+	store.RetrieveInventoryFunc = func(_ context.Context, id, source string) (map[string]string, error) {
+		return inventory[id+"/"+source], nil
+	}
+	store.StoreInventoryFunc = func(_ context.Context, id, source string, values map[string]string) error {
+		inventory[id+"/"+source] = values
+		return nil
+	}
+	var stored *storage.EnrollmentSupersession
+	store.StoreEnrollmentSupersessionFunc = func(_ context.Context, ss *storage.EnrollmentSupersession) error {
+		stored = ss
+		return nil
+	}
+
+	if err := supersede(ctx, store, "OLD", "OLD"); err == nil {
+		t.Error("expected error superseding by itself")
+	}
+	if err := supersede(ctx, store, "OLD", "NEW"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := &storage.EnrollmentMetadata{Tenant: "acme", Tags: []string{"b", "c", "a"}, Groups: []string{"lab"}}
+	if have := meta["NEW"]; !reflect.DeepEqual(have, want) {
+		t.Errorf("metadata: have %+v, want %+v", have, want)
+	}
+	if have, want := inventory["NEW/DeviceInformation"]["OSVersion"], "14.4"; have != want {
+		t.Errorf("copied inventory: have %q, want %q", have, want)
+	}
+	// existing snapshots of the superseding enrollment are kept
+	if have, want := inventory["NEW/SecurityInfo"]["PasscodePresent"], "false"; have != want {
+		t.Errorf("kept inventory: have %q, want %q", have, want)
+	}
+	if stored == nil || stored.ID != "OLD" || stored.SupersededBy != "NEW" {
+		t.Errorf("supersession: %+v", stored)
+	}
+}
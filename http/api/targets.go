@@ -0,0 +1,74 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+const (
+	// targetAllUsers is the target suffix selecting all user channels
+	// of a device, e.g. "DEVICE:*".
+	targetAllUsers = "*"
+
+	// targetUserPrefix is the target suffix prefix selecting the user
+	// channel of a Managed Apple ID, e.g. "DEVICE:user=user@example.com".
+	targetUserPrefix = "user="
+)
+
+// errInvalidTarget is returned when an enqueue target can not be expanded.
+var errInvalidTarget = errors.New("invalid target")
+
+// expandTargets expands friendly channel targets into normalized
+// enrollment IDs. Targets of the form "DEVICE:*" are expanded to all
+// user channel enrollments of the device and targets of the form
+// "DEVICE:user=<Managed Apple ID>" to the user channel enrollment of
+// that user on the device. Any other target is passed through as-is.
+// Returned IDs are de-duplicated and keep their first order.
+func expandTargets(ctx context.Context, store storage.EnrollmentRetriever, targets []string) ([]string, error) {
+	var ids []string
+	seen := make(map[string]struct{})
+	add := func(id string) {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+	for _, target := range targets {
+		device, sel, ok := strings.Cut(target, ":")
+		if !ok || (sel != targetAllUsers && !strings.HasPrefix(sel, targetUserPrefix)) {
+			// not a friendly target (regular IDs may contain colons)
+			add(target)
+			continue
+		}
+		if device == "" {
+			return nil, fmt.Errorf("%w: %s: empty device", errInvalidTarget, target)
+		}
+		userShortName := strings.TrimPrefix(sel, targetUserPrefix)
+		if sel != targetAllUsers && userShortName == "" {
+			return nil, fmt.Errorf("%w: %s: empty user", errInvalidTarget, target)
+		}
+		enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{DeviceID: device})
+		if err != nil {
+			return nil, fmt.Errorf("retrieving enrollments for %s: %w", device, err)
+		}
+		var found bool
+		for _, e := range enrollments {
+			if e.ID == e.DeviceID {
+				// skip the device channel enrollment itself
+				continue
+			}
+			if sel == targetAllUsers || strings.EqualFold(e.UserShortName, userShortName) {
+				add(e.ID)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s: no matching user channels", errInvalidTarget, target)
+		}
+	}
+	return ids, nil
+}
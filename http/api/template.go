@@ -0,0 +1,300 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+// templateRequestTypes are the command request types message templates
+// can be substituted into.
+var templateRequestTypes = map[string]bool{
+	"DeviceLock":  true,
+	"EraseDevice": true,
+}
+
+var errTemplateVariants = errors.New("targets resolve to different template variants")
+
+// matchLocale scores how well the variant locale matches locale: 2 for
+// the same locale and 1 for the same language (e.g. "de" for "de-CH").
+func matchLocale(variant, locale string) int {
+	variant, locale = strings.ToLower(variant), strings.ToLower(locale)
+	locale = strings.ReplaceAll(locale, "_", "-")
+	if variant == locale {
+		return 2
+	}
+	if lang, _, ok := strings.Cut(locale, "-"); ok && variant == lang {
+		return 1
+	}
+	return 0
+}
+
+func inGroups(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveTemplateVariant returns the most specific of variants matching
+// groups and locale. Group variants take precedence over locale
+// variants which take precedence over the default variant. Nil is
+// returned if no variant matches.
+func resolveTemplateVariant(variants []*storage.MessageTemplate, groups []string, locale string) *storage.MessageTemplate {
+	var best *storage.MessageTemplate
+	bestScore := -1
+	for _, v := range variants {
+		score := 0
+		if v.Group != "" {
+			if !inGroups(groups, v.Group) {
+				continue
+			}
+			score += 4
+		}
+		if v.Locale != "" {
+			m := matchLocale(v.Locale, locale)
+			if m < 1 {
+				continue
+			}
+			score += m
+		}
+		if score > bestScore {
+			best, bestScore = v, score
+		}
+	}
+	return best
+}
+
+// resolveTemplate resolves the variant of variants for the enrollment
+// metadata groups of every one of ids. All ids must resolve to the same
+// variant.
+func resolveTemplate(ctx context.Context, meta storage.EnrollmentMetadataStore, variants []*storage.MessageTemplate, ids []string, locale string) (*storage.MessageTemplate, error) {
+	var resolved *storage.MessageTemplate
+	for i, id := range ids {
+		var groups []string
+		if meta != nil {
+			m, err := meta.RetrieveEnrollmentMetadata(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("retrieving enrollment metadata: %w", err)
+			}
+			if m != nil {
+				groups = m.Groups
+			}
+		}
+		v := resolveTemplateVariant(variants, groups, locale)
+		if i > 0 && v != resolved {
+			return nil, errTemplateVariants
+		}
+		resolved = v
+	}
+	return resolved, nil
+}
+
+// substituteTemplate returns a copy of cmd with the Message and
+// PhoneNumber of its command dictionary set from the variant.
+func substituteTemplate(cmd *mdm.Command, variant *storage.MessageTemplate) (*mdm.Command, error) {
+	var m map[string]interface{}
+	if err := plist.Unmarshal(cmd.Raw, &m); err != nil {
+		return nil, err
+	}
+	c, ok := m["Command"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("no command dictionary")
+	}
+	if variant.Message != "" {
+		c["Message"] = variant.Message
+	}
+	if variant.PhoneNumber != "" {
+		c["PhoneNumber"] = variant.PhoneNumber
+	}
+	b, err := plist.MarshalIndent(m, "\t")
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(b)
+}
+
+// MessageTemplateHandler substitutes the message template named in the
+// "template" query parameter into the DeviceLock or EraseDevice command
+// in the request body before calling next (typically the enqueue
+// handler). The template variant is resolved from the enrollment
+// metadata groups of the targets in the URL path and the "locale" query
+// parameter. Targets resolving to different variants are rejected and
+// should be enqueued separately. Requests without a template are passed
+// to next as-is.
+func MessageTemplateHandler(next http.Handler, store storage.MessageTemplateStore, meta storage.EnrollmentMetadataStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("template")
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		logger = logger.With("template", name)
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		command, err := mdm.DecodeCommand(b)
+		if err != nil {
+			logger.Info("msg", "decoding command", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if !templateRequestTypes[command.Command.RequestType] {
+			http.Error(w, "template not supported for request type", http.StatusBadRequest)
+			return
+		}
+		variants, err := store.RetrieveMessageTemplates(ctx, name)
+		if err != nil {
+			logger.Info("msg", "retrieving message template", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		locale := r.URL.Query().Get("locale")
+		variant, err := resolveTemplate(ctx, meta, variants, ids, locale)
+		if errors.Is(err, errTemplateVariants) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			logger.Info("msg", "resolving message template", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if variant == nil {
+			logger.Info("msg", "unknown message template")
+			http.Error(w, "unknown template", http.StatusBadRequest)
+			return
+		}
+		if command, err = substituteTemplate(command, variant); err != nil {
+			logger.Info("msg", "substituting message template", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		logger.Debug(
+			"msg", "substituted message template",
+			"command_uuid", command.CommandUUID,
+			"group", variant.Group,
+			"locale", variant.Locale,
+		)
+		r.Body = io.NopCloser(bytes.NewReader(command.Raw))
+		r.ContentLength = int64(len(command.Raw))
+		next.ServeHTTP(w, r)
+	}
+}
+
+// MessageTemplatesHandler retrieves (HTTP GET), stores (HTTP PUT), or
+// deletes (HTTP DELETE) message templates. The URL path is the template
+// name which probably necessitates stripping the URL prefix before
+// using. A GET without a name retrieves the variants of all templates.
+// A PUT stores the variant in the JSON body (with optional "group" and
+// "locale") of the template. A DELETE deletes the variant of the
+// "group" and "locale" query parameters or all variants of the template
+// without either parameter. The variants of the template are returned
+// as a JSON array.
+func MessageTemplatesHandler(store storage.MessageTemplateStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if name != "" {
+			logger = logger.With("template", name)
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			if name == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			variant := new(storage.MessageTemplate)
+			if err := json.NewDecoder(r.Body).Decode(variant); err != nil {
+				logger.Info("msg", "decoding body", "err", err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if variant.Message == "" && variant.PhoneNumber == "" {
+				http.Error(w, "empty message and phone number", http.StatusBadRequest)
+				return
+			}
+			variant.Name = name
+			if err := store.StoreMessageTemplate(ctx, variant); err != nil {
+				logger.Info("msg", "storing message template", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "stored message template", "group", variant.Group, "locale", variant.Locale, "user", user)
+		case http.MethodDelete:
+			if name == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			q := r.URL.Query()
+			var variants []*storage.MessageTemplate
+			if q.Has("group") || q.Has("locale") {
+				variants = []*storage.MessageTemplate{{Group: q.Get("group"), Locale: q.Get("locale")}}
+			} else {
+				var err error
+				if variants, err = store.RetrieveMessageTemplates(ctx, name); err != nil {
+					logger.Info("msg", "retrieving message template", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			for _, v := range variants {
+				if err := store.DeleteMessageTemplate(ctx, name, v.Group, v.Locale); err != nil {
+					logger.Info("msg", "deleting message template", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "deleted message template", "variants", len(variants), "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		variants, err := store.RetrieveMessageTemplates(ctx, name)
+		if err != nil {
+			logger.Info("msg", "retrieving message templates", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if name != "" && len(variants) < 1 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if variants == nil {
+			variants = []*storage.MessageTemplate{}
+		}
+		json, err := json.MarshalIndent(variants, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
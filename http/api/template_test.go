@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestResolveTemplateVariant(t *testing.T) {
+	variants := []*storage.MessageTemplate{
+		{Name: "lost", Message: "default"},
+		{Name: "lost", Locale: "de", Message: "de"},
+		{Name: "lost", Locale: "de-CH", Message: "de-CH"},
+		{Name: "lost", Group: "lab", Message: "lab"},
+		{Name: "lost", Group: "lab", Locale: "de", Message: "lab-de"},
+	}
+	for _, tc := range []struct {
+		groups  []string
+		locale  string
+		message string
+	}{
+		{nil, "", "default"},
+		{nil, "fr", "default"},
+		{nil, "de", "de"},
+		{nil, "de_AT", "de"},
+		{nil, "de-ch", "de-CH"},
+		{[]string{"lab"}, "", "lab"},
+		{[]string{"other", "lab"}, "de-CH", "lab-de"},
+	} {
+		v := resolveTemplateVariant(variants, tc.groups, tc.locale)
+		if v == nil || v.Message != tc.message {
+			t.Errorf("groups %v locale %q: have %+v, want %q", tc.groups, tc.locale, v, tc.message)
+		}
+	}
+	if v := resolveTemplateVariant(variants[3:4], nil, ""); v != nil {
+		t.Errorf("expected no variant, have %+v", v)
+	}
+}
+
+func TestMessageTemplateHandler(t *testing.T) {
+	store := &mock.Storage{
+		RetrieveMessageTemplatesFunc: func(_ context.Context, name string) ([]*storage.MessageTemplate, error) {
+			if name != "lost" {
+				return nil, nil
+			}
+			return []*storage.MessageTemplate{
+				{Name: "lost", Message: "Lost device", PhoneNumber: "555-0100"},
+				{Name: "lost", Group: "lab", Message: "Return to the lab"},
+			}, nil
+		},
+		RetrieveEnrollmentMetadataFunc: func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+			if strings.HasPrefix(id, "LAB") {
+				return &storage.EnrollmentMetadata{Groups: []string{"lab"}}, nil
+			}
+			return nil, nil
+		},
+	}
+	var command *mdm.Command
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		command, _ = mdm.DecodeCommand(b)
+	})
+	h := http.StripPrefix(EndpointEnqueue, MessageTemplateHandler(next, store, store, log.NopLogger))
+
+	for _, tc := range []struct {
+		path    string
+		status  int
+		message string
+		phone   string
+	}{
+		{"DEV1", http.StatusOK, "", ""},
+		{"DEV1?template=other", http.StatusBadRequest, "", ""},
+		{"DEV1,DEV2?template=lost", http.StatusOK, "Lost device", "555-0100"},
+		{"LAB1?template=lost", http.StatusOK, "Return to the lab", ""},
+		{"DEV1,LAB1?template=lost", http.StatusBadRequest, "", ""},
+	} {
+		command = nil
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPut, EndpointEnqueue+tc.path, strings.NewReader(lockCommand)))
+		if w.Code != tc.status {
+			t.Errorf("%s: status: have %d, want %d", tc.path, w.Code, tc.status)
+		}
+		if tc.status != http.StatusOK {
+			continue
+		}
+		if command == nil {
+			t.Fatalf("%s: command not passed to next handler", tc.path)
+		}
+		if have, want := command.CommandUUID, "lock-1"; have != want {
+			t.Errorf("%s: command uuid: have %q, want %q", tc.path, have, want)
+		}
+		if !strings.Contains(string(command.Raw), tc.message) || !strings.Contains(string(command.Raw), tc.phone) {
+			t.Errorf("%s: message not substituted: %s", tc.path, command.Raw)
+		}
+	}
+}
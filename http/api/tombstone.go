@@ -0,0 +1,116 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// TombstonesHandler retrieves the tombstones of (HTTP GET), departs
+// (HTTP PUT), or deletes the tombstones of (HTTP DELETE) enrollments.
+// The URL path is the comma-separated enrollment IDs which probably
+// necessitates stripping the URL prefix before using. A GET without
+// IDs retrieves all tombstones. A PUT purges the stored data of the
+// device enrollments and their user channel enrollments leaving only
+// their tombstones and may have a JSON object with a "reason". IDs
+// that are not device enrollments are not departed and a PUT that
+// departs none of its IDs is answered with an HTTP 404. The tombstones
+// are returned as a JSON object keyed by enrollment ID.
+func TombstonesHandler(store storage.EnrollmentTombstoneStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var tombstones map[string]*storage.EnrollmentTombstone
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			tombstones, err = store.RetrieveEnrollmentTombstones(ctx, ids)
+			if err != nil {
+				logger.Info("msg", "retrieving tombstones", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPut:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			b, err := mdmhttp.ReadAllAndReplaceBody(r)
+			if err != nil {
+				logger.Info("msg", "reading body", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			tombstone := new(storage.EnrollmentTombstone)
+			if len(b) > 0 {
+				if err = json.Unmarshal(b, tombstone); err != nil {
+					logger.Info("msg", "decoding tombstone", "err", err)
+					http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+					return
+				}
+			}
+			tombstone.DepartedBy, _, _ = r.BasicAuth()
+			tombstones = make(map[string]*storage.EnrollmentTombstone)
+			for _, id := range ids {
+				t := storage.EnrollmentTombstone{ID: id, Reason: tombstone.Reason, DepartedBy: tombstone.DepartedBy}
+				departed, err := store.DepartEnrollment(ctx, &t)
+				if err != nil {
+					logger.Info("msg", "departing enrollment", "id", id, "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				} else if departed == nil {
+					logger.Debug("msg", "not a device enrollment", "id", id)
+					continue
+				}
+				tombstones[id] = departed
+			}
+			if len(tombstones) < 1 {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			logger.Info(
+				"msg", "departed enrollments",
+				"count", len(tombstones),
+				"reason", tombstone.Reason,
+				"user", tombstone.DepartedBy,
+			)
+		case http.MethodDelete:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				if err := store.DeleteEnrollmentTombstone(ctx, id); err != nil {
+					logger.Info("msg", "deleting tombstone", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "deleted tombstones", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		json, err := json.MarshalIndent(tombstones, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/micromdm/nanomdm/push/pushstats"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// topicStats are the storage statistics and recent push outcomes of
+// an APNs topic.
+type topicStats struct {
+	*storage.TopicStats
+	Push *pushstats.Rate `json:"push,omitempty"`
+}
+
+// TopicStatsHandler returns the enrollment and command queue counts
+// and recent push failure rates of each APNs topic as JSON. Push
+// failure rates are omitted if pushStats is nil.
+func TopicStatsHandler(store storage.TopicStatsRetriever, pushStats *pushstats.Recorder, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		stats, err := store.RetrieveTopicStats(ctx)
+		if err != nil {
+			logger.Info("msg", "retrieving topic stats", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		var rates map[string]*pushstats.Rate
+		if pushStats != nil {
+			rates = pushStats.Rates()
+		}
+		output := make([]*topicStats, 0, len(stats))
+		for _, ts := range stats {
+			output = append(output, &topicStats{TopicStats: ts, Push: rates[ts.Topic]})
+			delete(rates, ts.Topic)
+		}
+		// include pushed topics without enrollments
+		for topic, rate := range rates {
+			output = append(output, &topicStats{TopicStats: &storage.TopicStats{Topic: topic}, Push: rate})
+		}
+		sort.Slice(output, func(i, j int) bool { return output[i].Topic < output[j].Topic })
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
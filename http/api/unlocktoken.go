@@ -0,0 +1,311 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/http/audit"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+// UnlockTokenRequestTTL is how long after it is made an approved
+// UnlockToken request can be used.
+const UnlockTokenRequestTTL = 24 * time.Hour
+
+// unlockTokenResult is the escrowed UnlockToken of an enrollment.
+type unlockTokenResult struct {
+	ID          string `json:"id"`
+	UnlockToken []byte `json:"unlock_token"`
+}
+
+func writeUnlockTokenJSON(w http.ResponseWriter, status int, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// claimUnlockToken claims (deletes) the approved UnlockToken request
+// reqID of enrollment id made by user and retrieves the UnlockToken of
+// id. An HTTP status and message are returned if the request can not
+// be claimed.
+func claimUnlockToken(ctx context.Context, store storage.UnlockTokenStore, id, reqID, user string) ([]byte, int, string, error) {
+	if reqID == "" {
+		return nil, http.StatusBadRequest, "missing request", nil
+	}
+	reqs, err := store.RetrieveUnlockTokenRequests(ctx, []string{reqID})
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("retrieving unlock token request: %w", err)
+	}
+	if len(reqs) < 1 || reqs[0].EnrollmentID != id {
+		return nil, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil
+	}
+	req := reqs[0]
+	if user == "" || user != req.RequestedBy {
+		return nil, http.StatusForbidden, "request made by another user", nil
+	}
+	if req.ApprovedAt == nil {
+		return nil, http.StatusForbidden, "request not approved", nil
+	}
+	if time.Since(req.CreatedAt) > UnlockTokenRequestTTL {
+		return nil, http.StatusForbidden, "request expired", nil
+	}
+	// claim the request so that it is only used once
+	if deleted, err := store.DeleteUnlockTokenRequest(ctx, reqID); err != nil {
+		return nil, 0, "", fmt.Errorf("deleting unlock token request: %w", err)
+	} else if !deleted {
+		return nil, http.StatusNotFound, http.StatusText(http.StatusNotFound), nil
+	}
+	token, err := store.RetrieveUnlockToken(ctx, id)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("retrieving unlock token: %w", err)
+	}
+	if len(token) < 1 {
+		return nil, http.StatusNotFound, "no unlock token", nil
+	}
+	return token, 0, "", nil
+}
+
+// UnlockTokensHandler requests and retrieves the escrowed UnlockToken
+// of an enrollment. The URL path is the enrollment ID which probably
+// necessitates stripping the URL prefix before using. A POST makes a
+// request to retrieve the UnlockToken with the optional "reason" query
+// parameter. The request must be approved by another user with the
+// UnlockToken requests handler. A POST with the approved request ID in
+// the "request" query parameter retrieves the UnlockToken. Retrievals
+// are POSTs so that they are recorded by the audit log. Only the
+// requester (the HTTP basic auth username) can retrieve the UnlockToken
+// and only once.
+func UnlockTokensHandler(store storage.UnlockTokenStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		if id == "" || strings.Contains(id, ",") {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		if e := audit.EntryFromContext(ctx); e != nil {
+			e.RequestType = "UnlockToken"
+		}
+		user, _, _ := r.BasicAuth()
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if reqID := r.URL.Query().Get("request"); reqID != "" {
+			token, status, msg, err := claimUnlockToken(ctx, store, id, reqID, user)
+			if err != nil {
+				logger.Info("msg", "claiming unlock token request", "request", reqID, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			} else if status != 0 {
+				logger.Info("msg", "unlock token denied", "request", reqID, "reason", msg, "user", user)
+				http.Error(w, msg, status)
+				return
+			}
+			logger.Info("msg", "unlock token retrieved", "request", reqID, "user", user)
+			writeUnlockTokenJSON(w, http.StatusOK, &unlockTokenResult{ID: id, UnlockToken: token}, logger)
+			return
+		}
+		token, err := store.RetrieveUnlockToken(ctx, id)
+		if err != nil {
+			logger.Info("msg", "retrieving unlock token", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if len(token) < 1 {
+			http.Error(w, "no unlock token", http.StatusNotFound)
+			return
+		}
+		req := &storage.UnlockTokenRequest{
+			ID:           newUUID(),
+			EnrollmentID: id,
+			Reason:       r.URL.Query().Get("reason"),
+			RequestedBy:  user,
+			CreatedAt:    time.Now().UTC().Truncate(time.Second),
+		}
+		if err = store.StoreUnlockTokenRequest(ctx, req); err != nil {
+			logger.Info("msg", "storing unlock token request", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Info("msg", "unlock token requested", "request", req.ID, "reason", req.Reason, "user", user)
+		writeUnlockTokenJSON(w, http.StatusAccepted, req, logger)
+	}
+}
+
+// UnlockTokenRequestsHandler lists, approves, and rejects UnlockToken
+// requests. The URL path is the request ID which probably necessitates
+// stripping the URL prefix before using. A POST approves the request.
+// The approver (the HTTP basic auth username) must differ from the
+// requester. A DELETE rejects (deletes) the request.
+func UnlockTokenRequestsHandler(store storage.UnlockTokenStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqID := r.URL.Path
+		ctx, logger := setupCtxLog(r.Context(), nil, logger)
+		if reqID != "" {
+			logger = logger.With("request", reqID)
+		}
+		var ids []string
+		if reqID != "" {
+			ids = []string{reqID}
+		}
+		reqs, err := store.RetrieveUnlockTokenRequests(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving unlock token requests", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if reqID != "" && len(reqs) < 1 {
+			http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if reqs == nil {
+				reqs = []*storage.UnlockTokenRequest{}
+			}
+			if reqID != "" {
+				writeUnlockTokenJSON(w, http.StatusOK, reqs[0], logger)
+				return
+			}
+			writeUnlockTokenJSON(w, http.StatusOK, reqs, logger)
+		case http.MethodPost, http.MethodDelete:
+			if reqID == "" {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			req := reqs[0]
+			logger = logger.With("id", req.EnrollmentID)
+			if e := audit.EntryFromContext(ctx); e != nil {
+				e.RequestType = "UnlockToken"
+			}
+			user, _, _ := r.BasicAuth()
+			if r.Method == http.MethodDelete {
+				if _, err = store.DeleteUnlockTokenRequest(ctx, reqID); err != nil {
+					logger.Info("msg", "deleting unlock token request", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+				logger.Info("msg", "rejected unlock token request", "user", user)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if user == "" || user == req.RequestedBy {
+				logger.Info("msg", "approving own unlock token request", "user", user)
+				http.Error(w, "request must be approved by another user", http.StatusForbidden)
+				return
+			}
+			approved, err := store.ApproveUnlockTokenRequest(ctx, reqID, user)
+			if err != nil {
+				logger.Info("msg", "approving unlock token request", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if !approved {
+				http.Error(w, "request already approved", http.StatusConflict)
+				return
+			}
+			logger.Info("msg", "approved unlock token request", "user", user, "requested_by", req.RequestedBy)
+			now := time.Now().UTC().Truncate(time.Second)
+			req.ApprovedBy, req.ApprovedAt = user, &now
+			writeUnlockTokenJSON(w, http.StatusOK, req, logger)
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// newClearPasscode creates a ClearPasscode command plist with token.
+func newClearPasscode(uuid string, token []byte) ([]byte, error) {
+	return plist.MarshalIndent(map[string]interface{}{
+		"CommandUUID": uuid,
+		"Command": map[string]interface{}{
+			"RequestType": "ClearPasscode",
+			"UnlockToken": token,
+		},
+	}, "\t")
+}
+
+// ClearPasscodeHandler enqueues a ClearPasscode command with the
+// escrowed UnlockToken of an enrollment using an approved UnlockToken
+// request. The URL path is the enrollment ID which probably
+// necessitates stripping the URL prefix before using. The approved
+// request ID is the "request" query parameter which is claimed as with
+// retrieving the UnlockToken. The command is passed to enqueue
+// (typically the enqueue handler) whose response is returned. The
+// "nopush" query parameter is passed along.
+func ClearPasscodeHandler(store storage.UnlockTokenStore, enqueue http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Path
+		if id == "" || strings.Contains(id, ",") {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, logger := setupCtxLog(r.Context(), []string{id}, logger)
+		user, _, _ := r.BasicAuth()
+		reqID := r.URL.Query().Get("request")
+		token, status, msg, err := claimUnlockToken(ctx, store, id, reqID, user)
+		if err != nil {
+			logger.Info("msg", "claiming unlock token request", "request", reqID, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		} else if status != 0 {
+			logger.Info("msg", "clear passcode denied", "request", reqID, "reason", msg, "user", user)
+			http.Error(w, msg, status)
+			return
+		}
+		uuid := newUUID()
+		command, err := newClearPasscode(uuid, token)
+		if err != nil {
+			logger.Info("msg", "creating command", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if e := audit.EntryFromContext(ctx); e != nil {
+			e.RequestType, e.CommandUUID = "ClearPasscode", uuid
+		}
+		logger.Info("msg", "enqueueing clear passcode", "request", reqID, "command_uuid", uuid, "user", user)
+		r2 := r.Clone(ctx)
+		r2.Method = http.MethodPut
+		r2.URL.RawQuery = ""
+		if r.URL.Query().Get("nopush") != "" {
+			r2.URL.RawQuery = "nopush=1"
+		}
+		r2.Body = io.NopCloser(bytes.NewReader(command))
+		r2.ContentLength = int64(len(command))
+		enqueue.ServeHTTP(w, r2)
+	}
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestUnlockTokens(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu := msg.(*mdm.TokenUpdate)
+	id := tu.UDID
+	r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id}}
+	if err = store.StoreTokenUpdate(r, tu); err != nil {
+		t.Fatal(err)
+	}
+
+	var command *mdm.Command
+	enqueue := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		command, _ = mdm.DecodeCommand(b)
+		if r.URL.Path != id {
+			t.Errorf("enqueue path: have %q, want %q", r.URL.Path, id)
+		}
+	})
+	tokens := http.StripPrefix(EndpointUnlockTokens, UnlockTokensHandler(store, log.NopLogger))
+	reqs := http.StripPrefix(EndpointUnlockReqs, UnlockTokenRequestsHandler(store, log.NopLogger))
+	clear := http.StripPrefix(EndpointClearPass, ClearPasscodeHandler(store, enqueue, log.NopLogger))
+
+	serve := func(h http.Handler, method, target, user string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, nil)
+		req.SetBasicAuth(user, "secret")
+		h.ServeHTTP(w, req)
+		return w
+	}
+	request := func(user string) string {
+		w := serve(tokens, http.MethodPost, EndpointUnlockTokens+id+"?reason=forgotten", user)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("request: have %d, want %d", w.Code, http.StatusAccepted)
+		}
+		req := new(storage.UnlockTokenRequest)
+		if err := json.Unmarshal(w.Body.Bytes(), req); err != nil {
+			t.Fatal(err)
+		}
+		return req.ID
+	}
+
+	if w := serve(tokens, http.MethodPost, EndpointUnlockTokens+"UNKNOWN", "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("request without token: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	reqID := request("alice")
+	if w := serve(tokens, http.MethodPost, EndpointUnlockTokens+id+"?request="+reqID, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("unapproved retrieval: have %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(reqs, http.MethodPost, EndpointUnlockReqs+reqID, "alice"); w.Code != http.StatusForbidden {
+		t.Errorf("self approval: have %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(reqs, http.MethodPost, EndpointUnlockReqs+reqID, "bob"); w.Code != http.StatusOK {
+		t.Fatalf("approval: have %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serve(reqs, http.MethodPost, EndpointUnlockReqs+reqID, "carol"); w.Code != http.StatusConflict {
+		t.Errorf("second approval: have %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := serve(tokens, http.MethodPost, EndpointUnlockTokens+id+"?request="+reqID, "bob"); w.Code != http.StatusForbidden {
+		t.Errorf("retrieval by approver: have %d, want %d", w.Code, http.StatusForbidden)
+	}
+	w := serve(tokens, http.MethodPost, EndpointUnlockTokens+id+"?request="+reqID, "alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("retrieval: have %d, want %d", w.Code, http.StatusOK)
+	}
+	result := new(unlockTokenResult)
+	if err = json.Unmarshal(w.Body.Bytes(), result); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(result.UnlockToken, tu.UnlockToken) {
+		t.Errorf("unlock token: have %x, want %x", result.UnlockToken, tu.UnlockToken)
+	}
+	// requests are used once
+	if w = serve(tokens, http.MethodPost, EndpointUnlockTokens+id+"?request="+reqID, "alice"); w.Code != http.StatusNotFound {
+		t.Errorf("second retrieval: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	reqID = request("alice")
+	if w = serve(reqs, http.MethodPost, EndpointUnlockReqs+reqID, "bob"); w.Code != http.StatusOK {
+		t.Fatalf("approval: have %d, want %d", w.Code, http.StatusOK)
+	}
+	if w = serve(clear, http.MethodPost, EndpointClearPass+id+"?request="+reqID, "alice"); w.Code != http.StatusOK {
+		t.Fatalf("clear passcode: have %d, want %d", w.Code, http.StatusOK)
+	}
+	if command == nil || command.Command.RequestType != "ClearPasscode" || !bytes.Contains(command.Raw, []byte("<key>UnlockToken</key>")) {
+		t.Fatalf("unexpected command: %+v", command)
+	}
+
+	reqID = request("alice")
+	if w = serve(reqs, http.MethodDelete, EndpointUnlockReqs+reqID, "bob"); w.Code != http.StatusNoContent {
+		t.Errorf("rejection: have %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w = serve(reqs, http.MethodGet, EndpointUnlockReqs+reqID, "bob"); w.Code != http.StatusNotFound {
+		t.Errorf("rejected request: have %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// UserChannelsHandler returns a JSON map of device enrollment IDs to
+// a list of their user channel enrollments. If resolver is not nil
+// then the device identifiers may also be serial numbers, UDIDs, or
+// EnrollmentIDs.
+//
+// Note the whole URL path is used as the device enrollment ID(s) to
+// list. This probably necessitates stripping the URL prefix before
+// using.
+func UserChannelsHandler(store storage.EnrollmentRetriever, resolver storage.EnrollmentAliasResolver, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var err error
+		if resolver != nil {
+			if ids, err = resolveIDs(ctx, resolver, ids); err != nil {
+				logger.Info("msg", "resolving enrollment ids", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		output := make(map[string][]*storage.Enrollment)
+		var count int
+		for _, id := range ids {
+			enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{DeviceID: id})
+			if err != nil {
+				logger.Info("msg", "retrieving enrollments", "id", id, "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			output[id] = []*storage.Enrollment{}
+			for _, e := range enrollments {
+				// skip the device channel enrollment itself
+				if e.ID != e.DeviceID {
+					output[id] = append(output[id], e)
+				}
+			}
+			count += len(output[id])
+		}
+		logger.Debug("msg", "retrieved user channels", "count", count)
+		json, err := json.MarshalIndent(output, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// UserSessionsHandler returns a JSON map of device enrollment IDs to
+// their current and previous user sessions (such as the users of a
+// Shared iPad). Devices without user sessions are not returned. If
+// resolver is not nil then the device identifiers may also be serial
+// numbers, UDIDs, or EnrollmentIDs.
+//
+// Note the whole URL path is used as the device enrollment ID(s) to
+// report on. This probably necessitates stripping the URL prefix before
+// using.
+func UserSessionsHandler(store storage.UserSessionStore, resolver storage.EnrollmentAliasResolver, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ids := strings.Split(r.URL.Path, ",")
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var err error
+		if resolver != nil {
+			if ids, err = resolveIDs(ctx, resolver, ids); err != nil {
+				logger.Info("msg", "resolving enrollment ids", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+		sessions, err := store.RetrieveUserSessions(ctx, ids)
+		if err != nil {
+			logger.Info("msg", "retrieving user sessions", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("msg", "retrieved user sessions", "count", len(sessions))
+		json, err := json.MarshalIndent(sessions, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// WebhookDeliveriesHandler retrieves (HTTP GET), replays (HTTP POST),
+// or deletes (HTTP DELETE) webhook deliveries. The URL path is the
+// comma-separated delivery IDs which probably necessitates stripping
+// the URL prefix before using. A GET retrieves the dead-lettered
+// deliveries (all of them without IDs) or the pending deliveries with
+// the "pending" query parameter. A POST replays the dead-lettered
+// deliveries among the IDs: they are retried as soon as possible with
+// a fresh set of attempts. A POST that replays none of its IDs is
+// answered with an HTTP 404. The deliveries are returned as a JSON
+// array ordered by their next attempt time.
+func WebhookDeliveriesHandler(store storage.WebhookDeliveryStore, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		if r.URL.Path != "" {
+			ids = strings.Split(r.URL.Path, ",")
+		}
+		ctx, logger := setupCtxLog(r.Context(), ids, logger)
+		var deliveries []*storage.WebhookDelivery
+		switch r.Method {
+		case http.MethodGet:
+			var err error
+			deliveries, err = store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{
+				IDs:  ids,
+				Dead: !r.URL.Query().Has("pending"),
+			})
+			if err != nil {
+				logger.Info("msg", "retrieving webhook deliveries", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		case http.MethodPost:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			var err error
+			deliveries, err = store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{IDs: ids, Dead: true})
+			if err != nil {
+				logger.Info("msg", "retrieving webhook deliveries", "err", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if len(deliveries) < 1 {
+				http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+				return
+			}
+			now := time.Now().UTC()
+			for _, d := range deliveries {
+				d.Attempts = 0
+				d.Dead = false
+				d.NextAttemptAt = now
+				if err = store.StoreWebhookDelivery(ctx, d); err != nil {
+					logger.Info("msg", "storing webhook delivery", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "replayed webhook deliveries", "count", len(deliveries), "user", user)
+		case http.MethodDelete:
+			if len(ids) < 1 {
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			for _, id := range ids {
+				if err := store.DeleteWebhookDelivery(ctx, id); err != nil {
+					logger.Info("msg", "deleting webhook delivery", "err", err)
+					http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+					return
+				}
+			}
+			user, _, _ := r.BasicAuth()
+			logger.Info("msg", "deleted webhook deliveries", "user", user)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			w.Header().Set("Allow", "GET, POST, DELETE")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []*storage.WebhookDelivery{}
+		}
+		json, err := json.MarshalIndent(deliveries, "", "\t")
+		if err != nil {
+			logger.Info("msg", "marshal json", "err", err)
+		}
+		w.Header().Set("Content-type", "application/json")
+		_, err = w.Write(json)
+		if err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
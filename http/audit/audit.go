@@ -0,0 +1,248 @@
+// Package audit records a tamper-evident audit trail of administrative
+// API requests.
+//
+// Entries are written as JSON lines. Each entry includes the hash of
+// the previous entry and its own hash which chains the entries together:
+// modifying, removing, or reordering entries breaks the chain. Entries
+// can additionally be signed with an Ed25519 key so that the chain
+// cannot be rewritten without the key.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is an audit log entry.
+type Entry struct {
+	Seq         int64     `json:"seq"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user,omitempty"`
+	RemoteAddr  string    `json:"remote_addr,omitempty"`
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Status      int       `json:"status"`
+	RequestType string    `json:"request_type,omitempty"`
+	CommandUUID string    `json:"command_uuid,omitempty"`
+	// PolicyRule and PolicyAction are the enqueue policy decision.
+	PolicyRule   string `json:"policy_rule,omitempty"`
+	PolicyAction string `json:"policy_action,omitempty"`
+
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature,omitempty"`
+}
+
+// hash computes the hash of e. The hash covers every field (including
+// the previous hash) except the hash and signature themselves.
+func (e *Entry) hash() ([]byte, error) {
+	c := *e
+	c.Hash, c.Signature = "", ""
+	b, err := json.Marshal(&c)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
+
+// Log appends chained (and optionally signed) entries to a writer.
+type Log struct {
+	mu       sync.Mutex
+	w        io.Writer
+	seq      int64
+	prevHash string
+	key      ed25519.PrivateKey
+}
+
+// Option configures a Log.
+type Option func(*Log)
+
+// WithSigningKey signs each entry hash with key.
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(l *Log) {
+		l.key = key
+	}
+}
+
+// New creates a new audit log writing to w. Use Open to continue the
+// chain of an existing log file.
+func New(w io.Writer, opts ...Option) *Log {
+	l := &Log{w: w}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Open opens the audit log file at path for appending. The chain is
+// continued from the last entry in the file, if any.
+func Open(path string, opts ...Option) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := New(f, opts...)
+	last, err := lastEntry(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading last entry: %w", err)
+	}
+	if last != nil {
+		l.seq, l.prevHash = last.Seq, last.Hash
+	}
+	return l, nil
+}
+
+// lastEntry returns the last entry read from r.
+func lastEntry(r io.Reader) (*Entry, error) {
+	var last []byte
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil || last == nil {
+		return nil, err
+	}
+	e := new(Entry)
+	return e, json.Unmarshal(last, e)
+}
+
+// Append chains, signs, and writes e. The sequence number and hashes
+// of e are set by Append.
+func (l *Log) Append(e *Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e.Seq = l.seq + 1
+	e.PrevHash = l.prevHash
+	e.Signature = ""
+	sum, err := e.hash()
+	if err != nil {
+		return err
+	}
+	e.Hash = hex.EncodeToString(sum)
+	if l.key != nil {
+		e.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(l.key, sum))
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = l.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	l.seq, l.prevHash = e.Seq, e.Hash
+	return nil
+}
+
+// Verify reads the audit log from r and verifies the chain of entries.
+// The chain must start with the first entry (sequence number 1 without
+// a previous hash) so that removing entries from the head of the log is
+// detected. If pub is not nil every entry must have a valid signature. It
+// returns the number of entries verified and an error identifying the
+// first invalid entry, if any.
+func Verify(r io.Reader, pub ed25519.PublicKey) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	var prev *Entry
+	var n int
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) < 1 {
+			continue
+		}
+		e := new(Entry)
+		if err := json.Unmarshal(line, e); err != nil {
+			return n, fmt.Errorf("entry after seq %d: %w", n, err)
+		}
+		if prev == nil && (e.Seq != 1 || e.PrevHash != "") {
+			return n, fmt.Errorf("entry seq %d: not the first entry", e.Seq)
+		}
+		if prev != nil && (e.Seq != prev.Seq+1 || e.PrevHash != prev.Hash) {
+			return n, fmt.Errorf("entry seq %d: broken chain", e.Seq)
+		}
+		sum, err := e.hash()
+		if err != nil {
+			return n, err
+		}
+		if e.Hash != hex.EncodeToString(sum) {
+			return n, fmt.Errorf("entry seq %d: hash mismatch", e.Seq)
+		}
+		if pub != nil {
+			sig, err := base64.StdEncoding.DecodeString(e.Signature)
+			if err != nil || !ed25519.Verify(pub, sum, sig) {
+				return n, fmt.Errorf("entry seq %d: invalid signature", e.Seq)
+			}
+		}
+		prev = e
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key (e.g.
+// generated with "openssl genpkey -algorithm ed25519") from path.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 private key")
+	}
+	return edKey, nil
+}
+
+// LoadVerifyKey reads a PEM-encoded PKIX Ed25519 public key (or PKCS
+// #8 private key) from path for verifying signatures.
+func LoadVerifyKey(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var key interface{}
+	if block.Type == "PUBLIC KEY" {
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	} else {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey), nil
+	}
+	return nil, errors.New("not an Ed25519 key")
+}
@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+const lockCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>lock-1</string>
+</dict>
+</plist>`
+
+func TestChain(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, WithSigningKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}), l, log.NopLogger)
+	for _, req := range []struct{ method, path string }{
+		{"PUT", "/v1/enqueue/ID1"},
+		{"GET", "/v1/enrollments/ID1"},
+		{"GET", "/v1/enqueue/ID1"}, // always changes state
+		{"POST", "/v1/enqueue/ID1"},
+	} {
+		r := httptest.NewRequest(req.method, req.path, strings.NewReader(lockCommand))
+		r.SetBasicAuth("nanomdm", "secret")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// reopening continues the chain
+	if l, err = Open(path, WithSigningKey(key)); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Append(&Entry{Method: "DELETE", Path: "/v1/metadata/ID1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(b, []byte(`"user":"nanomdm"`)) || !bytes.Contains(b, []byte(`"request_type":"DeviceLock"`)) || !bytes.Contains(b, []byte(`"status":201`)) {
+		t.Errorf("missing entry fields: %s", b)
+	}
+	n, err := Verify(bytes.NewReader(b), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := n, 4; have != want {
+		t.Errorf("entries: have %d, want %d", have, want)
+	}
+
+	// tampering
+	lines := bytes.Split(bytes.TrimSpace(b), []byte("\n"))
+	edited := bytes.Replace(b, []byte("DeviceLock"), []byte("DeviceInformation"), 1)
+	if _, err = Verify(bytes.NewReader(edited), nil); err == nil {
+		t.Error("expected edited entry to fail verification")
+	}
+	removed := bytes.Join([][]byte{lines[0], lines[2]}, []byte("\n"))
+	if _, err = Verify(bytes.NewReader(removed), nil); err == nil {
+		t.Error("expected removed entry to fail verification")
+	}
+	truncated := bytes.Join(lines[1:], []byte("\n"))
+	if _, err = Verify(bytes.NewReader(truncated), pub); err == nil {
+		t.Error("expected truncated head to fail verification")
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err = Verify(bytes.NewReader(b), otherPub); err == nil {
+		t.Error("expected other key to fail verification")
+	}
+}
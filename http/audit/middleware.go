@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// statusWriter records the HTTP status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+type contextKeyEntry struct{}
+
+// EntryFromContext returns the pending audit entry of the request from
+// ctx or nil if the request is not audited. Handlers may annotate the
+// entry (e.g. with a policy decision) before it is appended.
+func EntryFromContext(ctx context.Context) *Entry {
+	e, _ := ctx.Value(contextKeyEntry{}).(*Entry)
+	return e
+}
+
+// Middleware appends an entry to l for each request that may change
+// state (see http.IsWrite) after it is handled.
+// Requests with an MDM command body (e.g. enqueueing) have the command
+// request type and UUID recorded. Requests are not failed if the audit
+// log can not be written; the error is logged instead.
+func Middleware(next http.Handler, l *Log, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !mdmhttp.IsWrite(r.Method, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		e := &Entry{
+			Time:       time.Now().UTC(),
+			RemoteAddr: r.RemoteAddr,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+		}
+		e.User, _, _ = r.BasicAuth()
+		if b, err := mdmhttp.ReadAllAndReplaceBody(r); err == nil && len(b) > 0 {
+			if cmd, err := mdm.DecodeCommand(b); err == nil {
+				e.RequestType, e.CommandUUID = cmd.Command.RequestType, cmd.CommandUUID
+			}
+		}
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), contextKeyEntry{}, e)))
+		e.Status = sw.status
+		if e.Status == 0 {
+			// nothing written means an implicit 200
+			e.Status = http.StatusOK
+		}
+		if err := l.Append(e); err != nil {
+			ctxlog.Logger(r.Context(), logger).Info("msg", "writing audit log", "err", err)
+		}
+	}
+}
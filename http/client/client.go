@@ -0,0 +1,104 @@
+// Package client configures HTTP clients for outbound requests.
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+type config struct {
+	timeout   time.Duration
+	rootCAs   *x509.CertPool
+	certs     []tls.Certificate
+	proxy     *url.URL
+	retries   int
+	retryWait time.Duration
+}
+
+// Option configures an HTTP client.
+type Option func(*config) error
+
+// WithTimeout sets the overall timeout for requests (including
+// retries).
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) error {
+		c.timeout = timeout
+		return nil
+	}
+}
+
+// WithRootCAsPEM validates servers against the PEM-encoded CA
+// certificates rather than the system roots.
+func WithRootCAsPEM(caPEM []byte) Option {
+	return func(c *config) error {
+		c.rootCAs = x509.NewCertPool()
+		if !c.rootCAs.AppendCertsFromPEM(caPEM) {
+			return errors.New("could not append CA certificate(s)")
+		}
+		return nil
+	}
+}
+
+// WithClientCertificate presents cert to servers requesting client
+// certificate (mutual TLS) authentication.
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(c *config) error {
+		c.certs = append(c.certs, cert)
+		return nil
+	}
+}
+
+// WithProxy sends requests via the proxy at proxyURL. By default the
+// proxy is configured from the environment.
+func WithProxy(proxyURL string) Option {
+	return func(c *config) (err error) {
+		c.proxy, err = url.Parse(proxyURL)
+		return
+	}
+}
+
+// WithRetry retries failed requests up to retries times. The wait
+// duration doubles after each attempt.
+func WithRetry(retries int, wait time.Duration) Option {
+	return func(c *config) error {
+		c.retries = retries
+		c.retryWait = wait
+		return nil
+	}
+}
+
+// New creates a new HTTP client.
+func New(opts ...Option) (*http.Client, error) {
+	c := &config{retryWait: time.Second}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if c.rootCAs != nil || len(c.certs) > 0 {
+		transport.TLSClientConfig = &tls.Config{
+			RootCAs:      c.rootCAs,
+			Certificates: c.certs,
+		}
+	}
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   c.timeout,
+	}
+	if c.retries > 0 {
+		client.Transport = &retryTransport{
+			next:    transport,
+			retries: c.retries,
+			wait:    c.retryWait,
+		}
+	}
+	return client, nil
+}
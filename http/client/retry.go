@@ -0,0 +1,56 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+// retryTransport retries requests that fail with network errors or
+// with HTTP statuses that indicate a temporary server problem.
+type retryTransport struct {
+	next    http.RoundTripper
+	retries int
+	wait    time.Duration
+}
+
+// retryable reports whether the response status indicates the request
+// may succeed if retried.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests ||
+		status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// RoundTrip executes the request, retrying as necessary. Requests with
+// bodies are only retried if the body can be re-read (i.e. GetBody is
+// set, as it is for requests created from in-memory buffers).
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait := t.wait
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.retries || (err == nil && !retryable(resp.StatusCode)) {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
@@ -0,0 +1,142 @@
+// Package files serves static files (such as InstallApplication
+// manifests and packages) to MDM clients using signed, expiring URLs.
+//
+// The signature acts as the authentication token for the file: anyone
+// with the URL can fetch the file until it expires. Signed URLs are
+// created with the API using the shared secret key.
+package files
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Signed URL query parameters.
+const (
+	ParamExpires   = "expires"
+	ParamSignature = "signature"
+)
+
+var (
+	ErrMissingSignature = errors.New("missing signature")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpired          = errors.New("signature expired")
+)
+
+// Signer signs and verifies file paths with an expiry using HMAC-SHA256.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a new signer using the secret key.
+func NewSigner(key []byte) (*Signer, error) {
+	if len(key) < 1 {
+		return nil, errors.New("empty signing key")
+	}
+	return &Signer{key: key}, nil
+}
+
+// CleanPath returns the canonical form of a file path that is signed.
+func CleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func (s *Signer) mac(p string, expires int64) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(strconv.FormatInt(expires, 10)))
+	h.Write([]byte{0})
+	h.Write([]byte(CleanPath(p)))
+	return h.Sum(nil)
+}
+
+// Sign returns the signed URL query parameters for path p that expire
+// at expires.
+func (s *Signer) Sign(p string, expires time.Time) url.Values {
+	exp := expires.Unix()
+	return url.Values{
+		ParamExpires:   []string{strconv.FormatInt(exp, 10)},
+		ParamSignature: []string{hex.EncodeToString(s.mac(p, exp))},
+	}
+}
+
+// Verify checks the signed URL query parameters q for path p at now.
+func (s *Signer) Verify(p string, q url.Values, now time.Time) error {
+	sig, expStr := q.Get(ParamSignature), q.Get(ParamExpires)
+	if sig == "" || expStr == "" {
+		return ErrMissingSignature
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	sigBytes, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(sigBytes, s.mac(p, exp)) {
+		return ErrInvalidSignature
+	}
+	if now.Unix() > exp {
+		return ErrExpired
+	}
+	return nil
+}
+
+// openFile opens the regular (non-directory) file p in fs.
+func openFile(fs http.FileSystem, p string) (http.File, os.FileInfo, error) {
+	f, err := fs.Open(p)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = fmt.Errorf("%s: is a directory", p)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, fi, nil
+}
+
+// Handler serves files from fs to requests with a valid signature for
+// the URL path. Directories are not served. The URL path is the file
+// path which probably necessitates stripping the URL prefix before
+// using.
+func Handler(fs http.FileSystem, signer *Signer, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := CleanPath(r.URL.Path)
+		logger := ctxlog.Logger(r.Context(), logger).With("path", p)
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err := signer.Verify(p, r.URL.Query(), time.Now()); err != nil {
+			logger.Info("msg", "verifying signature", "err", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		f, fi, err := openFile(fs, p)
+		if err != nil {
+			logger.Info("msg", "opening file", "err", err)
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		if path.Ext(p) == ".plist" {
+			// not in the standard MIME type tables
+			w.Header().Set("Content-Type", "application/xml")
+		}
+		logger.Debug("msg", "serving file", "size", fi.Size())
+		http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+	}
+}
@@ -0,0 +1,144 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestSigner(t *testing.T) {
+	signer, err := NewSigner([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	q := signer.Sign("/manifest.plist", now.Add(time.Hour))
+
+	if err := signer.Verify("manifest.plist", q, now); err != nil {
+		t.Errorf("expected valid signature: %v", err)
+	}
+	if have, want := signer.Verify("/other.plist", q, now), ErrInvalidSignature; have != want {
+		t.Errorf("other path: have %v, want %v", have, want)
+	}
+	if have, want := signer.Verify("/manifest.plist", q, now.Add(2*time.Hour)), ErrExpired; have != want {
+		t.Errorf("expired: have %v, want %v", have, want)
+	}
+	if have, want := signer.Verify("/manifest.plist", url.Values{}, now), ErrMissingSignature; have != want {
+		t.Errorf("unsigned: have %v, want %v", have, want)
+	}
+
+	// extending the expiry must invalidate the signature
+	q.Set(ParamExpires, "99999999999")
+	if have, want := signer.Verify("/manifest.plist", q, now), ErrInvalidSignature; have != want {
+		t.Errorf("tampered expiry: have %v, want %v", have, want)
+	}
+
+	other, _ := NewSigner([]byte("other"))
+	if have, want := other.Verify("/manifest.plist", signer.Sign("/manifest.plist", now.Add(time.Hour)), now), ErrInvalidSignature; have != want {
+		t.Errorf("other key: have %v, want %v", have, want)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	fs := http.FS(fstest.MapFS{
+		"apps/manifest.plist": &fstest.MapFile{Data: []byte("<plist/>")},
+		"apps/app.pkg":        &fstest.MapFile{Data: []byte("package")},
+	})
+	signer, _ := NewSigner([]byte("secret"))
+
+	mux := http.NewServeMux()
+	mux.Handle(EndpointFiles, http.StripPrefix("/files", Handler(fs, signer, log.NopLogger)))
+	mux.Handle(EndpointSign, http.StripPrefix("/v1/files", SignHandler(fs, signer, "/files", time.Hour, log.NopLogger)))
+	mux.Handle(EndpointManifest, http.StripPrefix("/v1/manifests", ManifestHandler(fs, signer, "/files", time.Hour, log.NopLogger)))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/files/apps/manifest.plist", nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("sign status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	var resp signResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", resp.URL, nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("file status: have %d, want %d", have, want)
+	}
+	if have, want := rec.Body.String(), "<plist/>"; have != want {
+		t.Errorf("body: have %q, want %q", have, want)
+	}
+	if have, want := rec.Header().Get("Content-Type"), "application/xml"; have != want {
+		t.Errorf("content type: have %q, want %q", have, want)
+	}
+
+	for _, test := range []struct {
+		path string
+		code int
+	}{
+		{"/files/apps/manifest.plist", http.StatusForbidden},
+		{"/v1/files/missing.plist", http.StatusNotFound},
+		{"/v1/files/apps", http.StatusNotFound},
+		{"/v1/files/apps/manifest.plist?expiry=bogus", http.StatusBadRequest},
+		{"/v1/manifests/apps/app.pkg", http.StatusBadRequest},
+		{"/v1/manifests/apps/missing.pkg?bundle_id=com.example.app&version=1", http.StatusNotFound},
+	} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+		if rec.Code != test.code {
+			t.Errorf("%s: have %d, want %d", test.path, rec.Code, test.code)
+		}
+	}
+
+	// a signed directory is still not served
+	q := signer.Sign("/apps", time.Now().Add(time.Hour))
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/files/apps?"+q.Encode(), nil))
+	if have, want := rec.Code, http.StatusNotFound; have != want {
+		t.Errorf("directory: have %d, want %d", have, want)
+	}
+}
+
+func TestManifestHandler(t *testing.T) {
+	fs := http.FS(fstest.MapFS{
+		"app.pkg": &fstest.MapFile{Data: []byte("package")},
+	})
+	signer, _ := NewSigner([]byte("secret"))
+	handler := ManifestHandler(fs, signer, "https://mdm.example.com/files/", time.Hour, log.NopLogger)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/app.pkg?bundle_id=com.example.app&version=1.0", nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	var m mdm.Manifest
+	if err := plist.Unmarshal(rec.Body.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Items) != 1 || len(m.Items[0].Assets) != 1 {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	if have, want := m.Items[0].Metadata.Title, "app.pkg"; have != want {
+		t.Errorf("title: have %q, want %q", have, want)
+	}
+	u, err := url.Parse(m.Items[0].Assets[0].URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := u.Path, "/files/app.pkg"; have != want {
+		t.Errorf("url path: have %q, want %q", have, want)
+	}
+	if err = signer.Verify("/app.pkg", u.Query(), time.Now()); err != nil {
+		t.Errorf("package url signature: %v", err)
+	}
+}
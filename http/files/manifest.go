@@ -0,0 +1,81 @@
+package files
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ManifestHandler generates an app manifest plist for a macOS package
+// in fs. The URL path is the package file path which probably
+// necessitates stripping the URL prefix before using. The package URL
+// in the manifest is signed and prefixed with baseURL (see SignHandler)
+// and expires after expiry unless overridden with the "expiry" query
+// parameter. The "bundle_id" and "version" query parameters are
+// required and "title" defaults to the package file name. The
+// "chunk_size" query parameter overrides the checksum chunk size.
+func ManifestHandler(fs http.FileSystem, signer *Signer, baseURL string, expiry time.Duration, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := CleanPath(r.URL.Path)
+		logger := ctxlog.Logger(r.Context(), logger).With("path", p)
+		q := r.URL.Query()
+		meta := mdm.ManifestMetadata{
+			BundleIdentifier: q.Get("bundle_id"),
+			BundleVersion:    q.Get("version"),
+			Title:            q.Get("title"),
+		}
+		if meta.BundleIdentifier == "" || meta.BundleVersion == "" {
+			http.Error(w, "missing bundle_id or version", http.StatusBadRequest)
+			return
+		}
+		if meta.Title == "" {
+			meta.Title = path.Base(p)
+		}
+		exp := expiry
+		if expStr := q.Get("expiry"); expStr != "" {
+			var err error
+			if exp, err = time.ParseDuration(expStr); err != nil || exp <= 0 {
+				http.Error(w, "invalid expiry", http.StatusBadRequest)
+				return
+			}
+		}
+		var chunkSize int64
+		if sizeStr := q.Get("chunk_size"); sizeStr != "" {
+			var err error
+			if chunkSize, err = strconv.ParseInt(sizeStr, 10, 64); err != nil || chunkSize < 1 {
+				http.Error(w, "invalid chunk_size", http.StatusBadRequest)
+				return
+			}
+		}
+		f, _, err := openFile(fs, p)
+		if err != nil {
+			logger.Info("msg", "opening file", "err", err)
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		expires := time.Now().Add(exp).Truncate(time.Second)
+		manifest, err := mdm.NewPackageManifest(f, signedURL(signer, baseURL, p, expires), chunkSize, meta)
+		if err != nil {
+			logger.Info("msg", "generating manifest", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		out, err := plist.MarshalIndent(manifest, "\t")
+		if err != nil {
+			logger.Info("msg", "marshal manifest", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		logger.Debug("msg", "generated manifest", "size", manifest.Items[0].Metadata.SizeInBytes)
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(out)
+	}
+}
@@ -0,0 +1,74 @@
+package files
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Endpoint paths.
+const (
+	EndpointFiles    = "/files/"
+	EndpointSign     = "/v1/files/"
+	EndpointManifest = "/v1/manifests/"
+)
+
+type signResponse struct {
+	URL     string    `json:"url,omitempty"`
+	Expires time.Time `json:"expires,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// signedURL returns the signed URL of path p below baseURL.
+func signedURL(signer *Signer, baseURL, p string, expires time.Time) string {
+	return strings.TrimSuffix(baseURL, "/") + p + "?" + signer.Sign(p, expires).Encode()
+}
+
+// SignHandler creates signed URLs for files in fs. The URL path is the
+// file path which probably necessitates stripping the URL prefix before
+// using. The signed URL is prefixed with baseURL (the external URL of
+// the file Handler, e.g. "https://mdm.example.com/files"). URLs expire
+// after expiry unless overridden with the "expiry" query parameter
+// (e.g. "?expiry=1h").
+func SignHandler(fs http.FileSystem, signer *Signer, baseURL string, expiry time.Duration, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p := CleanPath(r.URL.Path)
+		logger := ctxlog.Logger(r.Context(), logger).With("path", p)
+		if p == "/" {
+			writeJSON(w, http.StatusBadRequest, &signResponse{Error: "missing file path"})
+			return
+		}
+		exp := expiry
+		if expStr := r.URL.Query().Get("expiry"); expStr != "" {
+			var err error
+			if exp, err = time.ParseDuration(expStr); err != nil || exp <= 0 {
+				writeJSON(w, http.StatusBadRequest, &signResponse{Error: "invalid expiry"})
+				return
+			}
+		}
+		f, _, err := openFile(fs, p)
+		if err != nil {
+			logger.Info("msg", "opening file", "err", err)
+			writeJSON(w, http.StatusNotFound, &signResponse{Error: "file not found"})
+			return
+		}
+		f.Close()
+		expires := time.Now().Add(exp).Truncate(time.Second)
+		resp := &signResponse{
+			URL:     signedURL(signer, baseURL, p, expires),
+			Expires: expires,
+		}
+		logger.Debug("msg", "signed file URL", "expires", expires)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
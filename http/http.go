@@ -9,6 +9,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/ctxlog"
@@ -87,3 +88,134 @@ func TraceLoggingMiddleware(next http.Handler, logger log.Logger, traceID func(*
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
+
+// headerWriter applies headers to the response just before the
+// header is written.
+type headerWriter struct {
+	http.ResponseWriter
+	headers http.Header
+	wrote   bool
+}
+
+func (w *headerWriter) apply() {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	for k, v := range w.headers {
+		if len(v) < 1 || (len(v) == 1 && v[0] == "") {
+			w.ResponseWriter.Header().Del(k)
+			continue
+		}
+		w.ResponseWriter.Header()[k] = v
+	}
+}
+
+func (w *headerWriter) WriteHeader(statusCode int) {
+	w.apply()
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *headerWriter) Write(b []byte) (int, error) {
+	w.apply()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ResponseHeadersMiddleware overrides response headers set by next
+// with headers. A header with an empty value is removed from the
+// response which allows suppressing headers set by next.
+func ResponseHeadersMiddleware(next http.Handler, headers http.Header) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerWriter{ResponseWriter: w, headers: headers}
+		next.ServeHTTP(hw, r)
+		// make sure headers are applied for handlers that write nothing
+		hw.apply()
+	}
+}
+
+// MaxBodySizeMiddleware rejects requests with bodies larger than max
+// bytes with an HTTP 413. The body is read up-front so that later
+// handlers never see a partial body.
+func MaxBodySizeMiddleware(next http.Handler, max int64, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			ctxlog.Logger(r.Context(), logger).Info("msg", "request body too large", "size", r.ContentLength)
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(r.Body, max+1))
+		r.Body.Close()
+		if err != nil {
+			ctxlog.Logger(r.Context(), logger).Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if int64(len(b)) > max {
+			ctxlog.Logger(r.Context(), logger).Info("msg", "request body too large", "size", len(b))
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(b))
+		next.ServeHTTP(w, r)
+	}
+}
+
+// Mux registers HTTP handlers for URL path patterns.
+// It is satisfied by *http.ServeMux and many third-party routers.
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// MuxFunc adapts a function to a Mux. This is useful for routers
+// whose Handle methods have a different signature.
+type MuxFunc func(pattern string, handler http.Handler)
+
+// Handle calls f(pattern, handler).
+func (f MuxFunc) Handle(pattern string, handler http.Handler) {
+	f(pattern, handler)
+}
+
+// Middleware wraps an HTTP handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps h with middleware. The first middleware is the outermost.
+func Chain(h http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// WriteEndpoints are the API endpoints whose requests change state
+// regardless of the HTTP method (e.g. the MicroMDM compatible push API
+// which uses GET). Endpoints ending in a slash match all paths below
+// them.
+var WriteEndpoints = []string{
+	"/v1/pushcert",
+	"/v1/push/",
+	"/v1/enqueue/",
+	"/migration",
+	// the MicroMDM compatible API
+	"/v1/commands",
+	"/push/",
+}
+
+// IsWrite reports whether a request with method to the URL path may
+// change state. That is all but GET and HEAD requests and every request
+// to WriteEndpoints.
+func IsWrite(method, path string) bool {
+	if method != http.MethodGet && method != http.MethodHead {
+		return true
+	}
+	for _, endpoint := range WriteEndpoints {
+		if endpoint == path || (strings.HasSuffix(endpoint, "/") && strings.HasPrefix(path, endpoint)) {
+			return true
+		}
+	}
+	return false
+}
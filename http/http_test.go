@@ -0,0 +1,75 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestResponseHeadersMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Remove", "1")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	})
+	headers := http.Header{
+		"X-Remove":      []string{""},
+		"Cache-Control": []string{"no-store"},
+		"X-Custom":      []string{"a", "b"},
+	}
+	rec := httptest.NewRecorder()
+	ResponseHeadersMiddleware(next, headers).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if _, ok := rec.Header()["X-Remove"]; ok {
+		t.Error("expected X-Remove header to be removed")
+	}
+	if have, want := rec.Header().Get("Cache-Control"), "no-store"; have != want {
+		t.Errorf("Cache-Control: have %q, want %q", have, want)
+	}
+	if have, want := len(rec.Header().Values("X-Custom")), 2; have != want {
+		t.Errorf("X-Custom values: have %d, want %d", have, want)
+	}
+	if have, want := rec.Body.String(), "hello"; have != want {
+		t.Errorf("body: have %q, want %q", have, want)
+	}
+
+	// handlers that write nothing still get the headers
+	rec = httptest.NewRecorder()
+	ResponseHeadersMiddleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), headers).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if have, want := rec.Header().Get("Cache-Control"), "no-store"; have != want {
+		t.Errorf("empty handler Cache-Control: have %q, want %q", have, want)
+	}
+}
+
+func TestMaxBodySizeMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	})
+	h := MaxBodySizeMiddleware(next, 5, log.NopLogger)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/", strings.NewReader("hello")))
+	if have, want := rec.Body.String(), "hello"; have != want {
+		t.Errorf("body: have %q, want %q", have, want)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("PUT", "/", strings.NewReader("hello!")))
+	if have, want := rec.Code, http.StatusRequestEntityTooLarge; have != want {
+		t.Errorf("status: have %d, want %d", have, want)
+	}
+
+	// unknown content length
+	req := httptest.NewRequest("PUT", "/", io.MultiReader(strings.NewReader("hello!")))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if have, want := rec.Code, http.StatusRequestEntityTooLarge; have != want {
+		t.Errorf("status: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,98 @@
+package ipfilter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+type countryRange struct {
+	start, end net.IP // 16-byte form
+	country    string
+}
+
+// CSVCountryDB is a GeoIP country database loaded from CSV.
+type CSVCountryDB struct {
+	ranges []countryRange
+}
+
+// LoadCSVCountryDB loads a GeoIP country database from a CSV file of
+// "network,country" records where network is in CIDR notation and
+// country is an ISO 3166-1 alpha-2 country code. A header record
+// (starting with "network") and records starting with "#" are skipped.
+// Networks must not overlap.
+func LoadCSVCountryDB(path string) (*CSVCountryDB, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseCSVCountryDB(f)
+}
+
+// ParseCSVCountryDB parses a GeoIP country database from r.
+// See LoadCSVCountryDB for the format.
+func ParseCSVCountryDB(r io.Reader) (*CSVCountryDB, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.Comment = '#'
+	db := new(CSVCountryDB)
+	for {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(rec) < 2 {
+			return nil, fmt.Errorf("invalid record: %v", rec)
+		}
+		if rec[0] == "network" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(strings.TrimSpace(rec[0]))
+		if err != nil {
+			return nil, err
+		}
+		start := n.IP.To16()
+		end := make(net.IP, len(start))
+		mask := n.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12], mask...)
+		}
+		for i := range start {
+			end[i] = start[i] | ^mask[i]
+		}
+		db.ranges = append(db.ranges, countryRange{
+			start:   start,
+			end:     end,
+			country: strings.ToUpper(strings.TrimSpace(rec[1])),
+		})
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+	return db, nil
+}
+
+// LookupCountry returns the country code of the network containing ip.
+func (db *CSVCountryDB) LookupCountry(ip net.IP) (string, error) {
+	ip = ip.To16()
+	if ip == nil {
+		return "", errors.New("invalid IP address")
+	}
+	// find the last range starting at or before ip
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+	if i < 0 || bytes.Compare(ip, db.ranges[i].end) > 0 {
+		return "", nil
+	}
+	return db.ranges[i].country, nil
+}
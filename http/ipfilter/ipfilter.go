@@ -0,0 +1,225 @@
+// Package ipfilter restricts HTTP requests by client IP address.
+package ipfilter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// CountryLookup looks up the ISO 3166-1 alpha-2 country code of an IP
+// address, for example from a GeoIP database. An empty country is
+// returned for unknown addresses.
+type CountryLookup interface {
+	LookupCountry(ip net.IP) (string, error)
+}
+
+// Block describes a blocked request.
+type Block struct {
+	IP      string
+	Country string
+	Path    string
+	Reason  string
+}
+
+// BlockFunc is called for every blocked request, e.g. for auditing.
+type BlockFunc func(ctx context.Context, b *Block)
+
+// Filter allows or blocks requests by client IP address and country.
+type Filter struct {
+	allow          []*net.IPNet
+	deny           []*net.IPNet
+	lookup         CountryLookup
+	allowCountries map[string]bool
+	denyCountries  map[string]bool
+	ipHeader       string
+	blockFunc      BlockFunc
+	logger         log.Logger
+}
+
+// Option configures a Filter.
+type Option func(*Filter)
+
+// WithLogger configures a logger on the Filter.
+func WithLogger(logger log.Logger) Option {
+	return func(f *Filter) {
+		f.logger = logger
+	}
+}
+
+// WithAllow only allows requests from the networks in allow.
+func WithAllow(allow []*net.IPNet) Option {
+	return func(f *Filter) {
+		f.allow = allow
+	}
+}
+
+// WithDeny blocks requests from the networks in deny.
+func WithDeny(deny []*net.IPNet) Option {
+	return func(f *Filter) {
+		f.deny = deny
+	}
+}
+
+// WithCountries configures country filtering using lookup. Only
+// requests from the allow countries are allowed (if any) and requests
+// from the deny countries are blocked.
+func WithCountries(lookup CountryLookup, allow, deny []string) Option {
+	return func(f *Filter) {
+		f.lookup = lookup
+		f.allowCountries = countrySet(allow)
+		f.denyCountries = countrySet(deny)
+	}
+}
+
+// WithClientIPHeader takes the client IP address from the last
+// address in header (e.g. "X-Forwarded-For") as set by a trusted
+// reverse proxy rather than the connection's remote address.
+func WithClientIPHeader(header string) Option {
+	return func(f *Filter) {
+		f.ipHeader = header
+	}
+}
+
+// WithBlockFunc sets a function called for every blocked request.
+func WithBlockFunc(blockFunc BlockFunc) Option {
+	return func(f *Filter) {
+		f.blockFunc = blockFunc
+	}
+}
+
+// New creates a new IP address filter.
+func New(opts ...Option) (*Filter, error) {
+	f := &Filter{logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if (len(f.allowCountries) > 0 || len(f.denyCountries) > 0) && f.lookup == nil {
+		return nil, errors.New("country filtering requires a country lookup")
+	}
+	return f, nil
+}
+
+func countrySet(countries []string) map[string]bool {
+	if len(countries) < 1 {
+		return nil
+	}
+	set := make(map[string]bool, len(countries))
+	for _, c := range countries {
+		set[strings.ToUpper(c)] = true
+	}
+	return set
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client IP address of r.
+func (f *Filter) clientIP(r *http.Request) net.IP {
+	addr := r.RemoteAddr
+	if f.ipHeader != "" {
+		if v := r.Header.Get(f.ipHeader); v != "" {
+			parts := strings.Split(v, ",")
+			addr = strings.TrimSpace(parts[len(parts)-1])
+		}
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
+// check returns a non-empty reason if r should be blocked.
+func (f *Filter) check(r *http.Request, b *Block) string {
+	ip := f.clientIP(r)
+	if ip == nil {
+		return "invalid client address"
+	}
+	b.IP = ip.String()
+	if contains(f.deny, ip) {
+		return "denied network"
+	}
+	if len(f.allow) > 0 && !contains(f.allow, ip) {
+		return "network not allowed"
+	}
+	if f.lookup == nil {
+		return ""
+	}
+	country, err := f.lookup.LookupCountry(ip)
+	if err != nil {
+		ctxlog.Logger(r.Context(), f.logger).Info("msg", "country lookup", "ip", b.IP, "err", err)
+	}
+	b.Country = country
+	if f.denyCountries[country] {
+		return "denied country"
+	}
+	if len(f.allowCountries) > 0 && !f.allowCountries[country] {
+		return "country not allowed"
+	}
+	return ""
+}
+
+// Middleware blocks requests not allowed by filter with an HTTP 403.
+func Middleware(next http.Handler, filter *Filter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := &Block{Path: r.URL.Path}
+		if b.Reason = filter.check(r, b); b.Reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctxlog.Logger(r.Context(), filter.logger).Info(
+			"msg", "blocked request",
+			"ip", b.IP,
+			"country", b.Country,
+			"path", b.Path,
+			"reason", b.Reason,
+		)
+		if filter.blockFunc != nil {
+			filter.blockFunc(r.Context(), b)
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+}
+
+// ParseCIDRs parses a comma-separated list of CIDR networks. Bare IP
+// addresses are treated as single address networks.
+func ParseCIDRs(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address: %q", cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
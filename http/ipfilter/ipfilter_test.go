@@ -0,0 +1,99 @@
+package ipfilter
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testDB = `network,country
+# comment
+192.0.2.0/24,us
+198.51.100.0/24,DE
+2001:db8::/32,FR
+`
+
+func TestCSVCountryDB(t *testing.T) {
+	db, err := ParseCSVCountryDB(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]string{
+		"192.0.2.1":     "US",
+		"198.51.100.99": "DE",
+		"203.0.113.1":   "",
+		"2001:db8::1":   "FR",
+		"::1":           "",
+	} {
+		have, err := db.LookupCountry(parseIP(t, ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have != want {
+			t.Errorf("%s: have %q, want %q", ip, have, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	db, err := ParseCSVCountryDB(strings.NewReader(testDB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deny, err := ParseCIDRs("192.0.2.66, 10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []*Block
+	f, err := New(
+		WithDeny(deny),
+		WithCountries(db, []string{"us", "de"}, nil),
+		WithClientIPHeader("X-Forwarded-For"),
+		WithBlockFunc(func(_ context.Context, b *Block) { blocks = append(blocks, b) }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), f)
+
+	for _, test := range []struct {
+		remote string
+		xff    string
+		status int
+	}{
+		{"192.0.2.1:1234", "", http.StatusOK},
+		{"192.0.2.66:1234", "", http.StatusForbidden},
+		{"203.0.113.1:1234", "", http.StatusForbidden},
+		{"127.0.0.1:1234", "203.0.113.1, 198.51.100.1", http.StatusOK},
+		{"127.0.0.1:1234", "198.51.100.1, 203.0.113.1", http.StatusForbidden},
+	} {
+		req := httptest.NewRequest("GET", "/mdm", nil)
+		req.RemoteAddr = test.remote
+		if test.xff != "" {
+			req.Header.Set("X-Forwarded-For", test.xff)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if have, want := rec.Code, test.status; have != want {
+			t.Errorf("%s %s: have %d, want %d", test.remote, test.xff, have, want)
+		}
+	}
+	if have, want := len(blocks), 3; have != want {
+		t.Fatalf("blocks: have %d, want %d", have, want)
+	}
+	if have, want := blocks[0].Reason, "denied network"; have != want {
+		t.Errorf("reason: have %q, want %q", have, want)
+	}
+}
+
+func parseIP(t *testing.T, s string) net.IP {
+	t.Helper()
+	ip := net.ParseIP(s)
+	if ip == nil {
+		t.Fatalf("invalid IP: %s", s)
+	}
+	return ip
+}
@@ -44,6 +44,9 @@ func CheckinHandler(svc service.Checkin, logger log.Logger) http.HandlerFunc {
 			var statusErr *service.HTTPStatusError
 			if errors.As(err, &statusErr) {
 				httpStatus = statusErr.Status
+				for k, v := range statusErr.Header {
+					w.Header()[k] = v
+				}
 				err = fmt.Errorf("HTTP error: %w", statusErr.Unwrap())
 			}
 			// manualy unwrapping the `StatusErr` is not necessary as `errors.As` manually unwraps
@@ -77,6 +80,9 @@ func CommandAndReportResultsHandler(svc service.CommandAndReportResults, logger
 			var statusErr *service.HTTPStatusError
 			if errors.As(err, &statusErr) {
 				httpStatus = statusErr.Status
+				for k, v := range statusErr.Header {
+					w.Header()[k] = v
+				}
 				err = fmt.Errorf("HTTP error: %w", statusErr.Unwrap())
 			}
 			var parseErr *mdm.ParseError
@@ -0,0 +1,80 @@
+package mdm
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// statusWriter records the HTTP status and body size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+// Unwrap returns the underlying ResponseWriter for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// AccessLogMiddleware logs a single structured line for each MDM
+// request after it is handled. Along with the HTTP method, path,
+// status, and duration it includes the enrollment ID, check-in message
+// type, and command UUIDs as collected by the MDM request adapters.
+func AccessLogMiddleware(next http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := new(service.RequestInfo)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(service.NewContextWithRequestInfo(r.Context(), info)))
+		if sw.status == 0 {
+			// nothing written means an implicit 200
+			sw.status = http.StatusOK
+		}
+		logs := []interface{}{
+			"msg", "access",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", sw.status,
+			"size", sw.size,
+			"duration", time.Since(start).String(),
+		}
+		if info.EnrollmentID != "" {
+			logs = append(logs, "id", info.EnrollmentID)
+		}
+		if info.MessageType != "" {
+			logs = append(logs, "message_type", info.MessageType)
+		}
+		if info.CommandUUID != "" {
+			logs = append(logs, "command_uuid", info.CommandUUID)
+		}
+		if info.Status != "" {
+			logs = append(logs, "command_status", info.Status)
+		}
+		if info.NextCommandUUID != "" {
+			logs = append(logs, "next_command_uuid", info.NextCommandUUID)
+		}
+		ctxlog.Logger(r.Context(), logger).Info(logs...)
+	}
+}
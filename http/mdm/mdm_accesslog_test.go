@@ -0,0 +1,56 @@
+package mdm
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/micromdm/nanolib/log/test"
+)
+
+type testCommandService struct{}
+
+func (s *testCommandService) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	r.EnrollID = &mdm.EnrollID{Type: mdm.Device, ID: results.UDID}
+	return &mdm.Command{CommandUUID: "NEXT-UUID", Raw: []byte("next command")}, nil
+}
+
+const testCommandResults = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>RESULT-UUID</string>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>TEST-UDID</string>
+</dict>
+</plist>`
+
+func TestAccessLogMiddleware(t *testing.T) {
+	logger := new(test.Logger)
+	handler := AccessLogMiddleware(CommandAndReportResultsHandler(&testCommandService{}, logger), logger)
+
+	req := httptest.NewRequest("PUT", "/mdm", bytes.NewBufferString(testCommandResults))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if have, want := rr.Code, http.StatusOK; have != want {
+		t.Fatalf("status: have %d, want %d", have, want)
+	}
+
+	test.TestLastLogKeyValueMatches(t, logger, "msg", "access")
+	test.TestLastLogKeyValueMatches(t, logger, "method", "PUT")
+	test.TestLastLogKeyValueMatches(t, logger, "id", "TEST-UDID")
+	test.TestLastLogKeyValueMatches(t, logger, "command_uuid", "RESULT-UUID")
+	test.TestLastLogKeyValueMatches(t, logger, "command_status", "Acknowledged")
+	test.TestLastLogKeyValueMatches(t, logger, "next_command_uuid", "NEXT-UUID")
+
+	if _, status, _ := logger.LastKey("status"); status != http.StatusOK {
+		t.Errorf("logged status: have %v, want %d", status, http.StatusOK)
+	}
+}
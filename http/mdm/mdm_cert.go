@@ -4,7 +4,6 @@ import (
 	"context"
 	"crypto/x509"
 	"net/http"
-	"net/url"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
 	mdmhttp "github.com/micromdm/nanomdm/http"
@@ -26,24 +25,17 @@ var contextEnrollmentID struct{}
 // proxy_set_header directive. Though any reverse proxy setting a
 // similar header could be used, of course.
 func CertExtractPEMHeaderMiddleware(next http.Handler, header string, logger log.Logger) http.HandlerFunc {
+	extractor := PEMHeaderCertExtractor(header)
 	return func(w http.ResponseWriter, r *http.Request) {
 		logger := ctxlog.Logger(r.Context(), logger)
-		escapedCert := r.Header.Get(header)
-		if escapedCert == "" {
-			logger.Debug("msg", "empty header", "header", header)
-			next.ServeHTTP(w, r)
-			return
-		}
-		pemCert, err := url.QueryUnescape(escapedCert)
+		cert, err := extractor.ExtractCert(r)
 		if err != nil {
-			logger.Info("msg", "unescaping header", "header", header, "err", err)
+			logger.Info("msg", "extracting cert", "header", header, "err", err)
 			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
 			return
-		}
-		cert, err := cryptoutil.DecodePEMCertificate([]byte(pemCert))
-		if err != nil {
-			logger.Info("msg", "decoding cert", "header", header, "err", err)
-			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		} else if cert == nil {
+			logger.Debug("msg", "empty header", "header", header)
+			next.ServeHTTP(w, r)
 			return
 		}
 		ctx := context.WithValue(r.Context(), contextKeyCert{}, cert)
@@ -55,15 +47,16 @@ func CertExtractPEMHeaderMiddleware(next http.Handler, header string, logger log
 // certificate from the request into the HTTP request context. It looks
 // at the TLS peer certificate in the request.
 func CertExtractTLSMiddleware(next http.Handler, logger log.Logger) http.HandlerFunc {
+	extractor := TLSCertExtractor()
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 {
+		cert, _ := extractor.ExtractCert(r)
+		if cert == nil {
 			ctxlog.Logger(r.Context(), logger).Debug(
 				"msg", "no TLS peer certificate",
 			)
 			next.ServeHTTP(w, r)
 			return
 		}
-		cert := r.TLS.PeerCertificates[0]
 		ctx := context.WithValue(r.Context(), contextKeyCert{}, cert)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	}
@@ -0,0 +1,175 @@
+package mdm
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	mdmhttp "github.com/micromdm/nanomdm/http"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// CertExtractor extracts the MDM enrollment identity certificate from
+// an HTTP request. A nil certificate and nil error should be returned
+// if the request does not contain a certificate for this extractor.
+type CertExtractor interface {
+	ExtractCert(*http.Request) (*x509.Certificate, error)
+}
+
+// CertExtractorFunc is an adapter to allow using an ordinary function
+// as a CertExtractor.
+type CertExtractorFunc func(*http.Request) (*x509.Certificate, error)
+
+// ExtractCert calls f(r).
+func (f CertExtractorFunc) ExtractCert(r *http.Request) (*x509.Certificate, error) {
+	return f(r)
+}
+
+// TLSCertExtractor extracts the certificate from the TLS peer certificates.
+func TLSCertExtractor() CertExtractor {
+	return CertExtractorFunc(func(r *http.Request) (*x509.Certificate, error) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) < 1 {
+			return nil, nil
+		}
+		return r.TLS.PeerCertificates[0], nil
+	})
+}
+
+// PEMHeaderCertExtractor extracts the certificate from header which
+// should be a URL-encoded PEM certificate. Typically this is set by a
+// TLS-terminating reverse proxy.
+func PEMHeaderCertExtractor(header string) CertExtractor {
+	return CertExtractorFunc(func(r *http.Request) (*x509.Certificate, error) {
+		escapedCert := r.Header.Get(header)
+		if escapedCert == "" {
+			return nil, nil
+		}
+		pemCert, err := url.QueryUnescape(escapedCert)
+		if err != nil {
+			return nil, fmt.Errorf("unescaping header %s: %w", header, err)
+		}
+		cert, err := cryptoutil.DecodePEMCertificate([]byte(pemCert))
+		if err != nil {
+			return nil, fmt.Errorf("decoding cert from header %s: %w", header, err)
+		}
+		return cert, nil
+	})
+}
+
+// MdmSignatureCertExtractor extracts the certificate by verifying the
+// Mdm-Signature header against the request body.
+func MdmSignatureCertExtractor() CertExtractor {
+	return CertExtractorFunc(func(r *http.Request) (*x509.Certificate, error) {
+		mdmSig := r.Header.Get("Mdm-Signature")
+		if mdmSig == "" {
+			return nil, nil
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			return nil, fmt.Errorf("reading body: %w", err)
+		}
+		cert, err := cryptoutil.VerifyMdmSignature(mdmSig, b)
+		if err != nil {
+			return nil, fmt.Errorf("verifying Mdm-Signature header: %w", err)
+		}
+		return cert, nil
+	})
+}
+
+// ChainPolicy determines how the results of multiple CertExtractors
+// are evaluated by CertExtractChainMiddleware.
+type ChainPolicy int
+
+const (
+	// ChainFirst uses the certificate of the first extractor (in
+	// order) that finds one. Later extractors are not evaluated.
+	ChainFirst ChainPolicy = iota
+
+	// ChainConsistent evaluates every extractor and requires that all
+	// extractors that find a certificate find the same certificate.
+	ChainConsistent
+
+	// ChainAll evaluates every extractor and requires that every
+	// extractor finds the same certificate.
+	ChainAll
+)
+
+// ParseChainPolicy parses the named chain policy.
+func ParseChainPolicy(policy string) (ChainPolicy, error) {
+	switch policy {
+	case "", "first":
+		return ChainFirst, nil
+	case "consistent":
+		return ChainConsistent, nil
+	case "all":
+		return ChainAll, nil
+	}
+	return ChainFirst, fmt.Errorf("unknown chain policy: %q", policy)
+}
+
+var (
+	ErrChainMismatch   = errors.New("certificates mismatch")
+	ErrChainIncomplete = errors.New("certificate missing from extractor")
+)
+
+// extractChain evaluates extractors against r according to policy.
+func extractChain(r *http.Request, extractors []CertExtractor, policy ChainPolicy) (*x509.Certificate, error) {
+	var found *x509.Certificate
+	for i, extractor := range extractors {
+		cert, err := extractor.ExtractCert(r)
+		if err != nil {
+			return nil, fmt.Errorf("extractor %d: %w", i, err)
+		}
+		if cert == nil {
+			if policy == ChainAll {
+				return nil, fmt.Errorf("extractor %d: %w", i, ErrChainIncomplete)
+			}
+			continue
+		}
+		if policy == ChainFirst {
+			return cert, nil
+		}
+		if found != nil && !bytes.Equal(found.Raw, cert.Raw) {
+			return nil, fmt.Errorf("extractor %d: %w", i, ErrChainMismatch)
+		}
+		found = cert
+	}
+	return found, nil
+}
+
+// CertExtractChainMiddleware extracts the MDM enrollment identity
+// certificate from the request into the HTTP request context. It
+// evaluates each extractor in order according to policy.
+//
+// This middleware does not error if a certificate is not found
+// (excepting the ChainAll policy). It will, however, error with an
+// HTTP 400 status if any extractor errors or the policy fails.
+func CertExtractChainMiddleware(next http.Handler, extractors []CertExtractor, policy ChainPolicy, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cert, err := extractChain(r, extractors, policy)
+		if err != nil {
+			ctxlog.Logger(r.Context(), logger).Info(
+				"msg", "extracting certificate",
+				"err", err,
+			)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		if cert == nil {
+			ctxlog.Logger(r.Context(), logger).Debug(
+				"msg", "no certificate found",
+			)
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), contextKeyCert{}, cert)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
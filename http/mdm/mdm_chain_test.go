@@ -0,0 +1,50 @@
+package mdm
+
+import (
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func staticExtractor(cert *x509.Certificate) CertExtractor {
+	return CertExtractorFunc(func(_ *http.Request) (*x509.Certificate, error) {
+		return cert, nil
+	})
+}
+
+func TestExtractChain(t *testing.T) {
+	certA := &x509.Certificate{Raw: []byte("A")}
+	certB := &x509.Certificate{Raw: []byte("B")}
+	none := staticExtractor(nil)
+
+	req, err := http.NewRequest("GET", "/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name       string
+		extractors []CertExtractor
+		policy     ChainPolicy
+		cert       *x509.Certificate
+		err        error
+	}{
+		{"first", []CertExtractor{none, staticExtractor(certA), staticExtractor(certB)}, ChainFirst, certA, nil},
+		{"first-none", []CertExtractor{none}, ChainFirst, nil, nil},
+		{"consistent", []CertExtractor{staticExtractor(certA), none, staticExtractor(certA)}, ChainConsistent, certA, nil},
+		{"consistent-mismatch", []CertExtractor{staticExtractor(certA), staticExtractor(certB)}, ChainConsistent, nil, ErrChainMismatch},
+		{"all", []CertExtractor{staticExtractor(certA), staticExtractor(certA)}, ChainAll, certA, nil},
+		{"all-incomplete", []CertExtractor{staticExtractor(certA), none}, ChainAll, nil, ErrChainIncomplete},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cert, err := extractChain(req, test.extractors, test.policy)
+			if !errors.Is(err, test.err) {
+				t.Errorf("have err %v, want %v", err, test.err)
+			}
+			if cert != test.cert {
+				t.Errorf("have cert %v, want %v", cert, test.cert)
+			}
+		})
+	}
+}
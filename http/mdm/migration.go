@@ -0,0 +1,232 @@
+package mdm
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// MigrationResult is the result of migrating a single check-in message.
+type MigrationResult struct {
+	Index       int    `json:"index"`
+	ID          string `json:"id,omitempty"`
+	MessageType string `json:"message_type,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// MigrationResults are the results of a migration batch.
+type MigrationResults struct {
+	Succeeded int                `json:"succeeded"`
+	Failed    int                `json:"failed"`
+	DryRun    bool               `json:"dry_run,omitempty"`
+	Results   []*MigrationResult `json:"results"`
+}
+
+// MigrationMessages returns the check-in messages of a migration request
+// body and whether they were batched. A request with a JSON content type
+// is a batch: a JSON array of check-in message plists as strings.
+// Otherwise the body is a single check-in message.
+func MigrationMessages(r *http.Request, body []byte) ([][]byte, bool, error) {
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		return [][]byte{body}, false, nil
+	}
+	var batch []string
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return nil, true, fmt.Errorf("decoding batch: %w", err)
+	}
+	msgs := make([][]byte, len(batch))
+	for i, msg := range batch {
+		msgs[i] = []byte(msg)
+	}
+	return msgs, true, nil
+}
+
+// MigrationEnrollmentIDs returns the device and (if any) user channel
+// enrollment IDs of the check-in message b using the default ID
+// conventions.
+func MigrationEnrollmentIDs(b []byte) (string, string, error) {
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		return "", "", err
+	}
+	e, ok := msg.(interface {
+		Resolved() *mdm.ResolvedEnrollment
+	})
+	if !ok {
+		return "", "", errors.New("message has no enrollment")
+	}
+	r := e.Resolved()
+	if err = r.Validate(); err != nil {
+		return "", "", err
+	}
+	if r.IsUserChannel {
+		return r.DeviceChannelID, r.DeviceChannelID + ":" + r.UserChannelID, nil
+	}
+	return r.DeviceChannelID, "", nil
+}
+
+// migrationValidator checks the consistency of migrated check-in
+// messages. The push topic of a device must not change within a batch
+// and a push token must not be shared between enrollments.
+type migrationValidator struct {
+	topics map[string]string // device channel ID to topic
+	tokens map[string]string // hex push token to enrollment ID
+}
+
+func newMigrationValidator() *migrationValidator {
+	return &migrationValidator{
+		topics: make(map[string]string),
+		tokens: make(map[string]string),
+	}
+}
+
+// checkTopic checks topic against any earlier topic of the device.
+func (v *migrationValidator) checkTopic(r *mdm.ResolvedEnrollment, topic string) error {
+	if topic == "" {
+		return errors.New("empty topic")
+	}
+	if prev, ok := v.topics[r.DeviceChannelID]; ok && prev != topic {
+		return fmt.Errorf("topic %q does not match earlier topic %q", topic, prev)
+	}
+	v.topics[r.DeviceChannelID] = topic
+	return nil
+}
+
+// validate decodes and checks the check-in message b returning its
+// enrollment ID and message type.
+func (v *migrationValidator) validate(b []byte) (string, string, error) {
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		return "", "", err
+	}
+	var messageType string
+	if m, ok := msg.(interface{ Type() string }); ok {
+		messageType = m.Type()
+	}
+	e, ok := msg.(interface {
+		Resolved() *mdm.ResolvedEnrollment
+	})
+	if !ok {
+		return "", messageType, errors.New("message has no enrollment")
+	}
+	r := e.Resolved()
+	if err = r.Validate(); err != nil {
+		return "", messageType, err
+	}
+	id := r.DeviceChannelID
+	if r.IsUserChannel {
+		id += ":" + r.UserChannelID
+	}
+	switch m := msg.(type) {
+	case *mdm.Authenticate:
+		if r.IsUserChannel {
+			return id, messageType, errors.New("user channel authenticate")
+		}
+		err = v.checkTopic(r, m.Topic)
+	case *mdm.TokenUpdate:
+		if len(m.Token) < 1 {
+			return id, messageType, errors.New("empty push token")
+		}
+		if m.PushMagic == "" {
+			return id, messageType, errors.New("empty push magic")
+		}
+		if err = v.checkTopic(r, m.Topic); err != nil {
+			return id, messageType, err
+		}
+		token := hex.EncodeToString(m.Token)
+		if prev, ok := v.tokens[token]; ok && prev != id {
+			return id, messageType, fmt.Errorf("push token already used by %s", prev)
+		}
+		v.tokens[token] = id
+	}
+	return id, messageType, err
+}
+
+func writeMigrationJSON(w http.ResponseWriter, v interface{}, logger log.Logger) {
+	json, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		logger.Info("msg", "marshal json", "err", err)
+	}
+	w.Header().Set("Content-type", "application/json")
+	_, err = w.Write(json)
+	if err != nil {
+		logger.Info("msg", "writing body", "err", err)
+	}
+}
+
+// MigrationHandler validates migrated check-in messages and adapts them
+// to svc. A single check-in message is handled like CheckinHandler but
+// is rejected if it fails validation. A batch (see MigrationMessages) is
+// validated and handled message by message and the per-message results
+// are returned as JSON. The "dry_run" URL parameter only validates the
+// messages. Only PUT and POST requests are accepted.
+func MigrationHandler(svc service.Checkin, logger log.Logger) http.HandlerFunc {
+	checkin := CheckinHandler(svc, logger)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), logger)
+		bodyBytes, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil {
+			logger.Info("msg", "reading body", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		msgs, batch, err := MigrationMessages(r, bodyBytes)
+		if err != nil {
+			logger.Info("msg", "migration messages", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		dryRun := r.URL.Query().Get("dry_run") != ""
+		v := newMigrationValidator()
+		if !batch {
+			if _, _, err = v.validate(bodyBytes); err != nil {
+				logger.Info("msg", "validating migration", "err", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if dryRun {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			checkin.ServeHTTP(w, r)
+			return
+		}
+		results := &MigrationResults{DryRun: dryRun, Results: make([]*MigrationResult, len(msgs))}
+		for i, msg := range msgs {
+			result := &MigrationResult{Index: i}
+			results.Results[i] = result
+			result.ID, result.MessageType, err = v.validate(msg)
+			if err == nil && !dryRun {
+				_, err = service.CheckinRequest(svc, mdmReqFromHTTPReq(r), msg)
+			}
+			if err != nil {
+				result.Error = err.Error()
+				results.Failed++
+				continue
+			}
+			results.Succeeded++
+		}
+		logger.Info(
+			"msg", "migration batch",
+			"succeeded", results.Succeeded,
+			"failed", results.Failed,
+			"dry_run", dryRun,
+		)
+		writeMigrationJSON(w, results, logger)
+	}
+}
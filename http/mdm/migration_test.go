@@ -0,0 +1,111 @@
+package mdm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+type migrationCheckin struct {
+	service.Checkin
+	tokenUpdates []string
+}
+
+func (s *migrationCheckin) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	s.tokenUpdates = append(s.tokenUpdates, m.UDID)
+	return nil
+}
+
+func migrationTokenUpdate(udid, topic, token string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>magic</string>
+	<key>Token</key>
+	<data>%s</data>
+	<key>Topic</key>
+	<string>%s</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, token, topic, udid)
+}
+
+func TestMigrationHandler(t *testing.T) {
+	svc := &migrationCheckin{}
+	handler := MigrationHandler(svc, log.NopLogger)
+
+	r := httptest.NewRequest("GET", "/migration", strings.NewReader(migrationTokenUpdate("DEV1", "com.apple.mgmt.a", "AAAA")))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusMethodNotAllowed || len(svc.tokenUpdates) > 0 {
+		t.Errorf("get: have %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+
+	// single messages are validated
+	r = httptest.NewRequest("PUT", "/migration", strings.NewReader(migrationTokenUpdate("DEV1", "", "AAAA")))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty topic: have %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	batch := []string{
+		migrationTokenUpdate("DEV1", "com.apple.mgmt.a", "AAAA"),
+		migrationTokenUpdate("DEV1", "com.apple.mgmt.b", "AAAA"),
+		migrationTokenUpdate("DEV2", "com.apple.mgmt.a", "AAAA"),
+		migrationTokenUpdate("DEV3", "com.apple.mgmt.a", ""),
+		"not a plist",
+		migrationTokenUpdate("DEV4", "com.apple.mgmt.a", "BBBB"),
+	}
+	b, err := json.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, dryRun := range []bool{true, false} {
+		svc.tokenUpdates = nil
+		path := "/migration"
+		if dryRun {
+			path += "?dry_run=1"
+		}
+		r = httptest.NewRequest("PUT", path, strings.NewReader(string(b)))
+		r.Header.Set("Content-Type", "application/json")
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("batch: have %d, want %d", rec.Code, http.StatusOK)
+		}
+		results := new(MigrationResults)
+		if err = json.Unmarshal(rec.Body.Bytes(), results); err != nil {
+			t.Fatal(err)
+		}
+		if results.Succeeded != 2 || results.Failed != 4 || results.DryRun != dryRun {
+			t.Errorf("batch (dry run %v): have %d succeeded, %d failed", dryRun, results.Succeeded, results.Failed)
+		}
+		for i, failed := range []bool{false, true, true, true, true, false} {
+			if have := results.Results[i].Error != ""; have != failed {
+				t.Errorf("batch (dry run %v) result %d: error %q", dryRun, i, results.Results[i].Error)
+			}
+		}
+		want := 2
+		if dryRun {
+			want = 0
+		}
+		if len(svc.tokenUpdates) != want {
+			t.Errorf("batch (dry run %v): have %d token updates, want %d", dryRun, len(svc.tokenUpdates), want)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package mdm
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// URLs returns the ServerURL and, with SeparateCheckin, the CheckInURL
+// of the MDM endpoints registered with prefix for enrollment profiles.
+// baseURL is the external URL of the server (e.g. https://mdm.example.com).
+func (h *Handlers) URLs(baseURL, prefix string) (serverURL, checkInURL string) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	mdmPath, checkinPath := EndpointMDM, EndpointCheckin
+	if h.MDMPath != "" {
+		mdmPath = h.MDMPath
+	}
+	if h.CheckinPath != "" {
+		checkinPath = h.CheckinPath
+	}
+	serverURL = baseURL + prefix + mdmPath
+	if h.SeparateCheckin {
+		checkInURL = baseURL + prefix + checkinPath
+	}
+	return
+}
+
+// payload is a payload of an enrollment profile.
+type payload struct {
+	PayloadType        string
+	PayloadIdentifier  string
+	PayloadUUID        string
+	PayloadVersion     int
+	PayloadDisplayName string `plist:",omitempty"`
+
+	// Configuration
+	PayloadContent interface{} `plist:",omitempty"`
+
+	// com.apple.mdm
+	AccessRights            int      `plist:",omitempty"`
+	CheckOutWhenRemoved     bool     `plist:",omitempty"`
+	IdentityCertificateUUID string   `plist:",omitempty"`
+	ServerCapabilities      []string `plist:",omitempty"`
+	ServerURL               string   `plist:",omitempty"`
+	CheckInURL              string   `plist:",omitempty"`
+	SignMessage             bool     `plist:",omitempty"`
+	Topic                   string   `plist:",omitempty"`
+}
+
+// scepContent is the content of a SCEP payload.
+type scepContent struct {
+	URL       string
+	Challenge string `plist:",omitempty"`
+	KeyType   string `plist:"Key Type"`
+	KeyUsage  int    `plist:"Key Usage"`
+	Keysize   int
+}
+
+// newProfileUUID generates a random (version 4) UUID.
+func newProfileUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+// EnrollmentProfile generates an unsigned enrollment profile for the
+// MDM endpoints at serverURL and checkInURL (if not empty) with the APNs
+// topic. With a scepURL the profile includes a SCEP payload for the
+// device identity certificate using challenge. Otherwise an identity
+// payload (referenced by IdentityCertificateUUID) needs to be added to
+// the profile. signMessage corresponds to extracting the device
+// identity certificate from the Mdm-Signature header.
+func EnrollmentProfile(serverURL, checkInURL, topic, scepURL, challenge string, signMessage bool) ([]byte, error) {
+	mdmPayload := &payload{
+		PayloadType:       "com.apple.mdm",
+		PayloadIdentifier: "com.github.micromdm.nanomdm.mdm",
+		PayloadUUID:       newProfileUUID(),
+		PayloadVersion:    1,
+
+		AccessRights:            8191,
+		CheckOutWhenRemoved:     true,
+		IdentityCertificateUUID: newProfileUUID(),
+		ServerCapabilities: []string{
+			"com.apple.mdm.per-user-connections",
+			"com.apple.mdm.bootstraptoken",
+			"com.apple.mdm.token",
+		},
+		ServerURL:   serverURL,
+		CheckInURL:  checkInURL,
+		SignMessage: signMessage,
+		Topic:       topic,
+	}
+	var content []*payload
+	if scepURL != "" {
+		content = append(content, &payload{
+			PayloadType:       "com.apple.security.scep",
+			PayloadIdentifier: "com.github.micromdm.scep",
+			PayloadUUID:       mdmPayload.IdentityCertificateUUID,
+			PayloadVersion:    1,
+			PayloadContent: scepContent{
+				URL:       scepURL,
+				Challenge: challenge,
+				KeyType:   "RSA",
+				KeyUsage:  5,
+				Keysize:   2048,
+			},
+		})
+	}
+	content = append(content, mdmPayload)
+	return plist.MarshalIndent(&payload{
+		PayloadType:        "Configuration",
+		PayloadIdentifier:  "com.github.micromdm.nanomdm",
+		PayloadUUID:        newProfileUUID(),
+		PayloadVersion:     1,
+		PayloadDisplayName: "Enrollment Profile",
+		PayloadContent:     content,
+	}, "\t")
+}
+
+// EnrollmentProfileHandler serves enrollment profiles for the MDM
+// endpoints at serverURL and checkInURL (see URLs). The APNs topic is
+// given with the required topic query parameter and the optional SCEP
+// payload with the scep_url and challenge query parameters.
+func EnrollmentProfileHandler(serverURL, checkInURL string, signMessage bool, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		q := r.URL.Query()
+		topic := q.Get("topic")
+		if topic == "" {
+			http.Error(w, "missing topic", http.StatusBadRequest)
+			return
+		}
+		profile, err := EnrollmentProfile(serverURL, checkInURL, topic, q.Get("scep_url"), q.Get("challenge"), signMessage)
+		if err != nil {
+			logger.Info("msg", "generating enrollment profile", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		if _, err = w.Write(profile); err != nil {
+			logger.Info("msg", "writing body", "err", err)
+		}
+	}
+}
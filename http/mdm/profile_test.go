@@ -0,0 +1,63 @@
+package mdm
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestEnrollmentProfile(t *testing.T) {
+	h := &Handlers{SeparateCheckin: true, MDMPath: "/server", CheckinPath: "/ci"}
+	serverURL, checkInURL := h.URLs("https://mdm.example.com/", "/legacy")
+	if have, want := serverURL, "https://mdm.example.com/legacy/server"; have != want {
+		t.Errorf("server URL: have %q, want %q", have, want)
+	}
+	if have, want := checkInURL, "https://mdm.example.com/legacy/ci"; have != want {
+		t.Errorf("check-in URL: have %q, want %q", have, want)
+	}
+	if _, have := (&Handlers{}).URLs("https://mdm.example.com", ""); have != "" {
+		t.Errorf("check-in URL: have %q, want none", have)
+	}
+
+	handler := EnrollmentProfileHandler(serverURL, checkInURL, true, log.NopLogger)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if have, want := rec.Code, http.StatusBadRequest; have != want {
+		t.Errorf("missing topic: have %d, want %d", have, want)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/?topic=com.apple.mgmt.External.test&scep_url=https://mdm.example.com/scep&challenge=secret", nil))
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Fatalf("have %d, want %d", have, want)
+	}
+	var profile struct {
+		PayloadType    string
+		PayloadContent []map[string]interface{}
+	}
+	if err := plist.Unmarshal(rec.Body.Bytes(), &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.PayloadType != "Configuration" || len(profile.PayloadContent) != 2 {
+		t.Fatalf("unexpected profile: %s", rec.Body.String())
+	}
+	scep, mdm := profile.PayloadContent[0], profile.PayloadContent[1]
+	for key, want := range map[string]interface{}{
+		"PayloadType":             "com.apple.mdm",
+		"ServerURL":               serverURL,
+		"CheckInURL":              checkInURL,
+		"Topic":                   "com.apple.mgmt.External.test",
+		"SignMessage":             true,
+		"IdentityCertificateUUID": scep["PayloadUUID"],
+	} {
+		if have := mdm[key]; have != want {
+			t.Errorf("%s: have %v, want %v", key, have, want)
+		}
+	}
+	if content, _ := scep["PayloadContent"].(map[string]interface{}); content["URL"] != "https://mdm.example.com/scep" || content["Challenge"] != "secret" {
+		t.Errorf("unexpected SCEP payload: %v", scep)
+	}
+}
@@ -0,0 +1,62 @@
+package mdm
+
+import (
+	"net/http"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// MDM endpoint paths.
+const (
+	EndpointMDM     = "/mdm"
+	EndpointCheckin = "/checkin"
+)
+
+// Handlers are the MDM endpoints.
+type Handlers struct {
+	Service service.CheckinAndCommandService
+
+	// SeparateCheckin serves check-ins on their own endpoint and only
+	// commands and results on the MDM endpoint.
+	SeparateCheckin bool
+
+	// MDMPath and CheckinPath override the default EndpointMDM and
+	// EndpointCheckin paths, e.g. to match the ServerURL and
+	// CheckInURL of existing enrollment profiles.
+	MDMPath     string
+	CheckinPath string
+
+	Logger log.Logger
+}
+
+// Register registers the MDM endpoints on mux. Endpoint paths are
+// prefixed with prefix (which should not have a trailing slash) and
+// every handler is wrapped with middleware (typically certificate
+// extraction and verification).
+func (h *Handlers) Register(mux mdmhttp.Mux, prefix string, middleware ...mdmhttp.Middleware) {
+	logger := h.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	mdmPath, checkinPath := EndpointMDM, EndpointCheckin
+	if h.MDMPath != "" {
+		mdmPath = h.MDMPath
+	}
+	if h.CheckinPath != "" {
+		checkinPath = h.CheckinPath
+	}
+	var mdmHandler http.Handler
+	if h.SeparateCheckin {
+		// if we use the check-in handler then only handle commands
+		mdmHandler = CommandAndReportResultsHandler(h.Service, logger.With("handler", "command"))
+		checkinHandler := CheckinHandler(h.Service, logger.With("handler", "checkin"))
+		mux.Handle(prefix+checkinPath, mdmhttp.Chain(checkinHandler, middleware...))
+	} else {
+		// if we don't use a check-in handler then do both
+		mdmHandler = CheckinAndCommandHandler(h.Service, logger.With("handler", "checkin-command"))
+	}
+	mux.Handle(prefix+mdmPath, mdmhttp.Chain(mdmHandler, middleware...))
+}
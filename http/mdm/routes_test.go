@@ -0,0 +1,38 @@
+package mdm
+
+import (
+	"net/http"
+	"testing"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/service/mock"
+)
+
+func TestHandlersRegister(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		handlers *Handlers
+		prefix   string
+		want     []string
+	}{
+		{"default", &Handlers{}, "", []string{"/mdm"}},
+		{"checkin", &Handlers{SeparateCheckin: true}, "/nano", []string{"/nano/checkin", "/nano/mdm"}},
+		{"renamed", &Handlers{SeparateCheckin: true, MDMPath: "/server", CheckinPath: "/ci"}, "/legacy", []string{"/legacy/ci", "/legacy/server"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var have []string
+			test.handlers.Service = new(mock.Service)
+			test.handlers.Register(mdmhttp.MuxFunc(func(pattern string, _ http.Handler) {
+				have = append(have, pattern)
+			}), test.prefix)
+			if len(have) != len(test.want) {
+				t.Fatalf("patterns: have %v, want %v", have, test.want)
+			}
+			for i := range have {
+				if have[i] != test.want[i] {
+					t.Errorf("pattern %d: have %q, want %q", i, have[i], test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package micromdm
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/groob/plist"
+)
+
+// initialisms are the MicroMDM JSON key words that are not simply
+// capitalized in Apple MDM command keys.
+var initialisms = map[string]string{
+	"id":     "ID",
+	"mdm":    "MDM",
+	"os":     "OS",
+	"pin":    "PIN",
+	"udid":   "UDID",
+	"url":    "URL",
+	"uuid":   "UUID",
+	"itunes": "iTunes",
+}
+
+// dataKeys are Apple MDM command keys of data values. MicroMDM JSON
+// encodes these as base64 strings.
+var dataKeys = map[string]bool{
+	"Payload":             true,
+	"UnlockToken":         true,
+	"ProvisioningProfile": true,
+	"Data":                true,
+}
+
+// commandKey converts a MicroMDM snake_case JSON key to an Apple MDM
+// command key, e.g. "manifest_url" to "ManifestURL".
+func commandKey(key string) string {
+	var sb strings.Builder
+	for _, word := range strings.Split(key, "_") {
+		if word == "" {
+			continue
+		}
+		if s, ok := initialisms[word]; ok {
+			sb.WriteString(s)
+			continue
+		}
+		sb.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return sb.String()
+}
+
+// convertValue converts a decoded JSON value to a plist value.
+func convertValue(key string, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return convertObject(v)
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i := range v {
+			var err error
+			if ret[i], err = convertValue(key, v[i]); err != nil {
+				return nil, err
+			}
+		}
+		return ret, nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int64(v), nil
+		}
+		return v, nil
+	case string:
+		if dataKeys[key] {
+			b, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, fmt.Errorf("decoding %s: %w", key, err)
+			}
+			return b, nil
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// convertObject converts a decoded JSON object with MicroMDM keys to a
+// plist dictionary with Apple MDM command keys.
+func convertObject(obj map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if v == nil {
+			continue
+		}
+		key := commandKey(k)
+		var err error
+		if ret[key], err = convertValue(key, v); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// newCommandUUID generates a random (version 4) UUID.
+func newCommandUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newCommandPlist creates a raw MDM command plist from a MicroMDM
+// command request. The "udid" and "request_type" keys are taken from
+// req and the remaining keys become the command payload.
+func newCommandPlist(req map[string]interface{}) (uuid, requestType string, raw []byte, err error) {
+	requestType, _ = req["request_type"].(string)
+	if requestType == "" {
+		return "", "", nil, errors.New("missing request_type")
+	}
+	payload := make(map[string]interface{}, len(req))
+	for k, v := range req {
+		if k == "udid" || k == "request_type" {
+			continue
+		}
+		payload[k] = v
+	}
+	cmd, err := convertObject(payload)
+	if err != nil {
+		return "", "", nil, err
+	}
+	cmd["RequestType"] = requestType
+	uuid = newCommandUUID()
+	raw, err = plist.MarshalIndent(map[string]interface{}{
+		"CommandUUID": uuid,
+		"Command":     cmd,
+	}, "\t")
+	return uuid, requestType, raw, err
+}
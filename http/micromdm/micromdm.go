@@ -0,0 +1,227 @@
+// Package micromdm implements a compatibility layer for the MicroMDM v1
+// command and push APIs on top of NanoMDM.
+//
+// This allows existing MicroMDM automations to be pointed at NanoMDM
+// during a migration. Only the command queueing and push endpoints are
+// supported. Note the NanoMDM webhook already uses the MicroMDM webhook
+// format.
+package micromdm
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/push"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// MicroMDM API endpoint paths.
+const (
+	EndpointCommands = "/v1/commands"
+	EndpointPush     = "/push/"
+)
+
+// APIUsername is the HTTP Basic authentication username of the MicroMDM API.
+const APIUsername = "micromdm"
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type commandPayload struct {
+	CommandUUID string                 `json:"command_uuid"`
+	Command     map[string]interface{} `json:"command"`
+}
+
+type commandResponse struct {
+	Payload *commandPayload `json:"payload"`
+}
+
+// enqueueResult is the part of the NanoMDM enqueue API response used to
+// find out whether the command was queued.
+type enqueueResult struct {
+	CommandError string `json:"command_error"`
+	Status       map[string]struct {
+		CommandError string `json:"command_error"`
+	} `json:"status"`
+}
+
+// bufferedResponse buffers the response of the NanoMDM enqueue handler.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// CommandHandler handles MicroMDM style command requests. The JSON body
+// contains the "udid" of the enrollment, the "request_type" of the
+// command, and the command's keys in snake_case (e.g. "manifest_url"
+// for the InstallApplication ManifestURL key). Data keys (such as the
+// InstallProfile "payload") are base64 encoded. The command is passed
+// to enqueue (typically the NanoMDM enqueue handler, which queues it
+// and sends a push notification) with the udid as the URL path.
+func CommandHandler(enqueue http.Handler, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), logger)
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, &errorResponse{Error: http.StatusText(http.StatusMethodNotAllowed)})
+			return
+		}
+		var req map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Info("msg", "decoding command request", "err", err)
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: err.Error()})
+			return
+		}
+		udid, _ := req["udid"].(string)
+		if udid == "" {
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: "missing udid"})
+			return
+		}
+		uuid, requestType, raw, err := newCommandPlist(req)
+		if err != nil {
+			logger.Info("msg", "creating command", "err", err)
+			writeJSON(w, http.StatusBadRequest, &errorResponse{Error: err.Error()})
+			return
+		}
+		logger = logger.With("id", udid, "command_uuid", uuid, "request_type", requestType)
+		r2 := r.Clone(r.Context())
+		r2.Method = http.MethodPut
+		r2.URL.Path = udid
+		r2.URL.RawPath = ""
+		r2.URL.RawQuery = ""
+		r2.Body = io.NopCloser(bytes.NewReader(raw))
+		r2.ContentLength = int64(len(raw))
+		resp := &bufferedResponse{header: make(http.Header)}
+		enqueue.ServeHTTP(resp, r2)
+		if resp.status == 0 {
+			resp.status = http.StatusOK
+		}
+		delete(req, "udid")
+		payload := &commandResponse{Payload: &commandPayload{
+			CommandUUID: uuid,
+			Command:     req,
+		}}
+		switch {
+		case resp.status == http.StatusAccepted:
+			// e.g. held for approval
+			logger.Debug("msg", "enqueue accepted")
+			writeJSON(w, http.StatusAccepted, payload)
+		case strings.HasPrefix(resp.header.Get("Content-Type"), "application/json"):
+			// the enqueue result. MicroMDM does not report push errors
+			// for queued commands so only check for enqueue errors.
+			result := new(enqueueResult)
+			if err = json.Unmarshal(resp.body.Bytes(), result); err != nil {
+				logger.Info("msg", "decoding enqueue result", "err", err)
+				writeJSON(w, http.StatusInternalServerError, &errorResponse{Error: err.Error()})
+				return
+			}
+			if msg := result.Status[udid].CommandError; msg != "" {
+				result.CommandError = msg
+			}
+			if result.CommandError != "" {
+				logger.Info("msg", "enqueue", "err", result.CommandError)
+				writeJSON(w, http.StatusInternalServerError, &errorResponse{Error: result.CommandError})
+				return
+			}
+			logger.Debug("msg", "enqueue")
+			writeJSON(w, http.StatusCreated, payload)
+		default:
+			// e.g. rejected by a policy
+			msg := strings.TrimSpace(resp.body.String())
+			if msg == "" {
+				msg = http.StatusText(resp.status)
+			}
+			logger.Info("msg", "enqueue", "status", resp.status, "err", msg)
+			writeJSON(w, resp.status, &errorResponse{Error: msg})
+		}
+	}
+}
+
+type pushResponse struct {
+	Status int    `json:"status"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PushHandler handles MicroMDM style push requests. The URL path is
+// the UDID to push to which probably necessitates stripping the URL
+// prefix before using.
+func PushHandler(pusher push.Pusher, logger log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		udid := strings.Trim(r.URL.Path, "/")
+		logger := ctxlog.Logger(r.Context(), logger).With("id", udid)
+		if udid == "" {
+			writeJSON(w, http.StatusBadRequest, &pushResponse{Status: 1, Error: "missing udid"})
+			return
+		}
+		resps, err := pusher.Push(r.Context(), []string{udid})
+		if err == nil && resps[udid] != nil && resps[udid].Err != nil {
+			err = resps[udid].Err
+		}
+		if err != nil {
+			logger.Info("msg", "push", "err", err)
+			writeJSON(w, http.StatusInternalServerError, &pushResponse{Status: 1, Error: err.Error()})
+			return
+		}
+		logger.Debug("msg", "push")
+		resp := &pushResponse{}
+		if resps[udid] != nil {
+			resp.ID = resps[udid].Id
+		}
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// Handlers are the MicroMDM compatible API endpoints. Enqueue is the
+// NanoMDM enqueue handler that commands are passed to.
+type Handlers struct {
+	Enqueue http.Handler
+	Pusher  push.Pusher
+	Logger  log.Logger
+}
+
+// Register registers the MicroMDM compatible endpoints on mux.
+// Endpoint paths are prefixed with prefix (which should not have a
+// trailing slash) and every handler is wrapped with middleware
+// (typically authentication).
+func (h *Handlers) Register(mux mdmhttp.Mux, prefix string, middleware ...mdmhttp.Middleware) {
+	logger := h.Logger
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	var commandHandler http.Handler = CommandHandler(h.Enqueue, logger.With("handler", "micromdm-command"))
+	mux.Handle(prefix+EndpointCommands, mdmhttp.Chain(commandHandler, middleware...))
+
+	// we strip the prefix to use the path as a udid.
+	var pushHandler http.Handler = PushHandler(h.Pusher, logger.With("handler", "micromdm-push"))
+	pushHandler = http.StripPrefix(prefix+EndpointPush, pushHandler)
+	mux.Handle(prefix+EndpointPush, mdmhttp.Chain(pushHandler, middleware...))
+}
@@ -0,0 +1,164 @@
+package micromdm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	httpapi "github.com/micromdm/nanomdm/http/api"
+	"github.com/micromdm/nanomdm/http/policy"
+	"github.com/micromdm/nanomdm/http/stepup"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage/mock"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+func TestCommandKey(t *testing.T) {
+	for k, want := range map[string]string{
+		"manifest_url":     "ManifestURL",
+		"pin":              "PIN",
+		"itunes_store_id":  "iTunesStoreID",
+		"identifier":       "Identifier",
+		"managed_apple_id": "ManagedAppleID",
+	} {
+		if have := commandKey(k); have != want {
+			t.Errorf("%s: have %q, want %q", k, have, want)
+		}
+	}
+}
+
+func TestCommandHandler(t *testing.T) {
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(context.Context, []string, *mdm.Command) (map[string]error, error) {
+		return nil, nil
+	}
+	var pushed []string
+	pusher := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		pushed = append(pushed, ids...)
+		return nil, nil
+	})
+
+	body := `{"udid":"UDID1","request_type":"InstallProfile","payload":"` +
+		base64.StdEncoding.EncodeToString([]byte("profile")) + `","settings":[{"item":"DeviceName","device_name":"x"}]}`
+	enqueue := httpapi.RawCommandEnqueueHandler(store, pusher, nil, store, log.NopLogger)
+	rec := httptest.NewRecorder()
+	CommandHandler(enqueue, log.NopLogger).ServeHTTP(rec, httptest.NewRequest("POST", EndpointCommands, strings.NewReader(body)))
+	if have, want := rec.Code, http.StatusCreated; have != want {
+		t.Fatalf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+
+	var resp commandResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	calls := store.Calls("EnqueueCommand")
+	if len(calls) != 1 {
+		t.Fatalf("expected one enqueue, got %d", len(calls))
+	}
+	cmd := calls[0].Args[2].(*mdm.Command)
+	if cmd.CommandUUID != resp.Payload.CommandUUID {
+		t.Errorf("command UUID: have %q, want %q", cmd.CommandUUID, resp.Payload.CommandUUID)
+	}
+	if !bytes.HasPrefix(cmd.Raw, []byte("<?xml")) {
+		t.Error("expected XML plist command")
+	}
+	decoded, err := mdm.DecodeCommand(cmd.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := decoded.Command.RequestType, "InstallProfile"; have != want {
+		t.Errorf("request type: have %q, want %q", have, want)
+	}
+	var full struct {
+		Command struct {
+			Payload  []byte
+			Settings []struct {
+				Item       string
+				DeviceName string
+			}
+		}
+	}
+	if err = plist.Unmarshal(cmd.Raw, &full); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(full.Command.Payload), "profile"; have != want {
+		t.Errorf("payload: have %q, want %q", have, want)
+	}
+	if len(full.Command.Settings) != 1 || full.Command.Settings[0].DeviceName != "x" {
+		t.Errorf("unexpected settings: %v", full.Command.Settings)
+	}
+	if len(pushed) != 1 || pushed[0] != "UDID1" {
+		t.Errorf("unexpected pushes: %v", pushed)
+	}
+}
+
+func TestCommandHandlerStepUp(t *testing.T) {
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(context.Context, []string, *mdm.Command) (map[string]error, error) {
+		return nil, nil
+	}
+	pusher := pushFunc(func(context.Context, []string) (map[string]*push.Response, error) {
+		return nil, nil
+	})
+	guard, err := stepup.New(nil, stepup.WithTOTPSecret([]byte("12345678901234567890")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue := guard.Middleware(httpapi.RawCommandEnqueueHandler(store, pusher, nil, store, log.NopLogger), "")
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", EndpointCommands, strings.NewReader(`{"udid":"UDID1","request_type":"EraseDevice"}`))
+	CommandHandler(enqueue, log.NopLogger).ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusForbidden; have != want {
+		t.Errorf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	var resp errorResponse
+	if err = json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := resp.Error, stepup.ErrMissing.Error(); have != want {
+		t.Errorf("error: have %q, want %q", have, want)
+	}
+	if calls := store.Calls("EnqueueCommand"); len(calls) != 0 {
+		t.Errorf("expected no enqueue, got %d", len(calls))
+	}
+}
+
+func TestCommandHandlerPolicy(t *testing.T) {
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(context.Context, []string, *mdm.Command) (map[string]error, error) {
+		return nil, nil
+	}
+	pusher := pushFunc(func(context.Context, []string) (map[string]*push.Response, error) {
+		return nil, nil
+	})
+	engine, err := policy.New([]*policy.Rule{{Name: "no-wipe", RequestTypes: []string{"EraseDevice"}, Action: policy.ActionDeny}}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue := engine.Middleware(httpapi.RawCommandEnqueueHandler(store, pusher, nil, store, log.NopLogger), "")
+
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", EndpointCommands, strings.NewReader(`{"udid":"UDID1","request_type":"EraseDevice","pin":"123456"}`))
+	CommandHandler(enqueue, log.NopLogger).ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusForbidden; have != want {
+		t.Errorf("status: have %d, want %d: %s", have, want, rec.Body.String())
+	}
+	if calls := store.Calls("EnqueueCommand"); len(calls) != 0 {
+		t.Errorf("expected no enqueue, got %d", len(calls))
+	}
+}
+
+type pushFunc func(context.Context, []string) (map[string]*push.Response, error)
+
+func (f pushFunc) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	return f(ctx, ids)
+}
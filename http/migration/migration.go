@@ -0,0 +1,329 @@
+// Package migration authenticates requests to the migration endpoint.
+//
+// The migration endpoint accepts check-in messages without device
+// certificate authentication so it uses its own tokens rather than
+// the API key. Each token can be limited to client networks and scoped
+// to a tenant: migrated enrollments are assigned the token tenant and
+// a token can only migrate new enrollments or those of its tenant.
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/http/ipfilter"
+	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/http/rbac"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Token is a migration endpoint credential.
+type Token struct {
+	// Name is the HTTP basic auth username of the token.
+	Name string `json:"name"`
+	// SecretHash is the hex SHA-256 hash of the token secret (the HTTP
+	// basic auth password).
+	SecretHash string `json:"secret_hash"`
+	// Tenant scopes the token to the enrollments of a tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// IPAllow are comma-separated CIDR networks the token may be used
+	// from. Any network is allowed if empty.
+	IPAllow string `json:"ip_allow,omitempty"`
+
+	filter *ipfilter.Filter
+}
+
+// LoadTokens reads a JSON array of tokens from path.
+func LoadTokens(path string) ([]*Token, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []*Token
+	return tokens, json.Unmarshal(b, &tokens)
+}
+
+// TenantStore retrieves enrollments and stores their tenants in their
+// enrollment metadata.
+type TenantStore interface {
+	storage.EnrollmentRetriever
+	storage.EnrollmentMetadataStore
+}
+
+// Authenticator authenticates migration tokens.
+type Authenticator struct {
+	tokens   map[string]*Token
+	store    TenantStore
+	ipHeader string
+	logger   log.Logger
+}
+
+// Option configures an Authenticator.
+type Option func(*Authenticator)
+
+// WithLogger configures a logger on the Authenticator.
+func WithLogger(logger log.Logger) Option {
+	return func(a *Authenticator) {
+		a.logger = logger
+	}
+}
+
+// WithClientIPHeader takes the client IP address for token networks
+// from header. See ipfilter.WithClientIPHeader.
+func WithClientIPHeader(header string) Option {
+	return func(a *Authenticator) {
+		a.ipHeader = header
+	}
+}
+
+// WithTenants enforces and assigns the tenants of tenant-scoped tokens
+// using the enrollments and enrollment metadata in store. Tenant-scoped
+// tokens are rejected without it.
+func WithTenants(store TenantStore) Option {
+	return func(a *Authenticator) {
+		a.store = store
+	}
+}
+
+// New creates a new Authenticator of tokens.
+func New(tokens []*Token, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{tokens: make(map[string]*Token), logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(a)
+	}
+	for _, token := range tokens {
+		if token.Name == "" || token.SecretHash == "" {
+			return nil, errors.New("token without name or secret hash")
+		}
+		if _, ok := a.tokens[token.Name]; ok {
+			return nil, fmt.Errorf("duplicate token: %s", token.Name)
+		}
+		if token.IPAllow != "" {
+			nets, err := ipfilter.ParseCIDRs(token.IPAllow)
+			if err != nil {
+				return nil, fmt.Errorf("token %s: %w", token.Name, err)
+			}
+			filterOpts := []ipfilter.Option{ipfilter.WithAllow(nets), ipfilter.WithLogger(a.logger)}
+			if a.ipHeader != "" {
+				filterOpts = append(filterOpts, ipfilter.WithClientIPHeader(a.ipHeader))
+			}
+			if token.filter, err = ipfilter.New(filterOpts...); err != nil {
+				return nil, err
+			}
+		}
+		a.tokens[token.Name] = token
+	}
+	return a, nil
+}
+
+// authenticate returns the token for username and secret.
+func (a *Authenticator) authenticate(username, secret string) *Token {
+	token, ok := a.tokens[username]
+	if !ok || subtle.ConstantTimeCompare([]byte(rbac.HashSecret(secret)), []byte(token.SecretHash)) != 1 {
+		return nil
+	}
+	return token
+}
+
+// checkTenant returns errTenant unless id belongs to tenant or does
+// not exist yet. It returns whether id still needs the tenant assigned.
+func (a *Authenticator) checkTenant(ctx context.Context, id, tenant string) (bool, error) {
+	meta, err := a.store.RetrieveEnrollmentMetadata(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("retrieving enrollment metadata: %w", err)
+	}
+	if meta != nil && meta.Tenant != "" {
+		if meta.Tenant != tenant {
+			return false, errTenant
+		}
+		return false, nil
+	}
+	// existing enrollments without a tenant can not be claimed
+	enrollments, err := a.store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}})
+	if err != nil {
+		return false, fmt.Errorf("retrieving enrollment: %w", err)
+	}
+	if len(enrollments) > 0 {
+		return false, errTenant
+	}
+	return true, nil
+}
+
+// assignTenant sets the tenant in the enrollment metadata of id.
+func (a *Authenticator) assignTenant(ctx context.Context, id, tenant string) error {
+	meta, err := a.store.RetrieveEnrollmentMetadata(ctx, id)
+	if err != nil {
+		return fmt.Errorf("retrieving enrollment metadata: %w", err)
+	}
+	if meta == nil {
+		meta = new(storage.EnrollmentMetadata)
+	}
+	meta.Tenant = tenant
+	return a.store.StoreEnrollmentMetadata(ctx, id, meta)
+}
+
+var errTenant = errors.New("enrollment not in tenant")
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// statusWriter records the HTTP status and, with capture, the body of
+// a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status  int
+	capture bool
+	body    bytes.Buffer
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.capture {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Middleware authenticates the HTTP basic auth migration token, checks
+// the token networks, and for tenant-scoped tokens enforces and assigns
+// the tenant of the migrated enrollment.
+func (a *Authenticator) Middleware(next http.Handler, realm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), a.logger)
+		username, secret, ok := r.BasicAuth()
+		var token *Token
+		if ok {
+			token = a.authenticate(username, secret)
+		}
+		if token == nil {
+			if ok {
+				logger.Info("msg", "authenticating migration token", "name", username, "err", "invalid token")
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		logger = logger.With("token", token.Name)
+		handler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a.serveTenant(w, r, next, token, logger)
+		}))
+		if token.filter != nil {
+			handler = ipfilter.Middleware(handler, token.filter)
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
+
+// serveTenant serves next enforcing and assigning the tenant of token.
+// The tenant is only assigned to the enrollments that were migrated:
+// the enrollment of a single message answered with HTTP 200 or the
+// enrollments of the messages that succeeded in a batch.
+func (a *Authenticator) serveTenant(w http.ResponseWriter, r *http.Request, next http.Handler, token *Token, logger log.Logger) {
+	if token.Tenant == "" {
+		next.ServeHTTP(w, r)
+		return
+	}
+	if a.store == nil {
+		logger.Info("msg", "tenant-scoped token without tenant storage")
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	b, err := mdmhttp.ReadAllAndReplaceBody(r)
+	if err != nil {
+		logger.Info("msg", "reading body", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	msgs, batch, err := httpmdm.MigrationMessages(r, b)
+	if err != nil {
+		logger.Info("msg", "migration messages", "err", err)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	logger = logger.With("tenant", token.Tenant)
+	var ids, assign, migrated []string
+	for _, msg := range msgs {
+		deviceID, userID, err := httpmdm.MigrationEnrollmentIDs(msg)
+		if err != nil && batch {
+			// invalid batch messages are reported by the handler
+			continue
+		} else if err != nil {
+			logger.Info("msg", "resolving enrollment", "err", err)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, deviceID)
+		if userID != "" {
+			ids = append(ids, userID)
+			migrated = append(migrated, userID)
+		} else {
+			migrated = append(migrated, deviceID)
+		}
+	}
+	for _, id := range ids {
+		needed, err := a.checkTenant(r.Context(), id, token.Tenant)
+		if errors.Is(err, errTenant) {
+			logger.Info("msg", "checking tenant", "id", id, "err", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if err != nil {
+			logger.Info("msg", "checking tenant", "id", id, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if needed && !contains(assign, id) {
+			assign = append(assign, id)
+		}
+	}
+	sw := &statusWriter{ResponseWriter: w, capture: batch}
+	next.ServeHTTP(sw, r)
+	if sw.status != 0 && sw.status != http.StatusOK {
+		return
+	}
+	if batch {
+		results := new(httpmdm.MigrationResults)
+		if err = json.Unmarshal(sw.body.Bytes(), results); err != nil {
+			logger.Info("msg", "decoding migration results", "err", err)
+			return
+		}
+		migrated = nil
+		for _, result := range results.Results {
+			if !results.DryRun && result.Error == "" && result.ID != "" {
+				migrated = append(migrated, result.ID)
+			}
+		}
+	}
+	for _, id := range assign {
+		if !contains(migrated, id) {
+			continue
+		}
+		if err = a.assignTenant(r.Context(), id, token.Tenant); err != nil {
+			logger.Info("msg", "assigning tenant", "id", id, "err", err)
+		}
+	}
+}
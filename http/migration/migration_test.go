@@ -0,0 +1,147 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	httpmdm "github.com/micromdm/nanomdm/http/mdm"
+	"github.com/micromdm/nanomdm/http/rbac"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func tokenUpdate(udid string) string {
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>Topic</key>
+	<string>com.apple.mgmt.test</string>
+	<key>UDID</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, udid)
+}
+
+func TestMiddleware(t *testing.T) {
+	meta := map[string]*storage.EnrollmentMetadata{
+		"DEV2": {Tenant: "other"},
+		"DEV3": {Tags: []string{"keep"}},
+	}
+	// DEV3 and DEV4 are existing enrollments without a tenant
+	enrolled := map[string]bool{"DEV3": true, "DEV4": true}
+	store := &mock.Storage{
+		RetrieveEnrollmentsFunc: func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+			var enrollments []*storage.Enrollment
+			for _, id := range filter.IDs {
+				if enrolled[id] {
+					enrollments = append(enrollments, &storage.Enrollment{ID: id})
+				}
+			}
+			return enrollments, nil
+		},
+		RetrieveEnrollmentMetadataFunc: func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+			if m, ok := meta[id]; ok {
+				c := *m
+				return &c, nil
+			}
+			return nil, nil
+		},
+		StoreEnrollmentMetadataFunc: func(_ context.Context, id string, m *storage.EnrollmentMetadata) error {
+			meta[id] = m
+			return nil
+		},
+	}
+	a, err := New([]*Token{
+		{Name: "all", SecretHash: rbac.HashSecret("s1")},
+		{Name: "acme", SecretHash: rbac.HashSecret("s2"), Tenant: "acme"},
+		{Name: "lan", SecretHash: rbac.HashSecret("s3"), IPAllow: "10.0.0.0/8"},
+	}, WithTenants(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := a.Middleware(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), "nanomdm")
+
+	for _, test := range []struct {
+		user, secret, remote, udid string
+		code                       int
+		tenant                     string
+	}{
+		{"all", "s1", "192.0.2.1:1234", "DEV1", http.StatusOK, ""},
+		{"all", "s2", "192.0.2.1:1234", "DEV1", http.StatusUnauthorized, ""},
+		{"missing", "s1", "192.0.2.1:1234", "DEV1", http.StatusUnauthorized, ""},
+		{"lan", "s3", "192.0.2.1:1234", "DEV1", http.StatusForbidden, ""},
+		{"lan", "s3", "10.1.2.3:1234", "DEV1", http.StatusOK, ""},
+		{"acme", "s2", "192.0.2.1:1234", "DEV1", http.StatusOK, "acme"},
+		{"acme", "s2", "192.0.2.1:1234", "DEV1", http.StatusOK, "acme"},
+		{"acme", "s2", "192.0.2.1:1234", "DEV2", http.StatusForbidden, "other"},
+		{"acme", "s2", "192.0.2.1:1234", "DEV3", http.StatusForbidden, ""},
+		{"acme", "s2", "192.0.2.1:1234", "DEV4", http.StatusForbidden, ""},
+		{"all", "s1", "192.0.2.1:1234", "DEV4", http.StatusOK, ""},
+	} {
+		r := httptest.NewRequest("PUT", "/migration", strings.NewReader(tokenUpdate(test.udid)))
+		r.RemoteAddr = test.remote
+		r.SetBasicAuth(test.user, test.secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != test.code {
+			t.Errorf("%s %s: have %d, want %d", test.user, test.udid, rec.Code, test.code)
+		}
+		var tenant string
+		if m := meta[test.udid]; m != nil {
+			tenant = m.Tenant
+		}
+		if tenant != test.tenant {
+			t.Errorf("%s %s: tenant: have %q, want %q", test.user, test.udid, tenant, test.tenant)
+		}
+	}
+	if tags := meta["DEV3"].Tags; len(tags) != 1 {
+		t.Errorf("DEV3 tags: have %v", tags)
+	}
+
+	// only the enrollments of the batch messages that succeeded are
+	// assigned the tenant
+	batch := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		results := &httpmdm.MigrationResults{Results: []*httpmdm.MigrationResult{
+			{Index: 0, ID: "DEV5"},
+			{Index: 1, ID: "DEV6", Error: "failed"},
+		}}
+		json.NewEncoder(w).Encode(results)
+	}), "nanomdm")
+	body, _ := json.Marshal([]string{tokenUpdate("DEV5"), tokenUpdate("DEV6")})
+	r := httptest.NewRequest("PUT", "/migration", bytes.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.SetBasicAuth("acme", "s2")
+	rec := httptest.NewRecorder()
+	batch.ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusOK; have != want {
+		t.Errorf("batch: have %d, want %d", have, want)
+	}
+	if m := meta["DEV5"]; m == nil || m.Tenant != "acme" {
+		t.Errorf("DEV5: expected tenant assigned, have %+v", m)
+	}
+	if m := meta["DEV6"]; m != nil {
+		t.Errorf("DEV6: expected no tenant assigned, have %+v", m)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, tokens := range [][]*Token{
+		{{Name: "a"}},
+		{{Name: "a", SecretHash: "x"}, {Name: "a", SecretHash: "y"}},
+		{{Name: "a", SecretHash: "x", IPAllow: "bogus"}},
+	} {
+		if _, err := New(tokens); err == nil {
+			t.Errorf("expected error for %v", tokens[0])
+		}
+	}
+}
@@ -0,0 +1,431 @@
+// Package policy evaluates configured rules when commands are enqueued.
+//
+// Rules are evaluated in order and the first matching rule decides
+// whether the command is allowed, denied, requires approval, or is
+// modified before it is enqueued. Commands matching no rule are
+// allowed.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/http/audit"
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Actions.
+const (
+	ActionAllow   = "allow"
+	ActionDeny    = "deny"
+	ActionApprove = "approve"
+	ActionModify  = "modify"
+)
+
+// Rule matches enqueued commands. Empty conditions match everything.
+type Rule struct {
+	Name string `json:"name"`
+
+	// RequestTypes are the command request types the rule matches.
+	RequestTypes []string `json:"request_types,omitempty"`
+
+	// Targets are enrollment ID patterns (see path.Match) of which
+	// any target must match.
+	Targets []string `json:"targets,omitempty"`
+
+	// Groups are enrollment metadata groups of which any target must
+	// be a member.
+	Groups []string `json:"groups,omitempty"`
+
+	// MinTargets matches when at least this many targets are given.
+	MinTargets int `json:"min_targets,omitempty"`
+
+	// Days are the abbreviated weekdays (e.g. "Sat") and Hours is the
+	// time of day range (e.g. "09:00-17:00") the rule matches in.
+	// Ranges ending before they start span midnight.
+	Days  []string `json:"days,omitempty"`
+	Hours string   `json:"hours,omitempty"`
+
+	// Location is the time zone name for Days and Hours. Defaults to
+	// the local time zone.
+	Location string `json:"location,omitempty"`
+
+	// Action is the action to take for matching commands.
+	Action string `json:"action"`
+
+	// Set are the keys of the command dictionary to replace for the
+	// modify action.
+	Set map[string]interface{} `json:"set,omitempty"`
+
+	loc        *time.Location
+	start, end int // minutes of the day
+}
+
+// Decision is the result of evaluating the rules.
+type Decision struct {
+	// Rule is the name of the matching rule (if any).
+	Rule   string
+	Action string
+
+	// Command is the command to enqueue for the modify action.
+	Command *mdm.Command
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// compile validates r and prepares it for matching.
+func (r *Rule) compile() error {
+	switch r.Action {
+	case ActionAllow, ActionDeny, ActionApprove:
+	case ActionModify:
+		if len(r.Set) < 1 {
+			return errors.New("modify action without keys to set")
+		}
+		if _, ok := r.Set["RequestType"]; ok {
+			return errors.New("modify action can not set RequestType")
+		}
+	default:
+		return fmt.Errorf("invalid action: %q", r.Action)
+	}
+	for _, pattern := range r.Targets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("target pattern %q: %w", pattern, err)
+		}
+	}
+	r.loc = time.Local
+	if r.Location != "" {
+		var err error
+		if r.loc, err = time.LoadLocation(r.Location); err != nil {
+			return err
+		}
+	}
+	if r.Hours != "" {
+		start, end, ok := strings.Cut(r.Hours, "-")
+		if !ok {
+			return fmt.Errorf("invalid hours: %q", r.Hours)
+		}
+		var err error
+		if r.start, err = parseClock(strings.TrimSpace(start)); err != nil {
+			return fmt.Errorf("invalid hours: %w", err)
+		}
+		if r.end, err = parseClock(strings.TrimSpace(end)); err != nil {
+			return fmt.Errorf("invalid hours: %w", err)
+		}
+	}
+	return nil
+}
+
+func contains(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTime reports whether now is within the days and hours of r.
+func (r *Rule) matchTime(now time.Time) bool {
+	now = now.In(r.loc)
+	if len(r.Days) > 0 && !contains(r.Days, now.Format("Mon")) {
+		return false
+	}
+	if r.Hours == "" {
+		return true
+	}
+	m := now.Hour()*60 + now.Minute()
+	if r.start <= r.end {
+		return m >= r.start && m < r.end
+	}
+	return m >= r.start || m < r.end
+}
+
+// Engine evaluates rules.
+type Engine struct {
+	rules  []*Rule
+	meta   storage.EnrollmentMetadataStore
+	logger log.Logger
+	now    func() time.Time
+
+	approval   http.Handler
+	singleUser bool
+}
+
+// Option configures an Engine.
+type Option func(*Engine)
+
+// WithLogger configures a logger on the Engine.
+func WithLogger(logger log.Logger) Option {
+	return func(e *Engine) {
+		e.logger = logger
+	}
+}
+
+// WithApprovalHandler configures the handler for requests whose command
+// requires approval. By default these requests are rejected.
+func WithApprovalHandler(h http.Handler) Option {
+	return func(e *Engine) {
+		e.approval = h
+	}
+}
+
+// WithSingleUser configures the Engine for an API with a single user
+// (i.e. without RBAC API keys). Approvals must be made by a different
+// user than the requester so rules with the approve action are an
+// error as their commands could never be approved.
+func WithSingleUser() Option {
+	return func(e *Engine) {
+		e.singleUser = true
+	}
+}
+
+// ErrNoApprover is returned by New for rules requiring approval when
+// there is no user to approve commands.
+var ErrNoApprover = errors.New("approval requires a different user than the requester")
+
+// New creates a new Engine evaluating rules in order. Enrollment
+// groups are retrieved from meta.
+func New(rules []*Rule, meta storage.EnrollmentMetadataStore, opts ...Option) (*Engine, error) {
+	for i, rule := range rules {
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i, rule.Name, err)
+		}
+	}
+	e := &Engine{
+		rules:  rules,
+		meta:   meta,
+		logger: log.NopLogger,
+		now:    time.Now,
+		approval: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "command requires approval", http.StatusForbidden)
+		}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.singleUser {
+		for i, rule := range rules {
+			if rule.Action == ActionApprove {
+				return nil, fmt.Errorf("rule %d (%s): %w", i, rule.Name, ErrNoApprover)
+			}
+		}
+	}
+	return e, nil
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]*Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	d := json.NewDecoder(bytes.NewReader(b))
+	// keep integers as integers for modified commands
+	d.UseNumber()
+	return rules, d.Decode(&rules)
+}
+
+// matchTargets reports whether any of ids matches the target patterns
+// and groups of r.
+func (e *Engine) matchTargets(ctx context.Context, r *Rule, ids []string) (bool, error) {
+	if len(r.Targets) < 1 && len(r.Groups) < 1 {
+		return true, nil
+	}
+	for _, id := range ids {
+		for _, pattern := range r.Targets {
+			if ok, _ := path.Match(pattern, id); ok {
+				return true, nil
+			}
+		}
+		if len(r.Groups) < 1 || e.meta == nil {
+			continue
+		}
+		meta, err := e.meta.RetrieveEnrollmentMetadata(ctx, id)
+		if err != nil {
+			return false, fmt.Errorf("retrieving enrollment metadata: %w", err)
+		}
+		if meta == nil {
+			continue
+		}
+		for _, group := range meta.Groups {
+			if contains(r.Groups, group) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Evaluate returns the decision of the first rule matching cmd enqueued
+// to ids. Commands matching no rule are allowed.
+func (e *Engine) Evaluate(ctx context.Context, cmd *mdm.Command, ids []string) (*Decision, error) {
+	now := e.now()
+	for _, r := range e.rules {
+		if len(r.RequestTypes) > 0 && !contains(r.RequestTypes, cmd.Command.RequestType) {
+			continue
+		}
+		if len(ids) < r.MinTargets || !r.matchTime(now) {
+			continue
+		}
+		if ok, err := e.matchTargets(ctx, r, ids); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+		d := &Decision{Rule: r.Name, Action: r.Action, Command: cmd}
+		if r.Action == ActionModify {
+			var err error
+			if d.Command, err = modify(cmd, r.Set); err != nil {
+				return nil, fmt.Errorf("modifying command: %w", err)
+			}
+		}
+		return d, nil
+	}
+	return &Decision{Action: ActionAllow, Command: cmd}, nil
+}
+
+// plistValue converts JSON-decoded numbers for plist encoding.
+func plistValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = plistValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = plistValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+// modify returns a copy of cmd with keys of its command dictionary set.
+func modify(cmd *mdm.Command, set map[string]interface{}) (*mdm.Command, error) {
+	var m map[string]interface{}
+	if err := plist.Unmarshal(cmd.Raw, &m); err != nil {
+		return nil, err
+	}
+	c, ok := m["Command"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("no command dictionary")
+	}
+	for k, v := range set {
+		c[k] = plistValue(v)
+	}
+	b, err := plist.MarshalIndent(m, "\t")
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(b)
+}
+
+type ctxKeyApproved struct{}
+
+// NewContextWithApproval returns a new context marking the command
+// enqueued with it as approved. The approve action of rules allows
+// approved commands rather than passing them to the approval handler
+// again. Other actions still apply.
+func NewContextWithApproval(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyApproved{}, true)
+}
+
+func approved(ctx context.Context) bool {
+	v, _ := ctx.Value(ctxKeyApproved{}).(bool)
+	return v
+}
+
+// Middleware evaluates the rules for requests below prefix (e.g.
+// "/v1/enqueue/", or empty when wrapping an enqueue handler with the
+// prefix already stripped) that contain an MDM command in the body.
+// The targets are the comma-separated URL path after stripping prefix.
+// Denied requests are rejected, requests requiring approval are passed
+// to the approval handler, and modified commands replace the request
+// body. Requests are evaluated regardless of the HTTP method. Decisions
+// are recorded to the audit log entry of the request, if any.
+func (e *Engine) Middleware(next http.Handler, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil || len(b) < 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cmd, err := mdm.DecodeCommand(b)
+		if err != nil {
+			// not a command; leave it to the handler
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger := ctxlog.Logger(r.Context(), e.logger)
+		ids := strings.Split(strings.TrimPrefix(r.URL.Path, prefix), ",")
+		d, err := e.Evaluate(r.Context(), cmd, ids)
+		if err != nil {
+			logger.Info("msg", "evaluating policy", "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if ae := audit.EntryFromContext(r.Context()); ae != nil {
+			ae.PolicyRule, ae.PolicyAction = d.Rule, d.Action
+		}
+		if d.Rule != "" {
+			logger.Info(
+				"msg", "policy decision",
+				"rule", d.Rule,
+				"action", d.Action,
+				"request_type", cmd.Command.RequestType,
+				"command_uuid", cmd.CommandUUID,
+			)
+		}
+		switch d.Action {
+		case ActionDeny:
+			http.Error(w, fmt.Sprintf("denied by policy: %s", d.Rule), http.StatusForbidden)
+		case ActionApprove:
+			if approved(r.Context()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			e.approval.ServeHTTP(w, r)
+		case ActionModify:
+			r.Body = io.NopCloser(bytes.NewReader(d.Command.Raw))
+			r.ContentLength = int64(len(d.Command.Raw))
+			next.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	}
+}
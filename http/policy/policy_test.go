@@ -0,0 +1,127 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+const lockCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>DeviceLock</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>lock-1</string>
+</dict>
+</plist>`
+
+func TestMiddleware(t *testing.T) {
+	rules := []*Rule{
+		{Name: "no-wipe-execs", RequestTypes: []string{"EraseDevice", "DeviceLock"}, Groups: []string{"execs"}, Action: ActionDeny},
+		{Name: "mass-lock", RequestTypes: []string{"DeviceLock"}, MinTargets: 3, Action: ActionApprove},
+		{Name: "weekend", RequestTypes: []string{"DeviceLock"}, Days: []string{"Sat", "Sun"}, Location: "UTC", Action: ActionApprove},
+		{Name: "lock-message", RequestTypes: []string{"DeviceLock"}, Targets: []string{"LAB*"}, Hours: "22:00-06:00", Location: "UTC", Action: ActionModify, Set: map[string]interface{}{"Message": "Lab closed"}},
+	}
+	store := &mock.Storage{
+		RetrieveEnrollmentMetadataFunc: func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+			if id == "CEO" {
+				return &storage.EnrollmentMetadata{Groups: []string{"execs"}}, nil
+			}
+			return nil, nil
+		},
+	}
+	e, err := New(rules, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body string
+	handler := e.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}), "/v1/enqueue/")
+
+	// a Wednesday
+	wednesday := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		name     string
+		method   string
+		now      time.Time
+		path     string
+		approved bool
+		code     int
+		modify   bool
+	}{
+		{"allowed", "PUT", wednesday.Add(-12 * time.Hour), "/v1/enqueue/ID1", false, http.StatusOK, false},
+		{"group", "PUT", wednesday, "/v1/enqueue/ID1,CEO", false, http.StatusForbidden, false},
+		{"group get", "GET", wednesday, "/v1/enqueue/ID1,CEO", false, http.StatusForbidden, false},
+		{"min targets", "PUT", wednesday, "/v1/enqueue/ID1,ID2,ID3", false, http.StatusForbidden, false},
+		{"weekend", "PUT", wednesday.Add(72 * time.Hour), "/v1/enqueue/ID1", false, http.StatusForbidden, false},
+		{"modify", "PUT", wednesday, "/v1/enqueue/LAB1", false, http.StatusOK, true},
+		{"modify outside hours", "PUT", wednesday.Add(-12 * time.Hour), "/v1/enqueue/LAB1", false, http.StatusOK, false},
+		{"other endpoint", "PUT", wednesday, "/v1/other/CEO", false, http.StatusOK, false},
+		{"approved min targets", "PUT", wednesday.Add(-12 * time.Hour), "/v1/enqueue/ID1,ID2,ID3", true, http.StatusOK, false},
+		{"approved group", "PUT", wednesday, "/v1/enqueue/ID1,CEO", true, http.StatusForbidden, false},
+	} {
+		body = ""
+		e.now = func() time.Time { return test.now }
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(lockCommand))
+		if test.approved {
+			r = r.WithContext(NewContextWithApproval(r.Context()))
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != test.code {
+			t.Errorf("%s: have %d, want %d", test.name, rec.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		cmd, err := mdm.DecodeCommand([]byte(body))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if have, want := cmd.CommandUUID, "lock-1"; have != want {
+			t.Errorf("%s: command UUID: have %q, want %q", test.name, have, want)
+		}
+		if have := strings.Contains(body, "Lab closed"); have != test.modify {
+			t.Errorf("%s: modified: have %v, want %v", test.name, have, test.modify)
+		}
+	}
+
+	if _, err = New([]*Rule{{Name: "bad", Action: "explode"}}, nil); err == nil {
+		t.Error("expected invalid action error")
+	}
+}
+
+func TestSingleUser(t *testing.T) {
+	// a single API user can never approve their own commands
+	rules := []*Rule{
+		{Name: "erase", RequestTypes: []string{"EraseDevice"}, Action: ActionApprove},
+	}
+	if _, err := New(rules, nil, WithSingleUser()); !errors.Is(err, ErrNoApprover) {
+		t.Errorf("have %v, want %v", err, ErrNoApprover)
+	}
+	if _, err := New(rules, nil); err != nil {
+		t.Error(err)
+	}
+	rules[0].Action = ActionDeny
+	if _, err := New(rules, nil, WithSingleUser()); err != nil {
+		t.Error(err)
+	}
+}
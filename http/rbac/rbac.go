@@ -0,0 +1,363 @@
+// Package rbac implements role-based access control for the API.
+//
+// API keys are stored with a role. A role grants the "read" (GET and
+// HEAD requests) and "write" (all other requests, and every request to
+// endpoints that always change state such as pushing, see
+// http.WriteEndpoints) actions on API endpoints. Tenant-scoped roles can additionally only target the
+// enrollments whose enrollment metadata tenant is the API key tenant.
+package rbac
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Actions.
+const (
+	ActionRead  = "read"
+	ActionWrite = "write"
+)
+
+// Built-in role names.
+const (
+	RoleViewer      = "viewer"
+	RoleOperator    = "operator"
+	RoleAdmin       = "admin"
+	RoleTenantAdmin = "tenant-admin"
+)
+
+// AllEndpoints grants an action on every endpoint.
+const AllEndpoints = "*"
+
+// Role is a set of permissions.
+type Role struct {
+	// Read and Write are the endpoints (e.g. "/v1/enqueue/") the role
+	// may read from and write to. Endpoints ending in a slash match
+	// all paths below them.
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
+
+	// Tenant limits the role to targeting enrollments of the API key
+	// tenant. Only endpoints ending in a slash which take enrollment
+	// IDs in the path (e.g. "/v1/enqueue/") can be used.
+	Tenant bool `json:"tenant,omitempty"`
+}
+
+// tenantEndpoints are the endpoints that tenant-admins may use.
+var tenantEndpoints = []string{
+	"/v1/push/",
+	"/v1/enqueue/",
+	"/v1/metadata/",
+	"/v1/enrollments/",
+	"/v1/userchannels/",
+	"/v1/usersessions/",
+	"/v1/dmenablement/",
+	"/v1/ddmstatus/",
+}
+
+// viewerEndpoints are the endpoints that viewers may read. They exclude
+// endpoints returning commands, command results, or events (e.g. the
+// queue, history, and export endpoints), unlock tokens, and API keys.
+var viewerEndpoints = []string{
+	"/v1/pushcerts",
+	"/v1/topicstats",
+	"/v1/summary",
+	"/v1/rollups",
+	"/v1/capabilities",
+	"/v1/roles",
+	"/v1/maintenance",
+	"/v1/repush",
+	"/v1/dmenablement/",
+	"/v1/ddmstatus/",
+	"/v1/metadata/",
+	"/v1/groups/",
+	"/v1/enrollments/",
+	"/v1/resolve/",
+	"/v1/userchannels/",
+	"/v1/usersessions/",
+	"/v1/supersede/",
+	"/v1/campaigns/",
+	"/v1/jobs/",
+	"/v1/freeze/",
+	"/v1/evict/",
+	"/v1/tombstones/",
+	"/v1/templates/",
+	"/v1/declarationsets/",
+	"/v1/enrollmentsets/",
+	"/v1/debugtargets/",
+	"/debug/vars",
+}
+
+// DefaultRoles are the built-in roles.
+var DefaultRoles = map[string]*Role{
+	RoleViewer: {
+		Read: viewerEndpoints,
+	},
+	RoleOperator: {
+		Read: []string{AllEndpoints},
+		Write: []string{
+			"/v1/push/",
+			"/v1/enqueue/",
+			"/v1/campaigns/",
+			"/v1/repush",
+			"/v1/queuedcommands/",
+			// the MicroMDM compatible API
+			"/v1/commands",
+			"/push/",
+		},
+	},
+	RoleAdmin: {
+		Read:  []string{AllEndpoints},
+		Write: []string{AllEndpoints},
+	},
+	RoleTenantAdmin: {
+		Read:   tenantEndpoints,
+		Write:  tenantEndpoints,
+		Tenant: true,
+	},
+}
+
+// LoadRoles reads a JSON object of role names to roles from path.
+func LoadRoles(path string) (map[string]*Role, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roles map[string]*Role
+	return roles, json.Unmarshal(b, &roles)
+}
+
+func matchEndpoint(endpoints []string, path string) (string, bool) {
+	for _, endpoint := range endpoints {
+		if endpoint == AllEndpoints || endpoint == path {
+			return endpoint, true
+		}
+		if strings.HasSuffix(endpoint, "/") && strings.HasPrefix(path, endpoint) {
+			return endpoint, true
+		}
+	}
+	return "", false
+}
+
+// allows returns the endpoint the role grants action on path with.
+func (r *Role) allows(action, path string) (string, bool) {
+	if action == ActionRead {
+		return matchEndpoint(r.Read, path)
+	}
+	return matchEndpoint(r.Write, path)
+}
+
+// HashSecret returns the hex SHA-256 hash of an API key secret.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSecret generates a new random API key secret.
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Authorizer authenticates API keys and authorizes their requests.
+type Authorizer struct {
+	store  storage.APIKeyStore
+	meta   storage.EnrollmentMetadataStore
+	roles  map[string]*Role
+	admins map[string]string // static admin usernames to keys
+	logger log.Logger
+}
+
+// Option configures an Authorizer.
+type Option func(*Authorizer)
+
+// WithLogger configures a logger on the Authorizer.
+func WithLogger(logger log.Logger) Option {
+	return func(a *Authorizer) {
+		a.logger = logger
+	}
+}
+
+// WithRoles replaces the built-in roles with roles.
+func WithRoles(roles map[string]*Role) Option {
+	return func(a *Authorizer) {
+		a.roles = roles
+	}
+}
+
+// WithAdmin configures a static API key with the admin role. This is
+// typically the bootstrap key used to create other API keys. It may be
+// given multiple times for different usernames.
+func WithAdmin(username, key string) Option {
+	return func(a *Authorizer) {
+		a.admins[username] = key
+	}
+}
+
+// New creates a new Authorizer. API keys are retrieved from store and
+// enrollment tenants from meta. meta may be nil in which case
+// tenant-scoped roles are always forbidden.
+func New(store storage.APIKeyStore, meta storage.EnrollmentMetadataStore, opts ...Option) *Authorizer {
+	a := &Authorizer{
+		store:  store,
+		meta:   meta,
+		roles:  DefaultRoles,
+		admins: make(map[string]string),
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Roles returns the role names.
+func (a *Authorizer) Roles() []string {
+	var names []string
+	for name := range a.roles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Role returns the role name or nil if it does not exist.
+func (a *Authorizer) Role(name string) *Role {
+	return a.roles[name]
+}
+
+var (
+	errUnauthorized = errors.New("unauthorized")
+	errForbidden    = errors.New("forbidden")
+)
+
+// authenticate returns the API key for username and secret.
+func (a *Authorizer) authenticate(ctx context.Context, username, secret string) (*storage.APIKey, error) {
+	if adminKey, ok := a.admins[username]; ok && username != "" {
+		if adminKey != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(adminKey)) == 1 {
+			return &storage.APIKey{Name: username, Role: RoleAdmin}, nil
+		}
+		return nil, errUnauthorized
+	}
+	key, err := a.store.RetrieveAPIKey(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving API key: %w", err)
+	}
+	if key == nil || subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, errUnauthorized
+	}
+	return key, nil
+}
+
+// authorize checks that key may perform the request for path.
+func (a *Authorizer) authorize(ctx context.Context, key *storage.APIKey, method, path string) error {
+	role := a.roles[key.Role]
+	if _, ok := a.admins[key.Name]; ok && key.Role == RoleAdmin && key.Name != "" {
+		// the static admin always has full access
+		role = DefaultRoles[RoleAdmin]
+	}
+	if role == nil {
+		return fmt.Errorf("%w: unknown role %q", errForbidden, key.Role)
+	}
+	action := ActionRead
+	if mdmhttp.IsWrite(method, path) {
+		action = ActionWrite
+	}
+	endpoint, ok := role.allows(action, path)
+	if !ok {
+		return fmt.Errorf("%w: %s not allowed", errForbidden, action)
+	}
+	if !role.Tenant {
+		return nil
+	}
+	// tenant-scoped roles may only target enrollments of their tenant
+	if key.Tenant == "" || endpoint == AllEndpoints || !strings.HasSuffix(endpoint, "/") {
+		return fmt.Errorf("%w: tenant role", errForbidden)
+	}
+	ids := strings.TrimPrefix(path, endpoint)
+	if ids == "" {
+		return fmt.Errorf("%w: tenant role without enrollment IDs", errForbidden)
+	}
+	if a.meta == nil {
+		return fmt.Errorf("%w: tenant role without metadata storage", errForbidden)
+	}
+	for _, id := range strings.Split(ids, ",") {
+		meta, err := a.meta.RetrieveEnrollmentMetadata(ctx, id)
+		if err != nil {
+			return fmt.Errorf("retrieving enrollment metadata: %w", err)
+		}
+		if meta == nil || meta.Tenant != key.Tenant {
+			return fmt.Errorf("%w: enrollment %s not in tenant", errForbidden, id)
+		}
+	}
+	return nil
+}
+
+// Middleware authenticates the HTTP basic auth API key (the username is
+// the API key name and the password is the secret) and authorizes the
+// request path with the API key role.
+func (a *Authorizer) Middleware(next http.Handler, realm string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := ctxlog.Logger(r.Context(), a.logger)
+		username, secret, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		key, err := a.authenticate(r.Context(), username, secret)
+		if errors.Is(err, errUnauthorized) {
+			logger.Info("msg", "authenticating API key", "name", username, "err", err)
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			logger.Info("msg", "authenticating API key", "name", username, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		err = a.authorize(r.Context(), key, r.Method, r.URL.Path)
+		if errors.Is(err, errForbidden) {
+			logger.Info("msg", "authorizing API key", "name", username, "role", key.Role, "path", r.URL.Path, "err", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		} else if err != nil {
+			logger.Info("msg", "authorizing API key", "name", username, "err", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(NewContextWithAPIKey(r.Context(), key)))
+	}
+}
+
+type contextKeyAPIKey struct{}
+
+// NewContextWithAPIKey returns a new context with the authenticated key.
+func NewContextWithAPIKey(ctx context.Context, key *storage.APIKey) context.Context {
+	return context.WithValue(ctx, contextKeyAPIKey{}, key)
+}
+
+// APIKeyFromContext returns the authenticated API key from ctx.
+func APIKeyFromContext(ctx context.Context) *storage.APIKey {
+	key, _ := ctx.Value(contextKeyAPIKey{}).(*storage.APIKey)
+	return key
+}
@@ -0,0 +1,90 @@
+package rbac
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestMiddleware(t *testing.T) {
+	keys := map[string]*storage.APIKey{
+		"view":  {Name: "view", Role: RoleViewer, SecretHash: HashSecret("s1")},
+		"op":    {Name: "op", Role: RoleOperator, SecretHash: HashSecret("s2")},
+		"acme":  {Name: "acme", Role: RoleTenantAdmin, Tenant: "acme", SecretHash: HashSecret("s3")},
+		"bogus": {Name: "bogus", Role: "bogus", SecretHash: HashSecret("s4")},
+	}
+	tenants := map[string]string{"DEV1": "acme", "DEV2": "other"}
+	store := &mock.Storage{
+		RetrieveAPIKeyFunc: func(_ context.Context, name string) (*storage.APIKey, error) {
+			return keys[name], nil
+		},
+		RetrieveEnrollmentMetadataFunc: func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+			if tenant, ok := tenants[id]; ok {
+				return &storage.EnrollmentMetadata{Tenant: tenant}, nil
+			}
+			return nil, nil
+		},
+	}
+	a := New(store, store, WithAdmin("nanomdm", "admin"), WithAdmin("micromdm", "admin"))
+	var handled *storage.APIKey
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled = APIKeyFromContext(r.Context())
+	}), "nanomdm")
+
+	for _, test := range []struct {
+		user, secret, method, path string
+		code                       int
+	}{
+		{"nanomdm", "admin", "PUT", "/v1/apikeys/new", http.StatusOK},
+		{"nanomdm", "wrong", "GET", "/v1/enrollments/", http.StatusUnauthorized},
+		{"view", "s1", "GET", "/v1/enrollments/", http.StatusOK},
+		{"view", "s2", "GET", "/v1/enrollments/", http.StatusUnauthorized},
+		{"view", "s1", "PUT", "/v1/enqueue/DEV1", http.StatusForbidden},
+		{"view", "s1", "GET", "/v1/queue/DEV1", http.StatusForbidden},
+		{"view", "s1", "GET", "/v1/export/DEV1", http.StatusForbidden},
+		{"view", "s1", "GET", "/v1/unlocktokens/DEV1", http.StatusForbidden},
+		{"view", "s1", "GET", "/v1/apikeys/", http.StatusForbidden},
+		{"op", "s2", "PUT", "/v1/enqueue/DEV1", http.StatusOK},
+		{"op", "s2", "PUT", "/v1/apikeys/new", http.StatusForbidden},
+		{"op", "s2", "PUT", "/v1/pushcert", http.StatusForbidden},
+		{"op", "s2", "GET", "/push/DEV1", http.StatusOK},
+		{"view", "s1", "GET", "/push/DEV1", http.StatusForbidden},
+		{"op", "s2", "POST", "/v1/commands", http.StatusOK},
+		{"op", "s2", "PUT", "/v1/files/profile.mobileconfig", http.StatusForbidden},
+		{"micromdm", "admin", "POST", "/v1/commands", http.StatusOK},
+		{"acme", "s3", "PUT", "/v1/enqueue/DEV1", http.StatusOK},
+		{"acme", "s3", "PUT", "/v1/enqueue/DEV1,DEV2", http.StatusForbidden},
+		{"acme", "s3", "GET", "/v1/enrollments/", http.StatusForbidden},
+		{"acme", "s3", "GET", "/v1/pushcerts", http.StatusForbidden},
+		{"acme", "s3", "PUT", "/v1/enqueue/DEV3", http.StatusForbidden},
+		{"bogus", "s4", "GET", "/v1/enrollments/", http.StatusForbidden},
+		{"missing", "s1", "GET", "/v1/enrollments/", http.StatusUnauthorized},
+	} {
+		handled = nil
+		r := httptest.NewRequest(test.method, test.path, nil)
+		r.SetBasicAuth(test.user, test.secret)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != test.code {
+			t.Errorf("%s %s %s: have %d, want %d", test.user, test.method, test.path, rec.Code, test.code)
+		}
+		if test.code == http.StatusOK && (handled == nil || handled.Name != test.user) {
+			t.Errorf("%s %s %s: expected API key in context", test.user, test.method, test.path)
+		}
+	}
+
+	// the push certificate is stored from the body of any request so a
+	// GET is not a read
+	r := httptest.NewRequest("GET", "/v1/pushcert", strings.NewReader("-----BEGIN CERTIFICATE-----"))
+	r.SetBasicAuth("op", "s2")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r)
+	if have, want := rec.Code, http.StatusForbidden; have != want {
+		t.Errorf("GET /v1/pushcert: have %d, want %d", have, want)
+	}
+}
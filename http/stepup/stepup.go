@@ -0,0 +1,296 @@
+// Package stepup requires an additional confirmation factor before
+// destructive commands (such as EraseDevice) are enqueued.
+//
+// The API key alone is not enough to enqueue a guarded command. The
+// request must also carry either a time-based one-time password (TOTP)
+// from a separate shared secret or an approval signed by an approver's
+// Ed25519 key for that specific command and its targets.
+package stepup
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	mdmhttp "github.com/micromdm/nanomdm/http"
+	"github.com/micromdm/nanomdm/mdm"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Step-up HTTP headers.
+const (
+	HeaderTOTP     = "X-Step-Up-TOTP"
+	HeaderApproval = "X-Step-Up-Approval"
+)
+
+// DefaultRequestTypes are the command request types guarded by default.
+var DefaultRequestTypes = []string{"EraseDevice", "DeviceLock"}
+
+var (
+	ErrMissing         = errors.New("step-up required")
+	ErrInvalidTOTP     = errors.New("invalid step-up TOTP")
+	ErrInvalidApproval = errors.New("invalid step-up approval")
+)
+
+// Approval is a signed approval for enqueueing a command to targets.
+type Approval struct {
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type"`
+	// Targets is the comma-separated enqueue targets (i.e. the URL path
+	// after the enqueue endpoint).
+	Targets string `json:"targets"`
+	// Expires is the UNIX time after which the approval is invalid.
+	Expires int64 `json:"expires"`
+}
+
+// SignApproval encodes and signs a for the approval header.
+func SignApproval(a *Approval, key ed25519.PrivateKey) (string, error) {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b) + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(key, b)), nil
+}
+
+// verifyApproval decodes and verifies the approval header value s.
+func verifyApproval(s string, pub ed25519.PublicKey) (*Approval, error) {
+	payload, sig, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, ErrInvalidApproval
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidApproval
+	}
+	sigBytes, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(pub, b, sigBytes) {
+		return nil, ErrInvalidApproval
+	}
+	a := new(Approval)
+	if err = json.Unmarshal(b, a); err != nil {
+		return nil, ErrInvalidApproval
+	}
+	return a, nil
+}
+
+// totp returns the 6 digit RFC 6238 TOTP for secret at counter.
+func totp(secret []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", code%1000000)
+}
+
+// totpStep is the TOTP time step.
+const totpStep = 30
+
+// Guard enforces step-up for guarded command request types.
+type Guard struct {
+	types      map[string]bool
+	totpSecret []byte
+	approvers  []ed25519.PublicKey
+	logger     log.Logger
+	now        func() time.Time
+
+	mu sync.Mutex
+	// usedTOTP maps accepted TOTP counters to the command (UUID and
+	// targets) they confirmed, to prevent replays for other commands
+	usedTOTP map[uint64]string
+}
+
+// Option configures a Guard.
+type Option func(*Guard)
+
+// WithLogger configures a logger on the Guard.
+func WithLogger(logger log.Logger) Option {
+	return func(g *Guard) {
+		g.logger = logger
+	}
+}
+
+// WithTOTPSecret accepts TOTPs generated from secret.
+func WithTOTPSecret(secret []byte) Option {
+	return func(g *Guard) {
+		g.totpSecret = secret
+	}
+}
+
+// WithApprover accepts approvals signed by pub.
+func WithApprover(pub ed25519.PublicKey) Option {
+	return func(g *Guard) {
+		g.approvers = append(g.approvers, pub)
+	}
+}
+
+// New creates a new Guard for commands of requestTypes. If requestTypes
+// is nil DefaultRequestTypes is used.
+func New(requestTypes []string, opts ...Option) (*Guard, error) {
+	g := &Guard{
+		types:    make(map[string]bool),
+		logger:   log.NopLogger,
+		now:      time.Now,
+		usedTOTP: make(map[uint64]string),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if len(g.totpSecret) < 1 && len(g.approvers) < 1 {
+		return nil, errors.New("no TOTP secret or approvers")
+	}
+	if requestTypes == nil {
+		requestTypes = DefaultRequestTypes
+	}
+	for _, requestType := range requestTypes {
+		g.types[requestType] = true
+	}
+	return g, nil
+}
+
+// Guarded reports whether commands of requestType require step-up.
+func (g *Guard) Guarded(requestType string) bool {
+	return g.types[requestType]
+}
+
+// checkTOTP checks code at now allowing one step of clock skew. A code
+// only confirms the one command (by UUID and targets) it was first used
+// for: retries of that command are accepted but other commands are not.
+func (g *Guard) checkTOTP(code string, cmd *mdm.Command, targets string, now time.Time) error {
+	counter := uint64(now.Unix() / totpStep)
+	key := cmd.CommandUUID + "\x00" + targets
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for c := range g.usedTOTP {
+		// codes of these counters are no longer accepted
+		if c < counter-1 {
+			delete(g.usedTOTP, c)
+		}
+	}
+	for _, c := range []uint64{counter - 1, counter, counter + 1} {
+		if !hmac.Equal([]byte(totp(g.totpSecret, c)), []byte(code)) {
+			continue
+		}
+		if used, ok := g.usedTOTP[c]; ok && used != key {
+			return fmt.Errorf("%w: already used", ErrInvalidTOTP)
+		}
+		g.usedTOTP[c] = key
+		return nil
+	}
+	return ErrInvalidTOTP
+}
+
+// Check verifies the step-up headers of r for cmd enqueued to targets.
+func (g *Guard) Check(r *http.Request, cmd *mdm.Command, targets string) error {
+	if !g.Guarded(cmd.Command.RequestType) {
+		return nil
+	}
+	now := g.now()
+	if code := r.Header.Get(HeaderTOTP); code != "" && len(g.totpSecret) > 0 {
+		return g.checkTOTP(code, cmd, targets, now)
+	}
+	if s := r.Header.Get(HeaderApproval); s != "" && len(g.approvers) > 0 {
+		for _, pub := range g.approvers {
+			a, err := verifyApproval(s, pub)
+			if err != nil {
+				continue
+			}
+			if a.CommandUUID != cmd.CommandUUID || a.RequestType != cmd.Command.RequestType || a.Targets != targets {
+				return fmt.Errorf("%w: does not match command", ErrInvalidApproval)
+			}
+			if now.Unix() > a.Expires {
+				return fmt.Errorf("%w: expired", ErrInvalidApproval)
+			}
+			return nil
+		}
+		return ErrInvalidApproval
+	}
+	return ErrMissing
+}
+
+// Middleware enforces step-up on requests below prefix (e.g.
+// "/v1/enqueue/", or empty when wrapping an enqueue handler with the
+// prefix already stripped) that contain a guarded MDM command in the
+// body, regardless of the HTTP method. The enqueue targets are the URL
+// path after stripping prefix.
+func (g *Guard) Middleware(next http.Handler, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		b, err := mdmhttp.ReadAllAndReplaceBody(r)
+		if err != nil || len(b) < 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cmd, err := mdm.DecodeCommand(b)
+		if err != nil {
+			// not a command; leave it to the handler
+			next.ServeHTTP(w, r)
+			return
+		}
+		targets := strings.TrimPrefix(r.URL.Path, prefix)
+		if err = g.Check(r, cmd, targets); err != nil {
+			ctxlog.Logger(r.Context(), g.logger).Info(
+				"msg", "step-up",
+				"request_type", cmd.Command.RequestType,
+				"command_uuid", cmd.CommandUUID,
+				"targets", targets,
+				"err", err,
+			)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// LoadTOTPSecret reads a base32 encoded TOTP secret (as used by
+// authenticator apps) from path.
+func LoadTOTPSecret(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(string(b)), " ", ""))
+	return base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(s, "="))
+}
+
+// LoadApprover reads a PEM-encoded PKIX Ed25519 public key from path.
+func LoadApprover(path string) (ed25519.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PEM public key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("not an Ed25519 public key")
+	}
+	return pub, nil
+}
@@ -0,0 +1,95 @@
+package stepup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func command(requestType string) string {
+	return `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>` + requestType + `</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>cmd-1</string>
+</dict>
+</plist>`
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 SHA-1 test vector (truncated to 6 digits)
+	if have, want := totp([]byte("12345678901234567890"), uint64(59/totpStep)), "287082"; have != want {
+		t.Errorf("totp: have %s, want %s", have, want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("12345678901234567890")
+	g, err := New(nil, WithTOTPSecret(secret), WithApprover(pub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	g.now = func() time.Time { return now }
+	handler := g.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/v1/enqueue/")
+
+	approval, _ := SignApproval(&Approval{CommandUUID: "cmd-1", RequestType: "EraseDevice", Targets: "ID1", Expires: now.Unix() + 60}, key)
+	expired, _ := SignApproval(&Approval{CommandUUID: "cmd-1", RequestType: "EraseDevice", Targets: "ID1", Expires: now.Unix() - 1}, key)
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := SignApproval(&Approval{CommandUUID: "cmd-1", RequestType: "EraseDevice", Targets: "ID1", Expires: now.Unix() + 60}, otherKey)
+	code := totp(secret, uint64(now.Unix()/totpStep))
+	prevCode := totp(secret, uint64(now.Unix()/totpStep)-1)
+
+	for _, test := range []struct {
+		name        string
+		method      string
+		requestType string
+		path        string
+		header      string
+		value       string
+		code        int
+	}{
+		{"unguarded", "PUT", "DeviceInformation", "/v1/enqueue/ID1", "", "", http.StatusOK},
+		{"other endpoint", "PUT", "EraseDevice", "/v1/other", "", "", http.StatusOK},
+		{"missing", "PUT", "EraseDevice", "/v1/enqueue/ID1", "", "", http.StatusForbidden},
+		{"missing get", "GET", "EraseDevice", "/v1/enqueue/ID1", "", "", http.StatusForbidden},
+		{"totp", "PUT", "EraseDevice", "/v1/enqueue/ID1", HeaderTOTP, code, http.StatusOK},
+		{"totp retry", "PUT", "EraseDevice", "/v1/enqueue/ID1", HeaderTOTP, code, http.StatusOK},
+		{"totp replay", "PUT", "EraseDevice", "/v1/enqueue/ID2", HeaderTOTP, code, http.StatusForbidden},
+		{"totp previous step", "PUT", "EraseDevice", "/v1/enqueue/ID2", HeaderTOTP, prevCode, http.StatusOK},
+		{"bad totp", "PUT", "DeviceLock", "/v1/enqueue/ID1", HeaderTOTP, "000000", http.StatusForbidden},
+		{"approval", "PUT", "EraseDevice", "/v1/enqueue/ID1", HeaderApproval, approval, http.StatusOK},
+		{"approval other targets", "PUT", "EraseDevice", "/v1/enqueue/ID1,ID2", HeaderApproval, approval, http.StatusForbidden},
+		{"approval other type", "PUT", "DeviceLock", "/v1/enqueue/ID1", HeaderApproval, approval, http.StatusForbidden},
+		{"expired approval", "PUT", "EraseDevice", "/v1/enqueue/ID1", HeaderApproval, expired, http.StatusForbidden},
+		{"forged approval", "PUT", "EraseDevice", "/v1/enqueue/ID1", HeaderApproval, forged, http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(command(test.requestType)))
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, r)
+		if rec.Code != test.code {
+			t.Errorf("%s: have %d, want %d: %s", test.name, rec.Code, test.code, rec.Body.String())
+		}
+	}
+
+	if _, err = New(nil); err == nil {
+		t.Error("expected error without TOTP secret or approvers")
+	}
+}
@@ -15,6 +15,11 @@ type MessageType struct {
 	MessageType string
 }
 
+// Type returns the MessageType of a check-in message.
+func (t MessageType) Type() string {
+	return t.MessageType
+}
+
 // Authenticate is a representation of an "Authenticate" check-in message type.
 // See https://developer.apple.com/documentation/devicemanagement/authenticaterequest
 type Authenticate struct {
@@ -103,6 +108,19 @@ type DeclarativeManagement struct {
 	Raw      []byte `plist:"-"` // Original XML plist
 }
 
+// SyncTokens is a representation of a Declarative Management "SyncTokens" structure.
+// See https://developer.apple.com/documentation/devicemanagement/synchronizationtokens
+type SyncTokens struct {
+	DeclarationsToken string
+	Timestamp         string
+}
+
+// TokensResponse is a representation of a Declarative Management "TokensResponse" structure.
+// See https://developer.apple.com/documentation/devicemanagement/tokensresponse
+type TokensResponse struct {
+	SyncTokens SyncTokens
+}
+
 // TokenParameters is a representation of a "GetTokenRequest.TokenParameters" structure.
 // See https://developer.apple.com/documentation/devicemanagement/gettokenrequest/tokenparameters
 type TokenParameters struct {
@@ -187,6 +205,9 @@ func (w *checkinUnmarshaller) UnmarshalPlist(f func(interface{}) error) error {
 
 // DecodeCheckin unmarshals rawMessage into a specific check-in struct in message.
 func DecodeCheckin(rawMessage []byte) (message interface{}, err error) {
+	if err = DecodeLimits.Check(rawMessage); err != nil {
+		return nil, &ParseError{Err: err, Content: rawMessage}
+	}
 	w := &checkinUnmarshaller{raw: rawMessage}
 	err = plist.Unmarshal(rawMessage, w)
 	if err != nil {
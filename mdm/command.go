@@ -2,6 +2,7 @@ package mdm
 
 import (
 	"errors"
+	"time"
 
 	"github.com/groob/plist"
 )
@@ -32,6 +33,9 @@ type CommandResults struct {
 
 // DecodeCheckin unmarshals rawMessage into results
 func DecodeCommandResults(rawResults []byte) (results *CommandResults, err error) {
+	if err = DecodeLimits.Check(rawResults); err != nil {
+		return nil, &ParseError{Err: err, Content: rawResults}
+	}
 	results = new(CommandResults)
 	err = plist.Unmarshal(rawResults, results)
 	if err != nil {
@@ -51,6 +55,42 @@ type Command struct {
 		RequestType string
 	}
 	Raw []byte `plist:"-"` // Original command XML plist
+
+	// ExpiresAt is when the command expires, if not zero. Expired
+	// commands are no longer sent to enrollments.
+	ExpiresAt time.Time `plist:"-"`
+
+	// NotBefore is when the command is scheduled, if not zero.
+	// Commands are not sent to enrollments before they are due.
+	NotBefore time.Time `plist:"-"`
+
+	// Priority orders the command in the queue of enrollments. Higher
+	// priority commands are sent before lower priority commands which
+	// are sent in the order they were queued.
+	Priority int `plist:"-"`
+}
+
+// Command priority levels.
+const (
+	PriorityLow    = -10
+	PriorityNormal = 0
+	PriorityHigh   = 10
+	PriorityUrgent = 20
+
+	// MinPriority and MaxPriority bound the priorities that storage
+	// backends can store.
+	MinPriority = -128
+	MaxPriority = 127
+)
+
+// Expired reports whether the command has expired at now.
+func (c *Command) Expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !now.Before(c.ExpiresAt)
+}
+
+// Due reports whether the command is due (is not scheduled after) now.
+func (c *Command) Due(now time.Time) bool {
+	return c.NotBefore.IsZero() || !now.Before(c.NotBefore)
 }
 
 // DecodeCommand unmarshals rawCommand into command
@@ -0,0 +1,112 @@
+package mdm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+// JSON object keys of plist values without a JSON type. A JSON object
+// with only one of these keys converts to the plist type.
+const (
+	// JSONDataKey is the key of base64 encoded plist data values.
+	JSONDataKey = "$data"
+
+	// JSONDateKey is the key of RFC 3339 formatted plist date values.
+	JSONDateKey = "$date"
+)
+
+// plistToJSONValue converts a decoded plist value to a JSON value.
+func plistToJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = plistToJSONValue(v[k])
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = plistToJSONValue(v[i])
+		}
+		return v
+	case []byte:
+		return map[string]string{JSONDataKey: base64.StdEncoding.EncodeToString(v)}
+	case time.Time:
+		return map[string]string{JSONDateKey: v.UTC().Format(time.RFC3339)}
+	}
+	return v
+}
+
+// PlistToJSON converts a plist (such as a command or command result) to
+// JSON. Data and date values are converted to JSON objects with the
+// JSONDataKey or JSONDateKey key, respectively, so that they can be
+// converted back with JSONToPlist.
+func PlistToJSON(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := plist.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("decoding plist: %w", err)
+	}
+	return json.MarshalIndent(plistToJSONValue(v), "", "\t")
+}
+
+// jsonToPlistValue converts a decoded JSON value to a plist value.
+func jsonToPlistValue(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if s, ok := v[JSONDataKey].(string); ok {
+				return base64.StdEncoding.DecodeString(s)
+			}
+			if s, ok := v[JSONDateKey].(string); ok {
+				return time.Parse(time.RFC3339, s)
+			}
+		}
+		for k := range v {
+			var err error
+			if v[k], err = jsonToPlistValue(v[k]); err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return v, nil
+	case []interface{}:
+		for i := range v {
+			var err error
+			if v[i], err = jsonToPlistValue(v[i]); err != nil {
+				return nil, fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, nil
+		}
+		return v.Float64()
+	case nil:
+		return nil, errors.New("null has no plist type")
+	}
+	return v, nil
+}
+
+// JSONToPlist converts JSON to a plist (such as a command). JSON numbers
+// without a fraction or exponent are converted to integers and others
+// to reals. JSON objects with only a JSONDataKey or JSONDateKey key are
+// converted to data and date values, respectively. JSON null values are
+// not supported.
+func JSONToPlist(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("decoding json: %w", err)
+	}
+	v, err := jsonToPlistValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return plist.MarshalIndent(v, "\t")
+}
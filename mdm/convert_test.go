@@ -0,0 +1,81 @@
+package mdm
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestPlistJSONConversion(t *testing.T) {
+	b, err := JSONToPlist([]byte(`{
+		"CommandUUID": "0001",
+		"Command": {
+			"RequestType": "InstallProfile",
+			"Payload": {"$data": "AAEC"}
+		},
+		"Count": 3,
+		"Ratio": 0.5,
+		"NotAfter": {"$date": "2024-01-02T03:04:05Z"},
+		"Flags": [true, "x"]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := DecodeCommand(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cmd.Command.RequestType, "InstallProfile"; have != want {
+		t.Errorf("request type: have %q, want %q", have, want)
+	}
+
+	// and back again
+	if b, err = PlistToJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Command struct {
+			Payload map[string]string
+		}
+		Count    int
+		Ratio    float64
+		NotAfter map[string]string
+		Flags    []interface{}
+	}
+	if err = json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := v.Command.Payload[JSONDataKey], "AAEC"; have != want {
+		t.Errorf("data: have %q, want %q", have, want)
+	}
+	if have, want := v.NotAfter[JSONDateKey], "2024-01-02T03:04:05Z"; have != want {
+		t.Errorf("date: have %q, want %q", have, want)
+	}
+	if v.Count != 3 || v.Ratio != 0.5 || len(v.Flags) != 2 {
+		t.Errorf("unexpected values: %+v", v)
+	}
+
+	if _, err = JSONToPlist([]byte(`{"Key": null}`)); err == nil {
+		t.Error("expected error converting null")
+	}
+}
+
+func TestPlistToJSONResult(t *testing.T) {
+	b, err := os.ReadFile("testdata/DeviceInformation.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = PlistToJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	var v struct {
+		Status      string
+		CommandUUID string
+	}
+	if err = json.Unmarshal(b, &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Status != "Acknowledged" || v.CommandUUID != "76eda240-5488-4989-8339-f2ae160113c4" {
+		t.Errorf("unexpected result: %+v", v)
+	}
+}
@@ -0,0 +1,141 @@
+// Package errorkb maps Apple MDM command error chain codes to
+// categories that drive automatic handling of failed commands.
+//
+// A default mapping of common error codes is embedded. Deployments can
+// extend or override it with their own entries as error codes are
+// largely undocumented and vary between OS versions.
+package errorkb
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// Category is how an error is handled.
+type Category string
+
+// Error categories. An empty Category is an unknown error.
+const (
+	// Retryable errors are transient and the command may succeed if
+	// sent again (e.g. network errors).
+	Retryable Category = "retryable"
+
+	// UserActionRequired errors need action on the device (e.g. freeing
+	// disk space or changing the passcode) before the command can
+	// succeed.
+	UserActionRequired Category = "user_action_required"
+
+	// Fatal errors will not succeed if the command is sent again
+	// unchanged (e.g. a malformed profile).
+	Fatal Category = "fatal"
+)
+
+// severity ranks categories for classifying error chains.
+var severity = map[Category]int{
+	Retryable:          1,
+	UserActionRequired: 2,
+	Fatal:              3,
+}
+
+// Entry categorizes an error code.
+type Entry struct {
+	Domain string `json:"domain"`
+
+	// Code is the error code in Domain. A nil Code matches every code
+	// in Domain without its own entry.
+	Code *int `json:"code,omitempty"`
+
+	Category    Category `json:"category"`
+	Description string   `json:"description,omitempty"`
+}
+
+//go:embed codes.json
+var defaultCodes []byte
+
+// DefaultEntries returns the embedded entries.
+func DefaultEntries() []*Entry {
+	var entries []*Entry
+	if err := json.Unmarshal(defaultCodes, &entries); err != nil {
+		panic(fmt.Errorf("errorkb: decoding embedded codes: %w", err))
+	}
+	return entries
+}
+
+// LoadEntries reads a JSON list of entries from path.
+func LoadEntries(path string) ([]*Entry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	return entries, json.Unmarshal(b, &entries)
+}
+
+type codeKey struct {
+	domain string
+	code   int
+}
+
+// KB categorizes error codes.
+type KB struct {
+	codes   map[codeKey]*Entry
+	domains map[string]*Entry
+}
+
+// New creates a new KB of entries. Later entries for the same domain
+// and code replace earlier ones so that the DefaultEntries can be
+// overridden by appending to them.
+func New(entries []*Entry) (*KB, error) {
+	kb := &KB{
+		codes:   make(map[codeKey]*Entry),
+		domains: make(map[string]*Entry),
+	}
+	for i, e := range entries {
+		if e.Domain == "" {
+			return nil, fmt.Errorf("entry %d: empty domain", i)
+		}
+		if _, ok := severity[e.Category]; !ok {
+			return nil, fmt.Errorf("entry %d: invalid category: %q", i, e.Category)
+		}
+		if e.Code == nil {
+			kb.domains[e.Domain] = e
+		} else {
+			kb.codes[codeKey{e.Domain, *e.Code}] = e
+		}
+	}
+	return kb, nil
+}
+
+// Default returns a new KB of the DefaultEntries.
+func Default() *KB {
+	kb, err := New(DefaultEntries())
+	if err != nil {
+		panic(fmt.Errorf("errorkb: invalid embedded codes: %w", err))
+	}
+	return kb
+}
+
+// Lookup returns the entry of code in domain or nil if unknown.
+func (kb *KB) Lookup(domain string, code int) *Entry {
+	if e, ok := kb.codes[codeKey{domain, code}]; ok {
+		return e
+	}
+	return kb.domains[domain]
+}
+
+// Classify returns the category of the error chain of a command result:
+// the most severe category of its known errors. Unknown errors are
+// ignored so an empty Category is returned if no error is known.
+func (kb *KB) Classify(chain []mdm.ErrorChain) Category {
+	var c Category
+	for _, ec := range chain {
+		if e := kb.Lookup(ec.ErrorDomain, ec.ErrorCode); e != nil && severity[e.Category] > severity[c] {
+			c = e.Category
+		}
+	}
+	return c
+}
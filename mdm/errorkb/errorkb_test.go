@@ -0,0 +1,42 @@
+package errorkb
+
+import (
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+func TestClassify(t *testing.T) {
+	code := 1009
+	// panics if the embedded codes are invalid
+	Default()
+
+	kb, err := New(append(DefaultEntries(), &Entry{Domain: "NSURLErrorDomain", Code: &code, Category: Fatal}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		chain []mdm.ErrorChain
+		want  Category
+	}{
+		{"empty", nil, ""},
+		{"unknown", []mdm.ErrorChain{{ErrorDomain: "ExampleDomain", ErrorCode: 1}}, ""},
+		{"code", []mdm.ErrorChain{{ErrorDomain: "NSPOSIXErrorDomain", ErrorCode: 28}}, UserActionRequired},
+		{"domain", []mdm.ErrorChain{{ErrorDomain: "NSURLErrorDomain", ErrorCode: -1}}, Retryable},
+		{"override", []mdm.ErrorChain{{ErrorDomain: "NSURLErrorDomain", ErrorCode: 1009}}, Fatal},
+		{"most severe", []mdm.ErrorChain{
+			{ErrorDomain: "NSURLErrorDomain", ErrorCode: -1001},
+			{ErrorDomain: "MCProfileErrorDomain", ErrorCode: 1000},
+			{ErrorDomain: "ExampleDomain", ErrorCode: 1},
+		}, Fatal},
+	} {
+		if have := kb.Classify(test.chain); have != test.want {
+			t.Errorf("%s: have %q, want %q", test.name, have, test.want)
+		}
+	}
+
+	if _, err = New([]*Entry{{Domain: "ExampleDomain", Category: "bogus"}}); err == nil {
+		t.Error("expected invalid category error")
+	}
+}
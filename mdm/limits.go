@@ -0,0 +1,73 @@
+package mdm
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrPlistLimit is returned when a plist exceeds the parsing limits.
+var ErrPlistLimit = errors.New("plist limit exceeded")
+
+// PlistLimits are structural limits checked before parsing check-in
+// and command result plists. They protect the (public) MDM endpoints
+// from crafted payloads causing pathological CPU or memory use when
+// decoding. Zero values disable the respective limit.
+type PlistLimits struct {
+	// MaxSize is the maximum size of the plist in bytes.
+	MaxSize int
+	// MaxDepth is the maximum nesting depth of XML elements.
+	MaxDepth int
+	// MaxElements is the maximum total number of XML elements.
+	MaxElements int
+}
+
+// DefaultPlistLimits are the default limits. They are generous enough
+// for large, legitimate command results (e.g. InstalledApplicationList
+// or ProfileList responses).
+var DefaultPlistLimits = PlistLimits{
+	MaxDepth:    64,
+	MaxElements: 2000000,
+}
+
+// DecodeLimits are the limits used by DecodeCheckin and DecodeCommandResults.
+var DecodeLimits = DefaultPlistLimits
+
+// Check checks raw against the limits. Binary plists are only checked
+// against the size limit.
+func (l PlistLimits) Check(raw []byte) error {
+	if l.MaxSize > 0 && len(raw) > l.MaxSize {
+		return fmt.Errorf("%w: size %d exceeds %d", ErrPlistLimit, len(raw), l.MaxSize)
+	}
+	if (l.MaxDepth < 1 && l.MaxElements < 1) || bytes.HasPrefix(raw, []byte("bplist")) {
+		return nil
+	}
+	d := xml.NewDecoder(bytes.NewReader(raw))
+	// plists only use a few simple elements; skip DTD entity handling
+	d.Strict = false
+	var depth, elements int
+	for {
+		tok, err := d.RawToken()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			// leave reporting syntax errors to the plist decoder
+			return nil
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+			elements++
+			if l.MaxDepth > 0 && depth > l.MaxDepth {
+				return fmt.Errorf("%w: depth exceeds %d", ErrPlistLimit, l.MaxDepth)
+			}
+			if l.MaxElements > 0 && elements > l.MaxElements {
+				return fmt.Errorf("%w: elements exceed %d", ErrPlistLimit, l.MaxElements)
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
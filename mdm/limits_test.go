@@ -0,0 +1,87 @@
+package mdm
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func nestedPlist(depth int) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0">` +
+		strings.Repeat("<array>", depth) + strings.Repeat("</array>", depth) + `</plist>`)
+}
+
+func TestPlistLimits(t *testing.T) {
+	l := PlistLimits{MaxSize: 4096, MaxDepth: 10, MaxElements: 20}
+
+	for _, test := range []struct {
+		name string
+		raw  []byte
+		err  bool
+	}{
+		{"ok", nestedPlist(5), false},
+		{"depth", nestedPlist(10), true},
+		{"elements", []byte("<plist><array>" + strings.Repeat("<true/>", 20) + "</array></plist>"), true},
+		{"size", []byte(strings.Repeat(" ", 4097)), true},
+		{"binary", append([]byte("bplist00"), nestedPlist(100)...), false},
+		{"invalid", []byte("<plist><array"), false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			err := l.Check(test.raw)
+			if test.err && !errors.Is(err, ErrPlistLimit) {
+				t.Errorf("expected limit error, got: %v", err)
+			} else if !test.err && err != nil {
+				t.Errorf("expected no error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	_, err := DecodeCheckin(nestedPlist(DefaultPlistLimits.MaxDepth + 1))
+	if !errors.Is(err, ErrPlistLimit) {
+		t.Errorf("expected limit error, got: %v", err)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Error("expected parse error")
+	}
+}
+
+// addTestdata adds the plist files in testdata to the fuzz corpus.
+func addTestdata(f *testing.F) {
+	paths, err := filepath.Glob("testdata/*.plist")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+	f.Add(nestedPlist(DefaultPlistLimits.MaxDepth + 1))
+}
+
+func FuzzDecodeCheckin(f *testing.F) {
+	addTestdata(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		msg, err := DecodeCheckin(b)
+		if err == nil && msg == nil {
+			t.Error("nil message without error")
+		}
+	})
+}
+
+func FuzzDecodeCommandResults(f *testing.F) {
+	addTestdata(f)
+	f.Fuzz(func(t *testing.T, b []byte) {
+		results, err := DecodeCommandResults(b)
+		if err == nil && results == nil {
+			t.Error("nil results without error")
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package mdm
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+)
+
+// DefaultManifestChunkSize is the size of the chunks that package
+// manifest checksums are computed over.
+const DefaultManifestChunkSize = 10 << 20
+
+// ManifestAsset is an asset (e.g. a package) of an app manifest item.
+type ManifestAsset struct {
+	Kind       string   `plist:"kind"`
+	MD5Size    int64    `plist:"md5-size,omitempty"`
+	MD5s       []string `plist:"md5s,omitempty"`
+	SHA256Size int64    `plist:"sha256-size,omitempty"`
+	SHA256s    []string `plist:"sha256s,omitempty"`
+	URL        string   `plist:"url"`
+}
+
+// ManifestMetadata is the metadata of an app manifest item.
+type ManifestMetadata struct {
+	BundleIdentifier string `plist:"bundle-identifier"`
+	BundleVersion    string `plist:"bundle-version"`
+	Kind             string `plist:"kind"`
+	Title            string `plist:"title,omitempty"`
+	SizeInBytes      int64  `plist:"sizeInBytes,omitempty"`
+}
+
+// ManifestItem is an item of an app manifest.
+type ManifestItem struct {
+	Assets   []ManifestAsset  `plist:"assets"`
+	Metadata ManifestMetadata `plist:"metadata"`
+}
+
+// Manifest is an app manifest as used by the InstallApplication
+// (ManifestURL) and InstallEnterpriseApplication (Manifest) commands.
+// See https://developer.apple.com/documentation/devicemanagement/manifesturl
+type Manifest struct {
+	Items []ManifestItem `plist:"items"`
+}
+
+// NewPackageManifest creates a manifest for the macOS package read from
+// r which will be downloaded from url. The MD5 and SHA-256 checksums of
+// every chunkSize bytes of the package are computed; if chunkSize is 0
+// DefaultManifestChunkSize is used. The package size and the metadata
+// "kind" are set in the returned manifest metadata.
+func NewPackageManifest(r io.Reader, url string, chunkSize int64, meta ManifestMetadata) (*Manifest, error) {
+	if url == "" {
+		return nil, errors.New("empty url")
+	}
+	if chunkSize == 0 {
+		chunkSize = DefaultManifestChunkSize
+	} else if chunkSize < 0 {
+		return nil, errors.New("invalid chunk size")
+	}
+	asset := ManifestAsset{
+		Kind:       "software-package",
+		MD5Size:    chunkSize,
+		SHA256Size: chunkSize,
+		URL:        url,
+	}
+	md5h, sha256h := md5.New(), sha256.New()
+	for {
+		md5h.Reset()
+		sha256h.Reset()
+		n, err := io.CopyN(io.MultiWriter(md5h, sha256h), r, chunkSize)
+		if n > 0 {
+			asset.MD5s = append(asset.MD5s, hexSum(md5h))
+			asset.SHA256s = append(asset.SHA256s, hexSum(sha256h))
+			meta.SizeInBytes += n
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if meta.SizeInBytes < 1 {
+		return nil, errors.New("empty package")
+	}
+	meta.Kind = "software"
+	return &Manifest{Items: []ManifestItem{{
+		Assets:   []ManifestAsset{asset},
+		Metadata: meta,
+	}}}, nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,58 @@
+package mdm
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/groob/plist"
+)
+
+func TestNewPackageManifest(t *testing.T) {
+	pkg := bytes.Repeat([]byte("x"), 25)
+	m, err := NewPackageManifest(bytes.NewReader(pkg), "https://example.com/a.pkg", 10, ManifestMetadata{
+		BundleIdentifier: "com.example.a",
+		BundleVersion:    "1.0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(m.Items), 1; have != want {
+		t.Fatalf("items: have %d, want %d", have, want)
+	}
+	item := m.Items[0]
+	if have, want := item.Metadata.SizeInBytes, int64(25); have != want {
+		t.Errorf("size: have %d, want %d", have, want)
+	}
+	if have, want := item.Metadata.Kind, "software"; have != want {
+		t.Errorf("kind: have %q, want %q", have, want)
+	}
+	asset := item.Assets[0]
+	if have, want := len(asset.MD5s), 3; have != want {
+		t.Fatalf("md5s: have %d, want %d", have, want)
+	}
+	md5Sum := md5.Sum(pkg[20:])
+	if have, want := asset.MD5s[2], hex.EncodeToString(md5Sum[:]); have != want {
+		t.Errorf("last md5: have %q, want %q", have, want)
+	}
+	sha256Sum := sha256.Sum256(pkg[:10])
+	if have, want := asset.SHA256s[0], hex.EncodeToString(sha256Sum[:]); have != want {
+		t.Errorf("first sha256: have %q, want %q", have, want)
+	}
+
+	out, err := plist.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"md5-size", "sha256s", "bundle-identifier", "sizeInBytes", "software-package"} {
+		if !bytes.Contains(out, []byte(key)) {
+			t.Errorf("manifest plist missing %q", key)
+		}
+	}
+
+	if _, err = NewPackageManifest(bytes.NewReader(nil), "https://example.com/a.pkg", 0, ManifestMetadata{}); err == nil {
+		t.Error("expected error for empty package")
+	}
+}
@@ -0,0 +1,118 @@
+// Package metrics instruments NanoMDM with counters and histograms.
+//
+// Instrumented components record to a Recorder so that they are not
+// tied to a particular metrics system. See the prometheus package for
+// a Recorder served in the Prometheus text format.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Names of the built-in metrics.
+const (
+	// Checkins counts check-in messages by message type.
+	Checkins = "nanomdm_checkins_total"
+
+	// CommandResults counts command results by status.
+	CommandResults = "nanomdm_command_results_total"
+
+	// QueueDepth is the number of queued commands without results by
+	// push topic.
+	QueueDepth = "nanomdm_queue_depth"
+
+	// Pushes counts APNs pushes to enrollments by result.
+	Pushes = "nanomdm_pushes_total"
+
+	// StorageDuration observes the latency of storage calls by method.
+	StorageDuration = "nanomdm_storage_duration_seconds"
+
+	// StorageErrors counts failed storage calls by method.
+	StorageErrors = "nanomdm_storage_errors_total"
+
+	// HTTPRequests counts HTTP requests by route and status code.
+	HTTPRequests = "nanomdm_http_requests_total"
+
+	// HTTPDuration observes the latency of HTTP requests by route.
+	HTTPDuration = "nanomdm_http_request_duration_seconds"
+)
+
+// Help describes the built-in metrics.
+var Help = map[string]string{
+	Checkins:        "Check-in messages by message type.",
+	CommandResults:  "Command results by status.",
+	QueueDepth:      "Queued commands without results by push topic.",
+	Pushes:          "APNs pushes to enrollments by result.",
+	StorageDuration: "Latency of storage calls by method.",
+	StorageErrors:   "Failed storage calls by method.",
+	HTTPRequests:    "HTTP requests by route and status code.",
+	HTTPDuration:    "Latency of HTTP requests by route.",
+}
+
+// Recorder records metrics. Labels are label name and value pairs
+// (e.g. "status", "Acknowledged").
+type Recorder interface {
+	// Add adds value to the counter name.
+	Add(name string, value float64, labels ...string)
+
+	// Observe observes value in the histogram name.
+	Observe(name string, value float64, labels ...string)
+}
+
+type nopRecorder struct{}
+
+func (nopRecorder) Add(string, float64, ...string)     {}
+func (nopRecorder) Observe(string, float64, ...string) {}
+
+// NopRecorder records nothing.
+var NopRecorder Recorder = nopRecorder{}
+
+// Since observes the seconds since start in the histogram name.
+func Since(rec Recorder, name string, start time.Time, labels ...string) {
+	rec.Observe(name, time.Since(start).Seconds(), labels...)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes the response if supported (e.g. for event streams).
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Handler counts the requests to next and observes their latency. The
+// route label of requests is returned by route and should have few
+// distinct values (e.g. not include enrollment IDs).
+func Handler(next http.Handler, rec Recorder, route func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		name := route(r)
+		rec.Add(HTTPRequests, 1, "route", name, "code", strconv.Itoa(sw.status))
+		Since(rec, HTTPDuration, start, "route", name)
+	})
+}
@@ -0,0 +1,303 @@
+// Package prometheus serves metrics in the Prometheus text exposition
+// format without depending on the Prometheus client libraries.
+package prometheus
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/micromdm/nanomdm/metrics"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DefaultBuckets are the default histogram bucket upper bounds in
+// seconds (the same as the Prometheus client libraries).
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+const (
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+)
+
+// series is a labeled time series of a metric family.
+type series struct {
+	labels string // rendered label pairs without braces
+	value  float64
+
+	// histograms only
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+}
+
+// family is a metric and its series.
+type family struct {
+	typ    string
+	series map[string]*series
+}
+
+// Sample is a value of a gauge function. Labels are label name and
+// value pairs.
+type Sample struct {
+	Labels []string
+	Value  float64
+}
+
+// GaugeFunc returns the samples of a gauge when metrics are served.
+type GaugeFunc func(ctx context.Context) ([]Sample, error)
+
+// Registry is a metrics.Recorder that keeps metrics in memory and
+// serves them in the Prometheus text format.
+type Registry struct {
+	buckets []float64
+	help    map[string]string
+	logger  log.Logger
+
+	mu       sync.Mutex
+	families map[string]*family
+	gauges   map[string]GaugeFunc
+}
+
+// Option configures a Registry.
+type Option func(*Registry)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(r *Registry) {
+		r.logger = logger
+	}
+}
+
+// WithBuckets sets the histogram bucket upper bounds.
+func WithBuckets(buckets []float64) Option {
+	return func(r *Registry) {
+		r.buckets = buckets
+	}
+}
+
+// WithHelp sets the help text of metric name.
+func WithHelp(name, help string) Option {
+	return func(r *Registry) {
+		r.help[name] = help
+	}
+}
+
+// New creates a new Registry. The built-in metrics have the help text
+// of metrics.Help.
+func New(opts ...Option) *Registry {
+	r := &Registry{
+		buckets:  DefaultBuckets,
+		help:     make(map[string]string),
+		logger:   log.NopLogger,
+		families: make(map[string]*family),
+		gauges:   make(map[string]GaugeFunc),
+	}
+	for name, help := range metrics.Help {
+		r.help[name] = help
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.buckets = append([]float64(nil), r.buckets...)
+	sort.Float64s(r.buckets)
+	return r
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// renderLabels renders label name and value pairs. A trailing name
+// without a value is ignored.
+func renderLabels(labels []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteString(`"`)
+	}
+	return b.String()
+}
+
+// series returns the series of name with labels creating it if
+// needed. Nil is returned if name has a different type. The mutex
+// must be held.
+func (r *Registry) series(name, typ string, labels []string) *series {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{typ: typ, series: make(map[string]*series)}
+		r.families[name] = f
+	} else if f.typ != typ {
+		return nil
+	}
+	rendered := renderLabels(labels)
+	s, ok := f.series[rendered]
+	if !ok {
+		s = &series{labels: rendered}
+		if typ == typeHistogram {
+			s.counts = make([]uint64, len(r.buckets))
+		}
+		f.series[rendered] = s
+	}
+	return s
+}
+
+// Add adds value to the counter name.
+func (r *Registry) Add(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.series(name, typeCounter, labels); s != nil {
+		s.value += value
+	}
+}
+
+// Observe observes value in the histogram name.
+func (r *Registry) Observe(name string, value float64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.series(name, typeHistogram, labels)
+	if s == nil {
+		return
+	}
+	s.value += value
+	s.count++
+	if i := sort.SearchFloat64s(r.buckets, value); i < len(r.buckets) {
+		s.counts[i]++
+	}
+}
+
+// GaugeFunc registers the gauge name whose samples are returned by f
+// when metrics are served. Gauges are skipped if f returns an error.
+func (r *Registry) GaugeFunc(name string, f GaugeFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[name] = f
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// withLabel appends the label pair name and value to rendered labels.
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + value + `"`
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+func writeSample(w *bufio.Writer, name, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func (r *Registry) writeHeader(w *bufio.Writer, name, typ string) {
+	if help, ok := r.help[name]; ok {
+		fmt.Fprintf(w, "# HELP %s %s\n", name, strings.ReplaceAll(help, "\n", `\n`))
+	}
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+// sortedSeries returns the series of f ordered by labels.
+func sortedSeries(f *family) []*series {
+	ret := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].labels < ret[j].labels })
+	return ret
+}
+
+// writeFamilies writes the recorded metrics. The mutex must be held.
+func (r *Registry) writeFamilies(w *bufio.Writer) {
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := r.families[name]
+		r.writeHeader(w, name, f.typ)
+		for _, s := range sortedSeries(f) {
+			if f.typ != typeHistogram {
+				writeSample(w, name, s.labels, s.value)
+				continue
+			}
+			var cumulative uint64
+			for i, bound := range r.buckets {
+				cumulative += s.counts[i]
+				writeSample(w, name+"_bucket", withLabel(s.labels, "le", formatFloat(bound)), float64(cumulative))
+			}
+			writeSample(w, name+"_bucket", withLabel(s.labels, "le", "+Inf"), float64(s.count))
+			writeSample(w, name+"_sum", s.labels, s.value)
+			writeSample(w, name+"_count", s.labels, float64(s.count))
+		}
+	}
+}
+
+// WriteTo writes the metrics to w in the Prometheus text format.
+func (r *Registry) WriteTo(ctx context.Context, w *bufio.Writer) error {
+	r.mu.Lock()
+	r.writeFamilies(w)
+	gauges := make(map[string]GaugeFunc, len(r.gauges))
+	for name, f := range r.gauges {
+		gauges[name] = f
+	}
+	r.mu.Unlock()
+
+	// gauge functions may be slow (e.g. storage queries) so they are
+	// called without holding the mutex
+	names := make([]string, 0, len(gauges))
+	for name := range gauges {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var firstErr error
+	for _, name := range names {
+		samples, err := gauges[name](ctx)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("gauge %s: %w", name, err)
+			}
+			continue
+		}
+		r.writeHeader(w, name, typeGauge)
+		for _, sample := range samples {
+			writeSample(w, name, renderLabels(sample.Labels), sample.Value)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return firstErr
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format. Gauges
+// that fail are logged and left out of the response.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.WriteTo(req.Context(), bufio.NewWriter(w)); err != nil {
+		ctxlog.Logger(req.Context(), r.logger).Info("msg", "writing metrics", "err", err)
+	}
+}
@@ -0,0 +1,60 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/metrics"
+)
+
+func TestRegistry(t *testing.T) {
+	r := New(WithBuckets([]float64{1, 0.1}))
+	r.Add(metrics.Checkins, 1, "type", "Authenticate")
+	r.Add(metrics.Checkins, 2, "type", "TokenUpdate")
+	r.Add(metrics.Checkins, 1, "type", "Authenticate")
+	r.Add(metrics.Checkins, 1, "type", `quo"te`)
+	r.Observe(metrics.StorageDuration, 0.05, "method", "ClearQueue")
+	r.Observe(metrics.StorageDuration, 0.5, "method", "ClearQueue")
+	r.Observe(metrics.StorageDuration, 5, "method", "ClearQueue")
+	// type mismatches are ignored
+	r.Observe(metrics.Checkins, 1, "type", "Authenticate")
+	r.GaugeFunc(metrics.QueueDepth, func(context.Context) ([]Sample, error) {
+		return []Sample{{Labels: []string{"topic", "com.apple.mgmt.test"}, Value: 3}}, nil
+	})
+	r.GaugeFunc("failing", func(context.Context) ([]Sample, error) {
+		return nil, errors.New("failing")
+	})
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# HELP nanomdm_checkins_total Check-in messages by message type.\n",
+		"# TYPE nanomdm_checkins_total counter\n",
+		"nanomdm_checkins_total{type=\"Authenticate\"} 2\n",
+		"nanomdm_checkins_total{type=\"TokenUpdate\"} 2\n",
+		"nanomdm_checkins_total{type=\"quo\\\"te\"} 1\n",
+		"# TYPE nanomdm_storage_duration_seconds histogram\n",
+		"nanomdm_storage_duration_seconds_bucket{method=\"ClearQueue\",le=\"0.1\"} 1\n",
+		"nanomdm_storage_duration_seconds_bucket{method=\"ClearQueue\",le=\"1\"} 2\n",
+		"nanomdm_storage_duration_seconds_bucket{method=\"ClearQueue\",le=\"+Inf\"} 3\n",
+		"nanomdm_storage_duration_seconds_sum{method=\"ClearQueue\"} 5.55\n",
+		"nanomdm_storage_duration_seconds_count{method=\"ClearQueue\"} 3\n",
+		"# TYPE nanomdm_queue_depth gauge\n",
+		"nanomdm_queue_depth{topic=\"com.apple.mgmt.test\"} 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "failing") {
+		t.Errorf("failing gauge served:\n%s", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type: %q", ct)
+	}
+}
@@ -0,0 +1,492 @@
+// Package campaign sends MDM APNs pushes (and optionally enqueues an
+// MDM command) to a set of enrollments in scheduled waves.
+package campaign
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+)
+
+// Campaign states.
+const (
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateCanceled  = "canceled"
+)
+
+var (
+	ErrNoIDs         = errors.New("no enrollment IDs")
+	ErrNoWaves       = errors.New("no waves")
+	ErrInvalidWaves  = errors.New("wave percentages must increase and end at 100")
+	ErrNotRunning    = errors.New("campaign not running")
+	ErrNotFound      = errors.New("campaign not found")
+	ErrInvalidDelay  = errors.New("invalid wave delay")
+	ErrCommandDecode = errors.New("decoding command")
+	ErrNoGroups      = errors.New("groups not supported by storage")
+)
+
+// Duration is a time.Duration that is represented in JSON as a Go
+// duration string (e.g. "10m").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDelay, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Wave is a single step of a campaign.
+type Wave struct {
+	// Percent is the cumulative percentage of the campaign's
+	// enrollments that will have been sent to once this wave runs.
+	Percent int `json:"percent"`
+
+	// Delay is how long to wait after the prior wave (or after the
+	// campaign starts, for the first wave) before running this wave.
+	Delay Duration `json:"delay,omitempty"`
+}
+
+// Spec describes a campaign to start.
+type Spec struct {
+	Name string `json:"name,omitempty"`
+
+	// Command is an optional raw MDM command plist. If supplied the
+	// command is enqueued for each wave before sending pushes. A
+	// wave after the first is enqueued with a distinct command UUID
+	// which is the original command UUID suffixed with the wave number.
+	Command string `json:"command,omitempty"`
+
+	// IDs are the enrollment IDs targeted by the campaign.
+	IDs []string `json:"ids,omitempty"`
+
+	// Group targets the enabled enrollments that have this group
+	// in their enrollment metadata (in addition to any IDs).
+	Group string `json:"group,omitempty"`
+
+	Waves []Wave `json:"waves"`
+}
+
+// WaveStatus is the status of a single wave.
+type WaveStatus struct {
+	Wave
+	Count       int        `json:"count"`
+	CommandUUID string     `json:"command_uuid,omitempty"`
+	JobID       string     `json:"job_id,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	PushCount   int        `json:"push_count"`
+	PushErrors  int        `json:"push_errors"`
+	CommandErrs int        `json:"command_errors"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// Status is the status of a campaign.
+type Status struct {
+	ID          string       `json:"id"`
+	Name        string       `json:"name,omitempty"`
+	State       string       `json:"state"`
+	RequestType string       `json:"request_type,omitempty"`
+	Total       int          `json:"total"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	Waves       []WaveStatus `json:"waves"`
+}
+
+type campaign struct {
+	mu     sync.RWMutex
+	status Status
+	raw    []byte
+	uuid   string
+	ids    [][]string
+	cancel chan struct{}
+}
+
+func (c *campaign) copyStatus() *Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.status
+	s.Waves = make([]WaveStatus, len(c.status.Waves))
+	copy(s.Waves, c.status.Waves)
+	return &s
+}
+
+// Manager runs campaigns and keeps their status.
+// Campaigns are only tracked in memory and do not survive a restart.
+type Manager struct {
+	enqueuer storage.CommandEnqueuer
+	pusher   push.Pusher
+	jobs     storage.JobStore
+	logger   log.Logger
+
+	mu        sync.RWMutex
+	campaigns map[string]*campaign
+
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// Option configures a Manager.
+type Option func(*Manager)
+
+// WithLogger sets the logger for the Manager.
+func WithLogger(logger log.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// WithJobStore tracks each wave of a campaign as a job in store.
+func WithJobStore(store storage.JobStore) Option {
+	return func(m *Manager) {
+		m.jobs = store
+	}
+}
+
+// New creates a new campaign Manager.
+func New(enqueuer storage.CommandEnqueuer, pusher push.Pusher, opts ...Option) *Manager {
+	m := &Manager{
+		enqueuer:  enqueuer,
+		pusher:    pusher,
+		logger:    log.NopLogger,
+		campaigns: make(map[string]*campaign),
+		now:       time.Now,
+		after:     time.After,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// validateWaves checks that waves are in increasing order of
+// percentage and end with 100%.
+func validateWaves(waves []Wave) error {
+	if len(waves) < 1 {
+		return ErrNoWaves
+	}
+	var last int
+	for _, w := range waves {
+		if w.Percent <= last || w.Percent > 100 || w.Delay < 0 {
+			return ErrInvalidWaves
+		}
+		last = w.Percent
+	}
+	if last != 100 {
+		return ErrInvalidWaves
+	}
+	return nil
+}
+
+// splitWaves partitions ids into the cumulative wave percentages.
+// Each wave includes at least one id if any remain.
+func splitWaves(ids []string, waves []Wave) [][]string {
+	ret := make([][]string, len(waves))
+	var start int
+	for i, w := range waves {
+		end := int(math.Ceil(float64(len(ids)) * float64(w.Percent) / 100))
+		if end <= start {
+			end = start
+			if start < len(ids) {
+				end = start + 1
+			}
+		}
+		ret[i] = ids[start:end]
+		start = end
+	}
+	return ret
+}
+
+// commandWithUUID re-encodes the raw command plist with a new command UUID.
+func commandWithUUID(raw []byte, uuid string) (*mdm.Command, error) {
+	var m map[string]interface{}
+	if err := plist.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	m["CommandUUID"] = uuid
+	b, err := plist.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(b)
+}
+
+// Start validates spec and starts a new campaign targeting ids in the
+// background. Any IDs or Group in spec must already have been resolved
+// into ids by the caller.
+func (m *Manager) Start(ids []string, spec *Spec) (*Status, error) {
+	if len(ids) < 1 {
+		return nil, ErrNoIDs
+	}
+	if err := validateWaves(spec.Waves); err != nil {
+		return nil, err
+	}
+	c := &campaign{
+		status: Status{
+			ID:        newID(),
+			Name:      spec.Name,
+			State:     StateRunning,
+			Total:     len(ids),
+			CreatedAt: m.now(),
+			Waves:     make([]WaveStatus, len(spec.Waves)),
+		},
+		ids:    splitWaves(ids, spec.Waves),
+		cancel: make(chan struct{}),
+	}
+	if spec.Command != "" {
+		cmd, err := mdm.DecodeCommand([]byte(spec.Command))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCommandDecode, err)
+		}
+		c.raw = cmd.Raw
+		c.uuid = cmd.CommandUUID
+		c.status.RequestType = cmd.Command.RequestType
+	}
+	for i, w := range spec.Waves {
+		c.status.Waves[i] = WaveStatus{Wave: w, Count: len(c.ids[i])}
+	}
+	m.mu.Lock()
+	m.campaigns[c.status.ID] = c
+	m.mu.Unlock()
+	go m.run(c)
+	return c.copyStatus(), nil
+}
+
+// run executes the waves of c, waiting the delay of each wave first.
+func (m *Manager) run(c *campaign) {
+	logger := m.logger.With("campaign_id", c.status.ID)
+	logger.Info("msg", "campaign started", "total", c.status.Total, "waves", len(c.ids))
+	state := StateCompleted
+	for i := range c.ids {
+		c.mu.RLock()
+		delay := time.Duration(c.status.Waves[i].Delay)
+		c.mu.RUnlock()
+		if delay > 0 {
+			select {
+			case <-c.cancel:
+				state = StateCanceled
+			case <-m.after(delay):
+			}
+		} else {
+			select {
+			case <-c.cancel:
+				state = StateCanceled
+			default:
+			}
+		}
+		if state == StateCanceled {
+			break
+		}
+		m.runWave(c, i, logger.With("wave", i+1))
+	}
+	finished := m.now()
+	c.mu.Lock()
+	c.status.State = state
+	c.status.FinishedAt = &finished
+	c.mu.Unlock()
+	logger.Info("msg", "campaign finished", "state", state)
+}
+
+// runWave enqueues (if a command was supplied) and pushes to wave i of c.
+func (m *Manager) runWave(c *campaign, i int, logger log.Logger) {
+	ctx := context.Background()
+	ids := c.ids[i]
+	started := m.now()
+	ws := WaveStatus{StartedAt: &started}
+	var errs []string
+	var cmd *mdm.Command
+	if c.raw != nil {
+		var err error
+		if i == 0 {
+			ws.CommandUUID = c.uuid
+			cmd, err = mdm.DecodeCommand(c.raw)
+		} else {
+			ws.CommandUUID = fmt.Sprintf("%s.%d", c.uuid, i+1)
+			cmd, err = commandWithUUID(c.raw, ws.CommandUUID)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			ws.CommandErrs = len(ids)
+		}
+	}
+	if len(ids) > 0 && len(errs) == 0 {
+		ws.JobID = m.storeJob(ctx, c, i, ids, cmd, logger)
+	}
+	if cmd != nil {
+		idErrs, err := m.enqueuer.EnqueueCommand(ctx, ids, cmd)
+		ws.CommandErrs = len(idErrs)
+		var errored []string
+		for id := range idErrs {
+			errored = append(errored, id)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+			if len(idErrs) == 0 {
+				ws.CommandErrs = len(ids)
+				errored = ids
+			}
+		}
+		m.updateJob(ctx, ws.JobID, storage.JobStatusErrored, errored, logger)
+	}
+	if len(ids) > 0 && (c.raw == nil || cmd != nil) {
+		pushResp, err := m.pusher.Push(ctx, ids)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		var delivered, errored []string
+		for id, resp := range pushResp {
+			if resp.Err != nil {
+				ws.PushErrors++
+				errored = append(errored, id)
+			} else {
+				ws.PushCount++
+				delivered = append(delivered, id)
+			}
+		}
+		if cmd == nil {
+			// push-only job targets are complete once pushed
+			m.updateJob(ctx, ws.JobID, storage.JobStatusDelivered, delivered, logger)
+			m.updateJob(ctx, ws.JobID, storage.JobStatusErrored, errored, logger)
+		}
+	}
+	for _, err := range errs {
+		if ws.Error != "" {
+			ws.Error += "; "
+		}
+		ws.Error += err
+	}
+	logs := []interface{}{
+		"msg", "campaign wave",
+		"count", len(ids),
+		"push_count", ws.PushCount,
+	}
+	if ws.Error != "" || ws.PushErrors > 0 || ws.CommandErrs > 0 {
+		logs = append(logs, "push_errs", ws.PushErrors, "command_errs", ws.CommandErrs)
+		if ws.Error != "" {
+			logs = append(logs, "err", ws.Error)
+		}
+		logger.Info(logs...)
+	} else {
+		logger.Debug(logs...)
+	}
+	c.mu.Lock()
+	w := &c.status.Waves[i]
+	w.StartedAt = ws.StartedAt
+	w.CommandUUID = ws.CommandUUID
+	w.JobID = ws.JobID
+	w.PushCount = ws.PushCount
+	w.PushErrors = ws.PushErrors
+	w.CommandErrs = ws.CommandErrs
+	w.Error = ws.Error
+	c.mu.Unlock()
+}
+
+// storeJob creates a job for wave i of c, if a job store is configured.
+func (m *Manager) storeJob(ctx context.Context, c *campaign, i int, ids []string, cmd *mdm.Command, logger log.Logger) string {
+	if m.jobs == nil {
+		return ""
+	}
+	job := &storage.Job{
+		ID:   fmt.Sprintf("%s.%d", c.status.ID, i+1),
+		Name: fmt.Sprintf("campaign %s wave %d", c.status.ID, i+1),
+	}
+	if c.status.Name != "" {
+		job.Name = fmt.Sprintf("%s wave %d", c.status.Name, i+1)
+	}
+	if cmd != nil {
+		job.CommandUUID = cmd.CommandUUID
+		job.RequestType = cmd.Command.RequestType
+	}
+	if err := m.jobs.StoreJob(ctx, job, ids); err != nil {
+		logger.Info("msg", "storing job", "err", err)
+		return ""
+	}
+	return job.ID
+}
+
+// updateJob sets the status of ids in job jobID, if any.
+func (m *Manager) updateJob(ctx context.Context, jobID, status string, ids []string, logger log.Logger) {
+	if m.jobs == nil || jobID == "" || len(ids) < 1 {
+		return
+	}
+	if err := m.jobs.UpdateJobTargets(ctx, jobID, status, ids); err != nil {
+		logger.Info("msg", "updating job targets", "job_id", jobID, "err", err)
+	}
+}
+
+// Status returns the status of campaign id.
+func (m *Manager) Status(id string) (*Status, error) {
+	m.mu.RLock()
+	c, ok := m.campaigns[id]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.copyStatus(), nil
+}
+
+// List returns the status of all campaigns ordered by creation time.
+func (m *Manager) List() []*Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	ret := make([]*Status, 0, len(m.campaigns))
+	for _, c := range m.campaigns {
+		ret = append(ret, c.copyStatus())
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].CreatedAt.Before(ret[j].CreatedAt)
+	})
+	return ret
+}
+
+// Cancel stops campaign id from running any further waves.
+// A wave that is already in progress is not interrupted.
+func (m *Manager) Cancel(id string) error {
+	m.mu.RLock()
+	c, ok := m.campaigns[id]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.status.State != StateRunning {
+		return ErrNotRunning
+	}
+	select {
+	case <-c.cancel:
+		return ErrNotRunning
+	default:
+		close(c.cancel)
+	}
+	return nil
+}
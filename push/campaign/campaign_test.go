@@ -0,0 +1,171 @@
+package campaign
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+)
+
+const testCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>ProfileList</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>c7fc0872-f22f-4823-8ae0-f3d0174fb48a</string>
+</dict>
+</plist>
+`
+
+type recorder struct {
+	mu     sync.Mutex
+	pushes [][]string
+	uuids  []string
+}
+
+func (r *recorder) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushes = append(r.pushes, ids)
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		ret[id] = &push.Response{Id: "push-" + id}
+	}
+	return ret, nil
+}
+
+func (r *recorder) EnqueueCommand(_ context.Context, _ []string, cmd *mdm.Command) (map[string]error, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.uuids = append(r.uuids, cmd.CommandUUID)
+	return nil, nil
+}
+
+func TestSplitWaves(t *testing.T) {
+	ids := make([]string, 20)
+	waves := []Wave{{Percent: 5}, {Percent: 25}, {Percent: 100}}
+	split := splitWaves(ids, waves)
+	for i, want := range []int{1, 4, 15} {
+		if have := len(split[i]); have != want {
+			t.Errorf("wave %d: have %d, want %d", i, have, want)
+		}
+	}
+	// more waves than ids
+	split = splitWaves(ids[:2], []Wave{{Percent: 1}, {Percent: 2}, {Percent: 100}})
+	for i, want := range []int{1, 1, 0} {
+		if have := len(split[i]); have != want {
+			t.Errorf("small wave %d: have %d, want %d", i, have, want)
+		}
+	}
+}
+
+func TestValidateWaves(t *testing.T) {
+	for _, waves := range [][]Wave{
+		nil,
+		{{Percent: 50}},
+		{{Percent: 50}, {Percent: 50}},
+		{{Percent: 50}, {Percent: 101}},
+	} {
+		if err := validateWaves(waves); err == nil {
+			t.Errorf("expected error for %v", waves)
+		}
+	}
+	if err := validateWaves([]Wave{{Percent: 10}, {Percent: 100}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	r := &recorder{}
+	m := New(r, r)
+	delays := make(chan chan time.Time, 1)
+	m.after = func(time.Duration) <-chan time.Time {
+		c := make(chan time.Time, 1)
+		delays <- c
+		return c
+	}
+
+	ids := []string{"A", "B", "C", "D"}
+	spec := &Spec{
+		Command: testCommand,
+		Waves:   []Wave{{Percent: 25}, {Percent: 100, Delay: Duration(time.Hour)}},
+	}
+	status, err := m.Start(ids, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// wait for the second wave to be delayed, then release it
+	(<-delays) <- time.Now()
+
+	for i := 0; i < 100; i++ {
+		status, err = m.Status(status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.State != StateRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if have, want := status.State, StateCompleted; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+	if have, want := status.RequestType, "ProfileList"; have != want {
+		t.Errorf("request type: have %q, want %q", have, want)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if have, want := len(r.pushes), 2; have != want {
+		t.Fatalf("push count: have %d, want %d", have, want)
+	}
+	if have, want := len(r.pushes[0]), 1; have != want {
+		t.Errorf("first wave: have %d, want %d", have, want)
+	}
+	if have, want := status.Waves[1].PushCount, 3; have != want {
+		t.Errorf("second wave push count: have %d, want %d", have, want)
+	}
+	for i, want := range []string{
+		"c7fc0872-f22f-4823-8ae0-f3d0174fb48a",
+		"c7fc0872-f22f-4823-8ae0-f3d0174fb48a.2",
+	} {
+		if have := r.uuids[i]; have != want {
+			t.Errorf("command uuid %d: have %q, want %q", i, have, want)
+		}
+	}
+}
+
+func TestCampaignCancel(t *testing.T) {
+	r := &recorder{}
+	m := New(r, r)
+	m.after = func(time.Duration) <-chan time.Time { return nil }
+
+	spec := &Spec{Waves: []Wave{{Percent: 50}, {Percent: 100, Delay: Duration(time.Hour)}}}
+	status, err := m.Start([]string{"A", "B"}, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = m.Cancel(status.ID); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if status, _ = m.Status(status.ID); status.State != StateRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if have, want := status.State, StateCanceled; have != want {
+		t.Errorf("state: have %q, want %q", have, want)
+	}
+	if status.Waves[1].StartedAt != nil {
+		t.Error("second wave should not have started")
+	}
+}
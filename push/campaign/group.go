@@ -0,0 +1,68 @@
+package campaign
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// GroupStore retrieves enrollments and their metadata.
+type GroupStore interface {
+	storage.EnrollmentRetriever
+	storage.EnrollmentMetadataStore
+}
+
+// ResolveGroup returns the IDs of enabled enrollments that have group
+// in their enrollment metadata.
+func ResolveGroup(ctx context.Context, store GroupStore, group string) ([]string, error) {
+	enabled := true
+	enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{Enabled: &enabled})
+	if err != nil {
+		return nil, fmt.Errorf("retrieving enrollments: %w", err)
+	}
+	var ids []string
+	for _, e := range enrollments {
+		meta, err := store.RetrieveEnrollmentMetadata(ctx, e.ID)
+		if err != nil {
+			return nil, fmt.Errorf("retrieving metadata for %s: %w", e.ID, err)
+		}
+		if meta == nil {
+			continue
+		}
+		for _, g := range meta.Groups {
+			if g == group {
+				ids = append(ids, e.ID)
+				break
+			}
+		}
+	}
+	return ids, nil
+}
+
+// ResolveIDs returns the unique IDs targeted by spec, including the
+// IDs of any group. store may be nil if enrollment metadata is not
+// supported in which case targeting a group returns ErrNoGroups.
+func ResolveIDs(ctx context.Context, store GroupStore, spec *Spec) ([]string, error) {
+	ids := spec.IDs
+	if spec.Group != "" {
+		if store == nil {
+			return nil, ErrNoGroups
+		}
+		groupIDs, err := ResolveGroup(ctx, store, spec.Group)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(append([]string{}, ids...), groupIDs...)
+	}
+	seen := make(map[string]struct{}, len(ids))
+	var ret []string
+	for _, id := range ids {
+		if _, ok := seen[id]; ok || id == "" {
+			continue
+		}
+		seen[id] = struct{}{}
+		ret = append(ret, id)
+	}
+	return ret, nil
+}
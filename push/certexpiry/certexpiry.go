@@ -0,0 +1,241 @@
+// Package certexpiry monitors the expiry of stored APNs push certificates.
+package certexpiry
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// DefaultThresholds are the days before expiry at which alerts are sent.
+var DefaultThresholds = []int{30, 14, 7}
+
+// Store retrieves and lists stored push certificates.
+type Store interface {
+	storage.PushCertStore
+	storage.PushCertLister
+}
+
+// Status is the expiry status of a stored push certificate.
+type Status struct {
+	Topic         string    `json:"topic"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	Expired       bool      `json:"expired"`
+
+	// Error is set if the push certificate could not be loaded.
+	Error string `json:"error,omitempty"`
+}
+
+// certNotAfter returns the expiry of topic's push certificate in store.
+func certNotAfter(ctx context.Context, store storage.PushCertStore, topic string) (time.Time, error) {
+	cert, _, err := store.RetrievePushCert(ctx, topic)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if cert == nil || len(cert.Certificate) < 1 {
+		return time.Time{}, errors.New("no certificate")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return leaf.NotAfter, nil
+}
+
+// Check retrieves the expiry status of every stored push certificate
+// as of now. Certificates that cannot be loaded are returned with
+// their Error set.
+func Check(ctx context.Context, store Store, now time.Time) ([]*Status, error) {
+	topics, err := store.RetrievePushCertTopics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving push cert topics: %w", err)
+	}
+	statuses := make([]*Status, 0, len(topics))
+	for _, topic := range topics {
+		status := &Status{Topic: topic}
+		notAfter, err := certNotAfter(ctx, store, topic)
+		if err != nil {
+			status.Error = err.Error()
+		} else {
+			status.NotAfter = notAfter
+			status.DaysRemaining = int(notAfter.Sub(now).Hours() / 24)
+			status.Expired = !now.Before(notAfter)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// AlertFunc is called when a push certificate crosses an expiry
+// threshold (in days).
+type AlertFunc func(ctx context.Context, status *Status, threshold int) error
+
+// Monitor periodically checks push certificate expiry and alerts when
+// a certificate crosses each of the configured thresholds. Alerts only
+// escalate: each threshold is alerted once per certificate (a renewed
+// certificate starts over). Alert state is kept in memory only.
+type Monitor struct {
+	store      Store
+	logger     log.Logger
+	interval   time.Duration
+	thresholds []int
+	alert      AlertFunc
+
+	mu       sync.RWMutex
+	statuses []*Status
+	alerted  map[string]int // lowest alerted threshold by topic and expiry
+}
+
+// Option configures a Monitor.
+type Option func(*Monitor)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(m *Monitor) {
+		m.logger = logger
+	}
+}
+
+// WithInterval sets how often push certificates are checked.
+func WithInterval(interval time.Duration) Option {
+	return func(m *Monitor) {
+		m.interval = interval
+	}
+}
+
+// WithThresholds sets the days before expiry at which alerts are sent.
+func WithThresholds(days ...int) Option {
+	return func(m *Monitor) {
+		m.thresholds = days
+	}
+}
+
+// WithAlertFunc sets the function called for threshold alerts.
+// Alerts are always logged.
+func WithAlertFunc(f AlertFunc) Option {
+	return func(m *Monitor) {
+		m.alert = f
+	}
+}
+
+// New creates a new push certificate expiry Monitor.
+func New(store Store, opts ...Option) *Monitor {
+	m := &Monitor{
+		store:      store,
+		logger:     log.NopLogger,
+		interval:   12 * time.Hour,
+		thresholds: DefaultThresholds,
+		alerted:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	// check the smallest thresholds first to find the most severe
+	m.thresholds = append([]int(nil), m.thresholds...)
+	sort.Ints(m.thresholds)
+	return m
+}
+
+// crossedThreshold returns the most severe threshold days has crossed.
+func (m *Monitor) crossedThreshold(days int) (int, bool) {
+	for _, threshold := range m.thresholds {
+		if days <= threshold {
+			return threshold, true
+		}
+	}
+	return 0, false
+}
+
+// Check checks the expiry of all push certificates and sends any
+// threshold alerts.
+func (m *Monitor) Check(ctx context.Context) error {
+	logger := ctxlog.Logger(ctx, m.logger)
+	statuses, err := Check(ctx, m.store, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.statuses = statuses
+	m.mu.Unlock()
+	for _, status := range statuses {
+		if status.Error != "" {
+			logger.Info("msg", "checking push cert", "topic", status.Topic, "err", status.Error)
+			continue
+		}
+		threshold, ok := m.crossedThreshold(status.DaysRemaining)
+		if !ok {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", status.Topic, status.NotAfter.Unix())
+		m.mu.Lock()
+		prev, alerted := m.alerted[key]
+		if !alerted || threshold < prev {
+			m.alerted[key] = threshold
+		}
+		m.mu.Unlock()
+		if alerted && threshold >= prev {
+			continue
+		}
+		logger.Info(
+			"msg", "push cert expiring",
+			"topic", status.Topic,
+			"not_after", status.NotAfter,
+			"days_remaining", status.DaysRemaining,
+			"threshold", threshold,
+		)
+		if m.alert == nil {
+			continue
+		}
+		if err = m.alert(ctx, status, threshold); err != nil {
+			logger.Info("msg", "sending push cert alert", "topic", status.Topic, "err", err)
+		}
+	}
+	return nil
+}
+
+// Run checks push certificates immediately and then on every interval
+// until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if err := m.Check(ctx); err != nil {
+			ctxlog.Logger(ctx, m.logger).Info("msg", "checking push certs", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Statuses returns the push certificate statuses from the last check.
+func (m *Monitor) Statuses() []*Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.statuses
+}
+
+// Metrics returns the seconds until expiry of each push certificate
+// topic from the last check. It is suitable for use with expvar.Func.
+func (m *Monitor) Metrics() interface{} {
+	metrics := make(map[string]int64)
+	for _, status := range m.Statuses() {
+		if status.Error == "" {
+			metrics[status.Topic] = int64(time.Until(status.NotAfter).Seconds())
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,125 @@
+package certexpiry
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	certs map[string]*tls.Certificate
+}
+
+func (s *fakeStore) IsPushCertStale(context.Context, string, string) (bool, error) {
+	return false, nil
+}
+
+func (s *fakeStore) RetrievePushCert(_ context.Context, topic string) (*tls.Certificate, string, error) {
+	cert, ok := s.certs[topic]
+	if !ok {
+		return nil, "", errors.New("not found")
+	}
+	return cert, "", nil
+}
+
+func (s *fakeStore) StorePushCert(context.Context, []byte, []byte) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeStore) RetrievePushCertTopics(context.Context) ([]string, error) {
+	var topics []string
+	for topic := range s.certs {
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+func certExpiringIn(t *testing.T, d time.Duration) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(d),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}}
+}
+
+func TestMonitorEscalation(t *testing.T) {
+	const day = 24 * time.Hour
+	store := &fakeStore{certs: map[string]*tls.Certificate{
+		"topic.a": certExpiringIn(t, 100*day),
+	}}
+	var alerts []int
+	m := New(store, WithAlertFunc(func(_ context.Context, status *Status, threshold int) error {
+		if status.Topic != "topic.a" {
+			t.Errorf("unexpected topic: %s", status.Topic)
+		}
+		alerts = append(alerts, threshold)
+		return nil
+	}))
+	ctx := context.Background()
+
+	check := func(expiresIn time.Duration, want []int) {
+		t.Helper()
+		// keep the same expiry while escalating
+		if expiresIn != 0 {
+			store.certs["topic.a"] = certExpiringIn(t, expiresIn)
+		}
+		if err := m.Check(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if len(alerts) != len(want) {
+			t.Fatalf("alerts: have %v, want %v", alerts, want)
+		}
+		for i := range want {
+			if alerts[i] != want[i] {
+				t.Fatalf("alerts: have %v, want %v", alerts, want)
+			}
+		}
+	}
+
+	check(0, nil)
+	// jumping well past multiple thresholds alerts only the most severe
+	check(10*day, []int{14})
+	check(0, []int{14})
+	check(5*day, []int{14, 7})
+	check(0, []int{14, 7})
+
+	statuses := m.Statuses()
+	if len(statuses) != 1 || statuses[0].DaysRemaining != 4 || statuses[0].Expired {
+		t.Errorf("unexpected statuses: %+v", statuses[0])
+	}
+	if metrics := m.Metrics().(map[string]int64); metrics["topic.a"] <= 0 {
+		t.Errorf("unexpected metrics: %v", metrics)
+	}
+}
+
+func TestCheckError(t *testing.T) {
+	store := &fakeStore{certs: map[string]*tls.Certificate{
+		"topic.bad": {},
+	}}
+	statuses, err := Check(context.Background(), store, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Error == "" {
+		t.Errorf("expected status error: %+v", statuses)
+	}
+}
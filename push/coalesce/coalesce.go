@@ -0,0 +1,155 @@
+// Package coalesce coalesces pushes to the same enrollments.
+package coalesce
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// valuesContext keeps the values of a context but not its deadline or
+// cancellation.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valuesContext) Done() <-chan struct{}                   { return nil }
+func (valuesContext) Err() error                              { return nil }
+
+// batch is a set of enrollment IDs pushed together.
+type batch struct {
+	ctx  context.Context
+	ids  map[string]struct{}
+	done chan struct{}
+
+	// set before done is closed
+	resps map[string]*push.Response
+	err   error
+}
+
+// Pusher is a push middleware that coalesces pushes requested within a
+// short window into a single batched push. Enrollments requested more
+// than once in the window are pushed only once.
+//
+// Push calls wait until their batch has been pushed. A new batch starts
+// collecting as soon as the previous one is sent so pushes to APNs are
+// pipelined with further enqueues rather than serialized behind them.
+type Pusher struct {
+	next   push.Pusher
+	window time.Duration
+	logger log.Logger
+
+	mu    sync.Mutex
+	batch *batch
+
+	requested atomic.Int64
+	pushed    atomic.Int64
+	batches   atomic.Int64
+}
+
+// Option configures a Pusher.
+type Option func(*Pusher)
+
+// WithLogger configures a logger on the Pusher.
+func WithLogger(logger log.Logger) Option {
+	return func(p *Pusher) {
+		p.logger = logger
+	}
+}
+
+// NewPusher creates a new coalescing push middleware that batches
+// pushes requested within window.
+func NewPusher(next push.Pusher, window time.Duration, opts ...Option) *Pusher {
+	p := &Pusher{
+		next:   next,
+		window: window,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Metrics returns the coalescing push counters.
+func (p *Pusher) Metrics() interface{} {
+	requested, pushed := p.requested.Load(), p.pushed.Load()
+	return map[string]int64{
+		"requested": requested,
+		"pushed":    pushed,
+		"coalesced": requested - pushed,
+		"batches":   p.batches.Load(),
+	}
+}
+
+// Push adds ids to the current batch and waits for the batch to be
+// pushed. The responses for ids are returned along with any error of
+// the batched push. The batched push is not tied to ctx: if ctx is
+// done first Push returns its error but the batch is still pushed. The
+// batch is pushed with the values (e.g. logging and tracing) of the
+// context of its first Push call.
+func (p *Pusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	if len(ids) < 1 {
+		return nil, nil
+	}
+	p.mu.Lock()
+	b := p.batch
+	if b == nil {
+		b = &batch{
+			ctx:  valuesContext{ctx},
+			ids:  make(map[string]struct{}),
+			done: make(chan struct{}),
+		}
+		p.batch = b
+		time.AfterFunc(p.window, func() { p.flush(b) })
+	}
+	for _, id := range ids {
+		b.ids[id] = struct{}{}
+	}
+	p.mu.Unlock()
+	p.requested.Add(int64(len(ids)))
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		if resp, ok := b.resps[id]; ok {
+			ret[id] = resp
+		}
+	}
+	return ret, b.err
+}
+
+// flush pushes b. Push calls after b is detached start a new batch.
+func (p *Pusher) flush(b *batch) {
+	p.mu.Lock()
+	if p.batch == b {
+		p.batch = nil
+	}
+	ids := make([]string, 0, len(b.ids))
+	for id := range b.ids {
+		ids = append(ids, id)
+	}
+	p.mu.Unlock()
+	sort.Strings(ids)
+
+	p.pushed.Add(int64(len(ids)))
+	p.batches.Add(1)
+	b.resps, b.err = p.next.Push(b.ctx, ids)
+	if b.err != nil {
+		p.logger.Info("msg", "pushing batch", "count", len(ids), "err", b.err)
+	} else {
+		p.logger.Debug("msg", "pushed batch", "count", len(ids))
+	}
+	close(b.done)
+}
@@ -0,0 +1,98 @@
+package coalesce
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+)
+
+type fauxPusher struct {
+	mu     sync.Mutex
+	pushes [][]string
+	ctxs   []context.Context
+}
+
+func (p *fauxPusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pushes = append(p.pushes, ids)
+	p.ctxs = append(p.ctxs, ctx)
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		ret[id] = &push.Response{Id: "push-" + id}
+	}
+	return ret, nil
+}
+
+func TestCoalesce(t *testing.T) {
+	next := &fauxPusher{}
+	p := NewPusher(next, 20*time.Millisecond)
+
+	var wg sync.WaitGroup
+	for _, ids := range [][]string{{"ID1"}, {"ID1", "ID2"}, {"ID2"}, {"ID3"}} {
+		wg.Add(1)
+		go func(ids []string) {
+			defer wg.Done()
+			resps, err := p.Push(context.Background(), ids)
+			if err != nil {
+				t.Error(err)
+			}
+			if len(resps) != len(ids) {
+				t.Errorf("responses: have %d, want %d", len(resps), len(ids))
+			}
+			for _, id := range ids {
+				if resp := resps[id]; resp == nil || resp.Id != "push-"+id {
+					t.Errorf("unexpected response for %s: %v", id, resp)
+				}
+			}
+		}(ids)
+	}
+	wg.Wait()
+
+	if len(next.pushes) != 1 || len(next.pushes[0]) != 3 {
+		t.Errorf("pushes: %v", next.pushes)
+	}
+	m := p.Metrics().(map[string]int64)
+	if m["requested"] != 5 || m["pushed"] != 3 || m["coalesced"] != 2 {
+		t.Errorf("metrics: %v", m)
+	}
+
+	// a later push starts a new batch
+	if _, err := p.Push(context.Background(), []string{"ID1"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.pushes) != 2 {
+		t.Errorf("pushes: %v", next.pushes)
+	}
+}
+
+type ctxKey struct{}
+
+func TestCoalesceContext(t *testing.T) {
+	next := &fauxPusher{}
+	p := NewPusher(next, 20*time.Millisecond)
+
+	// the batch keeps the values but not the cancellation of the first
+	// caller's context
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "first"))
+	cancel()
+	if _, err := p.Push(ctx, []string{"ID1"}); err != context.Canceled {
+		t.Fatalf("have %v, want %v", err, context.Canceled)
+	}
+	if _, err := p.Push(context.Background(), []string{"ID2"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(next.ctxs) != 1 {
+		t.Fatalf("pushes: %v", next.pushes)
+	}
+	pushCtx := next.ctxs[0]
+	if have, want := pushCtx.Value(ctxKey{}), "first"; have != want {
+		t.Errorf("value: have %v, want %v", have, want)
+	}
+	if err := pushCtx.Err(); err != nil {
+		t.Errorf("push context: %v", err)
+	}
+}
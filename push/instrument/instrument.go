@@ -0,0 +1,46 @@
+// Package instrument records APNs push metrics.
+package instrument
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/metrics"
+	"github.com/micromdm/nanomdm/push"
+)
+
+// Pusher is a push middleware that counts pushes to enrollments by
+// result.
+type Pusher struct {
+	next push.Pusher
+	rec  metrics.Recorder
+}
+
+// NewPusher creates a new metrics recording push middleware.
+func NewPusher(next push.Pusher, rec metrics.Recorder) *Pusher {
+	return &Pusher{next: next, rec: rec}
+}
+
+// Push pushes to ids and counts the results. If the push fails
+// entirely all of ids are counted as failures.
+func (p *Pusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	resps, err := p.next.Push(ctx, ids)
+	if err != nil {
+		p.rec.Add(metrics.Pushes, float64(len(ids)), "result", "failure")
+		return resps, err
+	}
+	var success, failure float64
+	for _, resp := range resps {
+		if resp != nil && resp.Err != nil {
+			failure++
+		} else {
+			success++
+		}
+	}
+	if success > 0 {
+		p.rec.Add(metrics.Pushes, success, "result", "success")
+	}
+	if failure > 0 {
+		p.rec.Add(metrics.Pushes, failure, "result", "failure")
+	}
+	return resps, err
+}
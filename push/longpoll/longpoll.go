@@ -0,0 +1,94 @@
+// Package longpoll provides a development push provider that notifies
+// long-polling waiters (such as simulated devices and test harnesses)
+// instead of sending APNs push notifications.
+package longpoll
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"sync"
+
+	"github.com/micromdm/nanomdm/push"
+)
+
+// Notifier is a push.Pusher that records "pushes" to enrollment IDs
+// and wakes any waiters for those IDs. A push to an ID with no waiter
+// is kept pending so that the next waiter returns immediately.
+type Notifier struct {
+	mu      sync.Mutex
+	pending map[string]bool
+	waiters map[string][]chan struct{}
+}
+
+// New creates a new long-poll Notifier.
+func New() *Notifier {
+	return &Notifier{
+		pending: make(map[string]bool),
+		waiters: make(map[string][]chan struct{}),
+	}
+}
+
+func newPushID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("longpoll-%x", b)
+}
+
+// Push notifies the waiters of ids, or marks ids as pending if there
+// are no waiters. It never returns an error.
+func (n *Notifier) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	ret := make(map[string]*push.Response)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, id := range ids {
+		if waiters := n.waiters[id]; len(waiters) > 0 {
+			for _, c := range waiters {
+				close(c)
+			}
+			delete(n.waiters, id)
+		} else {
+			n.pending[id] = true
+		}
+		ret[id] = &push.Response{Id: newPushID()}
+	}
+	return ret, nil
+}
+
+// Wait blocks until id is pushed to or ctx is done. It returns true if
+// id was pushed to (including any pending push) and false otherwise.
+func (n *Notifier) Wait(ctx context.Context, id string) bool {
+	n.mu.Lock()
+	if n.pending[id] {
+		delete(n.pending, id)
+		n.mu.Unlock()
+		return true
+	}
+	c := make(chan struct{})
+	n.waiters[id] = append(n.waiters[id], c)
+	n.mu.Unlock()
+	select {
+	case <-c:
+		return true
+	case <-ctx.Done():
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-c:
+		// pushed while we were re-acquiring the lock
+		return true
+	default:
+	}
+	waiters := n.waiters[id]
+	for i := range waiters {
+		if waiters[i] == c {
+			n.waiters[id] = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(n.waiters[id]) == 0 {
+		delete(n.waiters, id)
+	}
+	return false
+}
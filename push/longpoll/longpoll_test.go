@@ -0,0 +1,59 @@
+package longpoll
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPending(t *testing.T) {
+	n := New()
+	if _, err := n.Push(context.Background(), []string{"A"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !n.Wait(ctx, "A") {
+		t.Error("expected pending push")
+	}
+	// pending push should be consumed
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if n.Wait(ctx, "A") {
+		t.Error("expected timeout")
+	}
+	if len(n.waiters) != 0 {
+		t.Error("expected waiter cleanup")
+	}
+}
+
+func TestWaiter(t *testing.T) {
+	n := New()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := make(chan bool)
+	go func() { result <- n.Wait(ctx, "A") }()
+	// wait for the waiter to register
+	for i := 0; i < 100; i++ {
+		n.mu.Lock()
+		ct := len(n.waiters["A"])
+		n.mu.Unlock()
+		if ct > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	resp, _ := n.Push(context.Background(), []string{"A", "B"})
+	if len(resp) != 2 {
+		t.Errorf("responses: have %d, want 2", len(resp))
+	}
+	if !<-result {
+		t.Error("expected notification")
+	}
+	if n.pending["A"] {
+		t.Error("waited push should not be pending")
+	}
+	if !n.pending["B"] {
+		t.Error("expected pending push")
+	}
+}
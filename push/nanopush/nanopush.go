@@ -1,13 +1,20 @@
 // Pacakge nanopush implements an Apple APNs HTTP/2 service for MDM.
 // It implements the PushProvider and PushProviderFactory interfaces.
+//
+// MDM pushes are authenticated with the MDM push certificate (mutual
+// TLS). APNs does not accept token-based (.p8 key) provider
+// authentication for MDM push topics.
 package nanopush
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/micromdm/nanomdm/cryptoutil"
 	"github.com/micromdm/nanomdm/push"
 	"golang.org/x/net/http2"
 )
@@ -46,6 +53,8 @@ type Factory struct {
 	newClient  NewClient
 	expiration time.Duration
 	workers    int
+	baseURL    string
+	topicURLs  map[string]string
 }
 
 type Option func(*Factory)
@@ -72,11 +81,33 @@ func WithWorkers(workers int) Option {
 	}
 }
 
+// WithBaseURL sets the APNs server URL for all push topics. Use
+// Development to send pushes to the APNs sandbox environment
+// (e.g. for development-provisioned MDM builds). The default is
+// Production.
+func WithBaseURL(baseURL string) Option {
+	return func(f *Factory) {
+		f.baseURL = baseURL
+	}
+}
+
+// WithTopicBaseURL sets the APNs server URL for a single push topic,
+// overriding WithBaseURL.
+func WithTopicBaseURL(topic, baseURL string) Option {
+	return func(f *Factory) {
+		if f.topicURLs == nil {
+			f.topicURLs = make(map[string]string)
+		}
+		f.topicURLs[topic] = baseURL
+	}
+}
+
 // NewFactory creates a new Factory.
 func NewFactory(opts ...Option) *Factory {
 	f := &Factory{
 		newClient: defaultNewClient,
 		workers:   5,
+		baseURL:   Production,
 	}
 	for _, opt := range opts {
 		opt(f)
@@ -89,9 +120,36 @@ func (f *Factory) NewPushProvider(cert *tls.Certificate) (push.PushProvider, err
 	p := &Provider{
 		expiration: f.expiration,
 		workers:    f.workers,
-		baseURL:    Production,
+		baseURL:    f.baseURL,
+	}
+	if len(f.topicURLs) > 0 {
+		topic, err := certTopic(cert)
+		if err != nil {
+			return nil, err
+		}
+		if baseURL, ok := f.topicURLs[topic]; ok {
+			p.baseURL = baseURL
+		}
 	}
 	var err error
 	p.client, err = f.newClient(cert)
 	return p, err
 }
+
+// certTopic returns the APNs push topic of cert.
+func certTopic(cert *tls.Certificate) (string, error) {
+	if cert == nil {
+		return "", errors.New("no cert provided")
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if len(cert.Certificate) < 1 {
+			return "", errors.New("no certificate in keypair")
+		}
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return "", fmt.Errorf("parsing certificate: %w", err)
+		}
+	}
+	return cryptoutil.TopicFromCert(leaf)
+}
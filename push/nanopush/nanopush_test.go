@@ -0,0 +1,65 @@
+package nanopush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTopicCert(t *testing.T, topic string) *tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+			{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: topic},
+		}},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestFactoryBaseURL(t *testing.T) {
+	newClient := WithNewClient(func(*tls.Certificate) (*http.Client, error) {
+		return http.DefaultClient, nil
+	})
+	for _, test := range []struct {
+		name  string
+		opts  []Option
+		topic string
+		want  string
+	}{
+		{"default", nil, "com.apple.mgmt.a", Production},
+		{"global", []Option{WithBaseURL(Development)}, "com.apple.mgmt.a", Development},
+		{"topic", []Option{WithTopicBaseURL("com.apple.mgmt.b", Development)}, "com.apple.mgmt.b", Development},
+		{"other-topic", []Option{WithTopicBaseURL("com.apple.mgmt.b", Development)}, "com.apple.mgmt.a", Production},
+		{"topic-override", []Option{WithBaseURL(Development), WithTopicBaseURL("com.apple.mgmt.a", Production)}, "com.apple.mgmt.a", Production},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			f := NewFactory(append(test.opts, newClient)...)
+			p, err := f.NewPushProvider(newTopicCert(t, test.topic))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if have := p.(*Provider).baseURL; have != test.want {
+				t.Errorf("have %q, want %q", have, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Package pushcert helps generate APNs MDM push certificate requests
+// for the Apple Push Certificates Portal.
+//
+// The portal accepts a push certificate request that contains a
+// customer certificate signing request (CSR) signed by an MDM vendor
+// certificate. See the "MDM Vendor CSR Signing Overview" in Apple's
+// MDM documentation.
+package pushcert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+
+	"github.com/groob/plist"
+)
+
+// KeySize is the RSA key size of generated push certificate keys.
+const KeySize = 2048
+
+// Request is the push certificate request plist uploaded (in base64
+// encoding) to the Apple Push Certificates Portal.
+type Request struct {
+	PushCertRequestCSR       string
+	PushCertCertificateChain string
+	PushCertSignature        string
+}
+
+// NewKeyAndCSR generates a new RSA private key and a DER-encoded
+// certificate signing request for a push certificate.
+func NewKeyAndCSR(commonName, email string) (*rsa.PrivateKey, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, KeySize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generating key: %w", err)
+	}
+	template := &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		SignatureAlgorithm: x509.SHA256WithRSA,
+	}
+	if email != "" {
+		template.EmailAddresses = []string{email}
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating CSR: %w", err)
+	}
+	return key, csr, nil
+}
+
+// SignRequest signs the DER-encoded csr with the MDM vendor key and
+// returns the push certificate request. The vendorChain must start
+// with the MDM vendor certificate and should be followed by the Apple
+// intermediate (WWDR) and Apple root CA certificates.
+func SignRequest(csr []byte, vendorKey crypto.Signer, vendorChain []*x509.Certificate) (*Request, error) {
+	if len(vendorChain) < 1 {
+		return nil, errors.New("no vendor certificate")
+	}
+	if _, err := x509.ParseCertificateRequest(csr); err != nil {
+		return nil, fmt.Errorf("parsing CSR: %w", err)
+	}
+	hashed := sha256.Sum256(csr)
+	sig, err := vendorKey.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("signing CSR: %w", err)
+	}
+	var chain []byte
+	for _, cert := range vendorChain {
+		chain = append(chain, cryptoutil.PEMCertificate(cert.Raw)...)
+	}
+	return &Request{
+		PushCertRequestCSR:       base64.StdEncoding.EncodeToString(csr),
+		PushCertCertificateChain: string(chain),
+		PushCertSignature:        base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// Encode returns the base64-encoded plist of r suitable for upload to
+// the Apple Push Certificates Portal.
+func (r *Request) Encode() ([]byte, error) {
+	b, err := plist.MarshalIndent(r, "\t")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, base64.StdEncoding.EncodedLen(len(b)))
+	base64.StdEncoding.Encode(out, b)
+	return out, nil
+}
+
+// ParseCertificates parses one or more PEM or (a single) DER encoded
+// certificates in b.
+func ParseCertificates(b []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+	// vendor certificates downloaded from Apple are DER encoded
+	cert, err := x509.ParseCertificate(b)
+	if err != nil {
+		return nil, fmt.Errorf("no PEM certificates and parsing DER: %w", err)
+	}
+	return []*x509.Certificate{cert}, nil
+}
+
+// ParsePrivateKey parses an unencrypted PEM-encoded PKCS#1 or PKCS#8
+// RSA private key.
+func ParsePrivateKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data")
+	}
+	if x509.IsEncryptedPEMBlock(block) {
+		return nil, errors.New("private key PEM appears to be encrypted")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// PEMPrivateKey returns key encoded as a PKCS#1 PEM block.
+func PEMPrivateKey(key *rsa.PrivateKey) []byte {
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+}
@@ -0,0 +1,99 @@
+package pushcert
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/groob/plist"
+)
+
+func TestSignRequest(t *testing.T) {
+	vendorKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "MDM Vendor: Test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &vendorKey.PublicKey, vendorKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vendorCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, csr, err := NewKeyAndCSR("Test Push", "test@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsedCSR, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.PublicKey.Equal(parsedCSR.PublicKey) {
+		t.Error("CSR public key does not match key")
+	}
+
+	req, err := SignRequest(csr, vendorKey, []*x509.Certificate{vendorCert})
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := req.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decode the request and verify the signature of the CSR
+	plistBytes, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := new(Request)
+	if err = plist.Unmarshal(plistBytes, decoded); err != nil {
+		t.Fatal(err)
+	}
+	csrBytes, err := base64.StdEncoding.DecodeString(decoded.PushCertRequestCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(decoded.PushCertSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(csrBytes)
+	if err = rsa.VerifyPKCS1v15(&vendorKey.PublicKey, crypto.SHA256, hashed[:], sig); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(decoded.PushCertCertificateChain, "-----BEGIN CERTIFICATE-----") {
+		t.Error("expected PEM certificate chain")
+	}
+
+	certs, err := ParseCertificates([]byte(decoded.PushCertCertificateChain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(vendorCert) {
+		t.Error("certificate chain mismatch")
+	}
+	parsedKey, err := ParsePrivateKey(PEMPrivateKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsedKey.Equal(key) {
+		t.Error("private key mismatch")
+	}
+}
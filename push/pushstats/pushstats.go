@@ -0,0 +1,125 @@
+// Package pushstats tallies recent APNs push outcomes by topic.
+package pushstats
+
+import (
+	"sync"
+	"time"
+)
+
+// buckets is the number of time buckets a window is divided into.
+const buckets = 60
+
+type bucket struct {
+	start    time.Time
+	pushes   int
+	failures int
+}
+
+// Rate is the push outcome tally of a topic over the recent window.
+type Rate struct {
+	Pushes      int     `json:"pushes"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+}
+
+// Recorder keeps a sliding window of push outcomes by topic.
+// Outcomes are kept in memory only.
+type Recorder struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	topics map[string][]*bucket // oldest bucket first
+}
+
+// Option configures a Recorder.
+type Option func(*Recorder)
+
+// WithWindow sets how far back push outcomes are tallied.
+func WithWindow(window time.Duration) Option {
+	return func(r *Recorder) {
+		r.window = window
+	}
+}
+
+// New creates a new push outcome Recorder.
+func New(opts ...Option) *Recorder {
+	r := &Recorder{
+		window: time.Hour,
+		now:    time.Now,
+		topics: make(map[string][]*bucket),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// prune removes the buckets of topic that have left the window.
+// The mutex must be held.
+func (r *Recorder) prune(topic string, now time.Time) []*bucket {
+	b := r.topics[topic]
+	cutoff := now.Add(-r.window)
+	var i int
+	for i < len(b) && !b[i].start.After(cutoff) {
+		i++
+	}
+	if i == len(b) {
+		delete(r.topics, topic)
+		return nil
+	}
+	b = b[i:]
+	r.topics[topic] = b
+	return b
+}
+
+// Record records that pushes pushes were sent to topic of which
+// failures failed.
+func (r *Recorder) Record(topic string, pushes, failures int) {
+	if pushes < 1 {
+		return
+	}
+	now := r.now()
+	start := now.Truncate(r.window / buckets)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.prune(topic, now)
+	if len(b) < 1 || !b[len(b)-1].start.Equal(start) {
+		b = append(b, &bucket{start: start})
+		r.topics[topic] = b
+	}
+	b[len(b)-1].pushes += pushes
+	b[len(b)-1].failures += failures
+}
+
+// Rates returns the push outcome tally of each topic with pushes
+// recorded in the window.
+func (r *Recorder) Rates() map[string]*Rate {
+	now := r.now()
+	rates := make(map[string]*Rate)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for topic := range r.topics {
+		rate := new(Rate)
+		for _, b := range r.prune(topic, now) {
+			rate.Pushes += b.pushes
+			rate.Failures += b.failures
+		}
+		if rate.Pushes < 1 {
+			continue
+		}
+		rate.FailureRate = float64(rate.Failures) / float64(rate.Pushes)
+		rates[topic] = rate
+	}
+	return rates
+}
+
+// Metrics returns the failure rate of each topic with pushes recorded
+// in the window. It is suitable for use with expvar.Func.
+func (r *Recorder) Metrics() interface{} {
+	metrics := make(map[string]float64)
+	for topic, rate := range r.Rates() {
+		metrics[topic] = rate.FailureRate
+	}
+	return metrics
+}
@@ -0,0 +1,45 @@
+package pushstats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRates(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	r := New(WithWindow(time.Hour))
+	r.now = func() time.Time { return now }
+
+	r.Record("topic.a", 3, 1)
+	r.Record("topic.a", 1, 0)
+	r.Record("topic.b", 2, 2)
+	r.Record("topic.c", 0, 0)
+
+	rates := r.Rates()
+	if have, want := len(rates), 2; have != want {
+		t.Fatalf("topics: have %d, want %d", have, want)
+	}
+	a := rates["topic.a"]
+	if a == nil || a.Pushes != 4 || a.Failures != 1 || a.FailureRate != 0.25 {
+		t.Errorf("unexpected topic.a rate: %+v", a)
+	}
+	if b := rates["topic.b"]; b == nil || b.FailureRate != 1 {
+		t.Errorf("unexpected topic.b rate: %+v", b)
+	}
+
+	// outcomes should leave the window
+	now = now.Add(30 * time.Minute)
+	r.Record("topic.a", 1, 1)
+	now = now.Add(45 * time.Minute)
+	rates = r.Rates()
+	if _, ok := rates["topic.b"]; ok {
+		t.Error("expected topic.b to leave the window")
+	}
+	a = rates["topic.a"]
+	if a == nil || a.Pushes != 1 || a.Failures != 1 {
+		t.Errorf("unexpected topic.a rate: %+v", a)
+	}
+	if metrics := r.Metrics().(map[string]float64); metrics["topic.a"] != 1 {
+		t.Errorf("unexpected metrics: %v", metrics)
+	}
+}
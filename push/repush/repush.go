@@ -0,0 +1,263 @@
+// Package repush pushes to every enrollment with commands waiting to
+// be sent.
+//
+// After an APNs outage (or a gap in processing webhook events that
+// enqueue commands) many devices may be sitting on undelivered
+// commands until they next check in on their own. A repush finds all
+// of these enrollments and pushes to them in rate limited batches.
+package repush
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// Repush states.
+const (
+	StateRunning   = "running"
+	StateCompleted = "completed"
+	StateCanceled  = "canceled"
+)
+
+// Default rate of pushes.
+const (
+	DefaultBatchSize = 100
+	DefaultInterval  = time.Second
+)
+
+var (
+	ErrRunning     = errors.New("repush already running")
+	ErrNotRunning  = errors.New("repush not running")
+	ErrInvalidRate = errors.New("invalid batch size or interval")
+)
+
+// Status is the status of a repush.
+type Status struct {
+	ID        string `json:"id"`
+	JobID     string `json:"job_id,omitempty"`
+	State     string `json:"state"`
+	BatchSize int    `json:"batch_size"`
+	Interval  string `json:"interval"`
+
+	Total      int `json:"total"`
+	PushCount  int `json:"push_count"`
+	PushErrors int `json:"push_errors"`
+
+	// Error is the last error pushing a batch, if any.
+	Error string `json:"error,omitempty"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Repusher runs repushes and keeps the status of the latest. Only one
+// repush runs at a time. Status is only tracked in memory and does not
+// survive a restart (though its job, if any, does).
+type Repusher struct {
+	store  storage.QueuedEnrollmentRetriever
+	pusher push.Pusher
+	jobs   storage.JobStore
+	logger log.Logger
+
+	mu     sync.RWMutex
+	status *Status
+	cancel chan struct{}
+
+	now   func() time.Time
+	after func(time.Duration) <-chan time.Time
+}
+
+// Option configures a Repusher.
+type Option func(*Repusher)
+
+// WithLogger sets the logger for the Repusher.
+func WithLogger(logger log.Logger) Option {
+	return func(r *Repusher) {
+		r.logger = logger
+	}
+}
+
+// WithJobStore tracks each repush as a push-only job in store.
+func WithJobStore(store storage.JobStore) Option {
+	return func(r *Repusher) {
+		r.jobs = store
+	}
+}
+
+// New creates a new Repusher.
+func New(store storage.QueuedEnrollmentRetriever, pusher push.Pusher, opts ...Option) *Repusher {
+	r := &Repusher{
+		store:  store,
+		pusher: pusher,
+		logger: log.NopLogger,
+		now:    time.Now,
+		after:  time.After,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// batches splits ids into batches of at most size.
+func batches(ids []string, size int) [][]string {
+	var ret [][]string
+	for len(ids) > size {
+		ret = append(ret, ids[:size])
+		ids = ids[size:]
+	}
+	if len(ids) > 0 {
+		ret = append(ret, ids)
+	}
+	return ret
+}
+
+// Start retrieves the enrollments with queued commands and pushes to
+// them in the background in batches of batchSize, waiting interval
+// between batches. ErrRunning is returned if a repush is already
+// running.
+func (r *Repusher) Start(ctx context.Context, batchSize int, interval time.Duration) (*Status, error) {
+	if batchSize < 1 || interval < 0 {
+		return nil, ErrInvalidRate
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status != nil && r.status.State == StateRunning {
+		return nil, ErrRunning
+	}
+	ids, err := r.store.RetrieveQueuedEnrollments(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving queued enrollments: %w", err)
+	}
+	status := &Status{
+		ID:        newID(),
+		State:     StateRunning,
+		BatchSize: batchSize,
+		Interval:  interval.String(),
+		Total:     len(ids),
+		CreatedAt: r.now(),
+	}
+	logger := r.logger.With("repush_id", status.ID)
+	if len(ids) > 0 && r.jobs != nil {
+		job := &storage.Job{ID: status.ID, Name: "repush " + status.ID}
+		if err = r.jobs.StoreJob(ctx, job, ids); err != nil {
+			logger.Info("msg", "storing job", "err", err)
+		} else {
+			status.JobID = job.ID
+		}
+	}
+	r.status = status
+	r.cancel = make(chan struct{})
+	go r.run(status.JobID, batches(ids, batchSize), interval, r.cancel, logger)
+	s := *status
+	return &s, nil
+}
+
+// run pushes to each of batches, waiting interval between them.
+func (r *Repusher) run(jobID string, batches [][]string, interval time.Duration, cancel chan struct{}, logger log.Logger) {
+	ctx := context.Background()
+	logger.Info("msg", "repush started", "batches", len(batches))
+	state := StateCompleted
+	for i, ids := range batches {
+		if i > 0 {
+			select {
+			case <-cancel:
+				state = StateCanceled
+			case <-r.after(interval):
+			}
+		}
+		if state == StateCanceled {
+			break
+		}
+		pushResp, err := r.pusher.Push(ctx, ids)
+		var delivered, errored []string
+		for id, resp := range pushResp {
+			if resp.Err != nil {
+				errored = append(errored, id)
+			} else {
+				delivered = append(delivered, id)
+			}
+		}
+		if err != nil {
+			logger.Info("msg", "repush batch", "batch", i+1, "count", len(ids), "err", err)
+			if len(pushResp) == 0 {
+				errored = ids
+			}
+		}
+		r.updateJob(ctx, jobID, storage.JobStatusDelivered, delivered, logger)
+		r.updateJob(ctx, jobID, storage.JobStatusErrored, errored, logger)
+		r.mu.Lock()
+		r.status.PushCount += len(delivered)
+		r.status.PushErrors += len(errored)
+		if err != nil {
+			r.status.Error = err.Error()
+		}
+		r.mu.Unlock()
+	}
+	finished := r.now()
+	r.mu.Lock()
+	r.status.State = state
+	r.status.FinishedAt = &finished
+	logs := []interface{}{
+		"msg", "repush finished",
+		"state", state,
+		"push_count", r.status.PushCount,
+		"push_errs", r.status.PushErrors,
+	}
+	r.mu.Unlock()
+	logger.Info(logs...)
+}
+
+// updateJob sets the status of ids in job jobID, if any.
+func (r *Repusher) updateJob(ctx context.Context, jobID, status string, ids []string, logger log.Logger) {
+	if r.jobs == nil || jobID == "" || len(ids) < 1 {
+		return
+	}
+	if err := r.jobs.UpdateJobTargets(ctx, jobID, status, ids); err != nil {
+		logger.Info("msg", "updating job targets", "job_id", jobID, "err", err)
+	}
+}
+
+// Status returns the status of the running or latest repush. Nil is
+// returned if there has not been a repush.
+func (r *Repusher) Status() *Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.status == nil {
+		return nil
+	}
+	s := *r.status
+	return &s
+}
+
+// Cancel stops the running repush from pushing any further batches.
+// A batch that is already being pushed is not interrupted.
+func (r *Repusher) Cancel() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status == nil || r.status.State != StateRunning {
+		return ErrNotRunning
+	}
+	select {
+	case <-r.cancel:
+		return ErrNotRunning
+	default:
+		close(r.cancel)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package repush
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage/file"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type recorder struct {
+	mu     sync.Mutex
+	pushes [][]string
+}
+
+func (r *recorder) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pushes = append(r.pushes, ids)
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		ret[id] = &push.Response{Id: "push-" + id}
+	}
+	return ret, nil
+}
+
+func waitFinished(t *testing.T, r *Repusher) *Status {
+	t.Helper()
+	var status *Status
+	for i := 0; i < 100; i++ {
+		if status = r.Status(); status.State != StateRunning {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return status
+}
+
+func TestBatches(t *testing.T) {
+	split := batches([]string{"A", "B", "C", "D", "E"}, 2)
+	for i, want := range []int{2, 2, 1} {
+		if have := len(split[i]); have != want {
+			t.Errorf("batch %d: have %d, want %d", i, have, want)
+		}
+	}
+	if have, want := len(batches(nil, 2)), 0; have != want {
+		t.Errorf("empty batches: have %d, want %d", have, want)
+	}
+}
+
+func TestRepush(t *testing.T) {
+	ctx := context.Background()
+	store := new(mock.Storage)
+	store.RetrieveQueuedEnrollmentsFunc = func(context.Context) ([]string, error) {
+		return []string{"A", "B", "C"}, nil
+	}
+	jobs, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &recorder{}
+	r := New(store, rec, WithJobStore(jobs))
+	var delays []time.Duration
+	r.after = func(d time.Duration) <-chan time.Time {
+		delays = append(delays, d)
+		c := make(chan time.Time, 1)
+		c <- time.Now()
+		return c
+	}
+
+	if _, err = r.Start(ctx, 0, time.Second); err != ErrInvalidRate {
+		t.Errorf("invalid rate: have %v, want %v", err, ErrInvalidRate)
+	}
+	status, err := r.Start(ctx, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := status.Total, 3; have != want {
+		t.Errorf("total: have %d, want %d", have, want)
+	}
+	status = waitFinished(t, r)
+	if have, want := status.State, StateCompleted; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+	if have, want := status.PushCount, 3; have != want {
+		t.Errorf("push count: have %d, want %d", have, want)
+	}
+	rec.mu.Lock()
+	if have, want := len(rec.pushes), 2; have != want {
+		t.Errorf("batches pushed: have %d, want %d", have, want)
+	}
+	rec.mu.Unlock()
+	if len(delays) != 1 || delays[0] != time.Minute {
+		t.Errorf("unexpected delays: %v", delays)
+	}
+
+	job, err := jobs.RetrieveJob(ctx, status.JobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job == nil || job.Counts.Total != 3 || job.Counts.Delivered != 3 {
+		t.Errorf("unexpected job: %+v", job)
+	}
+}
+
+func TestRepushCancel(t *testing.T) {
+	ctx := context.Background()
+	store := new(mock.Storage)
+	store.RetrieveQueuedEnrollmentsFunc = func(context.Context) ([]string, error) {
+		return []string{"A", "B"}, nil
+	}
+	r := New(store, &recorder{})
+	r.after = func(time.Duration) <-chan time.Time { return nil }
+
+	if _, err := r.Start(ctx, 1, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Start(ctx, 1, time.Hour); err != ErrRunning {
+		t.Errorf("second start: have %v, want %v", err, ErrRunning)
+	}
+	if err := r.Cancel(); err != nil {
+		t.Fatal(err)
+	}
+	status := waitFinished(t, r)
+	if have, want := status.State, StateCanceled; have != want {
+		t.Errorf("state: have %q, want %q", have, want)
+	}
+	if have, want := status.PushCount, 1; have != want {
+		t.Errorf("push count: have %d, want %d", have, want)
+	}
+	if err := r.Cancel(); err != ErrNotRunning {
+		t.Errorf("cancel: have %v, want %v", err, ErrNotRunning)
+	}
+}
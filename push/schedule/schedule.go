@@ -0,0 +1,129 @@
+// Package schedule pushes to enrollments when their scheduled commands
+// become due.
+//
+// Commands enqueued with a NotBefore in the future are not pushed when
+// they are enqueued (as the enrollment would not receive them). Instead
+// the Scheduler periodically retrieves the enrollments with commands
+// that became due since its last check and pushes to them.
+package schedule
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Scheduler periodically pushes to enrollments whose scheduled commands
+// became due. The time of the last check is kept in memory only: the
+// first check covers the interval before the Scheduler started and
+// commands that became due while it was not running are not pushed
+// (though they are still sent when the enrollment next checks in).
+type Scheduler struct {
+	store    storage.CommandScheduleStore
+	pusher   push.Pusher
+	logger   log.Logger
+	interval time.Duration
+
+	mu     sync.Mutex
+	last   time.Time
+	pushes int
+}
+
+// Option configures a Scheduler.
+type Option func(*Scheduler)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = logger
+	}
+}
+
+// WithInterval sets how often due commands are checked for.
+func WithInterval(interval time.Duration) Option {
+	return func(s *Scheduler) {
+		s.interval = interval
+	}
+}
+
+// New creates a new Scheduler.
+func New(store storage.CommandScheduleStore, pusher push.Pusher, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		store:    store,
+		pusher:   pusher,
+		logger:   log.NopLogger,
+		interval: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Push pushes to the enrollments with commands that became due after
+// the last successful Push and at or before now.
+func (s *Scheduler) Push(ctx context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last.IsZero() {
+		s.last = now.Add(-s.interval)
+	}
+	if !now.After(s.last) {
+		return nil
+	}
+	ids, err := s.store.RetrieveScheduledEnrollments(ctx, s.last, now)
+	if err != nil {
+		return err
+	}
+	if len(ids) > 0 {
+		resps, err := s.pusher.Push(ctx, ids)
+		if err != nil && len(resps) < 1 {
+			return err
+		}
+		var errCt int
+		for _, resp := range resps {
+			if resp != nil && resp.Err != nil {
+				errCt++
+			}
+		}
+		logs := []interface{}{"msg", "pushed scheduled commands", "count", len(ids)}
+		if errCt > 0 || err != nil {
+			logs = append(logs, "errs", errCt, "err", err)
+		}
+		ctxlog.Logger(ctx, s.logger).Info(logs...)
+		s.pushes += len(ids)
+	}
+	s.last = now
+	return nil
+}
+
+// Run pushes to enrollments with due commands every interval until ctx
+// is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.Push(ctx, now); err != nil {
+				ctxlog.Logger(ctx, s.logger).Info("msg", "pushing scheduled commands", "err", err)
+			}
+		}
+	}
+}
+
+// Metrics returns the number of pushes sent for scheduled commands. It
+// is suitable for use with expvar.Func.
+func (s *Scheduler) Metrics() interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]int{"pushes": s.pushes}
+}
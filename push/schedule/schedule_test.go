@@ -0,0 +1,68 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type fauxPusher struct {
+	pushes [][]string
+}
+
+func (p *fauxPusher) Push(_ context.Context, ids []string) (map[string]*push.Response, error) {
+	p.pushes = append(p.pushes, ids)
+	ret := make(map[string]*push.Response)
+	for _, id := range ids {
+		ret[id] = &push.Response{Id: "push-" + id}
+	}
+	return ret, nil
+}
+
+func TestPush(t *testing.T) {
+	type window struct{ after, until time.Time }
+	var windows []window
+	var retrieveErr error
+	store := new(mock.Storage)
+	store.RetrieveScheduledEnrollmentsFunc = func(_ context.Context, after, until time.Time) ([]string, error) {
+		windows = append(windows, window{after, until})
+		if retrieveErr != nil {
+			return nil, retrieveErr
+		}
+		return []string{"ID1"}, nil
+	}
+	pusher := &fauxPusher{}
+	s := New(store, pusher, WithInterval(time.Minute))
+	ctx := context.Background()
+
+	now := time.Now()
+	if err := s.Push(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := windows[0], (window{now.Add(-time.Minute), now}); have != want {
+		t.Errorf("first window: have %v, want %v", have, want)
+	}
+	if len(pusher.pushes) != 1 || len(pusher.pushes[0]) != 1 || pusher.pushes[0][0] != "ID1" {
+		t.Errorf("unexpected pushes: %v", pusher.pushes)
+	}
+
+	// failed retrievals are retried with the same window start
+	retrieveErr = errors.New("retrieve error")
+	if err := s.Push(ctx, now.Add(time.Minute)); err == nil {
+		t.Error("expected error")
+	}
+	retrieveErr = nil
+	if err := s.Push(ctx, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := windows[2], (window{now, now.Add(2 * time.Minute)}); have != want {
+		t.Errorf("window after error: have %v, want %v", have, want)
+	}
+	if have, want := len(pusher.pushes), 2; have != want {
+		t.Errorf("pushes: have %d, want %d", have, want)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/push/pushstats"
 	"github.com/micromdm/nanomdm/storage"
 
 	"github.com/micromdm/nanolib/log"
@@ -30,17 +31,50 @@ type PushService struct {
 	providersMu     sync.RWMutex
 	logger          log.Logger
 	providerFactory push.PushProviderFactory
+	stats           *pushstats.Recorder
+}
+
+// Option configures a PushService.
+type Option func(*PushService)
+
+// WithStats records the outcome of pushes by topic in stats.
+func WithStats(stats *pushstats.Recorder) Option {
+	return func(s *PushService) {
+		s.stats = stats
+	}
 }
 
 // NewPushService creates a new PushService.
-func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger) *PushService {
-	return &PushService{
+func New(store storage.PushStore, certStore storage.PushCertStore, providerFactory push.PushProviderFactory, logger log.Logger, opts ...Option) *PushService {
+	s := &PushService{
 		logger:          logger,
 		store:           store,
 		certStore:       certStore,
 		providers:       make(map[string]*provider),
 		providerFactory: providerFactory,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// record records the outcome of pushes to topic, if configured.
+// All pushes are considered failed if err is not nil.
+func (s *PushService) record(topic string, pushes int, responses map[string]*push.Response, err error) {
+	if s.stats == nil {
+		return
+	}
+	failures := pushes
+	if err == nil {
+		failures = 0
+		for _, resp := range responses {
+			if resp != nil && resp.Err != nil {
+				failures++
+			}
+		}
+	}
+	s.stats.Record(topic, pushes, failures)
 }
 
 // getProvider returns a PushProvider if it exists and is not stale.
@@ -101,9 +135,12 @@ func (s *PushService) pushSingle(ctx context.Context, pushInfo *mdm.Push) (map[s
 	}
 	prov, err := s.getProvider(ctx, pushInfo.Topic)
 	if err != nil {
+		s.record(pushInfo.Topic, 1, nil, err)
 		return nil, err
 	}
-	return prov.Push(ctx, []*mdm.Push{pushInfo})
+	resp, err := prov.Push(ctx, []*mdm.Push{pushInfo})
+	s.record(pushInfo.Topic, 1, resp, err)
+	return resp, err
 }
 
 // pushMulti sends pushes to (potentially) multiple push providers
@@ -124,12 +161,14 @@ func (s *PushService) pushMulti(ctx context.Context, pushInfos []*mdm.Push) (map
 				"msg", "get provider",
 				"err", err,
 			)
+			s.record(topic, len(pushInfos), nil, err)
 			finalErr = err
 			continue
 		}
 		topicPushCt += 1
 		go func(prov push.PushProvider, pushInfos []*mdm.Push, feedback chan<- pushFeedback, topic string) {
 			resp, err := prov.Push(ctx, pushInfos)
+			s.record(topic, len(pushInfos), resp, err)
 			feedback <- pushFeedback{
 				Responses: resp,
 				Err:       err,
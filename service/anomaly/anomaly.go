@@ -0,0 +1,183 @@
+// Package anomaly detects fleet-wide rate-of-change anomalies such as
+// mass CheckOuts, spikes in command errors, and push failure surges.
+//
+// Events are counted in memory over a sliding window. When a count
+// reaches its threshold an alert is raised. An alert is raised at most
+// once per window for the same type (and request type).
+package anomaly
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// Alert types.
+const (
+	AlertCheckOuts    = "CheckOuts"
+	AlertErrors       = "CommandErrors"
+	AlertPushFailures = "PushFailures"
+)
+
+// buckets is the number of time buckets a window is divided into.
+const buckets = 60
+
+// Alert is a rate-of-change anomaly.
+type Alert struct {
+	Type string
+	// RequestType is the command request type of AlertErrors.
+	RequestType string
+	Count       int
+	Threshold   int
+	Window      time.Duration
+}
+
+// AlertFunc is called when an alert is raised.
+type AlertFunc func(context.Context, *Alert)
+
+type bucket struct {
+	start time.Time
+	n     int
+}
+
+type key struct {
+	alertType   string
+	requestType string
+}
+
+// Detector counts events and raises alerts.
+type Detector struct {
+	window     time.Duration
+	thresholds map[string]int
+	alertFunc  AlertFunc
+	logger     log.Logger
+	now        func() time.Time
+
+	mu      sync.Mutex
+	counts  map[key][]*bucket // oldest bucket first
+	alerted map[key]time.Time
+}
+
+// Option configures a Detector.
+type Option func(*Detector)
+
+// WithLogger configures a logger on the Detector.
+func WithLogger(logger log.Logger) Option {
+	return func(d *Detector) {
+		d.logger = logger
+	}
+}
+
+// WithWindow sets the sliding window events are counted over.
+func WithWindow(window time.Duration) Option {
+	return func(d *Detector) {
+		d.window = window
+	}
+}
+
+// WithThreshold sets the count within the window at which an alert of
+// alertType is raised. Alert types without a threshold are disabled.
+func WithThreshold(alertType string, threshold int) Option {
+	return func(d *Detector) {
+		d.thresholds[alertType] = threshold
+	}
+}
+
+// WithAlertFunc sets the function called (in a new goroutine) when an
+// alert is raised. Alerts are always logged.
+func WithAlertFunc(alertFunc AlertFunc) Option {
+	return func(d *Detector) {
+		d.alertFunc = alertFunc
+	}
+}
+
+// New creates a new anomaly Detector.
+func New(opts ...Option) *Detector {
+	d := &Detector{
+		window:     10 * time.Minute,
+		thresholds: make(map[string]int),
+		logger:     log.NopLogger,
+		now:        time.Now,
+		counts:     make(map[key][]*bucket),
+		alerted:    make(map[key]time.Time),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// add counts n events for k and returns the count in the window.
+// The mutex must be held.
+func (d *Detector) add(k key, n int, now time.Time) int {
+	b := d.counts[k]
+	cutoff := now.Add(-d.window)
+	var i int
+	for i < len(b) && !b[i].start.After(cutoff) {
+		i++
+	}
+	b = b[i:]
+	start := now.Truncate(d.window / buckets)
+	if len(b) < 1 || !b[len(b)-1].start.Equal(start) {
+		b = append(b, &bucket{start: start})
+	}
+	b[len(b)-1].n += n
+	d.counts[k] = b
+	var count int
+	for _, bkt := range b {
+		count += bkt.n
+	}
+	return count
+}
+
+// record counts n events of alertType (and requestType) and raises an
+// alert if the threshold is reached.
+func (d *Detector) record(alertType, requestType string, n int) {
+	threshold := d.thresholds[alertType]
+	if threshold < 1 || n < 1 {
+		return
+	}
+	k := key{alertType: alertType, requestType: requestType}
+	now := d.now()
+	d.mu.Lock()
+	count := d.add(k, n, now)
+	if count < threshold || now.Sub(d.alerted[k]) < d.window {
+		d.mu.Unlock()
+		return
+	}
+	d.alerted[k] = now
+	d.mu.Unlock()
+
+	alert := &Alert{
+		Type:        alertType,
+		RequestType: requestType,
+		Count:       count,
+		Threshold:   threshold,
+		Window:      d.window,
+	}
+	logs := []interface{}{"msg", "anomaly alert", "type", alertType, "count", count, "threshold", threshold, "window", d.window.String()}
+	if requestType != "" {
+		logs = append(logs, "request_type", requestType)
+	}
+	d.logger.Info(logs...)
+	if d.alertFunc != nil {
+		go d.alertFunc(context.Background(), alert)
+	}
+}
+
+// CheckOut records a CheckOut.
+func (d *Detector) CheckOut() {
+	d.record(AlertCheckOuts, "", 1)
+}
+
+// CommandError records an Error command status for requestType.
+func (d *Detector) CommandError(requestType string) {
+	d.record(AlertErrors, requestType, 1)
+}
+
+// PushFailures records n failed pushes.
+func (d *Detector) PushFailures(n int) {
+	d.record(AlertPushFailures, "", n)
+}
@@ -0,0 +1,118 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/service/mock"
+)
+
+func newTestDetector(opts ...Option) (*Detector, *time.Time, chan *Alert) {
+	alerts := make(chan *Alert, 10)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	opts = append(opts, WithWindow(10*time.Minute), WithAlertFunc(func(_ context.Context, a *Alert) {
+		alerts <- a
+	}))
+	d := New(opts...)
+	d.now = func() time.Time { return now }
+	return d, &now, alerts
+}
+
+func expectAlerts(t *testing.T, alerts chan *Alert, n int) []*Alert {
+	t.Helper()
+	var ret []*Alert
+	for i := 0; i < n; i++ {
+		select {
+		case a := <-alerts:
+			ret = append(ret, a)
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d alerts, got %d", n, len(ret))
+		}
+	}
+	select {
+	case a := <-alerts:
+		t.Fatalf("unexpected alert: %+v", a)
+	case <-time.After(10 * time.Millisecond):
+	}
+	return ret
+}
+
+func TestDetector(t *testing.T) {
+	d, now, alerts := newTestDetector(WithThreshold(AlertCheckOuts, 3))
+
+	d.CheckOut()
+	d.CheckOut()
+	expectAlerts(t, alerts, 0)
+	d.CheckOut()
+	a := expectAlerts(t, alerts, 1)[0]
+	if a.Type != AlertCheckOuts || a.Count != 3 || a.Threshold != 3 {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+
+	// no repeated alert within the window
+	d.CheckOut()
+	expectAlerts(t, alerts, 0)
+
+	// old events leave the window
+	*now = now.Add(11 * time.Minute)
+	d.CheckOut()
+	d.CheckOut()
+	expectAlerts(t, alerts, 0)
+	d.CheckOut()
+	expectAlerts(t, alerts, 1)
+
+	// disabled alert types are not counted
+	d.PushFailures(100)
+	expectAlerts(t, alerts, 0)
+}
+
+func TestService(t *testing.T) {
+	d, _, alerts := newTestDetector(WithThreshold(AlertErrors, 2))
+	s := NewService(new(mock.Service), d)
+	r := &mdm.Request{Context: context.Background()}
+	for _, results := range []*mdm.CommandResults{
+		{Status: "Error", RequestType: "InstallProfile"},
+		{Status: "Acknowledged", RequestType: "InstallProfile"},
+		{Status: "Error", RequestType: "ProfileList"},
+		{Status: "Error", RequestType: "InstallProfile"},
+	} {
+		if _, err := s.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a := expectAlerts(t, alerts, 1)[0]
+	if a.Type != AlertErrors || a.RequestType != "InstallProfile" || a.Count != 2 {
+		t.Errorf("unexpected alert: %+v", a)
+	}
+}
+
+type pushFunc func(context.Context, []string) (map[string]*push.Response, error)
+
+func (f pushFunc) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	return f(ctx, ids)
+}
+
+func TestPusher(t *testing.T) {
+	d, _, alerts := newTestDetector(WithThreshold(AlertPushFailures, 3))
+	p := NewPusher(pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		return map[string]*push.Response{
+			"a": {Id: "1"},
+			"b": {Err: errors.New("BadDeviceToken")},
+			"c": {Err: errors.New("BadDeviceToken")},
+		}, nil
+	}), d)
+	if _, err := p.Push(context.Background(), []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	expectAlerts(t, alerts, 0)
+	if _, err := p.Push(context.Background(), []string{"a", "b", "c"}); err != nil {
+		t.Fatal(err)
+	}
+	if a := expectAlerts(t, alerts, 1)[0]; a.Count != 4 {
+		t.Errorf("count: have %d, want 4", a.Count)
+	}
+}
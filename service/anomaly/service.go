@@ -0,0 +1,63 @@
+package anomaly
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/service"
+)
+
+// Service is a service middleware that records CheckOuts and Error
+// command statuses with a Detector. Error statuses are recorded by the
+// RequestType of the command result which not all clients report; they
+// are otherwise recorded with an empty request type.
+type Service struct {
+	service.CheckinAndCommandService
+	detector *Detector
+}
+
+// NewService creates a new anomaly detection service middleware.
+func NewService(next service.CheckinAndCommandService, detector *Detector) *Service {
+	return &Service{CheckinAndCommandService: next, detector: detector}
+}
+
+func (s *Service) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	s.detector.CheckOut()
+	return s.CheckinAndCommandService.CheckOut(r, m)
+}
+
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if results.Status == "Error" {
+		s.detector.CommandError(results.RequestType)
+	}
+	return s.CheckinAndCommandService.CommandAndReportResults(r, results)
+}
+
+// Pusher is a push middleware that records push failures with a
+// Detector. If the push fails entirely every id is counted as failed.
+type Pusher struct {
+	next     push.Pusher
+	detector *Detector
+}
+
+// NewPusher creates a new anomaly detection push middleware.
+func NewPusher(next push.Pusher, detector *Detector) *Pusher {
+	return &Pusher{next: next, detector: detector}
+}
+
+func (p *Pusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	resps, err := p.next.Push(ctx, ids)
+	if err != nil {
+		p.detector.PushFailures(len(ids))
+		return resps, err
+	}
+	var failures int
+	for _, resp := range resps {
+		if resp != nil && resp.Err != nil {
+			failures++
+		}
+	}
+	p.detector.PushFailures(failures)
+	return resps, err
+}
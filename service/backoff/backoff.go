@@ -0,0 +1,125 @@
+// Package backoff is a NanoMDM service middleware that signals devices
+// to back off from polling for commands when storage is degraded.
+package backoff
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DefaultRetryAfter is the default Retry-After duration.
+const DefaultRetryAfter = 5 * time.Minute
+
+// ErrMaintenance is returned for command polls during maintenance.
+var ErrMaintenance = errors.New("maintenance mode")
+
+// Backoff is a service middleware that converts errors handling command
+// polls (command reports) into HTTP 503 Service Unavailable responses
+// with a Retry-After header. Devices treat this as a temporary
+// condition and retry later rather than immediately. Maintenance mode
+// answers all command polls this way without calling the next service.
+//
+// Optionally Idle command polls can be answered with an empty response
+// instead. This tells the device there are no commands and it goes
+// idle until its next push notification. Only Idle polls are answered
+// this way as they do not carry a command result that would be lost.
+type Backoff struct {
+	service.CheckinAndCommandService
+	logger      log.Logger
+	retryAfter  time.Duration
+	emptyIdle   bool
+	maintenance atomic.Bool
+}
+
+// Option configures a Backoff.
+type Option func(*Backoff)
+
+// WithLogger configures a logger on the Backoff.
+func WithLogger(logger log.Logger) Option {
+	return func(b *Backoff) {
+		b.logger = logger
+	}
+}
+
+// WithRetryAfter sets the duration sent in the Retry-After header.
+func WithRetryAfter(d time.Duration) Option {
+	return func(b *Backoff) {
+		b.retryAfter = d
+	}
+}
+
+// WithEmptyIdle answers Idle command polls with an empty response
+// rather than an HTTP 503.
+func WithEmptyIdle() Option {
+	return func(b *Backoff) {
+		b.emptyIdle = true
+	}
+}
+
+// New creates a new backoff service middleware.
+func New(next service.CheckinAndCommandService, opts ...Option) *Backoff {
+	b := &Backoff{
+		CheckinAndCommandService: next,
+		logger:                   log.NopLogger,
+		retryAfter:               DefaultRetryAfter,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// SetMaintenance turns maintenance mode on or off.
+func (b *Backoff) SetMaintenance(on bool) {
+	b.maintenance.Store(on)
+}
+
+// Maintenance reports whether maintenance mode is on.
+func (b *Backoff) Maintenance() bool {
+	return b.maintenance.Load()
+}
+
+// unavailable returns an HTTP 503 error with a Retry-After header.
+func (b *Backoff) unavailable(err error) error {
+	statusErr := service.NewHTTPStatusError(http.StatusServiceUnavailable, err)
+	statusErr.Header = http.Header{
+		"Retry-After": []string{strconv.Itoa(int(b.retryAfter.Seconds()))},
+	}
+	return statusErr
+}
+
+// CommandAndReportResults calls the next service unless in maintenance
+// mode and signals the device to back off on errors.
+func (b *Backoff) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	var err error
+	if b.Maintenance() {
+		err = ErrMaintenance
+	} else {
+		var cmd *mdm.Command
+		cmd, err = b.CheckinAndCommandService.CommandAndReportResults(r, results)
+		if err == nil {
+			return cmd, nil
+		}
+		var statusErr *service.HTTPStatusError
+		if errors.As(err, &statusErr) {
+			// already has an HTTP status: pass it through
+			return nil, err
+		}
+	}
+	logger := ctxlog.Logger(r.Context, b.logger)
+	if b.emptyIdle && results.Status == "Idle" {
+		logger.Info("msg", "empty response for idle command poll", "err", err)
+		return nil, nil
+	}
+	logger.Info("msg", "backoff command poll", "retry_after", b.retryAfter.String(), "err", err)
+	return nil, b.unavailable(err)
+}
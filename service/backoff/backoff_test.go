@@ -0,0 +1,71 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+)
+
+type testService struct {
+	service.CheckinAndCommandService
+	err error
+}
+
+func (s *testService) CommandAndReportResults(_ *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &mdm.Command{CommandUUID: "test"}, nil
+}
+
+func TestBackoff(t *testing.T) {
+	next := &testService{}
+	b := New(next)
+	r := &mdm.Request{Context: context.Background()}
+	idle := &mdm.CommandResults{Status: "Idle"}
+
+	cmd, err := b.CommandAndReportResults(r, idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != "test" {
+		t.Fatal("expected command from next service")
+	}
+
+	next.err = errors.New("storage overloaded")
+	_, err = b.CommandAndReportResults(r, idle)
+	var statusErr *service.HTTPStatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected HTTP status error, got: %v", err)
+	}
+	if have, want := statusErr.Status, http.StatusServiceUnavailable; have != want {
+		t.Errorf("status: have %d, want %d", have, want)
+	}
+	if have, want := statusErr.Header.Get("Retry-After"), "300"; have != want {
+		t.Errorf("Retry-After: have %q, want %q", have, want)
+	}
+
+	// errors with an HTTP status pass through as-is
+	next.err = service.NewHTTPStatusError(http.StatusBadRequest, errors.New("bad"))
+	_, err = b.CommandAndReportResults(r, idle)
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusBadRequest {
+		t.Errorf("expected HTTP 400 error, got: %v", err)
+	}
+
+	// maintenance mode with empty idle responses
+	next.err = nil
+	b = New(next, WithEmptyIdle())
+	b.SetMaintenance(true)
+	cmd, err = b.CommandAndReportResults(r, idle)
+	if err != nil || cmd != nil {
+		t.Errorf("expected empty response for idle poll: cmd=%v err=%v", cmd, err)
+	}
+	_, err = b.CommandAndReportResults(r, &mdm.CommandResults{Status: "Acknowledged"})
+	if !errors.Is(err, ErrMaintenance) {
+		t.Errorf("expected maintenance error, got: %v", err)
+	}
+}
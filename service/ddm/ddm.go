@@ -0,0 +1,185 @@
+// Package ddm serves Declarative Management declarations from storage.
+package ddm
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// declarationKinds maps declaration type prefixes to declaration kinds.
+var declarationKinds = []struct{ prefix, kind string }{
+	{"com.apple.activation.", "activation"},
+	{"com.apple.asset.", "asset"},
+	{"com.apple.configuration.", "configuration"},
+	{"com.apple.management.", "management"},
+}
+
+// Kind returns the declaration kind (e.g. "configuration") of the
+// declaration type declarationType or an empty string if unknown.
+func Kind(declarationType string) string {
+	for _, k := range declarationKinds {
+		if strings.HasPrefix(declarationType, k.prefix) {
+			return k.kind
+		}
+	}
+	return ""
+}
+
+// ServerToken computes a server token for the declaration from its
+// type and payload. The token changes whenever either changes.
+func ServerToken(declaration *storage.Declaration) string {
+	h := sha256.New()
+	h.Write([]byte(declaration.Type))
+	h.Write([]byte{0})
+	h.Write(declaration.Payload)
+	return fmt.Sprintf("%x", h.Sum(nil))[:16]
+}
+
+// DeclarationItem is a declaration in the declaration-items response.
+type DeclarationItem struct {
+	Identifier  string
+	ServerToken string
+}
+
+// DeclarationItems is the declaration-items response.
+// See https://developer.apple.com/documentation/devicemanagement/declarationitemsresponse
+type DeclarationItems struct {
+	Declarations struct {
+		Activations    []DeclarationItem
+		Assets         []DeclarationItem
+		Configurations []DeclarationItem
+		Management     []DeclarationItem
+	}
+	DeclarationsToken string
+}
+
+// Service implements the Declarative Management protocol by serving the
+// declarations of the declaration sets assigned to enrollments.
+type Service struct {
+	store  storage.DeclarationStore
+	logger log.Logger
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// New creates a new Declarative Management service backed by store.
+func New(store storage.DeclarationStore, opts ...Option) *Service {
+	s := &Service{store: store, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// declarationsToken computes the declarations sync token of declarations.
+// The token changes whenever the set of declarations or any of their
+// server tokens change.
+func declarationsToken(declarations []*storage.Declaration) string {
+	h := sha256.New()
+	for _, d := range declarations {
+		h.Write([]byte(d.Identifier))
+		h.Write([]byte{0})
+		h.Write([]byte(d.ServerToken))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// tokens returns the sync tokens of declarations. The timestamp is the
+// time of the latest declaration update.
+func tokens(declarations []*storage.Declaration) *mdm.TokensResponse {
+	var latest time.Time
+	for _, d := range declarations {
+		if d.UpdatedAt.After(latest) {
+			latest = d.UpdatedAt
+		}
+	}
+	if latest.IsZero() {
+		latest = time.Unix(0, 0)
+	}
+	return &mdm.TokensResponse{SyncTokens: mdm.SyncTokens{
+		DeclarationsToken: declarationsToken(declarations),
+		Timestamp:         latest.UTC().Format(time.RFC3339),
+	}}
+}
+
+// declarationItems returns the declaration-items of declarations.
+// Declarations of unknown kinds are skipped.
+func declarationItems(declarations []*storage.Declaration) *DeclarationItems {
+	items := new(DeclarationItems)
+	items.Declarations.Activations = []DeclarationItem{}
+	items.Declarations.Assets = []DeclarationItem{}
+	items.Declarations.Configurations = []DeclarationItem{}
+	items.Declarations.Management = []DeclarationItem{}
+	for _, d := range declarations {
+		item := DeclarationItem{Identifier: d.Identifier, ServerToken: d.ServerToken}
+		switch Kind(d.Type) {
+		case "activation":
+			items.Declarations.Activations = append(items.Declarations.Activations, item)
+		case "asset":
+			items.Declarations.Assets = append(items.Declarations.Assets, item)
+		case "configuration":
+			items.Declarations.Configurations = append(items.Declarations.Configurations, item)
+		case "management":
+			items.Declarations.Management = append(items.Declarations.Management, item)
+		}
+	}
+	items.DeclarationsToken = declarationsToken(declarations)
+	return items
+}
+
+// DeclarativeManagement serves the "tokens", "declaration-items", and
+// "declaration/<kind>/<identifier>" endpoints from the declarations
+// assigned to the enrollment. Status reports are acknowledged.
+func (s *Service) DeclarativeManagement(r *mdm.Request, message *mdm.DeclarativeManagement) ([]byte, error) {
+	endpoint := strings.Trim(message.Endpoint, "/")
+	if endpoint == "status" {
+		return nil, nil
+	}
+	declarations, err := s.store.RetrieveEnrollmentDeclarations(r.Context, r.ID)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving declarations: %w", err)
+	}
+	sort.Slice(declarations, func(i, j int) bool { return declarations[i].Identifier < declarations[j].Identifier })
+	switch {
+	case endpoint == "tokens":
+		return json.Marshal(tokens(declarations))
+	case endpoint == "declaration-items":
+		return json.Marshal(declarationItems(declarations))
+	case strings.HasPrefix(endpoint, "declaration/"):
+		kind, identifier, _ := strings.Cut(strings.TrimPrefix(endpoint, "declaration/"), "/")
+		for _, d := range declarations {
+			if d.Identifier == identifier && Kind(d.Type) == kind {
+				return json.Marshal(d)
+			}
+		}
+		ctxlog.Logger(r.Context, s.logger).Info(
+			"msg", "declaration not found",
+			"kind", kind,
+			"identifier", identifier,
+		)
+		return nil, service.NewHTTPStatusError(http.StatusNotFound, errors.New("declaration not found"))
+	}
+	return nil, service.NewHTTPStatusError(http.StatusNotFound, fmt.Errorf("unknown endpoint: %s", endpoint))
+}
@@ -0,0 +1,126 @@
+package ddm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestDeclarativeManagement(t *testing.T) {
+	declarations := []*storage.Declaration{
+		{
+			Identifier:  "test.passcode",
+			Type:        "com.apple.configuration.passcode.settings",
+			Payload:     []byte(`{"RequirePasscode":true}`),
+			ServerToken: "p1",
+			UpdatedAt:   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Identifier:  "test.activation",
+			Type:        "com.apple.activation.simple",
+			Payload:     []byte(`{"StandardConfigurations":["test.passcode"]}`),
+			ServerToken: "a1",
+			UpdatedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	store := &mock.Storage{
+		RetrieveEnrollmentDeclarationsFunc: func(_ context.Context, id string) ([]*storage.Declaration, error) {
+			if id != "<test>" {
+				return nil, nil
+			}
+			return declarations, nil
+		},
+	}
+	s := New(store)
+	r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "<test>"}}
+
+	body, err := s.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "tokens"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := new(mdm.TokensResponse)
+	if err = json.Unmarshal(body, tokens); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := tokens.SyncTokens.Timestamp, "2024-01-02T00:00:00Z"; have != want {
+		t.Errorf("timestamp: have %q, want %q", have, want)
+	}
+	token := tokens.SyncTokens.DeclarationsToken
+	if token == "" {
+		t.Error("empty declarations token")
+	}
+
+	body, err = s.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "declaration-items"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := new(DeclarationItems)
+	if err = json.Unmarshal(body, items); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := items.DeclarationsToken, token; have != want {
+		t.Errorf("items token: have %q, want %q", have, want)
+	}
+	if len(items.Declarations.Activations) != 1 || items.Declarations.Activations[0].Identifier != "test.activation" {
+		t.Errorf("unexpected activations: %+v", items.Declarations.Activations)
+	}
+	if len(items.Declarations.Configurations) != 1 || items.Declarations.Configurations[0].ServerToken != "p1" {
+		t.Errorf("unexpected configurations: %+v", items.Declarations.Configurations)
+	}
+
+	body, err = s.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "declaration/configuration/test.passcode"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := new(storage.Declaration)
+	if err = json.Unmarshal(body, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Identifier != "test.passcode" || d.Type != "com.apple.configuration.passcode.settings" || string(d.Payload) != `{"RequirePasscode":true}` {
+		t.Errorf("unexpected declaration: %s", body)
+	}
+
+	// wrong kind and unassigned declarations are not found
+	for _, endpoint := range []string{"declaration/activation/test.passcode", "declaration/configuration/test.other", "unknown"} {
+		_, err = s.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: endpoint})
+		var httpErr *service.HTTPStatusError
+		if !errors.As(err, &httpErr) || httpErr.Status != http.StatusNotFound {
+			t.Errorf("%s: expected not found, have %v", endpoint, err)
+		}
+	}
+
+	// a changed server token changes the declarations token
+	declarations[0].ServerToken = "p2"
+	body, err = s.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "tokens"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(body, tokens); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.SyncTokens.DeclarationsToken == token {
+		t.Error("declarations token not changed")
+	}
+}
+
+func TestKind(t *testing.T) {
+	for declarationType, kind := range map[string]string{
+		"com.apple.configuration.passcode.settings": "configuration",
+		"com.apple.activation.simple":               "activation",
+		"com.apple.asset.credential.userpassword":   "asset",
+		"com.apple.management.server-capabilities":  "management",
+		"com.example.unknown":                       "",
+	} {
+		if have, want := Kind(declarationType), kind; have != want {
+			t.Errorf("%s: have %q, want %q", declarationType, have, want)
+		}
+	}
+}
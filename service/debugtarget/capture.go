@@ -0,0 +1,90 @@
+package debugtarget
+
+import (
+	"encoding/base64"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Capture is a service middleware that logs the raw (base64 encoded)
+// check-in messages, command results, and responses of targeted
+// enrollments as debug messages. Bootstrap token messages and GetToken
+// responses are not captured as they contain secrets.
+type Capture struct {
+	service.CheckinAndCommandService
+	targets *Targets
+	logger  log.Logger
+}
+
+// NewCapture creates a new raw capture service middleware.
+func NewCapture(next service.CheckinAndCommandService, targets *Targets, logger log.Logger) *Capture {
+	return &Capture{
+		CheckinAndCommandService: next,
+		targets:                  targets,
+		logger:                   logger,
+	}
+}
+
+// capture logs the raw request and response of r if it is targeted.
+// It is called after the next service so that the enrollment ID of r
+// has been resolved.
+func (c *Capture) capture(r *mdm.Request, msg string, raw, resp []byte) {
+	if r.EnrollID == nil || !c.targets.Enabled(r.ID) {
+		return
+	}
+	logs := []interface{}{"msg", msg, "raw", base64.StdEncoding.EncodeToString(raw)}
+	if len(resp) > 0 {
+		logs = append(logs, "response", base64.StdEncoding.EncodeToString(resp))
+	}
+	ctxlog.Logger(r.Context, c.logger).Debug(logs...)
+}
+
+func (c *Capture) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	err := c.CheckinAndCommandService.Authenticate(r, m)
+	c.capture(r, "captured Authenticate", m.Raw, nil)
+	return err
+}
+
+func (c *Capture) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	err := c.CheckinAndCommandService.TokenUpdate(r, m)
+	c.capture(r, "captured TokenUpdate", m.Raw, nil)
+	return err
+}
+
+func (c *Capture) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	err := c.CheckinAndCommandService.CheckOut(r, m)
+	c.capture(r, "captured CheckOut", m.Raw, nil)
+	return err
+}
+
+func (c *Capture) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	resp, err := c.CheckinAndCommandService.UserAuthenticate(r, m)
+	c.capture(r, "captured UserAuthenticate", m.Raw, resp)
+	return resp, err
+}
+
+func (c *Capture) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	resp, err := c.CheckinAndCommandService.DeclarativeManagement(r, m)
+	c.capture(r, "captured DeclarativeManagement", m.Raw, resp)
+	return resp, err
+}
+
+func (c *Capture) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	token, err := c.CheckinAndCommandService.GetToken(r, m)
+	c.capture(r, "captured GetToken", m.Raw, nil)
+	return token, err
+}
+
+func (c *Capture) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := c.CheckinAndCommandService.CommandAndReportResults(r, results)
+	var resp []byte
+	if cmd != nil {
+		resp = cmd.Raw
+	}
+	c.capture(r, "captured command report", results.Raw, resp)
+	return cmd, err
+}
@@ -0,0 +1,112 @@
+// Package debugtarget enables debug logging and raw message capture for
+// individual enrollments for a limited time.
+//
+// This allows diagnosing production issues of a single device without
+// turning on debug logging globally. Debug logging is filtered by the
+// enrollment ID key-value pair that is added to loggers from the
+// request context (see ctxlog).
+package debugtarget
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultMaxTargets is the default maximum number of enrollments that
+// can be targeted at the same time.
+const DefaultMaxTargets = 10
+
+// ErrTooManyTargets is returned when enabling a target would exceed the
+// maximum number of targets.
+var ErrTooManyTargets = errors.New("too many debug targets")
+
+// Targets are the enrollments targeted for debugging and their expiry.
+type Targets struct {
+	mu      sync.RWMutex
+	expires map[string]time.Time
+
+	maxDuration time.Duration
+	maxTargets  int
+}
+
+// Option configures Targets.
+type Option func(*Targets)
+
+// WithMaxTargets sets the maximum number of enrollments that can be
+// targeted at the same time.
+func WithMaxTargets(n int) Option {
+	return func(t *Targets) {
+		t.maxTargets = n
+	}
+}
+
+// NewTargets creates new debug Targets. Enrollments can be targeted for
+// at most maxDuration.
+func NewTargets(maxDuration time.Duration, opts ...Option) *Targets {
+	t := &Targets{
+		expires:     make(map[string]time.Time),
+		maxDuration: maxDuration,
+		maxTargets:  DefaultMaxTargets,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// prune removes expired targets. The write lock must be held.
+func (t *Targets) prune(now time.Time) {
+	for id, expires := range t.expires {
+		if !now.Before(expires) {
+			delete(t.expires, id)
+		}
+	}
+}
+
+// Enable targets id for d (or the maximum duration if d is zero or
+// longer) and returns when the target expires. Enabling an already
+// targeted enrollment extends or shortens its target.
+func (t *Targets) Enable(id string, d time.Duration) (time.Time, error) {
+	if d <= 0 || d > t.maxDuration {
+		d = t.maxDuration
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(now)
+	if _, ok := t.expires[id]; !ok && len(t.expires) >= t.maxTargets {
+		return time.Time{}, ErrTooManyTargets
+	}
+	expires := now.Add(d)
+	t.expires[id] = expires
+	return expires, nil
+}
+
+// Disable stops targeting id.
+func (t *Targets) Disable(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.expires, id)
+}
+
+// Enabled reports whether id is targeted.
+func (t *Targets) Enabled(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	expires, ok := t.expires[id]
+	return ok && time.Now().Before(expires)
+}
+
+// Targets returns the expiry of the targeted enrollments keyed by
+// enrollment ID.
+func (t *Targets) Targets() map[string]time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prune(time.Now())
+	ret := make(map[string]time.Time, len(t.expires))
+	for id, expires := range t.expires {
+		ret[id] = expires
+	}
+	return ret
+}
@@ -0,0 +1,87 @@
+package debugtarget
+
+import (
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+// countLogger counts the debug messages of it and its nested loggers.
+type countLogger struct {
+	debugs *int
+}
+
+func (l *countLogger) Info(...interface{}) {}
+
+func (l *countLogger) Debug(...interface{}) { *l.debugs++ }
+
+func (l *countLogger) With(...interface{}) log.Logger { return l }
+
+func TestTargets(t *testing.T) {
+	targets := NewTargets(time.Hour, WithMaxTargets(2))
+
+	expires, err := targets.Enable("ID1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the maximum duration is used
+	if d := time.Until(expires); d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("expiry: %s", d)
+	}
+	if _, err = targets.Enable("ID2", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = targets.Enable("ID3", time.Minute); err != ErrTooManyTargets {
+		t.Errorf("have %v, want %v", err, ErrTooManyTargets)
+	}
+	// already targeted enrollments can be extended
+	if _, err = targets.Enable("ID2", 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !targets.Enabled("ID1") || targets.Enabled("ID3") {
+		t.Error("unexpected enabled targets")
+	}
+
+	// expired targets are removed
+	if _, err = targets.Enable("ID1", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if targets.Enabled("ID1") {
+		t.Error("expected expired target")
+	}
+	if all := targets.Targets(); len(all) != 1 || all["ID2"].IsZero() {
+		t.Errorf("targets: %v", all)
+	}
+
+	targets.Disable("ID2")
+	if targets.Enabled("ID2") {
+		t.Error("expected disabled target")
+	}
+}
+
+func TestLogger(t *testing.T) {
+	targets := NewTargets(time.Hour)
+	if _, err := targets.Enable("ID1", 0); err != nil {
+		t.Fatal(err)
+	}
+	var debugs int
+	logger := NewLogger(&countLogger{debugs: &debugs}, targets, false)
+
+	logger.Debug("msg", "no enrollment")
+	logger.With("id", "ID2", "type", "Device").Debug("msg", "not targeted")
+	if debugs != 0 {
+		t.Errorf("debug messages: have %d, want 0", debugs)
+	}
+	logger.With("handler", "test").With("id", "ID1").Debug("msg", "targeted")
+	if debugs != 1 {
+		t.Errorf("debug messages: have %d, want 1", debugs)
+	}
+
+	// global debug logs everything
+	NewLogger(&countLogger{debugs: &debugs}, targets, true).Debug("msg", "global")
+	if debugs != 2 {
+		t.Errorf("debug messages: have %d, want 2", debugs)
+	}
+}
@@ -0,0 +1,57 @@
+package debugtarget
+
+import (
+	"github.com/micromdm/nanolib/log"
+)
+
+// IDKey is the logger key of enrollment IDs.
+const IDKey = "id"
+
+// Logger is a log.Logger that only logs debug messages globally or
+// for targeted enrollments. The enrollment of a logger is the value of
+// the IDKey key-value pair given to With, such as from ctxlog.Logger
+// with the request context of an enrollment.
+type Logger struct {
+	logger  log.Logger
+	targets *Targets
+	debug   bool
+	id      string
+}
+
+// NewLogger creates a new targeted debug logger. The wrapped logger
+// must log debug messages as it is filtered by this logger. Debug
+// messages are always logged if debug is true.
+func NewLogger(logger log.Logger, targets *Targets, debug bool) *Logger {
+	return &Logger{
+		logger:  logger,
+		targets: targets,
+		debug:   debug,
+	}
+}
+
+// Info logs using the info level.
+func (l *Logger) Info(args ...interface{}) {
+	l.logger.Info(args...)
+}
+
+// Debug logs using the debug level if debug logging is enabled or if
+// the enrollment of l is targeted.
+func (l *Logger) Debug(args ...interface{}) {
+	if l.debug || (l.id != "" && l.targets.Enabled(l.id)) {
+		l.logger.Debug(args...)
+	}
+}
+
+// With returns a new nested Logger.
+func (l *Logger) With(args ...interface{}) log.Logger {
+	l2 := *l
+	l2.logger = l.logger.With(args...)
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); ok && k == IDKey {
+			if id, ok := args[i+1].(string); ok {
+				l2.id = id
+			}
+		}
+	}
+	return &l2
+}
@@ -0,0 +1,328 @@
+// Package idle enqueues commands to devices when they report Idle with
+// an empty command queue.
+//
+// Hooks enqueue periodic "hygiene" commands (e.g. DeviceInformation for
+// recurring inventory) at most once per interval per device without
+// an external scheduler. The time a hook last fired for a device is
+// kept in memory: after a restart hooks fire again at the next Idle.
+package idle
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Hook enqueues commands to devices that report Idle.
+type Hook struct {
+	Name string `json:"name"`
+
+	// Interval is the minimum duration (e.g. "24h") between firings of
+	// the hook for a device.
+	Interval string `json:"interval"`
+
+	// Commands are the MDM command dictionaries (each with a
+	// RequestType) to enqueue. Each enqueued command gets a new
+	// CommandUUID.
+	Commands []map[string]interface{} `json:"commands"`
+
+	// Groups and Tags limit the hook to enrollments with any of these
+	// enrollment metadata groups or tags.
+	Groups []string `json:"groups,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+
+	interval time.Duration
+}
+
+// init parses and validates h.
+func (h *Hook) init() error {
+	if h.Name == "" {
+		return errors.New("empty name")
+	}
+	var err error
+	if h.interval, err = time.ParseDuration(h.Interval); err != nil {
+		return fmt.Errorf("interval: %w", err)
+	}
+	if h.interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	if len(h.Commands) < 1 {
+		return errors.New("no commands")
+	}
+	for i, cmd := range h.Commands {
+		if requestType, _ := cmd["RequestType"].(string); requestType == "" {
+			return fmt.Errorf("command %d: missing RequestType", i)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the hook applies to an enrollment with meta.
+func (h *Hook) matches(meta *storage.EnrollmentMetadata) bool {
+	if len(h.Groups) < 1 && len(h.Tags) < 1 {
+		return true
+	}
+	if meta == nil {
+		return false
+	}
+	return anyOf(h.Groups, meta.Groups) || anyOf(h.Tags, meta.Tags)
+}
+
+func anyOf(want, have []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadHooks reads a JSON list of hooks from path.
+func LoadHooks(path string) ([]*Hook, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []*Hook
+	d := json.NewDecoder(bytes.NewReader(b))
+	// keep integers as integers for the command plists
+	d.UseNumber()
+	return hooks, d.Decode(&hooks)
+}
+
+// plistValue converts JSON-decoded numbers for plist encoding.
+func plistValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = plistValue(e)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = plistValue(e)
+		}
+		return a
+	}
+	return v
+}
+
+// newCommandUUID generates a random (version 4) UUID.
+func newCommandUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newCommand creates an MDM command from the command dictionary cmd.
+func newCommand(cmd map[string]interface{}) (*mdm.Command, error) {
+	b, err := plist.MarshalIndent(map[string]interface{}{
+		"CommandUUID": newCommandUUID(),
+		"Command":     plistValue(cmd),
+	}, "\t")
+	if err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(b)
+}
+
+// Store enqueues and retrieves commands.
+type Store interface {
+	storage.CommandEnqueuer
+	RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error)
+}
+
+// Service is a service middleware that fires hooks when devices report
+// Idle with an empty command queue. The first command enqueued by the
+// hooks is returned to the device.
+//
+// The middleware should wrap the core service directly so that other
+// middleware (e.g. job tracking) sees the returned command.
+type Service struct {
+	service.CheckinAndCommandService
+	hooks  []*Hook
+	store  Store
+	meta   storage.EnrollmentMetadataStore
+	logger log.Logger
+	now    func() time.Time
+
+	mu    sync.Mutex
+	fired map[string]time.Time // hook name and enrollment ID
+
+	fires    atomic.Int64
+	enqueued atomic.Int64
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithMetadata looks up enrollment groups and tags in meta for hooks
+// that have them.
+func WithMetadata(meta storage.EnrollmentMetadataStore) Option {
+	return func(s *Service) {
+		s.meta = meta
+	}
+}
+
+// New creates a new idle hook service middleware. Hook commands are
+// enqueued to and retrieved from store.
+func New(next service.CheckinAndCommandService, store Store, hooks []*Hook, opts ...Option) (*Service, error) {
+	s := &Service{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+		now:                      time.Now,
+		fired:                    make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	names := make(map[string]bool)
+	for i, h := range hooks {
+		if err := h.init(); err != nil {
+			return nil, fmt.Errorf("hook %d (%s): %w", i, h.Name, err)
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("duplicate hook: %s", h.Name)
+		}
+		names[h.Name] = true
+		if (len(h.Groups) > 0 || len(h.Tags) > 0) && s.meta == nil {
+			return nil, fmt.Errorf("hook %s: groups or tags require a metadata store", h.Name)
+		}
+		s.hooks = append(s.hooks, h)
+	}
+	return s, nil
+}
+
+// Metrics returns the idle hook counters.
+func (s *Service) Metrics() interface{} {
+	return map[string]int64{
+		"fires":    s.fires.Load(),
+		"enqueued": s.enqueued.Load(),
+	}
+}
+
+// due returns the hooks due for id at now and marks them fired.
+func (s *Service) due(id string, now time.Time) []*Hook {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var hooks []*Hook
+	for _, h := range s.hooks {
+		key := h.Name + "\x00" + id
+		if last, ok := s.fired[key]; ok && now.Sub(last) < h.interval {
+			continue
+		}
+		s.fired[key] = now
+		hooks = append(hooks, h)
+	}
+	return hooks
+}
+
+// fire enqueues the commands of hooks due for id. It returns the number
+// of commands enqueued.
+func (s *Service) fire(ctx context.Context, id string, logger log.Logger) (int, error) {
+	hooks := s.due(id, s.now())
+	if len(hooks) < 1 {
+		return 0, nil
+	}
+	var meta *storage.EnrollmentMetadata
+	var metaLoaded bool
+	var n int
+	for _, h := range hooks {
+		if (len(h.Groups) > 0 || len(h.Tags) > 0) && !metaLoaded {
+			var err error
+			if meta, err = s.meta.RetrieveEnrollmentMetadata(ctx, id); err != nil {
+				return n, fmt.Errorf("retrieving metadata: %w", err)
+			}
+			metaLoaded = true
+		}
+		if !h.matches(meta) {
+			continue
+		}
+		s.fires.Add(1)
+		for _, c := range h.Commands {
+			cmd, err := newCommand(c)
+			if err != nil {
+				return n, fmt.Errorf("hook %s: creating command: %w", h.Name, err)
+			}
+			idErrs, err := s.store.EnqueueCommand(ctx, []string{id}, cmd)
+			if err == nil {
+				err = idErrs[id]
+			}
+			if err != nil {
+				return n, fmt.Errorf("hook %s: enqueueing command: %w", h.Name, err)
+			}
+			n++
+			s.enqueued.Add(1)
+			logger.Debug(
+				"msg", "idle hook enqueued command",
+				"hook", h.Name,
+				"command_uuid", cmd.CommandUUID,
+				"request_type", cmd.Command.RequestType,
+			)
+		}
+	}
+	return n, nil
+}
+
+// CommandAndReportResults calls the next service and fires the due
+// hooks if a device reports Idle and has no queued command. Errors
+// firing hooks are logged and the device gets the empty response.
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := s.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || cmd != nil || results.Status != "Idle" || r.EnrollID == nil {
+		return cmd, err
+	}
+	if r.Type != mdm.Device && r.Type != mdm.UserEnrollmentDevice {
+		// only device channels
+		return nil, nil
+	}
+	logger := ctxlog.Logger(r.Context, s.logger)
+	n, err := s.fire(r.Context, r.ID, logger)
+	if err != nil {
+		logger.Info("msg", "firing idle hooks", "err", err)
+	}
+	if n < 1 {
+		return nil, nil
+	}
+	cmd, err = s.store.RetrieveNextCommand(r, false)
+	if err != nil {
+		logger.Info("msg", "retrieving next command", "err", err)
+		return nil, nil
+	}
+	return cmd, nil
+}
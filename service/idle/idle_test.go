@@ -0,0 +1,105 @@
+package idle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestService(t *testing.T) {
+	queue := make(map[string][]*mdm.Command)
+	store := new(mock.Storage)
+	store.EnqueueCommandFunc = func(_ context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+		for _, id := range ids {
+			queue[id] = append(queue[id], cmd)
+		}
+		return nil, nil
+	}
+	store.RetrieveNextCommandFunc = func(r *mdm.Request, _ bool) (*mdm.Command, error) {
+		if len(queue[r.ID]) < 1 {
+			return nil, nil
+		}
+		return queue[r.ID][0], nil
+	}
+	store.RetrieveEnrollmentMetadataFunc = func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+		if id == "lab1" {
+			return &storage.EnrollmentMetadata{Groups: []string{"lab"}}, nil
+		}
+		return nil, nil
+	}
+	next := new(servicemock.Service)
+	next.CommandAndReportResultsFunc = func(r *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+		return nil, nil
+	}
+	hooks := []*Hook{
+		{Name: "inventory", Interval: "24h", Commands: []map[string]interface{}{
+			{"RequestType": "DeviceInformation", "Queries": []interface{}{"OSVersion"}},
+		}},
+		{Name: "lab", Interval: "1h", Groups: []string{"lab"}, Commands: []map[string]interface{}{
+			{"RequestType": "ProfileList"},
+		}},
+	}
+	s, err := New(next, store, hooks, WithMetadata(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	idle := func(id string, typ mdm.EnrollType, status string) *mdm.Command {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: id, Type: typ}}
+		cmd, err := s.CommandAndReportResults(r, &mdm.CommandResults{Status: status})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+
+	cmd := idle("dev1", mdm.Device, "Idle")
+	if cmd == nil || cmd.Command.RequestType != "DeviceInformation" || cmd.CommandUUID == "" {
+		t.Fatalf("expected DeviceInformation command, have %v", cmd)
+	}
+	if len(queue["dev1"]) != 1 {
+		t.Errorf("dev1: have %d queued, want 1", len(queue["dev1"]))
+	}
+	queue["dev1"] = nil
+	if cmd = idle("dev1", mdm.Device, "Idle"); cmd != nil {
+		t.Error("expected no command within interval")
+	}
+	if cmd = idle("dev2", mdm.Device, "Acknowledged"); cmd != nil {
+		t.Error("expected no command for non-Idle status")
+	}
+	if cmd = idle("dev2:user", mdm.User, "Idle"); cmd != nil {
+		t.Error("expected no command for user channel")
+	}
+	idle("lab1", mdm.Device, "Idle")
+	if len(queue["lab1"]) != 2 {
+		t.Errorf("lab1: have %d queued, want 2", len(queue["lab1"]))
+	}
+
+	now = now.Add(25 * time.Hour)
+	if cmd = idle("dev1", mdm.Device, "Idle"); cmd == nil {
+		t.Error("expected command after interval")
+	}
+	if have := s.Metrics().(map[string]int64)["enqueued"]; have != 4 {
+		t.Errorf("enqueued: have %d, want 4", have)
+	}
+
+	for _, h := range []*Hook{
+		{Name: "", Interval: "1h", Commands: hooks[0].Commands},
+		{Name: "a", Interval: "bogus", Commands: hooks[0].Commands},
+		{Name: "a", Interval: "1h"},
+		{Name: "a", Interval: "1h", Commands: []map[string]interface{}{{"Queries": []interface{}{}}}},
+		{Name: "a", Interval: "1h", Commands: hooks[0].Commands, Tags: []string{"x"}},
+	} {
+		if _, err = New(next, store, []*Hook{h}); err == nil {
+			t.Errorf("expected error for hook %+v", h)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package instrument records check-in and command result metrics.
+package instrument
+
+import (
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/metrics"
+	"github.com/micromdm/nanomdm/service"
+)
+
+// statuses are the command result statuses recorded as-is. Others are
+// recorded as "other" to bound the number of series.
+var statuses = map[string]bool{
+	"Idle":               true,
+	"Acknowledged":       true,
+	"Error":              true,
+	"CommandFormatError": true,
+	"NotNow":             true,
+}
+
+// Service is a service middleware that counts check-in messages and
+// command results.
+type Service struct {
+	service.CheckinAndCommandService
+	rec metrics.Recorder
+}
+
+// New creates a new metrics recording service middleware.
+func New(next service.CheckinAndCommandService, rec metrics.Recorder) *Service {
+	return &Service{CheckinAndCommandService: next, rec: rec}
+}
+
+func (s *Service) checkin(messageType string) {
+	s.rec.Add(metrics.Checkins, 1, "type", messageType)
+}
+
+func (s *Service) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	s.checkin("Authenticate")
+	return s.CheckinAndCommandService.Authenticate(r, m)
+}
+
+func (s *Service) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	s.checkin("TokenUpdate")
+	return s.CheckinAndCommandService.TokenUpdate(r, m)
+}
+
+func (s *Service) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	s.checkin("CheckOut")
+	return s.CheckinAndCommandService.CheckOut(r, m)
+}
+
+func (s *Service) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	s.checkin("UserAuthenticate")
+	return s.CheckinAndCommandService.UserAuthenticate(r, m)
+}
+
+func (s *Service) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	s.checkin("SetBootstrapToken")
+	return s.CheckinAndCommandService.SetBootstrapToken(r, m)
+}
+
+func (s *Service) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	s.checkin("GetBootstrapToken")
+	return s.CheckinAndCommandService.GetBootstrapToken(r, m)
+}
+
+func (s *Service) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	s.checkin("DeclarativeManagement")
+	return s.CheckinAndCommandService.DeclarativeManagement(r, m)
+}
+
+func (s *Service) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	s.checkin("GetToken")
+	return s.CheckinAndCommandService.GetToken(r, m)
+}
+
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	status := results.Status
+	if !statuses[status] {
+		status = "other"
+	}
+	s.rec.Add(metrics.CommandResults, 1, "status", status)
+	return s.CheckinAndCommandService.CommandAndReportResults(r, results)
+}
@@ -0,0 +1,42 @@
+package instrument
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service/mock"
+)
+
+type recorder map[string]float64
+
+func (r recorder) Add(name string, value float64, labels ...string) {
+	r[name+"{"+strings.Join(labels, ",")+"}"] += value
+}
+
+func (r recorder) Observe(string, float64, ...string) {}
+
+func TestService(t *testing.T) {
+	rec := make(recorder)
+	s := New(new(mock.Service), rec)
+	r := new(mdm.Request)
+	s.Authenticate(r, new(mdm.Authenticate))
+	s.TokenUpdate(r, new(mdm.TokenUpdate))
+	s.TokenUpdate(r, new(mdm.TokenUpdate))
+	s.CommandAndReportResults(r, &mdm.CommandResults{Status: "Acknowledged"})
+	s.CommandAndReportResults(r, &mdm.CommandResults{Status: "Bogus"})
+
+	for name, want := range map[string]float64{
+		"nanomdm_checkins_total{type,Authenticate}":          1,
+		"nanomdm_checkins_total{type,TokenUpdate}":           2,
+		"nanomdm_command_results_total{status,Acknowledged}": 1,
+		"nanomdm_command_results_total{status,other}":        1,
+	} {
+		if have := rec[name]; have != want {
+			t.Errorf("%s: have %v, want %v", name, have, want)
+		}
+	}
+	if len(rec) != 4 {
+		t.Errorf("unexpected series: %v", rec)
+	}
+}
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+type ctxKeyMetadata struct{}
+
+// NewContextWithMetadata returns a new context with the enrollment metadata.
+func NewContextWithMetadata(ctx context.Context, meta *storage.EnrollmentMetadata) context.Context {
+	return context.WithValue(ctx, ctxKeyMetadata{}, meta)
+}
+
+// MetadataFromContext retrieves the enrollment metadata from ctx.
+// Nil is returned if no metadata was loaded for the request.
+func MetadataFromContext(ctx context.Context) *storage.EnrollmentMetadata {
+	meta, _ := ctx.Value(ctxKeyMetadata{}).(*storage.EnrollmentMetadata)
+	return meta
+}
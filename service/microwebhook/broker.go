@@ -0,0 +1,55 @@
+package microwebhook
+
+import (
+	"sync"
+)
+
+// Broker distributes webhook events to subscribers in-process.
+// Events are dropped for subscribers that are not keeping up.
+type Broker struct {
+	mu     sync.RWMutex
+	subs   map[chan *Event]struct{}
+	buffer int
+}
+
+// NewBroker creates a new event broker. Each subscriber channel will
+// buffer up to buffer events.
+func NewBroker(buffer int) *Broker {
+	return &Broker{
+		subs:   make(map[chan *Event]struct{}),
+		buffer: buffer,
+	}
+}
+
+// Subscribe returns a channel of published events and a function to
+// unsubscribe (which closes the channel).
+func (b *Broker) Subscribe() (<-chan *Event, func()) {
+	c := make(chan *Event, b.buffer)
+	b.mu.Lock()
+	b.subs[c] = struct{}{}
+	b.mu.Unlock()
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, c)
+			b.mu.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Publish sends ev to all subscribers without blocking. The number of
+// subscribers that the event was dropped for is returned.
+func (b *Broker) Publish(ev *Event) (dropped int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for c := range b.subs {
+		select {
+		case c <- ev:
+		default:
+			dropped++
+		}
+	}
+	return
+}
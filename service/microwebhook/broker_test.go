@@ -0,0 +1,38 @@
+package microwebhook
+
+import (
+	"testing"
+)
+
+func TestBroker(t *testing.T) {
+	b := NewBroker(1)
+	c1, unsub1 := b.Subscribe()
+	c2, unsub2 := b.Subscribe()
+	defer unsub2()
+
+	if dropped := b.Publish(&Event{Topic: "mdm.Authenticate"}); dropped != 0 {
+		t.Errorf("dropped: have %d, want 0", dropped)
+	}
+	// second event should be dropped for both full subscribers
+	if dropped := b.Publish(&Event{Topic: "mdm.TokenUpdate"}); dropped != 2 {
+		t.Errorf("dropped: have %d, want 2", dropped)
+	}
+	if ev := <-c1; ev.Topic != "mdm.Authenticate" {
+		t.Errorf("topic: have %q, want %q", ev.Topic, "mdm.Authenticate")
+	}
+	if ev := <-c2; ev.Topic != "mdm.Authenticate" {
+		t.Errorf("topic: have %q, want %q", ev.Topic, "mdm.Authenticate")
+	}
+
+	unsub1()
+	unsub1() // should be safe to call twice
+	if _, ok := <-c1; ok {
+		t.Error("expected closed channel")
+	}
+	if dropped := b.Publish(&Event{Topic: "mdm.CheckOut"}); dropped != 0 {
+		t.Errorf("dropped: have %d, want 0", dropped)
+	}
+	if ev := <-c2; ev.Topic != "mdm.CheckOut" {
+		t.Errorf("topic: have %q, want %q", ev.Topic, "mdm.CheckOut")
+	}
+}
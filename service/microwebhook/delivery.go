@@ -0,0 +1,141 @@
+package microwebhook
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Exponential backoff of webhook delivery retries.
+const (
+	RetryBackoff    = 30 * time.Second
+	MaxRetryBackoff = 2 * time.Hour
+)
+
+// retryBatchSize is the maximum number of due deliveries retried at a time.
+const retryBatchSize = 100
+
+// WithDeliveryStore persists the events that fail to be delivered to
+// the webhook URL to store to be retried by RetryDeliveries with an
+// exponential backoff. Deliveries that fail attempts times (including
+// the first attempt) are dead-lettered: they are kept in store but no
+// longer retried until they are replayed.
+func WithDeliveryStore(store storage.WebhookDeliveryStore, attempts int) Option {
+	return func(w *MicroWebhook) {
+		w.deliveries = store
+		w.attempts = attempts
+	}
+}
+
+// retryBackoff returns the delay before the next attempt of a delivery
+// that has failed attempts times.
+func retryBackoff(attempts int) time.Duration {
+	backoff := RetryBackoff
+	for i := 1; i < attempts && backoff < MaxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > MaxRetryBackoff {
+		backoff = MaxRetryBackoff
+	}
+	return backoff
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// failed records the failed delivery attempt postErr of d and
+// dead-letters d if it is out of attempts.
+func (w *MicroWebhook) failed(d *storage.WebhookDelivery, postErr error, now time.Time) {
+	d.Attempts++
+	d.LastError = postErr.Error()
+	d.NextAttemptAt = now.Add(retryBackoff(d.Attempts))
+	d.Dead = d.Attempts >= w.attempts
+}
+
+// storeFailedDelivery persists the encoded event body of ev for
+// retrying after it failed to be delivered with postErr.
+func (w *MicroWebhook) storeFailedDelivery(ctx context.Context, ev *Event, body []byte, postErr error) error {
+	d := &storage.WebhookDelivery{
+		ID:      ev.EventID,
+		URL:     w.url,
+		Topic:   ev.Topic,
+		Version: w.version,
+		Body:    body,
+	}
+	w.failed(d, postErr, time.Now())
+	if err := w.deliveries.StoreWebhookDelivery(ctx, d); err != nil {
+		return fmt.Errorf("storing delivery: %v; after delivery error: %w", err, postErr)
+	}
+	ctxlog.Logger(ctx, w.logger).Info(
+		"msg", "webhook delivery failed",
+		"id", d.ID,
+		"topic", d.Topic,
+		"dead", d.Dead,
+		"err", postErr,
+	)
+	return nil
+}
+
+// RetryDeliveries retries delivering the persisted events whose next
+// attempt is due.
+func (w *MicroWebhook) RetryDeliveries(ctx context.Context) error {
+	if w.deliveries == nil {
+		return errors.New("no delivery store")
+	}
+	due, err := w.deliveries.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{
+		DueBy: time.Now(),
+		Limit: retryBatchSize,
+	})
+	if err != nil {
+		return fmt.Errorf("retrieving due deliveries: %w", err)
+	}
+	logger := ctxlog.Logger(ctx, w.logger)
+	for _, d := range due {
+		postErr := postWebhookBody(ctx, w.client, d.URL, d.Body, d.Version)
+		if postErr == nil {
+			logger.Debug("msg", "webhook delivery retried", "id", d.ID, "topic", d.Topic, "attempts", d.Attempts+1)
+			if err = w.deliveries.DeleteWebhookDelivery(ctx, d.ID); err != nil {
+				return fmt.Errorf("deleting delivery: %w", err)
+			}
+			continue
+		}
+		w.failed(d, postErr, time.Now())
+		logger.Info(
+			"msg", "webhook delivery retry failed",
+			"id", d.ID,
+			"topic", d.Topic,
+			"attempts", d.Attempts,
+			"dead", d.Dead,
+			"err", postErr,
+		)
+		if err = w.deliveries.StoreWebhookDelivery(ctx, d); err != nil {
+			return fmt.Errorf("storing delivery: %w", err)
+		}
+	}
+	return nil
+}
+
+// Run retries due deliveries every interval until ctx is done.
+func (w *MicroWebhook) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.RetryDeliveries(ctx); err != nil {
+				w.logger.Info("msg", "retrying webhook deliveries", "err", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package microwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		attempts int
+		backoff  time.Duration
+	}{
+		{1, RetryBackoff},
+		{2, 2 * RetryBackoff},
+		{3, 4 * RetryBackoff},
+		{100, MaxRetryBackoff},
+	} {
+		if have, want := retryBackoff(tc.attempts), tc.backoff; have != want {
+			t.Errorf("attempts %d: have %v, want %v", tc.attempts, have, want)
+		}
+	}
+}
+
+func TestRetryDeliveries(t *testing.T) {
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	fail := true
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, b)
+	}))
+	defer srv.Close()
+
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := New(srv.URL, nil, WithDeliveryStore(store, 2))
+
+	// a failed delivery is stored for retrying
+	if err = w.PushCertExpiring(ctx, &PushCertEvent{Topic: "com.apple.mgmt.test"}); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := store.RetrieveWebhookDeliveries(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 || pending[0].Topic != "nanomdm.PushCertExpiring" || pending[0].Dead {
+		t.Fatalf("unexpected pending deliveries: %+v", pending)
+	}
+	id := pending[0].ID
+
+	// not yet due
+	if err = w.RetryDeliveries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = store.RetrieveWebhookDeliveries(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].Attempts != 1 {
+		t.Fatalf("retried delivery that is not due: %+v", pending)
+	}
+
+	// out of attempts
+	pending[0].NextAttemptAt = time.Now().Add(-time.Second)
+	if err = store.StoreWebhookDelivery(ctx, pending[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RetryDeliveries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{Dead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 2 || dead[0].LastError == "" {
+		t.Fatalf("unexpected dead deliveries: %+v", dead)
+	}
+
+	// replayed and delivered
+	mu.Lock()
+	fail = false
+	mu.Unlock()
+	dead[0].Dead = false
+	dead[0].NextAttemptAt = time.Now().Add(-time.Second)
+	if err = store.StoreWebhookDelivery(ctx, dead[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.RetryDeliveries(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = store.RetrieveWebhookDeliveries(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pending), 0; have != want {
+		t.Errorf("pending deliveries: have %d, want %d", have, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if have, want := len(bodies), 1; have != want {
+		t.Fatalf("delivered: have %d, want %d", have, want)
+	}
+	ev := new(Event)
+	if err = json.Unmarshal(bodies[0], ev); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := ev.EventID, id; have != want {
+		t.Errorf("event id: have %q, want %q", have, want)
+	}
+}
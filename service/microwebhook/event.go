@@ -1,6 +1,11 @@
 package microwebhook
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
 
 type Event struct {
 	Topic     string    `json:"topic"`
@@ -9,6 +14,20 @@ type Event struct {
 
 	AcknowledgeEvent *AcknowledgeEvent `json:"acknowledge_event,omitempty"`
 	CheckinEvent     *CheckinEvent     `json:"checkin_event,omitempty"`
+	PushCertEvent    *PushCertEvent    `json:"push_cert_event,omitempty"`
+	UserSessionEvent *UserSessionEvent `json:"user_session_event,omitempty"`
+	CircuitEvent     *CircuitEvent     `json:"circuit_event,omitempty"`
+	BlockedEvent     *BlockedEvent     `json:"blocked_event,omitempty"`
+	AlertEvent       *AlertEvent       `json:"alert_event,omitempty"`
+	InventoryEvent   *InventoryEvent   `json:"inventory_event,omitempty"`
+	DuplicateEvent   *DuplicateEvent   `json:"duplicate_event,omitempty"`
+	DDMEvent         *DDMEvent         `json:"ddm_event,omitempty"`
+	StarvationEvent  *StarvationEvent  `json:"starvation_event,omitempty"`
+	TransitionEvent  *TransitionEvent  `json:"transition_event,omitempty"`
+	EvictionEvent    *EvictionEvent    `json:"eviction_event,omitempty"`
+
+	// Metadata is the enrollment metadata, if loaded for the request.
+	Metadata *storage.EnrollmentMetadata `json:"metadata,omitempty"`
 }
 
 type AcknowledgeEvent struct {
@@ -30,3 +49,125 @@ type CheckinEvent struct {
 	// is the initial enrollment vs. a following tokenupdate
 	TokenUpdateTally *int `json:"token_update_tally,omitempty"`
 }
+
+// PushCertEvent is sent when a stored APNs push certificate is near expiry.
+type PushCertEvent struct {
+	Topic         string    `json:"topic"`
+	NotAfter      time.Time `json:"not_after"`
+	DaysRemaining int       `json:"days_remaining"`
+	// ThresholdDays is the expiry warning threshold that was crossed.
+	ThresholdDays int `json:"threshold_days"`
+}
+
+// UserSessionEvent is sent when the current user session of a device
+// changes, such as a different user logging in to a Shared iPad.
+type UserSessionEvent struct {
+	DeviceID string               `json:"device_id"`
+	Current  *storage.UserSession `json:"current"`
+	Previous *storage.UserSession `json:"previous,omitempty"`
+}
+
+// CircuitEvent is sent when the storage circuit breaker changes state.
+type CircuitEvent struct {
+	State         string `json:"state"`
+	PreviousState string `json:"previous_state"`
+	// Requests and Failures are the counts of the breaker's window.
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+}
+
+// BlockedEvent is sent when a request is blocked by client IP address
+// or country.
+type BlockedEvent struct {
+	IP      string `json:"ip"`
+	Country string `json:"country,omitempty"`
+	Path    string `json:"path"`
+	Reason  string `json:"reason"`
+}
+
+// AlertEvent is sent when a fleet-wide rate-of-change anomaly is
+// detected, such as a spike in CheckOuts.
+type AlertEvent struct {
+	Type        string `json:"type"`
+	RequestType string `json:"request_type,omitempty"`
+	Count       int    `json:"count"`
+	Threshold   int    `json:"threshold"`
+	// WindowSeconds is the sliding window Count is over.
+	WindowSeconds int `json:"window_seconds"`
+}
+
+// InventoryEvent is sent when the inventory of an enrollment (from
+// DeviceInformation, SecurityInfo, or ProfileList results) changes.
+type InventoryEvent struct {
+	EnrollmentID string                     `json:"enrollment_id"`
+	Changes      []*storage.InventoryChange `json:"changes"`
+}
+
+// DuplicateEvent is sent when a device (by serial number) Authenticates
+// with a different enrollment ID than its previous enrollment.
+type DuplicateEvent struct {
+	SerialNumber string `json:"serial_number"`
+	EnrollmentID string `json:"enrollment_id"`
+	PreviousID   string `json:"previous_id"`
+	// Disabled is true if the previous enrollment was disabled.
+	Disabled bool `json:"disabled"`
+}
+
+// TransitionEvent is sent when a check-in message is rejected because
+// it is invalid for the state of its enrollment.
+type TransitionEvent struct {
+	EnrollmentID string `json:"enrollment_id"`
+	MessageType  string `json:"message_type"`
+	State        string `json:"state"`
+}
+
+// EvictionEvent is sent when an evicted enrollment first receives the
+// HTTP 410 that prompts it to unenroll.
+type EvictionEvent struct {
+	EnrollmentID string    `json:"enrollment_id"`
+	Reason       string    `json:"reason,omitempty"`
+	EvictedBy    string    `json:"evicted_by,omitempty"`
+	EvictedAt    time.Time `json:"evicted_at"`
+	DeliveredAt  time.Time `json:"delivered_at"`
+}
+
+// DeclarationStatus is the status of a declaration from a Declarative
+// Management status report.
+type DeclarationStatus struct {
+	// Kind is the declaration kind (e.g. "configurations").
+	Kind        string          `json:"kind"`
+	Identifier  string          `json:"identifier"`
+	Active      bool            `json:"active"`
+	Valid       string          `json:"valid"`
+	ServerToken string          `json:"server_token"`
+	Reasons     json.RawMessage `json:"reasons,omitempty"`
+}
+
+// DDMEvent is sent when an enrollment sends a Declarative Management
+// status report, when the status of its declarations changes, or when
+// the declarations sync token sent to it changes.
+type DDMEvent struct {
+	EnrollmentID string `json:"enrollment_id"`
+
+	// StatusReport is the raw JSON status report.
+	StatusReport json.RawMessage      `json:"status_report,omitempty"`
+	Declarations []*DeclarationStatus `json:"declarations,omitempty"`
+
+	DeclarationsToken         string `json:"declarations_token,omitempty"`
+	PreviousDeclarationsToken string `json:"previous_declarations_token,omitempty"`
+}
+
+// StarvationEvent is sent when an enrollment checks in but a command
+// queued long before has no result.
+type StarvationEvent struct {
+	EnrollmentID string    `json:"enrollment_id"`
+	CommandUUID  string    `json:"command_uuid"`
+	RequestType  string    `json:"request_type,omitempty"`
+	QueuedAt     time.Time `json:"queued_at"`
+	LastSeenAt   time.Time `json:"last_seen_at"`
+	// Pending is the number of queued commands without a result.
+	Pending int `json:"pending"`
+	// Answered is the number of commands queued after CommandUUID that
+	// have results.
+	Answered int `json:"answered"`
+}
@@ -6,23 +6,33 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 )
 
-func postWebhookEvent(
+// encodeWebhookEvent encodes event in event schema version as the JSON
+// body of a webhook request.
+func encodeWebhookEvent(event *Event, version int) ([]byte, error) {
+	encoded, err := EncodeVersion(event, version)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(encoded, "", "\t")
+}
+
+// postWebhookBody posts the encoded event body to url.
+func postWebhookBody(
 	ctx context.Context,
 	client *http.Client,
 	url string,
-	event *Event,
+	body []byte,
+	version int,
 ) error {
-	jsonBytes, err := json.MarshalIndent(event, "", "\t")
-	if err != nil {
-		return err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(jsonBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set(VersionHeader, strconv.Itoa(version))
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
@@ -2,25 +2,235 @@
 package microwebhook
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
 	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
 )
 
 type MicroWebhook struct {
 	url    string
 	client *http.Client
 	store  storage.TokenUpdateTallyStore
+	broker *Broker
+	logger log.Logger
+
+	version int
+
+	// deliveries persists events that fail delivery for retrying
+	deliveries storage.WebhookDeliveryStore
+	attempts   int
+}
+
+// Option configures a MicroWebhook.
+type Option func(*MicroWebhook)
+
+// WithClient sets the HTTP client used to deliver webhook events.
+func WithClient(client *http.Client) Option {
+	return func(w *MicroWebhook) {
+		w.client = client
+	}
+}
+
+// WithBroker publishes webhook events to broker.
+func WithBroker(broker *Broker) Option {
+	return func(w *MicroWebhook) {
+		w.broker = broker
+	}
+}
+
+// WithLogger configures a logger on the MicroWebhook.
+func WithLogger(logger log.Logger) Option {
+	return func(w *MicroWebhook) {
+		w.logger = logger
+	}
+}
+
+// WithVersion sets the event schema version of events posted to the
+// webhook URL. The default is version 1.
+func WithVersion(version int) Option {
+	return func(w *MicroWebhook) {
+		w.version = version
+	}
+}
+
+// New creates a new MicroMDM-emulating webhook service. Events are
+// HTTP POSTed to url (if not empty) and published to any broker.
+func New(url string, store storage.TokenUpdateTallyStore, opts ...Option) *MicroWebhook {
+	w := &MicroWebhook{
+		url:     url,
+		client:  http.DefaultClient,
+		store:   store,
+		logger:  log.NopLogger,
+		version: Version1,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// send publishes ev to the broker and posts it to the webhook URL.
+func (w *MicroWebhook) send(ctx context.Context, ev *Event) error {
+	if ev.Metadata == nil {
+		ev.Metadata = service.MetadataFromContext(ctx)
+	}
+	if w.broker != nil {
+		w.broker.Publish(ev)
+	}
+	if w.url == "" {
+		return nil
+	}
+	if w.deliveries != nil && ev.EventID == "" {
+		// lets the receiver recognize redelivered events
+		ev.EventID = newID()
+	}
+	body, err := encodeWebhookEvent(ev, w.version)
+	if err != nil {
+		return err
+	}
+	err = postWebhookBody(ctx, w.client, w.url, body, w.version)
+	if err != nil && w.deliveries != nil {
+		return w.storeFailedDelivery(ctx, ev, body, err)
+	}
+	return err
+}
+
+// PushCertExpiring sends a push certificate expiry warning event.
+func (w *MicroWebhook) PushCertExpiring(ctx context.Context, pce *PushCertEvent) error {
+	ev := &Event{
+		Topic:         "nanomdm.PushCertExpiring",
+		CreatedAt:     time.Now(),
+		PushCertEvent: pce,
+	}
+	return w.send(ctx, ev)
+}
+
+// UserSessionChanged sends a device user session change event.
+func (w *MicroWebhook) UserSessionChanged(ctx context.Context, use *UserSessionEvent) error {
+	ev := &Event{
+		Topic:            "nanomdm.UserSessionChanged",
+		CreatedAt:        time.Now(),
+		UserSessionEvent: use,
+	}
+	return w.send(ctx, ev)
+}
+
+// InventoryChanged sends an enrollment inventory change event.
+func (w *MicroWebhook) InventoryChanged(ctx context.Context, ie *InventoryEvent) error {
+	ev := &Event{
+		Topic:          "nanomdm.InventoryChanged",
+		CreatedAt:      time.Now(),
+		InventoryEvent: ie,
+	}
+	return w.send(ctx, ev)
 }
 
-func New(url string, store storage.TokenUpdateTallyStore) *MicroWebhook {
-	return &MicroWebhook{
-		url:    url,
-		client: http.DefaultClient,
-		store:  store,
+// StorageCircuitChanged sends a storage circuit breaker state change event.
+func (w *MicroWebhook) StorageCircuitChanged(ctx context.Context, ce *CircuitEvent) error {
+	ev := &Event{
+		Topic:        "nanomdm.StorageCircuitChanged",
+		CreatedAt:    time.Now(),
+		CircuitEvent: ce,
+	}
+	return w.send(ctx, ev)
+}
+
+// RequestBlocked sends a blocked request event.
+func (w *MicroWebhook) RequestBlocked(ctx context.Context, be *BlockedEvent) error {
+	ev := &Event{
+		Topic:        "nanomdm.RequestBlocked",
+		CreatedAt:    time.Now(),
+		BlockedEvent: be,
+	}
+	return w.send(ctx, ev)
+}
+
+// AnomalyDetected sends a rate-of-change anomaly alert event.
+func (w *MicroWebhook) AnomalyDetected(ctx context.Context, ae *AlertEvent) error {
+	ev := &Event{
+		Topic:      "nanomdm.AnomalyDetected",
+		CreatedAt:  time.Now(),
+		AlertEvent: ae,
+	}
+	return w.send(ctx, ev)
+}
+
+// EnrollmentDuplicated sends a duplicate enrollment event.
+func (w *MicroWebhook) EnrollmentDuplicated(ctx context.Context, de *DuplicateEvent) error {
+	ev := &Event{
+		Topic:          "nanomdm.EnrollmentDuplicated",
+		CreatedAt:      time.Now(),
+		DuplicateEvent: de,
+	}
+	return w.send(ctx, ev)
+}
+
+// InvalidTransition sends an invalid enrollment state transition event.
+func (w *MicroWebhook) InvalidTransition(ctx context.Context, te *TransitionEvent) error {
+	ev := &Event{
+		Topic:           "nanomdm.InvalidTransition",
+		CreatedAt:       time.Now(),
+		TransitionEvent: te,
+	}
+	return w.send(ctx, ev)
+}
+
+// EnrollmentEvicted sends an enrollment eviction delivery event.
+func (w *MicroWebhook) EnrollmentEvicted(ctx context.Context, ee *EvictionEvent) error {
+	ev := &Event{
+		Topic:         "nanomdm.EnrollmentEvicted",
+		CreatedAt:     time.Now(),
+		EvictionEvent: ee,
+	}
+	return w.send(ctx, ev)
+}
+
+// QueueStarved sends a command queue starvation event.
+func (w *MicroWebhook) QueueStarved(ctx context.Context, se *StarvationEvent) error {
+	ev := &Event{
+		Topic:           "nanomdm.QueueStarved",
+		CreatedAt:       time.Now(),
+		StarvationEvent: se,
+	}
+	return w.send(ctx, ev)
+}
+
+// DDMStatusReported sends a Declarative Management status report event.
+func (w *MicroWebhook) DDMStatusReported(ctx context.Context, de *DDMEvent) error {
+	ev := &Event{
+		Topic:     "nanomdm.DDMStatusReport",
+		CreatedAt: time.Now(),
+		DDMEvent:  de,
+	}
+	return w.send(ctx, ev)
+}
+
+// DDMDeclarationStatusChanged sends a Declarative Management declaration
+// status change event.
+func (w *MicroWebhook) DDMDeclarationStatusChanged(ctx context.Context, de *DDMEvent) error {
+	ev := &Event{
+		Topic:     "nanomdm.DDMDeclarationStatusChanged",
+		CreatedAt: time.Now(),
+		DDMEvent:  de,
+	}
+	return w.send(ctx, ev)
+}
+
+// DDMSyncTokenAdvanced sends a Declarative Management declarations sync
+// token change event.
+func (w *MicroWebhook) DDMSyncTokenAdvanced(ctx context.Context, de *DDMEvent) error {
+	ev := &Event{
+		Topic:     "nanomdm.DDMSyncTokenAdvanced",
+		CreatedAt: time.Now(),
+		DDMEvent:  de,
 	}
+	return w.send(ctx, ev)
 }
 
 func (w *MicroWebhook) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
@@ -34,7 +244,7 @@ func (w *MicroWebhook) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
 			Params:       r.Params,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
@@ -55,7 +265,7 @@ func (w *MicroWebhook) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
 		}
 		ev.CheckinEvent.TokenUpdateTally = &tally
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
@@ -69,7 +279,7 @@ func (w *MicroWebhook) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
 			Params:       r.Params,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
@@ -83,7 +293,7 @@ func (w *MicroWebhook) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate)
 			Params:       r.Params,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	return nil, w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
@@ -97,7 +307,7 @@ func (w *MicroWebhook) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToke
 			Params:       r.Params,
 		},
 	}
-	return postWebhookEvent(r.Context, w.client, w.url, ev)
+	return w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
@@ -111,7 +321,7 @@ func (w *MicroWebhook) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToke
 			Params:       r.Params,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	return nil, w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
@@ -127,7 +337,7 @@ func (w *MicroWebhook) CommandAndReportResults(r *mdm.Request, results *mdm.Comm
 			Params:       r.Params,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	return nil, w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
@@ -141,7 +351,7 @@ func (w *MicroWebhook) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeM
 			Params:       r.Params,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	return nil, w.send(r.Context, ev)
 }
 
 func (w *MicroWebhook) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
@@ -155,5 +365,5 @@ func (w *MicroWebhook) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenR
 			Params:       r.Params,
 		},
 	}
-	return nil, postWebhookEvent(r.Context, w.client, w.url, ev)
+	return nil, w.send(r.Context, ev)
 }
@@ -0,0 +1,79 @@
+package microwebhook
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/groob/plist"
+)
+
+// Webhook event schema versions.
+const (
+	// Version1 is the original MicroMDM-compatible event format.
+	Version1 = 1
+
+	// Version2 adds a version field and the decoded MDM check-in or
+	// command report plist (as the payload field) to version 1.
+	Version2 = 2
+
+	// LatestVersion is the most recent event schema version.
+	LatestVersion = Version2
+)
+
+// VersionHeader is the HTTP header containing the event schema version
+// of webhook requests.
+const VersionHeader = "X-Webhook-Version"
+
+// ParseVersion parses an event schema version such as "2" or "v2".
+// An empty string is version 1.
+func ParseVersion(s string) (int, error) {
+	if s == "" {
+		return Version1, nil
+	}
+	version, err := strconv.Atoi(strings.TrimPrefix(strings.ToLower(s), "v"))
+	if err == nil && (version < Version1 || version > LatestVersion) {
+		err = fmt.Errorf("unsupported version: %d", version)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("parsing webhook version %q: %w", s, err)
+	}
+	return version, nil
+}
+
+// eventV2 is the version 2 event format.
+type eventV2 struct {
+	Version int `json:"version"`
+	*Event
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// rawPayload returns the raw MDM plist of ev, if any.
+func (ev *Event) rawPayload() []byte {
+	if ev.AcknowledgeEvent != nil {
+		return ev.AcknowledgeEvent.RawPayload
+	}
+	if ev.CheckinEvent != nil {
+		return ev.CheckinEvent.RawPayload
+	}
+	return nil
+}
+
+// EncodeVersion returns ev in the format of event schema version,
+// suitable for JSON marshaling.
+func EncodeVersion(ev *Event, version int) (interface{}, error) {
+	switch version {
+	case Version1:
+		return ev, nil
+	case Version2:
+		v2 := &eventV2{Version: Version2, Event: ev}
+		if raw := ev.rawPayload(); len(raw) > 0 {
+			if err := plist.Unmarshal(raw, &v2.Payload); err != nil {
+				return nil, fmt.Errorf("decoding payload: %w", err)
+			}
+		}
+		return v2, nil
+	default:
+		return nil, fmt.Errorf("unsupported webhook version: %d", version)
+	}
+}
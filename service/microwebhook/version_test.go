@@ -0,0 +1,75 @@
+package microwebhook
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestEncodeVersion(t *testing.T) {
+	raw, err := os.ReadFile("../../mdm/testdata/TokenUpdate.2.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{
+		Topic:        "mdm.TokenUpdate",
+		CheckinEvent: &CheckinEvent{UDID: "66ADE930-5FDF-5EC4-8429-15640684C489", RawPayload: raw},
+	}
+
+	// version 1 must remain the unmodified event
+	v1, err := EncodeVersion(ev, Version1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 != ev {
+		t.Error("version 1 is not the original event")
+	}
+
+	v2, err := EncodeVersion(ev, Version2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(v2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Version      int                    `json:"version"`
+		Topic        string                 `json:"topic"`
+		CheckinEvent *CheckinEvent          `json:"checkin_event"`
+		Payload      map[string]interface{} `json:"payload"`
+	}
+	if err = json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Version != Version2 || decoded.Topic != ev.Topic || decoded.CheckinEvent == nil {
+		t.Errorf("unexpected version 2 event: %s", b)
+	}
+	if have, want := decoded.Payload["MessageType"], "TokenUpdate"; have != want {
+		t.Errorf("payload MessageType: have %v, want %v", have, want)
+	}
+
+	if _, err = EncodeVersion(ev, 99); err == nil {
+		t.Error("expected error for unsupported version")
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, test := range []struct {
+		in      string
+		version int
+		err     bool
+	}{
+		{"", Version1, false},
+		{"1", Version1, false},
+		{"v2", Version2, false},
+		{"0", 0, true},
+		{"3", 0, true},
+		{"two", 0, true},
+	} {
+		version, err := ParseVersion(test.in)
+		if (err != nil) != test.err || version != test.version {
+			t.Errorf("%q: have %d, %v", test.in, version, err)
+		}
+	}
+}
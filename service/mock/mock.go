@@ -0,0 +1,138 @@
+// Package mock provides a programmable mock of the NanoMDM service
+// interfaces for use in tests.
+package mock
+
+import (
+	"sync"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// Call is a recorded call of a mock method.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// calls records method calls. It is safe for concurrent use.
+type calls struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (c *calls) record(method string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order. If method is not empty
+// only calls of that method are returned.
+func (c *calls) Calls(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []Call
+	for _, call := range c.calls {
+		if method == "" || call.Method == method {
+			ret = append(ret, call)
+		}
+	}
+	return ret
+}
+
+// Reset clears the recorded calls.
+func (c *calls) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// Service is a mock of service.CheckinAndCommandService. Every method
+// call is recorded (see Calls). Responses are programmed by setting
+// the method's corresponding Func field; methods without one return
+// zero values and a nil error. The zero value is ready to use.
+type Service struct {
+	calls
+
+	AuthenticateFunc            func(*mdm.Request, *mdm.Authenticate) error
+	TokenUpdateFunc             func(*mdm.Request, *mdm.TokenUpdate) error
+	CheckOutFunc                func(*mdm.Request, *mdm.CheckOut) error
+	SetBootstrapTokenFunc       func(*mdm.Request, *mdm.SetBootstrapToken) error
+	GetBootstrapTokenFunc       func(*mdm.Request, *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error)
+	UserAuthenticateFunc        func(*mdm.Request, *mdm.UserAuthenticate) ([]byte, error)
+	DeclarativeManagementFunc   func(*mdm.Request, *mdm.DeclarativeManagement) ([]byte, error)
+	GetTokenFunc                func(*mdm.Request, *mdm.GetToken) (*mdm.GetTokenResponse, error)
+	CommandAndReportResultsFunc func(*mdm.Request, *mdm.CommandResults) (*mdm.Command, error)
+}
+
+func (s *Service) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	s.record("Authenticate", r, m)
+	if s.AuthenticateFunc != nil {
+		return s.AuthenticateFunc(r, m)
+	}
+	return nil
+}
+
+func (s *Service) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	s.record("TokenUpdate", r, m)
+	if s.TokenUpdateFunc != nil {
+		return s.TokenUpdateFunc(r, m)
+	}
+	return nil
+}
+
+func (s *Service) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	s.record("CheckOut", r, m)
+	if s.CheckOutFunc != nil {
+		return s.CheckOutFunc(r, m)
+	}
+	return nil
+}
+
+func (s *Service) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	s.record("SetBootstrapToken", r, m)
+	if s.SetBootstrapTokenFunc != nil {
+		return s.SetBootstrapTokenFunc(r, m)
+	}
+	return nil
+}
+
+func (s *Service) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	s.record("GetBootstrapToken", r, m)
+	if s.GetBootstrapTokenFunc != nil {
+		return s.GetBootstrapTokenFunc(r, m)
+	}
+	return nil, nil
+}
+
+func (s *Service) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	s.record("UserAuthenticate", r, m)
+	if s.UserAuthenticateFunc != nil {
+		return s.UserAuthenticateFunc(r, m)
+	}
+	return nil, nil
+}
+
+func (s *Service) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	s.record("DeclarativeManagement", r, m)
+	if s.DeclarativeManagementFunc != nil {
+		return s.DeclarativeManagementFunc(r, m)
+	}
+	return nil, nil
+}
+
+func (s *Service) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	s.record("GetToken", r, m)
+	if s.GetTokenFunc != nil {
+		return s.GetTokenFunc(r, m)
+	}
+	return nil, nil
+}
+
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	s.record("CommandAndReportResults", r, results)
+	if s.CommandAndReportResultsFunc != nil {
+		return s.CommandAndReportResultsFunc(r, results)
+	}
+	return nil, nil
+}
@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+)
+
+func TestService(t *testing.T) {
+	var svc service.CheckinAndCommandService = new(Service)
+	m := svc.(*Service)
+
+	m.CommandAndReportResultsFunc = func(_ *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+		return &mdm.Command{CommandUUID: "next-" + results.CommandUUID}, nil
+	}
+
+	cmd, err := svc.CommandAndReportResults(&mdm.Request{}, &mdm.CommandResults{CommandUUID: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := cmd.CommandUUID, "next-a"; have != want {
+		t.Errorf("command: have %q, want %q", have, want)
+	}
+	if err := svc.Authenticate(&mdm.Request{}, &mdm.Authenticate{}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(m.Calls("CommandAndReportResults")), 1; have != want {
+		t.Errorf("calls: have %d, want %d", have, want)
+	}
+	if have, want := m.Calls("")[1].Method, "Authenticate"; have != want {
+		t.Errorf("method: have %q, want %q", have, want)
+	}
+}
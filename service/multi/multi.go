@@ -3,6 +3,7 @@ package multi
 
 import (
 	"context"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/service"
@@ -19,7 +20,6 @@ import (
 type MultiService struct {
 	logger log.Logger
 	svcs   []service.CheckinAndCommandService
-	ctx    context.Context
 }
 
 func New(logger log.Logger, svcs ...service.CheckinAndCommandService) *MultiService {
@@ -29,7 +29,6 @@ func New(logger log.Logger, svcs ...service.CheckinAndCommandService) *MultiServ
 	return &MultiService{
 		logger: logger,
 		svcs:   svcs,
-		ctx:    context.Background(),
 	}
 }
 
@@ -49,10 +48,27 @@ func (ms *MultiService) runOthers(ctx context.Context, r errorRunner) {
 	}
 }
 
-// RequestWithContext returns a clone of r and sets its context to ctx.
+// valuesContext is a context that retains the values of its parent
+// but not its cancellation or deadline.
+type valuesContext struct {
+	context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valuesContext) Done() <-chan struct{}                   { return nil }
+func (valuesContext) Err() error                              { return nil }
+
+// RequestWithContext returns a clone of r with a context detached from
+// the cancellation of the original request. The other services run
+// asynchronously and may outlive the original request. Context values
+// (such as enrollment metadata) are retained.
 func (ms *MultiService) RequestWithContext(r *mdm.Request) *mdm.Request {
 	r2 := r.Clone()
-	r2.Context = ms.ctx
+	if r.Context == nil {
+		r2.Context = context.Background()
+	} else {
+		r2.Context = valuesContext{r.Context}
+	}
 	return r2
 }
 
@@ -0,0 +1,214 @@
+package nanomdm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DeclarationStatus is the status of a declaration from a Declarative
+// Management status report.
+// See https://developer.apple.com/documentation/devicemanagementstatus/managementdeclarationsdeclarationobject
+type DeclarationStatus struct {
+	// Kind is the declaration kind (e.g. "configurations").
+	Kind        string          `json:"kind"`
+	Identifier  string          `json:"identifier"`
+	Active      bool            `json:"active"`
+	Valid       string          `json:"valid"`
+	ServerToken string          `json:"server_token"`
+	Reasons     json.RawMessage `json:"reasons,omitempty"`
+}
+
+// DMStatusFunc is called with the raw status report and the declaration
+// statuses it contains when an enrollment sends a status report.
+// Devices only report changed status items after their first report so
+// declarations are those whose status changed.
+type DMStatusFunc func(ctx context.Context, id string, report []byte, declarations []*DeclarationStatus) error
+
+// DMTokenFunc is called when the declarations sync token sent to an
+// enrollment differs from the previous token sent to it.
+type DMTokenFunc func(ctx context.Context, id, token, previous string) error
+
+// DMTracker is a Declarative Management middleware that records, per
+// enrollment, that Declarative Management check-ins have been seen and
+// the last declarations sync token sent to the enrollment.
+type DMTracker struct {
+	next        service.DeclarativeManagement
+	store       storage.DMEnablementStore
+	statusStore storage.DDMStatusStore
+	logger      log.Logger
+	onStatus    DMStatusFunc
+	onToken     DMTokenFunc
+}
+
+// DMTrackerOption configures a DMTracker.
+type DMTrackerOption func(*DMTracker)
+
+// WithDMTrackerLogger configures a logger on the DMTracker.
+func WithDMTrackerLogger(logger log.Logger) DMTrackerOption {
+	return func(t *DMTracker) {
+		t.logger = logger
+	}
+}
+
+// WithDMStatusFunc sets the function called with status reports.
+func WithDMStatusFunc(f DMStatusFunc) DMTrackerOption {
+	return func(t *DMTracker) {
+		t.onStatus = f
+	}
+}
+
+// WithDMStatusStore stores the latest status report of enrollments
+// (and the declaration statuses it contains) in store. Reports are
+// stored regardless of which Declarative Management handler is wrapped
+// (e.g. when proxying to an external DDM server).
+func WithDMStatusStore(store storage.DDMStatusStore) DMTrackerOption {
+	return func(t *DMTracker) {
+		t.statusStore = store
+	}
+}
+
+// WithDMTokenFunc sets the function called with changed declarations
+// sync tokens.
+func WithDMTokenFunc(f DMTokenFunc) DMTrackerOption {
+	return func(t *DMTracker) {
+		t.onToken = f
+	}
+}
+
+// NewDMTracker creates a new Declarative Management tracking middleware.
+func NewDMTracker(next service.DeclarativeManagement, store storage.DMEnablementStore, opts ...DMTrackerOption) *DMTracker {
+	t := &DMTracker{
+		next:   next,
+		store:  store,
+		logger: log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// declarationsToken extracts the declarations sync token from the
+// response body of a "tokens" endpoint request.
+func declarationsToken(endpoint string, body []byte) (string, error) {
+	if strings.Trim(endpoint, "/") != "tokens" || len(body) < 1 {
+		return "", nil
+	}
+	tokens := new(mdm.TokensResponse)
+	if err := json.Unmarshal(body, tokens); err != nil {
+		return "", err
+	}
+	return tokens.SyncTokens.DeclarationsToken, nil
+}
+
+// declarationStatuses extracts the declaration statuses from the
+// status report of a "status" endpoint request.
+func declarationStatuses(report []byte) ([]*DeclarationStatus, error) {
+	var status struct {
+		StatusItems struct {
+			Management struct {
+				Declarations map[string][]struct {
+					Identifier  string          `json:"identifier"`
+					Active      bool            `json:"active"`
+					Valid       string          `json:"valid"`
+					ServerToken string          `json:"server-token"`
+					Reasons     json.RawMessage `json:"reasons"`
+				} `json:"declarations"`
+			} `json:"management"`
+		} `json:"StatusItems"`
+	}
+	if err := json.Unmarshal(report, &status); err != nil {
+		return nil, err
+	}
+	var ret []*DeclarationStatus
+	// iterate the kinds in a stable order
+	for _, kind := range []string{"activations", "configurations", "assets", "management"} {
+		for _, d := range status.StatusItems.Management.Declarations[kind] {
+			ret = append(ret, &DeclarationStatus{
+				Kind:        kind,
+				Identifier:  d.Identifier,
+				Active:      d.Active,
+				Valid:       d.Valid,
+				ServerToken: d.ServerToken,
+				Reasons:     d.Reasons,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// storeStatus stores report and its declaration statuses, if any, as
+// the latest status report of id.
+func (t *DMTracker) storeStatus(ctx context.Context, id string, report []byte, declarations []*DeclarationStatus) error {
+	var b []byte
+	if len(declarations) > 0 {
+		var err error
+		if b, err = json.Marshal(declarations); err != nil {
+			return err
+		}
+	}
+	return t.statusStore.StoreDDMStatusReport(ctx, id, report, b)
+}
+
+// DeclarativeManagement calls the next Declarative Management handler
+// and, if successful, records the enrollment's DM activity, stores any
+// status report, and calls any status report and sync token functions.
+// Errors storing the activity are logged but otherwise ignored.
+func (t *DMTracker) DeclarativeManagement(r *mdm.Request, message *mdm.DeclarativeManagement) ([]byte, error) {
+	body, err := t.next.DeclarativeManagement(r, message)
+	if err != nil {
+		return body, err
+	}
+	logger := ctxlog.Logger(r.Context, t.logger)
+	token, err := declarationsToken(message.Endpoint, body)
+	if err != nil {
+		logger.Info("msg", "parsing tokens response", "err", err)
+	}
+	var previous string
+	changed := false
+	if token != "" && t.onToken != nil {
+		// retrieve the previous token before it is replaced
+		enablements, err := t.store.RetrieveDMEnablements(r.Context, []string{r.ID})
+		if err != nil {
+			logger.Info("msg", "retrieving DM enablement", "err", err)
+		} else {
+			if e := enablements[r.ID]; e != nil {
+				previous = e.DeclarationsToken
+			}
+			changed = token != previous
+		}
+	}
+	if err = t.store.StoreDMEnablement(r, message.Endpoint, token); err != nil {
+		logger.Info("msg", "storing DM enablement", "err", err)
+	}
+	if changed {
+		if err = t.onToken(r.Context, r.ID, token, previous); err != nil {
+			logger.Info("msg", "declarations token changed", "err", err)
+		}
+	}
+	if strings.Trim(message.Endpoint, "/") == "status" && len(message.Data) > 0 && (t.onStatus != nil || t.statusStore != nil) {
+		declarations, err := declarationStatuses(message.Data)
+		if err != nil {
+			logger.Info("msg", "parsing status report", "err", err)
+		}
+		if t.statusStore != nil {
+			if err = t.storeStatus(r.Context, r.ID, message.Data, declarations); err != nil {
+				logger.Info("msg", "storing status report", "err", err)
+			}
+		}
+		if t.onStatus != nil {
+			if err = t.onStatus(r.Context, r.ID, message.Data, declarations); err != nil {
+				logger.Info("msg", "status report", "err", err)
+			}
+		}
+	}
+	return body, nil
+}
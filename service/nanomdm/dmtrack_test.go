@@ -0,0 +1,156 @@
+package nanomdm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type fauxDM struct {
+	body []byte
+}
+
+func (f *fauxDM) DeclarativeManagement(_ *mdm.Request, _ *mdm.DeclarativeManagement) ([]byte, error) {
+	return f.body, nil
+}
+
+type fauxDMStore struct {
+	endpoint, token string
+}
+
+func (f *fauxDMStore) StoreDMEnablement(_ *mdm.Request, endpoint, token string) error {
+	f.endpoint = endpoint
+	f.token = token
+	return nil
+}
+
+func (f *fauxDMStore) RetrieveDMEnablements(_ context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	if f.endpoint == "" {
+		return nil, nil
+	}
+	return map[string]*storage.DMEnablement{ids[0]: {LastEndpoint: f.endpoint, DeclarationsToken: f.token}}, nil
+}
+
+func TestDMTracker(t *testing.T) {
+	dm := &fauxDM{body: []byte(`{"SyncTokens":{"DeclarationsToken":"abc123","Timestamp":"2023-01-01T00:00:00Z"}}`)}
+	store := &fauxDMStore{}
+	tracker := NewDMTracker(dm, store)
+	r := newMDMReq()
+	r.Context = context.Background()
+	_, err := tracker.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "tokens"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := store.endpoint, "tokens"; have != want {
+		t.Errorf("endpoint: have %q, want %q", have, want)
+	}
+	if have, want := store.token, "abc123"; have != want {
+		t.Errorf("token: have %q, want %q", have, want)
+	}
+	// non-tokens endpoints should not record a token
+	dm.body = []byte(`{}`)
+	_, err = tracker.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "declaration-items"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := store.endpoint, "declaration-items"; have != want {
+		t.Errorf("endpoint: have %q, want %q", have, want)
+	}
+	if store.token != "" {
+		t.Errorf("token: have %q, want empty", store.token)
+	}
+}
+
+func TestDMTrackerEvents(t *testing.T) {
+	dm := &fauxDM{body: []byte(`{"SyncTokens":{"DeclarationsToken":"abc123"}}`)}
+	store := &fauxDMStore{}
+	type tokenChange struct{ token, previous string }
+	var tokens []tokenChange
+	var declarations []*DeclarationStatus
+	var reports int
+	tracker := NewDMTracker(dm, store,
+		WithDMTokenFunc(func(_ context.Context, _, token, previous string) error {
+			tokens = append(tokens, tokenChange{token, previous})
+			return nil
+		}),
+		WithDMStatusFunc(func(_ context.Context, _ string, _ []byte, d []*DeclarationStatus) error {
+			reports++
+			declarations = d
+			return nil
+		}),
+	)
+	r := newMDMReq()
+	r.Context = context.Background()
+
+	// the same token twice and then a new token
+	for _, body := range []string{
+		`{"SyncTokens":{"DeclarationsToken":"abc123"}}`,
+		`{"SyncTokens":{"DeclarationsToken":"abc123"}}`,
+		`{"SyncTokens":{"DeclarationsToken":"def456"}}`,
+	} {
+		dm.body = []byte(body)
+		if _, err := tracker.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "tokens"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := len(tokens), 2; have != want {
+		t.Fatalf("token changes: have %d, want %d", have, want)
+	}
+	if tokens[0] != (tokenChange{"abc123", ""}) || tokens[1] != (tokenChange{"def456", "abc123"}) {
+		t.Errorf("token changes: %v", tokens)
+	}
+
+	dm.body = nil
+	report := []byte(`{"StatusItems":{"management":{"declarations":{"configurations":[{"identifier":"com.example.passcode","active":false,"valid":"invalid","server-token":"1","reasons":[{"code":"Error.ConfigurationCannotBeApplied"}]}],"activations":[{"identifier":"com.example.activation","active":true,"valid":"valid","server-token":"2"}]}}},"Errors":[]}`)
+	if _, err := tracker.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "status", Data: report}); err != nil {
+		t.Fatal(err)
+	}
+	if reports != 1 {
+		t.Fatalf("status reports: have %d, want 1", reports)
+	}
+	if have, want := len(declarations), 2; have != want {
+		t.Fatalf("declarations: have %d, want %d", have, want)
+	}
+	if d := declarations[0]; d.Kind != "activations" || d.Identifier != "com.example.activation" || !d.Active || d.ServerToken != "2" {
+		t.Errorf("activation status: %+v", d)
+	}
+	if d := declarations[1]; d.Kind != "configurations" || d.Valid != "invalid" || d.Active || len(d.Reasons) < 1 {
+		t.Errorf("configuration status: %+v", d)
+	}
+}
+
+func TestDMTrackerStatusStore(t *testing.T) {
+	type stored struct{ report, declarations string }
+	var reports []stored
+	store := &mock.Storage{
+		StoreDDMStatusReportFunc: func(_ context.Context, _ string, report, declarations []byte) error {
+			reports = append(reports, stored{string(report), string(declarations)})
+			return nil
+		},
+	}
+	tracker := NewDMTracker(&fauxDM{}, &fauxDMStore{}, WithDMStatusStore(store))
+	r := newMDMReq()
+	r.Context = context.Background()
+
+	for _, report := range []string{
+		`{"StatusItems":{"management":{"declarations":{"configurations":[{"identifier":"com.example.passcode","active":true,"valid":"valid","server-token":"1"}]}}}}`,
+		`{"StatusItems":{"device":{"model":{"family":"iPhone"}}}}`,
+	} {
+		if _, err := tracker.DeclarativeManagement(r, &mdm.DeclarativeManagement{Endpoint: "status", Data: []byte(report)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := len(reports), 2; have != want {
+		t.Fatalf("stored reports: have %d, want %d", have, want)
+	}
+	if !strings.Contains(reports[0].declarations, `"identifier":"com.example.passcode"`) {
+		t.Errorf("declarations: %s", reports[0].declarations)
+	}
+	if reports[1].declarations != "" {
+		t.Errorf("declarations of report without declarations: %s", reports[1].declarations)
+	}
+}
@@ -0,0 +1,134 @@
+package nanomdm
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DuplicateEnrollment is a device that Authenticated with a different
+// enrollment ID than its previous enrollment (e.g. after a restore).
+type DuplicateEnrollment struct {
+	SerialNumber string `json:"serial_number"`
+	EnrollmentID string `json:"enrollment_id"`
+	PreviousID   string `json:"previous_id"`
+
+	// Disabled is true if the previous enrollment was disabled.
+	Disabled bool `json:"disabled"`
+}
+
+// DuplicateFunc is called when a duplicate enrollment is detected.
+type DuplicateFunc func(ctx context.Context, d *DuplicateEnrollment) error
+
+// DuplicateStore resolves serial numbers to enrollments and disables
+// enrollments.
+type DuplicateStore interface {
+	storage.EnrollmentAliasResolver
+	storage.CheckinStore
+}
+
+// DuplicateDetector is a service middleware that detects when the serial
+// number of an Authenticate message was last seen with a different
+// enrollment. This happens when a device is restored or re-enrolled
+// with a new UDID or EnrollmentID and otherwise leaves a stale ("ghost")
+// duplicate enrollment behind.
+type DuplicateDetector struct {
+	service.CheckinAndCommandService
+	store   DuplicateStore
+	logger  log.Logger
+	disable bool
+	onDup   DuplicateFunc
+}
+
+// DuplicateDetectorOption configures a DuplicateDetector.
+type DuplicateDetectorOption func(*DuplicateDetector)
+
+// WithDuplicateDetectorLogger configures a logger on the DuplicateDetector.
+func WithDuplicateDetectorLogger(logger log.Logger) DuplicateDetectorOption {
+	return func(d *DuplicateDetector) {
+		d.logger = logger
+	}
+}
+
+// WithDuplicateDisable disables the previous enrollment of duplicates.
+func WithDuplicateDisable() DuplicateDetectorOption {
+	return func(d *DuplicateDetector) {
+		d.disable = true
+	}
+}
+
+// WithDuplicateFunc sets the function called with detected duplicates.
+func WithDuplicateFunc(f DuplicateFunc) DuplicateDetectorOption {
+	return func(d *DuplicateDetector) {
+		d.onDup = f
+	}
+}
+
+// NewDuplicateDetector creates a new duplicate enrollment detecting
+// service middleware.
+func NewDuplicateDetector(next service.CheckinAndCommandService, store DuplicateStore, opts ...DuplicateDetectorOption) *DuplicateDetector {
+	d := &DuplicateDetector{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Authenticate resolves the serial number of m to its previous
+// enrollment and calls the next service. If the previous enrollment
+// differs from the enrollment in r it is reported as a duplicate and
+// optionally disabled. Errors detecting duplicates are logged but
+// otherwise ignored.
+func (d *DuplicateDetector) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if r.EnrollID == nil || r.ParentID != "" || m.SerialNumber == "" {
+		return d.CheckinAndCommandService.Authenticate(r, m)
+	}
+	logger := ctxlog.Logger(r.Context, d.logger)
+	// resolve before the next service points the serial number at
+	// the new enrollment
+	resolved, err := d.store.ResolveEnrollmentIDs(r.Context, []string{m.SerialNumber})
+	if err != nil {
+		logger.Info("msg", "resolving serial number", "err", err)
+	}
+	if err = d.CheckinAndCommandService.Authenticate(r, m); err != nil {
+		return err
+	}
+	prevID := resolved[m.SerialNumber]
+	if prevID == "" || prevID == r.ID {
+		return nil
+	}
+	dup := &DuplicateEnrollment{
+		SerialNumber: m.SerialNumber,
+		EnrollmentID: r.ID,
+		PreviousID:   prevID,
+	}
+	if d.disable {
+		prevReq := &mdm.Request{Context: r.Context, EnrollID: &mdm.EnrollID{ID: prevID}}
+		if err = storage.DisableWithReason(d.store, prevReq, storage.DisableReasonDuplicate); err != nil {
+			logger.Info("msg", "disabling duplicate enrollment", "previous_id", prevID, "err", err)
+		} else {
+			dup.Disabled = true
+		}
+	}
+	logger.Info(
+		"msg", "duplicate enrollment",
+		"serial_number", dup.SerialNumber,
+		"previous_id", dup.PreviousID,
+		"disabled", dup.Disabled,
+	)
+	if d.onDup != nil {
+		if err = d.onDup(r.Context, dup); err != nil {
+			logger.Info("msg", "duplicate enrollment", "err", err)
+		}
+	}
+	return nil
+}
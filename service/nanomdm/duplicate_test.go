@@ -0,0 +1,66 @@
+package nanomdm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+
+	"github.com/groob/plist"
+)
+
+func TestDuplicateDetector(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dups []*DuplicateEnrollment
+	d := NewDuplicateDetector(New(store), store, WithDuplicateDisable(), WithDuplicateFunc(
+		func(_ context.Context, dup *DuplicateEnrollment) error {
+			dups = append(dups, dup)
+			return nil
+		},
+	))
+	enroll := func(id, serial string) {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device}}
+		msgs := []map[string]interface{}{
+			{"MessageType": "Authenticate", "UDID": id, "Topic": "com.example", "SerialNumber": serial},
+			{"MessageType": "TokenUpdate", "UDID": id, "Topic": "com.example", "Token": []byte(id), "PushMagic": "magic"},
+		}
+		for _, msg := range msgs {
+			b, err := plist.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = service.CheckinRequest(d, r, b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	enroll("DEV1", "SERIAL1")
+	enroll("DEV1", "SERIAL1")
+	enroll("DEV2", "SERIAL2")
+	if len(dups) > 0 {
+		t.Fatalf("unexpected duplicates: %+v", dups[0])
+	}
+
+	enroll("DEV3", "SERIAL1")
+	if have, want := len(dups), 1; have != want {
+		t.Fatalf("duplicates: have %d, want %d", have, want)
+	}
+	if dup := dups[0]; dup.EnrollmentID != "DEV3" || dup.PreviousID != "DEV1" || dup.SerialNumber != "SERIAL1" || !dup.Disabled {
+		t.Errorf("unexpected duplicate: %+v", dup)
+	}
+	enrollments, err := store.RetrieveEnrollments(context.Background(), &storage.EnrollmentFilter{IDs: []string{"DEV1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 1 || enrollments[0].Enabled || enrollments[0].DisableReason != storage.DisableReasonDuplicate {
+		t.Errorf("previous enrollment not disabled: %+v", enrollments)
+	}
+}
@@ -0,0 +1,124 @@
+package nanomdm
+
+import (
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/certauth"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// EventLogger is a service middleware that appends successfully
+// processed check-in and command events to the event log. Read-only
+// check-ins (such as GetBootstrapToken) are not logged.
+type EventLogger struct {
+	service.CheckinAndCommandService
+	store  storage.EventLogStore
+	logger log.Logger
+}
+
+// EventLoggerOption configures an EventLogger.
+type EventLoggerOption func(*EventLogger)
+
+// WithEventLoggerLogger configures a logger on the EventLogger.
+func WithEventLoggerLogger(logger log.Logger) EventLoggerOption {
+	return func(l *EventLogger) {
+		l.logger = logger
+	}
+}
+
+// NewEventLogger creates a new event logging service middleware.
+func NewEventLogger(next service.CheckinAndCommandService, store storage.EventLogStore, opts ...EventLoggerOption) *EventLogger {
+	l := &EventLogger{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// certHash returns the hash of the identity certificate of r, if any.
+func certHash(r *mdm.Request) string {
+	if r.Certificate == nil {
+		return ""
+	}
+	return certauth.HashCert(r.Certificate)
+}
+
+// storeEvent appends event for the enrollment in r to the event log.
+// Errors are logged but otherwise ignored.
+func (l *EventLogger) storeEvent(r *mdm.Request, event *storage.LogEvent) {
+	event.EnrollmentID = r.ID
+	if err := l.store.StoreLogEvent(r.Context, event); err != nil {
+		ctxlog.Logger(r.Context, l.logger).Info("msg", "storing log event", "topic", event.Topic, "err", err)
+	}
+}
+
+func (l *EventLogger) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	err := l.CheckinAndCommandService.Authenticate(r, m)
+	if err == nil {
+		l.storeEvent(r, &storage.LogEvent{Topic: "mdm.Authenticate", RawPayload: m.Raw, CertHash: certHash(r)})
+	}
+	return err
+}
+
+func (l *EventLogger) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	err := l.CheckinAndCommandService.TokenUpdate(r, m)
+	if err == nil {
+		l.storeEvent(r, &storage.LogEvent{Topic: "mdm.TokenUpdate", RawPayload: m.Raw, CertHash: certHash(r)})
+	}
+	return err
+}
+
+func (l *EventLogger) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	err := l.CheckinAndCommandService.CheckOut(r, m)
+	if err == nil {
+		l.storeEvent(r, &storage.LogEvent{Topic: "mdm.CheckOut", RawPayload: m.Raw})
+	}
+	return err
+}
+
+// SetBootstrapToken logs the event without the payload to avoid
+// storing the bootstrap token in the event log.
+func (l *EventLogger) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	err := l.CheckinAndCommandService.SetBootstrapToken(r, m)
+	if err == nil {
+		l.storeEvent(r, &storage.LogEvent{Topic: "mdm.SetBootstrapToken"})
+	}
+	return err
+}
+
+func (l *EventLogger) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	resp, err := l.CheckinAndCommandService.UserAuthenticate(r, m)
+	if err == nil {
+		l.storeEvent(r, &storage.LogEvent{Topic: "mdm.UserAuthenticate", RawPayload: m.Raw})
+	}
+	return resp, err
+}
+
+// CommandAndReportResults logs the command report (as "mdm.Connect")
+// and the delivery of any next command (as "nanomdm.CommandDelivered").
+func (l *EventLogger) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := l.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil {
+		return cmd, err
+	}
+	l.storeEvent(r, &storage.LogEvent{
+		Topic:       "mdm.Connect",
+		CommandUUID: results.CommandUUID,
+		Status:      results.Status,
+		RawPayload:  results.Raw,
+	})
+	if cmd != nil {
+		l.storeEvent(r, &storage.LogEvent{
+			Topic:       "nanomdm.CommandDelivered",
+			CommandUUID: cmd.CommandUUID,
+		})
+	}
+	return cmd, nil
+}
@@ -0,0 +1,170 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ErrEvicted is returned for the check-ins and command polls of evicted
+// enrollments.
+var ErrEvicted = errors.New("enrollment evicted")
+
+// EvictionFunc is called when an eviction is first delivered.
+type EvictionFunc func(ctx context.Context, eviction *storage.EnrollmentEviction) error
+
+// Evictor is a service middleware that replies to the check-ins and
+// command polls of evicted enrollments with an HTTP 410 which prompts
+// devices to remove their MDM enrollment profile. The first delivery of
+// an eviction is recorded. Evicting a device enrollment also evicts its
+// user channel enrollments. CheckOut messages are passed through so
+// that the enrollment is disabled when the device unenrolls.
+type Evictor struct {
+	service.CheckinAndCommandService
+	store     storage.EnrollmentEvictionStore
+	logger    log.Logger
+	onEvicted EvictionFunc
+}
+
+// EvictorOption configures an Evictor.
+type EvictorOption func(*Evictor)
+
+// WithEvictorLogger configures a logger on the Evictor.
+func WithEvictorLogger(logger log.Logger) EvictorOption {
+	return func(e *Evictor) {
+		e.logger = logger
+	}
+}
+
+// WithEvictionFunc sets the function called with evictions when they
+// are first delivered.
+func WithEvictionFunc(f EvictionFunc) EvictorOption {
+	return func(e *Evictor) {
+		e.onEvicted = f
+	}
+}
+
+// NewEvictor creates a new enrollment evicting service middleware.
+func NewEvictor(next service.CheckinAndCommandService, store storage.EnrollmentEvictionStore, opts ...EvictorOption) *Evictor {
+	e := &Evictor{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// check returns an HTTP 410 error if the enrollment of r (or its device
+// channel enrollment) is evicted.
+func (e *Evictor) check(r *mdm.Request, messageType string) error {
+	if r.EnrollID == nil || r.ID == "" {
+		// not yet normalized (e.g. agent requests)
+		return nil
+	}
+	ids := []string{r.ID}
+	if r.ParentID != "" {
+		ids = append(ids, r.ParentID)
+	}
+	evictions, err := e.store.RetrieveEnrollmentEvictions(r.Context, ids)
+	if err != nil {
+		return fmt.Errorf("retrieving evictions: %w", err)
+	}
+	eviction := evictions[r.ID]
+	if eviction == nil && r.ParentID != "" && evictions[r.ParentID] != nil {
+		// only the device channel records delivery
+		return service.NewHTTPStatusError(http.StatusGone, ErrEvicted)
+	} else if eviction == nil {
+		return nil
+	}
+	logger := ctxlog.Logger(r.Context, e.logger)
+	if eviction.DeliveredAt == nil {
+		delivered, err := e.store.MarkEnrollmentEvictionDelivered(r.Context, r.ID)
+		if err != nil {
+			logger.Info("msg", "marking eviction delivered", "err", err)
+		} else if delivered {
+			now := time.Now().UTC()
+			eviction.DeliveredAt = &now
+			logger.Info(
+				"msg", "eviction delivered",
+				"message_type", messageType,
+				"reason", eviction.Reason,
+			)
+			if e.onEvicted != nil {
+				if err = e.onEvicted(r.Context, eviction); err != nil {
+					logger.Info("msg", "eviction delivered", "err", err)
+				}
+			}
+		}
+	} else {
+		logger.Debug("msg", "evicted enrollment", "message_type", messageType)
+	}
+	return service.NewHTTPStatusError(http.StatusGone, ErrEvicted)
+}
+
+func (e *Evictor) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	if err := e.check(r, "Authenticate"); err != nil {
+		return err
+	}
+	return e.CheckinAndCommandService.Authenticate(r, m)
+}
+
+func (e *Evictor) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := e.check(r, "TokenUpdate"); err != nil {
+		return err
+	}
+	return e.CheckinAndCommandService.TokenUpdate(r, m)
+}
+
+func (e *Evictor) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	if err := e.check(r, "SetBootstrapToken"); err != nil {
+		return err
+	}
+	return e.CheckinAndCommandService.SetBootstrapToken(r, m)
+}
+
+func (e *Evictor) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	if err := e.check(r, "GetBootstrapToken"); err != nil {
+		return nil, err
+	}
+	return e.CheckinAndCommandService.GetBootstrapToken(r, m)
+}
+
+func (e *Evictor) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	if err := e.check(r, "UserAuthenticate"); err != nil {
+		return nil, err
+	}
+	return e.CheckinAndCommandService.UserAuthenticate(r, m)
+}
+
+func (e *Evictor) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	if err := e.check(r, "DeclarativeManagement"); err != nil {
+		return nil, err
+	}
+	return e.CheckinAndCommandService.DeclarativeManagement(r, m)
+}
+
+func (e *Evictor) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	if err := e.check(r, "GetToken"); err != nil {
+		return nil, err
+	}
+	return e.CheckinAndCommandService.GetToken(r, m)
+}
+
+func (e *Evictor) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if err := e.check(r, "CommandAndReportResults"); err != nil {
+		return nil, err
+	}
+	return e.CheckinAndCommandService.CommandAndReportResults(r, results)
+}
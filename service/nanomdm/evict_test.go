@@ -0,0 +1,66 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+func TestEvictor(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.StoreEnrollmentEviction(ctx, &storage.EnrollmentEviction{ID: "EVICTED", Reason: "stolen"}); err != nil {
+		t.Fatal(err)
+	}
+	var delivered []*storage.EnrollmentEviction
+	e := NewEvictor(new(mock.Service), store, WithEvictionFunc(func(_ context.Context, eviction *storage.EnrollmentEviction) error {
+		delivered = append(delivered, eviction)
+		return nil
+	}))
+	poll := func(id, parentID string) error {
+		r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id, ParentID: parentID}}
+		_, err := e.CommandAndReportResults(r, &mdm.CommandResults{Status: "Idle"})
+		return err
+	}
+	isGone := func(err error) bool {
+		var statusErr *service.HTTPStatusError
+		return errors.As(err, &statusErr) && statusErr.Status == http.StatusGone && errors.Is(err, ErrEvicted)
+	}
+
+	if err = poll("ENROLLED", ""); err != nil {
+		t.Errorf("enrolled: unexpected error: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err = poll("EVICTED", ""); !isGone(err) {
+			t.Errorf("evicted: unexpected error: %v", err)
+		}
+	}
+	if err = poll("EVICTED:USER", "EVICTED"); !isGone(err) {
+		t.Errorf("evicted user channel: unexpected error: %v", err)
+	}
+	if have, want := len(delivered), 1; have != want {
+		t.Fatalf("deliveries: have %d, want %d", have, want)
+	}
+	if delivered[0].ID != "EVICTED" || delivered[0].Reason != "stolen" || delivered[0].DeliveredAt == nil {
+		t.Errorf("unexpected delivered eviction: %+v", delivered[0])
+	}
+
+	// CheckOut is passed through for evicted enrollments
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "EVICTED"}}
+	if err = e.CheckOut(r, new(mdm.CheckOut)); err != nil {
+		t.Errorf("check out: unexpected error: %v", err)
+	}
+	if err = e.TokenUpdate(r, new(mdm.TokenUpdate)); !isGone(err) {
+		t.Errorf("token update: unexpected error: %v", err)
+	}
+}
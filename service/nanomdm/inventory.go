@@ -0,0 +1,219 @@
+package nanomdm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// InventoryChangeFunc is called with the inventory changes of an
+// enrollment when a new inventory snapshot differs from the previous.
+type InventoryChangeFunc func(ctx context.Context, id string, changes []*storage.InventoryChange) error
+
+// InventorySnapshotFunc is called after new inventory snapshots of an
+// enrollment are stored, whether or not they changed.
+type InventorySnapshotFunc func(ctx context.Context, id string) error
+
+// InventoryTracker is a service middleware that stores inventory
+// snapshots of DeviceInformation, SecurityInfo, and ProfileList
+// command results and reports the changes from the previous snapshot.
+// The first snapshot of an enrollment has no changes.
+type InventoryTracker struct {
+	service.CheckinAndCommandService
+	store    storage.InventoryStore
+	logger   log.Logger
+	onChange InventoryChangeFunc
+	onStore  InventorySnapshotFunc
+}
+
+// InventoryTrackerOption configures an InventoryTracker.
+type InventoryTrackerOption func(*InventoryTracker)
+
+// WithInventoryTrackerLogger configures a logger on the InventoryTracker.
+func WithInventoryTrackerLogger(logger log.Logger) InventoryTrackerOption {
+	return func(t *InventoryTracker) {
+		t.logger = logger
+	}
+}
+
+// WithInventoryChangeFunc sets the function called with inventory changes.
+func WithInventoryChangeFunc(f InventoryChangeFunc) InventoryTrackerOption {
+	return func(t *InventoryTracker) {
+		t.onChange = f
+	}
+}
+
+// WithInventorySnapshotFunc sets the function called after inventory
+// snapshots are stored.
+func WithInventorySnapshotFunc(f InventorySnapshotFunc) InventoryTrackerOption {
+	return func(t *InventoryTracker) {
+		t.onStore = f
+	}
+}
+
+// NewInventoryTracker creates a new inventory tracking service middleware.
+func NewInventoryTracker(next service.CheckinAndCommandService, store storage.InventoryStore, opts ...InventoryTrackerOption) *InventoryTracker {
+	t := &InventoryTracker{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// flatten adds the values of v to values with keys prefixed by prefix.
+// Dictionaries are flattened with dot-separated keys and other values
+// are formatted as strings (arrays as JSON).
+func flatten(values map[string]string, prefix string, v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if prefix != "" {
+				k = prefix + "." + k
+			}
+			flatten(values, k, e)
+		}
+	case []interface{}:
+		b, _ := json.Marshal(v)
+		values[prefix] = string(b)
+	case []byte:
+		values[prefix] = fmt.Sprintf("%x", v)
+	case time.Time:
+		values[prefix] = v.UTC().Format(time.RFC3339)
+	default:
+		values[prefix] = fmt.Sprint(v)
+	}
+}
+
+// inventorySnapshots extracts the inventory snapshots keyed by source
+// from the raw command result.
+func inventorySnapshots(raw []byte) (map[string]map[string]string, error) {
+	var result struct {
+		QueryResponses map[string]interface{}
+		SecurityInfo   map[string]interface{}
+		ProfileList    []struct {
+			PayloadIdentifier string
+			PayloadUUID       string
+		}
+	}
+	if err := plist.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	snapshots := make(map[string]map[string]string)
+	if result.QueryResponses != nil {
+		values := make(map[string]string)
+		flatten(values, "", result.QueryResponses)
+		snapshots["DeviceInformation"] = values
+	}
+	if result.SecurityInfo != nil {
+		values := make(map[string]string)
+		flatten(values, "", result.SecurityInfo)
+		snapshots["SecurityInfo"] = values
+	}
+	if result.ProfileList != nil {
+		// profiles are keyed by identifier so that an updated profile
+		// shows as a changed UUID
+		values := make(map[string]string)
+		for _, profile := range result.ProfileList {
+			values[profile.PayloadIdentifier] = profile.PayloadUUID
+		}
+		snapshots["ProfileList"] = values
+	}
+	return snapshots, nil
+}
+
+// diffInventory returns the changes from prev to cur of source sorted
+// by key.
+func diffInventory(source string, prev, cur map[string]string) []*storage.InventoryChange {
+	var changes []*storage.InventoryChange
+	for k, v := range cur {
+		if p, ok := prev[k]; !ok {
+			changes = append(changes, &storage.InventoryChange{Source: source, Key: k, Change: storage.InventoryAdded, Current: v})
+		} else if p != v {
+			changes = append(changes, &storage.InventoryChange{Source: source, Key: k, Change: storage.InventoryChanged, Previous: p, Current: v})
+		}
+	}
+	for k, p := range prev {
+		if _, ok := cur[k]; !ok {
+			changes = append(changes, &storage.InventoryChange{Source: source, Key: k, Change: storage.InventoryRemoved, Previous: p})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// track stores the inventory snapshots of results and returns the
+// changes from the previous snapshots and the number of snapshots.
+func (t *InventoryTracker) track(ctx context.Context, id string, results *mdm.CommandResults) ([]*storage.InventoryChange, int, error) {
+	snapshots, err := inventorySnapshots(results.Raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("decoding inventory: %w", err)
+	}
+	sources := make([]string, 0, len(snapshots))
+	for source := range snapshots {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	var changes []*storage.InventoryChange
+	for _, source := range sources {
+		prev, err := t.store.RetrieveInventory(ctx, id, source)
+		if err != nil {
+			return changes, 0, fmt.Errorf("retrieving inventory: %w", err)
+		}
+		if err = t.store.StoreInventory(ctx, id, source, snapshots[source]); err != nil {
+			return changes, 0, fmt.Errorf("storing inventory: %w", err)
+		}
+		if prev != nil {
+			changes = append(changes, diffInventory(source, prev, snapshots[source])...)
+		}
+	}
+	return changes, len(snapshots), nil
+}
+
+// CommandAndReportResults calls the next service and then, for
+// acknowledged inventory command results, stores the inventory snapshot
+// and reports any changes. Errors tracking the inventory are logged but
+// otherwise ignored.
+func (t *InventoryTracker) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := t.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || r.EnrollID == nil || results.Status != "Acknowledged" {
+		return cmd, err
+	}
+	logger := ctxlog.Logger(r.Context, t.logger)
+	changes, n, err := t.track(r.Context, r.ID, results)
+	if err != nil {
+		logger.Info("msg", "tracking inventory", "command_uuid", results.CommandUUID, "err", err)
+	}
+	if n > 0 && t.onStore != nil {
+		if err = t.onStore(r.Context, r.ID); err != nil {
+			logger.Info("msg", "inventory snapshot", "err", err)
+		}
+	}
+	if len(changes) < 1 {
+		return cmd, nil
+	}
+	logger.Debug(
+		"msg", "inventory changed",
+		"command_uuid", results.CommandUUID,
+		"changes", len(changes),
+	)
+	if t.onChange != nil {
+		if err = t.onChange(r.Context, r.ID, changes); err != nil {
+			logger.Info("msg", "inventory change", "err", err)
+		}
+	}
+	return cmd, nil
+}
@@ -0,0 +1,121 @@
+package nanomdm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+type fauxCommandAndReportResults struct {
+	service.CheckinAndCommandService
+}
+
+func (f *fauxCommandAndReportResults) CommandAndReportResults(_ *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+	return nil, nil
+}
+
+const securityInfoResult = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>uuid</string>
+	<key>SecurityInfo</key>
+	<dict>
+		<key>FDE_Enabled</key>
+		<%s/>
+		<key>FirewallSettings</key>
+		<dict>
+			<key>FirewallEnabled</key>
+			<true/>
+		</dict>
+	</dict>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>DEV1</string>
+</dict>
+</plist>
+`
+
+const profileListResult = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CommandUUID</key>
+	<string>uuid</string>
+	<key>ProfileList</key>
+	<array>%s</array>
+	<key>Status</key>
+	<string>Acknowledged</string>
+	<key>UDID</key>
+	<string>DEV1</string>
+</dict>
+</plist>
+`
+
+const profileListEntry = `
+		<dict>
+			<key>PayloadIdentifier</key>
+			<string>com.example.wifi</string>
+			<key>PayloadUUID</key>
+			<string>A1</string>
+		</dict>`
+
+func TestInventoryTracker(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes [][]*storage.InventoryChange
+	tracker := NewInventoryTracker(&fauxCommandAndReportResults{}, store, WithInventoryChangeFunc(
+		func(_ context.Context, id string, c []*storage.InventoryChange) error {
+			if id != "DEV1" {
+				t.Errorf("id: have %q, want %q", id, "DEV1")
+			}
+			changes = append(changes, c)
+			return nil
+		},
+	))
+	report := func(raw string) {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "DEV1"}}
+		results, err := mdm.DecodeCommandResults([]byte(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tracker.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report(fmt.Sprintf(securityInfoResult, "true"))
+	report(fmt.Sprintf(securityInfoResult, "true"))
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, have %d", len(changes))
+	}
+	report(fmt.Sprintf(securityInfoResult, "false"))
+	if len(changes) != 1 || len(changes[0]) != 1 {
+		t.Fatalf("expected one change, have %v", changes)
+	}
+	c := changes[0][0]
+	if c.Source != "SecurityInfo" || c.Key != "FDE_Enabled" || c.Change != storage.InventoryChanged || c.Previous != "true" || c.Current != "false" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+
+	changes = nil
+	report(fmt.Sprintf(profileListResult, profileListEntry))
+	report(fmt.Sprintf(profileListResult, ""))
+	if len(changes) != 1 || len(changes[0]) != 1 {
+		t.Fatalf("expected one change, have %v", changes)
+	}
+	c = changes[0][0]
+	if c.Source != "ProfileList" || c.Key != "com.example.wifi" || c.Change != storage.InventoryRemoved || c.Previous != "A1" {
+		t.Errorf("unexpected change: %+v", c)
+	}
+}
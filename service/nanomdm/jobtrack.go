@@ -0,0 +1,76 @@
+package nanomdm
+
+import (
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// JobTracker is a service middleware that updates the status of job
+// targets as commands are delivered to enrollments and as their
+// results arrive.
+type JobTracker struct {
+	service.CheckinAndCommandService
+	store  storage.JobStore
+	logger log.Logger
+}
+
+// JobTrackerOption configures a JobTracker.
+type JobTrackerOption func(*JobTracker)
+
+// WithJobTrackerLogger configures a logger on the JobTracker.
+func WithJobTrackerLogger(logger log.Logger) JobTrackerOption {
+	return func(t *JobTracker) {
+		t.logger = logger
+	}
+}
+
+// NewJobTracker creates a new job tracking service middleware.
+func NewJobTracker(next service.CheckinAndCommandService, store storage.JobStore, opts ...JobTrackerOption) *JobTracker {
+	t := &JobTracker{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// jobStatusFromResult maps a command result status to a job status.
+// An empty string is returned for results that do not change the status.
+func jobStatusFromResult(status string) string {
+	switch status {
+	case "Acknowledged":
+		return storage.JobStatusAcknowledged
+	case "Error", "CommandFormatError":
+		return storage.JobStatusErrored
+	}
+	return ""
+}
+
+// CommandAndReportResults calls the next service and then records the
+// reported command result and the delivery of the next command.
+// Errors updating job status are logged but otherwise ignored.
+func (t *JobTracker) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := t.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil {
+		return cmd, err
+	}
+	logger := ctxlog.Logger(r.Context, t.logger)
+	if status := jobStatusFromResult(results.Status); status != "" && results.CommandUUID != "" {
+		if err := t.store.UpdateJobCommandTarget(r.Context, r.ID, results.CommandUUID, status); err != nil {
+			logger.Info("msg", "updating job status", "command_uuid", results.CommandUUID, "err", err)
+		}
+	}
+	if cmd != nil {
+		if err := t.store.UpdateJobCommandTarget(r.Context, r.ID, cmd.CommandUUID, storage.JobStatusDelivered); err != nil {
+			logger.Info("msg", "updating job status", "command_uuid", cmd.CommandUUID, "err", err)
+		}
+	}
+	return cmd, nil
+}
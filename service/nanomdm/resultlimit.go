@@ -0,0 +1,164 @@
+package nanomdm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Oversized command result policies.
+const (
+	// ResultPolicyTruncate replaces the result with a stub.
+	ResultPolicyTruncate = "truncate"
+
+	// ResultPolicySpill saves the full result with a ResultSpiller
+	// and replaces the result with a stub referencing it.
+	ResultPolicySpill = "spill"
+
+	// ResultPolicyReject rejects the result with an HTTP 413.
+	ResultPolicyReject = "reject"
+)
+
+// ErrResultTooLarge is returned when rejecting an oversized command result.
+var ErrResultTooLarge = errors.New("command result too large")
+
+// ResultSpiller saves oversized command results outside of storage.
+type ResultSpiller interface {
+	// SpillResult saves raw and returns a reference to it.
+	SpillResult(r *mdm.Request, results *mdm.CommandResults) (ref string, err error)
+}
+
+// DirResultSpiller saves oversized command results as files in a directory.
+// Files are named by the SHA-256 hash of the result.
+type DirResultSpiller string
+
+// SpillResult writes the raw result to the directory and returns its path.
+func (d DirResultSpiller) SpillResult(_ *mdm.Request, results *mdm.CommandResults) (string, error) {
+	sum := sha256.Sum256(results.Raw)
+	path := filepath.Join(string(d), hex.EncodeToString(sum[:])+".plist")
+	if err := os.WriteFile(path, results.Raw, 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// truncatedResults is the stub stored in place of an oversized result.
+// It keeps the fields needed to process the result and flags it.
+type truncatedResults struct {
+	mdm.Enrollment
+	CommandUUID string
+	Status      string
+	ErrorChain  []mdm.ErrorChain `plist:",omitempty"`
+	RequestType string           `plist:",omitempty"`
+
+	NanoMDMTruncated    bool
+	NanoMDMOriginalSize int
+	NanoMDMResultRef    string `plist:",omitempty"`
+}
+
+// ResultSizeLimiter is a service middleware that handles command results
+// larger than a maximum size according to a policy. This prevents very
+// large results (e.g. a huge InstalledApplicationList) from failing to
+// be stored (and being lost entirely).
+type ResultSizeLimiter struct {
+	service.CheckinAndCommandService
+	max     int
+	policy  string
+	spiller ResultSpiller
+	logger  log.Logger
+}
+
+// ResultSizeLimiterOption configures a ResultSizeLimiter.
+type ResultSizeLimiterOption func(*ResultSizeLimiter)
+
+// WithResultSizeLimiterLogger configures a logger on the ResultSizeLimiter.
+func WithResultSizeLimiterLogger(logger log.Logger) ResultSizeLimiterOption {
+	return func(l *ResultSizeLimiter) {
+		l.logger = logger
+	}
+}
+
+// WithResultSpiller sets the spiller for the spill policy.
+func WithResultSpiller(spiller ResultSpiller) ResultSizeLimiterOption {
+	return func(l *ResultSizeLimiter) {
+		l.spiller = spiller
+	}
+}
+
+// NewResultSizeLimiter creates a new command result size limiting service
+// middleware. Results larger than max bytes are handled according to policy.
+func NewResultSizeLimiter(next service.CheckinAndCommandService, max int, policy string, opts ...ResultSizeLimiterOption) (*ResultSizeLimiter, error) {
+	l := &ResultSizeLimiter{
+		CheckinAndCommandService: next,
+		max:                      max,
+		policy:                   policy,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	switch policy {
+	case ResultPolicyTruncate, ResultPolicyReject:
+	case ResultPolicySpill:
+		if l.spiller == nil {
+			return nil, errors.New("spill policy requires a result spiller")
+		}
+	default:
+		return nil, fmt.Errorf("invalid result policy: %q", policy)
+	}
+	return l, nil
+}
+
+// CommandAndReportResults handles oversized results according to the
+// policy before calling the next service.
+func (l *ResultSizeLimiter) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if l.max < 1 || len(results.Raw) <= l.max {
+		return l.CheckinAndCommandService.CommandAndReportResults(r, results)
+	}
+	logger := ctxlog.Logger(r.Context, l.logger).With(
+		"command_uuid", results.CommandUUID,
+		"size", len(results.Raw),
+		"policy", l.policy,
+	)
+	if l.policy == ResultPolicyReject {
+		logger.Info("msg", "rejecting oversized command result")
+		return nil, service.NewHTTPStatusError(http.StatusRequestEntityTooLarge, ErrResultTooLarge)
+	}
+	stub := &truncatedResults{
+		Enrollment:          results.Enrollment,
+		CommandUUID:         results.CommandUUID,
+		Status:              results.Status,
+		ErrorChain:          results.ErrorChain,
+		RequestType:         results.RequestType,
+		NanoMDMTruncated:    true,
+		NanoMDMOriginalSize: len(results.Raw),
+	}
+	if l.policy == ResultPolicySpill {
+		ref, err := l.spiller.SpillResult(r, results)
+		if err != nil {
+			return nil, fmt.Errorf("spilling command result: %w", err)
+		}
+		stub.NanoMDMResultRef = ref
+	}
+	raw, err := plist.Marshal(stub)
+	if err != nil {
+		return nil, fmt.Errorf("marshal truncated command result: %w", err)
+	}
+	logger.Info("msg", "oversized command result", "ref", stub.NanoMDMResultRef)
+	// copy rather than modify the results which may be shared with
+	// other (asynchronous) services.
+	results2 := *results
+	results2.Raw = raw
+	return l.CheckinAndCommandService.CommandAndReportResults(r, &results2)
+}
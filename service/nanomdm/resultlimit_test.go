@@ -0,0 +1,95 @@
+package nanomdm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+)
+
+type fauxCommandReport struct {
+	service.CheckinAndCommandService
+	results *mdm.CommandResults
+}
+
+func (f *fauxCommandReport) CommandAndReportResults(_ *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	f.results = results
+	return nil, nil
+}
+
+func TestResultSizeLimiter(t *testing.T) {
+	r := &mdm.Request{Context: context.Background()}
+	big := &mdm.CommandResults{
+		Enrollment:  mdm.Enrollment{UDID: "DEV1"},
+		CommandUUID: "CMD1",
+		Status:      "Acknowledged",
+		Raw:         bytes.Repeat([]byte("A"), 100),
+	}
+	small := &mdm.CommandResults{Status: "Idle", Raw: []byte("small")}
+
+	next := &fauxCommandReport{}
+	l, err := NewResultSizeLimiter(next, 50, ResultPolicyTruncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.CommandAndReportResults(r, small); err != nil {
+		t.Fatal(err)
+	}
+	if next.results != small {
+		t.Error("expected small result to be passed through")
+	}
+	if _, err = l.CommandAndReportResults(r, big); err != nil {
+		t.Fatal(err)
+	}
+	if len(big.Raw) != 100 {
+		t.Error("original results modified")
+	}
+	stub, err := mdm.DecodeCommandResults(next.results.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stub.CommandUUID != "CMD1" || stub.Status != "Acknowledged" || stub.UDID != "DEV1" {
+		t.Errorf("unexpected truncated result: %+v", stub)
+	}
+	if !bytes.Contains(next.results.Raw, []byte("NanoMDMTruncated")) {
+		t.Error("expected truncated flag in result")
+	}
+
+	dir := t.TempDir()
+	l, err = NewResultSizeLimiter(next, 50, ResultPolicySpill, WithResultSpiller(DirResultSpiller(dir)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.CommandAndReportResults(r, big); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("spilled files: have %d, want 1", len(entries))
+	}
+	if !bytes.Contains(next.results.Raw, []byte(entries[0].Name())) {
+		t.Error("expected spilled result reference in result")
+	}
+
+	l, err = NewResultSizeLimiter(next, 50, ResultPolicyReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = l.CommandAndReportResults(r, big)
+	var statusErr *service.HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected HTTP 413 error, got: %v", err)
+	}
+
+	if _, err = NewResultSizeLimiter(next, 50, ResultPolicySpill); err == nil {
+		t.Error("expected error for spill policy without spiller")
+	}
+}
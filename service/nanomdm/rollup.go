@@ -0,0 +1,187 @@
+package nanomdm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+)
+
+type rollupCounts struct {
+	checkins, commands, errors int64
+}
+
+// RollupRecorder is a service middleware that counts check-ins,
+// command results, and command errors and periodically adds them to
+// the hourly and daily metrics rollups in storage. Counts are kept in
+// memory between flushes so several instances can share storage.
+type RollupRecorder struct {
+	service.CheckinAndCommandService
+	store  storage.MetricsRollupStore
+	logger log.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	pending map[time.Time]*rollupCounts // keyed by UTC hour
+}
+
+// RollupRecorderOption configures a RollupRecorder.
+type RollupRecorderOption func(*RollupRecorder)
+
+// WithRollupRecorderLogger configures a logger on the RollupRecorder.
+func WithRollupRecorderLogger(logger log.Logger) RollupRecorderOption {
+	return func(rr *RollupRecorder) {
+		rr.logger = logger
+	}
+}
+
+// NewRollupRecorder creates a new metrics rollup service middleware.
+// Run or Flush must be called to store the counts.
+func NewRollupRecorder(next service.CheckinAndCommandService, store storage.MetricsRollupStore, opts ...RollupRecorderOption) *RollupRecorder {
+	rr := &RollupRecorder{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+		now:                      time.Now,
+		pending:                  make(map[time.Time]*rollupCounts),
+	}
+	for _, opt := range opts {
+		opt(rr)
+	}
+	return rr
+}
+
+// add adds counts to the current hour.
+func (rr *RollupRecorder) add(checkins, commands, errors int64) {
+	hour := rr.now().UTC().Truncate(time.Hour)
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+	c, ok := rr.pending[hour]
+	if !ok {
+		c = new(rollupCounts)
+		rr.pending[hour] = c
+	}
+	c.checkins += checkins
+	c.commands += commands
+	c.errors += errors
+}
+
+// Flush adds the counts since the last flush to the stored rollups.
+// Counts that fail to store are kept for the next flush.
+func (rr *RollupRecorder) Flush(ctx context.Context) error {
+	rr.mu.Lock()
+	pending := rr.pending
+	rr.pending = make(map[time.Time]*rollupCounts)
+	rr.mu.Unlock()
+
+	var firstErr error
+	for hour, c := range pending {
+		err := rr.store.AddMetricsRollup(ctx, &storage.MetricsRollup{
+			Period:   storage.RollupHour,
+			Start:    hour,
+			Checkins: c.checkins,
+			Commands: c.commands,
+			Errors:   c.errors,
+		})
+		if err == nil {
+			// the day is only added if the hour was so that a retry
+			// does not count the day twice
+			err = rr.store.AddMetricsRollup(ctx, &storage.MetricsRollup{
+				Period:   storage.RollupDay,
+				Start:    hour.Truncate(24 * time.Hour),
+				Checkins: c.checkins,
+				Commands: c.commands,
+				Errors:   c.errors,
+			})
+			if err != nil {
+				rr.logger.Info("msg", "adding daily rollup", "start", hour, "err", err)
+			}
+			continue
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		rr.mu.Lock()
+		if cur, ok := rr.pending[hour]; ok {
+			cur.checkins += c.checkins
+			cur.commands += c.commands
+			cur.errors += c.errors
+		} else {
+			rr.pending[hour] = c
+		}
+		rr.mu.Unlock()
+	}
+	return firstErr
+}
+
+// Run flushes the counts every interval until ctx is done.
+func (rr *RollupRecorder) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := rr.Flush(ctx); err != nil {
+			rr.logger.Info("msg", "flushing metrics rollups", "err", err)
+		}
+	}
+}
+
+func (rr *RollupRecorder) Authenticate(r *mdm.Request, m *mdm.Authenticate) error {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.Authenticate(r, m)
+}
+
+func (rr *RollupRecorder) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.TokenUpdate(r, m)
+}
+
+func (rr *RollupRecorder) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.CheckOut(r, m)
+}
+
+func (rr *RollupRecorder) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.UserAuthenticate(r, m)
+}
+
+func (rr *RollupRecorder) SetBootstrapToken(r *mdm.Request, m *mdm.SetBootstrapToken) error {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.SetBootstrapToken(r, m)
+}
+
+func (rr *RollupRecorder) GetBootstrapToken(r *mdm.Request, m *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.GetBootstrapToken(r, m)
+}
+
+func (rr *RollupRecorder) DeclarativeManagement(r *mdm.Request, m *mdm.DeclarativeManagement) ([]byte, error) {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.DeclarativeManagement(r, m)
+}
+
+func (rr *RollupRecorder) GetToken(r *mdm.Request, m *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	rr.add(1, 0, 0)
+	return rr.CheckinAndCommandService.GetToken(r, m)
+}
+
+func (rr *RollupRecorder) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	switch results.Status {
+	case "Idle":
+	case "Error":
+		rr.add(0, 1, 1)
+	default:
+		rr.add(0, 1, 0)
+	}
+	return rr.CheckinAndCommandService.CommandAndReportResults(r, results)
+}
@@ -0,0 +1,69 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestRollupRecorder(t *testing.T) {
+	store := new(mock.Storage)
+	var rollups []*storage.MetricsRollup
+	failing := true
+	store.AddMetricsRollupFunc = func(_ context.Context, rollup *storage.MetricsRollup) error {
+		if failing {
+			return errors.New("storage down")
+		}
+		rollups = append(rollups, rollup)
+		return nil
+	}
+	rr := NewRollupRecorder(new(servicemock.Service), store)
+	now := time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC)
+	rr.now = func() time.Time { return now }
+
+	r := &mdm.Request{Context: context.Background()}
+	rr.Authenticate(r, &mdm.Authenticate{})
+	rr.TokenUpdate(r, &mdm.TokenUpdate{})
+	for _, status := range []string{"Idle", "Acknowledged", "Error", "NotNow"} {
+		rr.CommandAndReportResults(r, &mdm.CommandResults{Status: status})
+	}
+
+	// failed flushes are retried
+	if err := rr.Flush(context.Background()); err == nil {
+		t.Fatal("expected flush error")
+	}
+	failing = false
+	rr.CheckOut(r, &mdm.CheckOut{})
+	if err := rr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if have, want := len(rollups), 2; have != want {
+		t.Fatalf("rollups: have %d, want %d", have, want)
+	}
+	hour, day := rollups[0], rollups[1]
+	if hour.Period != storage.RollupHour || !hour.Start.Equal(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected hourly rollup: %+v", hour)
+	}
+	if day.Period != storage.RollupDay || !day.Start.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected daily rollup: %+v", day)
+	}
+	if hour.Checkins != 3 || hour.Commands != 3 || hour.Errors != 1 {
+		t.Errorf("unexpected counts: %+v", hour)
+	}
+
+	// nothing more to flush
+	rollups = nil
+	if err := rr.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 0 {
+		t.Errorf("expected no rollups, got %d", len(rollups))
+	}
+}
@@ -2,6 +2,7 @@
 package nanomdm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -27,6 +28,12 @@ type Service struct {
 
 	// GetToken handler
 	gt service.GetToken
+
+	// enrollment metadata loaded into the request context
+	meta storage.EnrollmentMetadataStore
+
+	// serializes command reports and next command retrieval
+	locker storage.QueueLocker
 }
 
 // normalize generates enrollment IDs that are used by other
@@ -84,6 +91,23 @@ func WithGetToken(gt service.GetToken) Option {
 	}
 }
 
+// WithEnrollmentMetadata loads the enrollment metadata from store into
+// the request context for every request. Downstream services (and
+// handlers) can retrieve it with service.MetadataFromContext.
+func WithEnrollmentMetadata(store storage.EnrollmentMetadataStore) Option {
+	return func(s *Service) {
+		s.meta = store
+	}
+}
+
+// WithQueueLocker locks the command queue of the enrollment with locker
+// while storing a command report and retrieving the next command.
+func WithQueueLocker(locker storage.QueueLocker) Option {
+	return func(s *Service) {
+		s.locker = locker
+	}
+}
+
 // New returns a new NanoMDM main service.
 func New(store storage.ServiceStore, opts ...Option) *Service {
 	nanomdm := &Service{
@@ -109,6 +133,18 @@ func (s *Service) setupRequest(r *mdm.Request, e *mdm.Enrollment) error {
 	}
 	r.Context = newContextWithValues(r.Context, r)
 	r.Context = ctxlog.AddFunc(r.Context, ctxKVs)
+	if s.meta != nil {
+		meta, err := s.meta.RetrieveEnrollmentMetadata(r.Context, r.ID)
+		if err != nil {
+			// metadata is auxiliary: don't fail the request
+			ctxlog.Logger(r.Context, s.logger).Info(
+				"msg", "retrieving enrollment metadata",
+				"err", err,
+			)
+		} else if meta != nil {
+			r.Context = service.NewContextWithMetadata(r.Context, meta)
+		}
+	}
 	return nil
 }
 
@@ -124,18 +160,24 @@ func (s *Service) Authenticate(r *mdm.Request, message *mdm.Authenticate) error
 		logs = append(logs, "serial_number", message.SerialNumber)
 	}
 	ctxlog.Logger(r.Context, s.logger).Info(logs...)
-	if err := s.store.StoreAuthenticate(r, message); err != nil {
-		return err
-	}
-	// clear the command queue for any enrollment or sub-enrollment.
-	// this prevents queued commands still being queued after device
-	// unenrollment.
-	if err := s.store.ClearQueue(r); err != nil {
-		return err
-	}
-	// then, disable the enrollment or any sub-enrollment (because an
-	// enrollment is only valid after a tokenupdate)
-	return s.store.Disable(r)
+	// store the Authenticate in a single transaction (if supported by
+	// the storage) so that enrollments are not left half-disabled.
+	return storage.InTransaction(r.Context, s.store, func(ctx context.Context) error {
+		r := r.Clone()
+		r.Context = ctx
+		if err := s.store.StoreAuthenticate(r, message); err != nil {
+			return err
+		}
+		// clear the command queue for any enrollment or sub-enrollment.
+		// this prevents queued commands still being queued after device
+		// unenrollment.
+		if err := s.store.ClearQueue(r); err != nil {
+			return err
+		}
+		// then, disable the enrollment or any sub-enrollment (because an
+		// enrollment is only valid after a tokenupdate)
+		return storage.DisableWithReason(s.store, r, storage.DisableReasonAuthenticate)
+	})
 }
 
 // TokenUpdate Check-in message implementation.
@@ -153,7 +195,7 @@ func (s *Service) CheckOut(r *mdm.Request, message *mdm.CheckOut) error {
 		return err
 	}
 	ctxlog.Logger(r.Context, s.logger).Info("msg", "CheckOut")
-	return s.store.Disable(r)
+	return storage.DisableWithReason(s.store, r, storage.DisableReasonCheckOut)
 }
 
 // UserAuthenticate Check-in message implementation
@@ -219,6 +261,13 @@ func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandRe
 	if err := s.setupRequest(r, &results.Enrollment); err != nil {
 		return nil, err
 	}
+	if s.locker != nil {
+		unlock, err := s.locker.LockQueue(r.Context, r.ID)
+		if err != nil {
+			return nil, fmt.Errorf("locking queue: %w", err)
+		}
+		defer unlock()
+	}
 	logger := ctxlog.Logger(r.Context, s.logger)
 	logs := []interface{}{
 		"status", results.Status,
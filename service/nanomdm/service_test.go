@@ -0,0 +1,134 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type fauxMetadataStore struct {
+	meta map[string]*storage.EnrollmentMetadata
+}
+
+func (f *fauxMetadataStore) StoreEnrollmentMetadata(_ context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	f.meta[id] = meta
+	return nil
+}
+
+func (f *fauxMetadataStore) RetrieveEnrollmentMetadata(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	return f.meta[id], nil
+}
+
+type ctxCaptureDM struct {
+	meta *storage.EnrollmentMetadata
+}
+
+func (c *ctxCaptureDM) DeclarativeManagement(r *mdm.Request, _ *mdm.DeclarativeManagement) ([]byte, error) {
+	c.meta = service.MetadataFromContext(r.Context)
+	return nil, nil
+}
+
+func TestEnrollmentMetadata(t *testing.T) {
+	meta := &storage.EnrollmentMetadata{Tenant: "acme", Tags: []string{"a", "b"}}
+	store := &fauxMetadataStore{meta: map[string]*storage.EnrollmentMetadata{"AAAA-1111": meta}}
+	dm := &ctxCaptureDM{}
+	s := New(nil, WithDeclarativeManagement(dm), WithEnrollmentMetadata(store))
+
+	msg := &mdm.DeclarativeManagement{Enrollment: mdm.Enrollment{UDID: "AAAA-1111"}}
+	if _, err := s.DeclarativeManagement(newTokenMDMReq(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dm.meta, meta) {
+		t.Errorf("have %v; want %v", dm.meta, meta)
+	}
+
+	msg = &mdm.DeclarativeManagement{Enrollment: mdm.Enrollment{UDID: "BBBB-2222"}}
+	if _, err := s.DeclarativeManagement(newTokenMDMReq(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if dm.meta != nil {
+		t.Errorf("have %v; want nil", dm.meta)
+	}
+}
+
+func TestQueueLocker(t *testing.T) {
+	store := new(mock.Storage)
+	var events []string
+	store.LockQueueFunc = func(_ context.Context, id string) (func(), error) {
+		events = append(events, "lock "+id)
+		return func() { events = append(events, "unlock "+id) }, nil
+	}
+	store.StoreCommandReportFunc = func(_ *mdm.Request, _ *mdm.CommandResults) error {
+		events = append(events, "report")
+		return nil
+	}
+	store.RetrieveNextCommandFunc = func(_ *mdm.Request, _ bool) (*mdm.Command, error) {
+		events = append(events, "next")
+		return nil, nil
+	}
+	s := New(store, WithQueueLocker(store))
+	results := &mdm.CommandResults{Enrollment: mdm.Enrollment{UDID: "AAAA-1111"}, Status: "Idle"}
+	if _, err := s.CommandAndReportResults(newTokenMDMReq(), results); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"lock AAAA-1111", "report", "next", "unlock AAAA-1111"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("have %v; want %v", events, want)
+	}
+}
+
+type txKey struct{}
+
+// txStore is a mock storage with transactions.
+type txStore struct {
+	*mock.Storage
+	events []string
+}
+
+func (s *txStore) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	s.events = append(s.events, "begin")
+	if err := f(context.WithValue(ctx, txKey{}, true)); err != nil {
+		s.events = append(s.events, "rollback")
+		return err
+	}
+	s.events = append(s.events, "commit")
+	return nil
+}
+
+func TestAuthenticateTransaction(t *testing.T) {
+	store := &txStore{Storage: new(mock.Storage)}
+	record := func(r *mdm.Request, event string) {
+		if r.Context.Value(txKey{}) == nil {
+			event += " (no transaction)"
+		}
+		store.events = append(store.events, event)
+	}
+	store.StoreAuthenticateFunc = func(r *mdm.Request, _ *mdm.Authenticate) error {
+		record(r, "authenticate")
+		return nil
+	}
+	store.ClearQueueFunc = func(r *mdm.Request) error {
+		record(r, "clear")
+		return nil
+	}
+	disableErr := errors.New("disable failed")
+	store.DisableFunc = func(r *mdm.Request, _ string) error {
+		record(r, "disable")
+		return disableErr
+	}
+	s := New(store)
+	msg := &mdm.Authenticate{Enrollment: mdm.Enrollment{UDID: "AAAA-1111"}}
+	if err := s.Authenticate(newTokenMDMReq(), msg); !errors.Is(err, disableErr) {
+		t.Fatalf("have %v; want %v", err, disableErr)
+	}
+	want := []string{"begin", "authenticate", "clear", "disable", "rollback"}
+	if !reflect.DeepEqual(store.events, want) {
+		t.Errorf("have %v; want %v", store.events, want)
+	}
+}
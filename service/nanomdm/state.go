@@ -0,0 +1,229 @@
+package nanomdm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// States of an enrollment.
+const (
+	// StateNone is an enrollment that has never Authenticated.
+	StateNone = "none"
+	// StatePending is an enrollment that has Authenticated but not
+	// yet sent a TokenUpdate since.
+	StatePending = "pending"
+	// StateEnrolled is an enabled enrollment.
+	StateEnrolled = "enrolled"
+	// StateDisabled is an enrollment that has been disabled (e.g. by a
+	// CheckOut). It is re-enrolled by a TokenUpdate.
+	StateDisabled = "disabled"
+)
+
+// transitions are the states each device channel check-in message
+// may be received in and the state it moves the enrollment to.
+// Messages not listed are not checked. Authenticate is valid in every
+// state so the StateEnforcer does not check it.
+var transitions = map[string]map[string]string{
+	"Authenticate": {
+		StateNone:     StatePending,
+		StatePending:  StatePending,
+		StateEnrolled: StatePending,
+		StateDisabled: StatePending,
+	},
+	"TokenUpdate": {
+		StatePending:  StateEnrolled,
+		StateEnrolled: StateEnrolled,
+		StateDisabled: StateEnrolled,
+	},
+	"CheckOut": {
+		StatePending:  StateDisabled,
+		StateEnrolled: StateDisabled,
+		StateDisabled: StateDisabled,
+	},
+}
+
+// TransitionError is returned for check-in messages that are invalid
+// in the state of their enrollment. User channel messages are invalid
+// unless their device channel enrollment is enrolled.
+type TransitionError struct {
+	EnrollmentID string `json:"enrollment_id"`
+	MessageType  string `json:"message_type"`
+
+	// State is the state of the enrollment or, for user channel
+	// messages, of its device channel enrollment.
+	State string `json:"state"`
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("invalid %s for %s enrollment %s", e.MessageType, e.State, e.EnrollmentID)
+}
+
+// TransitionFunc is called when an invalid transition is rejected.
+type TransitionFunc func(ctx context.Context, e *TransitionError) error
+
+// StateStore retrieves enrollments and resolves the aliases of
+// Authenticated devices.
+type StateStore interface {
+	storage.EnrollmentRetriever
+	storage.EnrollmentAliasResolver
+}
+
+// StateEnforcer is a service middleware that rejects check-in messages
+// which are invalid for the state of their enrollment, such as a
+// TokenUpdate for a device that never Authenticated. Without it the
+// outcome of such messages depends on the storage backend.
+type StateEnforcer struct {
+	service.CheckinAndCommandService
+	store        StateStore
+	logger       log.Logger
+	onTransition TransitionFunc
+}
+
+// StateEnforcerOption configures a StateEnforcer.
+type StateEnforcerOption func(*StateEnforcer)
+
+// WithStateEnforcerLogger configures a logger on the StateEnforcer.
+func WithStateEnforcerLogger(logger log.Logger) StateEnforcerOption {
+	return func(s *StateEnforcer) {
+		s.logger = logger
+	}
+}
+
+// WithTransitionFunc sets the function called with rejected
+// transitions.
+func WithTransitionFunc(f TransitionFunc) StateEnforcerOption {
+	return func(s *StateEnforcer) {
+		s.onTransition = f
+	}
+}
+
+// NewStateEnforcer creates a new enrollment state enforcing service
+// middleware.
+func NewStateEnforcer(next service.CheckinAndCommandService, store StateStore, opts ...StateEnforcerOption) *StateEnforcer {
+	s := &StateEnforcer{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// state retrieves the state of enrollment id. Devices without an
+// enrollment are pending if any of aliases (from the message) resolve
+// to id as they only resolve after an Authenticate.
+func (s *StateEnforcer) state(ctx context.Context, id string, aliases []string) (string, error) {
+	enrollments, err := s.store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}})
+	if err != nil {
+		return "", fmt.Errorf("retrieving enrollment: %w", err)
+	}
+	if len(enrollments) > 0 {
+		switch e := enrollments[0]; {
+		case e.Enabled:
+			return StateEnrolled, nil
+		case e.DisableReason == storage.DisableReasonAuthenticate:
+			return StatePending, nil
+		}
+		return StateDisabled, nil
+	}
+	if len(aliases) < 1 {
+		return StateNone, nil
+	}
+	resolved, err := s.store.ResolveEnrollmentIDs(ctx, aliases)
+	if err != nil {
+		return "", fmt.Errorf("resolving aliases: %w", err)
+	}
+	for _, alias := range aliases {
+		if resolved[alias] == id {
+			return StatePending, nil
+		}
+	}
+	return StateNone, nil
+}
+
+// enrollmentAliases returns the device identifiers of e.
+func enrollmentAliases(e *mdm.Enrollment) []string {
+	var aliases []string
+	for _, alias := range []string{e.UDID, e.EnrollmentID} {
+		if alias != "" {
+			aliases = append(aliases, alias)
+		}
+	}
+	return aliases
+}
+
+// check checks that messageType is valid for the enrollment of r. User
+// channel messages require an enrolled device channel enrollment.
+func (s *StateEnforcer) check(r *mdm.Request, messageType string, e *mdm.Enrollment) error {
+	if r.EnrollID == nil || r.ID == "" {
+		// not yet normalized (e.g. agent requests)
+		return nil
+	}
+	var tErr *TransitionError
+	if r.ParentID != "" {
+		state, err := s.state(r.Context, r.ParentID, nil)
+		if err != nil {
+			return err
+		}
+		if state == StateEnrolled {
+			return nil
+		}
+		tErr = &TransitionError{EnrollmentID: r.ID, MessageType: messageType, State: state}
+	} else {
+		allowed, ok := transitions[messageType]
+		if !ok {
+			return nil
+		}
+		state, err := s.state(r.Context, r.ID, enrollmentAliases(e))
+		if err != nil {
+			return err
+		}
+		if _, ok = allowed[state]; ok {
+			return nil
+		}
+		tErr = &TransitionError{EnrollmentID: r.ID, MessageType: messageType, State: state}
+	}
+	logger := ctxlog.Logger(r.Context, s.logger)
+	logger.Info(
+		"msg", "invalid state transition",
+		"message_type", tErr.MessageType,
+		"state", tErr.State,
+	)
+	if s.onTransition != nil {
+		if err := s.onTransition(r.Context, tErr); err != nil {
+			logger.Info("msg", "invalid state transition", "err", err)
+		}
+	}
+	return service.NewHTTPStatusError(http.StatusBadRequest, tErr)
+}
+
+func (s *StateEnforcer) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := s.check(r, "TokenUpdate", &m.Enrollment); err != nil {
+		return err
+	}
+	return s.CheckinAndCommandService.TokenUpdate(r, m)
+}
+
+func (s *StateEnforcer) CheckOut(r *mdm.Request, m *mdm.CheckOut) error {
+	if err := s.check(r, "CheckOut", &m.Enrollment); err != nil {
+		return err
+	}
+	return s.CheckinAndCommandService.CheckOut(r, m)
+}
+
+func (s *StateEnforcer) UserAuthenticate(r *mdm.Request, m *mdm.UserAuthenticate) ([]byte, error) {
+	if err := s.check(r, "UserAuthenticate", &m.Enrollment); err != nil {
+		return nil, err
+	}
+	return s.CheckinAndCommandService.UserAuthenticate(r, m)
+}
@@ -0,0 +1,68 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage/file"
+
+	"github.com/groob/plist"
+)
+
+func TestStateEnforcer(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected []*TransitionError
+	s := NewStateEnforcer(New(store), store, WithTransitionFunc(
+		func(_ context.Context, e *TransitionError) error {
+			rejected = append(rejected, e)
+			return nil
+		},
+	))
+	checkin := func(id string, msg map[string]interface{}) error {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: id, Type: mdm.Device}}
+		msg["UDID"] = id
+		msg["Topic"] = "com.example"
+		b, err := plist.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = service.CheckinRequest(s, r, b)
+		return err
+	}
+	authenticate := map[string]interface{}{"MessageType": "Authenticate"}
+	tokenUpdate := map[string]interface{}{"MessageType": "TokenUpdate", "Token": []byte("token"), "PushMagic": "magic"}
+	checkOut := map[string]interface{}{"MessageType": "CheckOut"}
+
+	// never Authenticated
+	for _, msg := range []map[string]interface{}{tokenUpdate, checkOut} {
+		err := checkin("DEV1", msg)
+		var tErr *TransitionError
+		if !errors.As(err, &tErr) || tErr.State != StateNone {
+			t.Fatalf("expected transition error: %v", err)
+		}
+		var statusErr *service.HTTPStatusError
+		if !errors.As(err, &statusErr) || statusErr.Status != 400 {
+			t.Errorf("expected HTTP 400 error: %v", err)
+		}
+	}
+	if have, want := len(rejected), 2; have != want {
+		t.Fatalf("rejected: have %d, want %d", have, want)
+	}
+
+	// pending → enrolled → disabled → re-enrolled
+	for _, msg := range []map[string]interface{}{authenticate, tokenUpdate, tokenUpdate, checkOut, authenticate, tokenUpdate} {
+		if err := checkin("DEV1", msg); err != nil {
+			t.Fatalf("%s: %v", msg["MessageType"], err)
+		}
+	}
+	if have, want := len(rejected), 2; have != want {
+		t.Errorf("rejected: have %d, want %d", have, want)
+	}
+}
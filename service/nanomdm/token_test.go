@@ -3,7 +3,13 @@ package nanomdm
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/groob/plist"
 	"github.com/micromdm/nanomdm/mdm"
@@ -95,3 +101,45 @@ func TestToken(t *testing.T) {
 		t.Fatal("should be an error")
 	}
 }
+
+func TestSignedToken(t *testing.T) {
+	key := []byte("secret")
+	tok := NewSignedToken(key, WithSignedTokenIssuer("test"))
+	tok.now = func() time.Time { return time.Unix(1000, 0) }
+
+	s := New(nil, WithGetToken(tok))
+	resp, err := s.GetToken(newTokenMDMReq(), newGetToken("com.apple.maid", "AAAA-1111"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(string(resp.TokenData), ".")
+	if len(parts) != 3 {
+		t.Fatalf("invalid token parts: %d", len(parts))
+	}
+
+	// verify the signature
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if have, want := parts[2], base64.RawURLEncoding.EncodeToString(mac.Sum(nil)); have != want {
+		t.Errorf("signature: have %q; want %q", have, want)
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := new(signedTokenClaims)
+	if err = json.Unmarshal(claimsJSON, claims); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := claims.Subject, "AAAA-1111"; have != want {
+		t.Errorf("sub: have %q; want %q", have, want)
+	}
+	if have, want := claims.Audience, "com.apple.maid"; have != want {
+		t.Errorf("aud: have %q; want %q", have, want)
+	}
+	if have, want := claims.ExpiresAt, int64(1000+3600); have != want {
+		t.Errorf("exp: have %d; want %d", have, want)
+	}
+}
@@ -0,0 +1,141 @@
+package nanomdm
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+)
+
+// GetTokenHTTPCaller is a GetToken handler that calls out to an HTTP
+// service to retrieve the token data.
+type GetTokenHTTPCaller struct {
+	url    string
+	client *http.Client
+}
+
+// NewGetTokenHTTPCaller creates a new GetTokenHTTPCaller.
+// The raw GetToken check-in message is sent as the body of an HTTP
+// POST to url and the response body is used as the token data.
+func NewGetTokenHTTPCaller(url string, client *http.Client) *GetTokenHTTPCaller {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &GetTokenHTTPCaller{url: url, client: client}
+}
+
+// GetToken calls out to an HTTP URL to retrieve the token data.
+func (c *GetTokenHTTPCaller) GetToken(r *mdm.Request, message *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	if c.url == "" {
+		return nil, errors.New("missing URL")
+	}
+	req, err := http.NewRequestWithContext(r.Context, http.MethodPost, c.url, bytes.NewBuffer(message.Raw))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(enrollmentIDHeader, r.ID)
+	req.Header.Set("Content-Type", "application/x-apple-aspen-mdm-checkin")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, service.NewHTTPStatusError(
+			resp.StatusCode,
+			fmt.Errorf("unexpected HTTP status: %s", resp.Status),
+		)
+	}
+	return &mdm.GetTokenResponse{TokenData: bodyBytes}, nil
+}
+
+// SignedToken is a GetToken handler that issues HMAC-SHA256 signed JSON
+// Web Tokens (i.e. "HS256" JWTs) for the requesting enrollment.
+// The "sub" claim is the enrollment ID and the "aud" claim is the
+// TokenServiceType of the GetToken request.
+type SignedToken struct {
+	key    []byte
+	issuer string
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// SignedTokenOption configures a SignedToken.
+type SignedTokenOption func(*SignedToken)
+
+// WithSignedTokenIssuer sets the "iss" claim of issued tokens.
+func WithSignedTokenIssuer(issuer string) SignedTokenOption {
+	return func(t *SignedToken) {
+		t.issuer = issuer
+	}
+}
+
+// WithSignedTokenTTL sets the validity duration of issued tokens.
+func WithSignedTokenTTL(ttl time.Duration) SignedTokenOption {
+	return func(t *SignedToken) {
+		t.ttl = ttl
+	}
+}
+
+// NewSignedToken creates a new signing-key based token issuer.
+func NewSignedToken(key []byte, opts ...SignedTokenOption) *SignedToken {
+	t := &SignedToken{
+		key: key,
+		ttl: time.Hour,
+		now: time.Now,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+type signedTokenClaims struct {
+	Issuer    string `json:"iss,omitempty"`
+	Subject   string `json:"sub"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// signedTokenHeader is the static base64url-encoded JWT header.
+var signedTokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// GetToken issues a signed token for the requesting enrollment.
+func (t *SignedToken) GetToken(r *mdm.Request, message *mdm.GetToken) (*mdm.GetTokenResponse, error) {
+	if len(t.key) < 1 {
+		return nil, errors.New("missing signing key")
+	}
+	if r.EnrollID == nil || r.ID == "" {
+		return nil, errors.New("missing enrollment ID")
+	}
+	now := t.now()
+	claims, err := json.Marshal(&signedTokenClaims{
+		Issuer:    t.issuer,
+		Subject:   r.ID,
+		Audience:  message.TokenServiceType,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(t.ttl).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signingInput := signedTokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(signingInput))
+	token := signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	return &mdm.GetTokenResponse{TokenData: []byte(token)}, nil
+}
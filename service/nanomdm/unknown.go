@@ -0,0 +1,103 @@
+package nanomdm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Responses to command polls of unknown enrollments.
+const (
+	// UnknownEmpty replies without a command (as for an empty queue)
+	// and without storing the command report.
+	UnknownEmpty = "empty"
+	// UnknownUnauthorized replies with an HTTP 401.
+	UnknownUnauthorized = "unauthorized"
+	// UnknownGone replies with an HTTP 410 which prompts devices to
+	// unenroll.
+	UnknownGone = "gone"
+)
+
+// ErrUnknownEnrollment is returned for command polls of enrollments
+// that are not known to storage.
+var ErrUnknownEnrollment = errors.New("unknown enrollment")
+
+// UnknownPollResponder is a service middleware that responds to the
+// command polls (command reports and next command requests) of
+// enrollments that have no stored enrollment with a configured
+// response. Without it unknown enrollments are handled by the storage
+// backend which usually finds no commands but may fail to store the
+// command report.
+type UnknownPollResponder struct {
+	service.CheckinAndCommandService
+	store    storage.EnrollmentRetriever
+	response string
+	logger   log.Logger
+}
+
+// UnknownPollResponderOption configures an UnknownPollResponder.
+type UnknownPollResponderOption func(*UnknownPollResponder)
+
+// WithUnknownPollResponderLogger configures a logger on the
+// UnknownPollResponder.
+func WithUnknownPollResponderLogger(logger log.Logger) UnknownPollResponderOption {
+	return func(u *UnknownPollResponder) {
+		u.logger = logger
+	}
+}
+
+// NewUnknownPollResponder creates a new service middleware replying
+// to the command polls of unknown enrollments with response which is
+// one of UnknownEmpty, UnknownUnauthorized, or UnknownGone.
+func NewUnknownPollResponder(next service.CheckinAndCommandService, store storage.EnrollmentRetriever, response string, opts ...UnknownPollResponderOption) (*UnknownPollResponder, error) {
+	switch response {
+	case UnknownEmpty, UnknownUnauthorized, UnknownGone:
+	default:
+		return nil, fmt.Errorf("invalid unknown enrollment response: %q", response)
+	}
+	u := &UnknownPollResponder{
+		CheckinAndCommandService: next,
+		store:                    store,
+		response:                 response,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u, nil
+}
+
+// CommandAndReportResults replies with the configured response if the
+// enrollment of r is unknown and calls the next service otherwise.
+func (u *UnknownPollResponder) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	if r.EnrollID == nil || r.ID == "" {
+		// not yet normalized (e.g. agent requests)
+		return u.CheckinAndCommandService.CommandAndReportResults(r, results)
+	}
+	enrollments, err := u.store.RetrieveEnrollments(r.Context, &storage.EnrollmentFilter{IDs: []string{r.ID}})
+	if err != nil {
+		return nil, fmt.Errorf("retrieving enrollment: %w", err)
+	}
+	if len(enrollments) > 0 {
+		return u.CheckinAndCommandService.CommandAndReportResults(r, results)
+	}
+	ctxlog.Logger(r.Context, u.logger).Info(
+		"msg", "command poll of unknown enrollment",
+		"status", results.Status,
+		"response", u.response,
+	)
+	switch u.response {
+	case UnknownUnauthorized:
+		return nil, service.NewHTTPStatusError(http.StatusUnauthorized, ErrUnknownEnrollment)
+	case UnknownGone:
+		return nil, service.NewHTTPStatusError(http.StatusGone, ErrUnknownEnrollment)
+	}
+	return nil, nil
+}
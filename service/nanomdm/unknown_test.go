@@ -0,0 +1,67 @@
+package nanomdm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	storagemock "github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestUnknownPollResponder(t *testing.T) {
+	store := new(storagemock.Storage)
+	store.RetrieveEnrollmentsFunc = func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		if filter.IDs[0] == "KNOWN" {
+			return []*storage.Enrollment{{ID: "KNOWN"}}, nil
+		}
+		return nil, nil
+	}
+	next := new(mock.Service)
+	next.CommandAndReportResultsFunc = func(*mdm.Request, *mdm.CommandResults) (*mdm.Command, error) {
+		return &mdm.Command{CommandUUID: "CMD1"}, nil
+	}
+	if _, err := NewUnknownPollResponder(next, store, "bogus"); err == nil {
+		t.Error("expected invalid response error")
+	}
+	poll := func(s service.CheckinAndCommandService, id string) (*mdm.Command, error) {
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: id}}
+		return s.CommandAndReportResults(r, &mdm.CommandResults{Status: "Idle"})
+	}
+
+	for _, test := range []struct {
+		response string
+		status   int
+	}{
+		{UnknownEmpty, 0},
+		{UnknownUnauthorized, http.StatusUnauthorized},
+		{UnknownGone, http.StatusGone},
+	} {
+		u, err := NewUnknownPollResponder(next, store, test.response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd, err := poll(u, "KNOWN"); err != nil || cmd == nil {
+			t.Errorf("%s: known enrollment: have %v, %v", test.response, cmd, err)
+		}
+		cmd, err := poll(u, "UNKNOWN")
+		if cmd != nil {
+			t.Errorf("%s: unexpected command: %v", test.response, cmd)
+		}
+		var statusErr *service.HTTPStatusError
+		if test.status == 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.response, err)
+			}
+		} else if !errors.As(err, &statusErr) || statusErr.Status != test.status || !errors.Is(err, ErrUnknownEnrollment) {
+			t.Errorf("%s: unexpected error: %v", test.response, err)
+		}
+	}
+	if have, want := len(next.Calls("CommandAndReportResults")), 3; have != want {
+		t.Errorf("next calls: have %d, want %d", have, want)
+	}
+}
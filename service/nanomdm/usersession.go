@@ -0,0 +1,115 @@
+package nanomdm
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// UserSessionChangeFunc is called when the current user session of
+// deviceID changes. The previous session is nil for the first user
+// session of a device.
+type UserSessionChangeFunc func(ctx context.Context, deviceID string, sessions *storage.UserSessions) error
+
+// UserSessionTracker is a service middleware that records the user of
+// user channel TokenUpdates as the current user session of the device,
+// such as the user logged in to a Shared iPad.
+type UserSessionTracker struct {
+	service.CheckinAndCommandService
+	store    storage.UserSessionStore
+	logger   log.Logger
+	onChange UserSessionChangeFunc
+}
+
+// UserSessionTrackerOption configures a UserSessionTracker.
+type UserSessionTrackerOption func(*UserSessionTracker)
+
+// WithUserSessionTrackerLogger configures a logger on the UserSessionTracker.
+func WithUserSessionTrackerLogger(logger log.Logger) UserSessionTrackerOption {
+	return func(t *UserSessionTracker) {
+		t.logger = logger
+	}
+}
+
+// WithUserSessionChangeFunc sets the function called when the current
+// user session of a device changes.
+func WithUserSessionChangeFunc(f UserSessionChangeFunc) UserSessionTrackerOption {
+	return func(t *UserSessionTracker) {
+		t.onChange = f
+	}
+}
+
+// NewUserSessionTracker creates a new user session tracking service middleware.
+func NewUserSessionTracker(next service.CheckinAndCommandService, store storage.UserSessionStore, opts ...UserSessionTrackerOption) *UserSessionTracker {
+	t := &UserSessionTracker{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// currentSessionID retrieves the enrollment ID of the current user
+// session of deviceID. An empty string is returned if there is none.
+func (t *UserSessionTracker) currentSessionID(ctx context.Context, deviceID string) (string, error) {
+	sessions, err := t.store.RetrieveUserSessions(ctx, []string{deviceID})
+	if err != nil {
+		return "", err
+	}
+	if sessions[deviceID] == nil || sessions[deviceID].Current == nil {
+		return "", nil
+	}
+	return sessions[deviceID].Current.EnrollmentID, nil
+}
+
+// TokenUpdate calls the next service and then, for user channel
+// TokenUpdates, records the user session of the device.
+// Errors recording the session are logged but otherwise ignored.
+func (t *UserSessionTracker) TokenUpdate(r *mdm.Request, m *mdm.TokenUpdate) error {
+	err := t.CheckinAndCommandService.TokenUpdate(r, m)
+	if err != nil || r.EnrollID == nil || r.ParentID == "" {
+		return err
+	}
+	logger := ctxlog.Logger(r.Context, t.logger)
+	prevID, err := t.currentSessionID(r.Context, r.ParentID)
+	if err != nil {
+		logger.Info("msg", "retrieving user session", "err", err)
+		return nil
+	}
+	if err = t.store.StoreUserSession(r, m.UserShortName, m.UserLongName); err != nil {
+		logger.Info("msg", "storing user session", "err", err)
+		return nil
+	}
+	if prevID == r.ID {
+		return nil
+	}
+	logger.Debug(
+		"msg", "user session changed",
+		"device_id", r.ParentID,
+		"previous_id", prevID,
+		"user_short_name", m.UserShortName,
+	)
+	if t.onChange == nil {
+		return nil
+	}
+	sessions, err := t.store.RetrieveUserSessions(r.Context, []string{r.ParentID})
+	if err != nil {
+		logger.Info("msg", "retrieving user session", "err", err)
+		return nil
+	}
+	if sessions[r.ParentID] == nil {
+		return nil
+	}
+	if err = t.onChange(r.Context, r.ParentID, sessions[r.ParentID]); err != nil {
+		logger.Info("msg", "user session change", "err", err)
+	}
+	return nil
+}
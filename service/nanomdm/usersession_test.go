@@ -0,0 +1,58 @@
+package nanomdm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+type fauxTokenUpdate struct {
+	service.CheckinAndCommandService
+}
+
+func (f *fauxTokenUpdate) TokenUpdate(_ *mdm.Request, _ *mdm.TokenUpdate) error {
+	return nil
+}
+
+func TestUserSessionTracker(t *testing.T) {
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []*storage.UserSessions
+	tracker := NewUserSessionTracker(&fauxTokenUpdate{}, store, WithUserSessionChangeFunc(
+		func(_ context.Context, deviceID string, sessions *storage.UserSessions) error {
+			if deviceID != "DEV1" {
+				t.Errorf("device id: have %q, want %q", deviceID, "DEV1")
+			}
+			changes = append(changes, sessions)
+			return nil
+		},
+	))
+	tokenUpdate := func(id, parentID, shortName string) {
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: id, ParentID: parentID}}
+		msg := &mdm.TokenUpdate{Enrollment: mdm.Enrollment{UserShortName: shortName}}
+		if err := tracker.TokenUpdate(r, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokenUpdate("DEV1", "", "")
+	tokenUpdate("DEV1:A", "DEV1", "a@example.com")
+	tokenUpdate("DEV1:A", "DEV1", "a@example.com")
+	tokenUpdate("DEV1:B", "DEV1", "b@example.com")
+
+	if have, want := len(changes), 2; have != want {
+		t.Fatalf("changes: have %d, want %d", have, want)
+	}
+	if changes[0].Current.UserShortName != "a@example.com" || changes[0].Previous != nil {
+		t.Errorf("unexpected first change: %+v", changes[0])
+	}
+	if changes[1].Current.UserShortName != "b@example.com" || changes[1].Previous == nil || changes[1].Previous.EnrollmentID != "DEV1:A" {
+		t.Errorf("unexpected second change: %+v", changes[1])
+	}
+}
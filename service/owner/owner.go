@@ -0,0 +1,147 @@
+// Package owner forwards command results to the external systems that
+// own the commands.
+//
+// A command is owned by the external system (e.g. one of several
+// automation systems sharing a NanoMDM instance) that enqueued it with
+// an owner. Results of owned commands are delivered to the owner's URL
+// in addition to the generic webhook so that owners do not need to
+// filter each other's results.
+package owner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Owner is an external system that owns commands.
+type Owner struct {
+	Name string `json:"name"`
+
+	// URL receives the results of the owner's commands as webhook
+	// events.
+	URL string `json:"url"`
+}
+
+// LoadOwners reads a JSON list of owners from path.
+func LoadOwners(path string) ([]*Owner, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var owners []*Owner
+	return owners, json.Unmarshal(b, &owners)
+}
+
+// Service is a service middleware that forwards the results of owned
+// commands to their owners.
+type Service struct {
+	service.CheckinAndCommandService
+	store    storage.CommandOwnerStore
+	logger   log.Logger
+	webhooks map[string]*microwebhook.MicroWebhook
+	whOpts   []microwebhook.Option
+
+	forwarded atomic.Int64
+	failed    atomic.Int64
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithWebhookOptions configures the webhooks delivering results to
+// owners (e.g. the HTTP client and event version).
+func WithWebhookOptions(opts ...microwebhook.Option) Option {
+	return func(s *Service) {
+		s.whOpts = append(s.whOpts, opts...)
+	}
+}
+
+// New creates a new result forwarding service middleware. Command
+// owners are retrieved from store.
+func New(next service.CheckinAndCommandService, store storage.CommandOwnerStore, owners []*Owner, opts ...Option) (*Service, error) {
+	s := &Service{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+		webhooks:                 make(map[string]*microwebhook.MicroWebhook),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for i, o := range owners {
+		if o.Name == "" {
+			return nil, fmt.Errorf("owner %d: empty name", i)
+		}
+		if o.URL == "" {
+			return nil, fmt.Errorf("owner %s: empty URL", o.Name)
+		}
+		if _, ok := s.webhooks[o.Name]; ok {
+			return nil, fmt.Errorf("duplicate owner: %s", o.Name)
+		}
+		s.webhooks[o.Name] = microwebhook.New(o.URL, nil, s.whOpts...)
+	}
+	return s, nil
+}
+
+// Owner reports whether name is a configured owner.
+func (s *Service) Owner(name string) bool {
+	_, ok := s.webhooks[name]
+	return ok
+}
+
+// Metrics returns the result forwarding counters.
+func (s *Service) Metrics() interface{} {
+	return map[string]int64{
+		"forwarded": s.forwarded.Load(),
+		"failed":    s.failed.Load(),
+	}
+}
+
+// CommandAndReportResults calls the next service and then forwards the
+// results of owned commands to their owner. Errors forwarding results
+// are logged but otherwise ignored.
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := s.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || results.CommandUUID == "" || results.Status == "Idle" {
+		return cmd, err
+	}
+	logger := ctxlog.Logger(r.Context, s.logger).With("command_uuid", results.CommandUUID)
+	owner, err := s.store.RetrieveCommandOwner(r.Context, results.CommandUUID)
+	if err != nil {
+		logger.Info("msg", "retrieving command owner", "err", err)
+		return cmd, nil
+	}
+	if owner == "" {
+		return cmd, nil
+	}
+	webhook, ok := s.webhooks[owner]
+	if !ok {
+		logger.Info("msg", "unknown command owner", "owner", owner)
+		return cmd, nil
+	}
+	if _, err = webhook.CommandAndReportResults(r, results); err != nil {
+		s.failed.Add(1)
+		logger.Info("msg", "forwarding results to owner", "owner", owner, "err", err)
+		return cmd, nil
+	}
+	s.forwarded.Add(1)
+	logger.Debug("msg", "forwarded results to owner", "owner", owner, "status", results.Status)
+	return cmd, nil
+}
@@ -0,0 +1,82 @@
+package owner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestService(t *testing.T) {
+	var events []*microwebhook.Event
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := new(microwebhook.Event)
+		if err := json.NewDecoder(r.Body).Decode(ev); err != nil {
+			t.Error(err)
+			return
+		}
+		events = append(events, ev)
+	}))
+	defer srv.Close()
+
+	store := new(mock.Storage)
+	store.RetrieveCommandOwnerFunc = func(_ context.Context, uuid string) (string, error) {
+		switch uuid {
+		case "CMD1":
+			return "automation", nil
+		case "CMD2":
+			return "retired", nil
+		}
+		return "", nil
+	}
+	next := new(servicemock.Service)
+	next.CommandAndReportResultsFunc = func(_ *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+		return nil, nil
+	}
+	s, err := New(next, store, []*Owner{{Name: "automation", URL: srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Owner("automation") || s.Owner("retired") {
+		t.Error("unexpected owners")
+	}
+
+	report := func(uuid, status string) {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "DEV1"}}
+		results := &mdm.CommandResults{CommandUUID: uuid, Status: status, Raw: []byte("<plist/>")}
+		if _, err := s.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("", "Idle")
+	report("CMD1", "Acknowledged")
+	report("CMD2", "Acknowledged")
+	report("CMD3", "Error")
+
+	if len(events) != 1 {
+		t.Fatalf("events: have %d, want 1", len(events))
+	}
+	if ev := events[0].AcknowledgeEvent; ev == nil || ev.CommandUUID != "CMD1" || ev.Status != "Acknowledged" {
+		t.Errorf("unexpected event: %+v", ev)
+	}
+	if have := s.Metrics().(map[string]int64)["forwarded"]; have != 1 {
+		t.Errorf("forwarded: have %d, want 1", have)
+	}
+
+	for _, owners := range [][]*Owner{
+		{{Name: "", URL: srv.URL}},
+		{{Name: "a"}},
+		{{Name: "a", URL: srv.URL}, {Name: "a", URL: srv.URL}},
+	} {
+		if _, err = New(next, store, owners); err == nil {
+			t.Errorf("expected error for owners %+v", owners)
+		}
+	}
+}
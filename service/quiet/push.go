@@ -0,0 +1,99 @@
+package quiet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ErrSuppressed is the push response error for suppressed pushes.
+var ErrSuppressed = errors.New("push suppressed in quiet window")
+
+// CommandRetriever retrieves the next queued command for an enrollment.
+type CommandRetriever interface {
+	RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error)
+}
+
+// Pusher is a push middleware that suppresses pushes to enrollments in
+// a quiet window unless their next queued command is urgent.
+// Suppressed pushes are not retried when the window ends.
+type Pusher struct {
+	next     push.Pusher
+	schedule *Schedule
+	store    CommandRetriever
+	logger   log.Logger
+
+	suppressed atomic.Int64
+}
+
+// NewPusher creates a new quiet window push middleware. The store is
+// used to check whether the next queued command is urgent.
+func NewPusher(next push.Pusher, schedule *Schedule, store CommandRetriever, logger log.Logger) *Pusher {
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	return &Pusher{next: next, schedule: schedule, store: store, logger: logger}
+}
+
+// Metrics returns the quiet window push counters.
+func (p *Pusher) Metrics() interface{} {
+	return map[string]int64{
+		"suppressed": p.suppressed.Load(),
+	}
+}
+
+// urgent reports whether the next queued command for id is urgent.
+func (p *Pusher) urgent(ctx context.Context, id string) (bool, error) {
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id}}
+	cmd, err := p.store.RetrieveNextCommand(r, true)
+	if err != nil {
+		return false, fmt.Errorf("retrieving next command: %w", err)
+	}
+	return cmd != nil && p.schedule.UrgentCommand(cmd), nil
+}
+
+// Push sends pushes to ids that are not in a quiet window or whose next
+// queued command is urgent. Suppressed pushes have an ErrSuppressed
+// response error.
+func (p *Pusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	logger := ctxlog.Logger(ctx, p.logger)
+	now := p.schedule.now()
+	ret := make(map[string]*push.Response)
+	var send []string
+	for _, id := range ids {
+		quiet, err := p.schedule.Quiet(ctx, id, now)
+		if err == nil && quiet {
+			quiet, err = p.urgent(ctx, id)
+			quiet = !quiet
+		}
+		if err != nil {
+			// deliver the push if we can't tell
+			logger.Info("msg", "checking quiet window", "id", id, "err", err)
+			quiet = false
+		}
+		if quiet {
+			ret[id] = &push.Response{Err: ErrSuppressed}
+			continue
+		}
+		send = append(send, id)
+	}
+	if len(ret) > 0 {
+		p.suppressed.Add(int64(len(ret)))
+		logger.Debug("msg", "suppressed pushes in quiet window", "count", len(ret))
+	}
+	if len(send) < 1 {
+		return ret, nil
+	}
+	resps, err := p.next.Push(ctx, send)
+	for id, resp := range resps {
+		ret[id] = resp
+	}
+	return ret, err
+}
@@ -0,0 +1,206 @@
+// Package quiet implements "do not disturb" quiet hours for enrollments.
+//
+// During a quiet window non-urgent commands are withheld from devices
+// and push notifications are suppressed. Commands with an urgent
+// request type (e.g. DeviceLock) or an urgent priority are exempt. Windows can be limited to
+// enrollments with particular enrollment metadata groups or tags.
+package quiet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// DefaultUrgentRequestTypes are the command request types that are
+// exempt from quiet windows by default.
+var DefaultUrgentRequestTypes = []string{
+	"ClearPasscode",
+	"DeviceLocation",
+	"DeviceLock",
+	"DisableLostMode",
+	"EnableLostMode",
+	"EraseDevice",
+	"PlayLostModeSound",
+}
+
+// Window is a daily quiet window.
+type Window struct {
+	// Start and End are the "HH:MM" local times of the window. Windows
+	// that end before they start span midnight.
+	Start string `json:"start"`
+	End   string `json:"end"`
+
+	// Timezone is the IANA time zone name of Start and End. Defaults
+	// to the server's local time zone.
+	Timezone string `json:"timezone,omitempty"`
+
+	// Groups and Tags limit the window to enrollments with any of
+	// these enrollment metadata groups or tags. The window applies to
+	// all enrollments if both are empty.
+	Groups []string `json:"groups,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+
+	start, end int // minutes since midnight
+	loc        *time.Location
+}
+
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, fmt.Errorf("invalid time: %q", s)
+	}
+	hour, err := strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, fmt.Errorf("invalid hour: %q", s)
+	}
+	min, err := strconv.Atoi(m)
+	if err != nil || min < 0 || min > 59 {
+		return 0, fmt.Errorf("invalid minute: %q", s)
+	}
+	return hour*60 + min, nil
+}
+
+// init parses and validates w.
+func (w *Window) init() error {
+	var err error
+	if w.start, err = parseClock(w.Start); err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	if w.end, err = parseClock(w.End); err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if w.start == w.end {
+		return errors.New("empty window")
+	}
+	w.loc = time.Local
+	if w.Timezone != "" {
+		if w.loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+	}
+	return nil
+}
+
+// contains reports whether t is inside the window.
+func (w *Window) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	min := t.Hour()*60 + t.Minute()
+	if w.start < w.end {
+		return min >= w.start && min < w.end
+	}
+	// spans midnight
+	return min >= w.start || min < w.end
+}
+
+// matches reports whether the window applies to an enrollment with meta.
+func (w *Window) matches(meta *storage.EnrollmentMetadata) bool {
+	if len(w.Groups) < 1 && len(w.Tags) < 1 {
+		return true
+	}
+	if meta == nil {
+		return false
+	}
+	return anyOf(w.Groups, meta.Groups) || anyOf(w.Tags, meta.Tags)
+}
+
+func anyOf(want, have []string) bool {
+	for _, w := range want {
+		for _, h := range have {
+			if w == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Schedule determines whether enrollments are in a quiet window.
+type Schedule struct {
+	windows []Window
+	store   storage.EnrollmentMetadataStore
+	urgent  map[string]bool
+	now     func() time.Time
+}
+
+// NewSchedule creates a new schedule of windows. The store is used to
+// look up enrollment groups and tags for windows that have them. If
+// urgent is nil DefaultUrgentRequestTypes is used.
+func NewSchedule(windows []Window, store storage.EnrollmentMetadataStore, urgent []string) (*Schedule, error) {
+	s := &Schedule{store: store, urgent: make(map[string]bool), now: time.Now}
+	for i := range windows {
+		w := windows[i]
+		if err := w.init(); err != nil {
+			return nil, fmt.Errorf("window %d: %w", i, err)
+		}
+		if (len(w.Groups) > 0 || len(w.Tags) > 0) && store == nil {
+			return nil, fmt.Errorf("window %d: groups or tags require a metadata store", i)
+		}
+		s.windows = append(s.windows, w)
+	}
+	if urgent == nil {
+		urgent = DefaultUrgentRequestTypes
+	}
+	for _, requestType := range urgent {
+		s.urgent[requestType] = true
+	}
+	return s, nil
+}
+
+// ParseWindows parses a JSON list of windows.
+func ParseWindows(b []byte) ([]Window, error) {
+	var windows []Window
+	return windows, json.Unmarshal(b, &windows)
+}
+
+// LoadWindows reads a JSON list of windows from the file at path.
+func LoadWindows(path string) ([]Window, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseWindows(b)
+}
+
+// Urgent reports whether commands of requestType are exempt from quiet
+// windows.
+func (s *Schedule) Urgent(requestType string) bool {
+	return s.urgent[requestType]
+}
+
+// UrgentCommand reports whether cmd is exempt from quiet windows: it
+// has an urgent request type or at least urgent priority.
+func (s *Schedule) UrgentCommand(cmd *mdm.Command) bool {
+	return cmd.Priority >= mdm.PriorityUrgent || s.Urgent(cmd.Command.RequestType)
+}
+
+// Quiet reports whether enrollment id is in a quiet window at t.
+func (s *Schedule) Quiet(ctx context.Context, id string, t time.Time) (bool, error) {
+	var meta *storage.EnrollmentMetadata
+	var metaLoaded bool
+	for i := range s.windows {
+		w := &s.windows[i]
+		if !w.contains(t) {
+			continue
+		}
+		if (len(w.Groups) > 0 || len(w.Tags) > 0) && !metaLoaded {
+			var err error
+			if meta, err = s.store.RetrieveEnrollmentMetadata(ctx, id); err != nil {
+				return false, fmt.Errorf("retrieving metadata: %w", err)
+			}
+			metaLoaded = true
+		}
+		if w.matches(meta) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
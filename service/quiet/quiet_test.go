@@ -0,0 +1,172 @@
+package quiet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func at(hour, min int) time.Time {
+	return time.Date(2024, 1, 1, hour, min, 0, 0, time.UTC)
+}
+
+func TestSchedule(t *testing.T) {
+	windows, err := ParseWindows([]byte(`[
+		{"start": "22:00", "end": "07:00", "timezone": "UTC", "groups": ["lab"]},
+		{"start": "12:00", "end": "13:00", "timezone": "UTC", "tags": ["lunch"]}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := new(mock.Storage)
+	store.RetrieveEnrollmentMetadataFunc = func(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+		switch id {
+		case "lab1":
+			return &storage.EnrollmentMetadata{Groups: []string{"lab"}}, nil
+		case "lunch1":
+			return &storage.EnrollmentMetadata{Tags: []string{"lunch"}}, nil
+		}
+		return nil, nil
+	}
+	s, err := NewSchedule(windows, store, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		id    string
+		t     time.Time
+		quiet bool
+	}{
+		{"lab1", at(23, 0), true},
+		{"lab1", at(3, 0), true},
+		{"lab1", at(7, 0), false},
+		{"lab1", at(12, 30), false},
+		{"lunch1", at(12, 30), true},
+		{"lunch1", at(23, 0), false},
+		{"other", at(23, 0), false},
+	} {
+		quiet, err := s.Quiet(context.Background(), test.id, test.t)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if quiet != test.quiet {
+			t.Errorf("%s at %s: have %v, want %v", test.id, test.t.Format("15:04"), quiet, test.quiet)
+		}
+	}
+
+	if _, err = NewSchedule([]Window{{Start: "25:00", End: "07:00"}}, nil, nil); err == nil {
+		t.Error("expected invalid window error")
+	}
+	if _, err = NewSchedule([]Window{{Start: "22:00", End: "07:00", Groups: []string{"lab"}}}, nil, nil); err == nil {
+		t.Error("expected missing store error")
+	}
+}
+
+func newTestSchedule(t *testing.T, quiet bool) *Schedule {
+	t.Helper()
+	s, err := NewSchedule([]Window{{Start: "22:00", End: "07:00", Timezone: "UTC"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = func() time.Time { return at(12, 0) }
+	if quiet {
+		s.now = func() time.Time { return at(23, 0) }
+	}
+	return s
+}
+
+func TestService(t *testing.T) {
+	next := new(servicemock.Service)
+	var requestType string
+	var priority int
+	next.CommandAndReportResultsFunc = func(r *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+		cmd := &mdm.Command{CommandUUID: "uuid", Priority: priority}
+		cmd.Command.RequestType = requestType
+		return cmd, nil
+	}
+	r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "id1"}}
+	for _, test := range []struct {
+		quiet       bool
+		requestType string
+		priority    int
+		withheld    bool
+	}{
+		{false, "ProfileList", mdm.PriorityNormal, false},
+		{true, "ProfileList", mdm.PriorityNormal, true},
+		{true, "ProfileList", mdm.PriorityHigh, true},
+		{true, "ProfileList", mdm.PriorityUrgent, false},
+		{true, "ProfileList", mdm.MaxPriority, false},
+		{true, "DeviceLock", mdm.PriorityNormal, false},
+	} {
+		requestType, priority = test.requestType, test.priority
+		cmd, err := New(next, newTestSchedule(t, test.quiet)).CommandAndReportResults(r, &mdm.CommandResults{Status: "Idle"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := cmd == nil; have != test.withheld {
+			t.Errorf("quiet=%v %s %d: withheld: have %v, want %v", test.quiet, test.requestType, test.priority, have, test.withheld)
+		}
+	}
+}
+
+type pushFunc func(context.Context, []string) (map[string]*push.Response, error)
+
+func (f pushFunc) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	return f(ctx, ids)
+}
+
+func TestPusher(t *testing.T) {
+	store := new(mock.Storage)
+	store.RetrieveNextCommandFunc = func(r *mdm.Request, _ bool) (*mdm.Command, error) {
+		cmd := new(mdm.Command)
+		switch r.ID {
+		case "urgent":
+			cmd.Command.RequestType = "EraseDevice"
+		case "priority":
+			cmd.Command.RequestType = "InstallProfile"
+			cmd.Priority = mdm.PriorityUrgent
+		default:
+			return nil, nil
+		}
+		return cmd, nil
+	}
+	var pushed []string
+	next := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		pushed = append(pushed, ids...)
+		ret := make(map[string]*push.Response)
+		for _, id := range ids {
+			ret[id] = &push.Response{Id: "apns-" + id}
+		}
+		return ret, nil
+	})
+
+	p := NewPusher(next, newTestSchedule(t, true), store, nil)
+	resps, err := p.Push(context.Background(), []string{"normal", "urgent", "priority"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != 2 || pushed[0] != "urgent" || pushed[1] != "priority" {
+		t.Errorf("pushed: have %v, want [urgent priority]", pushed)
+	}
+	if resps["normal"] == nil || resps["normal"].Err != ErrSuppressed {
+		t.Errorf("expected suppressed push response for normal")
+	}
+	if resps["urgent"] == nil || resps["urgent"].Id != "apns-urgent" {
+		t.Errorf("expected push response for urgent")
+	}
+
+	pushed = nil
+	p = NewPusher(next, newTestSchedule(t, false), store, nil)
+	if _, err = p.Push(context.Background(), []string{"normal"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pushed) != 1 {
+		t.Errorf("expected push outside quiet window")
+	}
+}
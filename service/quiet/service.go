@@ -0,0 +1,85 @@
+package quiet
+
+import (
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Service is a service middleware that withholds non-urgent commands
+// from devices in a quiet window. The device receives an empty
+// response and goes idle until its next push notification or
+// check-in. Note that an urgent command queued behind a non-urgent
+// command is withheld as well.
+//
+// The middleware should wrap the core service directly so that other
+// middleware (e.g. job tracking) does not see withheld commands as
+// delivered.
+type Service struct {
+	service.CheckinAndCommandService
+	schedule *Schedule
+	logger   log.Logger
+
+	withheld atomic.Int64
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// New creates a new quiet window service middleware.
+func New(next service.CheckinAndCommandService, schedule *Schedule, opts ...Option) *Service {
+	s := &Service{
+		CheckinAndCommandService: next,
+		schedule:                 schedule,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Metrics returns the quiet window service counters.
+func (s *Service) Metrics() interface{} {
+	return map[string]int64{
+		"withheld": s.withheld.Load(),
+	}
+}
+
+// CommandAndReportResults calls the next service and withholds the
+// next command if it is not urgent and the enrollment is in a quiet
+// window. Errors determining the quiet window are logged and the
+// command is delivered.
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := s.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || cmd == nil || r.EnrollID == nil || s.schedule.UrgentCommand(cmd) {
+		return cmd, err
+	}
+	logger := ctxlog.Logger(r.Context, s.logger)
+	quiet, err := s.schedule.Quiet(r.Context, r.ID, s.schedule.now())
+	if err != nil {
+		logger.Info("msg", "checking quiet window", "err", err)
+		return cmd, nil
+	}
+	if !quiet {
+		return cmd, nil
+	}
+	s.withheld.Add(1)
+	logger.Debug(
+		"msg", "withholding command in quiet window",
+		"command_uuid", cmd.CommandUUID,
+		"request_type", cmd.Command.RequestType,
+	)
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+// Package replay is a NanoMDM service middleware that detects replayed
+// check-in messages.
+package replay
+
+import (
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// DefaultWindow is the default replay detection window.
+const DefaultWindow = 5 * time.Minute
+
+// ErrReplay is returned for rejected replayed check-in messages.
+var ErrReplay = errors.New("replayed check-in message")
+
+// Replay is a service middleware that detects replayed check-in
+// messages. Messages are keyed on the hash of their raw body. A
+// message seen again within the window is considered a replay: it is
+// logged and counted and, if rejecting, answered with an HTTP 403
+// without calling the next service.
+//
+// Authenticate, TokenUpdate, CheckOut, and SetBootstrapToken messages
+// are checked. Devices may legitimately re-send an identical message
+// (e.g. after a network failure) so consider the window carefully
+// when rejecting.
+type Replay struct {
+	service.CheckinAndCommandService
+	logger log.Logger
+	window time.Duration
+	reject bool
+
+	mu        sync.Mutex
+	seen      map[[sha256.Size]byte]time.Time
+	lastSweep time.Time
+
+	detected atomic.Int64
+	rejected atomic.Int64
+}
+
+// Option configures a Replay.
+type Option func(*Replay)
+
+// WithLogger configures a logger on the Replay.
+func WithLogger(logger log.Logger) Option {
+	return func(r *Replay) {
+		r.logger = logger
+	}
+}
+
+// WithWindow sets the time a check-in message is remembered.
+func WithWindow(window time.Duration) Option {
+	return func(r *Replay) {
+		r.window = window
+	}
+}
+
+// WithReject rejects replayed check-in messages rather than only
+// logging them.
+func WithReject() Option {
+	return func(r *Replay) {
+		r.reject = true
+	}
+}
+
+// New creates a new replay detection service middleware.
+func New(next service.CheckinAndCommandService, opts ...Option) *Replay {
+	r := &Replay{
+		CheckinAndCommandService: next,
+		logger:                   log.NopLogger,
+		window:                   DefaultWindow,
+		seen:                     make(map[[sha256.Size]byte]time.Time),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Metrics returns the replay detection counters.
+func (r *Replay) Metrics() interface{} {
+	return map[string]int64{
+		"detected": r.detected.Load(),
+		"rejected": r.rejected.Load(),
+	}
+}
+
+// replayed records raw and reports whether it was seen within the window.
+func (r *Replay) replayed(raw []byte) bool {
+	key := sha256.Sum256(raw)
+	now := time.Now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastSweep) > r.window {
+		// periodically forget expired messages
+		for k, t := range r.seen {
+			if now.Sub(t) > r.window {
+				delete(r.seen, k)
+			}
+		}
+		r.lastSweep = now
+	}
+	t, ok := r.seen[key]
+	if !ok || now.Sub(t) > r.window {
+		r.seen[key] = now
+		return false
+	}
+	return true
+}
+
+// check checks the raw check-in message for replay. An error is
+// returned if it should be rejected.
+func (r *Replay) check(req *mdm.Request, messageType string, raw []byte) error {
+	if !r.replayed(raw) {
+		return nil
+	}
+	r.detected.Add(1)
+	logger := ctxlog.Logger(req.Context, r.logger)
+	if !r.reject {
+		logger.Info("msg", "replayed check-in detected", "message_type", messageType)
+		return nil
+	}
+	r.rejected.Add(1)
+	logger.Info("msg", "rejecting replayed check-in", "message_type", messageType)
+	return service.NewHTTPStatusError(http.StatusForbidden, ErrReplay)
+}
+
+func (r *Replay) Authenticate(req *mdm.Request, m *mdm.Authenticate) error {
+	if err := r.check(req, m.Type(), m.Raw); err != nil {
+		return err
+	}
+	return r.CheckinAndCommandService.Authenticate(req, m)
+}
+
+func (r *Replay) TokenUpdate(req *mdm.Request, m *mdm.TokenUpdate) error {
+	if err := r.check(req, m.Type(), m.Raw); err != nil {
+		return err
+	}
+	return r.CheckinAndCommandService.TokenUpdate(req, m)
+}
+
+func (r *Replay) CheckOut(req *mdm.Request, m *mdm.CheckOut) error {
+	if err := r.check(req, m.Type(), m.Raw); err != nil {
+		return err
+	}
+	return r.CheckinAndCommandService.CheckOut(req, m)
+}
+
+func (r *Replay) SetBootstrapToken(req *mdm.Request, m *mdm.SetBootstrapToken) error {
+	if err := r.check(req, m.Type(), m.Raw); err != nil {
+		return err
+	}
+	return r.CheckinAndCommandService.SetBootstrapToken(req, m)
+}
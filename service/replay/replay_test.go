@@ -0,0 +1,65 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/mock"
+)
+
+func TestReplay(t *testing.T) {
+	next := new(mock.Service)
+	r := New(next, WithReject(), WithWindow(20*time.Millisecond))
+	req := &mdm.Request{Context: context.Background()}
+
+	msg := &mdm.TokenUpdate{Raw: []byte("token update")}
+	if err := r.TokenUpdate(req, msg); err != nil {
+		t.Fatal(err)
+	}
+	err := r.TokenUpdate(req, msg)
+	var statusErr *service.HTTPStatusError
+	if !errors.As(err, &statusErr) || !errors.Is(err, ErrReplay) {
+		t.Fatalf("expected replay status error, got: %v", err)
+	}
+	if have, want := len(next.Calls("TokenUpdate")), 1; have != want {
+		t.Errorf("calls: have %d, want %d", have, want)
+	}
+
+	// a different message is not a replay
+	if err := r.TokenUpdate(req, &mdm.TokenUpdate{Raw: []byte("other")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// after the window the message is accepted again
+	time.Sleep(30 * time.Millisecond)
+	if err := r.TokenUpdate(req, msg); err != nil {
+		t.Fatal(err)
+	}
+
+	m := r.Metrics().(map[string]int64)
+	if m["detected"] != 1 || m["rejected"] != 1 {
+		t.Errorf("unexpected metrics: %v", m)
+	}
+}
+
+func TestReplayDetectOnly(t *testing.T) {
+	next := new(mock.Service)
+	r := New(next)
+	req := &mdm.Request{Context: context.Background()}
+	msg := &mdm.Authenticate{Raw: []byte("authenticate")}
+	for i := 0; i < 2; i++ {
+		if err := r.Authenticate(req, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := len(next.Calls("Authenticate")), 2; have != want {
+		t.Errorf("calls: have %d, want %d", have, want)
+	}
+	if have, want := r.Metrics().(map[string]int64)["detected"], int64(1); have != want {
+		t.Errorf("detected: have %d, want %d", have, want)
+	}
+}
@@ -13,6 +13,9 @@ import (
 type HTTPStatusError struct {
 	Status int
 	Err    error
+
+	// Header is optionally set on the HTTP response.
+	Header http.Header
 }
 
 func (e *HTTPStatusError) Error() string {
@@ -34,6 +37,12 @@ func CheckinRequest(svc Checkin, r *mdm.Request, bodyBytes []byte) ([]byte, erro
 	if err != nil {
 		return nil, NewHTTPStatusError(http.StatusBadRequest, fmt.Errorf("decoding check-in: %w", err))
 	}
+	if info := RequestInfoFromContext(r.Context); info != nil {
+		if m, ok := msg.(interface{ Type() string }); ok {
+			info.MessageType = m.Type()
+		}
+		defer info.setEnrollment(r)
+	}
 	var respBytes []byte
 	switch m := msg.(type) {
 	case *mdm.Authenticate:
@@ -108,11 +117,20 @@ func CommandAndReportResultsRequest(svc CommandAndReportResults, r *mdm.Request,
 	if err != nil {
 		return nil, NewHTTPStatusError(http.StatusBadRequest, fmt.Errorf("decoding command results: %w", err))
 	}
+	info := RequestInfoFromContext(r.Context)
+	if info != nil {
+		info.CommandUUID = report.CommandUUID
+		info.Status = report.Status
+		defer info.setEnrollment(r)
+	}
 	cmd, err := svc.CommandAndReportResults(r, report)
 	if err != nil {
 		return nil, fmt.Errorf("command and report results service: %w", err)
 	}
 	if cmd != nil {
+		if info != nil {
+			info.NextCommandUUID = cmd.CommandUUID
+		}
 		return cmd.Raw, nil
 	}
 	return nil, nil
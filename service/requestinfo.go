@@ -0,0 +1,43 @@
+package service
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// RequestInfo collects details of an MDM request as it is handled.
+// This is used, e.g., for access logging a request in a single line.
+type RequestInfo struct {
+	EnrollmentID string
+	MessageType  string
+
+	// CommandUUID and Status are from a command report.
+	CommandUUID string
+	Status      string
+
+	// NextCommandUUID is the command UUID sent in the response.
+	NextCommandUUID string
+}
+
+// setEnrollment sets the enrollment ID from r if it has been resolved.
+func (i *RequestInfo) setEnrollment(r *mdm.Request) {
+	if r.EnrollID != nil {
+		i.EnrollmentID = r.ID
+	}
+}
+
+type ctxKeyRequestInfo struct{}
+
+// NewContextWithRequestInfo returns a new context with the request info.
+// The request adapters fill in info as requests are handled.
+func NewContextWithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, ctxKeyRequestInfo{}, info)
+}
+
+// RequestInfoFromContext retrieves the request info from ctx.
+// Nil is returned if no request info was setup for the request.
+func RequestInfoFromContext(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(ctxKeyRequestInfo{}).(*RequestInfo)
+	return info
+}
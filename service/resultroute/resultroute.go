@@ -0,0 +1,193 @@
+// Package resultroute routes command results to webhook destinations
+// by their content.
+//
+// Rules match command results by the command request type and result
+// status. For example all EraseDevice results may be routed to a
+// security system and all Error statuses to an alerting endpoint.
+// Matching results are delivered in addition to the generic webhook.
+package resultroute
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Rule routes matching command results to a URL.
+type Rule struct {
+	Name string `json:"name"`
+
+	// URL receives the matching command results as webhook events.
+	URL string `json:"url"`
+
+	// RequestTypes match the command request type (e.g. "EraseDevice").
+	// Empty matches any request type.
+	RequestTypes []string `json:"request_types,omitempty"`
+
+	// Statuses match the result status (e.g. "Error"). Empty matches
+	// any status.
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// LoadRules reads a JSON list of rules from path.
+func LoadRules(path string) ([]*Rule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	return rules, json.Unmarshal(b, &rules)
+}
+
+// contains reports whether s is in list. An empty list contains
+// everything.
+func contains(list []string, s string) bool {
+	if len(list) < 1 {
+		return true
+	}
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// route is a configured rule with its webhook.
+type route struct {
+	rule    *Rule
+	webhook *microwebhook.MicroWebhook
+}
+
+// Service is a service middleware that routes command results to the
+// URLs of matching rules.
+type Service struct {
+	service.CheckinAndCommandService
+	store  storage.QueueRetriever
+	logger log.Logger
+	routes []*route
+	whOpts []microwebhook.Option
+
+	// byType is true if any rule matches request types.
+	byType bool
+
+	routed atomic.Int64
+	failed atomic.Int64
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// WithWebhookOptions configures the webhooks delivering results to
+// rule URLs (e.g. the HTTP client and event version).
+func WithWebhookOptions(opts ...microwebhook.Option) Option {
+	return func(s *Service) {
+		s.whOpts = append(s.whOpts, opts...)
+	}
+}
+
+// New creates a new result routing service middleware. Not all clients
+// report the request type of command results so it is otherwise looked
+// up in the enrollment's queue from store.
+func New(next service.CheckinAndCommandService, store storage.QueueRetriever, rules []*Rule, opts ...Option) (*Service, error) {
+	s := &Service{
+		CheckinAndCommandService: next,
+		store:                    store,
+		logger:                   log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	names := make(map[string]bool)
+	for i, rule := range rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d: empty name", i)
+		}
+		if rule.URL == "" {
+			return nil, fmt.Errorf("rule %s: empty URL", rule.Name)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rule: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.RequestTypes) > 0 {
+			s.byType = true
+		}
+		s.routes = append(s.routes, &route{
+			rule:    rule,
+			webhook: microwebhook.New(rule.URL, nil, s.whOpts...),
+		})
+	}
+	return s, nil
+}
+
+// Metrics returns the result routing counters.
+func (s *Service) Metrics() interface{} {
+	return map[string]int64{
+		"routed": s.routed.Load(),
+		"failed": s.failed.Load(),
+	}
+}
+
+// requestType returns the request type of results, looking it up in
+// the queue of the enrollment if the client did not report it.
+func (s *Service) requestType(r *mdm.Request, results *mdm.CommandResults) (string, error) {
+	if results.RequestType != "" || !s.byType || r.EnrollID == nil {
+		return results.RequestType, nil
+	}
+	cmds, err := s.store.RetrieveQueue(r.Context, r.ID)
+	if err != nil {
+		return "", err
+	}
+	for _, cmd := range cmds {
+		if cmd.CommandUUID == results.CommandUUID {
+			return cmd.RequestType, nil
+		}
+	}
+	return "", nil
+}
+
+// CommandAndReportResults calls the next service and then routes the
+// results to the URLs of every matching rule. Errors routing results
+// are logged but otherwise ignored.
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := s.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || results.CommandUUID == "" || results.Status == "Idle" {
+		return cmd, err
+	}
+	logger := ctxlog.Logger(r.Context, s.logger).With("command_uuid", results.CommandUUID)
+	requestType, err := s.requestType(r, results)
+	if err != nil {
+		// rules without request types can still match
+		logger.Info("msg", "retrieving request type", "err", err)
+	}
+	for _, route := range s.routes {
+		if !contains(route.rule.Statuses, results.Status) || !contains(route.rule.RequestTypes, requestType) {
+			continue
+		}
+		if _, err = route.webhook.CommandAndReportResults(r, results); err != nil {
+			s.failed.Add(1)
+			logger.Info("msg", "routing results", "rule", route.rule.Name, "err", err)
+			continue
+		}
+		s.routed.Add(1)
+		logger.Debug("msg", "routed results", "rule", route.rule.Name, "status", results.Status, "request_type", requestType)
+	}
+	return cmd, nil
+}
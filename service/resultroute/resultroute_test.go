@@ -0,0 +1,83 @@
+package resultroute
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service/microwebhook"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func TestService(t *testing.T) {
+	var mu sync.Mutex
+	events := make(map[string][]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ev := new(microwebhook.Event)
+		if err := json.NewDecoder(r.Body).Decode(ev); err != nil {
+			t.Error(err)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		events[r.URL.Path] = append(events[r.URL.Path], ev.AcknowledgeEvent.CommandUUID)
+	}))
+	defer srv.Close()
+
+	store := new(mock.Storage)
+	store.RetrieveQueueFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		return []*storage.QueuedCommand{{CommandUUID: "CMD3", RequestType: "EraseDevice"}}, nil
+	}
+	next := new(servicemock.Service)
+	next.CommandAndReportResultsFunc = func(_ *mdm.Request, _ *mdm.CommandResults) (*mdm.Command, error) {
+		return nil, nil
+	}
+	s, err := New(next, store, []*Rule{
+		{Name: "security", URL: srv.URL + "/security", RequestTypes: []string{"EraseDevice", "DeviceLock"}},
+		{Name: "alerts", URL: srv.URL + "/alerts", Statuses: []string{"Error"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(uuid, requestType, status string) {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "DEV1"}}
+		results := &mdm.CommandResults{CommandUUID: uuid, RequestType: requestType, Status: status, Raw: []byte("<plist/>")}
+		if _, err := s.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("", "", "Idle")
+	report("CMD1", "DeviceLock", "Acknowledged")
+	report("CMD2", "ProfileList", "Error")
+	// request type looked up in the queue
+	report("CMD3", "", "Error")
+	report("CMD4", "ProfileList", "Acknowledged")
+
+	if have := events["/security"]; len(have) != 2 || have[0] != "CMD1" || have[1] != "CMD3" {
+		t.Errorf("security events: %v", have)
+	}
+	if have := events["/alerts"]; len(have) != 2 || have[0] != "CMD2" || have[1] != "CMD3" {
+		t.Errorf("alerts events: %v", have)
+	}
+	if have := s.Metrics().(map[string]int64)["routed"]; have != 4 {
+		t.Errorf("routed: have %d, want 4", have)
+	}
+
+	for _, rules := range [][]*Rule{
+		{{Name: "", URL: srv.URL}},
+		{{Name: "a"}},
+		{{Name: "a", URL: srv.URL}, {Name: "a", URL: srv.URL}},
+	} {
+		if _, err = New(next, store, rules); err == nil {
+			t.Errorf("expected error for rules %+v", rules)
+		}
+	}
+}
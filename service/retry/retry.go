@@ -0,0 +1,183 @@
+// Package retry automatically re-enqueues commands that failed with
+// retryable errors.
+//
+// Whether an error is retryable is decided by the error chain of the
+// command result using an error code knowledge base (see package
+// errorkb). Commands are re-enqueued with a new command UUID and are
+// sent the next time the enrollment polls for commands.
+package retry
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Store retrieves queued commands and enqueues commands.
+type Store interface {
+	storage.QueueRetriever
+	storage.CommandEnqueuer
+}
+
+// Service is a service middleware that re-enqueues commands with Error
+// results that are classified as retryable.
+type Service struct {
+	service.CheckinAndCommandService
+	store  Store
+	kb     *errorkb.KB
+	max    int
+	logger log.Logger
+
+	// retries tracks the attempt of re-enqueued commands by their
+	// command UUID. It is kept in memory only.
+	mu      sync.Mutex
+	retries map[string]int
+
+	retried   atomic.Int64
+	exhausted atomic.Int64
+}
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithLogger configures a logger on the Service.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Service) {
+		s.logger = logger
+	}
+}
+
+// New creates a new retry service middleware. Commands are retried at
+// most max times.
+func New(next service.CheckinAndCommandService, store Store, kb *errorkb.KB, max int, opts ...Option) *Service {
+	s := &Service{
+		CheckinAndCommandService: next,
+		store:                    store,
+		kb:                       kb,
+		max:                      max,
+		logger:                   log.NopLogger,
+		retries:                  make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Metrics returns the retry counters. It is suitable for use with
+// expvar.Func.
+func (s *Service) Metrics() interface{} {
+	s.mu.Lock()
+	pending := len(s.retries)
+	s.mu.Unlock()
+	return map[string]int64{
+		"retried":   s.retried.Load(),
+		"exhausted": s.exhausted.Load(),
+		"pending":   int64(pending),
+	}
+}
+
+// attempts returns the number of times the command uuid has been
+// retried and stops tracking it.
+func (s *Service) attempts(uuid string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.retries[uuid]
+	delete(s.retries, uuid)
+	return n
+}
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+// retryCommand returns the raw command uuid queued for id with a new
+// command UUID.
+func (s *Service) retryCommand(ctx context.Context, id, uuid string) (*mdm.Command, error) {
+	cmds, err := s.store.RetrieveQueue(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving queue: %w", err)
+	}
+	var raw []byte
+	for _, cmd := range cmds {
+		if cmd.CommandUUID == uuid {
+			raw = cmd.Command
+			break
+		}
+	}
+	if raw == nil {
+		return nil, errors.New("command not in storage")
+	}
+	var m map[string]interface{}
+	if err = plist.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	m["CommandUUID"] = newUUID()
+	if raw, err = plist.Marshal(m); err != nil {
+		return nil, err
+	}
+	return mdm.DecodeCommand(raw)
+}
+
+// CommandAndReportResults calls the next service and then re-enqueues
+// the command of an Error result if its error chain is retryable and
+// it has been retried fewer than the maximum times. Errors re-enqueueing
+// are logged but otherwise ignored.
+func (s *Service) CommandAndReportResults(r *mdm.Request, results *mdm.CommandResults) (*mdm.Command, error) {
+	cmd, err := s.CheckinAndCommandService.CommandAndReportResults(r, results)
+	if err != nil || r.EnrollID == nil || results.CommandUUID == "" || results.Status == "NotNow" {
+		return cmd, err
+	}
+	n := s.attempts(results.CommandUUID)
+	if results.Status != "Error" {
+		return cmd, nil
+	}
+	if s.kb.Classify(results.ErrorChain) != errorkb.Retryable {
+		return cmd, nil
+	}
+	logger := ctxlog.Logger(r.Context, s.logger).With(
+		"command_uuid", results.CommandUUID,
+		"request_type", results.RequestType,
+		"attempt", n+1,
+	)
+	if n >= s.max {
+		s.exhausted.Add(1)
+		logger.Info("msg", "command retries exhausted")
+		return cmd, nil
+	}
+	retry, err := s.retryCommand(r.Context, r.ID, results.CommandUUID)
+	if err == nil {
+		var idErrs map[string]error
+		if idErrs, err = s.store.EnqueueCommand(r.Context, []string{r.ID}, retry); err == nil {
+			err = idErrs[r.ID]
+		}
+	}
+	if err != nil {
+		logger.Info("msg", "re-enqueueing command", "err", err)
+		return cmd, nil
+	}
+	s.mu.Lock()
+	s.retries[retry.CommandUUID] = n + 1
+	s.mu.Unlock()
+	s.retried.Add(1)
+	logger.Debug("msg", "re-enqueued command", "retry_command_uuid", retry.CommandUUID)
+	return cmd, nil
+}
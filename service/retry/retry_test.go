@@ -0,0 +1,86 @@
+package retry
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/mdm/errorkb"
+	servicemock "github.com/micromdm/nanomdm/service/mock"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+const rawCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>InstallProfile</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>CMD1</string>
+</dict>
+</plist>
+`
+
+func TestService(t *testing.T) {
+	queue := map[string][]byte{"CMD1": []byte(rawCommand)}
+	store := new(mock.Storage)
+	store.RetrieveQueueFunc = func(context.Context, string) ([]*storage.QueuedCommand, error) {
+		var cmds []*storage.QueuedCommand
+		for uuid, raw := range queue {
+			cmds = append(cmds, &storage.QueuedCommand{CommandUUID: uuid, Command: raw})
+		}
+		return cmds, nil
+	}
+	var enqueued []*mdm.Command
+	store.EnqueueCommandFunc = func(_ context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+		if len(ids) != 1 || ids[0] != "DEV1" {
+			t.Errorf("unexpected ids: %v", ids)
+		}
+		enqueued = append(enqueued, cmd)
+		queue[cmd.CommandUUID] = cmd.Raw
+		return nil, nil
+	}
+	next := new(servicemock.Service)
+	next.CommandAndReportResultsFunc = func(*mdm.Request, *mdm.CommandResults) (*mdm.Command, error) {
+		return nil, nil
+	}
+	s := New(next, store, errorkb.Default(), 2)
+
+	report := func(uuid, domain string) {
+		t.Helper()
+		r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "DEV1"}}
+		results := &mdm.CommandResults{
+			CommandUUID: uuid,
+			Status:      "Error",
+			ErrorChain:  []mdm.ErrorChain{{ErrorDomain: domain, ErrorCode: -1}},
+		}
+		if _, err := s.CommandAndReportResults(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report("CMD1", "MCProfileErrorDomain")
+	if have, want := len(enqueued), 0; have != want {
+		t.Fatalf("fatal error: have %d enqueued, want %d", have, want)
+	}
+
+	report("CMD1", "NSURLErrorDomain")
+	report(enqueued[0].CommandUUID, "NSURLErrorDomain")
+	report(enqueued[1].CommandUUID, "NSURLErrorDomain")
+	if have, want := len(enqueued), 2; have != want {
+		t.Fatalf("retries: have %d enqueued, want %d", have, want)
+	}
+	for _, cmd := range enqueued {
+		if cmd.CommandUUID == "CMD1" || cmd.Command.RequestType != "InstallProfile" {
+			t.Errorf("unexpected retry command: %s %s", cmd.CommandUUID, cmd.Command.RequestType)
+		}
+	}
+	if have, want := s.exhausted.Load(), int64(1); have != want {
+		t.Errorf("exhausted: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,286 @@
+// Package smartgroup maintains dynamic enrollment groups defined by
+// criteria on stored inventory.
+//
+// Smart group membership is kept in the groups of the enrollment
+// metadata so that smart groups can be targeted wherever enrollment
+// metadata groups are (e.g. campaigns, policy rules, quiet windows).
+// Membership is re-evaluated whenever new inventory is stored.
+package smartgroup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Criterion operators.
+const (
+	OpEqual     = "eq"
+	OpNotEqual  = "ne"
+	OpPrefix    = "prefix"
+	OpContains  = "contains"
+	OpExists    = "exists"
+	OpAbsent    = "absent"
+	OpVersionLT = "version_lt"
+	OpVersionLE = "version_le"
+	OpVersionGT = "version_gt"
+	OpVersionGE = "version_ge"
+)
+
+// Criterion matches an inventory value.
+type Criterion struct {
+	// Source is the inventory source (e.g. "DeviceInformation").
+	Source string `json:"source"`
+	// Key is the inventory key (e.g. "OSVersion").
+	Key   string `json:"key"`
+	Op    string `json:"op"`
+	Value string `json:"value,omitempty"`
+}
+
+// compareVersions compares dotted numeric versions (e.g. "14.2.1")
+// returning -1, 0, or 1. Missing components are zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x < y {
+			return -1
+		} else if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// match reports whether the criterion matches value v which exists
+// if ok.
+func (c *Criterion) match(v string, ok bool) bool {
+	switch c.Op {
+	case OpExists:
+		return ok
+	case OpAbsent:
+		return !ok
+	case OpNotEqual:
+		return !ok || v != c.Value
+	}
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case OpEqual:
+		return v == c.Value
+	case OpPrefix:
+		return strings.HasPrefix(v, c.Value)
+	case OpContains:
+		return strings.Contains(v, c.Value)
+	case OpVersionLT:
+		return compareVersions(v, c.Value) < 0
+	case OpVersionLE:
+		return compareVersions(v, c.Value) <= 0
+	case OpVersionGT:
+		return compareVersions(v, c.Value) > 0
+	case OpVersionGE:
+		return compareVersions(v, c.Value) >= 0
+	}
+	return false
+}
+
+// Group is a smart group.
+type Group struct {
+	Name string `json:"name"`
+	// Any makes enrollments matching any criterion members. By default
+	// all criteria must match.
+	Any      bool         `json:"any,omitempty"`
+	Criteria []*Criterion `json:"criteria"`
+}
+
+// validate checks g for errors.
+func (g *Group) validate() error {
+	if g.Name == "" {
+		return errors.New("empty name")
+	}
+	if len(g.Criteria) < 1 {
+		return errors.New("no criteria")
+	}
+	for i, c := range g.Criteria {
+		if c.Source == "" || c.Key == "" {
+			return fmt.Errorf("criterion %d: empty source or key", i)
+		}
+		switch c.Op {
+		case OpEqual, OpNotEqual, OpPrefix, OpContains, OpExists, OpAbsent,
+			OpVersionLT, OpVersionLE, OpVersionGT, OpVersionGE:
+		default:
+			return fmt.Errorf("criterion %d: invalid op: %q", i, c.Op)
+		}
+	}
+	return nil
+}
+
+// LoadGroups reads a JSON list of smart groups from path.
+func LoadGroups(path string) ([]*Group, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var groups []*Group
+	return groups, json.Unmarshal(b, &groups)
+}
+
+// Store retrieves inventory and enrollments and stores enrollment
+// metadata.
+type Store interface {
+	storage.InventoryStore
+	storage.EnrollmentMetadataStore
+	storage.EnrollmentRetriever
+}
+
+// Evaluator evaluates smart group membership.
+type Evaluator struct {
+	groups []*Group
+	names  map[string]bool
+	store  Store
+	logger log.Logger
+}
+
+// Option configures an Evaluator.
+type Option func(*Evaluator)
+
+// WithLogger configures a logger on the Evaluator.
+func WithLogger(logger log.Logger) Option {
+	return func(e *Evaluator) {
+		e.logger = logger
+	}
+}
+
+// New creates a new Evaluator of groups.
+func New(groups []*Group, store Store, opts ...Option) (*Evaluator, error) {
+	e := &Evaluator{names: make(map[string]bool), store: store, logger: log.NopLogger}
+	for _, opt := range opts {
+		opt(e)
+	}
+	for i, g := range groups {
+		if err := g.validate(); err != nil {
+			return nil, fmt.Errorf("group %d (%s): %w", i, g.Name, err)
+		}
+		if e.names[g.Name] {
+			return nil, fmt.Errorf("duplicate group: %s", g.Name)
+		}
+		e.names[g.Name] = true
+		e.groups = append(e.groups, g)
+	}
+	return e, nil
+}
+
+// Groups returns the smart groups.
+func (e *Evaluator) Groups() []*Group {
+	return e.groups
+}
+
+// Smart reports whether name is a smart group.
+func (e *Evaluator) Smart(name string) bool {
+	return e.names[name]
+}
+
+// members returns the names of the smart groups id is a member of.
+func (e *Evaluator) members(ctx context.Context, id string) ([]string, error) {
+	snapshots := make(map[string]map[string]string)
+	var names []string
+	for _, g := range e.groups {
+		var matched int
+		for _, c := range g.Criteria {
+			values, ok := snapshots[c.Source]
+			if !ok {
+				var err error
+				if values, err = e.store.RetrieveInventory(ctx, id, c.Source); err != nil {
+					return nil, fmt.Errorf("retrieving inventory: %w", err)
+				}
+				snapshots[c.Source] = values
+			}
+			v, ok := values[c.Key]
+			if c.match(v, ok) {
+				matched++
+			}
+		}
+		if (g.Any && matched > 0) || matched == len(g.Criteria) {
+			names = append(names, g.Name)
+		}
+	}
+	return names, nil
+}
+
+// Evaluate re-evaluates the smart group membership of enrollment id
+// and updates the groups of its enrollment metadata. Groups that are
+// not smart groups are kept. It returns the smart groups id is a
+// member of.
+func (e *Evaluator) Evaluate(ctx context.Context, id string) ([]string, error) {
+	names, err := e.members(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := e.store.RetrieveEnrollmentMetadata(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving metadata: %w", err)
+	}
+	if meta == nil {
+		if len(names) < 1 {
+			return nil, nil
+		}
+		meta = new(storage.EnrollmentMetadata)
+	}
+	var groups []string
+	for _, g := range meta.Groups {
+		if !e.names[g] {
+			groups = append(groups, g)
+		}
+	}
+	groups = append(groups, names...)
+	sort.Strings(groups)
+	prev := append([]string{}, meta.Groups...)
+	sort.Strings(prev)
+	if strings.Join(prev, "\x00") == strings.Join(groups, "\x00") {
+		return names, nil
+	}
+	meta.Groups = groups
+	if err = e.store.StoreEnrollmentMetadata(ctx, id, meta); err != nil {
+		return nil, fmt.Errorf("storing metadata: %w", err)
+	}
+	ctxlog.Logger(ctx, e.logger).Debug(
+		"msg", "smart group membership changed",
+		"id", id,
+		"smart_groups", strings.Join(names, ","),
+	)
+	return names, nil
+}
+
+// EvaluateAll re-evaluates the smart group membership of all enabled
+// enrollments (e.g. after changing smart group criteria). It returns
+// the number of enrollments evaluated.
+func (e *Evaluator) EvaluateAll(ctx context.Context) (int, error) {
+	enabled := true
+	enrollments, err := e.store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{Enabled: &enabled})
+	if err != nil {
+		return 0, fmt.Errorf("retrieving enrollments: %w", err)
+	}
+	for i, enrollment := range enrollments {
+		if _, err = e.Evaluate(ctx, enrollment.ID); err != nil {
+			return i, fmt.Errorf("evaluating %s: %w", enrollment.ID, err)
+		}
+	}
+	return len(enrollments), nil
+}
@@ -0,0 +1,109 @@
+package smartgroup
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+func TestCompareVersions(t *testing.T) {
+	for _, tc := range []struct {
+		a, b string
+		want int
+	}{
+		{"14.2.1", "14.2.1", 0},
+		{"14.2", "14.2.0", 0},
+		{"14.2", "14.10", -1},
+		{"15", "14.9.9", 1},
+	} {
+		if have := compareVersions(tc.a, tc.b); have != tc.want {
+			t.Errorf("%s %s: have %d, want %d", tc.a, tc.b, have, tc.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	ctx := context.Background()
+	store, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := []*Group{
+		{Name: "outdated", Criteria: []*Criterion{
+			{Source: "DeviceInformation", Key: "OSVersion", Op: OpVersionLT, Value: "14.2"},
+			{Source: "DeviceInformation", Key: "Model", Op: OpPrefix, Value: "Mac"},
+		}},
+		{Name: "unencrypted", Any: true, Criteria: []*Criterion{
+			{Source: "SecurityInfo", Key: "FDE_Enabled", Op: OpEqual, Value: "false"},
+			{Source: "SecurityInfo", Key: "FDE_Enabled", Op: OpAbsent},
+		}},
+	}
+	e, err := New(groups, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.StoreEnrollmentMetadata(ctx, "DEV1", &storage.EnrollmentMetadata{Tenant: "acme", Groups: []string{"lab", "outdated"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.StoreInventory(ctx, "DEV1", "DeviceInformation", map[string]string{"OSVersion": "14.1.2", "Model": "MacBookPro18,1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := e.Evaluate(ctx, "DEV1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"outdated", "unencrypted"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("smart groups: have %v, want %v", names, want)
+	}
+	meta, err := store.RetrieveEnrollmentMetadata(ctx, "DEV1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lab", "outdated", "unencrypted"}; !reflect.DeepEqual(meta.Groups, want) {
+		t.Errorf("groups: have %v, want %v", meta.Groups, want)
+	}
+	if meta.Tenant != "acme" {
+		t.Errorf("tenant: have %q, want %q", meta.Tenant, "acme")
+	}
+
+	err = store.StoreInventory(ctx, "DEV1", "DeviceInformation", map[string]string{"OSVersion": "14.2", "Model": "MacBookPro18,1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.StoreInventory(ctx, "DEV1", "SecurityInfo", map[string]string{"FDE_Enabled": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names, err = e.Evaluate(ctx, "DEV1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("smart groups: have %v, want none", names)
+	}
+	if meta, err = store.RetrieveEnrollmentMetadata(ctx, "DEV1"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lab"}; !reflect.DeepEqual(meta.Groups, want) {
+		t.Errorf("groups: have %v, want %v", meta.Groups, want)
+	}
+
+	for _, g := range []*Group{
+		{Name: "", Criteria: groups[0].Criteria},
+		{Name: "a"},
+		{Name: "a", Criteria: []*Criterion{{Source: "SecurityInfo", Op: OpExists}}},
+		{Name: "a", Criteria: []*Criterion{{Source: "SecurityInfo", Key: "FDE_Enabled", Op: "bogus"}}},
+	} {
+		if _, err = New([]*Group{g}, store); err == nil {
+			t.Errorf("expected error for group %+v", g)
+		}
+	}
+	if _, err = New([]*Group{groups[0], groups[0]}, store); err == nil {
+		t.Error("expected error for duplicate group")
+	}
+}
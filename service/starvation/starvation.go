@@ -0,0 +1,244 @@
+// Package starvation detects enrollments whose command queues are not
+// being delivered.
+//
+// An enrollment is starved when it has checked in well after a command
+// was queued for it but the command still has no result. Devices that
+// are in contact with NanoMDM should receive their queued commands so
+// this usually indicates a problem with command delivery, such as a
+// storage backend returning queued commands in the wrong order.
+package starvation
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// Store retrieves enrollments and their command queues.
+type Store interface {
+	storage.EnrollmentRetriever
+	storage.QueueRetriever
+}
+
+// Starved is an enrollment with a starved command queue.
+type Starved struct {
+	EnrollmentID string `json:"enrollment_id"`
+
+	// CommandUUID and RequestType are of the oldest queued command
+	// without a result.
+	CommandUUID string    `json:"command_uuid"`
+	RequestType string    `json:"request_type"`
+	QueuedAt    time.Time `json:"queued_at"`
+
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	// Pending is the number of queued commands without a result.
+	Pending int `json:"pending"`
+
+	// Answered is the number of commands queued after CommandUUID that
+	// do have results, which suggests commands are delivered out of
+	// order.
+	Answered int `json:"answered"`
+}
+
+// starved returns the starvation of an enrollment last seen at seen
+// with the command queue cmds, or nil if it is not starved. Commands
+// are starved when the enrollment was seen at least age after they
+// were queued (or scheduled, if later). Commands not yet due when the
+// enrollment was seen are ignored.
+func starved(cmds []*storage.QueuedCommand, seen time.Time, age time.Duration) *Starved {
+	var s *Starved
+	for _, cmd := range cmds {
+		if !cmd.Active {
+			continue
+		}
+		queuedAt := cmd.CreatedAt
+		if cmd.NotBefore != nil && cmd.NotBefore.After(queuedAt) {
+			if seen.Before(*cmd.NotBefore) {
+				continue
+			}
+			queuedAt = *cmd.NotBefore
+		}
+		if s != nil && cmd.Status != "" {
+			s.Answered++
+			continue
+		}
+		if cmd.Status != "" {
+			continue
+		}
+		if s != nil {
+			s.Pending++
+			continue
+		}
+		if seen.Sub(queuedAt) < age {
+			// the oldest pending command is not starved
+			return nil
+		}
+		s = &Starved{
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.RequestType,
+			QueuedAt:    queuedAt,
+			LastSeenAt:  seen,
+			Pending:     1,
+		}
+	}
+	return s
+}
+
+// AlertFunc is called when an enrollment is found starved.
+type AlertFunc func(ctx context.Context, s *Starved) error
+
+// Watchdog periodically checks the command queues of recently seen
+// enrollments for starvation. Each starved command is alerted once.
+// Alert state is kept in memory only.
+type Watchdog struct {
+	store    Store
+	logger   log.Logger
+	interval time.Duration
+	age      time.Duration
+	alert    AlertFunc
+
+	mu      sync.RWMutex
+	starved []*Starved
+	alerted map[string]string // command UUID by enrollment ID
+}
+
+// Option configures a Watchdog.
+type Option func(*Watchdog)
+
+// WithLogger sets the logger.
+func WithLogger(logger log.Logger) Option {
+	return func(w *Watchdog) {
+		w.logger = logger
+	}
+}
+
+// WithInterval sets how often command queues are checked.
+func WithInterval(interval time.Duration) Option {
+	return func(w *Watchdog) {
+		w.interval = interval
+	}
+}
+
+// WithAlertFunc sets the function called for starved enrollments.
+// Starved enrollments are always logged.
+func WithAlertFunc(f AlertFunc) Option {
+	return func(w *Watchdog) {
+		w.alert = f
+	}
+}
+
+// New creates a new starvation Watchdog. Enrollments are starved when
+// they were seen at least age after a command without a result was
+// queued. Only enrollments seen within age of a check are checked.
+func New(store Store, age time.Duration, opts ...Option) *Watchdog {
+	w := &Watchdog{
+		store:    store,
+		logger:   log.NopLogger,
+		interval: 15 * time.Minute,
+		age:      age,
+		alerted:  make(map[string]string),
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Check checks the command queues of enabled enrollments seen within
+// age and alerts newly starved enrollments.
+func (w *Watchdog) Check(ctx context.Context) error {
+	logger := ctxlog.Logger(ctx, w.logger)
+	enabled := true
+	enrollments, err := w.store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{Enabled: &enabled})
+	if err != nil {
+		return fmt.Errorf("retrieving enrollments: %w", err)
+	}
+	now := time.Now()
+	var found []*Starved
+	for _, e := range enrollments {
+		if now.Sub(e.LastSeenAt) > w.age {
+			continue
+		}
+		cmds, err := w.store.RetrieveQueue(ctx, e.ID)
+		if err != nil {
+			logger.Info("msg", "retrieving queue", "id", e.ID, "err", err)
+			continue
+		}
+		if s := starved(cmds, e.LastSeenAt, w.age); s != nil {
+			s.EnrollmentID = e.ID
+			found = append(found, s)
+		}
+	}
+
+	var alert []*Starved
+	w.mu.Lock()
+	w.starved = found
+	alerted := make(map[string]string)
+	for _, s := range found {
+		if w.alerted[s.EnrollmentID] != s.CommandUUID {
+			alert = append(alert, s)
+		}
+		alerted[s.EnrollmentID] = s.CommandUUID
+	}
+	// forget enrollments that are no longer starved
+	w.alerted = alerted
+	w.mu.Unlock()
+
+	for _, s := range alert {
+		logger.Info(
+			"msg", "command queue starved",
+			"id", s.EnrollmentID,
+			"command_uuid", s.CommandUUID,
+			"request_type", s.RequestType,
+			"queued_at", s.QueuedAt,
+			"last_seen_at", s.LastSeenAt,
+			"pending", s.Pending,
+			"answered", s.Answered,
+		)
+		if w.alert == nil {
+			continue
+		}
+		if err = w.alert(ctx, s); err != nil {
+			logger.Info("msg", "sending starvation alert", "id", s.EnrollmentID, "err", err)
+		}
+	}
+	return nil
+}
+
+// Run checks command queues on every interval until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := w.Check(ctx); err != nil {
+			ctxlog.Logger(ctx, w.logger).Info("msg", "checking starved queues", "err", err)
+		}
+	}
+}
+
+// Starved returns the starved enrollments from the last check.
+func (w *Watchdog) Starved() []*Starved {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.starved
+}
+
+// Metrics returns the number of starved enrollments from the last
+// check. It is suitable for use with expvar.Func.
+func (w *Watchdog) Metrics() interface{} {
+	return map[string]int{
+		"starved": len(w.Starved()),
+	}
+}
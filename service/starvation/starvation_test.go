@@ -0,0 +1,82 @@
+package starvation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
+func TestWatchdog(t *testing.T) {
+	now := time.Now()
+	queues := map[string][]*storage.QueuedCommand{
+		// oldest pending command queued long before the last check-in
+		"ID1": {
+			{CommandUUID: "CMD1", Active: true, Status: "Acknowledged", CreatedAt: now.Add(-3 * time.Hour)},
+			{CommandUUID: "CMD2", Active: true, RequestType: "ProfileList", CreatedAt: now.Add(-2 * time.Hour)},
+			{CommandUUID: "CMD3", Active: true, Status: "Acknowledged", CreatedAt: now.Add(-90 * time.Minute)},
+			{CommandUUID: "CMD4", Active: true, CreatedAt: now.Add(-time.Minute)},
+		},
+		// recently queued
+		"ID2": {
+			{CommandUUID: "CMD5", Active: true, CreatedAt: now.Add(-time.Minute)},
+		},
+		// not seen recently
+		"ID3": {
+			{CommandUUID: "CMD6", Active: true, CreatedAt: now.Add(-5 * time.Hour)},
+		},
+		// cleared queue
+		"ID4": {
+			{CommandUUID: "CMD7", CreatedAt: now.Add(-5 * time.Hour)},
+		},
+		// scheduled commands that are not due or recently due
+		"ID5": {
+			{CommandUUID: "CMD8", Active: true, CreatedAt: now.Add(-5 * time.Hour), NotBefore: timePtr(now.Add(time.Hour))},
+			{CommandUUID: "CMD9", Active: true, CreatedAt: now.Add(-5 * time.Hour), NotBefore: timePtr(now.Add(-time.Minute))},
+		},
+	}
+	store := new(mock.Storage)
+	store.RetrieveEnrollmentsFunc = func(_ context.Context, _ *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		return []*storage.Enrollment{
+			{ID: "ID1", LastSeenAt: now},
+			{ID: "ID2", LastSeenAt: now},
+			{ID: "ID3", LastSeenAt: now.Add(-4 * time.Hour)},
+			{ID: "ID4", LastSeenAt: now},
+			{ID: "ID5", LastSeenAt: now},
+		}, nil
+	}
+	store.RetrieveQueueFunc = func(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+		return queues[id], nil
+	}
+
+	var alerts []*Starved
+	w := New(store, time.Hour, WithAlertFunc(func(_ context.Context, s *Starved) error {
+		alerts = append(alerts, s)
+		return nil
+	}))
+	ctx := context.Background()
+	if err := w.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 {
+		t.Fatalf("alerts: have %d, want 1", len(alerts))
+	}
+	s := alerts[0]
+	if s.EnrollmentID != "ID1" || s.CommandUUID != "CMD2" || s.RequestType != "ProfileList" || s.Pending != 2 || s.Answered != 1 {
+		t.Errorf("unexpected starvation: %+v", s)
+	}
+
+	// starved commands are alerted once
+	if err := w.Check(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(alerts) != 1 || len(w.Starved()) != 1 {
+		t.Errorf("alerts: have %d, want 1", len(alerts))
+	}
+}
@@ -1,13 +1,21 @@
 package storage
 
-// AllStorage represents all required storage by NanoMDM
+// AllStorage represents all required storage by NanoMDM. Optional
+// features (such as enrollment metadata or job tracking) have their own
+// interfaces which are found with As and gated by the storage
+// capabilities.
 type AllStorage interface {
 	ServiceStore
 	PushStore
 	PushCertStore
+	PushCertLister
 	CommandEnqueuer
 	CertAuthStore
 	CertAuthRetriever
 	StoreMigrator
 	TokenUpdateTallyStore
+	DMEnablementStore
+	EnrollmentRetriever
+	EnrollmentAliasResolver
+	TopicStatsRetriever
 }
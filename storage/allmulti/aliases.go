@@ -0,0 +1,14 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) ResolveEnrollmentIDs(ctx context.Context, aliases []string) (map[string]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.ResolveEnrollmentIDs(ctx, aliases)
+	})
+	return val.(map[string]string), err
+}
@@ -2,6 +2,7 @@ package allmulti
 
 import (
 	"context"
+	"errors"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage"
@@ -10,9 +11,14 @@ import (
 	"github.com/micromdm/nanolib/log/ctxlog"
 )
 
+// errNotSupported is returned by stores that do not implement an
+// optional storage interface.
+var errNotSupported = errors.New("not supported by storage")
+
 // MultiAllStorage dispatches to multiple AllStorage instances.
 // It returns results and errors from the first store and simply
-// logs errors, if any, for the remaining.
+// logs errors, if any, for the remaining. Optional storage interfaces
+// of the stores are found with storage.As.
 type MultiAllStorage struct {
 	logger log.Logger
 	stores []storage.AllStorage
@@ -97,3 +103,10 @@ func (ms *MultiAllStorage) Disable(r *mdm.Request) error {
 	})
 	return err
 }
+
+func (ms *MultiAllStorage) DisableWithReason(r *mdm.Request, reason string) error {
+	_, err := ms.execStores(r.Context, func(s storage.AllStorage) (interface{}, error) {
+		return nil, storage.DisableWithReason(s, r, reason)
+	})
+	return err
+}
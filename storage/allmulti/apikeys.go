@@ -0,0 +1,53 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreAPIKey(ctx context.Context, key *storage.APIKey) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.APIKeyStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreAPIKey(ctx, key)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveAPIKey(ctx context.Context, name string) (*storage.APIKey, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.APIKeyStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveAPIKey(ctx, name)
+	})
+	ret, _ := val.(*storage.APIKey)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveAPIKeys(ctx context.Context) ([]*storage.APIKey, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.APIKeyStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveAPIKeys(ctx)
+	})
+	ret, _ := val.([]*storage.APIKey)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteAPIKey(ctx context.Context, name string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.APIKeyStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteAPIKey(ctx, name)
+	})
+	return err
+}
@@ -0,0 +1,41 @@
+package allmulti
+
+import "github.com/micromdm/nanomdm/storage"
+
+// optionalCapabilities are the capabilities of optional stores which
+// are written to (or required of) every store.
+var optionalCapabilities = []storage.Capability{
+	storage.CapabilityEviction,
+	storage.CapabilityTombstones,
+	storage.CapabilityWebhookDeliveries,
+	storage.CapabilityMessageTemplates,
+	storage.CapabilityDeclarations,
+	storage.CapabilityUnlockTokens,
+	storage.CapabilityDDMStatus,
+	storage.CapabilityQueuedEnrollments,
+	storage.CapabilityQueuedCommands,
+	storage.CapabilityUserSessions,
+	storage.CapabilityJobs,
+	storage.CapabilityEventLog,
+	storage.CapabilityMetricsRollups,
+	storage.CapabilityAPIKeys,
+	storage.CapabilityPendingCommands,
+	storage.CapabilityFreeze,
+	storage.CapabilityInventory,
+	storage.CapabilitySupersession,
+	storage.CapabilityCommandOwners,
+}
+
+// Capabilities reports the capabilities of the first store which
+// returns results and takes queue locks. Optional stores are only
+// reported if every store supports them.
+func (ms *MultiAllStorage) Capabilities() storage.Capabilities {
+	caps := storage.DiscoverCapabilities(ms.stores[0])
+	for _, s := range ms.stores[1:] {
+		other := storage.DiscoverCapabilities(s)
+		for _, c := range optionalCapabilities {
+			caps[c] = caps[c] && other[c]
+		}
+	}
+	return caps
+}
@@ -0,0 +1,30 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreDDMStatusReport(ctx context.Context, id string, report, declarations []byte) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DDMStatusStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreDDMStatusReport(ctx, id, report, declarations)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDDMStatusReports(ctx context.Context, ids []string) (map[string]*storage.DDMStatusReport, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DDMStatusStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveDDMStatusReports(ctx, ids)
+	})
+	ret, _ := val.(map[string]*storage.DDMStatusReport)
+	return ret, err
+}
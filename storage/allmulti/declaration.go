@@ -0,0 +1,99 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreDeclaration(ctx context.Context, declaration *storage.Declaration) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreDeclaration(ctx, declaration)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDeclarations(ctx context.Context, identifiers []string) ([]*storage.Declaration, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveDeclarations(ctx, identifiers)
+	})
+	ret, _ := val.([]*storage.Declaration)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteDeclaration(ctx context.Context, identifier string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteDeclaration(ctx, identifier)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) StoreDeclarationSet(ctx context.Context, name string, identifiers []string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreDeclarationSet(ctx, name, identifiers)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDeclarationSets(ctx context.Context, names []string) (map[string][]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveDeclarationSets(ctx, names)
+	})
+	ret, _ := val.(map[string][]string)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) StoreEnrollmentDeclarationSets(ctx context.Context, id string, sets []string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreEnrollmentDeclarationSets(ctx, id, sets)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentDeclarationSets(ctx context.Context, id string) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentDeclarationSets(ctx, id)
+	})
+	ret, _ := val.([]string)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentDeclarations(ctx context.Context, id string) ([]*storage.Declaration, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.DeclarationStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentDeclarations(ctx, id)
+	})
+	ret, _ := val.([]*storage.Declaration)
+	return ret, err
+}
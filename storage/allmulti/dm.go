@@ -0,0 +1,22 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreDMEnablement(r *mdm.Request, endpoint, declarationsToken string) error {
+	_, err := ms.execStores(r.Context, func(s storage.AllStorage) (interface{}, error) {
+		return nil, s.StoreDMEnablement(r, endpoint, declarationsToken)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveDMEnablements(ctx context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveDMEnablements(ctx, ids)
+	})
+	return val.(map[string]*storage.DMEnablement), err
+}
@@ -0,0 +1,14 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) RetrieveEnrollments(ctx context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveEnrollments(ctx, filter)
+	})
+	return val.([]*storage.Enrollment), err
+}
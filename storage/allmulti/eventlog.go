@@ -0,0 +1,36 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreLogEvent(ctx context.Context, event *storage.LogEvent) error {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EventLogStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		// each store assigns its own sequence number
+		storeEvent := *event
+		err := store.StoreLogEvent(ctx, &storeEvent)
+		return storeEvent.Seq, err
+	})
+	if seq, ok := val.(int64); ok {
+		event.Seq = seq
+	}
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveLogEvents(ctx context.Context, after int64, limit int) ([]*storage.LogEvent, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EventLogStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveLogEvents(ctx, after, limit)
+	})
+	ret, _ := val.([]*storage.LogEvent)
+	return ret, err
+}
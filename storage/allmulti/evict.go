@@ -0,0 +1,53 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreEnrollmentEviction(ctx context.Context, eviction *storage.EnrollmentEviction) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentEvictionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreEnrollmentEviction(ctx, eviction)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentEvictionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentEvictions(ctx, ids)
+	})
+	ret, _ := val.(map[string]*storage.EnrollmentEviction)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentEvictionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.MarkEnrollmentEvictionDelivered(ctx, id)
+	})
+	ret, _ := val.(bool)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteEnrollmentEviction(ctx context.Context, id string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentEvictionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteEnrollmentEviction(ctx, id)
+	})
+	return err
+}
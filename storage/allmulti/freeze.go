@@ -0,0 +1,41 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreEnrollmentFreeze(ctx context.Context, freeze *storage.EnrollmentFreeze) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentFreezeStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreEnrollmentFreeze(ctx, freeze)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentFreezes(ctx context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentFreezeStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentFreezes(ctx, ids)
+	})
+	ret, _ := val.(map[string]*storage.EnrollmentFreeze)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteEnrollmentFreeze(ctx context.Context, id string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentFreezeStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteEnrollmentFreeze(ctx, id)
+	})
+	return err
+}
@@ -0,0 +1,30 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreInventory(ctx context.Context, id, source string, values map[string]string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.InventoryStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreInventory(ctx, id, source, values)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveInventory(ctx context.Context, id, source string) (map[string]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.InventoryStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveInventory(ctx, id, source)
+	})
+	ret, _ := val.(map[string]string)
+	return ret, err
+}
@@ -0,0 +1,64 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreJob(ctx context.Context, job *storage.Job, ids []string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.JobStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreJob(ctx, job, ids)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) UpdateJobTargets(ctx context.Context, jobID, status string, ids []string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.JobStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.UpdateJobTargets(ctx, jobID, status, ids)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) UpdateJobCommandTarget(ctx context.Context, id, commandUUID, status string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.JobStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.UpdateJobCommandTarget(ctx, id, commandUUID, status)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.JobStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveJob(ctx, jobID)
+	})
+	ret, _ := val.(*storage.Job)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveJobTargets(ctx context.Context, jobID string) (map[string]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.JobStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveJobTargets(ctx, jobID)
+	})
+	ret, _ := val.(map[string]string)
+	return ret, err
+}
@@ -0,0 +1,17 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// LockQueue locks the command queue of id in the first store only.
+// Locks are not taken in the remaining stores.
+func (ms *MultiAllStorage) LockQueue(ctx context.Context, id string) (func(), error) {
+	var store storage.QueueLocker
+	if !storage.As(ms.stores[0], &store) {
+		return nil, errNotSupported
+	}
+	return store.LockQueue(ctx, id)
+}
@@ -0,0 +1,30 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreEnrollmentMetadata(ctx context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentMetadataStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreEnrollmentMetadata(ctx, id, meta)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentMetadata(ctx context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentMetadataStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentMetadata(ctx, id)
+	})
+	ret, _ := val.(*storage.EnrollmentMetadata)
+	return ret, err
+}
@@ -0,0 +1,30 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreCommandOwner(ctx context.Context, uuid, owner string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.CommandOwnerStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreCommandOwner(ctx, uuid, owner)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveCommandOwner(ctx context.Context, uuid string) (string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.CommandOwnerStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveCommandOwner(ctx, uuid)
+	})
+	ret, _ := val.(string)
+	return ret, err
+}
@@ -0,0 +1,54 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StorePendingCommand(ctx context.Context, cmd *storage.PendingCommand) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.PendingCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StorePendingCommand(ctx, cmd)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrievePendingCommand(ctx context.Context, uuid string) (*storage.PendingCommand, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.PendingCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrievePendingCommand(ctx, uuid)
+	})
+	ret, _ := val.(*storage.PendingCommand)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrievePendingCommands(ctx context.Context) ([]*storage.PendingCommand, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.PendingCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrievePendingCommands(ctx)
+	})
+	ret, _ := val.([]*storage.PendingCommand)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeletePendingCommand(ctx context.Context, uuid string) (bool, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.PendingCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.DeletePendingCommand(ctx, uuid)
+	})
+	ret, _ := val.(bool)
+	return ret, err
+}
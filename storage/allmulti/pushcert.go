@@ -36,3 +36,10 @@ func (ms *MultiAllStorage) StorePushCert(ctx context.Context, pemCert, pemKey []
 	})
 	return err
 }
+
+func (ms *MultiAllStorage) RetrievePushCertTopics(ctx context.Context) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrievePushCertTopics(ctx)
+	})
+	return val.([]string), err
+}
@@ -2,6 +2,7 @@ package allmulti
 
 import (
 	"context"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage"
@@ -34,3 +35,63 @@ func (ms *MultiAllStorage) EnqueueCommand(ctx context.Context, id []string, cmd
 	})
 	return val.(map[string]error), err
 }
+
+func (ms *MultiAllStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.QueueRetriever
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveQueue(ctx, id)
+	})
+	ret, _ := val.([]*storage.QueuedCommand)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.CommandScheduleStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveScheduledEnrollments(ctx, after, until)
+	})
+	ret, _ := val.([]string)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveQueuedEnrollments(ctx context.Context) ([]string, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.QueuedEnrollmentRetriever
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveQueuedEnrollments(ctx)
+	})
+	ret, _ := val.([]string)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveQueuedCommands(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.QueuedCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveQueuedCommands(ctx, id)
+	})
+	ret, _ := val.([]*storage.QueuedCommand)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.QueuedCommandStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.CancelQueuedCommand(ctx, id, uuid)
+	})
+	ret, _ := val.(bool)
+	return ret, err
+}
@@ -0,0 +1,31 @@
+package allmulti
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) AddMetricsRollup(ctx context.Context, rollup *storage.MetricsRollup) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.MetricsRollupStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.AddMetricsRollup(ctx, rollup)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveMetricsRollups(ctx context.Context, period string, since time.Time) ([]*storage.MetricsRollup, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.MetricsRollupStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveMetricsRollups(ctx, period, since)
+	})
+	ret, _ := val.([]*storage.MetricsRollup)
+	return ret, err
+}
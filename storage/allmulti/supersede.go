@@ -0,0 +1,30 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreEnrollmentSupersession(ctx context.Context, ss *storage.EnrollmentSupersession) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentSupersessionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreEnrollmentSupersession(ctx, ss)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentSupersessionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentSupersessions(ctx, ids)
+	})
+	ret, _ := val.(map[string]*storage.EnrollmentSupersession)
+	return ret, err
+}
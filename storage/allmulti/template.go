@@ -0,0 +1,41 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreMessageTemplate(ctx context.Context, template *storage.MessageTemplate) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.MessageTemplateStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreMessageTemplate(ctx, template)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveMessageTemplates(ctx context.Context, name string) ([]*storage.MessageTemplate, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.MessageTemplateStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveMessageTemplates(ctx, name)
+	})
+	ret, _ := val.([]*storage.MessageTemplate)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteMessageTemplate(ctx context.Context, name, group, locale string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.MessageTemplateStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteMessageTemplate(ctx, name, group, locale)
+	})
+	return err
+}
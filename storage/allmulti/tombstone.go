@@ -0,0 +1,42 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentTombstoneStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.DepartEnrollment(ctx, tombstone)
+	})
+	ret, _ := val.(*storage.EnrollmentTombstone)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentTombstoneStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveEnrollmentTombstones(ctx, ids)
+	})
+	ret, _ := val.(map[string]*storage.EnrollmentTombstone)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteEnrollmentTombstone(ctx context.Context, id string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.EnrollmentTombstoneStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteEnrollmentTombstone(ctx, id)
+	})
+	return err
+}
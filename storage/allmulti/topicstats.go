@@ -0,0 +1,14 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) RetrieveTopicStats(ctx context.Context) ([]*storage.TopicStats, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		return s.RetrieveTopicStats(ctx)
+	})
+	return val.([]*storage.TopicStats), err
+}
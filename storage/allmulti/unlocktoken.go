@@ -0,0 +1,66 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UnlockTokenStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveUnlockToken(ctx, id)
+	})
+	ret, _ := val.([]byte)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) StoreUnlockTokenRequest(ctx context.Context, req *storage.UnlockTokenRequest) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UnlockTokenStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreUnlockTokenRequest(ctx, req)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveUnlockTokenRequests(ctx context.Context, ids []string) ([]*storage.UnlockTokenRequest, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UnlockTokenStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveUnlockTokenRequests(ctx, ids)
+	})
+	ret, _ := val.([]*storage.UnlockTokenRequest)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) ApproveUnlockTokenRequest(ctx context.Context, id, approvedBy string) (bool, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UnlockTokenStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.ApproveUnlockTokenRequest(ctx, id, approvedBy)
+	})
+	ret, _ := val.(bool)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteUnlockTokenRequest(ctx context.Context, id string) (bool, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UnlockTokenStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.DeleteUnlockTokenRequest(ctx, id)
+	})
+	ret, _ := val.(bool)
+	return ret, err
+}
@@ -0,0 +1,31 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreUserSession(r *mdm.Request, userShortName, userLongName string) error {
+	_, err := ms.execStores(r.Context, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UserSessionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreUserSession(r, userShortName, userLongName)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveUserSessions(ctx context.Context, deviceIDs []string) (map[string]*storage.UserSessions, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.UserSessionStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveUserSessions(ctx, deviceIDs)
+	})
+	ret, _ := val.(map[string]*storage.UserSessions)
+	return ret, err
+}
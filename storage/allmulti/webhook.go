@@ -0,0 +1,41 @@
+package allmulti
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (ms *MultiAllStorage) StoreWebhookDelivery(ctx context.Context, delivery *storage.WebhookDelivery) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.WebhookDeliveryStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.StoreWebhookDelivery(ctx, delivery)
+	})
+	return err
+}
+
+func (ms *MultiAllStorage) RetrieveWebhookDeliveries(ctx context.Context, filter *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error) {
+	val, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.WebhookDeliveryStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return store.RetrieveWebhookDeliveries(ctx, filter)
+	})
+	ret, _ := val.([]*storage.WebhookDelivery)
+	return ret, err
+}
+
+func (ms *MultiAllStorage) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	_, err := ms.execStores(ctx, func(s storage.AllStorage) (interface{}, error) {
+		var store storage.WebhookDeliveryStore
+		if !storage.As(s, &store) {
+			return nil, errNotSupported
+		}
+		return nil, store.DeleteWebhookDelivery(ctx, id)
+	})
+	return err
+}
@@ -0,0 +1,84 @@
+// Package batch implements "group commit" batching of storage writes.
+package batch
+
+import (
+	"context"
+	"time"
+)
+
+// FlushFunc writes items as a single batch.
+type FlushFunc func(ctx context.Context, items []interface{}) error
+
+type request struct {
+	item interface{}
+	done chan error
+}
+
+// Batcher collects items submitted concurrently and flushes them
+// together. A batch is flushed when the window has passed since its
+// first item was submitted or when it reaches the maximum size.
+// Batches are flushed one at a time.
+type Batcher struct {
+	window time.Duration
+	max    int
+	flush  FlushFunc
+	reqs   chan *request
+}
+
+// New creates and starts a new Batcher. A max less than one means
+// batches are only limited by window.
+func New(window time.Duration, max int, flush FlushFunc) *Batcher {
+	b := &Batcher{
+		window: window,
+		max:    max,
+		flush:  flush,
+		reqs:   make(chan *request),
+	}
+	go b.run()
+	return b
+}
+
+// Submit adds item to the next batch and waits for the batch to be
+// flushed. The error flushing the batch is returned. Note that if ctx
+// is done before the batch is flushed the item may still be written.
+func (b *Batcher) Submit(ctx context.Context, item interface{}) error {
+	req := &request{item: item, done: make(chan error, 1)}
+	select {
+	case b.reqs <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Batcher) run() {
+	for {
+		reqs := []*request{<-b.reqs}
+		timer := time.NewTimer(b.window)
+	collect:
+		for b.max < 1 || len(reqs) < b.max {
+			select {
+			case req := <-b.reqs:
+				reqs = append(reqs, req)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+		items := make([]interface{}, len(reqs))
+		for i, req := range reqs {
+			items[i] = req.item
+		}
+		// the batch is shared by many requests so we can't use any
+		// single request's context.
+		err := b.flush(context.Background(), items)
+		for _, req := range reqs {
+			req.done <- err
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package batch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]interface{}
+	b := New(50*time.Millisecond, 3, func(_ context.Context, items []interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, items)
+		for _, item := range items {
+			if item == "fail" {
+				return errors.New("flush failed")
+			}
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := b.Submit(context.Background(), i); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	var count int
+	for _, batch := range batches {
+		if len(batch) > 3 {
+			t.Errorf("batch size: have %d, want <= 3", len(batch))
+		}
+		count += len(batch)
+	}
+	mu.Unlock()
+	if have, want := count, 5; have != want {
+		t.Errorf("items flushed: have %d, want %d", have, want)
+	}
+	if len(batches) > 3 {
+		t.Errorf("expected items to be batched, got %d batches", len(batches))
+	}
+
+	if err := b.Submit(context.Background(), "fail"); err == nil {
+		t.Error("expected flush error")
+	}
+}
@@ -0,0 +1,401 @@
+// Package cache implements a read-through caching storage decorator.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// errNotSupported is returned when the wrapped storage does not
+// implement an optional storage interface.
+var errNotSupported = errors.New("not supported by storage")
+
+// DefaultTTL is the default time cached values are kept.
+const DefaultTTL = 5 * time.Minute
+
+// Cache is a key-value cache. Implementations may be in-process or
+// shared between NanoMDM instances (e.g. Redis).
+type Cache interface {
+	// Get returns the value for key and whether it was found.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set sets the value for key expiring after ttl.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes keys from the cache.
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// Cache key prefixes.
+const (
+	keyPush     = "push:"
+	keyCertHash = "certhash:"
+	keyCertID   = "certassoc:"
+	keyMetadata = "metadata:"
+)
+
+// Storage is a storage decorator which caches the most frequent
+// enrollment reads: push info, certificate hash lookups, and enrollment
+// metadata. Cached values are invalidated when written through the
+// decorator. Writes by other NanoMDM instances are only visible once
+// values expire unless the cache is shared between instances.
+type Storage struct {
+	storage.AllStorage
+	cache  Cache
+	ttl    time.Duration
+	logger log.Logger
+}
+
+// Option configures a Storage.
+type Option func(*Storage)
+
+// WithTTL sets the time cached values are kept.
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.ttl = ttl
+	}
+}
+
+// WithLogger configures a logger on the Storage.
+func WithLogger(logger log.Logger) Option {
+	return func(s *Storage) {
+		s.logger = logger
+	}
+}
+
+// New creates a new caching storage decorator of next using cache.
+func New(next storage.AllStorage, cache Cache, opts ...Option) *Storage {
+	s := &Storage{
+		AllStorage: next,
+		cache:      cache,
+		ttl:        DefaultTTL,
+		logger:     log.NopLogger,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// get unmarshals the cached JSON value of key into v.
+// Cache errors are logged and treated as a cache miss.
+func (s *Storage) get(ctx context.Context, key string, v interface{}) bool {
+	b, ok, err := s.cache.Get(ctx, key)
+	if err == nil && ok {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		ctxlog.Logger(ctx, s.logger).Info("msg", "cache get", "key", key, "err", err)
+		return false
+	}
+	return ok
+}
+
+// set caches the JSON value of v at key.
+// Cache errors are logged but otherwise ignored.
+func (s *Storage) set(ctx context.Context, key string, v interface{}) {
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = s.cache.Set(ctx, key, b, s.ttl)
+	}
+	if err != nil {
+		ctxlog.Logger(ctx, s.logger).Info("msg", "cache set", "key", key, "err", err)
+	}
+}
+
+// invalidate removes keys from the cache.
+func (s *Storage) invalidate(ctx context.Context, keys ...string) error {
+	if err := s.cache.Delete(ctx, keys...); err != nil {
+		ctxlog.Logger(ctx, s.logger).Info("msg", "cache delete", "keys", len(keys), "err", err)
+		return err
+	}
+	return nil
+}
+
+// RetrievePushInfo retrieves push info from the cache and retrieves
+// any uncached push info from storage.
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (map[string]*mdm.Push, error) {
+	pushInfos := make(map[string]*mdm.Push, len(ids))
+	var missed []string
+	for _, id := range ids {
+		push := new(mdm.Push)
+		if s.get(ctx, keyPush+id, push) {
+			pushInfos[id] = push
+		} else {
+			missed = append(missed, id)
+		}
+	}
+	if len(missed) < 1 {
+		return pushInfos, nil
+	}
+	retrieved, err := s.AllStorage.RetrievePushInfo(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for id, push := range retrieved {
+		s.set(ctx, keyPush+id, push)
+		pushInfos[id] = push
+	}
+	return pushInfos, nil
+}
+
+// StoreTokenUpdate invalidates the cached push info of r.
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	err := s.AllStorage.StoreTokenUpdate(r, msg)
+	if invErr := s.invalidate(r.Context, keyPush+r.ID); err == nil {
+		err = invErr
+	}
+	return err
+}
+
+// EnrollmentFromHash retrieves the enrollment ID of a cert hash.
+// Only found enrollment IDs are cached.
+func (s *Storage) EnrollmentFromHash(ctx context.Context, hash string) (string, error) {
+	var id string
+	if s.get(ctx, keyCertHash+hash, &id) {
+		return id, nil
+	}
+	id, err := s.AllStorage.EnrollmentFromHash(ctx, hash)
+	if err == nil && id != "" {
+		s.set(ctx, keyCertHash+hash, id)
+	}
+	return id, err
+}
+
+// IsCertHashAssociated reports whether hash is associated to r.
+// Only positive associations are cached.
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
+	key := keyCertID + r.ID + ":" + hash
+	var assoc bool
+	if s.get(r.Context, key, &assoc) {
+		return assoc, nil
+	}
+	assoc, err := s.AllStorage.IsCertHashAssociated(r, hash)
+	if err == nil && assoc {
+		s.set(r.Context, key, assoc)
+	}
+	return assoc, err
+}
+
+// AssociateCertHash invalidates the cached enrollment ID of hash.
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	err := s.AllStorage.AssociateCertHash(r, hash)
+	if invErr := s.invalidate(r.Context, keyCertHash+hash); err == nil {
+		err = invErr
+	}
+	return err
+}
+
+// metadata finds the metadata store of the wrapped storage.
+func (s *Storage) metadata() (storage.EnrollmentMetadataStore, error) {
+	var store storage.EnrollmentMetadataStore
+	if !storage.As(s.AllStorage, &store) {
+		return nil, errNotSupported
+	}
+	return store, nil
+}
+
+// RetrieveEnrollmentMetadata retrieves the metadata for id.
+func (s *Storage) RetrieveEnrollmentMetadata(ctx context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	store, err := s.metadata()
+	if err != nil {
+		return nil, err
+	}
+	meta := new(storage.EnrollmentMetadata)
+	if s.get(ctx, keyMetadata+id, &meta) {
+		return meta, nil
+	}
+	meta, err = store.RetrieveEnrollmentMetadata(ctx, id)
+	if err == nil {
+		// nil metadata is cached (as JSON null) too
+		s.set(ctx, keyMetadata+id, meta)
+	}
+	return meta, err
+}
+
+// StoreEnrollmentMetadata invalidates the cached metadata for id.
+func (s *Storage) StoreEnrollmentMetadata(ctx context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	store, err := s.metadata()
+	if err != nil {
+		return err
+	}
+	err = store.StoreEnrollmentMetadata(ctx, id, meta)
+	if invErr := s.invalidate(ctx, keyMetadata+id); err == nil {
+		err = invErr
+	}
+	return err
+}
+
+// enrollmentKeys returns the push info and metadata keys of the device
+// enrollment id and its user channel enrollments.
+func enrollmentKeys(id string, enrollments []*storage.Enrollment) []string {
+	keys := []string{keyPush + id, keyMetadata + id}
+	for _, e := range enrollments {
+		keys = append(keys, keyPush+e.ID, keyMetadata+e.ID)
+	}
+	return keys
+}
+
+// supersessions finds the supersession store of the wrapped storage.
+func (s *Storage) supersessions() (storage.EnrollmentSupersessionStore, error) {
+	var store storage.EnrollmentSupersessionStore
+	if !storage.As(s.AllStorage, &store) {
+		return nil, errNotSupported
+	}
+	return store, nil
+}
+
+// StoreEnrollmentSupersession invalidates the cached push info and
+// metadata of the superseded and superseding enrollments.
+func (s *Storage) StoreEnrollmentSupersession(ctx context.Context, ss *storage.EnrollmentSupersession) error {
+	store, err := s.supersessions()
+	if err != nil {
+		return err
+	}
+	err = store.StoreEnrollmentSupersession(ctx, ss)
+	keys := []string{keyPush + ss.ID, keyMetadata + ss.ID}
+	if ss.SupersededBy != "" {
+		keys = append(keys, keyPush+ss.SupersededBy, keyMetadata+ss.SupersededBy)
+	}
+	if invErr := s.invalidate(ctx, keys...); err == nil {
+		err = invErr
+	}
+	return err
+}
+
+// RetrieveEnrollmentSupersessions retrieves supersessions from the
+// wrapped storage.
+func (s *Storage) RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	store, err := s.supersessions()
+	if err != nil {
+		return nil, err
+	}
+	return store.RetrieveEnrollmentSupersessions(ctx, ids)
+}
+
+// evictions finds the eviction store of the wrapped storage.
+func (s *Storage) evictions() (storage.EnrollmentEvictionStore, error) {
+	var store storage.EnrollmentEvictionStore
+	if !storage.As(s.AllStorage, &store) {
+		return nil, errNotSupported
+	}
+	return store, nil
+}
+
+// StoreEnrollmentEviction invalidates the cached push info and metadata
+// of the evicted enrollment and, for a device, its user channel
+// enrollments.
+func (s *Storage) StoreEnrollmentEviction(ctx context.Context, eviction *storage.EnrollmentEviction) error {
+	store, err := s.evictions()
+	if err != nil {
+		return err
+	}
+	enrollments, err := s.AllStorage.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{DeviceID: eviction.ID})
+	if err != nil {
+		return err
+	}
+	err = store.StoreEnrollmentEviction(ctx, eviction)
+	if invErr := s.invalidate(ctx, enrollmentKeys(eviction.ID, enrollments)...); err == nil {
+		err = invErr
+	}
+	return err
+}
+
+// RetrieveEnrollmentEvictions retrieves evictions from the wrapped
+// storage.
+func (s *Storage) RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	store, err := s.evictions()
+	if err != nil {
+		return nil, err
+	}
+	return store.RetrieveEnrollmentEvictions(ctx, ids)
+}
+
+// MarkEnrollmentEvictionDelivered marks an eviction delivered in the
+// wrapped storage.
+func (s *Storage) MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error) {
+	store, err := s.evictions()
+	if err != nil {
+		return false, err
+	}
+	return store.MarkEnrollmentEvictionDelivered(ctx, id)
+}
+
+// DeleteEnrollmentEviction deletes an eviction from the wrapped
+// storage.
+func (s *Storage) DeleteEnrollmentEviction(ctx context.Context, id string) error {
+	store, err := s.evictions()
+	if err != nil {
+		return err
+	}
+	return store.DeleteEnrollmentEviction(ctx, id)
+}
+
+// tombstones finds the tombstone store of the wrapped storage.
+func (s *Storage) tombstones() (storage.EnrollmentTombstoneStore, error) {
+	var store storage.EnrollmentTombstoneStore
+	if !storage.As(s.AllStorage, &store) {
+		return nil, errNotSupported
+	}
+	return store, nil
+}
+
+// DepartEnrollment invalidates the cached push info and metadata of
+// the departed device enrollment and its user channel enrollments.
+func (s *Storage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	store, err := s.tombstones()
+	if err != nil {
+		return nil, err
+	}
+	enrollments, err := s.AllStorage.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{DeviceID: tombstone.ID})
+	if err != nil {
+		return nil, err
+	}
+	departed, err := store.DepartEnrollment(ctx, tombstone)
+	if invErr := s.invalidate(ctx, enrollmentKeys(tombstone.ID, enrollments)...); err == nil {
+		err = invErr
+	}
+	return departed, err
+}
+
+// RetrieveEnrollmentTombstones retrieves tombstones from the wrapped
+// storage.
+func (s *Storage) RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	store, err := s.tombstones()
+	if err != nil {
+		return nil, err
+	}
+	return store.RetrieveEnrollmentTombstones(ctx, ids)
+}
+
+// DeleteEnrollmentTombstone deletes a tombstone from the wrapped
+// storage.
+func (s *Storage) DeleteEnrollmentTombstone(ctx context.Context, id string) error {
+	store, err := s.tombstones()
+	if err != nil {
+		return err
+	}
+	return store.DeleteEnrollmentTombstone(ctx, id)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
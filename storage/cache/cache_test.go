@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type countingStorage struct {
+	storage.AllStorage
+	pushReads int
+}
+
+func (s *countingStorage) RetrievePushInfo(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+	s.pushReads++
+	ret := make(map[string]*mdm.Push)
+	for _, id := range ids {
+		ret[id] = &mdm.Push{Topic: "topic." + id, Token: []byte{1, 2, 3}}
+	}
+	return ret, nil
+}
+
+func (s *countingStorage) StoreTokenUpdate(_ *mdm.Request, _ *mdm.TokenUpdate) error {
+	return nil
+}
+
+func TestStoragePushInfo(t *testing.T) {
+	ctx := context.Background()
+	next := &countingStorage{}
+	s := New(next, NewMemoryCache(0))
+
+	for i := 0; i < 2; i++ {
+		pushInfos, err := s.RetrievePushInfo(ctx, []string{"A", "B"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have, want := pushInfos["B"].Topic, "topic.B"; have != want {
+			t.Errorf("topic: have %q, want %q", have, want)
+		}
+		if have, want := pushInfos["A"].Token.String(), "010203"; have != want {
+			t.Errorf("token: have %q, want %q", have, want)
+		}
+	}
+	if have, want := next.pushReads, 1; have != want {
+		t.Errorf("storage reads: have %d, want %d", have, want)
+	}
+
+	// invalidate one enrollment: only it should be read again
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: "A"}}
+	if err := s.StoreTokenUpdate(r, &mdm.TokenUpdate{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.RetrievePushInfo(ctx, []string{"A", "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := next.pushReads, 2; have != want {
+		t.Errorf("storage reads: have %d, want %d", have, want)
+	}
+}
+
+func TestMemoryCacheMax(t *testing.T) {
+	ctx := context.Background()
+	c := NewMemoryCache(2)
+	for _, k := range []string{"a", "b", "c"} {
+		if err := c.Set(ctx, k, []byte(k), DefaultTTL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if have, want := len(c.entries), 2; have != want {
+		t.Errorf("entries: have %d, want %d", have, want)
+	}
+	if _, ok, _ := c.Get(ctx, "c"); !ok {
+		t.Error("expected most recent entry to be cached")
+	}
+}
+
+func TestStorageSupersessionEviction(t *testing.T) {
+	ctx := context.Background()
+	next := new(mock.Storage)
+	var pushReads []string
+	next.RetrievePushInfoFunc = func(_ context.Context, ids []string) (map[string]*mdm.Push, error) {
+		pushReads = append(pushReads, ids...)
+		ret := make(map[string]*mdm.Push)
+		for _, id := range ids {
+			ret[id] = &mdm.Push{Topic: "topic." + id}
+		}
+		return ret, nil
+	}
+	next.RetrieveEnrollmentsFunc = func(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+		if filter.DeviceID == "A" {
+			return []*storage.Enrollment{{ID: "A:user"}}, nil
+		}
+		return nil, nil
+	}
+	s := New(next, NewMemoryCache(0))
+
+	ids := []string{"A", "A:user", "B", "C", "D"}
+	read := func() {
+		t.Helper()
+		pushReads = nil
+		if _, err := s.RetrievePushInfo(ctx, ids); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read()
+
+	// the evicted device and its user channel are read again
+	if err := s.StoreEnrollmentEviction(ctx, &storage.EnrollmentEviction{ID: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	read()
+	if have, want := pushReads, []string{"A", "A:user"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("evicted reads: have %v, want %v", have, want)
+	}
+	if have, want := len(next.Calls("StoreEnrollmentEviction")), 1; have != want {
+		t.Errorf("evictions: have %d, want %d", have, want)
+	}
+
+	// the superseded and superseding enrollments are read again
+	if err := s.StoreEnrollmentSupersession(ctx, &storage.EnrollmentSupersession{ID: "B", SupersededBy: "C"}); err != nil {
+		t.Fatal(err)
+	}
+	read()
+	if have, want := pushReads, []string{"B", "C"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("superseded reads: have %v, want %v", have, want)
+	}
+}
@@ -0,0 +1,282 @@
+// Package chaos implements a fault-injecting storage decorator for testing.
+package chaos
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// ErrInjected is the default error returned for injected failures.
+var ErrInjected = errors.New("chaos: injected storage error")
+
+// Fault describes the faults injected into a storage method.
+type Fault struct {
+	// Latency is added to every call.
+	Latency time.Duration
+	// Jitter is the maximum random latency added on top of Latency.
+	Jitter time.Duration
+	// ErrorRate is the probability (0 to 1) of a call failing.
+	ErrorRate float64
+	// Err is returned for failed calls. ErrInjected if nil.
+	Err error
+}
+
+// Storage is a storage decorator which injects latencies and errors
+// into storage calls. Faults are configured per method name (e.g.
+// "RetrieveNextCommand") with an optional default for the remaining
+// methods. Randomness comes from a seeded source so a given seed and
+// sequence of calls always injects the same faults.
+//
+// Failed calls do not call the wrapped storage.
+type Storage struct {
+	storage.AllStorage
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	faults  map[string]Fault
+	deflt   *Fault
+	counter map[string]int
+}
+
+// Option configures a Storage.
+type Option func(*Storage)
+
+// WithSeed sets the seed of the random source. The default is 1.
+func WithSeed(seed int64) Option {
+	return func(s *Storage) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithFault sets the fault injected into the named storage method.
+func WithFault(method string, f Fault) Option {
+	return func(s *Storage) {
+		s.faults[method] = f
+	}
+}
+
+// WithDefaultFault sets the fault injected into methods without a
+// fault of their own.
+func WithDefaultFault(f Fault) Option {
+	return func(s *Storage) {
+		s.deflt = &f
+	}
+}
+
+// New creates a new fault-injecting storage decorator of next.
+func New(next storage.AllStorage, opts ...Option) *Storage {
+	s := &Storage{
+		AllStorage: next,
+		rand:       rand.New(rand.NewSource(1)),
+		faults:     make(map[string]Fault),
+		counter:    make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Injected returns the number of errors injected per method name.
+func (s *Storage) Injected() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make(map[string]int, len(s.counter))
+	for k, v := range s.counter {
+		ret[k] = v
+	}
+	return ret
+}
+
+// inject applies the fault configured for method. It sleeps for any
+// injected latency and returns the injected error, if any.
+func (s *Storage) inject(ctx context.Context, method string) error {
+	f, ok := s.faults[method]
+	if !ok {
+		if s.deflt == nil {
+			return nil
+		}
+		f = *s.deflt
+	}
+
+	// draw from the random source under lock to keep the sequence
+	// deterministic for a given seed and call order
+	s.mu.Lock()
+	delay := f.Latency
+	if f.Jitter > 0 {
+		delay += time.Duration(s.rand.Int63n(int64(f.Jitter)))
+	}
+	fail := f.ErrorRate > 0 && s.rand.Float64() < f.ErrorRate
+	if fail {
+		s.counter[method]++
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if !fail {
+		return nil
+	}
+	if f.Err != nil {
+		return f.Err
+	}
+	return ErrInjected
+}
+
+func (s *Storage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	if err := s.inject(r.Context, "StoreAuthenticate"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreAuthenticate(r, msg)
+}
+
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	if err := s.inject(r.Context, "StoreTokenUpdate"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreTokenUpdate(r, msg)
+}
+
+func (s *Storage) Disable(r *mdm.Request) error {
+	if err := s.inject(r.Context, "Disable"); err != nil {
+		return err
+	}
+	return s.AllStorage.Disable(r)
+}
+
+func (s *Storage) DisableWithReason(r *mdm.Request, reason string) error {
+	if err := s.inject(r.Context, "DisableWithReason"); err != nil {
+		return err
+	}
+	return storage.DisableWithReason(s.AllStorage, r, reason)
+}
+
+func (s *Storage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	if err := s.inject(r.Context, "StoreUserAuthenticate"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreUserAuthenticate(r, msg)
+}
+
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	if err := s.inject(r.Context, "StoreCommandReport"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreCommandReport(r, report)
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	if err := s.inject(r.Context, "RetrieveNextCommand"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrieveNextCommand(r, skipNotNow)
+}
+
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	if err := s.inject(r.Context, "ClearQueue"); err != nil {
+		return err
+	}
+	return s.AllStorage.ClearQueue(r)
+}
+
+func (s *Storage) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	if err := s.inject(r.Context, "StoreBootstrapToken"); err != nil {
+		return err
+	}
+	return s.AllStorage.StoreBootstrapToken(r, msg)
+}
+
+func (s *Storage) RetrieveBootstrapToken(r *mdm.Request, msg *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	if err := s.inject(r.Context, "RetrieveBootstrapToken"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrieveBootstrapToken(r, msg)
+}
+
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (map[string]*mdm.Push, error) {
+	if err := s.inject(ctx, "RetrievePushInfo"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrievePushInfo(ctx, ids)
+}
+
+func (s *Storage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	if err := s.inject(ctx, "EnqueueCommand"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.EnqueueCommand(ctx, ids, cmd)
+}
+
+func (s *Storage) HasCertHash(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "HasCertHash"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.HasCertHash(r, hash)
+}
+
+func (s *Storage) EnrollmentHasCertHash(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "EnrollmentHasCertHash"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.EnrollmentHasCertHash(r, hash)
+}
+
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
+	if err := s.inject(r.Context, "IsCertHashAssociated"); err != nil {
+		return false, err
+	}
+	return s.AllStorage.IsCertHashAssociated(r, hash)
+}
+
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	if err := s.inject(r.Context, "AssociateCertHash"); err != nil {
+		return err
+	}
+	return s.AllStorage.AssociateCertHash(r, hash)
+}
+
+func (s *Storage) EnrollmentFromHash(ctx context.Context, hash string) (string, error) {
+	if err := s.inject(ctx, "EnrollmentFromHash"); err != nil {
+		return "", err
+	}
+	return s.AllStorage.EnrollmentFromHash(ctx, hash)
+}
+
+func (s *Storage) RetrieveEnrollments(ctx context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	if err := s.inject(ctx, "RetrieveEnrollments"); err != nil {
+		return nil, err
+	}
+	return s.AllStorage.RetrieveEnrollments(ctx, filter)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
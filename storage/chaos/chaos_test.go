@@ -0,0 +1,63 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage/file"
+)
+
+func injectedPattern(t *testing.T, seed int64) []bool {
+	t.Helper()
+	fs, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(fs, WithSeed(seed), WithFault("RetrievePushInfo", Fault{ErrorRate: 0.5}))
+	var ret []bool
+	for i := 0; i < 50; i++ {
+		_, err := s.RetrievePushInfo(context.Background(), nil)
+		if err != nil && !errors.Is(err, ErrInjected) {
+			t.Fatal(err)
+		}
+		ret = append(ret, err != nil)
+	}
+	if s.Injected()["RetrievePushInfo"] == 0 {
+		t.Error("expected injected errors")
+	}
+	return ret
+}
+
+func TestDeterministic(t *testing.T) {
+	a := injectedPattern(t, 42)
+	b := injectedPattern(t, 42)
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("call %d: injected errors differ for the same seed", i)
+		}
+	}
+}
+
+func TestLatency(t *testing.T) {
+	fs, err := file.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errTest := errors.New("test")
+	s := New(fs, WithDefaultFault(Fault{Latency: 10 * time.Millisecond, ErrorRate: 1, Err: errTest}))
+	start := time.Now()
+	if _, err := s.EnrollmentFromHash(context.Background(), "x"); !errors.Is(err, errTest) {
+		t.Errorf("expected test error, got: %v", err)
+	}
+	if d := time.Since(start); d < 10*time.Millisecond {
+		t.Errorf("expected injected latency, took %s", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.EnrollmentFromHash(ctx, "x"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected canceled error, got: %v", err)
+	}
+}
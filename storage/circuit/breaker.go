@@ -0,0 +1,203 @@
+// Package circuit implements a circuit breaking storage decorator.
+package circuit
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State string
+
+const (
+	// StateClosed is the normal state: calls pass through.
+	StateClosed State = "closed"
+	// StateOpen fails calls fast without calling storage.
+	StateOpen State = "open"
+	// StateHalfOpen lets a single probe call through to test recovery.
+	StateHalfOpen State = "half-open"
+)
+
+// ErrOpen is returned for calls rejected by an open circuit.
+var ErrOpen = errors.New("storage circuit breaker open")
+
+// StateChangeFunc is called when a Breaker changes state.
+// It is called in its own goroutine.
+type StateChangeFunc func(ctx context.Context, from, to State, stats Stats)
+
+// Stats are the call counts of the current window.
+type Stats struct {
+	Requests int `json:"requests"`
+	Failures int `json:"failures"`
+}
+
+// Breaker trips open when the failure rate of calls within a window
+// reaches a threshold. Calls slower than a threshold count as
+// failures. After a cooldown a single probe call is let through and
+// the breaker closes again if it succeeds.
+type Breaker struct {
+	rate     float64
+	min      int
+	slow     time.Duration
+	window   time.Duration
+	cooldown time.Duration
+	onChange StateChangeFunc
+
+	mu          sync.Mutex
+	state       State
+	stats       Stats
+	windowStart time.Time
+	openedAt    time.Time
+	probing     bool
+	trips       int
+}
+
+// Option configures a Breaker.
+type Option func(*Breaker)
+
+// WithMinRequests sets the minimum number of calls in a window before
+// the breaker can trip.
+func WithMinRequests(min int) Option {
+	return func(b *Breaker) {
+		b.min = min
+	}
+}
+
+// WithSlowThreshold counts calls taking longer than d as failures.
+func WithSlowThreshold(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.slow = d
+	}
+}
+
+// WithWindow sets the duration over which calls are counted.
+func WithWindow(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.window = d
+	}
+}
+
+// WithCooldown sets how long the breaker stays open before probing.
+func WithCooldown(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.cooldown = d
+	}
+}
+
+// WithStateChangeFunc sets the function called on state changes.
+func WithStateChangeFunc(f StateChangeFunc) Option {
+	return func(b *Breaker) {
+		b.onChange = f
+	}
+}
+
+// NewBreaker creates a new Breaker that trips when the failure rate
+// (between 0 and 1) of calls is reached.
+func NewBreaker(rate float64, opts ...Option) *Breaker {
+	b := &Breaker{
+		rate:     rate,
+		min:      20,
+		window:   10 * time.Second,
+		cooldown: 30 * time.Second,
+		state:    StateClosed,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Cooldown returns the time the breaker stays open before probing.
+func (b *Breaker) Cooldown() time.Duration {
+	return b.cooldown
+}
+
+// setState changes state and calls the state change func.
+// The lock must be held.
+func (b *Breaker) setState(to State) {
+	from := b.state
+	b.state = to
+	if to == StateOpen {
+		b.openedAt = time.Now()
+		b.trips++
+	}
+	if b.onChange != nil && from != to {
+		go b.onChange(context.Background(), from, to, b.stats)
+	}
+}
+
+// Allow returns ErrOpen if a call should fail fast. If a nil error is
+// returned then the outcome of the call must be reported with Done.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrOpen
+		}
+		b.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.probing {
+			return ErrOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Done reports the error and duration of an allowed call.
+func (b *Breaker) Done(err error, d time.Duration) {
+	failed := (err != nil && !errors.Is(err, context.Canceled)) || (b.slow > 0 && d > b.slow)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if b.state == StateHalfOpen {
+		b.probing = false
+		b.stats = Stats{}
+		b.windowStart = now
+		if failed {
+			b.setState(StateOpen)
+		} else {
+			b.setState(StateClosed)
+		}
+		return
+	}
+	if b.state != StateClosed {
+		// a call allowed before the breaker opened
+		return
+	}
+	if now.Sub(b.windowStart) > b.window {
+		b.stats = Stats{}
+		b.windowStart = now
+	}
+	b.stats.Requests++
+	if failed {
+		b.stats.Failures++
+	}
+	if b.stats.Requests >= b.min && float64(b.stats.Failures)/float64(b.stats.Requests) >= b.rate {
+		b.setState(StateOpen)
+	}
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Metrics returns the state, current window stats, and number of times
+// the breaker has tripped. Suitable for use with expvar.Func.
+func (b *Breaker) Metrics() interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return struct {
+		State State `json:"state"`
+		Stats
+		Trips int `json:"trips"`
+	}{b.state, b.stats, b.trips}
+}
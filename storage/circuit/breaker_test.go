@@ -0,0 +1,64 @@
+package circuit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(0.5, WithMinRequests(4), WithCooldown(20*time.Millisecond))
+	errTest := errors.New("test")
+
+	call := func(err error) error {
+		if allowErr := b.Allow(); allowErr != nil {
+			return allowErr
+		}
+		b.Done(err, time.Millisecond)
+		return err
+	}
+
+	// under the minimum number of requests nothing trips
+	for i := 0; i < 3; i++ {
+		call(errTest)
+	}
+	if have, want := b.State(), StateClosed; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+	call(nil)
+	if have, want := b.State(), StateOpen; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+	if err := call(nil); !errors.Is(err, ErrOpen) {
+		t.Errorf("expected open error, got: %v", err)
+	}
+
+	// a failed probe re-opens
+	time.Sleep(30 * time.Millisecond)
+	if err := call(errTest); !errors.Is(err, errTest) {
+		t.Errorf("expected probe to be allowed, got: %v", err)
+	}
+	if have, want := b.State(), StateOpen; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+
+	// a successful probe closes
+	time.Sleep(30 * time.Millisecond)
+	if err := call(nil); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := b.State(), StateClosed; have != want {
+		t.Fatalf("state: have %q, want %q", have, want)
+	}
+}
+
+func TestBreakerSlow(t *testing.T) {
+	b := NewBreaker(1, WithMinRequests(1), WithSlowThreshold(time.Millisecond))
+	if err := b.Allow(); err != nil {
+		t.Fatal(err)
+	}
+	b.Done(nil, time.Second)
+	if have, want := b.State(), StateOpen; have != want {
+		t.Errorf("state: have %q, want %q", have, want)
+	}
+}
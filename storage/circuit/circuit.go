@@ -0,0 +1,150 @@
+package circuit
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/service"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// Storage is a storage decorator which guards the storage calls made
+// for MDM requests (check-ins, command reports, certificate
+// authentication, and pushes) with a circuit breaker. While the
+// breaker is open calls fail fast with an HTTP 503 error rather than
+// piling up on a struggling backend.
+type Storage struct {
+	storage.AllStorage
+	breaker *Breaker
+}
+
+// New creates a new circuit breaking storage decorator of next.
+func New(next storage.AllStorage, breaker *Breaker) *Storage {
+	return &Storage{AllStorage: next, breaker: breaker}
+}
+
+// call calls f if the breaker allows it and records its outcome.
+func (s *Storage) call(f func() error) error {
+	if err := s.breaker.Allow(); err != nil {
+		statusErr := service.NewHTTPStatusError(http.StatusServiceUnavailable, err)
+		statusErr.Header = http.Header{
+			"Retry-After": []string{strconv.Itoa(int(s.breaker.Cooldown().Seconds()))},
+		}
+		return statusErr
+	}
+	start := time.Now()
+	err := f()
+	s.breaker.Done(err, time.Since(start))
+	return err
+}
+
+func (s *Storage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	return s.call(func() error { return s.AllStorage.StoreAuthenticate(r, msg) })
+}
+
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	return s.call(func() error { return s.AllStorage.StoreTokenUpdate(r, msg) })
+}
+
+func (s *Storage) Disable(r *mdm.Request) error {
+	return s.call(func() error { return s.AllStorage.Disable(r) })
+}
+
+func (s *Storage) DisableWithReason(r *mdm.Request, reason string) error {
+	return s.call(func() error { return storage.DisableWithReason(s.AllStorage, r, reason) })
+}
+
+func (s *Storage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	return s.call(func() error { return s.AllStorage.StoreUserAuthenticate(r, msg) })
+}
+
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	return s.call(func() error { return s.AllStorage.StoreCommandReport(r, report) })
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (cmd *mdm.Command, err error) {
+	err = s.call(func() error {
+		cmd, err = s.AllStorage.RetrieveNextCommand(r, skipNotNow)
+		return err
+	})
+	return
+}
+
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	return s.call(func() error { return s.AllStorage.ClearQueue(r) })
+}
+
+func (s *Storage) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	return s.call(func() error { return s.AllStorage.StoreBootstrapToken(r, msg) })
+}
+
+func (s *Storage) RetrieveBootstrapToken(r *mdm.Request, msg *mdm.GetBootstrapToken) (token *mdm.BootstrapToken, err error) {
+	err = s.call(func() error {
+		token, err = s.AllStorage.RetrieveBootstrapToken(r, msg)
+		return err
+	})
+	return
+}
+
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (infos map[string]*mdm.Push, err error) {
+	err = s.call(func() error {
+		infos, err = s.AllStorage.RetrievePushInfo(ctx, ids)
+		return err
+	})
+	return
+}
+
+func (s *Storage) HasCertHash(r *mdm.Request, hash string) (has bool, err error) {
+	err = s.call(func() error {
+		has, err = s.AllStorage.HasCertHash(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) EnrollmentHasCertHash(r *mdm.Request, hash string) (has bool, err error) {
+	err = s.call(func() error {
+		has, err = s.AllStorage.EnrollmentHasCertHash(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (assoc bool, err error) {
+	err = s.call(func() error {
+		assoc, err = s.AllStorage.IsCertHashAssociated(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	return s.call(func() error { return s.AllStorage.AssociateCertHash(r, hash) })
+}
+
+func (s *Storage) EnrollmentFromHash(ctx context.Context, hash string) (id string, err error) {
+	err = s.call(func() error {
+		id, err = s.AllStorage.EnrollmentFromHash(ctx, hash)
+		return err
+	})
+	return
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
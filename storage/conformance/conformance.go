@@ -0,0 +1,210 @@
+// Package conformance is an integration test harness of SQL storage
+// backends.
+//
+// It creates the schema in an empty database, runs the storage
+// conformance tests against it, and drops the schema again. Point it at
+// a scratch database of the same server (and with the same user and
+// connection options) as production to find configuration, character
+// set and collation, and permission problems before going live. For
+// example for the MySQL storage backend:
+//
+//	NANOMDM_MYSQL_STORAGE_CONFORMANCE_DSN='nanomdm:secret@tcp(db:3306)/nanomdm_scratch' \
+//		go test ./storage/mysql -run TestConformance -v
+//
+// And for the PostgreSQL storage backend:
+//
+//	go test -tags integration ./storage/pgsql -run TestConformance -v \
+//		-args -conformance-dsn 'postgres://nanomdm:secret@db/nanomdm_scratch'
+package conformance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/sqlschema"
+	"github.com/micromdm/nanomdm/storage/test"
+)
+
+// Storage is a SQL storage backend that can manage its own schema.
+// The optional storage interfaces are also tested.
+type Storage interface {
+	storage.AllStorage
+	storage.EnrollmentEvictionStore
+	storage.EnrollmentTombstoneStore
+	storage.WebhookDeliveryStore
+	storage.MessageTemplateStore
+	storage.DeclarationStore
+	storage.UnlockTokenStore
+	storage.DDMStatusStore
+	storage.CommandScheduleStore
+	storage.QueuedEnrollmentRetriever
+	storage.QueuedCommandStore
+	storage.EnrollmentMetadataStore
+	storage.UserSessionStore
+	storage.JobStore
+	storage.EventLogStore
+	storage.MetricsRollupStore
+	storage.APIKeyStore
+	storage.PendingCommandStore
+	storage.EnrollmentFreezeStore
+	storage.InventoryStore
+	storage.QueueRetriever
+	storage.CommandOwnerStore
+	storage.QueueLocker
+	storage.EnrollmentSupersessionStore
+	CheckSchema(context.Context) (*sqlschema.Report, error)
+	MigrateSchema(context.Context) (*sqlschema.Report, error)
+	SchemaEmpty(context.Context) (bool, error)
+	DropSchema(context.Context) error
+}
+
+const authenticatePlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>Authenticate</string>
+	<key>SerialNumber</key>
+	<string>CONFORMANCE1</string>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.conformance</string>
+	<key>UDID</key>
+	<string>CONFORMANCE-TEST-DEVICE</string>
+</dict>
+</plist>
+`
+
+const tokenUpdatePlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>MessageType</key>
+	<string>TokenUpdate</string>
+	<key>PushMagic</key>
+	<string>CONFORMANCE-PUSH-MAGIC</string>
+	<key>Token</key>
+	<data>
+	Y29uZm9ybWFuY2U=
+	</data>
+	<key>Topic</key>
+	<string>com.apple.mgmt.External.conformance</string>
+	<key>UDID</key>
+	<string>CONFORMANCE-TEST-DEVICE</string>
+</dict>
+</plist>
+`
+
+// enroll stores the Authenticate and TokenUpdate check-ins of the
+// conformance test device.
+func enroll(t *testing.T, store storage.ServiceStore) *mdm.Authenticate {
+	t.Helper()
+	var msgs []interface{}
+	for _, s := range []string{authenticatePlist, tokenUpdatePlist} {
+		msg, err := mdm.DecodeCheckin([]byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	auth := msgs[0].(*mdm.Authenticate)
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: auth.UDID},
+	}
+	if err := store.StoreAuthenticate(r, auth); err != nil {
+		t.Fatalf("storing Authenticate: %v", err)
+	}
+	if err := store.StoreTokenUpdate(r, msgs[1].(*mdm.TokenUpdate)); err != nil {
+		t.Fatalf("storing TokenUpdate: %v", err)
+	}
+	return auth
+}
+
+// Run creates the schema in the empty database of store, runs the
+// storage conformance tests, and drops the schema afterwards. Run
+// refuses to use a database that already has any of the tables of the
+// schema so that it can not destroy existing data.
+func Run(t *testing.T, store Storage) {
+	ctx := context.Background()
+
+	empty, err := store.SchemaEmpty(ctx)
+	if err != nil {
+		t.Fatalf("checking for existing tables: %v", err)
+	}
+	if !empty {
+		t.Fatal("database has existing tables: refusing to run against a database that is not empty")
+	}
+
+	report, err := store.MigrateSchema(ctx)
+	if err != nil {
+		t.Fatalf("creating schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := store.DropSchema(context.Background()); err != nil {
+			t.Errorf("dropping schema: %v", err)
+		}
+	})
+	if err = report.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Schema", func(t *testing.T) {
+		report, err := store.CheckSchema(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = report.Err(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	auth := enroll(t, store)
+
+	t.Run("Queue", func(t *testing.T) {
+		test.TestQueue(t, auth.UDID, store)
+		test.TestRetrieveQueue(t, auth.UDID, store)
+		test.TestExpiringQueue(t, auth.UDID, store)
+		test.TestScheduledQueue(t, auth.UDID, store)
+		test.TestQueuedEnrollments(t, auth.UDID, store)
+		test.TestPriorityQueue(t, auth.UDID, store)
+		test.TestQueuedCommands(t, auth.UDID, store)
+	})
+	t.Run("Enrollments", func(t *testing.T) {
+		test.TestTopicStats(t, auth.UDID, store)
+		test.TestEnrollments(t, auth.UDID, store)
+	})
+
+	// the enrollment tests disable the enrollment
+	auth = enroll(t, store)
+
+	t.Run("EnrollmentAliases", func(t *testing.T) {
+		test.TestEnrollmentAliases(t, auth.UDID, auth.SerialNumber, store)
+	})
+	t.Run("UserSessions", func(t *testing.T) {
+		test.TestUserSessions(t, auth.UDID, store)
+	})
+	t.Run("DDMStatusReports", func(t *testing.T) {
+		test.TestDDMStatusReports(t, auth.UDID, store)
+	})
+	t.Run("Stores", func(t *testing.T) {
+		test.TestJobs(t, store)
+		test.TestEventLog(t, store)
+		test.TestMetricsRollups(t, store)
+		test.TestAPIKeys(t, store)
+		test.TestPendingCommands(t, store)
+		test.TestEnrollmentFreezes(t, store)
+		test.TestEnrollmentEvictions(t, store)
+		test.TestEnrollmentSupersessions(t, store)
+		test.TestInventory(t, store)
+		test.TestCommandOwners(t, store)
+		test.TestWebhookDeliveries(t, store)
+		test.TestMessageTemplates(t, store)
+		test.TestDeclarations(t, store)
+		test.TestUnlockTokenRequests(t, store)
+	})
+	t.Run("EnrollmentTombstones", func(t *testing.T) {
+		test.TestEnrollmentTombstones(t, auth.UDID, auth.SerialNumber, store)
+	})
+}
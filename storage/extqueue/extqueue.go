@@ -0,0 +1,209 @@
+// Package extqueue serves enrollment command queues from an external
+// system.
+//
+// NanoMDM continues to handle check-ins, certificate authentication,
+// and command results while commands can also be queued in (and
+// retrieved from) an external system such as an existing orchestration
+// platform. This allows hybrid architectures without replacing all of
+// the storage backend.
+package extqueue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// QueueProvider is an external command queue.
+type QueueProvider interface {
+	// RetrieveNextCommand retrieves the next command queued for r.
+	// A nil command and nil error are returned if there is none.
+	// Commands that were reported as NotNow should be skipped if
+	// skipNotNow is set.
+	RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error)
+
+	// StoreCommandReport reports the result of a command (other than
+	// Idle) so that the external queue can advance. It returns false
+	// if the command was not queued in the external queue.
+	StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) (bool, error)
+}
+
+// Storage retrieves commands from an external queue once the queue of
+// the wrapped storage is empty.
+type Storage struct {
+	storage.AllStorage
+	provider QueueProvider
+	logger   log.Logger
+
+	retrieved atomic.Int64
+	reported  atomic.Int64
+}
+
+// New wraps store with the external queue of provider.
+func New(store storage.AllStorage, provider QueueProvider, logger log.Logger) *Storage {
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	return &Storage{AllStorage: store, provider: provider, logger: logger}
+}
+
+// Metrics returns the external queue counters.
+func (s *Storage) Metrics() interface{} {
+	return map[string]int64{
+		"retrieved": s.retrieved.Load(),
+		"reported":  s.reported.Load(),
+	}
+}
+
+// StoreCommandReport reports command results to the external queue.
+// Results of commands that are not from the external queue are stored
+// in the wrapped storage.
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	if report.Status == "Idle" || report.CommandUUID == "" {
+		return s.AllStorage.StoreCommandReport(r, report)
+	}
+	external, err := s.provider.StoreCommandReport(r, report)
+	if err != nil {
+		return fmt.Errorf("reporting to external queue: %w", err)
+	}
+	if !external {
+		return s.AllStorage.StoreCommandReport(r, report)
+	}
+	s.reported.Add(1)
+	ctxlog.Logger(r.Context, s.logger).Debug(
+		"msg", "reported to external queue",
+		"command_uuid", report.CommandUUID,
+		"status", report.Status,
+	)
+	// the command is not in the wrapped storage so only record that
+	// the enrollment was seen like an Idle report does
+	idle := *report
+	idle.Status = "Idle"
+	return s.AllStorage.StoreCommandReport(r, &idle)
+}
+
+// RetrieveNextCommand retrieves the next command from the wrapped
+// storage or, if its queue is empty, from the external queue.
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	cmd, err := s.AllStorage.RetrieveNextCommand(r, skipNotNow)
+	if err != nil || cmd != nil {
+		return cmd, err
+	}
+	cmd, err = s.provider.RetrieveNextCommand(r, skipNotNow)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving from external queue: %w", err)
+	}
+	if cmd != nil {
+		s.retrieved.Add(1)
+	}
+	return cmd, nil
+}
+
+const enrollmentIDHeader = "X-Enrollment-ID"
+
+// HTTPProvider is a QueueProvider for an external HTTP service.
+//
+// The next command is retrieved with a GET request to the URL with
+// the enrollment ID in the X-Enrollment-ID header and the query
+// parameter "skip_not_now=1" if NotNow commands should be skipped. The
+// service responds with the raw command plist or with HTTP 204 (No
+// Content) if there is no command. Command results are sent as the raw
+// result plist with a PUT request to the URL with the X-Enrollment-ID
+// header. The service responds with HTTP 200 (or 204) if the command
+// is from its queue or HTTP 404 if it is not.
+type HTTPProvider struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTPProvider creates a new HTTP external queue provider at url.
+func NewHTTPProvider(url string, client *http.Client) *HTTPProvider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPProvider{url: url, client: client}
+}
+
+func (p *HTTPProvider) do(r *mdm.Request, method, url string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequestWithContext(r.Context, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set(enrollmentIDHeader, r.ID)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/xml")
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody, err
+}
+
+// RetrieveNextCommand retrieves the next command from the service.
+func (p *HTTPProvider) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	url := p.url
+	if skipNotNow && strings.Contains(url, "?") {
+		url += "&skip_not_now=1"
+	} else if skipNotNow {
+		url += "?skip_not_now=1"
+	}
+	status, body, err := p.do(r, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch status {
+	case http.StatusOK:
+		if len(body) < 1 {
+			return nil, errors.New("empty command")
+		}
+		return mdm.DecodeCommand(body)
+	case http.StatusNoContent:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected HTTP status: %d", status)
+}
+
+// StoreCommandReport sends the command result to the service.
+func (p *HTTPProvider) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) (bool, error) {
+	status, _, err := p.do(r, http.MethodPut, p.url, report.Raw)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK, http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected HTTP status: %d", status)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
@@ -0,0 +1,113 @@
+package extqueue
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+const externalCommand = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Command</key>
+	<dict>
+		<key>RequestType</key>
+		<string>ProfileList</string>
+	</dict>
+	<key>CommandUUID</key>
+	<string>EXT1</string>
+</dict>
+</plist>
+`
+
+func TestStorage(t *testing.T) {
+	var queued bool
+	var reports []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if have, want := r.Header.Get("X-Enrollment-ID"), "DEV1"; have != want {
+			t.Errorf("enrollment ID header: have %q, want %q", have, want)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if !queued {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			io.WriteString(w, externalCommand)
+		case http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			results, err := mdm.DecodeCommandResults(b)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if results.CommandUUID != "EXT1" {
+				http.NotFound(w, r)
+				return
+			}
+			reports = append(reports, results.Status)
+			queued = false
+		}
+	}))
+	defer srv.Close()
+
+	store := new(mock.Storage)
+	var local *mdm.Command
+	store.RetrieveNextCommandFunc = func(*mdm.Request, bool) (*mdm.Command, error) {
+		return local, nil
+	}
+	var stored []string
+	store.StoreCommandReportFunc = func(_ *mdm.Request, results *mdm.CommandResults) error {
+		stored = append(stored, results.CommandUUID+":"+results.Status)
+		return nil
+	}
+	s := New(store, NewHTTPProvider(srv.URL, srv.Client()), nil)
+	r := &mdm.Request{Context: context.Background(), EnrollID: &mdm.EnrollID{ID: "DEV1"}}
+
+	if cmd, err := s.RetrieveNextCommand(r, false); err != nil || cmd != nil {
+		t.Fatalf("expected no command, have %v, %v", cmd, err)
+	}
+	queued = true
+	local = &mdm.Command{CommandUUID: "LOCAL1"}
+	if cmd, err := s.RetrieveNextCommand(r, false); err != nil || cmd != local {
+		t.Fatalf("expected local command first, have %v, %v", cmd, err)
+	}
+	local = nil
+	cmd, err := s.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.CommandUUID != "EXT1" || cmd.Command.RequestType != "ProfileList" {
+		t.Fatalf("expected external command, have %v", cmd)
+	}
+
+	report := func(uuid string) {
+		t.Helper()
+		raw := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>CommandUUID</key><string>` + uuid + `</string><key>Status</key><string>Acknowledged</string><key>UDID</key><string>DEV1</string></dict></plist>`)
+		results, err := mdm.DecodeCommandResults(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = s.StoreCommandReport(r, results); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("EXT1")
+	report("LOCAL1")
+	if have, want := len(reports), 1; have != want {
+		t.Fatalf("external reports: have %d, want %d", have, want)
+	}
+	if have, want := stored, []string{"EXT1:Idle", "LOCAL1:Acknowledged"}; len(have) != 2 || have[0] != want[0] || have[1] != want[1] {
+		t.Errorf("stored reports: have %v, want %v", have, want)
+	}
+	if have := s.Metrics().(map[string]int64); have["retrieved"] != 1 || have["reported"] != 1 {
+		t.Errorf("unexpected metrics: %v", have)
+	}
+}
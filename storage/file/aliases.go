@@ -0,0 +1,73 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// AliasesFilename is the append-only index of enrollment aliases.
+// Each line is an alias and enrollment ID separated by a comma.
+const AliasesFilename = "Aliases.txt"
+
+// storeEnrollmentAliases points each of aliases at enrollment id.
+func (s *FileStorage) storeEnrollmentAliases(id string, aliases []string) error {
+	if len(aliases) < 1 {
+		return nil
+	}
+	f, err := os.OpenFile(
+		path.Join(s.path, AliasesFilename),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY,
+		0644,
+	)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, alias := range aliases {
+		if _, err := f.WriteString(alias + "," + id + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveEnrollmentIDs resolves aliases using the enrollment
+// directories and the alias index. Later alias index entries supersede
+// earlier ones.
+func (s *FileStorage) ResolveEnrollmentIDs(_ context.Context, aliases []string) (map[string]string, error) {
+	resolved := make(map[string]string)
+	wanted := make(map[string]bool)
+	for _, alias := range aliases {
+		exists, err := s.newEnrollment(alias).fileExists(TokenUpdateFilename)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			resolved[alias] = alias
+		} else {
+			wanted[alias] = true
+		}
+	}
+	if len(wanted) < 1 {
+		return resolved, nil
+	}
+	f, err := os.Open(path.Join(s.path, AliasesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return resolved, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		split := strings.SplitN(scanner.Text(), ",", 2)
+		if len(split) == 2 && wanted[split[0]] {
+			resolved[split[0]] = split[1]
+		}
+	}
+	return resolved, scanner.Err()
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// APIKeysFilename is the JSON file of API keys.
+const APIKeysFilename = "apikeys.json"
+
+func (s *FileStorage) readAPIKeys() (map[string]*storage.APIKey, error) {
+	keys := make(map[string]*storage.APIKey)
+	b, err := os.ReadFile(path.Join(s.path, APIKeysFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return keys, json.Unmarshal(b, &keys)
+}
+
+func (s *FileStorage) writeAPIKeys(keys map[string]*storage.APIKey) error {
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	// contains secret hashes
+	return os.WriteFile(path.Join(s.path, APIKeysFilename), b, 0600)
+}
+
+// StoreAPIKey stores key in the API keys file.
+func (s *FileStorage) StoreAPIKey(_ context.Context, key *storage.APIKey) error {
+	s.apiKeysMu.Lock()
+	defer s.apiKeysMu.Unlock()
+	keys, err := s.readAPIKeys()
+	if err != nil {
+		return err
+	}
+	stored := *key
+	stored.CreatedAt = time.Now().UTC()
+	keys[key.Name] = &stored
+	return s.writeAPIKeys(keys)
+}
+
+// RetrieveAPIKey retrieves the API key name from the API keys file.
+func (s *FileStorage) RetrieveAPIKey(_ context.Context, name string) (*storage.APIKey, error) {
+	s.apiKeysMu.Lock()
+	defer s.apiKeysMu.Unlock()
+	keys, err := s.readAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	return keys[name], nil
+}
+
+// RetrieveAPIKeys retrieves all API keys from the API keys file.
+func (s *FileStorage) RetrieveAPIKeys(_ context.Context) ([]*storage.APIKey, error) {
+	s.apiKeysMu.Lock()
+	defer s.apiKeysMu.Unlock()
+	keys, err := s.readAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*storage.APIKey
+	for _, key := range keys {
+		ret = append(ret, key)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// DeleteAPIKey deletes the API key name from the API keys file.
+func (s *FileStorage) DeleteAPIKey(_ context.Context, name string) error {
+	s.apiKeysMu.Lock()
+	defer s.apiKeysMu.Unlock()
+	keys, err := s.readAPIKeys()
+	if err != nil {
+		return err
+	}
+	delete(keys, name)
+	return s.writeAPIKeys(keys)
+}
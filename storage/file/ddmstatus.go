@@ -0,0 +1,80 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+const (
+	DMStatusReportFilename = "DeclarativeManagement.StatusReport.json"
+	DMDeclarationsFilename = "DeclarativeManagement.Declarations.json"
+)
+
+// StoreDDMStatusReport writes the status report and (if present) the
+// declaration statuses of enrollment id to disk.
+func (s *FileStorage) StoreDDMStatusReport(_ context.Context, id string, report, declarations []byte) error {
+	e := s.newEnrollment(id)
+	if err := e.writeFile(DMStatusReportFilename, report); err != nil {
+		return err
+	}
+	if len(declarations) > 0 {
+		return e.writeFile(DMDeclarationsFilename, declarations)
+	}
+	return nil
+}
+
+// retrieveDDMStatusReport reads the status report from disk. The
+// modification times of the files are used for the timestamps.
+func (e *enrollment) retrieveDDMStatusReport() (*storage.DDMStatusReport, error) {
+	info, err := os.Stat(e.dirPrefix(DMStatusReportFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	report, err := e.readFile(DMStatusReportFilename)
+	if err != nil {
+		return nil, err
+	}
+	ret := &storage.DDMStatusReport{
+		Report:     report,
+		ReportedAt: info.ModTime(),
+	}
+	info, err = os.Stat(e.dirPrefix(DMDeclarationsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if ret.Declarations, err = e.readFile(DMDeclarationsFilename); err != nil {
+		return nil, err
+	}
+	declarationsAt := info.ModTime()
+	ret.DeclarationsAt = &declarationsAt
+	return ret, nil
+}
+
+// RetrieveDDMStatusReports reads the status reports of ids. If ids is
+// empty then every enrollment directory is checked.
+func (s *FileStorage) RetrieveDDMStatusReports(_ context.Context, ids []string) (map[string]*storage.DDMStatusReport, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	ret := make(map[string]*storage.DDMStatusReport)
+	for _, id := range ids {
+		report, err := s.newEnrollment(id).retrieveDDMStatusReport()
+		if err != nil {
+			return nil, err
+		}
+		if report != nil {
+			ret[id] = report
+		}
+	}
+	return ret, nil
+}
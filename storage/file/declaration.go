@@ -0,0 +1,267 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+const (
+	// DeclarationsFilename is the JSON file of declarations.
+	DeclarationsFilename = "declarations.json"
+
+	// DeclarationSetsFilename is the JSON file of declaration sets.
+	DeclarationSetsFilename = "declarationsets.json"
+
+	// EnrollmentDeclarationSetsFilename is the JSON file of the
+	// declaration sets assigned to enrollments.
+	EnrollmentDeclarationSetsFilename = "enrollmentdeclarationsets.json"
+)
+
+// fileDeclaration is a declaration with its update time.
+type fileDeclaration struct {
+	*storage.Declaration
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// readJSONFile unmarshals the JSON file name into v.
+// A missing file leaves v as-is.
+func (s *FileStorage) readJSONFile(name string, v interface{}) error {
+	b, err := os.ReadFile(path.Join(s.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// writeJSONFile marshals v to the JSON file name.
+func (s *FileStorage) writeJSONFile(name string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, name), b, 0644)
+}
+
+func (s *FileStorage) readDeclarations() (map[string]*fileDeclaration, error) {
+	declarations := make(map[string]*fileDeclaration)
+	return declarations, s.readJSONFile(DeclarationsFilename, &declarations)
+}
+
+func (s *FileStorage) readDeclarationSets() (map[string][]string, error) {
+	sets := make(map[string][]string)
+	return sets, s.readJSONFile(DeclarationSetsFilename, &sets)
+}
+
+func (s *FileStorage) readEnrollmentDeclarationSets() (map[string][]string, error) {
+	sets := make(map[string][]string)
+	return sets, s.readJSONFile(EnrollmentDeclarationSetsFilename, &sets)
+}
+
+// sortedUnique returns a sorted copy of values without duplicates.
+func sortedUnique(values []string) []string {
+	seen := make(map[string]bool)
+	var ret []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			ret = append(ret, v)
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// sortedDeclarations returns the declarations of identifiers (all
+// without identifiers) ordered by identifier.
+func sortedDeclarations(declarations map[string]*fileDeclaration, identifiers []string) []*storage.Declaration {
+	var ret []*storage.Declaration
+	add := func(d *fileDeclaration) {
+		declaration := *d.Declaration
+		declaration.UpdatedAt = d.UpdatedAt
+		ret = append(ret, &declaration)
+	}
+	if len(identifiers) < 1 {
+		for _, d := range declarations {
+			add(d)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, identifier := range identifiers {
+		if d, ok := declarations[identifier]; ok && !seen[identifier] {
+			seen[identifier] = true
+			add(d)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Identifier < ret[j].Identifier })
+	return ret
+}
+
+// StoreDeclaration stores declaration in the declarations file.
+func (s *FileStorage) StoreDeclaration(_ context.Context, declaration *storage.Declaration) error {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	declarations, err := s.readDeclarations()
+	if err != nil {
+		return err
+	}
+	stored := *declaration
+	declarations[declaration.Identifier] = &fileDeclaration{
+		Declaration: &stored,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	return s.writeJSONFile(DeclarationsFilename, declarations)
+}
+
+// RetrieveDeclarations retrieves declarations from the declarations file.
+func (s *FileStorage) RetrieveDeclarations(_ context.Context, identifiers []string) ([]*storage.Declaration, error) {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	declarations, err := s.readDeclarations()
+	if err != nil {
+		return nil, err
+	}
+	return sortedDeclarations(declarations, identifiers), nil
+}
+
+// DeleteDeclaration deletes the declaration of identifier from the
+// declarations file and from the declaration sets file.
+func (s *FileStorage) DeleteDeclaration(_ context.Context, identifier string) error {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	declarations, err := s.readDeclarations()
+	if err != nil {
+		return err
+	}
+	delete(declarations, identifier)
+	if err = s.writeJSONFile(DeclarationsFilename, declarations); err != nil {
+		return err
+	}
+	sets, err := s.readDeclarationSets()
+	if err != nil {
+		return err
+	}
+	for name, identifiers := range sets {
+		kept := identifiers[:0]
+		for _, i := range identifiers {
+			if i != identifier {
+				kept = append(kept, i)
+			}
+		}
+		if len(kept) > 0 {
+			sets[name] = kept
+		} else {
+			delete(sets, name)
+		}
+	}
+	return s.writeJSONFile(DeclarationSetsFilename, sets)
+}
+
+// StoreDeclarationSet stores the declaration set name in the
+// declaration sets file.
+func (s *FileStorage) StoreDeclarationSet(_ context.Context, name string, identifiers []string) error {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	declarations, err := s.readDeclarations()
+	if err != nil {
+		return err
+	}
+	for _, identifier := range identifiers {
+		if _, ok := declarations[identifier]; !ok {
+			return fmt.Errorf("declaration not found: %s", identifier)
+		}
+	}
+	sets, err := s.readDeclarationSets()
+	if err != nil {
+		return err
+	}
+	if len(identifiers) > 0 {
+		sets[name] = sortedUnique(identifiers)
+	} else {
+		delete(sets, name)
+	}
+	return s.writeJSONFile(DeclarationSetsFilename, sets)
+}
+
+// RetrieveDeclarationSets retrieves declaration sets from the
+// declaration sets file.
+func (s *FileStorage) RetrieveDeclarationSets(_ context.Context, names []string) (map[string][]string, error) {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	sets, err := s.readDeclarationSets()
+	if err != nil || len(names) < 1 {
+		return sets, err
+	}
+	ret := make(map[string][]string)
+	for _, name := range names {
+		if identifiers, ok := sets[name]; ok {
+			ret[name] = identifiers
+		}
+	}
+	return ret, nil
+}
+
+// StoreEnrollmentDeclarationSets stores the declaration sets of id in
+// the enrollment declaration sets file.
+func (s *FileStorage) StoreEnrollmentDeclarationSets(_ context.Context, id string, sets []string) error {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	enrollmentSets, err := s.readEnrollmentDeclarationSets()
+	if err != nil {
+		return err
+	}
+	if len(sets) > 0 {
+		enrollmentSets[id] = sortedUnique(sets)
+	} else {
+		delete(enrollmentSets, id)
+	}
+	return s.writeJSONFile(EnrollmentDeclarationSetsFilename, enrollmentSets)
+}
+
+// RetrieveEnrollmentDeclarationSets retrieves the declaration sets of
+// id from the enrollment declaration sets file.
+func (s *FileStorage) RetrieveEnrollmentDeclarationSets(_ context.Context, id string) ([]string, error) {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	enrollmentSets, err := s.readEnrollmentDeclarationSets()
+	if err != nil {
+		return nil, err
+	}
+	return enrollmentSets[id], nil
+}
+
+// RetrieveEnrollmentDeclarations retrieves the declarations of the
+// declaration sets of id.
+func (s *FileStorage) RetrieveEnrollmentDeclarations(_ context.Context, id string) ([]*storage.Declaration, error) {
+	s.declarationsMu.Lock()
+	defer s.declarationsMu.Unlock()
+	enrollmentSets, err := s.readEnrollmentDeclarationSets()
+	if err != nil || len(enrollmentSets[id]) < 1 {
+		return nil, err
+	}
+	sets, err := s.readDeclarationSets()
+	if err != nil {
+		return nil, err
+	}
+	var identifiers []string
+	for _, name := range enrollmentSets[id] {
+		identifiers = append(identifiers, sets[name]...)
+	}
+	if len(identifiers) < 1 {
+		return nil, nil
+	}
+	declarations, err := s.readDeclarations()
+	if err != nil {
+		return nil, err
+	}
+	return sortedDeclarations(declarations, identifiers), nil
+}
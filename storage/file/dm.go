@@ -0,0 +1,83 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+const (
+	DMEndpointFilename          = "DeclarativeManagement.Endpoint.txt"
+	DMDeclarationsTokenFilename = "DeclarativeManagement.DeclarationsToken.txt"
+)
+
+// StoreDMEnablement records the DeclarativeManagement check-in endpoint
+// and (if present) the declarations token to disk.
+func (s *FileStorage) StoreDMEnablement(r *mdm.Request, endpoint, declarationsToken string) error {
+	e := s.newEnrollment(r.ID)
+	if err := e.writeFile(DMEndpointFilename, []byte(endpoint)); err != nil {
+		return err
+	}
+	if declarationsToken != "" {
+		return e.writeFile(DMDeclarationsTokenFilename, []byte(declarationsToken))
+	}
+	return nil
+}
+
+// retrieveDMEnablement reads the Declarative Management enablement from
+// disk. The modification times of the files are used for the timestamps.
+func (e *enrollment) retrieveDMEnablement() (*storage.DMEnablement, error) {
+	info, err := os.Stat(e.dirPrefix(DMEndpointFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	endpoint, err := e.readFile(DMEndpointFilename)
+	if err != nil {
+		return nil, err
+	}
+	dm := &storage.DMEnablement{
+		LastEndpoint:  string(endpoint),
+		LastCheckinAt: info.ModTime(),
+	}
+	info, err = os.Stat(e.dirPrefix(DMDeclarationsTokenFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return dm, nil
+	} else if err != nil {
+		return nil, err
+	}
+	token, err := e.readFile(DMDeclarationsTokenFilename)
+	if err != nil {
+		return nil, err
+	}
+	dm.DeclarationsToken = string(token)
+	tokenAt := info.ModTime()
+	dm.DeclarationsTokenAt = &tokenAt
+	return dm, nil
+}
+
+// RetrieveDMEnablements reads the Declarative Management enablement for ids.
+// If ids is empty then every enrollment directory is checked.
+func (s *FileStorage) RetrieveDMEnablements(_ context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	ret := make(map[string]*storage.DMEnablement)
+	for _, id := range ids {
+		dm, err := s.newEnrollment(id).retrieveDMEnablement()
+		if err != nil {
+			return nil, err
+		}
+		if dm != nil {
+			ret[id] = dm
+		}
+	}
+	return ret, nil
+}
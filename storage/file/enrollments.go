@@ -0,0 +1,122 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// enrollmentIDs lists the IDs of every enrollment directory.
+func (s *FileStorage) enrollmentIDs() ([]string, error) {
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// retrieveEnrollment assembles an enrollment summary from the files
+// on disk. Nil is returned if the enrollment has no TokenUpdate. The
+// modification time of the TokenUpdate is used as the last seen time.
+func (e *enrollment) retrieveEnrollment() (*storage.Enrollment, error) {
+	info, err := os.Stat(e.dirPrefix(TokenUpdateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	tuBytes, err := e.readFile(TokenUpdateFilename)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := mdm.DecodeCheckin(tuBytes)
+	if err != nil {
+		return nil, err
+	}
+	tu, ok := msg.(*mdm.TokenUpdate)
+	if !ok {
+		return nil, fmt.Errorf("invalid TokenUpdate message for %s", e.id)
+	}
+	resolved := tu.Enrollment.Resolved()
+	if err = resolved.Validate(); err != nil {
+		return nil, err
+	}
+	ret := &storage.Enrollment{
+		ID:         e.id,
+		DeviceID:   resolved.DeviceChannelID,
+		Type:       resolved.Type.String(),
+		Topic:      tu.Topic,
+		LastSeenAt: info.ModTime(),
+	}
+	if resolved.IsUserChannel {
+		ret.UserID = e.id
+		ret.UserShortName = tu.UserShortName
+	}
+	disabled, err := e.fileExists(DisabledFilename)
+	if err != nil {
+		return nil, err
+	}
+	ret.Enabled = !disabled
+	info, err = os.Stat(e.dirPrefix(DisableReasonFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	reason, err := e.readFile(DisableReasonFile)
+	if err != nil {
+		return nil, err
+	}
+	ret.DisableReason = string(reason)
+	disabledAt := info.ModTime()
+	ret.DisabledAt = &disabledAt
+	return ret, nil
+}
+
+// RetrieveEnrollments reads the enrollments matching filter from disk.
+func (s *FileStorage) RetrieveEnrollments(_ context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	if filter == nil {
+		filter = &storage.EnrollmentFilter{}
+	}
+	ids := filter.IDs
+	if len(ids) < 1 {
+		var err error
+		if ids, err = s.enrollmentIDs(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(ids)
+	var enrollments []*storage.Enrollment
+	var skipped int
+	for _, id := range ids {
+		e, err := s.newEnrollment(id).retrieveEnrollment()
+		if err != nil {
+			return nil, err
+		}
+		if e == nil ||
+			(filter.DeviceID != "" && e.DeviceID != filter.DeviceID) ||
+			(filter.Enabled != nil && e.Enabled != *filter.Enabled) {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		enrollments = append(enrollments, e)
+		if filter.Limit > 0 && len(enrollments) >= filter.Limit {
+			break
+		}
+	}
+	return enrollments, nil
+}
@@ -0,0 +1,297 @@
+package file
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage/test"
+)
+
+func TestEnrollments(t *testing.T) {
+	storage, err := New("test-db-enrollments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-enrollments")
+
+	b, err := ioutil.ReadFile("../../mdm/testdata/TokenUpdate.2.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu, ok := msg.(*mdm.TokenUpdate)
+	if !ok {
+		t.Fatal("not a TokenUpdate message")
+	}
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: tu.UDID},
+	}
+	if err = storage.StoreTokenUpdate(r, tu); err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestTopicStats(t, tu.UDID, storage)
+	test.TestEnrollments(t, tu.UDID, storage)
+}
+
+func TestJobs(t *testing.T) {
+	storage, err := New("test-db-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-jobs")
+
+	test.TestJobs(t, storage)
+}
+
+func TestEventLog(t *testing.T) {
+	storage, err := New("test-db-eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-eventlog")
+
+	test.TestEventLog(t, storage)
+
+	// a new instance should continue the sequence
+	storage, err = New("test-db-eventlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.TestEventLog(t, storage)
+}
+
+// enrollTestDevice stores the Authenticate and TokenUpdate test
+// messages of a device.
+func enrollTestDevice(t *testing.T, storage *FileStorage) *mdm.Authenticate {
+	var msgs []interface{}
+	for _, name := range []string{"Authenticate.2.plist", "TokenUpdate.2.plist"} {
+		b, err := ioutil.ReadFile("../../mdm/testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mdm.DecodeCheckin(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	auth, ok := msgs[0].(*mdm.Authenticate)
+	if !ok {
+		t.Fatal("not an Authenticate message")
+	}
+	tu, ok := msgs[1].(*mdm.TokenUpdate)
+	if !ok {
+		t.Fatal("not a TokenUpdate message")
+	}
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: auth.UDID},
+	}
+	if err := storage.StoreAuthenticate(r, auth); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.StoreTokenUpdate(r, tu); err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestMetricsRollups(t *testing.T) {
+	storage, err := New("test-db-rollups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-rollups")
+
+	test.TestMetricsRollups(t, storage)
+}
+
+func TestAPIKeys(t *testing.T) {
+	storage, err := New("test-db-apikeys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-apikeys")
+
+	test.TestAPIKeys(t, storage)
+}
+
+func TestPendingCommands(t *testing.T) {
+	storage, err := New("test-db-pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-pending")
+
+	test.TestPendingCommands(t, storage)
+}
+
+func TestEnrollmentFreezes(t *testing.T) {
+	storage, err := New("test-db-freezes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-freezes")
+
+	test.TestEnrollmentFreezes(t, storage)
+}
+
+func TestEnrollmentEvictions(t *testing.T) {
+	storage, err := New("test-db-evictions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-evictions")
+
+	test.TestEnrollmentEvictions(t, storage)
+}
+
+func TestEnrollmentSupersessions(t *testing.T) {
+	storage, err := New("test-db-supersessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-supersessions")
+
+	test.TestEnrollmentSupersessions(t, storage)
+}
+
+func TestInventory(t *testing.T) {
+	storage, err := New("test-db-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-inventory")
+
+	test.TestInventory(t, storage)
+}
+
+func TestCommandOwners(t *testing.T) {
+	storage, err := New("test-db-owners")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-owners")
+
+	test.TestCommandOwners(t, storage)
+}
+
+func TestEnrollmentAliases(t *testing.T) {
+	storage, err := New("test-db-aliases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-aliases")
+
+	auth := enrollTestDevice(t, storage)
+	test.TestEnrollmentAliases(t, auth.UDID, auth.SerialNumber, storage)
+}
+
+func TestUserSessions(t *testing.T) {
+	storage, err := New("test-db-usersessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-usersessions")
+
+	auth := enrollTestDevice(t, storage)
+	test.TestUserSessions(t, auth.UDID, storage)
+}
+
+func TestDDMStatusReports(t *testing.T) {
+	storage, err := New("test-db-ddmstatus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-ddmstatus")
+
+	auth := enrollTestDevice(t, storage)
+	test.TestDDMStatusReports(t, auth.UDID, storage)
+}
+
+func TestEnrollmentTombstones(t *testing.T) {
+	storage, err := New("test-db-tombstones")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-tombstones")
+
+	auth := enrollTestDevice(t, storage)
+	test.TestEnrollmentTombstones(t, auth.UDID, auth.SerialNumber, storage)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	storage, err := New("test-db-webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-webhooks")
+
+	test.TestWebhookDeliveries(t, storage)
+}
+
+func TestMessageTemplates(t *testing.T) {
+	storage, err := New("test-db-templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-templates")
+
+	test.TestMessageTemplates(t, storage)
+}
+
+func TestDeclarations(t *testing.T) {
+	storage, err := New("test-db-declarations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-declarations")
+
+	test.TestDeclarations(t, storage)
+}
+
+func TestUnlockTokens(t *testing.T) {
+	storage, err := New("test-db-unlocktokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("test-db-unlocktokens")
+
+	b, err := ioutil.ReadFile("../../mdm/testdata/TokenUpdate.1.plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mdm.DecodeCheckin(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tu, ok := msg.(*mdm.TokenUpdate)
+	if !ok || len(tu.UnlockToken) < 1 {
+		t.Fatal("not a TokenUpdate message with an UnlockToken")
+	}
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: tu.UDID},
+	}
+	if err = storage.StoreTokenUpdate(r, tu); err != nil {
+		t.Fatal(err)
+	}
+	token, err := storage.RetrieveUnlockToken(r.Context, tu.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(token, tu.UnlockToken) {
+		t.Errorf("unlock token: have %x, want %x", token, tu.UnlockToken)
+	}
+
+	test.TestUnlockTokenRequests(t, storage)
+}
@@ -0,0 +1,87 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// EventLogFilename is the file of JSON event log entries, one per line.
+const EventLogFilename = "eventlog.json"
+
+// readLogEvents calls f for each event in the event log until f
+// returns false.
+func (s *FileStorage) readLogEvents(f func(*storage.LogEvent) bool) error {
+	file, err := os.Open(path.Join(s.path, EventLogFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+	dec := json.NewDecoder(bufio.NewReader(file))
+	for dec.More() {
+		event := new(storage.LogEvent)
+		if err = dec.Decode(event); err != nil {
+			return err
+		}
+		if !f(event) {
+			break
+		}
+	}
+	return nil
+}
+
+// StoreLogEvent appends event to the event log file.
+func (s *FileStorage) StoreLogEvent(_ context.Context, event *storage.LogEvent) error {
+	s.eventLogMu.Lock()
+	defer s.eventLogMu.Unlock()
+	if s.eventLogSeq == 0 {
+		// find the last sequence number
+		err := s.readLogEvents(func(e *storage.LogEvent) bool {
+			s.eventLogSeq = e.Seq
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	stored := *event
+	stored.Seq = s.eventLogSeq + 1
+	stored.CreatedAt = time.Now()
+	b, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path.Join(s.path, EventLogFilename), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.eventLogSeq = stored.Seq
+	event.Seq = stored.Seq
+	return nil
+}
+
+// RetrieveLogEvents reads events from the event log file.
+func (s *FileStorage) RetrieveLogEvents(_ context.Context, after int64, limit int) ([]*storage.LogEvent, error) {
+	s.eventLogMu.Lock()
+	defer s.eventLogMu.Unlock()
+	var events []*storage.LogEvent
+	err := s.readLogEvents(func(e *storage.LogEvent) bool {
+		if e.Seq > after {
+			events = append(events, e)
+		}
+		return len(events) < limit
+	})
+	return events, err
+}
@@ -0,0 +1,96 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// EvictionsFilename is the JSON file of enrollment evictions.
+const EvictionsFilename = "evictions.json"
+
+func (s *FileStorage) readEvictions() (map[string]*storage.EnrollmentEviction, error) {
+	evictions := make(map[string]*storage.EnrollmentEviction)
+	b, err := os.ReadFile(path.Join(s.path, EvictionsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return evictions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return evictions, json.Unmarshal(b, &evictions)
+}
+
+func (s *FileStorage) writeEvictions(evictions map[string]*storage.EnrollmentEviction) error {
+	b, err := json.Marshal(evictions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, EvictionsFilename), b, 0644)
+}
+
+// StoreEnrollmentEviction stores eviction in the evictions file.
+func (s *FileStorage) StoreEnrollmentEviction(_ context.Context, eviction *storage.EnrollmentEviction) error {
+	s.evictionsMu.Lock()
+	defer s.evictionsMu.Unlock()
+	evictions, err := s.readEvictions()
+	if err != nil {
+		return err
+	}
+	stored := *eviction
+	stored.CreatedAt = time.Now().UTC()
+	stored.DeliveredAt = nil
+	evictions[eviction.ID] = &stored
+	return s.writeEvictions(evictions)
+}
+
+// RetrieveEnrollmentEvictions retrieves evictions from the evictions file.
+func (s *FileStorage) RetrieveEnrollmentEvictions(_ context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	s.evictionsMu.Lock()
+	defer s.evictionsMu.Unlock()
+	evictions, err := s.readEvictions()
+	if err != nil || len(ids) < 1 {
+		return evictions, err
+	}
+	ret := make(map[string]*storage.EnrollmentEviction)
+	for _, id := range ids {
+		if eviction, ok := evictions[id]; ok {
+			ret[id] = eviction
+		}
+	}
+	return ret, nil
+}
+
+// MarkEnrollmentEvictionDelivered sets the delivery time of the eviction
+// of id in the evictions file if it is not yet set.
+func (s *FileStorage) MarkEnrollmentEvictionDelivered(_ context.Context, id string) (bool, error) {
+	s.evictionsMu.Lock()
+	defer s.evictionsMu.Unlock()
+	evictions, err := s.readEvictions()
+	if err != nil {
+		return false, err
+	}
+	eviction, ok := evictions[id]
+	if !ok || eviction.DeliveredAt != nil {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	eviction.DeliveredAt = &now
+	return true, s.writeEvictions(evictions)
+}
+
+// DeleteEnrollmentEviction deletes the eviction of id from the evictions file.
+func (s *FileStorage) DeleteEnrollmentEviction(_ context.Context, id string) error {
+	s.evictionsMu.Lock()
+	defer s.evictionsMu.Unlock()
+	evictions, err := s.readEvictions()
+	if err != nil {
+		return err
+	}
+	delete(evictions, id)
+	return s.writeEvictions(evictions)
+}
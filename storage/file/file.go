@@ -8,9 +8,11 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 const (
@@ -20,6 +22,7 @@ const (
 	SerialNumberFilename = "SerialNumber.txt"
 	IdentityCertFilename = "Identity.pem"
 	DisabledFilename     = "Disabled"
+	DisableReasonFile    = "DisableReason.txt"
 	BootstrapTokenFile   = "BootstrapToken.dat"
 
 	TokenUpdateTallyFilename = "TokenUpdate.tally.txt"
@@ -39,6 +42,33 @@ const (
 // FileStorage implements filesystem-based storage for MDM services
 type FileStorage struct {
 	path string
+
+	jobsMu sync.Mutex
+
+	eventLogMu  sync.Mutex
+	eventLogSeq int64 // last event log sequence number, loaded lazily
+
+	rollupsMu sync.Mutex
+
+	apiKeysMu sync.Mutex
+
+	pendingMu sync.Mutex
+
+	freezesMu sync.Mutex
+
+	supersessionsMu sync.Mutex
+
+	evictionsMu sync.Mutex
+
+	tombstonesMu sync.Mutex
+
+	webhookDeliveriesMu sync.Mutex
+
+	templatesMu sync.Mutex
+
+	declarationsMu sync.Mutex
+
+	unlockTokenRequestsMu sync.Mutex
 }
 
 // New creates a new FileStorage backend
@@ -166,7 +196,10 @@ func (s *FileStorage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) e
 			return err
 		}
 	}
-	return e.writeFile(AuthenticateFilename, []byte(msg.Raw))
+	if err := e.writeFile(AuthenticateFilename, []byte(msg.Raw)); err != nil {
+		return err
+	}
+	return s.storeEnrollmentAliases(r.ID, storage.AuthenticateAliases(msg))
 }
 
 // StoreTokenUpdate stores the TokenUpdate message
@@ -214,7 +247,16 @@ func (s *FileStorage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthent
 	return e.writeFile(filename, msg.Raw)
 }
 
+// Disable writes the disabled marker for the enrollment and any
+// sub-enrollments.
 func (s *FileStorage) Disable(r *mdm.Request) error {
+	return s.DisableWithReason(r, "")
+}
+
+// DisableWithReason writes the disabled marker and reason for the
+// enrollment and any sub-enrollments. The reason file is retained after
+// re-enablement.
+func (s *FileStorage) DisableWithReason(r *mdm.Request, reason string) error {
 	if r.ParentID != "" {
 		return errors.New("can only disable a device channel")
 	}
@@ -229,6 +271,9 @@ func (s *FileStorage) Disable(r *mdm.Request) error {
 		if err := e.writeFile(DisabledFilename, nil); err != nil {
 			return err
 		}
+		if err := e.writeFile(DisableReasonFile, []byte(reason)); err != nil {
+			return err
+		}
 		if err := e.resetNumericFile(TokenUpdateTallyFilename); err != nil {
 			return err
 		}
@@ -0,0 +1,77 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// FreezesFilename is the JSON file of enrollment freezes.
+const FreezesFilename = "freezes.json"
+
+func (s *FileStorage) readFreezes() (map[string]*storage.EnrollmentFreeze, error) {
+	freezes := make(map[string]*storage.EnrollmentFreeze)
+	b, err := os.ReadFile(path.Join(s.path, FreezesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return freezes, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return freezes, json.Unmarshal(b, &freezes)
+}
+
+func (s *FileStorage) writeFreezes(freezes map[string]*storage.EnrollmentFreeze) error {
+	b, err := json.Marshal(freezes)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, FreezesFilename), b, 0644)
+}
+
+// StoreEnrollmentFreeze stores freeze in the freezes file.
+func (s *FileStorage) StoreEnrollmentFreeze(_ context.Context, freeze *storage.EnrollmentFreeze) error {
+	s.freezesMu.Lock()
+	defer s.freezesMu.Unlock()
+	freezes, err := s.readFreezes()
+	if err != nil {
+		return err
+	}
+	stored := *freeze
+	stored.CreatedAt = time.Now().UTC()
+	freezes[freeze.ID] = &stored
+	return s.writeFreezes(freezes)
+}
+
+// RetrieveEnrollmentFreezes retrieves freezes from the freezes file.
+func (s *FileStorage) RetrieveEnrollmentFreezes(_ context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+	s.freezesMu.Lock()
+	defer s.freezesMu.Unlock()
+	freezes, err := s.readFreezes()
+	if err != nil || len(ids) < 1 {
+		return freezes, err
+	}
+	ret := make(map[string]*storage.EnrollmentFreeze)
+	for _, id := range ids {
+		if freeze, ok := freezes[id]; ok {
+			ret[id] = freeze
+		}
+	}
+	return ret, nil
+}
+
+// DeleteEnrollmentFreeze deletes the freeze of id from the freezes file.
+func (s *FileStorage) DeleteEnrollmentFreeze(_ context.Context, id string) error {
+	s.freezesMu.Lock()
+	defer s.freezesMu.Unlock()
+	freezes, err := s.readFreezes()
+	if err != nil {
+		return err
+	}
+	delete(freezes, id)
+	return s.writeFreezes(freezes)
+}
@@ -0,0 +1,36 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+// inventoryFilename is the JSON file of the inventory snapshot of source.
+func inventoryFilename(source string) string {
+	return "Inventory." + source + ".json"
+}
+
+// StoreInventory writes the inventory snapshot of source to the
+// enrollment directory.
+func (s *FileStorage) StoreInventory(_ context.Context, id, source string, values map[string]string) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(id).writeFile(inventoryFilename(source), b)
+}
+
+// RetrieveInventory reads the inventory snapshot of source from the
+// enrollment directory.
+func (s *FileStorage) RetrieveInventory(_ context.Context, id, source string) (map[string]string, error) {
+	b, err := s.newEnrollment(id).readFile(inventoryFilename(source))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	return values, json.Unmarshal(b, &values)
+}
@@ -0,0 +1,161 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// fileJob is the on-disk JSON representation of a job.
+type fileJob struct {
+	storage.Job
+	Targets map[string]string `json:"targets"`
+}
+
+func (s *FileStorage) jobPath(jobID string) string {
+	return path.Join(s.path, "job."+jobID+".json")
+}
+
+// jobCommandPath is the file which lists the job IDs of a command UUID.
+func (s *FileStorage) jobCommandPath(commandUUID string) string {
+	return path.Join(s.path, "job.cmd."+commandUUID+".txt")
+}
+
+// readJob reads job jobID from disk. Nil is returned if it does not exist.
+func (s *FileStorage) readJob(jobID string) (*fileJob, error) {
+	b, err := ioutil.ReadFile(s.jobPath(jobID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job := new(fileJob)
+	return job, json.Unmarshal(b, job)
+}
+
+func (s *FileStorage) writeJob(job *fileJob) error {
+	b, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.jobPath(job.ID), b, 0644)
+}
+
+// StoreJob writes the job to disk and, for command jobs, adds the job
+// ID to the list of jobs for the command UUID.
+func (s *FileStorage) StoreJob(_ context.Context, job *storage.Job, ids []string) error {
+	if len(ids) < 1 {
+		return errors.New("no id(s) supplied for job")
+	}
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	fj := &fileJob{Job: *job, Targets: make(map[string]string)}
+	fj.CreatedAt = time.Now()
+	fj.Counts = storage.JobCounts{}
+	for _, id := range ids {
+		fj.Targets[id] = storage.JobStatusQueued
+	}
+	if err := s.writeJob(fj); err != nil {
+		return err
+	}
+	if job.CommandUUID == "" {
+		return nil
+	}
+	f, err := os.OpenFile(s.jobCommandPath(job.CommandUUID), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(job.ID + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// UpdateJobTargets sets the status of ids in the job on disk.
+func (s *FileStorage) UpdateJobTargets(_ context.Context, jobID, status string, ids []string) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	job, err := s.readJob(jobID)
+	if err != nil || job == nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, ok := job.Targets[id]; ok {
+			job.Targets[id] = status
+		}
+	}
+	return s.writeJob(job)
+}
+
+// jobStatusProgresses reports whether a target may move from status
+// current to status next.
+func jobStatusProgresses(current, next string) bool {
+	if next == storage.JobStatusDelivered {
+		return current == storage.JobStatusQueued
+	}
+	return current == storage.JobStatusQueued || current == storage.JobStatusDelivered
+}
+
+// UpdateJobCommandTarget sets the status of id in the jobs of commandUUID.
+func (s *FileStorage) UpdateJobCommandTarget(_ context.Context, id, commandUUID, status string) error {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	b, err := ioutil.ReadFile(s.jobCommandPath(commandUUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, jobID := range strings.Fields(string(b)) {
+		job, err := s.readJob(jobID)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			continue
+		}
+		current, ok := job.Targets[id]
+		if !ok || !jobStatusProgresses(current, status) {
+			continue
+		}
+		job.Targets[id] = status
+		if err = s.writeJob(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RetrieveJob reads the job from disk and tallies its target statuses.
+func (s *FileStorage) RetrieveJob(_ context.Context, jobID string) (*storage.Job, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	fj, err := s.readJob(jobID)
+	if err != nil || fj == nil {
+		return nil, err
+	}
+	job := fj.Job
+	for _, status := range fj.Targets {
+		job.Counts.Add(status, 1)
+	}
+	return &job, nil
+}
+
+// RetrieveJobTargets reads the target statuses of the job from disk.
+func (s *FileStorage) RetrieveJobTargets(_ context.Context, jobID string) (map[string]string, error) {
+	s.jobsMu.Lock()
+	defer s.jobsMu.Unlock()
+	fj, err := s.readJob(jobID)
+	if err != nil || fj == nil {
+		return nil, err
+	}
+	return fj.Targets, nil
+}
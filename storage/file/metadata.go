@@ -0,0 +1,33 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+const MetadataFilename = "Metadata.json"
+
+// StoreEnrollmentMetadata writes the enrollment metadata to disk as JSON.
+func (s *FileStorage) StoreEnrollmentMetadata(_ context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return s.newEnrollment(id).writeFile(MetadataFilename, metaJSON)
+}
+
+// RetrieveEnrollmentMetadata reads the enrollment metadata from disk.
+func (s *FileStorage) RetrieveEnrollmentMetadata(_ context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	e := s.newEnrollment(id)
+	if exists, err := e.fileExists(MetadataFilename); err != nil || !exists {
+		return nil, err
+	}
+	metaJSON, err := e.readFile(MetadataFilename)
+	if err != nil {
+		return nil, err
+	}
+	meta := new(storage.EnrollmentMetadata)
+	return meta, json.Unmarshal(metaJSON, meta)
+}
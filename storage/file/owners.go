@@ -0,0 +1,28 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// commandOwnerPath is the file which contains the owner of a command UUID.
+func (s *FileStorage) commandOwnerPath(uuid string) string {
+	return path.Join(s.path, "owner.cmd."+uuid+".txt")
+}
+
+// StoreCommandOwner writes the owner of the command uuid to disk.
+func (s *FileStorage) StoreCommandOwner(_ context.Context, uuid, owner string) error {
+	return os.WriteFile(s.commandOwnerPath(uuid), []byte(owner+"\n"), 0644)
+}
+
+// RetrieveCommandOwner reads the owner of the command uuid from disk.
+func (s *FileStorage) RetrieveCommandOwner(_ context.Context, uuid string) (string, error) {
+	b, err := os.ReadFile(s.commandOwnerPath(uuid))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(b)), err
+}
@@ -0,0 +1,99 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// PendingFilename is the JSON file of commands awaiting approval.
+const PendingFilename = "pending.json"
+
+func (s *FileStorage) readPending() (map[string]*storage.PendingCommand, error) {
+	cmds := make(map[string]*storage.PendingCommand)
+	b, err := os.ReadFile(path.Join(s.path, PendingFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return cmds, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return cmds, json.Unmarshal(b, &cmds)
+}
+
+func (s *FileStorage) writePending(cmds map[string]*storage.PendingCommand) error {
+	b, err := json.Marshal(cmds)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, PendingFilename), b, 0644)
+}
+
+// StorePendingCommand stores cmd in the pending commands file.
+func (s *FileStorage) StorePendingCommand(_ context.Context, cmd *storage.PendingCommand) error {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	cmds, err := s.readPending()
+	if err != nil {
+		return err
+	}
+	stored := *cmd
+	stored.CreatedAt = time.Now().UTC()
+	cmds[cmd.CommandUUID] = &stored
+	return s.writePending(cmds)
+}
+
+// RetrievePendingCommand retrieves the pending command uuid from the
+// pending commands file.
+func (s *FileStorage) RetrievePendingCommand(_ context.Context, uuid string) (*storage.PendingCommand, error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	cmds, err := s.readPending()
+	if err != nil {
+		return nil, err
+	}
+	return cmds[uuid], nil
+}
+
+// RetrievePendingCommands retrieves all pending commands from the
+// pending commands file.
+func (s *FileStorage) RetrievePendingCommands(_ context.Context) ([]*storage.PendingCommand, error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	cmds, err := s.readPending()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*storage.PendingCommand
+	for _, cmd := range cmds {
+		ret = append(ret, cmd)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].CreatedAt.Equal(ret[j].CreatedAt) {
+			return ret[i].CommandUUID < ret[j].CommandUUID
+		}
+		return ret[i].CreatedAt.Before(ret[j].CreatedAt)
+	})
+	return ret, nil
+}
+
+// DeletePendingCommand deletes the pending command uuid from the
+// pending commands file.
+func (s *FileStorage) DeletePendingCommand(_ context.Context, uuid string) (bool, error) {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	cmds, err := s.readPending()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := cmds[uuid]; !ok {
+		return false, nil
+	}
+	delete(cmds, uuid)
+	return true, s.writePending(cmds)
+}
@@ -7,6 +7,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
 )
@@ -42,6 +45,24 @@ func (s *FileStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte)
 	return ps.StorePushCert(ctx, pemCert, pemKey)
 }
 
+// RetrievePushCertTopics lists the topics of stored push certificates.
+func (s *FileStorage) RetrievePushCertTopics(_ context.Context) ([]string, error) {
+	matches, err := filepath.Glob(path.Join(s.path, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	var topics []string
+	for _, match := range matches {
+		topic := strings.TrimSuffix(filepath.Base(match), ".pem")
+		// only include certs with a matching key
+		if _, err := os.Stat(path.Join(s.path, topic+".key")); err == nil {
+			topics = append(topics, topic)
+		}
+	}
+	sort.Strings(topics)
+	return topics, nil
+}
+
 // PushCertFileStorage is a filesystem-based PushCertStore
 type PushCertFileStorage struct {
 	certFilepath string
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"os"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 const (
@@ -37,11 +41,80 @@ func (q *queue) mkdir() error {
 	return os.MkdirAll(q.dir(), 0755)
 }
 
-func (q *queue) enqueue(uuid string, raw []byte) error {
+// expiresSuffix is the suffix of the files with the expiry of queued
+// commands that expire.
+const expiresSuffix = ".expires"
+
+// notBeforeSuffix is the suffix of the files with the schedule of
+// queued commands that are scheduled.
+const notBeforeSuffix = ".notbefore"
+
+// writeTime writes t to the file of uuid with suffix if t is not zero.
+func (q *queue) writeTime(uuid, suffix string, t time.Time) error {
+	if t.IsZero() {
+		return nil
+	}
+	return os.WriteFile(
+		path.Join(q.dir(), uuid+suffix),
+		[]byte(t.UTC().Format(time.RFC3339Nano)),
+		0755,
+	)
+}
+
+// readTime reads the time from the file of uuid with suffix or returns
+// the zero time if there is no file.
+func (q *queue) readTime(uuid, suffix string) (time.Time, error) {
+	b, err := os.ReadFile(path.Join(q.dir(), uuid+suffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, string(b))
+}
+
+// prioritySuffix is the suffix of the files with the priority of
+// queued commands that do not have the normal priority.
+const prioritySuffix = ".priority"
+
+// writePriority writes priority to the file of uuid if it is not zero.
+func (q *queue) writePriority(uuid string, priority int) error {
+	if priority == 0 {
+		return nil
+	}
+	return os.WriteFile(
+		path.Join(q.dir(), uuid+prioritySuffix),
+		[]byte(strconv.Itoa(priority)),
+		0755,
+	)
+}
+
+// priority returns the priority of the queued command uuid or zero if
+// it has the normal priority.
+func (q *queue) priority(uuid string) (int, error) {
+	b, err := os.ReadFile(path.Join(q.dir(), uuid+prioritySuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(string(b))
+}
+
+func (q *queue) enqueue(uuid string, raw []byte, expiresAt, notBefore time.Time, priority int) error {
 	err := q.mkdir()
 	if err != nil {
 		return err
 	}
+	if err = q.writeTime(uuid, expiresSuffix, expiresAt); err != nil {
+		return err
+	}
+	if err = q.writeTime(uuid, notBeforeSuffix, notBefore); err != nil {
+		return err
+	}
+	if err = q.writePriority(uuid, priority); err != nil {
+		return err
+	}
 	return os.WriteFile(
 		path.Join(q.dir(), uuid+".plist"),
 		raw,
@@ -49,6 +122,18 @@ func (q *queue) enqueue(uuid string, raw []byte) error {
 	)
 }
 
+// expiresAt returns the expiry of the queued command uuid or the zero
+// time if it does not expire.
+func (q *queue) expiresAt(uuid string) (time.Time, error) {
+	return q.readTime(uuid, expiresSuffix)
+}
+
+// notBefore returns the schedule of the queued command uuid or the zero
+// time if it is not scheduled.
+func (q *queue) notBefore(uuid string) (time.Time, error) {
+	return q.readTime(uuid, notBeforeSuffix)
+}
+
 func (q *queue) exists(uuid string) (bool, error) {
 	if _, err := os.Stat(path.Join(q.dir(), uuid+".plist")); err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -64,12 +149,38 @@ func (q *queue) move(uuid string, dest *queue) error {
 	if err != nil {
 		return err
 	}
+	for _, suffix := range []string{expiresSuffix, notBeforeSuffix, prioritySuffix} {
+		err = os.Rename(
+			path.Join(q.dir(), uuid+suffix),
+			path.Join(dest.dir(), uuid+suffix),
+		)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
 	return os.Rename(
 		path.Join(q.dir(), uuid+".plist"),
 		path.Join(dest.dir(), uuid+".plist"),
 	)
 }
 
+// expire moves the expired command uuid and its results (if any) to
+// the inactive queue.
+func (q *queue) expire(uuid string) error {
+	dest := q.e.newQueue(subInactive)
+	if err := q.move(uuid, dest); err != nil {
+		return err
+	}
+	err := os.Rename(
+		path.Join(q.dir(), uuid+".result.plist"),
+		path.Join(dest.dir(), uuid+".result.plist"),
+	)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
 func (q *queue) removeResults(uuid string) error {
 	return os.Remove(path.Join(q.dir(), uuid+".result.plist"))
 }
@@ -82,22 +193,56 @@ func (q *queue) writeResults(uuid string, raw []byte) error {
 	)
 }
 
-func (q *queue) getNext() (*mdm.Command, error) {
+// getNext returns the highest priority unexpired command of the queue,
+// otherwise the first in directory order. Commands scheduled in the
+// future are skipped if skipScheduled is set.
+func (q *queue) getNext(skipScheduled bool) (*mdm.Command, error) {
 	entries, err := os.ReadDir(q.dir())
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return nil, err
 	}
+	now := time.Now()
+	var next *mdm.Command
 	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".plist") {
+		if !strings.HasSuffix(entry.Name(), ".plist") || strings.HasSuffix(entry.Name(), ".result.plist") {
 			continue
 		}
-		raw, err := os.ReadFile(path.Join(q.dir(), entry.Name()))
-		if err != nil {
+		cmd := &mdm.Command{CommandUUID: strings.TrimSuffix(entry.Name(), ".plist")}
+		if cmd.ExpiresAt, err = q.expiresAt(cmd.CommandUUID); err != nil {
 			return nil, err
 		}
-		return mdm.DecodeCommand(raw)
+		if cmd.NotBefore, err = q.notBefore(cmd.CommandUUID); err != nil {
+			return nil, err
+		}
+		if skipScheduled && !cmd.Due(now) {
+			continue
+		}
+		if cmd.Expired(now) {
+			if err = q.expire(cmd.CommandUUID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if cmd.Priority, err = q.priority(cmd.CommandUUID); err != nil {
+			return nil, err
+		}
+		if next == nil || cmd.Priority > next.Priority {
+			next = cmd
+		}
 	}
-	return nil, nil
+	if next == nil {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(path.Join(q.dir(), next.CommandUUID+".plist"))
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := mdm.DecodeCommand(raw)
+	if err != nil {
+		return nil, err
+	}
+	cmd.ExpiresAt, cmd.NotBefore, cmd.Priority = next.ExpiresAt, next.NotBefore, next.Priority
+	return cmd, nil
 }
 
 // EnqueueCommand writes the command to disk in the queue directory
@@ -106,7 +251,7 @@ func (s *FileStorage) EnqueueCommand(_ context.Context, ids []string, command *m
 	for _, id := range ids {
 		e := s.newEnrollment(id)
 		q := e.newQueue(subQueue)
-		if err := q.enqueue(command.CommandUUID, command.Raw); err != nil {
+		if err := q.enqueue(command.CommandUUID, command.Raw, command.ExpiresAt, command.NotBefore, command.Priority); err != nil {
 			idErrs[id] = err
 		}
 	}
@@ -156,7 +301,7 @@ func (s *FileStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm
 	var q *queue
 	if !skipNotNow {
 		q = e.newQueue(subNotNow)
-		raw, err := q.getNext()
+		raw, err := q.getNext(true)
 		if err != nil {
 			return raw, err
 		}
@@ -165,7 +310,7 @@ func (s *FileStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm
 		}
 	}
 	q = e.newQueue(subQueue)
-	return q.getNext()
+	return q.getNext(true)
 }
 
 func (s *FileStorage) ClearQueue(r *mdm.Request) error {
@@ -181,13 +326,13 @@ func (s *FileStorage) ClearQueue(r *mdm.Request) error {
 		e := s.newEnrollment(id)
 		dest := e.newQueue(subInactive)
 		for _, q := range []*queue{e.newQueue(subQueue), e.newQueue(subNotNow)} {
-			raw, err := q.getNext()
+			raw, err := q.getNext(false)
 			for raw != nil && err == nil {
 				err = q.move(raw.CommandUUID, dest)
 				if err != nil {
 					return err
 				}
-				raw, err = q.getNext()
+				raw, err = q.getNext(false)
 			}
 			if err != nil {
 				return err
@@ -196,3 +341,227 @@ func (s *FileStorage) ClearQueue(r *mdm.Request) error {
 	}
 	return nil
 }
+
+// list returns the commands of the queue with their results, if any.
+func (q *queue) list(active bool) ([]*storage.QueuedCommand, error) {
+	entries, err := os.ReadDir(q.dir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	now := time.Now()
+	var cmds []*storage.QueuedCommand
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".plist") || strings.HasSuffix(entry.Name(), ".result.plist") {
+			continue
+		}
+		raw, err := os.ReadFile(path.Join(q.dir(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		cmd, err := mdm.DecodeCommand(raw)
+		if err != nil {
+			return nil, err
+		}
+		qc := &storage.QueuedCommand{
+			CommandUUID: cmd.CommandUUID,
+			RequestType: cmd.Command.RequestType,
+			Active:      active,
+			Command:     raw,
+		}
+		// queued commands are moved but not rewritten
+		if info, err := entry.Info(); err == nil {
+			qc.CreatedAt = info.ModTime()
+		}
+		qc.Result, err = os.ReadFile(path.Join(q.dir(), cmd.CommandUUID+".result.plist"))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if qc.Result != nil {
+			results, err := mdm.DecodeCommandResults(qc.Result)
+			if err != nil {
+				return nil, err
+			}
+			qc.Status = results.Status
+		}
+		expiresAt, err := q.expiresAt(cmd.CommandUUID)
+		if err != nil {
+			return nil, err
+		}
+		if !expiresAt.IsZero() {
+			qc.SetExpiry(&expiresAt, now)
+		}
+		notBefore, err := q.notBefore(cmd.CommandUUID)
+		if err != nil {
+			return nil, err
+		}
+		if !notBefore.IsZero() {
+			qc.NotBefore = &notBefore
+		}
+		if qc.Priority, err = q.priority(cmd.CommandUUID); err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, qc)
+	}
+	return cmds, nil
+}
+
+// RetrieveQueue retrieves the commands with results followed by the
+// NotNow, queued, and inactive commands of id.
+func (s *FileStorage) RetrieveQueue(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+	e := s.newEnrollment(id)
+	var cmds []*storage.QueuedCommand
+	for _, sub := range []string{subDone, subNotNow, subQueue, subInactive} {
+		qCmds, err := e.newQueue(sub).list(sub != subInactive)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, qCmds...)
+	}
+	return cmds, nil
+}
+
+// RetrieveQueuedCommands retrieves the unexpired NotNow and queued
+// commands of id, highest priority first.
+func (s *FileStorage) RetrieveQueuedCommands(_ context.Context, id string) ([]*storage.QueuedCommand, error) {
+	e := s.newEnrollment(id)
+	var cmds []*storage.QueuedCommand
+	for _, sub := range []string{subNotNow, subQueue} {
+		qCmds, err := e.newQueue(sub).list(true)
+		if err != nil {
+			return nil, err
+		}
+		for _, cmd := range qCmds {
+			if cmd.Status != storage.StatusExpired {
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return cmds[i].Priority > cmds[j].Priority
+	})
+	return cmds, nil
+}
+
+// CancelQueuedCommand moves the NotNow or queued command uuid of id to
+// the inactive queue.
+func (s *FileStorage) CancelQueuedCommand(_ context.Context, id, uuid string) (bool, error) {
+	e := s.newEnrollment(id)
+	for _, sub := range []string{subQueue, subNotNow} {
+		q := e.newQueue(sub)
+		found, err := q.exists(uuid)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			// canceled commands are deactivated like expired commands
+			return true, q.expire(uuid)
+		}
+	}
+	return false, nil
+}
+
+// RetrieveScheduledEnrollments retrieves the IDs of enrollments with
+// NotNow or queued commands scheduled after after and at or before
+// until.
+func (s *FileStorage) RetrieveScheduledEnrollments(_ context.Context, after, until time.Time) ([]string, error) {
+	ids, err := s.enrollmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []string
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		found, err := e.scheduled(after, until)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			scheduled = append(scheduled, id)
+		}
+	}
+	return scheduled, nil
+}
+
+// scheduled reports whether the NotNow or queued commands of e include
+// a command scheduled after after and at or before until.
+func (e *enrollment) scheduled(after, until time.Time) (bool, error) {
+	for _, sub := range []string{subNotNow, subQueue} {
+		q := e.newQueue(sub)
+		entries, err := os.ReadDir(q.dir())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		for _, entry := range entries {
+			uuid := strings.TrimSuffix(entry.Name(), notBeforeSuffix)
+			if uuid == entry.Name() {
+				continue
+			}
+			notBefore, err := q.notBefore(uuid)
+			if err != nil {
+				return false, err
+			}
+			if notBefore.After(after) && !notBefore.After(until) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// RetrieveQueuedEnrollments retrieves the IDs of enabled enrollments
+// with due, unexpired NotNow or queued commands.
+func (s *FileStorage) RetrieveQueuedEnrollments(_ context.Context) ([]string, error) {
+	ids, err := s.enrollmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	now := time.Now()
+	var queued []string
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		disabled, err := e.fileExists(DisabledFilename)
+		if err != nil {
+			return nil, err
+		}
+		if disabled {
+			continue
+		}
+		found, err := e.queued(now)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			queued = append(queued, id)
+		}
+	}
+	return queued, nil
+}
+
+// queued reports whether the NotNow or queued commands of e include a
+// command that is due and unexpired at now.
+func (e *enrollment) queued(now time.Time) (bool, error) {
+	for _, sub := range []string{subNotNow, subQueue} {
+		q := e.newQueue(sub)
+		entries, err := os.ReadDir(q.dir())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".plist") || strings.HasSuffix(entry.Name(), ".result.plist") {
+				continue
+			}
+			cmd := &mdm.Command{CommandUUID: strings.TrimSuffix(entry.Name(), ".plist")}
+			if cmd.ExpiresAt, err = q.expiresAt(cmd.CommandUUID); err != nil {
+				return false, err
+			}
+			if cmd.NotBefore, err = q.notBefore(cmd.CommandUUID); err != nil {
+				return false, err
+			}
+			if cmd.Due(now) && !cmd.Expired(now) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
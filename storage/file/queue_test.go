@@ -13,5 +13,11 @@ func TestQueue(t *testing.T) {
 		t.Fatal(err)
 	}
 	test.TestQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestRetrieveQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestExpiringQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestScheduledQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestQueuedEnrollments(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestPriorityQueue(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
+	test.TestQueuedCommands(t, "EA4E19F1-7F8B-493D-BEAB-264B33BCF4E6", storage)
 	os.RemoveAll("test-db")
 }
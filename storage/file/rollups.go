@@ -0,0 +1,78 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// RollupsFilename is the JSON file of metrics rollups.
+const RollupsFilename = "rollups.json"
+
+func (s *FileStorage) readRollups() ([]*storage.MetricsRollup, error) {
+	b, err := os.ReadFile(path.Join(s.path, RollupsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var rollups []*storage.MetricsRollup
+	return rollups, json.Unmarshal(b, &rollups)
+}
+
+// AddMetricsRollup adds rollup to the rollups file.
+func (s *FileStorage) AddMetricsRollup(_ context.Context, rollup *storage.MetricsRollup) error {
+	s.rollupsMu.Lock()
+	defer s.rollupsMu.Unlock()
+	rollups, err := s.readRollups()
+	if err != nil {
+		return err
+	}
+	start := rollup.Start.UTC()
+	var found bool
+	for _, r := range rollups {
+		if r.Period == rollup.Period && r.Start.Equal(start) {
+			r.Checkins += rollup.Checkins
+			r.Commands += rollup.Commands
+			r.Errors += rollup.Errors
+			found = true
+			break
+		}
+	}
+	if !found {
+		stored := *rollup
+		stored.Start = start
+		rollups = append(rollups, &stored)
+		sort.Slice(rollups, func(i, j int) bool {
+			return rollups[i].Start.Before(rollups[j].Start)
+		})
+	}
+	b, err := json.Marshal(rollups)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, RollupsFilename), b, 0644)
+}
+
+// RetrieveMetricsRollups reads rollups from the rollups file.
+func (s *FileStorage) RetrieveMetricsRollups(_ context.Context, period string, since time.Time) ([]*storage.MetricsRollup, error) {
+	s.rollupsMu.Lock()
+	defer s.rollupsMu.Unlock()
+	rollups, err := s.readRollups()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*storage.MetricsRollup
+	for _, r := range rollups {
+		if r.Period == period && !r.Start.Before(since) {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
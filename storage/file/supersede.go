@@ -0,0 +1,62 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// SupersessionsFilename is the JSON file of enrollment supersessions.
+const SupersessionsFilename = "supersessions.json"
+
+func (s *FileStorage) readSupersessions() (map[string]*storage.EnrollmentSupersession, error) {
+	supersessions := make(map[string]*storage.EnrollmentSupersession)
+	b, err := os.ReadFile(path.Join(s.path, SupersessionsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return supersessions, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return supersessions, json.Unmarshal(b, &supersessions)
+}
+
+// StoreEnrollmentSupersession stores ss in the supersessions file.
+func (s *FileStorage) StoreEnrollmentSupersession(_ context.Context, ss *storage.EnrollmentSupersession) error {
+	s.supersessionsMu.Lock()
+	defer s.supersessionsMu.Unlock()
+	supersessions, err := s.readSupersessions()
+	if err != nil {
+		return err
+	}
+	stored := *ss
+	stored.CreatedAt = time.Now().UTC()
+	supersessions[ss.ID] = &stored
+	b, err := json.Marshal(supersessions)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, SupersessionsFilename), b, 0644)
+}
+
+// RetrieveEnrollmentSupersessions retrieves supersessions from the
+// supersessions file.
+func (s *FileStorage) RetrieveEnrollmentSupersessions(_ context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	s.supersessionsMu.Lock()
+	defer s.supersessionsMu.Unlock()
+	supersessions, err := s.readSupersessions()
+	if err != nil || len(ids) < 1 {
+		return supersessions, err
+	}
+	ret := make(map[string]*storage.EnrollmentSupersession)
+	for _, id := range ids {
+		if ss, ok := supersessions[id]; ok {
+			ret[id] = ss
+		}
+	}
+	return ret, nil
+}
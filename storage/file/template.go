@@ -0,0 +1,101 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// MessageTemplatesFilename is the JSON file of message templates.
+const MessageTemplatesFilename = "templates.json"
+
+func (s *FileStorage) readMessageTemplates() ([]*storage.MessageTemplate, error) {
+	var templates []*storage.MessageTemplate
+	b, err := os.ReadFile(path.Join(s.path, MessageTemplatesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return templates, json.Unmarshal(b, &templates)
+}
+
+func (s *FileStorage) writeMessageTemplates(templates []*storage.MessageTemplate) error {
+	b, err := json.Marshal(templates)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, MessageTemplatesFilename), b, 0644)
+}
+
+func sameVariant(t *storage.MessageTemplate, name, group, locale string) bool {
+	return t.Name == name && t.Group == group && t.Locale == locale
+}
+
+// StoreMessageTemplate stores the template variant in the templates file.
+func (s *FileStorage) StoreMessageTemplate(_ context.Context, template *storage.MessageTemplate) error {
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	templates, err := s.readMessageTemplates()
+	if err != nil {
+		return err
+	}
+	stored := *template
+	stored.UpdatedAt = time.Now().UTC()
+	for i, t := range templates {
+		if sameVariant(t, template.Name, template.Group, template.Locale) {
+			templates[i] = &stored
+			return s.writeMessageTemplates(templates)
+		}
+	}
+	return s.writeMessageTemplates(append(templates, &stored))
+}
+
+// RetrieveMessageTemplates retrieves template variants from the templates file.
+func (s *FileStorage) RetrieveMessageTemplates(_ context.Context, name string) ([]*storage.MessageTemplate, error) {
+	s.templatesMu.Lock()
+	templates, err := s.readMessageTemplates()
+	s.templatesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*storage.MessageTemplate
+	for _, t := range templates {
+		if name == "" || t.Name == name {
+			ret = append(ret, t)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		if ret[i].Group != ret[j].Group {
+			return ret[i].Group < ret[j].Group
+		}
+		return ret[i].Locale < ret[j].Locale
+	})
+	return ret, nil
+}
+
+// DeleteMessageTemplate deletes the template variant from the templates file.
+func (s *FileStorage) DeleteMessageTemplate(_ context.Context, name, group, locale string) error {
+	s.templatesMu.Lock()
+	defer s.templatesMu.Unlock()
+	templates, err := s.readMessageTemplates()
+	if err != nil {
+		return err
+	}
+	kept := templates[:0]
+	for _, t := range templates {
+		if !sameVariant(t, name, group, locale) {
+			kept = append(kept, t)
+		}
+	}
+	return s.writeMessageTemplates(kept)
+}
@@ -0,0 +1,172 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TombstonesFilename is the JSON file of enrollment tombstones.
+const TombstonesFilename = "tombstones.json"
+
+func (s *FileStorage) readTombstones() (map[string]*storage.EnrollmentTombstone, error) {
+	tombstones := make(map[string]*storage.EnrollmentTombstone)
+	b, err := os.ReadFile(path.Join(s.path, TombstonesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return tombstones, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return tombstones, json.Unmarshal(b, &tombstones)
+}
+
+func (s *FileStorage) writeTombstones(tombstones map[string]*storage.EnrollmentTombstone) error {
+	b, err := json.Marshal(tombstones)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, TombstonesFilename), b, 0644)
+}
+
+// purgeIndexLines rewrites the comma-separated index file name without
+// the lines whose field n is one of ids.
+func (s *FileStorage) purgeIndexLines(name string, n int, ids map[string]bool) error {
+	b, err := os.ReadFile(path.Join(s.path, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var kept strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(string(b)))
+	for scanner.Scan() {
+		split := strings.Split(scanner.Text(), ",")
+		if len(split) > n && ids[split[n]] {
+			continue
+		}
+		kept.WriteString(scanner.Text() + "\n")
+	}
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+	tmp := path.Join(s.path, name+".tmp")
+	if err = os.WriteFile(tmp, []byte(kept.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(s.path, name))
+}
+
+// DepartEnrollment stores the tombstone in the tombstones file and
+// removes the enrollment directories of the device and its user
+// channel enrollments along with their entries in the other files.
+func (s *FileStorage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	e := s.newEnrollment(tombstone.ID)
+	info, err := os.Stat(e.dirPrefix(AuthenticateFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	stored := *tombstone
+	enrolledAt := info.ModTime().UTC()
+	stored.EnrolledAt = &enrolledAt
+	serial, err := e.readFile(SerialNumberFilename)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	stored.SerialNumber = string(serial)
+	enrollment, err := e.retrieveEnrollment()
+	if err != nil {
+		return nil, err
+	}
+	if enrollment != nil {
+		lastSeenAt := enrollment.LastSeenAt.UTC()
+		stored.Type, stored.LastSeenAt = enrollment.Type, &lastSeenAt
+	}
+	stored.DepartedAt = time.Now().UTC()
+
+	s.tombstonesMu.Lock()
+	tombstones, err := s.readTombstones()
+	if err == nil {
+		tombstones[stored.ID] = &stored
+		err = s.writeTombstones(tombstones)
+	}
+	s.tombstonesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := map[string]bool{stored.ID: true}
+	for _, id := range e.listSubEnrollments() {
+		ids[id] = true
+	}
+	for id := range ids {
+		if err = os.RemoveAll(s.newEnrollment(id).dir()); err != nil {
+			return nil, err
+		}
+		if err = s.DeleteEnrollmentFreeze(ctx, id); err != nil {
+			return nil, err
+		}
+		if err = s.DeleteEnrollmentEviction(ctx, id); err != nil {
+			return nil, err
+		}
+		if err = s.StoreEnrollmentDeclarationSets(ctx, id, nil); err != nil {
+			return nil, err
+		}
+	}
+	s.supersessionsMu.Lock()
+	supersessions, err := s.readSupersessions()
+	if err == nil {
+		for id := range ids {
+			delete(supersessions, id)
+		}
+		var b []byte
+		if b, err = json.Marshal(supersessions); err == nil {
+			err = os.WriteFile(path.Join(s.path, SupersessionsFilename), b, 0644)
+		}
+	}
+	s.supersessionsMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err = s.purgeIndexLines(AliasesFilename, 1, ids); err != nil {
+		return nil, err
+	}
+	return &stored, s.purgeIndexLines(CertAuthAssociationsFilename, 0, ids)
+}
+
+// RetrieveEnrollmentTombstones retrieves tombstones from the tombstones file.
+func (s *FileStorage) RetrieveEnrollmentTombstones(_ context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	s.tombstonesMu.Lock()
+	defer s.tombstonesMu.Unlock()
+	tombstones, err := s.readTombstones()
+	if err != nil || len(ids) < 1 {
+		return tombstones, err
+	}
+	ret := make(map[string]*storage.EnrollmentTombstone)
+	for _, id := range ids {
+		if tombstone, ok := tombstones[id]; ok {
+			ret[id] = tombstone
+		}
+	}
+	return ret, nil
+}
+
+// DeleteEnrollmentTombstone deletes the tombstone of id from the tombstones file.
+func (s *FileStorage) DeleteEnrollmentTombstone(_ context.Context, id string) error {
+	s.tombstonesMu.Lock()
+	defer s.tombstonesMu.Unlock()
+	tombstones, err := s.readTombstones()
+	if err != nil {
+		return err
+	}
+	delete(tombstones, id)
+	return s.writeTombstones(tombstones)
+}
@@ -0,0 +1,68 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// pending counts the commands in the queue directory.
+func (q *queue) pending() (int, error) {
+	entries, err := os.ReadDir(q.dir())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	var ct int
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, ".plist") && !strings.HasSuffix(name, ".result.plist") {
+			ct++
+		}
+	}
+	return ct, nil
+}
+
+// RetrieveTopicStats reads every enrollment from disk and tallies
+// them by topic.
+func (s *FileStorage) RetrieveTopicStats(_ context.Context) ([]*storage.TopicStats, error) {
+	ids, err := s.enrollmentIDs()
+	if err != nil {
+		return nil, err
+	}
+	byTopic := make(map[string]*storage.TopicStats)
+	for _, id := range ids {
+		e := s.newEnrollment(id)
+		enrollment, err := e.retrieveEnrollment()
+		if err != nil {
+			return nil, err
+		} else if enrollment == nil {
+			continue
+		}
+		ts, ok := byTopic[enrollment.Topic]
+		if !ok {
+			ts = &storage.TopicStats{Topic: enrollment.Topic}
+			byTopic[enrollment.Topic] = ts
+		}
+		ts.Enrollments++
+		if enrollment.Enabled {
+			ts.ActiveEnrollments++
+		}
+		for _, sub := range []string{subQueue, subNotNow} {
+			ct, err := e.newQueue(sub).pending()
+			if err != nil {
+				return nil, err
+			}
+			ts.PendingCommands += ct
+		}
+	}
+	stats := make([]*storage.TopicStats, 0, len(byTopic))
+	for _, ts := range byTopic {
+		stats = append(stats, ts)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Topic < stats[j].Topic })
+	return stats, nil
+}
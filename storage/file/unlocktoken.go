@@ -0,0 +1,106 @@
+package file
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// UnlockTokenRequestsFilename is the JSON file of UnlockToken requests.
+const UnlockTokenRequestsFilename = "unlocktokenrequests.json"
+
+// RetrieveUnlockToken reads the UnlockToken of id from its file.
+func (s *FileStorage) RetrieveUnlockToken(_ context.Context, id string) ([]byte, error) {
+	token, err := s.newEnrollment(id).readFile(UnlockTokenFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return token, err
+}
+
+func (s *FileStorage) readUnlockTokenRequests() (map[string]*storage.UnlockTokenRequest, error) {
+	reqs := make(map[string]*storage.UnlockTokenRequest)
+	return reqs, s.readJSONFile(UnlockTokenRequestsFilename, &reqs)
+}
+
+// StoreUnlockTokenRequest stores req in the UnlockToken requests file.
+func (s *FileStorage) StoreUnlockTokenRequest(_ context.Context, req *storage.UnlockTokenRequest) error {
+	s.unlockTokenRequestsMu.Lock()
+	defer s.unlockTokenRequestsMu.Unlock()
+	reqs, err := s.readUnlockTokenRequests()
+	if err != nil {
+		return err
+	}
+	stored := *req
+	stored.ApprovedBy, stored.ApprovedAt = "", nil
+	stored.CreatedAt = time.Now().UTC()
+	reqs[req.ID] = &stored
+	return s.writeJSONFile(UnlockTokenRequestsFilename, reqs)
+}
+
+// RetrieveUnlockTokenRequests retrieves requests from the UnlockToken
+// requests file.
+func (s *FileStorage) RetrieveUnlockTokenRequests(_ context.Context, ids []string) ([]*storage.UnlockTokenRequest, error) {
+	s.unlockTokenRequestsMu.Lock()
+	defer s.unlockTokenRequestsMu.Unlock()
+	reqs, err := s.readUnlockTokenRequests()
+	if err != nil {
+		return nil, err
+	}
+	var ret []*storage.UnlockTokenRequest
+	if len(ids) < 1 {
+		for _, req := range reqs {
+			ret = append(ret, req)
+		}
+	}
+	for _, id := range ids {
+		if req, ok := reqs[id]; ok {
+			ret = append(ret, req)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].CreatedAt.Equal(ret[j].CreatedAt) {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].CreatedAt.Before(ret[j].CreatedAt)
+	})
+	return ret, nil
+}
+
+// ApproveUnlockTokenRequest approves the request id in the UnlockToken
+// requests file.
+func (s *FileStorage) ApproveUnlockTokenRequest(_ context.Context, id, approvedBy string) (bool, error) {
+	s.unlockTokenRequestsMu.Lock()
+	defer s.unlockTokenRequestsMu.Unlock()
+	reqs, err := s.readUnlockTokenRequests()
+	if err != nil {
+		return false, err
+	}
+	req, ok := reqs[id]
+	if !ok || req.ApprovedAt != nil {
+		return false, nil
+	}
+	now := time.Now().UTC()
+	req.ApprovedBy, req.ApprovedAt = approvedBy, &now
+	return true, s.writeJSONFile(UnlockTokenRequestsFilename, reqs)
+}
+
+// DeleteUnlockTokenRequest deletes the request id from the UnlockToken
+// requests file.
+func (s *FileStorage) DeleteUnlockTokenRequest(_ context.Context, id string) (bool, error) {
+	s.unlockTokenRequestsMu.Lock()
+	defer s.unlockTokenRequestsMu.Unlock()
+	reqs, err := s.readUnlockTokenRequests()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := reqs[id]; !ok {
+		return false, nil
+	}
+	delete(reqs, id)
+	return true, s.writeJSONFile(UnlockTokenRequestsFilename, reqs)
+}
@@ -0,0 +1,74 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// UserSessionsFilename is the JSON file of the user sessions of a device.
+const UserSessionsFilename = "UserSessions.json"
+
+// retrieveUserSessions reads the user sessions of the enrollment.
+// Nil is returned if the enrollment has no user sessions.
+func (e *enrollment) retrieveUserSessions() (*storage.UserSessions, error) {
+	b, err := e.readFile(UserSessionsFilename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sessions := new(storage.UserSessions)
+	return sessions, json.Unmarshal(b, sessions)
+}
+
+func (s *FileStorage) StoreUserSession(r *mdm.Request, userShortName, userLongName string) error {
+	if r.ParentID == "" {
+		return errors.New("can only store a user session for a user channel")
+	}
+	e := s.newEnrollment(r.ParentID)
+	sessions, err := e.retrieveUserSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	session := &storage.UserSession{
+		EnrollmentID:  r.ID,
+		UserShortName: userShortName,
+		UserLongName:  userLongName,
+		StartedAt:     now,
+		LastSeenAt:    now,
+	}
+	if sessions == nil {
+		sessions = &storage.UserSessions{}
+	} else if sessions.Current != nil && sessions.Current.EnrollmentID == r.ID {
+		session.StartedAt = sessions.Current.StartedAt
+	} else {
+		sessions.Previous = sessions.Current
+	}
+	sessions.Current = session
+	b, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+	return e.writeFile(UserSessionsFilename, b)
+}
+
+func (s *FileStorage) RetrieveUserSessions(_ context.Context, deviceIDs []string) (map[string]*storage.UserSessions, error) {
+	ret := make(map[string]*storage.UserSessions)
+	for _, id := range deviceIDs {
+		sessions, err := s.newEnrollment(id).retrieveUserSessions()
+		if err != nil {
+			return nil, err
+		}
+		if sessions != nil {
+			ret[id] = sessions
+		}
+	}
+	return ret, nil
+}
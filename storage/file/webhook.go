@@ -0,0 +1,102 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// WebhookDeliveriesFilename is the JSON file of webhook deliveries.
+const WebhookDeliveriesFilename = "webhookdeliveries.json"
+
+func (s *FileStorage) readWebhookDeliveries() (map[string]*storage.WebhookDelivery, error) {
+	deliveries := make(map[string]*storage.WebhookDelivery)
+	b, err := os.ReadFile(path.Join(s.path, WebhookDeliveriesFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return deliveries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return deliveries, json.Unmarshal(b, &deliveries)
+}
+
+// StoreWebhookDelivery stores d in the webhook deliveries file.
+func (s *FileStorage) StoreWebhookDelivery(_ context.Context, d *storage.WebhookDelivery) error {
+	s.webhookDeliveriesMu.Lock()
+	defer s.webhookDeliveriesMu.Unlock()
+	deliveries, err := s.readWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+	stored := *d
+	stored.CreatedAt = time.Now().UTC()
+	if existing, ok := deliveries[d.ID]; ok {
+		stored.CreatedAt = existing.CreatedAt
+	}
+	deliveries[d.ID] = &stored
+	b, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, WebhookDeliveriesFilename), b, 0644)
+}
+
+// RetrieveWebhookDeliveries retrieves the deliveries matching filter
+// from the webhook deliveries file.
+func (s *FileStorage) RetrieveWebhookDeliveries(_ context.Context, filter *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error) {
+	if filter == nil {
+		filter = &storage.WebhookDeliveryFilter{}
+	}
+	s.webhookDeliveriesMu.Lock()
+	deliveries, err := s.readWebhookDeliveries()
+	s.webhookDeliveriesMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, id := range filter.IDs {
+		ids[id] = true
+	}
+	var ret []*storage.WebhookDelivery
+	for _, d := range deliveries {
+		if d.Dead != filter.Dead ||
+			(len(ids) > 0 && !ids[d.ID]) ||
+			(!filter.DueBy.IsZero() && d.NextAttemptAt.After(filter.DueBy)) {
+			continue
+		}
+		ret = append(ret, d)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].NextAttemptAt.Equal(ret[j].NextAttemptAt) {
+			return ret[i].ID < ret[j].ID
+		}
+		return ret[i].NextAttemptAt.Before(ret[j].NextAttemptAt)
+	})
+	if filter.Limit > 0 && len(ret) > filter.Limit {
+		ret = ret[:filter.Limit]
+	}
+	return ret, nil
+}
+
+// DeleteWebhookDelivery deletes the delivery of id from the webhook
+// deliveries file.
+func (s *FileStorage) DeleteWebhookDelivery(_ context.Context, id string) error {
+	s.webhookDeliveriesMu.Lock()
+	defer s.webhookDeliveriesMu.Unlock()
+	deliveries, err := s.readWebhookDeliveries()
+	if err != nil {
+		return err
+	}
+	delete(deliveries, id)
+	b, err := json.Marshal(deliveries)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path.Join(s.path, WebhookDeliveriesFilename), b, 0644)
+}
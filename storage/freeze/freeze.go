@@ -0,0 +1,197 @@
+// Package freeze blocks new commands and pushes to frozen enrollments.
+//
+// Frozen enrollments (e.g. during a legal hold or investigation) can
+// still check-in and report command results: only enqueueing new
+// commands and sending pushes is blocked. Freezing a device enrollment
+// also freezes its user channel enrollments.
+package freeze
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+
+	"github.com/micromdm/nanolib/log"
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// ErrFrozen is the per-enrollment error for blocked enqueues and pushes.
+var ErrFrozen = errors.New("enrollment frozen")
+
+// frozen returns the frozen ids among ids. User channel IDs (of the form
+// "device:user") are frozen if their device is frozen.
+func frozen(ctx context.Context, store storage.EnrollmentFreezeStore, ids []string) (map[string]bool, error) {
+	lookup := make([]string, 0, len(ids))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		device, _, _ := strings.Cut(id, ":")
+		for _, v := range []string{id, device} {
+			if !seen[v] {
+				seen[v] = true
+				lookup = append(lookup, v)
+			}
+		}
+	}
+	if len(lookup) < 1 {
+		return nil, nil
+	}
+	freezes, err := store.RetrieveEnrollmentFreezes(ctx, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving freezes: %w", err)
+	}
+	ret := make(map[string]bool)
+	for _, id := range ids {
+		device, _, _ := strings.Cut(id, ":")
+		if freezes[id] != nil || freezes[device] != nil {
+			ret[id] = true
+		}
+	}
+	return ret, nil
+}
+
+// Storage blocks enqueueing commands to frozen enrollments.
+type Storage struct {
+	storage.AllStorage
+	freezes storage.EnrollmentFreezeStore
+	logger  log.Logger
+
+	blocked atomic.Int64
+}
+
+// New wraps store blocking enqueues to the frozen enrollments of
+// freezes.
+func New(store storage.AllStorage, freezes storage.EnrollmentFreezeStore, logger log.Logger) *Storage {
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	return &Storage{AllStorage: store, freezes: freezes, logger: logger}
+}
+
+// Metrics returns the blocked enqueue counter.
+func (s *Storage) Metrics() interface{} {
+	return map[string]int64{
+		"blocked": s.blocked.Load(),
+	}
+}
+
+// EnqueueCommand enqueues cmd to the ids that are not frozen. Frozen
+// ids have an ErrFrozen error. Enqueueing fails entirely if the frozen
+// enrollments can not be determined.
+func (s *Storage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	frozenIDs, err := frozen(ctx, s.freezes, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(frozenIDs) < 1 {
+		return s.AllStorage.EnqueueCommand(ctx, ids, cmd)
+	}
+	idErrs := make(map[string]error)
+	var enqueue []string
+	var blocked []string
+	for _, id := range ids {
+		if frozenIDs[id] {
+			idErrs[id] = ErrFrozen
+			blocked = append(blocked, id)
+			continue
+		}
+		enqueue = append(enqueue, id)
+	}
+	s.blocked.Add(int64(len(blocked)))
+	ctxlog.Logger(ctx, s.logger).Info(
+		"msg", "blocked enqueue to frozen enrollments",
+		"command_uuid", cmd.CommandUUID,
+		"request_type", cmd.Command.RequestType,
+		"ids", strings.Join(blocked, ","),
+	)
+	if len(enqueue) < 1 {
+		return idErrs, nil
+	}
+	enqErrs, err := s.AllStorage.EnqueueCommand(ctx, enqueue, cmd)
+	for id, err := range enqErrs {
+		idErrs[id] = err
+	}
+	return idErrs, err
+}
+
+// Pusher is a push middleware that blocks pushes to frozen enrollments.
+type Pusher struct {
+	next   push.Pusher
+	store  storage.EnrollmentFreezeStore
+	logger log.Logger
+
+	blocked atomic.Int64
+}
+
+// NewPusher creates a new push middleware blocking pushes to the frozen
+// enrollments of store.
+func NewPusher(next push.Pusher, store storage.EnrollmentFreezeStore, logger log.Logger) *Pusher {
+	if logger == nil {
+		logger = log.NopLogger
+	}
+	return &Pusher{next: next, store: store, logger: logger}
+}
+
+// Metrics returns the blocked push counter.
+func (p *Pusher) Metrics() interface{} {
+	return map[string]int64{
+		"blocked": p.blocked.Load(),
+	}
+}
+
+// Push sends pushes to ids that are not frozen. Frozen ids have an
+// ErrFrozen response error.
+func (p *Pusher) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	frozenIDs, err := frozen(ctx, p.store, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(frozenIDs) < 1 {
+		return p.next.Push(ctx, ids)
+	}
+	ret := make(map[string]*push.Response)
+	var send []string
+	var blocked []string
+	for _, id := range ids {
+		if frozenIDs[id] {
+			ret[id] = &push.Response{Err: ErrFrozen}
+			blocked = append(blocked, id)
+			continue
+		}
+		send = append(send, id)
+	}
+	p.blocked.Add(int64(len(blocked)))
+	ctxlog.Logger(ctx, p.logger).Info(
+		"msg", "blocked push to frozen enrollments",
+		"ids", strings.Join(blocked, ","),
+	)
+	if len(send) < 1 {
+		return ret, nil
+	}
+	resps, err := p.next.Push(ctx, send)
+	for id, resp := range resps {
+		ret[id] = resp
+	}
+	return ret, err
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
@@ -0,0 +1,83 @@
+package freeze
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/push"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/mock"
+)
+
+type pushFunc func(context.Context, []string) (map[string]*push.Response, error)
+
+func (f pushFunc) Push(ctx context.Context, ids []string) (map[string]*push.Response, error) {
+	return f(ctx, ids)
+}
+
+func newTestStore(enqueued *[]string) *mock.Storage {
+	return &mock.Storage{
+		RetrieveEnrollmentFreezesFunc: func(_ context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+			ret := make(map[string]*storage.EnrollmentFreeze)
+			for _, id := range ids {
+				if id == "FROZEN" {
+					ret[id] = &storage.EnrollmentFreeze{ID: id}
+				}
+			}
+			return ret, nil
+		},
+		EnqueueCommandFunc: func(_ context.Context, ids []string, _ *mdm.Command) (map[string]error, error) {
+			*enqueued = append(*enqueued, ids...)
+			return nil, nil
+		},
+	}
+}
+
+func TestEnqueue(t *testing.T) {
+	var enqueued []string
+	store := newTestStore(&enqueued)
+	s := New(store, store, nil)
+	cmd := &mdm.Command{CommandUUID: "cmd-1"}
+	idErrs, err := s.EnqueueCommand(context.Background(), []string{"ID1", "FROZEN", "FROZEN:USER1"}, cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := enqueued, []string{"ID1"}; len(have) != 1 || have[0] != want[0] {
+		t.Errorf("enqueued: have %v, want %v", have, want)
+	}
+	for _, id := range []string{"FROZEN", "FROZEN:USER1"} {
+		if !errors.Is(idErrs[id], ErrFrozen) {
+			t.Errorf("%s: expected frozen error, have %v", id, idErrs[id])
+		}
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var pushed []string
+	next := pushFunc(func(_ context.Context, ids []string) (map[string]*push.Response, error) {
+		pushed = append(pushed, ids...)
+		ret := make(map[string]*push.Response)
+		for _, id := range ids {
+			ret[id] = &push.Response{Id: "apns-" + id}
+		}
+		return ret, nil
+	})
+	p := NewPusher(next, newTestStore(nil), nil)
+	resps, err := p.Push(context.Background(), []string{"ID1", "FROZEN", "ID2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pushed)
+	if len(pushed) != 2 || pushed[0] != "ID1" || pushed[1] != "ID2" {
+		t.Errorf("pushed: have %v", pushed)
+	}
+	if resp := resps["FROZEN"]; resp == nil || !errors.Is(resp.Err, ErrFrozen) {
+		t.Errorf("expected frozen push response, have %+v", resp)
+	}
+	if have, want := len(resps), 3; have != want {
+		t.Errorf("responses: have %d, want %d", have, want)
+	}
+}
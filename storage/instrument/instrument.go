@@ -0,0 +1,152 @@
+// Package instrument records storage latency and error metrics.
+package instrument
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/metrics"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// Storage is a storage decorator which records the latency and errors
+// of the storage calls made for MDM requests (check-ins, command
+// reports, certificate authentication, and pushes) and command
+// enqueues.
+type Storage struct {
+	storage.AllStorage
+	rec metrics.Recorder
+}
+
+// New creates a new metrics recording storage decorator of next.
+func New(next storage.AllStorage, rec metrics.Recorder) *Storage {
+	return &Storage{AllStorage: next, rec: rec}
+}
+
+// call calls f and records its latency and error as method.
+func (s *Storage) call(method string, f func() error) error {
+	start := time.Now()
+	err := f()
+	metrics.Since(s.rec, metrics.StorageDuration, start, "method", method)
+	if err != nil {
+		s.rec.Add(metrics.StorageErrors, 1, "method", method)
+	}
+	return err
+}
+
+func (s *Storage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	return s.call("StoreAuthenticate", func() error { return s.AllStorage.StoreAuthenticate(r, msg) })
+}
+
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	return s.call("StoreTokenUpdate", func() error { return s.AllStorage.StoreTokenUpdate(r, msg) })
+}
+
+func (s *Storage) Disable(r *mdm.Request) error {
+	return s.call("Disable", func() error { return s.AllStorage.Disable(r) })
+}
+
+func (s *Storage) DisableWithReason(r *mdm.Request, reason string) error {
+	return s.call("DisableWithReason", func() error { return storage.DisableWithReason(s.AllStorage, r, reason) })
+}
+
+func (s *Storage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	return s.call("StoreUserAuthenticate", func() error { return s.AllStorage.StoreUserAuthenticate(r, msg) })
+}
+
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	return s.call("StoreCommandReport", func() error { return s.AllStorage.StoreCommandReport(r, report) })
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (cmd *mdm.Command, err error) {
+	err = s.call("RetrieveNextCommand", func() error {
+		cmd, err = s.AllStorage.RetrieveNextCommand(r, skipNotNow)
+		return err
+	})
+	return
+}
+
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	return s.call("ClearQueue", func() error { return s.AllStorage.ClearQueue(r) })
+}
+
+func (s *Storage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (errs map[string]error, err error) {
+	err = s.call("EnqueueCommand", func() error {
+		errs, err = s.AllStorage.EnqueueCommand(ctx, ids, cmd)
+		return err
+	})
+	return
+}
+
+func (s *Storage) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	return s.call("StoreBootstrapToken", func() error { return s.AllStorage.StoreBootstrapToken(r, msg) })
+}
+
+func (s *Storage) RetrieveBootstrapToken(r *mdm.Request, msg *mdm.GetBootstrapToken) (token *mdm.BootstrapToken, err error) {
+	err = s.call("RetrieveBootstrapToken", func() error {
+		token, err = s.AllStorage.RetrieveBootstrapToken(r, msg)
+		return err
+	})
+	return
+}
+
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (infos map[string]*mdm.Push, err error) {
+	err = s.call("RetrievePushInfo", func() error {
+		infos, err = s.AllStorage.RetrievePushInfo(ctx, ids)
+		return err
+	})
+	return
+}
+
+func (s *Storage) HasCertHash(r *mdm.Request, hash string) (has bool, err error) {
+	err = s.call("HasCertHash", func() error {
+		has, err = s.AllStorage.HasCertHash(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) EnrollmentHasCertHash(r *mdm.Request, hash string) (has bool, err error) {
+	err = s.call("EnrollmentHasCertHash", func() error {
+		has, err = s.AllStorage.EnrollmentHasCertHash(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (assoc bool, err error) {
+	err = s.call("IsCertHashAssociated", func() error {
+		assoc, err = s.AllStorage.IsCertHashAssociated(r, hash)
+		return err
+	})
+	return
+}
+
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	return s.call("AssociateCertHash", func() error { return s.AllStorage.AssociateCertHash(r, hash) })
+}
+
+func (s *Storage) EnrollmentFromHash(ctx context.Context, hash string) (id string, err error) {
+	err = s.call("EnrollmentFromHash", func() error {
+		id, err = s.AllStorage.EnrollmentFromHash(ctx, hash)
+		return err
+	})
+	return
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
@@ -0,0 +1,894 @@
+// Package mock provides a programmable mock of the NanoMDM storage
+// interfaces for use in tests.
+package mock
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// Call is a recorded call of a mock method.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// calls records method calls. It is safe for concurrent use.
+type calls struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (c *calls) record(method string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = append(c.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns the recorded calls in order. If method is not empty
+// only calls of that method are returned.
+func (c *calls) Calls(method string) []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ret []Call
+	for _, call := range c.calls {
+		if method == "" || call.Method == method {
+			ret = append(ret, call)
+		}
+	}
+	return ret
+}
+
+// Reset clears the recorded calls.
+func (c *calls) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+}
+
+// Storage is a mock of storage.AllStorage. Every method call is
+// recorded (see Calls). Responses are programmed by setting the
+// method's corresponding Func field; methods without one return zero
+// values and a nil error. The zero value is ready to use.
+type Storage struct {
+	calls
+
+	StoreAuthenticateFunc          func(*mdm.Request, *mdm.Authenticate) error
+	StoreTokenUpdateFunc           func(*mdm.Request, *mdm.TokenUpdate) error
+	DisableFunc                    func(*mdm.Request, string) error
+	StoreUserAuthenticateFunc      func(*mdm.Request, *mdm.UserAuthenticate) error
+	StoreCommandReportFunc         func(*mdm.Request, *mdm.CommandResults) error
+	RetrieveNextCommandFunc        func(*mdm.Request, bool) (*mdm.Command, error)
+	ClearQueueFunc                 func(*mdm.Request) error
+	StoreBootstrapTokenFunc        func(*mdm.Request, *mdm.SetBootstrapToken) error
+	RetrieveBootstrapTokenFunc     func(*mdm.Request, *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error)
+	RetrievePushInfoFunc           func(context.Context, []string) (map[string]*mdm.Push, error)
+	IsPushCertStaleFunc            func(context.Context, string, string) (bool, error)
+	RetrievePushCertFunc           func(context.Context, string) (*tls.Certificate, string, error)
+	StorePushCertFunc              func(context.Context, []byte, []byte) error
+	RetrievePushCertTopicsFunc     func(context.Context) ([]string, error)
+	EnqueueCommandFunc             func(context.Context, []string, *mdm.Command) (map[string]error, error)
+	HasCertHashFunc                func(*mdm.Request, string) (bool, error)
+	EnrollmentHasCertHashFunc      func(*mdm.Request, string) (bool, error)
+	IsCertHashAssociatedFunc       func(*mdm.Request, string) (bool, error)
+	AssociateCertHashFunc          func(*mdm.Request, string) error
+	EnrollmentFromHashFunc         func(context.Context, string) (string, error)
+	RetrieveMigrationCheckinsFunc  func(context.Context, chan<- interface{}) error
+	RetrieveTokenUpdateTallyFunc   func(context.Context, string) (int, error)
+	StoreDMEnablementFunc          func(*mdm.Request, string, string) error
+	RetrieveDMEnablementsFunc      func(context.Context, []string) (map[string]*storage.DMEnablement, error)
+	StoreEnrollmentMetadataFunc    func(context.Context, string, *storage.EnrollmentMetadata) error
+	RetrieveEnrollmentMetadataFunc func(context.Context, string) (*storage.EnrollmentMetadata, error)
+	RetrieveEnrollmentsFunc        func(context.Context, *storage.EnrollmentFilter) ([]*storage.Enrollment, error)
+	ResolveEnrollmentIDsFunc       func(context.Context, []string) (map[string]string, error)
+	StoreUserSessionFunc           func(*mdm.Request, string, string) error
+	RetrieveUserSessionsFunc       func(context.Context, []string) (map[string]*storage.UserSessions, error)
+	RetrieveTopicStatsFunc         func(context.Context) ([]*storage.TopicStats, error)
+	StoreJobFunc                   func(context.Context, *storage.Job, []string) error
+	UpdateJobTargetsFunc           func(context.Context, string, string, []string) error
+	UpdateJobCommandTargetFunc     func(context.Context, string, string, string) error
+	RetrieveJobFunc                func(context.Context, string) (*storage.Job, error)
+	RetrieveJobTargetsFunc         func(context.Context, string) (map[string]string, error)
+	StoreLogEventFunc              func(context.Context, *storage.LogEvent) error
+	RetrieveLogEventsFunc          func(context.Context, int64, int) ([]*storage.LogEvent, error)
+	AddMetricsRollupFunc           func(context.Context, *storage.MetricsRollup) error
+	RetrieveMetricsRollupsFunc     func(context.Context, string, time.Time) ([]*storage.MetricsRollup, error)
+	StoreAPIKeyFunc                func(context.Context, *storage.APIKey) error
+	RetrieveAPIKeyFunc             func(context.Context, string) (*storage.APIKey, error)
+	RetrieveAPIKeysFunc            func(context.Context) ([]*storage.APIKey, error)
+	DeleteAPIKeyFunc               func(context.Context, string) error
+	StorePendingCommandFunc        func(context.Context, *storage.PendingCommand) error
+	RetrievePendingCommandFunc     func(context.Context, string) (*storage.PendingCommand, error)
+	RetrievePendingCommandsFunc    func(context.Context) ([]*storage.PendingCommand, error)
+	DeletePendingCommandFunc       func(context.Context, string) (bool, error)
+	StoreEnrollmentFreezeFunc      func(context.Context, *storage.EnrollmentFreeze) error
+	RetrieveEnrollmentFreezesFunc  func(context.Context, []string) (map[string]*storage.EnrollmentFreeze, error)
+	DeleteEnrollmentFreezeFunc     func(context.Context, string) error
+	StoreInventoryFunc             func(context.Context, string, string, map[string]string) error
+	RetrieveInventoryFunc          func(context.Context, string, string) (map[string]string, error)
+	RetrieveQueueFunc              func(context.Context, string) ([]*storage.QueuedCommand, error)
+	StoreCommandOwnerFunc          func(context.Context, string, string) error
+	RetrieveCommandOwnerFunc       func(context.Context, string) (string, error)
+	LockQueueFunc                  func(context.Context, string) (func(), error)
+
+	StoreEnrollmentSupersessionFunc     func(context.Context, *storage.EnrollmentSupersession) error
+	RetrieveEnrollmentSupersessionsFunc func(context.Context, []string) (map[string]*storage.EnrollmentSupersession, error)
+	StoreEnrollmentEvictionFunc         func(context.Context, *storage.EnrollmentEviction) error
+	RetrieveEnrollmentEvictionsFunc     func(context.Context, []string) (map[string]*storage.EnrollmentEviction, error)
+	MarkEnrollmentEvictionDeliveredFunc func(context.Context, string) (bool, error)
+	DeleteEnrollmentEvictionFunc        func(context.Context, string) error
+	DepartEnrollmentFunc                func(context.Context, *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error)
+	RetrieveEnrollmentTombstonesFunc    func(context.Context, []string) (map[string]*storage.EnrollmentTombstone, error)
+	DeleteEnrollmentTombstoneFunc       func(context.Context, string) error
+	StoreWebhookDeliveryFunc            func(context.Context, *storage.WebhookDelivery) error
+	RetrieveWebhookDeliveriesFunc       func(context.Context, *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error)
+	DeleteWebhookDeliveryFunc           func(context.Context, string) error
+	StoreMessageTemplateFunc            func(context.Context, *storage.MessageTemplate) error
+	RetrieveMessageTemplatesFunc        func(context.Context, string) ([]*storage.MessageTemplate, error)
+	DeleteMessageTemplateFunc           func(context.Context, string, string, string) error
+
+	StoreDeclarationFunc                  func(context.Context, *storage.Declaration) error
+	RetrieveDeclarationsFunc              func(context.Context, []string) ([]*storage.Declaration, error)
+	DeleteDeclarationFunc                 func(context.Context, string) error
+	StoreDeclarationSetFunc               func(context.Context, string, []string) error
+	RetrieveDeclarationSetsFunc           func(context.Context, []string) (map[string][]string, error)
+	StoreEnrollmentDeclarationSetsFunc    func(context.Context, string, []string) error
+	RetrieveEnrollmentDeclarationSetsFunc func(context.Context, string) ([]string, error)
+	RetrieveEnrollmentDeclarationsFunc    func(context.Context, string) ([]*storage.Declaration, error)
+
+	RetrieveUnlockTokenFunc         func(context.Context, string) ([]byte, error)
+	StoreUnlockTokenRequestFunc     func(context.Context, *storage.UnlockTokenRequest) error
+	RetrieveUnlockTokenRequestsFunc func(context.Context, []string) ([]*storage.UnlockTokenRequest, error)
+	ApproveUnlockTokenRequestFunc   func(context.Context, string, string) (bool, error)
+	DeleteUnlockTokenRequestFunc    func(context.Context, string) (bool, error)
+
+	StoreDDMStatusReportFunc     func(context.Context, string, []byte, []byte) error
+	RetrieveDDMStatusReportsFunc func(context.Context, []string) (map[string]*storage.DDMStatusReport, error)
+
+	RetrieveScheduledEnrollmentsFunc func(context.Context, time.Time, time.Time) ([]string, error)
+	RetrieveQueuedEnrollmentsFunc    func(context.Context) ([]string, error)
+
+	RetrieveQueuedCommandsFunc func(context.Context, string) ([]*storage.QueuedCommand, error)
+	CancelQueuedCommandFunc    func(context.Context, string, string) (bool, error)
+}
+
+func (s *Storage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error {
+	s.record("StoreAuthenticate", r, msg)
+	if s.StoreAuthenticateFunc != nil {
+		return s.StoreAuthenticateFunc(r, msg)
+	}
+	return nil
+}
+
+func (s *Storage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	s.record("StoreTokenUpdate", r, msg)
+	if s.StoreTokenUpdateFunc != nil {
+		return s.StoreTokenUpdateFunc(r, msg)
+	}
+	return nil
+}
+
+func (s *Storage) Disable(r *mdm.Request) error {
+	s.record("Disable", r)
+	if s.DisableFunc != nil {
+		return s.DisableFunc(r, "")
+	}
+	return nil
+}
+
+func (s *Storage) DisableWithReason(r *mdm.Request, reason string) error {
+	s.record("DisableWithReason", r, reason)
+	if s.DisableFunc != nil {
+		return s.DisableFunc(r, reason)
+	}
+	return nil
+}
+
+func (s *Storage) StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error {
+	s.record("StoreUserAuthenticate", r, msg)
+	if s.StoreUserAuthenticateFunc != nil {
+		return s.StoreUserAuthenticateFunc(r, msg)
+	}
+	return nil
+}
+
+func (s *Storage) StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error {
+	s.record("StoreCommandReport", r, report)
+	if s.StoreCommandReportFunc != nil {
+		return s.StoreCommandReportFunc(r, report)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	s.record("RetrieveNextCommand", r, skipNotNow)
+	if s.RetrieveNextCommandFunc != nil {
+		return s.RetrieveNextCommandFunc(r, skipNotNow)
+	}
+	return nil, nil
+}
+
+func (s *Storage) ClearQueue(r *mdm.Request) error {
+	s.record("ClearQueue", r)
+	if s.ClearQueueFunc != nil {
+		return s.ClearQueueFunc(r)
+	}
+	return nil
+}
+
+func (s *Storage) StoreBootstrapToken(r *mdm.Request, msg *mdm.SetBootstrapToken) error {
+	s.record("StoreBootstrapToken", r, msg)
+	if s.StoreBootstrapTokenFunc != nil {
+		return s.StoreBootstrapTokenFunc(r, msg)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveBootstrapToken(r *mdm.Request, msg *mdm.GetBootstrapToken) (*mdm.BootstrapToken, error) {
+	s.record("RetrieveBootstrapToken", r, msg)
+	if s.RetrieveBootstrapTokenFunc != nil {
+		return s.RetrieveBootstrapTokenFunc(r, msg)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrievePushInfo(ctx context.Context, ids []string) (map[string]*mdm.Push, error) {
+	s.record("RetrievePushInfo", ctx, ids)
+	if s.RetrievePushInfoFunc != nil {
+		return s.RetrievePushInfoFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) IsPushCertStale(ctx context.Context, topic string, staleToken string) (bool, error) {
+	s.record("IsPushCertStale", ctx, topic, staleToken)
+	if s.IsPushCertStaleFunc != nil {
+		return s.IsPushCertStaleFunc(ctx, topic, staleToken)
+	}
+	return false, nil
+}
+
+func (s *Storage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+	s.record("RetrievePushCert", ctx, topic)
+	if s.RetrievePushCertFunc != nil {
+		return s.RetrievePushCertFunc(ctx, topic)
+	}
+	return nil, "", nil
+}
+
+func (s *Storage) StorePushCert(ctx context.Context, pemCert []byte, pemKey []byte) error {
+	s.record("StorePushCert", ctx, pemCert, pemKey)
+	if s.StorePushCertFunc != nil {
+		return s.StorePushCertFunc(ctx, pemCert, pemKey)
+	}
+	return nil
+}
+
+func (s *Storage) RetrievePushCertTopics(ctx context.Context) ([]string, error) {
+	s.record("RetrievePushCertTopics", ctx)
+	if s.RetrievePushCertTopicsFunc != nil {
+		return s.RetrievePushCertTopicsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Storage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	s.record("EnqueueCommand", ctx, ids, cmd)
+	if s.EnqueueCommandFunc != nil {
+		return s.EnqueueCommandFunc(ctx, ids, cmd)
+	}
+	return nil, nil
+}
+
+func (s *Storage) HasCertHash(r *mdm.Request, hash string) (bool, error) {
+	s.record("HasCertHash", r, hash)
+	if s.HasCertHashFunc != nil {
+		return s.HasCertHashFunc(r, hash)
+	}
+	return false, nil
+}
+
+func (s *Storage) EnrollmentHasCertHash(r *mdm.Request, hash string) (bool, error) {
+	s.record("EnrollmentHasCertHash", r, hash)
+	if s.EnrollmentHasCertHashFunc != nil {
+		return s.EnrollmentHasCertHashFunc(r, hash)
+	}
+	return false, nil
+}
+
+func (s *Storage) IsCertHashAssociated(r *mdm.Request, hash string) (bool, error) {
+	s.record("IsCertHashAssociated", r, hash)
+	if s.IsCertHashAssociatedFunc != nil {
+		return s.IsCertHashAssociatedFunc(r, hash)
+	}
+	return false, nil
+}
+
+func (s *Storage) AssociateCertHash(r *mdm.Request, hash string) error {
+	s.record("AssociateCertHash", r, hash)
+	if s.AssociateCertHashFunc != nil {
+		return s.AssociateCertHashFunc(r, hash)
+	}
+	return nil
+}
+
+func (s *Storage) EnrollmentFromHash(ctx context.Context, hash string) (string, error) {
+	s.record("EnrollmentFromHash", ctx, hash)
+	if s.EnrollmentFromHashFunc != nil {
+		return s.EnrollmentFromHashFunc(ctx, hash)
+	}
+	return "", nil
+}
+
+func (s *Storage) RetrieveMigrationCheckins(ctx context.Context, checkins chan<- interface{}) error {
+	s.record("RetrieveMigrationCheckins", ctx, checkins)
+	if s.RetrieveMigrationCheckinsFunc != nil {
+		return s.RetrieveMigrationCheckinsFunc(ctx, checkins)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveTokenUpdateTally(ctx context.Context, id string) (int, error) {
+	s.record("RetrieveTokenUpdateTally", ctx, id)
+	if s.RetrieveTokenUpdateTallyFunc != nil {
+		return s.RetrieveTokenUpdateTallyFunc(ctx, id)
+	}
+	return 0, nil
+}
+
+func (s *Storage) StoreDMEnablement(r *mdm.Request, endpoint string, declarationsToken string) error {
+	s.record("StoreDMEnablement", r, endpoint, declarationsToken)
+	if s.StoreDMEnablementFunc != nil {
+		return s.StoreDMEnablementFunc(r, endpoint, declarationsToken)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveDMEnablements(ctx context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	s.record("RetrieveDMEnablements", ctx, ids)
+	if s.RetrieveDMEnablementsFunc != nil {
+		return s.RetrieveDMEnablementsFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreEnrollmentMetadata(ctx context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	s.record("StoreEnrollmentMetadata", ctx, id, meta)
+	if s.StoreEnrollmentMetadataFunc != nil {
+		return s.StoreEnrollmentMetadataFunc(ctx, id, meta)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveEnrollmentMetadata(ctx context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	s.record("RetrieveEnrollmentMetadata", ctx, id)
+	if s.RetrieveEnrollmentMetadataFunc != nil {
+		return s.RetrieveEnrollmentMetadataFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveEnrollments(ctx context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	s.record("RetrieveEnrollments", ctx, filter)
+	if s.RetrieveEnrollmentsFunc != nil {
+		return s.RetrieveEnrollmentsFunc(ctx, filter)
+	}
+	return nil, nil
+}
+
+func (s *Storage) ResolveEnrollmentIDs(ctx context.Context, aliases []string) (map[string]string, error) {
+	s.record("ResolveEnrollmentIDs", ctx, aliases)
+	if s.ResolveEnrollmentIDsFunc != nil {
+		return s.ResolveEnrollmentIDsFunc(ctx, aliases)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreUserSession(r *mdm.Request, userShortName string, userLongName string) error {
+	s.record("StoreUserSession", r, userShortName, userLongName)
+	if s.StoreUserSessionFunc != nil {
+		return s.StoreUserSessionFunc(r, userShortName, userLongName)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveUserSessions(ctx context.Context, deviceIDs []string) (map[string]*storage.UserSessions, error) {
+	s.record("RetrieveUserSessions", ctx, deviceIDs)
+	if s.RetrieveUserSessionsFunc != nil {
+		return s.RetrieveUserSessionsFunc(ctx, deviceIDs)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveTopicStats(ctx context.Context) ([]*storage.TopicStats, error) {
+	s.record("RetrieveTopicStats", ctx)
+	if s.RetrieveTopicStatsFunc != nil {
+		return s.RetrieveTopicStatsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreJob(ctx context.Context, job *storage.Job, ids []string) error {
+	s.record("StoreJob", ctx, job, ids)
+	if s.StoreJobFunc != nil {
+		return s.StoreJobFunc(ctx, job, ids)
+	}
+	return nil
+}
+
+func (s *Storage) UpdateJobTargets(ctx context.Context, jobID string, status string, ids []string) error {
+	s.record("UpdateJobTargets", ctx, jobID, status, ids)
+	if s.UpdateJobTargetsFunc != nil {
+		return s.UpdateJobTargetsFunc(ctx, jobID, status, ids)
+	}
+	return nil
+}
+
+func (s *Storage) UpdateJobCommandTarget(ctx context.Context, id string, commandUUID string, status string) error {
+	s.record("UpdateJobCommandTarget", ctx, id, commandUUID, status)
+	if s.UpdateJobCommandTargetFunc != nil {
+		return s.UpdateJobCommandTargetFunc(ctx, id, commandUUID, status)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	s.record("RetrieveJob", ctx, jobID)
+	if s.RetrieveJobFunc != nil {
+		return s.RetrieveJobFunc(ctx, jobID)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveJobTargets(ctx context.Context, jobID string) (map[string]string, error) {
+	s.record("RetrieveJobTargets", ctx, jobID)
+	if s.RetrieveJobTargetsFunc != nil {
+		return s.RetrieveJobTargetsFunc(ctx, jobID)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreLogEvent(ctx context.Context, event *storage.LogEvent) error {
+	s.record("StoreLogEvent", ctx, event)
+	if s.StoreLogEventFunc != nil {
+		return s.StoreLogEventFunc(ctx, event)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveLogEvents(ctx context.Context, after int64, limit int) ([]*storage.LogEvent, error) {
+	s.record("RetrieveLogEvents", ctx, after, limit)
+	if s.RetrieveLogEventsFunc != nil {
+		return s.RetrieveLogEventsFunc(ctx, after, limit)
+	}
+	return nil, nil
+}
+
+func (s *Storage) AddMetricsRollup(ctx context.Context, rollup *storage.MetricsRollup) error {
+	s.record("AddMetricsRollup", ctx, rollup)
+	if s.AddMetricsRollupFunc != nil {
+		return s.AddMetricsRollupFunc(ctx, rollup)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveMetricsRollups(ctx context.Context, period string, since time.Time) ([]*storage.MetricsRollup, error) {
+	s.record("RetrieveMetricsRollups", ctx, period, since)
+	if s.RetrieveMetricsRollupsFunc != nil {
+		return s.RetrieveMetricsRollupsFunc(ctx, period, since)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreAPIKey(ctx context.Context, key *storage.APIKey) error {
+	s.record("StoreAPIKey", ctx, key)
+	if s.StoreAPIKeyFunc != nil {
+		return s.StoreAPIKeyFunc(ctx, key)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveAPIKey(ctx context.Context, name string) (*storage.APIKey, error) {
+	s.record("RetrieveAPIKey", ctx, name)
+	if s.RetrieveAPIKeyFunc != nil {
+		return s.RetrieveAPIKeyFunc(ctx, name)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveAPIKeys(ctx context.Context) ([]*storage.APIKey, error) {
+	s.record("RetrieveAPIKeys", ctx)
+	if s.RetrieveAPIKeysFunc != nil {
+		return s.RetrieveAPIKeysFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteAPIKey(ctx context.Context, name string) error {
+	s.record("DeleteAPIKey", ctx, name)
+	if s.DeleteAPIKeyFunc != nil {
+		return s.DeleteAPIKeyFunc(ctx, name)
+	}
+	return nil
+}
+
+func (s *Storage) StorePendingCommand(ctx context.Context, cmd *storage.PendingCommand) error {
+	s.record("StorePendingCommand", ctx, cmd)
+	if s.StorePendingCommandFunc != nil {
+		return s.StorePendingCommandFunc(ctx, cmd)
+	}
+	return nil
+}
+
+func (s *Storage) RetrievePendingCommand(ctx context.Context, uuid string) (*storage.PendingCommand, error) {
+	s.record("RetrievePendingCommand", ctx, uuid)
+	if s.RetrievePendingCommandFunc != nil {
+		return s.RetrievePendingCommandFunc(ctx, uuid)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrievePendingCommands(ctx context.Context) ([]*storage.PendingCommand, error) {
+	s.record("RetrievePendingCommands", ctx)
+	if s.RetrievePendingCommandsFunc != nil {
+		return s.RetrievePendingCommandsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeletePendingCommand(ctx context.Context, uuid string) (bool, error) {
+	s.record("DeletePendingCommand", ctx, uuid)
+	if s.DeletePendingCommandFunc != nil {
+		return s.DeletePendingCommandFunc(ctx, uuid)
+	}
+	return false, nil
+}
+
+func (s *Storage) StoreEnrollmentFreeze(ctx context.Context, freeze *storage.EnrollmentFreeze) error {
+	s.record("StoreEnrollmentFreeze", ctx, freeze)
+	if s.StoreEnrollmentFreezeFunc != nil {
+		return s.StoreEnrollmentFreezeFunc(ctx, freeze)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveEnrollmentFreezes(ctx context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+	s.record("RetrieveEnrollmentFreezes", ctx, ids)
+	if s.RetrieveEnrollmentFreezesFunc != nil {
+		return s.RetrieveEnrollmentFreezesFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteEnrollmentFreeze(ctx context.Context, id string) error {
+	s.record("DeleteEnrollmentFreeze", ctx, id)
+	if s.DeleteEnrollmentFreezeFunc != nil {
+		return s.DeleteEnrollmentFreezeFunc(ctx, id)
+	}
+	return nil
+}
+
+func (s *Storage) StoreInventory(ctx context.Context, id, source string, values map[string]string) error {
+	s.record("StoreInventory", ctx, id, source, values)
+	if s.StoreInventoryFunc != nil {
+		return s.StoreInventoryFunc(ctx, id, source, values)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveInventory(ctx context.Context, id, source string) (map[string]string, error) {
+	s.record("RetrieveInventory", ctx, id, source)
+	if s.RetrieveInventoryFunc != nil {
+		return s.RetrieveInventoryFunc(ctx, id, source)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	s.record("RetrieveQueue", ctx, id)
+	if s.RetrieveQueueFunc != nil {
+		return s.RetrieveQueueFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreCommandOwner(ctx context.Context, uuid, owner string) error {
+	s.record("StoreCommandOwner", ctx, uuid, owner)
+	if s.StoreCommandOwnerFunc != nil {
+		return s.StoreCommandOwnerFunc(ctx, uuid, owner)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveCommandOwner(ctx context.Context, uuid string) (string, error) {
+	s.record("RetrieveCommandOwner", ctx, uuid)
+	if s.RetrieveCommandOwnerFunc != nil {
+		return s.RetrieveCommandOwnerFunc(ctx, uuid)
+	}
+	return "", nil
+}
+
+func (s *Storage) LockQueue(ctx context.Context, id string) (func(), error) {
+	s.record("LockQueue", ctx, id)
+	if s.LockQueueFunc != nil {
+		return s.LockQueueFunc(ctx, id)
+	}
+	return func() {}, nil
+}
+
+func (s *Storage) StoreEnrollmentSupersession(ctx context.Context, ss *storage.EnrollmentSupersession) error {
+	s.record("StoreEnrollmentSupersession", ctx, ss)
+	if s.StoreEnrollmentSupersessionFunc != nil {
+		return s.StoreEnrollmentSupersessionFunc(ctx, ss)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	s.record("RetrieveEnrollmentSupersessions", ctx, ids)
+	if s.RetrieveEnrollmentSupersessionsFunc != nil {
+		return s.RetrieveEnrollmentSupersessionsFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreEnrollmentEviction(ctx context.Context, eviction *storage.EnrollmentEviction) error {
+	s.record("StoreEnrollmentEviction", ctx, eviction)
+	if s.StoreEnrollmentEvictionFunc != nil {
+		return s.StoreEnrollmentEvictionFunc(ctx, eviction)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	s.record("RetrieveEnrollmentEvictions", ctx, ids)
+	if s.RetrieveEnrollmentEvictionsFunc != nil {
+		return s.RetrieveEnrollmentEvictionsFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error) {
+	s.record("MarkEnrollmentEvictionDelivered", ctx, id)
+	if s.MarkEnrollmentEvictionDeliveredFunc != nil {
+		return s.MarkEnrollmentEvictionDeliveredFunc(ctx, id)
+	}
+	return false, nil
+}
+
+func (s *Storage) DeleteEnrollmentEviction(ctx context.Context, id string) error {
+	s.record("DeleteEnrollmentEviction", ctx, id)
+	if s.DeleteEnrollmentEvictionFunc != nil {
+		return s.DeleteEnrollmentEvictionFunc(ctx, id)
+	}
+	return nil
+}
+
+func (s *Storage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	s.record("DepartEnrollment", ctx, tombstone)
+	if s.DepartEnrollmentFunc != nil {
+		return s.DepartEnrollmentFunc(ctx, tombstone)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	s.record("RetrieveEnrollmentTombstones", ctx, ids)
+	if s.RetrieveEnrollmentTombstonesFunc != nil {
+		return s.RetrieveEnrollmentTombstonesFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteEnrollmentTombstone(ctx context.Context, id string) error {
+	s.record("DeleteEnrollmentTombstone", ctx, id)
+	if s.DeleteEnrollmentTombstoneFunc != nil {
+		return s.DeleteEnrollmentTombstoneFunc(ctx, id)
+	}
+	return nil
+}
+
+func (s *Storage) StoreWebhookDelivery(ctx context.Context, delivery *storage.WebhookDelivery) error {
+	s.record("StoreWebhookDelivery", ctx, delivery)
+	if s.StoreWebhookDeliveryFunc != nil {
+		return s.StoreWebhookDeliveryFunc(ctx, delivery)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveWebhookDeliveries(ctx context.Context, filter *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error) {
+	s.record("RetrieveWebhookDeliveries", ctx, filter)
+	if s.RetrieveWebhookDeliveriesFunc != nil {
+		return s.RetrieveWebhookDeliveriesFunc(ctx, filter)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	s.record("DeleteWebhookDelivery", ctx, id)
+	if s.DeleteWebhookDeliveryFunc != nil {
+		return s.DeleteWebhookDeliveryFunc(ctx, id)
+	}
+	return nil
+}
+
+func (s *Storage) StoreMessageTemplate(ctx context.Context, template *storage.MessageTemplate) error {
+	s.record("StoreMessageTemplate", ctx, template)
+	if s.StoreMessageTemplateFunc != nil {
+		return s.StoreMessageTemplateFunc(ctx, template)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveMessageTemplates(ctx context.Context, name string) ([]*storage.MessageTemplate, error) {
+	s.record("RetrieveMessageTemplates", ctx, name)
+	if s.RetrieveMessageTemplatesFunc != nil {
+		return s.RetrieveMessageTemplatesFunc(ctx, name)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteMessageTemplate(ctx context.Context, name, group, locale string) error {
+	s.record("DeleteMessageTemplate", ctx, name, group, locale)
+	if s.DeleteMessageTemplateFunc != nil {
+		return s.DeleteMessageTemplateFunc(ctx, name, group, locale)
+	}
+	return nil
+}
+
+func (s *Storage) StoreDeclaration(ctx context.Context, declaration *storage.Declaration) error {
+	s.record("StoreDeclaration", ctx, declaration)
+	if s.StoreDeclarationFunc != nil {
+		return s.StoreDeclarationFunc(ctx, declaration)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveDeclarations(ctx context.Context, identifiers []string) ([]*storage.Declaration, error) {
+	s.record("RetrieveDeclarations", ctx, identifiers)
+	if s.RetrieveDeclarationsFunc != nil {
+		return s.RetrieveDeclarationsFunc(ctx, identifiers)
+	}
+	return nil, nil
+}
+
+func (s *Storage) DeleteDeclaration(ctx context.Context, identifier string) error {
+	s.record("DeleteDeclaration", ctx, identifier)
+	if s.DeleteDeclarationFunc != nil {
+		return s.DeleteDeclarationFunc(ctx, identifier)
+	}
+	return nil
+}
+
+func (s *Storage) StoreDeclarationSet(ctx context.Context, name string, identifiers []string) error {
+	s.record("StoreDeclarationSet", ctx, name, identifiers)
+	if s.StoreDeclarationSetFunc != nil {
+		return s.StoreDeclarationSetFunc(ctx, name, identifiers)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveDeclarationSets(ctx context.Context, names []string) (map[string][]string, error) {
+	s.record("RetrieveDeclarationSets", ctx, names)
+	if s.RetrieveDeclarationSetsFunc != nil {
+		return s.RetrieveDeclarationSetsFunc(ctx, names)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreEnrollmentDeclarationSets(ctx context.Context, id string, sets []string) error {
+	s.record("StoreEnrollmentDeclarationSets", ctx, id, sets)
+	if s.StoreEnrollmentDeclarationSetsFunc != nil {
+		return s.StoreEnrollmentDeclarationSetsFunc(ctx, id, sets)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveEnrollmentDeclarationSets(ctx context.Context, id string) ([]string, error) {
+	s.record("RetrieveEnrollmentDeclarationSets", ctx, id)
+	if s.RetrieveEnrollmentDeclarationSetsFunc != nil {
+		return s.RetrieveEnrollmentDeclarationSetsFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveEnrollmentDeclarations(ctx context.Context, id string) ([]*storage.Declaration, error) {
+	s.record("RetrieveEnrollmentDeclarations", ctx, id)
+	if s.RetrieveEnrollmentDeclarationsFunc != nil {
+		return s.RetrieveEnrollmentDeclarationsFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error) {
+	s.record("RetrieveUnlockToken", ctx, id)
+	if s.RetrieveUnlockTokenFunc != nil {
+		return s.RetrieveUnlockTokenFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) StoreUnlockTokenRequest(ctx context.Context, req *storage.UnlockTokenRequest) error {
+	s.record("StoreUnlockTokenRequest", ctx, req)
+	if s.StoreUnlockTokenRequestFunc != nil {
+		return s.StoreUnlockTokenRequestFunc(ctx, req)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveUnlockTokenRequests(ctx context.Context, ids []string) ([]*storage.UnlockTokenRequest, error) {
+	s.record("RetrieveUnlockTokenRequests", ctx, ids)
+	if s.RetrieveUnlockTokenRequestsFunc != nil {
+		return s.RetrieveUnlockTokenRequestsFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) ApproveUnlockTokenRequest(ctx context.Context, id, approvedBy string) (bool, error) {
+	s.record("ApproveUnlockTokenRequest", ctx, id, approvedBy)
+	if s.ApproveUnlockTokenRequestFunc != nil {
+		return s.ApproveUnlockTokenRequestFunc(ctx, id, approvedBy)
+	}
+	return false, nil
+}
+
+func (s *Storage) DeleteUnlockTokenRequest(ctx context.Context, id string) (bool, error) {
+	s.record("DeleteUnlockTokenRequest", ctx, id)
+	if s.DeleteUnlockTokenRequestFunc != nil {
+		return s.DeleteUnlockTokenRequestFunc(ctx, id)
+	}
+	return false, nil
+}
+
+func (s *Storage) StoreDDMStatusReport(ctx context.Context, id string, report, declarations []byte) error {
+	s.record("StoreDDMStatusReport", ctx, id, report, declarations)
+	if s.StoreDDMStatusReportFunc != nil {
+		return s.StoreDDMStatusReportFunc(ctx, id, report, declarations)
+	}
+	return nil
+}
+
+func (s *Storage) RetrieveDDMStatusReports(ctx context.Context, ids []string) (map[string]*storage.DDMStatusReport, error) {
+	s.record("RetrieveDDMStatusReports", ctx, ids)
+	if s.RetrieveDDMStatusReportsFunc != nil {
+		return s.RetrieveDDMStatusReportsFunc(ctx, ids)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error) {
+	s.record("RetrieveScheduledEnrollments", ctx, after, until)
+	if s.RetrieveScheduledEnrollmentsFunc != nil {
+		return s.RetrieveScheduledEnrollmentsFunc(ctx, after, until)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveQueuedEnrollments(ctx context.Context) ([]string, error) {
+	s.record("RetrieveQueuedEnrollments", ctx)
+	if s.RetrieveQueuedEnrollmentsFunc != nil {
+		return s.RetrieveQueuedEnrollmentsFunc(ctx)
+	}
+	return nil, nil
+}
+
+func (s *Storage) RetrieveQueuedCommands(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	s.record("RetrieveQueuedCommands", ctx, id)
+	if s.RetrieveQueuedCommandsFunc != nil {
+		return s.RetrieveQueuedCommandsFunc(ctx, id)
+	}
+	return nil, nil
+}
+
+func (s *Storage) CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error) {
+	s.record("CancelQueuedCommand", ctx, id, uuid)
+	if s.CancelQueuedCommandFunc != nil {
+		return s.CancelQueuedCommandFunc(ctx, id, uuid)
+	}
+	return false, nil
+}
@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func TestStorage(t *testing.T) {
+	var s storage.AllStorage = new(Storage)
+	m := s.(*Storage)
+
+	errTest := errors.New("test")
+	m.EnrollmentFromHashFunc = func(_ context.Context, hash string) (string, error) {
+		if hash == "bad" {
+			return "", errTest
+		}
+		return "ID1", nil
+	}
+
+	if id, err := s.EnrollmentFromHash(context.Background(), "good"); err != nil || id != "ID1" {
+		t.Errorf("have %q, %v", id, err)
+	}
+	if _, err := s.EnrollmentFromHash(context.Background(), "bad"); !errors.Is(err, errTest) {
+		t.Errorf("expected test error, got: %v", err)
+	}
+	if cmd, err := s.RetrieveNextCommand(&mdm.Request{}, false); cmd != nil || err != nil {
+		t.Errorf("expected zero values, got: %v, %v", cmd, err)
+	}
+
+	calls := m.Calls("EnrollmentFromHash")
+	if have, want := len(calls), 2; have != want {
+		t.Fatalf("calls: have %d, want %d", have, want)
+	}
+	if have, want := calls[1].Args[1], "bad"; have != want {
+		t.Errorf("arg: have %v, want %v", have, want)
+	}
+	if have, want := len(m.Calls("")), 3; have != want {
+		t.Errorf("all calls: have %d, want %d", have, want)
+	}
+	m.Reset()
+	if have := len(m.Calls("")); have != 0 {
+		t.Errorf("calls after reset: have %d, want 0", have)
+	}
+}
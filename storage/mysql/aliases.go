@@ -0,0 +1,62 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// storeEnrollmentAliases points each of aliases at the enrollment in r.
+func (s *MySQLStorage) storeEnrollmentAliases(r *mdm.Request, aliases []string) error {
+	if len(aliases) < 1 {
+		return nil
+	}
+	var args []interface{}
+	for _, alias := range aliases {
+		args = append(args, alias, r.ID)
+	}
+	_, err := s.execer(r.Context).ExecContext(
+		r.Context,
+		`INSERT INTO enrollment_aliases (alias, id) VALUES (?, ?)`+strings.Repeat(", (?, ?)", len(aliases)-1)+` AS new ON DUPLICATE KEY UPDATE id = new.id;`,
+		args...,
+	)
+	return err
+}
+
+func (s *MySQLStorage) ResolveEnrollmentIDs(ctx context.Context, aliases []string) (map[string]string, error) {
+	if len(aliases) < 1 {
+		return nil, nil
+	}
+	in := `(?` + strings.Repeat(", ?", len(aliases)-1) + `)`
+	var args []interface{}
+	for _, alias := range aliases {
+		args = append(args, alias)
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT id, id, 1 FROM enrollments WHERE id IN `+in+`
+UNION ALL
+SELECT alias, id, 0 FROM enrollment_aliases WHERE alias IN `+in+`;`,
+		append(args, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resolved := make(map[string]string)
+	isEnrollment := make(map[string]bool)
+	for rows.Next() {
+		var alias, id string
+		var enrollment bool
+		if err := rows.Scan(&alias, &id, &enrollment); err != nil {
+			return nil, err
+		}
+		if isEnrollment[alias] {
+			continue
+		}
+		resolved[alias] = id
+		isEnrollment[alias] = enrollment
+	}
+	return resolved, rows.Err()
+}
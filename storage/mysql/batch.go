@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// reportItem is a command report to be written in a batch.
+type reportItem struct {
+	id     string
+	result *mdm.CommandResults
+}
+
+// flushReports writes a batch of command reports in one transaction.
+// The last seen time of all reporting enrollments is updated and the
+// (non-Idle) results are upserted.
+func (s *MySQLStorage) flushReports(ctx context.Context, items []interface{}) error {
+	var ids []string
+	seen := make(map[string]struct{})
+	var values []string
+	var args []interface{}
+	for _, item := range items {
+		report, ok := item.(*reportItem)
+		if !ok {
+			return errors.New("invalid batch item")
+		}
+		if _, ok := seen[report.id]; !ok {
+			seen[report.id] = struct{}{}
+			ids = append(ids, report.id)
+		}
+		if report.result.Status == "Idle" {
+			continue
+		}
+		values = append(values, `(?, ?, ?, ?, IF(? = 'NotNow', CURRENT_TIMESTAMP, NULL), IF(? = 'NotNow', 1, 0))`)
+		args = append(args,
+			report.id,
+			report.result.CommandUUID,
+			report.result.Status,
+			report.result.Raw,
+			report.result.Status,
+			report.result.Status,
+		)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	idArgs := make([]interface{}, len(ids))
+	for i, id := range ids {
+		idArgs[i] = id
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE enrollments SET last_seen_at = CURRENT_TIMESTAMP WHERE id IN (?`+strings.Repeat(", ?", len(ids)-1)+`);`,
+		idArgs...,
+	)
+	if err != nil {
+		err = fmt.Errorf("updating last seen: %w", err)
+	} else if len(values) > 0 {
+		// note that due to the ON DUPLICATE KEY we don't UPDATE the
+		// not_now_at field. thus it will only represent the first NotNow.
+		_, err = tx.ExecContext(
+			ctx, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    `+strings.Join(values, ", ")+` AS new
+ON DUPLICATE KEY
+UPDATE
+    status = new.status,
+    result = new.result,
+    command_results.not_now_tally = command_results.not_now_tally + new.not_now_tally;`,
+			args...,
+		)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,142 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage/sqlschema"
+)
+
+// RequiredCharset is the character set NanoMDM requires of the
+// connection and the columns of the database.
+const RequiredCharset = "utf8mb4"
+
+// idColumns are the names of columns holding enrollment IDs.
+var idColumns = map[string]bool{
+	"id":            true,
+	"enrollment_id": true,
+	"device_id":     true,
+	"user_id":       true,
+}
+
+// columnCharset is the character set and collation of a column.
+type columnCharset struct {
+	table, column      string
+	charset, collation string
+}
+
+// CollationReport is the result of checking the character sets and
+// collations of a database.
+type CollationReport struct {
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether there are no problems.
+func (r *CollationReport) OK() bool {
+	return len(r.Problems) < 1
+}
+
+// Err returns an error describing the problems or nil if there are none.
+func (r *CollationReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return errors.New("database character set and collation problems: " + strings.Join(r.Problems, "; "))
+}
+
+// caseInsensitive reports whether collation compares case-insensitively
+// (e.g. "utf8mb4_0900_ai_ci" rather than "utf8mb4_bin").
+func caseInsensitive(collation string) bool {
+	return strings.HasSuffix(strings.ToLower(collation), "_ci")
+}
+
+// checkCharsets checks the connection and database default character
+// sets and the columns of the database.
+func checkCharsets(connCharset, dbCharset string, columns []columnCharset) *CollationReport {
+	report := new(CollationReport)
+	if connCharset != RequiredCharset {
+		report.Problems = append(report.Problems, fmt.Sprintf("connection character set is %s, not %s (set charset=%[2]s in the DSN)", connCharset, RequiredCharset))
+	}
+	if dbCharset != RequiredCharset {
+		report.Problems = append(report.Problems, fmt.Sprintf("database default character set is %s, not %s", dbCharset, RequiredCharset))
+	}
+	var charsets, insensitive []string
+	idCollations := make(map[string]int)
+	for _, c := range columns {
+		name := c.table + "." + c.column
+		if c.charset != RequiredCharset {
+			charsets = append(charsets, fmt.Sprintf("%s (%s)", name, c.charset))
+		}
+		if !idColumns[c.column] {
+			continue
+		}
+		idCollations[c.collation]++
+		if caseInsensitive(c.collation) {
+			insensitive = append(insensitive, fmt.Sprintf("%s (%s)", name, c.collation))
+		}
+	}
+	if len(charsets) > 0 {
+		sort.Strings(charsets)
+		report.Problems = append(report.Problems, fmt.Sprintf("columns not in the %s character set: %s", RequiredCharset, strings.Join(charsets, ", ")))
+	}
+	if len(insensitive) > 0 {
+		sort.Strings(insensitive)
+		report.Problems = append(report.Problems, "enrollment ID columns with case-insensitive collations match IDs differing only in case: "+strings.Join(insensitive, ", "))
+	}
+	if len(idCollations) > 1 {
+		var collations []string
+		for collation, n := range idCollations {
+			collations = append(collations, fmt.Sprintf("%s (%d columns)", collation, n))
+		}
+		sort.Strings(collations)
+		report.Problems = append(report.Problems, "enrollment ID columns have different collations: "+strings.Join(collations, ", "))
+	}
+	return report
+}
+
+// CheckCollation checks that the connection and the database use the
+// utf8mb4 character set and that the columns holding enrollment IDs
+// use the same case-sensitive collation (e.g. "utf8mb4_bin"). With a
+// case-insensitive collation enrollment IDs differing only in case are
+// duplicates of (or match) each other. Only the columns of the tables
+// of Schema are checked.
+func (s *MySQLStorage) CheckCollation(ctx context.Context) (*CollationReport, error) {
+	var connCharset, dbCharset string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT @@character_set_connection, @@character_set_database;`,
+	).Scan(&connCharset, &dbCharset)
+	if err != nil {
+		return nil, fmt.Errorf("selecting character sets: %w", err)
+	}
+	tables := make(map[string]bool)
+	for _, table := range sqlschema.Parse(Schema) {
+		tables[table.Name] = true
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT table_name, column_name, character_set_name, collation_name FROM information_schema.columns WHERE table_schema = DATABASE() AND character_set_name IS NOT NULL;`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("selecting columns: %w", err)
+	}
+	defer rows.Close()
+	var columns []columnCharset
+	for rows.Next() {
+		var c columnCharset
+		if err = rows.Scan(&c.table, &c.column, &c.charset, &c.collation); err != nil {
+			return nil, err
+		}
+		c.table, c.column = strings.ToLower(c.table), strings.ToLower(c.column)
+		if tables[c.table] {
+			columns = append(columns, c)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return checkCharsets(connCharset, dbCharset, columns), nil
+}
@@ -0,0 +1,39 @@
+package mysql
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckCharsets(t *testing.T) {
+	columns := []columnCharset{
+		{"devices", "id", "utf8mb4", "utf8mb4_bin"},
+		{"enrollments", "device_id", "utf8mb4", "utf8mb4_bin"},
+		{"devices", "serial_number", "utf8mb4", "utf8mb4_0900_ai_ci"},
+	}
+	if report := checkCharsets("utf8mb4", "utf8mb4", columns); !report.OK() {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+
+	columns = append(columns,
+		columnCharset{"users", "id", "utf8mb4", "utf8mb4_0900_ai_ci"},
+		columnCharset{"users", "user_short_name", "latin1", "latin1_swedish_ci"},
+	)
+	report := checkCharsets("utf8mb3", "utf8mb4", columns)
+	if have, want := len(report.Problems), 4; have != want {
+		t.Fatalf("problems: have %d, want %d: %v", have, want, report.Problems)
+	}
+	for i, want := range []string{
+		"charset=utf8mb4",
+		"users.user_short_name (latin1)",
+		"users.id (utf8mb4_0900_ai_ci)",
+		"utf8mb4_bin (2 columns)",
+	} {
+		if !strings.Contains(report.Problems[i], want) {
+			t.Errorf("problem %d: %q does not contain %q", i, report.Problems[i], want)
+		}
+	}
+	if err := report.Err(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,73 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreDDMStatusReport(ctx context.Context, id string, report, declarations []byte) error {
+	cols := `(id, report, reported_at) VALUES (?, ?, CURRENT_TIMESTAMP)`
+	update := ``
+	args := []interface{}{id, string(report)}
+	if len(declarations) > 0 {
+		cols = `(id, report, reported_at, declarations, declarations_at) VALUES (?, ?, CURRENT_TIMESTAMP, ?, CURRENT_TIMESTAMP)`
+		update = `,
+    declarations = new.declarations,
+    declarations_at = new.declarations_at`
+		args = append(args, string(declarations))
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO ddm_status_reports
+    `+cols+` AS new
+ON DUPLICATE KEY
+UPDATE
+    report = new.report,
+    reported_at = new.reported_at`+update+`;`,
+		args...,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveDDMStatusReports(ctx context.Context, ids []string) (map[string]*storage.DDMStatusReport, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	// we select UNIX timestamps to avoid depending on the parseTime DSN option
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, report, UNIX_TIMESTAMP(reported_at), declarations, UNIX_TIMESTAMP(declarations_at) FROM ddm_status_reports`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.DDMStatusReport)
+	for rows.Next() {
+		var id, report string
+		var declarations sql.NullString
+		var reportedAt, declarationsAt sql.NullInt64
+		if err := rows.Scan(&id, &report, &reportedAt, &declarations, &declarationsAt); err != nil {
+			return nil, err
+		}
+		r := &storage.DDMStatusReport{Report: []byte(report)}
+		if t := timeFromUnix(reportedAt); t != nil {
+			r.ReportedAt = *t
+		}
+		if declarations.Valid {
+			r.Declarations = []byte(declarations.String)
+		}
+		r.DeclarationsAt = timeFromUnix(declarationsAt)
+		ret[id] = r
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,181 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreDeclaration(ctx context.Context, d *storage.Declaration) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO declarations
+    (identifier, type, payload, server_token)
+VALUES
+    (?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    type = new.type,
+    payload = new.payload,
+    server_token = new.server_token;`,
+		d.Identifier, d.Type, string(d.Payload), d.ServerToken,
+	)
+	return err
+}
+
+func (s *MySQLStorage) queryDeclarations(ctx context.Context, query string, args ...interface{}) ([]*storage.Declaration, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var declarations []*storage.Declaration
+	for rows.Next() {
+		d := new(storage.Declaration)
+		var payload string
+		var updatedAt sql.NullInt64
+		if err := rows.Scan(&d.Identifier, &d.Type, &payload, &d.ServerToken, &updatedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = []byte(payload)
+		if t := timeFromUnix(updatedAt); t != nil {
+			d.UpdatedAt = t.UTC()
+		}
+		declarations = append(declarations, d)
+	}
+	return declarations, rows.Err()
+}
+
+func (s *MySQLStorage) RetrieveDeclarations(ctx context.Context, identifiers []string) ([]*storage.Declaration, error) {
+	var where string
+	args := make([]interface{}, len(identifiers))
+	if len(identifiers) > 0 {
+		where = ` WHERE identifier IN (?` + strings.Repeat(", ?", len(identifiers)-1) + `)`
+		for i, v := range identifiers {
+			args[i] = v
+		}
+	}
+	return s.queryDeclarations(
+		ctx,
+		`SELECT identifier, type, payload, server_token, UNIX_TIMESTAMP(updated_at) FROM declarations`+where+` ORDER BY identifier;`,
+		args...,
+	)
+}
+
+func (s *MySQLStorage) DeleteDeclaration(ctx context.Context, identifier string) error {
+	// declaration sets cascade
+	_, err := s.db.ExecContext(ctx, `DELETE FROM declarations WHERE identifier = ?;`, identifier)
+	return err
+}
+
+// replaceRows replaces the rows of table whose key column is key with
+// rows of key and each of values (in column) in a transaction.
+func (s *MySQLStorage) replaceRows(ctx context.Context, table, keyColumn, key, column string, values []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+keyColumn+` = ?;`, key)
+	if err == nil && len(values) > 0 {
+		args := make([]interface{}, 0, len(values)*2)
+		for _, v := range values {
+			args = append(args, key, v)
+		}
+		_, err = tx.ExecContext(
+			ctx,
+			`INSERT IGNORE INTO `+table+` (`+keyColumn+`, `+column+`) VALUES (?, ?)`+strings.Repeat(", (?, ?)", len(values)-1)+`;`,
+			args...,
+		)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *MySQLStorage) StoreDeclarationSet(ctx context.Context, name string, identifiers []string) error {
+	return s.replaceRows(ctx, "declaration_sets", "set_name", name, "declaration_identifier", identifiers)
+}
+
+func (s *MySQLStorage) RetrieveDeclarationSets(ctx context.Context, names []string) (map[string][]string, error) {
+	var where string
+	args := make([]interface{}, len(names))
+	if len(names) > 0 {
+		where = ` WHERE set_name IN (?` + strings.Repeat(", ?", len(names)-1) + `)`
+		for i, v := range names {
+			args[i] = v
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT set_name, declaration_identifier FROM declaration_sets`+where+` ORDER BY set_name, declaration_identifier;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sets := make(map[string][]string)
+	for rows.Next() {
+		var name, identifier string
+		if err := rows.Scan(&name, &identifier); err != nil {
+			return nil, err
+		}
+		sets[name] = append(sets[name], identifier)
+	}
+	return sets, rows.Err()
+}
+
+func (s *MySQLStorage) StoreEnrollmentDeclarationSets(ctx context.Context, id string, sets []string) error {
+	return s.replaceRows(ctx, "enrollment_declaration_sets", "id", id, "set_name", sets)
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentDeclarationSets(ctx context.Context, id string) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT set_name FROM enrollment_declaration_sets WHERE id = ? ORDER BY set_name;`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var sets []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		sets = append(sets, name)
+	}
+	return sets, rows.Err()
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentDeclarations(ctx context.Context, id string) ([]*storage.Declaration, error) {
+	return s.queryDeclarations(
+		ctx,
+		`
+SELECT
+    d.identifier, d.type, d.payload, d.server_token, UNIX_TIMESTAMP(d.updated_at)
+FROM
+    declarations d
+WHERE
+    d.identifier IN (
+        SELECT ds.declaration_identifier
+        FROM declaration_sets ds
+            INNER JOIN enrollment_declaration_sets eds
+                ON eds.set_name = ds.set_name
+        WHERE eds.id = ?
+    )
+ORDER BY
+    d.identifier;`,
+		id,
+	)
+}
@@ -0,0 +1,82 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreDMEnablement(r *mdm.Request, endpoint, declarationsToken string) error {
+	cols := `(id, last_endpoint, last_checkin_at) VALUES (?, ?, CURRENT_TIMESTAMP)`
+	update := ``
+	args := []interface{}{r.ID, endpoint}
+	if declarationsToken != "" {
+		cols = `(id, last_endpoint, last_checkin_at, declarations_token, declarations_token_at) VALUES (?, ?, CURRENT_TIMESTAMP, ?, CURRENT_TIMESTAMP)`
+		update = `,
+    declarations_token = new.declarations_token,
+    declarations_token_at = new.declarations_token_at`
+		args = append(args, declarationsToken)
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO dm_enablements
+    `+cols+` AS new
+ON DUPLICATE KEY
+UPDATE
+    last_endpoint = new.last_endpoint,
+    last_checkin_at = new.last_checkin_at`+update+`;`,
+		args...,
+	)
+	return err
+}
+
+// timeFromUnix converts a (possibly NULL) UNIX timestamp to a time.
+func timeFromUnix(ts sql.NullInt64) *time.Time {
+	if !ts.Valid {
+		return nil
+	}
+	t := time.Unix(ts.Int64, 0)
+	return &t
+}
+
+func (s *MySQLStorage) RetrieveDMEnablements(ctx context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	// we select UNIX timestamps to avoid depending on the parseTime DSN option
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, last_endpoint, UNIX_TIMESTAMP(last_checkin_at), declarations_token, UNIX_TIMESTAMP(declarations_token_at) FROM dm_enablements`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.DMEnablement)
+	for rows.Next() {
+		var id string
+		var token sql.NullString
+		var checkinAt, tokenAt sql.NullInt64
+		dm := new(storage.DMEnablement)
+		if err := rows.Scan(&id, &dm.LastEndpoint, &checkinAt, &token, &tokenAt); err != nil {
+			return nil, err
+		}
+		if t := timeFromUnix(checkinAt); t != nil {
+			dm.LastCheckinAt = *t
+		}
+		dm.DeclarationsToken = token.String
+		dm.DeclarationsTokenAt = timeFromUnix(tokenAt)
+		ret[id] = dm
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// enrollmentsWhere builds the WHERE clause and arguments for filter.
+func enrollmentsWhere(filter *storage.EnrollmentFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if len(filter.IDs) > 0 {
+		where = append(where, `id IN (?`+strings.Repeat(", ?", len(filter.IDs)-1)+`)`)
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if filter.DeviceID != "" {
+		where = append(where, `device_id = ?`)
+		args = append(args, filter.DeviceID)
+	}
+	if filter.Enabled != nil {
+		where = append(where, `enabled = ?`)
+		args = append(args, *filter.Enabled)
+	}
+	if len(where) < 1 {
+		return "", nil
+	}
+	return ` WHERE ` + strings.Join(where, " AND "), args
+}
+
+func (s *MySQLStorage) RetrieveEnrollments(ctx context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	if filter == nil {
+		filter = &storage.EnrollmentFilter{}
+	}
+	where, args := enrollmentsWhere(filter)
+	var limit string
+	if filter.Limit > 0 {
+		limit = ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, device_id, user_id, (SELECT user_short_name FROM users WHERE users.id = enrollments.user_id AND users.device_id = enrollments.device_id), type, topic, enabled, UNIX_TIMESTAMP(last_seen_at), disable_reason, UNIX_TIMESTAMP(disabled_at) FROM enrollments`+where+` ORDER BY id`+limit+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var enrollments []*storage.Enrollment
+	for rows.Next() {
+		var userID, userShortName, reason sql.NullString
+		var lastSeenAt, disabledAt sql.NullInt64
+		e := new(storage.Enrollment)
+		if err := rows.Scan(&e.ID, &e.DeviceID, &userID, &userShortName, &e.Type, &e.Topic, &e.Enabled, &lastSeenAt, &reason, &disabledAt); err != nil {
+			return nil, err
+		}
+		e.UserID = userID.String
+		e.UserShortName = userShortName.String
+		if t := timeFromUnix(lastSeenAt); t != nil {
+			e.LastSeenAt = *t
+		}
+		e.DisableReason = reason.String
+		e.DisabledAt = timeFromUnix(disabledAt)
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, rows.Err()
+}
@@ -0,0 +1,55 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreLogEvent(ctx context.Context, event *storage.LogEvent) error {
+	result, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO event_log (topic, enrollment_id, command_uuid, status, raw_payload, cert_hash) VALUES (?, ?, ?, ?, ?, ?);`,
+		event.Topic,
+		event.EnrollmentID,
+		nullEmptyString(event.CommandUUID),
+		nullEmptyString(event.Status),
+		event.RawPayload,
+		nullEmptyString(event.CertHash),
+	)
+	if err != nil {
+		return err
+	}
+	event.Seq, err = result.LastInsertId()
+	return err
+}
+
+func (s *MySQLStorage) RetrieveLogEvents(ctx context.Context, after int64, limit int) ([]*storage.LogEvent, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT seq, topic, enrollment_id, command_uuid, status, raw_payload, cert_hash, UNIX_TIMESTAMP(created_at) FROM event_log WHERE seq > ? ORDER BY seq LIMIT ?;`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []*storage.LogEvent
+	for rows.Next() {
+		event := new(storage.LogEvent)
+		var commandUUID, status, certHash sql.NullString
+		var createdAt sql.NullInt64
+		if err = rows.Scan(&event.Seq, &event.Topic, &event.EnrollmentID, &commandUUID, &status, &event.RawPayload, &certHash, &createdAt); err != nil {
+			return nil, err
+		}
+		event.CommandUUID = commandUUID.String
+		event.Status = status.String
+		event.CertHash = certHash.String
+		if t := timeFromUnix(createdAt); t != nil {
+			event.CreatedAt = *t
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
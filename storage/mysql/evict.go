@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreEnrollmentEviction(ctx context.Context, eviction *storage.EnrollmentEviction) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_evictions
+    (id, reason, evicted_by)
+VALUES
+    (?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    reason = new.reason,
+    evicted_by = new.evicted_by,
+    delivered_at = NULL,
+    created_at = CURRENT_TIMESTAMP;`,
+		eviction.ID, nullEmptyString(eviction.Reason), nullEmptyString(eviction.EvictedBy),
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, reason, evicted_by, UNIX_TIMESTAMP(created_at), UNIX_TIMESTAMP(delivered_at) FROM enrollment_evictions`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentEviction)
+	for rows.Next() {
+		eviction := new(storage.EnrollmentEviction)
+		var reason, evictedBy sql.NullString
+		var createdAt, deliveredAt sql.NullInt64
+		if err := rows.Scan(&eviction.ID, &reason, &evictedBy, &createdAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		eviction.Reason, eviction.EvictedBy = reason.String, evictedBy.String
+		if t := timeFromUnix(createdAt); t != nil {
+			eviction.CreatedAt = t.UTC()
+		}
+		if t := timeFromUnix(deliveredAt); t != nil {
+			delivered := t.UTC()
+			eviction.DeliveredAt = &delivered
+		}
+		ret[eviction.ID] = eviction
+	}
+	return ret, rows.Err()
+}
+
+func (s *MySQLStorage) MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_evictions SET delivered_at = CURRENT_TIMESTAMP WHERE id = ? AND delivered_at IS NULL;`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *MySQLStorage) DeleteEnrollmentEviction(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_evictions WHERE id = ?;`, id)
+	return err
+}
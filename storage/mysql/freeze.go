@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreEnrollmentFreeze(ctx context.Context, freeze *storage.EnrollmentFreeze) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_freezes
+    (id, reason, frozen_by)
+VALUES
+    (?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    reason = new.reason,
+    frozen_by = new.frozen_by,
+    created_at = CURRENT_TIMESTAMP;`,
+		freeze.ID, nullEmptyString(freeze.Reason), nullEmptyString(freeze.FrozenBy),
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentFreezes(ctx context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, reason, frozen_by, UNIX_TIMESTAMP(created_at) FROM enrollment_freezes`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentFreeze)
+	for rows.Next() {
+		freeze := new(storage.EnrollmentFreeze)
+		var reason, frozenBy sql.NullString
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&freeze.ID, &reason, &frozenBy, &createdAt); err != nil {
+			return nil, err
+		}
+		freeze.Reason, freeze.FrozenBy = reason.String, frozenBy.String
+		if t := timeFromUnix(createdAt); t != nil {
+			freeze.CreatedAt = t.UTC()
+		}
+		ret[freeze.ID] = freeze
+	}
+	return ret, rows.Err()
+}
+
+func (s *MySQLStorage) DeleteEnrollmentFreeze(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_freezes WHERE id = ?;`, id)
+	return err
+}
@@ -0,0 +1,44 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+func (s *MySQLStorage) StoreInventory(ctx context.Context, id, source string, values map[string]string) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO inventory_snapshots
+    (id, source, snapshot)
+VALUES
+    (?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    snapshot = new.snapshot;`,
+		id, source, string(b),
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveInventory(ctx context.Context, id, source string) (map[string]string, error) {
+	var snapshot string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT snapshot FROM inventory_snapshots WHERE id = ? AND source = ?;`,
+		id, source,
+	).Scan(&snapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	return values, json.Unmarshal([]byte(snapshot), &values)
+}
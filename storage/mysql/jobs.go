@@ -0,0 +1,151 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreJob(ctx context.Context, job *storage.Job, ids []string) error {
+	if len(ids) < 1 {
+		return errors.New("no id(s) supplied for job")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO jobs (id, name, command_uuid, request_type) VALUES (?, ?, ?, ?);`,
+		job.ID, nullEmptyString(job.Name), nullEmptyString(job.CommandUUID), nullEmptyString(job.RequestType),
+	)
+	if err == nil {
+		query := `INSERT INTO job_targets (job_id, id, status) VALUES (?, ?, ?)`
+		query += strings.Repeat(", (?, ?, ?)", len(ids)-1)
+		args := make([]interface{}, len(ids)*3)
+		for i, id := range ids {
+			args[i*3] = job.ID
+			args[i*3+1] = id
+			args[i*3+2] = storage.JobStatusQueued
+		}
+		_, err = tx.ExecContext(ctx, query+";", args...)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *MySQLStorage) UpdateJobTargets(ctx context.Context, jobID, status string, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	args := []interface{}{status, jobID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE job_targets SET status = ? WHERE job_id = ? AND id IN (?`+strings.Repeat(", ?", len(ids)-1)+`);`,
+		args...,
+	)
+	return err
+}
+
+// jobStatusesFrom returns the statuses from which status may progress.
+func jobStatusesFrom(status string) []interface{} {
+	if status == storage.JobStatusDelivered {
+		return []interface{}{storage.JobStatusQueued}
+	}
+	return []interface{}{storage.JobStatusQueued, storage.JobStatusDelivered}
+}
+
+func (s *MySQLStorage) UpdateJobCommandTarget(ctx context.Context, id, commandUUID, status string) error {
+	from := jobStatusesFrom(status)
+	args := append([]interface{}{status, id, commandUUID}, from...)
+	_, err := s.db.ExecContext(
+		ctx, `
+UPDATE
+    job_targets t
+    INNER JOIN jobs j
+        ON t.job_id = j.id
+SET
+    t.status = ?
+WHERE
+    t.id = ? AND
+    j.command_uuid = ? AND
+    t.status IN (?`+strings.Repeat(", ?", len(from)-1)+`);`,
+		args...,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	var name, commandUUID, requestType sql.NullString
+	var createdAt sql.NullInt64
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT name, command_uuid, request_type, UNIX_TIMESTAMP(created_at) FROM jobs WHERE id = ?;`,
+		jobID,
+	).Scan(&name, &commandUUID, &requestType, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job := &storage.Job{
+		ID:          jobID,
+		Name:        name.String,
+		CommandUUID: commandUUID.String,
+		RequestType: requestType.String,
+	}
+	if t := timeFromUnix(createdAt); t != nil {
+		job.CreatedAt = *t
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT status, COUNT(*) FROM job_targets WHERE job_id = ? GROUP BY status;`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var ct int
+		if err := rows.Scan(&status, &ct); err != nil {
+			return nil, err
+		}
+		job.Counts.Add(status, ct)
+	}
+	return job, rows.Err()
+}
+
+func (s *MySQLStorage) RetrieveJobTargets(ctx context.Context, jobID string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, status FROM job_targets WHERE job_id = ?;`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]string)
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		ret[id] = status
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,66 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// queueLockName returns the GET_LOCK name of the command queue of
+// enrollment id. Lock names are limited to 64 characters so the ID is
+// hashed. Hash collisions only serialize unrelated enrollments.
+func queueLockName(id string) string {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	return fmt.Sprintf("nanomdm.queue.%016x", h.Sum64())
+}
+
+// discardConn closes conn and removes it from the connection pool
+// which releases any locks held by its session.
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// LockQueue locks the command queue of enrollment id with GET_LOCK if
+// serialized queue operations are enabled (see WithSerializedQueue).
+// The lock is held by a dedicated connection until unlocked.
+func (s *MySQLStorage) LockQueue(ctx context.Context, id string) (func(), error) {
+	if s.lockTimeout <= 0 {
+		return func() {}, nil
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name := queueLockName(id)
+	var locked sql.NullInt64
+	err = conn.QueryRowContext(
+		ctx,
+		`SELECT GET_LOCK(?, ?);`,
+		name, int64(math.Ceil(s.lockTimeout.Seconds())),
+	).Scan(&locked)
+	if err != nil {
+		discardConn(conn)
+		return nil, err
+	}
+	if !locked.Valid || locked.Int64 != 1 {
+		discardConn(conn)
+		return nil, fmt.Errorf("timeout locking queue: %s", id)
+	}
+	return func() {
+		// the request context may be done by now
+		_, err := conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?);`, name)
+		if err != nil {
+			ctxlog.Logger(ctx, s.logger).Info("msg", "releasing queue lock", "id", id, "err", err)
+			discardConn(conn)
+			return
+		}
+		conn.Close()
+	}, nil
+}
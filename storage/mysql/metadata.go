@@ -0,0 +1,70 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// marshalList JSON-encodes l or returns nil for an empty list.
+func marshalList(l []string) ([]byte, error) {
+	if len(l) < 1 {
+		return nil, nil
+	}
+	return json.Marshal(l)
+}
+
+func (s *MySQLStorage) StoreEnrollmentMetadata(ctx context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	tags, err := marshalList(meta.Tags)
+	if err != nil {
+		return err
+	}
+	groups, err := marshalList(meta.Groups)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx, `
+INSERT INTO enrollment_metadata
+    (id, tenant, tags, group_names)
+VALUES
+    (?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    tenant = new.tenant,
+    tags = new.tags,
+    group_names = new.group_names;`,
+		id, nullEmptyString(meta.Tenant), tags, groups,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentMetadata(ctx context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	var tenant sql.NullString
+	var tags, groups []byte
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT tenant, tags, group_names FROM enrollment_metadata WHERE id = ?;`,
+		id,
+	).Scan(&tenant, &tags, &groups)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	meta := &storage.EnrollmentMetadata{Tenant: tenant.String}
+	if len(tags) > 0 {
+		if err = json.Unmarshal(tags, &meta.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if len(groups) > 0 {
+		if err = json.Unmarshal(groups, &meta.Groups); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
@@ -7,9 +7,13 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/cryptoutil/keyprovider"
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/batch"
 
 	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/ctxlog"
@@ -23,17 +27,26 @@ var Schema string
 var ErrNoCert = errors.New("no certificate in MDM Request")
 
 type MySQLStorage struct {
-	logger log.Logger
-	db     *sql.DB
-	rm     bool
+	logger  log.Logger
+	db      *sql.DB
+	rm      bool
+	batcher *batch.Batcher
+	kp      keyprovider.KeyProvider
+
+	// lockTimeout enables serialized queue operations
+	lockTimeout time.Duration
 }
 
 type config struct {
-	driver string
-	dsn    string
-	db     *sql.DB
-	logger log.Logger
-	rm     bool
+	driver      string
+	dsn         string
+	db          *sql.DB
+	logger      log.Logger
+	rm          bool
+	batchWindow time.Duration
+	batchMax    int
+	kp          keyprovider.KeyProvider
+	lockTimeout time.Duration
 }
 
 type Option func(*config)
@@ -68,6 +81,37 @@ func WithDeleteCommands() Option {
 	}
 }
 
+// WithReportBatching batches command report writes. Reports arriving
+// within window of each other (up to max reports) are written together
+// in a single transaction. This trades a little latency for many fewer
+// transactions under load.
+func WithReportBatching(window time.Duration, max int) Option {
+	return func(c *config) {
+		c.batchWindow = window
+		c.batchMax = max
+	}
+}
+
+// WithKeyProvider encrypts push certificate private keys at rest with
+// kp. Keys stored before encryption was enabled can still be read and
+// are encrypted with MigratePushCertKeys.
+func WithKeyProvider(kp keyprovider.KeyProvider) Option {
+	return func(c *config) {
+		c.kp = kp
+	}
+}
+
+// WithSerializedQueue serializes the command queue operations of each
+// enrollment with per-enrollment database locks (see LockQueue). This
+// trades latency (and a database connection per locked enrollment) for
+// strictly ordered, exactly-once command delivery to enrollments making
+// concurrent requests. Acquiring a lock fails after timeout.
+func WithSerializedQueue(timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = timeout
+	}
+}
+
 func New(opts ...Option) (*MySQLStorage, error) {
 	cfg := &config{logger: log.NopLogger, driver: "mysql"}
 	for _, opt := range opts {
@@ -83,7 +127,11 @@ func New(opts ...Option) (*MySQLStorage, error) {
 	if err = cfg.db.Ping(); err != nil {
 		return nil, err
 	}
-	return &MySQLStorage{db: cfg.db, logger: cfg.logger, rm: cfg.rm}, nil
+	s := &MySQLStorage{db: cfg.db, logger: cfg.logger, rm: cfg.rm, kp: cfg.kp, lockTimeout: cfg.lockTimeout}
+	if cfg.batchWindow > 0 {
+		s.batcher = batch.New(cfg.batchWindow, cfg.batchMax, s.flushReports)
+	}
+	return s, nil
 }
 
 // nullEmptyString returns a NULL string if s is empty.
@@ -99,7 +147,7 @@ func (s *MySQLStorage) StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate)
 	if r.Certificate != nil {
 		pemCert = cryptoutil.PEMCertificate(r.Certificate.Raw)
 	}
-	_, err := s.db.ExecContext(
+	_, err := s.execer(r.Context).ExecContext(
 		r.Context, `
 INSERT INTO devices
     (id, identity_cert, serial_number, authenticate, authenticate_at)
@@ -113,7 +161,10 @@ UPDATE
     authenticate_at = CURRENT_TIMESTAMP;`,
 		r.ID, pemCert, nullEmptyString(msg.SerialNumber), msg.Raw,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return s.storeEnrollmentAliases(r, storage.AuthenticateAliases(msg))
 }
 
 func (s *MySQLStorage) storeDeviceTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
@@ -126,7 +177,7 @@ func (s *MySQLStorage) storeDeviceTokenUpdate(r *mdm.Request, msg *mdm.TokenUpda
 	}
 	query += ` WHERE id = ? LIMIT 1;`
 	args = append(args, r.ID)
-	_, err := s.db.ExecContext(r.Context, query, args...)
+	_, err := s.execer(r.Context).ExecContext(r.Context, query, args...)
 	return err
 }
 
@@ -138,7 +189,7 @@ func (s *MySQLStorage) storeUserTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate
 			"msg", "Unlock Token on user channel not stored",
 		)
 	}
-	_, err := s.db.ExecContext(
+	_, err := s.execer(r.Context).ExecContext(
 		r.Context, `
 INSERT INTO users
     (id, device_id, user_short_name, user_long_name, token_update, token_update_at)
@@ -160,7 +211,17 @@ UPDATE
 	return err
 }
 
+// StoreTokenUpdate stores the TokenUpdate of the device or user and
+// its enrollment in a single transaction.
 func (s *MySQLStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
+	return s.InTransaction(r.Context, func(ctx context.Context) error {
+		r := r.Clone()
+		r.Context = ctx
+		return s.storeTokenUpdate(r, msg)
+	})
+}
+
+func (s *MySQLStorage) storeTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
 	var err error
 	var deviceId, userId string
 	resolved := (&msg.Enrollment).Resolved()
@@ -178,7 +239,7 @@ func (s *MySQLStorage) StoreTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) er
 	if err != nil {
 		return err
 	}
-	_, err = s.db.ExecContext(
+	_, err = s.execer(r.Context).ExecContext(
 		r.Context, `
 INSERT INTO enrollments
 	(id, device_id, user_id, type, topic, push_magic, token_hex, last_seen_at, token_update_tally)
@@ -252,19 +313,25 @@ UPDATE
 
 // Disable can be called for an Authenticate or CheckOut message
 func (s *MySQLStorage) Disable(r *mdm.Request) error {
+	return s.DisableWithReason(r, "")
+}
+
+// DisableWithReason disables like Disable recording reason.
+func (s *MySQLStorage) DisableWithReason(r *mdm.Request, reason string) error {
 	if r.ParentID != "" {
 		return errors.New("can only disable a device channel")
 	}
-	_, err := s.db.ExecContext(
+	_, err := s.execer(r.Context).ExecContext(
 		r.Context,
-		`UPDATE enrollments SET enabled = 0, token_update_tally = 0, last_seen_at = CURRENT_TIMESTAMP WHERE device_id = ? AND enabled = 1;`,
+		`UPDATE enrollments SET enabled = 0, token_update_tally = 0, last_seen_at = CURRENT_TIMESTAMP, disable_reason = ?, disabled_at = CURRENT_TIMESTAMP WHERE device_id = ? AND enabled = 1;`,
+		nullEmptyString(reason),
 		r.ID,
 	)
 	return err
 }
 
 func (s *MySQLStorage) updateLastSeen(r *mdm.Request) (err error) {
-	_, err = s.db.ExecContext(
+	_, err = s.execer(r.Context).ExecContext(
 		r.Context,
 		`UPDATE enrollments SET last_seen_at = CURRENT_TIMESTAMP WHERE id = ?`,
 		r.ID,
@@ -0,0 +1,36 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func (s *MySQLStorage) StoreCommandOwner(ctx context.Context, uuid, owner string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO command_owners
+    (command_uuid, owner)
+VALUES
+    (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    owner = new.owner;`,
+		uuid, owner,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveCommandOwner(ctx context.Context, uuid string) (string, error) {
+	var owner string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT owner FROM command_owners WHERE command_uuid = ?;`,
+		uuid,
+	).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
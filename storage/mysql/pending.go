@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StorePendingCommand(ctx context.Context, cmd *storage.PendingCommand) error {
+	var expiresAt, notBefore interface{}
+	if cmd.ExpiresAt != nil {
+		expiresAt = cmd.ExpiresAt.Unix()
+	}
+	if cmd.NotBefore != nil {
+		notBefore = cmd.NotBefore.Unix()
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO pending_commands
+    (command_uuid, request_type, targets, no_push, requested_by, command, expires_at, not_before, priority, template, locale, owner)
+VALUES
+    (?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?), FROM_UNIXTIME(?), ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    request_type = new.request_type,
+    targets = new.targets,
+    no_push = new.no_push,
+    requested_by = new.requested_by,
+    command = new.command,
+    expires_at = new.expires_at,
+    not_before = new.not_before,
+    priority = new.priority,
+    template = new.template,
+    locale = new.locale,
+    owner = new.owner,
+    created_at = CURRENT_TIMESTAMP;`,
+		cmd.CommandUUID, cmd.RequestType, strings.Join(cmd.Targets, ","), cmd.NoPush, nullEmptyString(cmd.RequestedBy), cmd.Command,
+		expiresAt, notBefore, cmd.Priority,
+		nullEmptyString(cmd.Template), nullEmptyString(cmd.Locale), nullEmptyString(cmd.Owner),
+	)
+	return err
+}
+
+// scanPendingCommand scans a pending command row.
+func scanPendingCommand(row interface{ Scan(...interface{}) error }) (*storage.PendingCommand, error) {
+	cmd := new(storage.PendingCommand)
+	var targets string
+	var requestedBy, template, locale, owner sql.NullString
+	var expiresAt, notBefore, createdAt sql.NullInt64
+	if err := row.Scan(
+		&cmd.CommandUUID, &cmd.RequestType, &targets, &cmd.NoPush, &requestedBy, &cmd.Command,
+		&expiresAt, &notBefore, &cmd.Priority, &template, &locale, &owner, &createdAt,
+	); err != nil {
+		return nil, err
+	}
+	cmd.Targets = strings.Split(targets, ",")
+	cmd.RequestedBy = requestedBy.String
+	cmd.Template, cmd.Locale, cmd.Owner = template.String, locale.String, owner.String
+	if t := timeFromUnix(expiresAt); t != nil {
+		utc := t.UTC()
+		cmd.ExpiresAt = &utc
+	}
+	if t := timeFromUnix(notBefore); t != nil {
+		utc := t.UTC()
+		cmd.NotBefore = &utc
+	}
+	if t := timeFromUnix(createdAt); t != nil {
+		cmd.CreatedAt = t.UTC()
+	}
+	return cmd, nil
+}
+
+const pendingColumns = `command_uuid, request_type, targets, no_push, requested_by, command, UNIX_TIMESTAMP(expires_at), UNIX_TIMESTAMP(not_before), priority, template, locale, owner, UNIX_TIMESTAMP(created_at)`
+
+func (s *MySQLStorage) RetrievePendingCommand(ctx context.Context, uuid string) (*storage.PendingCommand, error) {
+	cmd, err := scanPendingCommand(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+pendingColumns+` FROM pending_commands WHERE command_uuid = ?;`,
+		uuid,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return cmd, err
+}
+
+func (s *MySQLStorage) RetrievePendingCommands(ctx context.Context) ([]*storage.PendingCommand, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+pendingColumns+` FROM pending_commands ORDER BY created_at, command_uuid;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cmds []*storage.PendingCommand
+	for rows.Next() {
+		cmd, err := scanPendingCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, rows.Err()
+}
+
+func (s *MySQLStorage) DeletePendingCommand(ctx context.Context, uuid string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM pending_commands WHERE command_uuid = ?;`, uuid)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
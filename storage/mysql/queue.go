@@ -6,28 +6,39 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) error {
 	if len(ids) < 1 {
 		return errors.New("no id(s) supplied to queue command to")
 	}
+	var expiresAt interface{}
+	if !cmd.ExpiresAt.IsZero() {
+		expiresAt = cmd.ExpiresAt.Unix()
+	}
+	var notBefore interface{}
+	if !cmd.NotBefore.IsZero() {
+		notBefore = cmd.NotBefore.Unix()
+	}
 	_, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO commands (command_uuid, request_type, command) VALUES (?, ?, ?);`,
-		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw,
+		`INSERT INTO commands (command_uuid, request_type, command, expires_at, not_before) VALUES (?, ?, ?, FROM_UNIXTIME(?), FROM_UNIXTIME(?));`,
+		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw, expiresAt, notBefore,
 	)
 	if err != nil {
 		return err
 	}
-	query := `INSERT INTO enrollment_queue (id, command_uuid) VALUES (?, ?)`
-	query += strings.Repeat(", (?, ?)", len(ids)-1)
-	args := make([]interface{}, len(ids)*2)
+	query := `INSERT INTO enrollment_queue (id, command_uuid, priority) VALUES (?, ?, ?)`
+	query += strings.Repeat(", (?, ?, ?)", len(ids)-1)
+	args := make([]interface{}, len(ids)*3)
 	for i, id := range ids {
-		args[i*2] = id
-		args[i*2+1] = cmd.CommandUUID
+		args[i*3] = id
+		args[i*3+1] = cmd.CommandUUID
+		args[i*3+2] = cmd.Priority
 	}
 	_, err = tx.ExecContext(ctx, query+";", args...)
 	return err
@@ -113,6 +124,9 @@ func (s *MySQLStorage) deleteCommandTx(r *mdm.Request, result *mdm.CommandResult
 }
 
 func (s *MySQLStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	if s.batcher != nil && (!s.rm || result.Status == "NotNow" || result.Status == "Idle") {
+		return s.batcher.Submit(r.Context, &reportItem{id: r.ID, result: result})
+	}
 	if err := s.updateLastSeen(r); err != nil {
 		return err
 	}
@@ -148,32 +162,65 @@ UPDATE
 	return err
 }
 
+// RetrieveNextCommand retrieves the next queued command for r.
+// The queue can be walked in order using the idx_queue_next index and each
+// queue item is checked for a result using the idx_results_status
+// covering index. This avoids sorting an enrollment's whole queue
+// history or reading (large) result rows. Expired commands are
+// deactivated and skipped. Commands scheduled in the future are skipped
+// but stay queued.
 func (s *MySQLStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
-	command := new(mdm.Command)
-	err := s.db.QueryRowContext(
-		r.Context, `
-SELECT c.command_uuid, c.request_type, c.command
+	for {
+		command := new(mdm.Command)
+		var expiresAt sql.NullInt64
+		err := s.db.QueryRowContext(
+			r.Context, `
+SELECT c.command_uuid, c.request_type, c.command, UNIX_TIMESTAMP(c.expires_at), q.priority
 FROM enrollment_queue AS q
     INNER JOIN commands AS c
         ON q.command_uuid = c.command_uuid
-    LEFT JOIN command_results r
-        ON r.command_uuid = q.command_uuid AND r.id = q.id
 WHERE q.id = ?
     AND q.active = 1
-    AND (r.status IS NULL OR (r.status = 'NotNow' AND NOT ?))
+    AND (c.not_before IS NULL OR c.not_before <= CURRENT_TIMESTAMP)
+    AND NOT EXISTS (
+        SELECT 1
+        FROM command_results AS r
+        WHERE r.id = q.id
+            AND r.command_uuid = q.command_uuid
+            AND (r.status != 'NotNow' OR ?)
+    )
 ORDER BY
     q.priority DESC,
     q.created_at
 LIMIT 1;`,
-		r.ID, skipNotNow,
-	).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-	if err != nil {
-		return nil, err
+			r.ID, skipNotNow,
+		).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw, &expiresAt, &command.Priority)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if t := timeFromUnix(expiresAt); t != nil {
+			command.ExpiresAt = t.UTC()
+		}
+		if !command.Expired(time.Now()) {
+			return command, nil
+		}
+		if err = s.expireCommand(r.Context, r.ID, command.CommandUUID); err != nil {
+			return nil, fmt.Errorf("expiring command: %w", err)
+		}
 	}
-	return command, nil
+}
+
+// expireCommand deactivates the expired command uuid in the queue of id.
+func (s *MySQLStorage) expireCommand(ctx context.Context, id, uuid string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = 0 WHERE id = ? AND command_uuid = ?;`,
+		id, uuid,
+	)
+	return err
 }
 
 func (s *MySQLStorage) ClearQueue(r *mdm.Request) error {
@@ -184,7 +231,7 @@ func (s *MySQLStorage) ClearQueue(r *mdm.Request) error {
 	// this will clear (mark inactive) the queue of not only this
 	// device ID, but all user-channel enrollments with a 'parent' ID of
 	// this device, too.
-	_, err := s.db.ExecContext(
+	_, err := s.execer(r.Context).ExecContext(
 		r.Context,
 		`
 UPDATE
@@ -205,3 +252,165 @@ WHERE
 	)
 	return err
 }
+
+// RetrieveQueue retrieves the queued commands of id in queue order.
+func (s *MySQLStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(ctx, `v.id = ?`, id)
+}
+
+// RetrieveQueuedCommands retrieves the active, unexpired commands of id
+// without a result (or with NotNow) in queue order.
+func (s *MySQLStorage) RetrieveQueuedCommands(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(
+		ctx, `v.id = ?
+    AND v.active = 1
+    AND (v.status IS NULL OR v.status = 'NotNow')
+    AND (c.expires_at IS NULL OR c.expires_at > CURRENT_TIMESTAMP)`,
+		id,
+	)
+}
+
+// CancelQueuedCommand deactivates the command uuid in the queue of id
+// if it is active and has no result (or NotNow).
+func (s *MySQLStorage) CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx, `
+UPDATE enrollment_queue AS q
+SET q.active = 0
+WHERE q.id = ?
+    AND q.command_uuid = ?
+    AND q.active = 1
+    AND NOT EXISTS (
+        SELECT 1
+        FROM command_results AS r
+        WHERE r.id = q.id
+            AND r.command_uuid = q.command_uuid
+            AND r.status != 'NotNow'
+    );`,
+		id, uuid,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// queryQueue retrieves the queued commands matching the where clause
+// in queue order.
+func (s *MySQLStorage) queryQueue(ctx context.Context, where string, args ...interface{}) ([]*storage.QueuedCommand, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`
+SELECT
+    v.command_uuid, v.request_type, v.active, v.status, v.command, v.result, v.priority,
+    UNIX_TIMESTAMP(v.created_at), UNIX_TIMESTAMP(c.expires_at), UNIX_TIMESTAMP(c.not_before)
+FROM
+    view_queue AS v
+    INNER JOIN commands AS c
+        ON c.command_uuid = v.command_uuid
+WHERE
+    `+where+`
+ORDER BY
+    v.priority DESC,
+    v.created_at;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	var cmds []*storage.QueuedCommand
+	for rows.Next() {
+		cmd := new(storage.QueuedCommand)
+		var status sql.NullString
+		var createdAt, expiresAt, notBefore sql.NullInt64
+		if err = rows.Scan(&cmd.CommandUUID, &cmd.RequestType, &cmd.Active, &status, &cmd.Command, &cmd.Result, &cmd.Priority, &createdAt, &expiresAt, &notBefore); err != nil {
+			return nil, err
+		}
+		cmd.Status = status.String
+		if t := timeFromUnix(createdAt); t != nil {
+			cmd.CreatedAt = *t
+		}
+		cmd.SetExpiry(timeFromUnix(expiresAt), now)
+		cmd.NotBefore = timeFromUnix(notBefore)
+		cmds = append(cmds, cmd)
+	}
+	return cmds, rows.Err()
+}
+
+// RetrieveScheduledEnrollments retrieves the IDs of enrollments with
+// active queued commands scheduled after after and at or before until.
+func (s *MySQLStorage) RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT q.id
+FROM enrollment_queue AS q
+    INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+WHERE q.active = 1
+    AND c.not_before > FROM_UNIXTIME(?)
+    AND c.not_before <= FROM_UNIXTIME(?)
+    AND NOT EXISTS (
+        SELECT 1
+        FROM command_results AS r
+        WHERE r.id = q.id
+            AND r.command_uuid = q.command_uuid
+            AND r.status != 'NotNow'
+    );`,
+		after.Unix(), until.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RetrieveQueuedEnrollments retrieves the IDs of enabled enrollments
+// with due, unexpired commands without a result (or with NotNow).
+func (s *MySQLStorage) RetrieveQueuedEnrollments(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT DISTINCT q.id
+FROM enrollment_queue AS q
+    INNER JOIN enrollments AS e
+        ON q.id = e.id
+    INNER JOIN commands AS c
+        ON q.command_uuid = c.command_uuid
+WHERE q.active = 1
+    AND e.enabled = 1
+    AND (c.not_before IS NULL OR c.not_before <= CURRENT_TIMESTAMP)
+    AND (c.expires_at IS NULL OR c.expires_at > CURRENT_TIMESTAMP)
+    AND NOT EXISTS (
+        SELECT 1
+        FROM command_results AS r
+        WHERE r.id = q.id
+            AND r.command_uuid = q.command_uuid
+            AND r.status != 'NotNow'
+    )
+ORDER BY q.id;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
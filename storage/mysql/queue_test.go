@@ -1,9 +1,12 @@
 package mysql
 
 import (
+	"context"
 	"os"
 	"testing"
+	"time"
 
+	"github.com/micromdm/nanomdm/storage/conformance"
 	"github.com/micromdm/nanomdm/storage/test"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -37,4 +40,383 @@ func TestQueue(t *testing.T) {
 	t.Run("normal", func(t *testing.T) {
 		test.TestQueue(t, d.UDID, storage)
 	})
+
+	t.Run("RetrieveQueue", func(t *testing.T) {
+		test.TestRetrieveQueue(t, d.UDID, storage)
+		test.TestExpiringQueue(t, d.UDID, storage)
+		test.TestScheduledQueue(t, d.UDID, storage)
+		test.TestQueuedEnrollments(t, d.UDID, storage)
+		test.TestPriorityQueue(t, d.UDID, storage)
+		test.TestQueuedCommands(t, d.UDID, storage)
+	})
+}
+
+func TestEnrollments(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestTopicStats(t, d.UDID, storage)
+	test.TestEnrollments(t, d.UDID, storage)
+}
+
+func TestEnrollmentAliases(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMsg, _, err := loadAuthMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEnrollmentAliases(t, d.UDID, authMsg.SerialNumber, storage)
+}
+
+func TestDDMStatusReports(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestDDMStatusReports(t, d.UDID, storage)
+}
+
+func TestUserSessions(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestUserSessions(t, d.UDID, storage)
+}
+
+func TestEnrollmentTombstones(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authMsg, _, err := loadAuthMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEnrollmentTombstones(t, d.UDID, authMsg.SerialNumber, storage)
+}
+
+func TestJobs(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestJobs(t, storage)
+}
+
+func TestEventLog(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEventLog(t, storage)
+}
+
+func TestMetricsRollups(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestMetricsRollups(t, storage)
+}
+
+func TestAPIKeys(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestAPIKeys(t, storage)
+}
+
+func TestPendingCommands(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestPendingCommands(t, storage)
+}
+
+func TestEnrollmentFreezes(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEnrollmentFreezes(t, storage)
+}
+
+func TestEnrollmentEvictions(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEnrollmentEvictions(t, storage)
+}
+
+func TestEnrollmentSupersessions(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestEnrollmentSupersessions(t, storage)
+}
+
+func TestInventory(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestInventory(t, storage)
+}
+
+func TestCommandOwners(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestCommandOwners(t, storage)
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestWebhookDeliveries(t, storage)
+}
+
+func TestMessageTemplates(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestMessageTemplates(t, storage)
+}
+
+func TestDeclarations(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestDeclarations(t, storage)
+}
+
+func TestUnlockTokenRequests(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestUnlockTokenRequests(t, storage)
+}
+
+func TestQueueLocker(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN), WithSerializedQueue(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestQueueLocker(t, storage)
+}
+
+func TestSchema(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := storage.CheckSchema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = report.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollation(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := storage.CheckCollation(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range report.Problems {
+		t.Log(problem)
+	}
+}
+
+// TestConformance runs the conformance tests against the (empty)
+// database of NANOMDM_MYSQL_STORAGE_CONFORMANCE_DSN.
+func TestConformance(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_CONFORMANCE_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_CONFORMANCE_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conformance.Run(t, storage)
 }
@@ -0,0 +1,56 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) AddMetricsRollup(ctx context.Context, rollup *storage.MetricsRollup) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO metrics_rollups
+    (period, start_at, checkins, commands, errors)
+VALUES
+    (?, FROM_UNIXTIME(?), ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    checkins = metrics_rollups.checkins + new.checkins,
+    commands = metrics_rollups.commands + new.commands,
+    errors = metrics_rollups.errors + new.errors;`,
+		rollup.Period,
+		rollup.Start.Unix(),
+		rollup.Checkins,
+		rollup.Commands,
+		rollup.Errors,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveMetricsRollups(ctx context.Context, period string, since time.Time) ([]*storage.MetricsRollup, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT UNIX_TIMESTAMP(start_at), checkins, commands, errors FROM metrics_rollups WHERE period = ? AND start_at >= FROM_UNIXTIME(?) ORDER BY start_at;`,
+		period, since.Unix(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rollups []*storage.MetricsRollup
+	for rows.Next() {
+		rollup := &storage.MetricsRollup{Period: period}
+		var start sql.NullInt64
+		if err = rows.Scan(&start, &rollup.Checkins, &rollup.Commands, &rollup.Errors); err != nil {
+			return nil, err
+		}
+		if t := timeFromUnix(start); t != nil {
+			rollup.Start = t.UTC()
+		}
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,42 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage/sqlschema"
+)
+
+// schemaChecker returns a checker of the database against Schema.
+func (s *MySQLStorage) schemaChecker() *sqlschema.Checker {
+	return sqlschema.New(
+		s.db,
+		sqlschema.Parse(Schema),
+		`SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = DATABASE();`,
+		`SELECT table_name, index_name FROM information_schema.statistics WHERE table_schema = DATABASE();`,
+	)
+}
+
+// CheckSchema checks the database for the tables, columns, and indexes
+// of Schema.
+func (s *MySQLStorage) CheckSchema(ctx context.Context) (*sqlschema.Report, error) {
+	return s.schemaChecker().Check(ctx)
+}
+
+// MigrateSchema creates the tables and adds the columns and indexes of
+// Schema that are missing from the database where possible. See
+// sqlschema.Checker.Migrate.
+func (s *MySQLStorage) MigrateSchema(ctx context.Context) (*sqlschema.Report, error) {
+	return s.schemaChecker().Migrate(ctx)
+}
+
+// SchemaEmpty reports whether none of the tables of Schema exist in the
+// database.
+func (s *MySQLStorage) SchemaEmpty(ctx context.Context) (bool, error) {
+	return s.schemaChecker().Empty(ctx)
+}
+
+// DropSchema drops the tables of Schema and all of their data from the
+// database. It is intended for tearing down test databases.
+func (s *MySQLStorage) DropSchema(ctx context.Context) error {
+	return s.schemaChecker().Drop(ctx)
+}
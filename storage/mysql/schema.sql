@@ -102,6 +102,10 @@ CREATE TABLE enrollments (
     enabled            BOOLEAN NOT NULL DEFAULT 1,
     token_update_tally INTEGER NOT NULL DEFAULT 1,
 
+    -- Why and when the enrollment was last disabled, if ever.
+    disable_reason VARCHAR(31) NULL,
+    disabled_at    TIMESTAMP   NULL,
+
     last_seen_at TIMESTAMP NOT NULL,
 
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -137,12 +141,18 @@ CREATE TABLE commands (
     request_type VARCHAR(63)  NOT NULL,
     -- Raw command Plist
     command      MEDIUMTEXT   NOT NULL,
+    -- Commands are not sent once expired
+    expires_at   TIMESTAMP    NULL,
+    -- Commands are not sent before they are scheduled
+    not_before   TIMESTAMP    NULL,
 
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
 
     PRIMARY KEY (command_uuid),
 
+    INDEX (not_before),
+
     CHECK (command_uuid != ''),
     CHECK (request_type != ''),
     CHECK (SUBSTRING(command FROM 1 FOR 5) = '<?xml')
@@ -186,7 +196,11 @@ CREATE TABLE command_results (
     -- capture results in the case they're malformed.
     CHECK (status != ''),
     INDEX (status),
-    CHECK (SUBSTRING(result FROM 1 FOR 5) = '<?xml')
+    CHECK (SUBSTRING(result FROM 1 FOR 5) = '<?xml'),
+
+    -- covering index for finding queued commands with (or without) a
+    -- result without reading the (large) result rows themselves.
+    INDEX idx_results_status (id, command_uuid, status)
 );
 
 
@@ -204,6 +218,10 @@ CREATE TABLE enrollment_queue (
 
     INDEX (priority DESC, created_at),
 
+    -- covering index for retrieving an enrollment's next command in
+    -- queue order without sorting its entire queue history.
+    INDEX idx_queue_next (id, active, priority DESC, created_at),
+
     FOREIGN KEY (id)
         REFERENCES enrollments (id)
         ON DELETE CASCADE ON UPDATE CASCADE,
@@ -280,3 +298,357 @@ CREATE TABLE cert_auth_associations (
     CHECK (id != ''),
     CHECK (sha256 != '')
 );
+
+
+/* Tracks which enrollments have issued DeclarativeManagement check-ins
+ * (i.e. have activated Declarative Device Management) and the last
+ * declarations sync token sent to them.
+ */
+CREATE TABLE dm_enablements (
+    id VARCHAR(255) NOT NULL,
+
+    last_endpoint   VARCHAR(255) NOT NULL,
+    last_checkin_at TIMESTAMP    NOT NULL,
+
+    declarations_token    VARCHAR(255) NULL,
+    declarations_token_at TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (declarations_token IS NULL OR declarations_token != '')
+);
+
+
+/* Operator-supplied enrollment metadata. Note there is no foreign key
+ * to the enrollments table so that metadata may be assigned before an
+ * enrollment exists.
+ */
+CREATE TABLE enrollment_metadata (
+    id VARCHAR(255) NOT NULL,
+
+    tenant      VARCHAR(255) NULL,
+    tags        JSON         NULL,
+    group_names JSON         NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    CHECK (id != ''),
+    CHECK (tenant IS NULL OR tenant != '')
+);
+
+
+/* Bulk enqueue and push operations tracked as jobs. Note there is no
+ * foreign key to the commands table as commands are deleted when
+ * complete and push-only jobs have no command.
+ */
+CREATE TABLE jobs (
+    id VARCHAR(127) NOT NULL,
+
+    name         VARCHAR(255) NULL,
+    command_uuid VARCHAR(127) NULL,
+    request_type VARCHAR(63)  NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    INDEX (command_uuid),
+
+    CHECK (id != ''),
+    CHECK (command_uuid IS NULL OR command_uuid != '')
+);
+
+CREATE TABLE job_targets (
+    job_id VARCHAR(127) NOT NULL,
+    id     VARCHAR(255) NOT NULL,
+
+    status VARCHAR(15) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (job_id, id),
+
+    FOREIGN KEY (job_id)
+        REFERENCES jobs (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    INDEX (id),
+
+    CHECK (status IN ('queued', 'delivered', 'acknowledged', 'errored'))
+);
+
+CREATE TABLE event_log (
+    seq BIGINT NOT NULL AUTO_INCREMENT,
+
+    topic         VARCHAR(63)  NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    command_uuid  VARCHAR(127) NULL,
+    status        VARCHAR(31)  NULL,
+    raw_payload   MEDIUMBLOB   NULL,
+    cert_hash     CHAR(64)     NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (seq),
+
+    CHECK (topic != '')
+);
+
+CREATE TABLE enrollment_aliases (
+    alias VARCHAR(255) NOT NULL,
+    id    VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (alias),
+
+    FOREIGN KEY (id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (alias != '')
+);
+
+CREATE TABLE user_sessions (
+    device_id VARCHAR(255) NOT NULL,
+
+    enrollment_id   VARCHAR(255) NOT NULL,
+    user_short_name VARCHAR(255) NULL,
+    user_long_name  VARCHAR(255) NULL,
+    started_at      TIMESTAMP    NOT NULL,
+    last_seen_at    TIMESTAMP    NOT NULL,
+
+    prev_enrollment_id   VARCHAR(255) NULL,
+    prev_user_short_name VARCHAR(255) NULL,
+    prev_user_long_name  VARCHAR(255) NULL,
+    prev_started_at      TIMESTAMP    NULL,
+    prev_last_seen_at    TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (device_id),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE metrics_rollups (
+    period   VARCHAR(7) NOT NULL,
+    start_at TIMESTAMP  NOT NULL,
+
+    checkins BIGINT NOT NULL DEFAULT 0,
+    commands BIGINT NOT NULL DEFAULT 0,
+    errors   BIGINT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (period, start_at),
+
+    CHECK (period IN ('hour', 'day'))
+);
+
+CREATE TABLE api_keys (
+    name        VARCHAR(255) NOT NULL,
+    role        VARCHAR(255) NOT NULL,
+    tenant      VARCHAR(255) NULL,
+    secret_hash CHAR(64)     NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name)
+);
+
+CREATE TABLE pending_commands (
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    targets      TEXT         NOT NULL,
+    no_push      BOOLEAN      NOT NULL DEFAULT FALSE,
+    requested_by VARCHAR(255) NULL,
+    command      MEDIUMTEXT   NOT NULL,
+    expires_at   TIMESTAMP    NULL,
+    not_before   TIMESTAMP    NULL,
+    priority     TINYINT      NOT NULL DEFAULT 0,
+    template     VARCHAR(255) NULL,
+    locale       VARCHAR(35)  NULL,
+    owner        VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
+CREATE TABLE enrollment_freezes (
+    id        VARCHAR(255) NOT NULL,
+    reason    TEXT         NULL,
+    frozen_by VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_evictions (
+    id           VARCHAR(255) NOT NULL,
+    reason       TEXT         NULL,
+    evicted_by   VARCHAR(255) NULL,
+    delivered_at TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_tombstones (
+    id            VARCHAR(255) NOT NULL,
+    serial_number VARCHAR(127) NULL,
+    type          VARCHAR(31)  NULL,
+    reason        TEXT         NULL,
+    departed_by   VARCHAR(255) NULL,
+    enrolled_at   TIMESTAMP    NULL,
+    last_seen_at  TIMESTAMP    NULL,
+
+    departed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    INDEX (serial_number)
+);
+
+CREATE TABLE webhook_deliveries (
+    id      VARCHAR(127) NOT NULL,
+    url     TEXT         NOT NULL,
+    topic   VARCHAR(255) NOT NULL,
+    version INTEGER      NOT NULL,
+    body    MEDIUMTEXT   NOT NULL,
+
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    last_error      TEXT      NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    dead            BOOLEAN   NOT NULL DEFAULT 0,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    INDEX (dead, next_attempt_at)
+);
+
+CREATE TABLE message_templates (
+    name         VARCHAR(255) NOT NULL,
+    group_name   VARCHAR(255) NOT NULL DEFAULT '',
+    locale       VARCHAR(35)  NOT NULL DEFAULT '',
+    message      TEXT         NULL,
+    phone_number VARCHAR(63)  NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name, group_name, locale)
+);
+
+CREATE TABLE declarations (
+    identifier   VARCHAR(255) NOT NULL,
+    type         VARCHAR(255) NOT NULL,
+    payload      MEDIUMTEXT   NOT NULL,
+    server_token VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE declaration_sets (
+    set_name               VARCHAR(255) NOT NULL,
+    declaration_identifier VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (set_name, declaration_identifier),
+
+    FOREIGN KEY (declaration_identifier)
+        REFERENCES declarations (identifier)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE enrollment_declaration_sets (
+    id       VARCHAR(255) NOT NULL,
+    set_name VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, set_name)
+);
+
+CREATE TABLE unlock_token_requests (
+    id            VARCHAR(127) NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    reason        TEXT         NULL,
+    requested_by  VARCHAR(255) NULL,
+    approved_by   VARCHAR(255) NULL,
+    approved_at   TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE ddm_status_reports (
+    id              VARCHAR(255) NOT NULL,
+    report          MEDIUMTEXT   NOT NULL,
+    reported_at     TIMESTAMP    NOT NULL,
+    declarations    MEDIUMTEXT   NULL,
+    declarations_at TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE inventory_snapshots (
+    id       VARCHAR(255) NOT NULL,
+    source   VARCHAR(63)  NOT NULL,
+    snapshot MEDIUMTEXT   NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, source)
+);
+
+CREATE TABLE command_owners (
+    command_uuid VARCHAR(127) NOT NULL,
+    owner        VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
+CREATE TABLE enrollment_supersessions (
+    id            VARCHAR(255) NOT NULL,
+    superseded_by VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
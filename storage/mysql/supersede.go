@@ -0,0 +1,59 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreEnrollmentSupersession(ctx context.Context, ss *storage.EnrollmentSupersession) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_supersessions
+    (id, superseded_by)
+VALUES
+    (?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    superseded_by = new.superseded_by,
+    created_at = CURRENT_TIMESTAMP;`,
+		ss.ID, ss.SupersededBy,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, superseded_by, UNIX_TIMESTAMP(created_at) FROM enrollment_supersessions`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentSupersession)
+	for rows.Next() {
+		ss := new(storage.EnrollmentSupersession)
+		var createdAt sql.NullInt64
+		if err := rows.Scan(&ss.ID, &ss.SupersededBy, &createdAt); err != nil {
+			return nil, err
+		}
+		if t := timeFromUnix(createdAt); t != nil {
+			ss.CreatedAt = t.UTC()
+		}
+		ret[ss.ID] = ss
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,67 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreMessageTemplate(ctx context.Context, t *storage.MessageTemplate) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO message_templates
+    (name, group_name, locale, message, phone_number)
+VALUES
+    (?, ?, ?, ?, ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    message = new.message,
+    phone_number = new.phone_number;`,
+		t.Name, t.Group, t.Locale, nullEmptyString(t.Message), nullEmptyString(t.PhoneNumber),
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveMessageTemplates(ctx context.Context, name string) ([]*storage.MessageTemplate, error) {
+	var where string
+	var args []interface{}
+	if name != "" {
+		where = ` WHERE name = ?`
+		args = append(args, name)
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, group_name, locale, message, phone_number, UNIX_TIMESTAMP(updated_at) FROM message_templates`+where+` ORDER BY name, group_name, locale;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var templates []*storage.MessageTemplate
+	for rows.Next() {
+		t := new(storage.MessageTemplate)
+		var message, phoneNumber sql.NullString
+		var updatedAt sql.NullInt64
+		if err := rows.Scan(&t.Name, &t.Group, &t.Locale, &message, &phoneNumber, &updatedAt); err != nil {
+			return nil, err
+		}
+		t.Message, t.PhoneNumber = message.String, phoneNumber.String
+		if ut := timeFromUnix(updatedAt); ut != nil {
+			t.UpdatedAt = ut.UTC()
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+func (s *MySQLStorage) DeleteMessageTemplate(ctx context.Context, name, group, locale string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM message_templates WHERE name = ? AND group_name = ? AND locale = ?;`,
+		name, group, locale,
+	)
+	return err
+}
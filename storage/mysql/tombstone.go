@@ -0,0 +1,169 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// tombstonePurgeTables are the tables keyed by enrollment ID without a
+// foreign key to the devices table. Their rows of departed enrollments
+// are purged explicitly.
+var tombstonePurgeTables = []string{
+	"cert_auth_associations",
+	"enrollment_metadata",
+	"inventory_snapshots",
+	"enrollment_freezes",
+	"enrollment_evictions",
+	"enrollment_supersessions",
+	"enrollment_declaration_sets",
+}
+
+// departEnrollment stores the tombstone of id in tx and purges id and
+// its user channel enrollments. It reports whether id is a device.
+func departEnrollment(ctx context.Context, tx *sql.Tx, tombstone *storage.EnrollmentTombstone) (bool, error) {
+	var serial, enrollType sql.NullString
+	var enrolledAt, lastSeenAt sql.NullInt64
+	err := tx.QueryRowContext(
+		ctx,
+		`
+SELECT
+    d.serial_number,
+    e.type,
+    UNIX_TIMESTAMP(d.authenticate_at),
+    UNIX_TIMESTAMP(e.last_seen_at)
+FROM
+    devices AS d
+    LEFT JOIN enrollments AS e
+        ON e.id = d.id
+WHERE
+    d.id = ?;`,
+		tombstone.ID,
+	).Scan(&serial, &enrollType, &enrolledAt, &lastSeenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("selecting device: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM enrollments WHERE device_id = ?;`, tombstone.ID)
+	if err != nil {
+		return false, fmt.Errorf("selecting enrollments: %w", err)
+	}
+	ids := []interface{}{tombstone.ID}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if id != tombstone.ID {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_tombstones
+    (id, serial_number, type, reason, departed_by, enrolled_at, last_seen_at)
+VALUES
+    (?, ?, ?, ?, ?, FROM_UNIXTIME(?), FROM_UNIXTIME(?)) AS new
+ON DUPLICATE KEY
+UPDATE
+    serial_number = new.serial_number,
+    type = new.type,
+    reason = new.reason,
+    departed_by = new.departed_by,
+    enrolled_at = new.enrolled_at,
+    last_seen_at = new.last_seen_at,
+    departed_at = CURRENT_TIMESTAMP;`,
+		tombstone.ID, serial, enrollType,
+		nullEmptyString(tombstone.Reason), nullEmptyString(tombstone.DepartedBy),
+		enrolledAt, lastSeenAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("inserting tombstone: %w", err)
+	}
+	// the users, enrollments, queues, results, and so on cascade
+	if _, err = tx.ExecContext(ctx, `DELETE FROM devices WHERE id = ?;`, tombstone.ID); err != nil {
+		return false, fmt.Errorf("deleting device: %w", err)
+	}
+	in := `id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+	for _, table := range tombstonePurgeTables {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+in+`;`, ids...); err != nil {
+			return false, fmt.Errorf("deleting from %s: %w", table, err)
+		}
+	}
+	return true, nil
+}
+
+func (s *MySQLStorage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	departed, err := departEnrollment(ctx, tx, tombstone)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil || !departed {
+		return nil, err
+	}
+	tombstones, err := s.RetrieveEnrollmentTombstones(ctx, []string{tombstone.ID})
+	if err != nil {
+		return nil, err
+	}
+	return tombstones[tombstone.ID], nil
+}
+
+func (s *MySQLStorage) RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (?` + strings.Repeat(", ?", len(ids)-1) + `)`
+		for i, v := range ids {
+			args[i] = v
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, serial_number, type, reason, departed_by, UNIX_TIMESTAMP(enrolled_at), UNIX_TIMESTAMP(last_seen_at), UNIX_TIMESTAMP(departed_at) FROM enrollment_tombstones`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentTombstone)
+	for rows.Next() {
+		t := new(storage.EnrollmentTombstone)
+		var serial, enrollType, reason, departedBy sql.NullString
+		var enrolledAt, lastSeenAt, departedAt sql.NullInt64
+		if err := rows.Scan(&t.ID, &serial, &enrollType, &reason, &departedBy, &enrolledAt, &lastSeenAt, &departedAt); err != nil {
+			return nil, err
+		}
+		t.SerialNumber, t.Type = serial.String, enrollType.String
+		t.Reason, t.DepartedBy = reason.String, departedBy.String
+		t.EnrolledAt, t.LastSeenAt = timeFromUnix(enrolledAt), timeFromUnix(lastSeenAt)
+		if d := timeFromUnix(departedAt); d != nil {
+			t.DepartedAt = d.UTC()
+		}
+		ret[t.ID] = t
+	}
+	return ret, rows.Err()
+}
+
+func (s *MySQLStorage) DeleteEnrollmentTombstone(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_tombstones WHERE id = ?;`, id)
+	return err
+}
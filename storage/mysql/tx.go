@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// execer executes queries on the database or in a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type txKey struct{}
+
+// txValue is a transaction in progress on db.
+type txValue struct {
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// txFromContext returns the transaction of ctx started on this
+// storage's database by InTransaction, if any.
+func (s *MySQLStorage) txFromContext(ctx context.Context) *sql.Tx {
+	if v, ok := ctx.Value(txKey{}).(*txValue); ok && v.db == s.db {
+		return v.tx
+	}
+	return nil
+}
+
+// execer returns the transaction of ctx if any or the database.
+func (s *MySQLStorage) execer(ctx context.Context) execer {
+	if tx := s.txFromContext(ctx); tx != nil {
+		return tx
+	}
+	return s.db
+}
+
+// InTransaction calls f with a context in which the check-in writes of
+// the storage (StoreAuthenticate, StoreTokenUpdate, ClearQueue, and
+// Disable) are made in a single transaction. The transaction is rolled
+// back if f returns an error or ctx is done before it is committed.
+func (s *MySQLStorage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	if s.txFromContext(ctx) != nil {
+		// join the transaction in progress
+		return f(ctx)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = f(context.WithValue(ctx, txKey{}, &txValue{db: s.db, tx: tx})); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,46 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestInTransaction(t *testing.T) {
+	testDSN := os.Getenv("NANOMDM_MYSQL_STORAGE_TEST_DSN")
+	if testDSN == "" {
+		t.Skip("NANOMDM_MYSQL_STORAGE_TEST_DSN not set")
+	}
+
+	storage, err := New(WithDSN(testDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := enrollTestDevice(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed transaction should not disable the enrollment
+	errTest := errors.New("test error")
+	err = storage.InTransaction(context.Background(), func(ctx context.Context) error {
+		r := d.newMdmReq()
+		r.Context = ctx
+		if err := storage.DisableWithReason(r, "test"); err != nil {
+			return err
+		}
+		return errTest
+	})
+	if !errors.Is(err, errTest) {
+		t.Fatalf("have %v; want %v", err, errTest)
+	}
+	tally, err := storage.RetrieveTokenUpdateTally(context.Background(), d.UDID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tally < 1 {
+		t.Errorf("enrollment disabled by rolled back transaction: tally: %d", tally)
+	}
+}
@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreUserSession(r *mdm.Request, userShortName, userLongName string) error {
+	if r.ParentID == "" {
+		return errors.New("can only store a user session for a user channel")
+	}
+	// note: assignments are evaluated in order so the previous session
+	// columns must be assigned before the current session columns.
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO user_sessions
+    (device_id, enrollment_id, user_short_name, user_long_name, started_at, last_seen_at)
+VALUES
+    (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP) AS new
+ON DUPLICATE KEY
+UPDATE
+    prev_enrollment_id = IF(enrollment_id = new.enrollment_id, prev_enrollment_id, enrollment_id),
+    prev_user_short_name = IF(enrollment_id = new.enrollment_id, prev_user_short_name, user_short_name),
+    prev_user_long_name = IF(enrollment_id = new.enrollment_id, prev_user_long_name, user_long_name),
+    prev_started_at = IF(enrollment_id = new.enrollment_id, prev_started_at, started_at),
+    prev_last_seen_at = IF(enrollment_id = new.enrollment_id, prev_last_seen_at, last_seen_at),
+    started_at = IF(enrollment_id = new.enrollment_id, started_at, new.started_at),
+    user_short_name = new.user_short_name,
+    user_long_name = new.user_long_name,
+    last_seen_at = new.last_seen_at,
+    enrollment_id = new.enrollment_id;`,
+		r.ParentID, r.ID, nullEmptyString(userShortName), nullEmptyString(userLongName),
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveUserSessions(ctx context.Context, deviceIDs []string) (map[string]*storage.UserSessions, error) {
+	if len(deviceIDs) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	args := make([]interface{}, len(deviceIDs))
+	for i, id := range deviceIDs {
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    device_id,
+    enrollment_id, user_short_name, user_long_name, UNIX_TIMESTAMP(started_at), UNIX_TIMESTAMP(last_seen_at),
+    prev_enrollment_id, prev_user_short_name, prev_user_long_name, UNIX_TIMESTAMP(prev_started_at), UNIX_TIMESTAMP(prev_last_seen_at)
+FROM user_sessions
+WHERE device_id IN (?`+strings.Repeat(", ?", len(deviceIDs)-1)+`);`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.UserSessions)
+	for rows.Next() {
+		var deviceID, enrollmentID string
+		var shortName, longName, prevID, prevShortName, prevLongName sql.NullString
+		var startedAt, lastSeenAt, prevStartedAt, prevLastSeenAt sql.NullInt64
+		if err := rows.Scan(
+			&deviceID,
+			&enrollmentID, &shortName, &longName, &startedAt, &lastSeenAt,
+			&prevID, &prevShortName, &prevLongName, &prevStartedAt, &prevLastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		sessions := &storage.UserSessions{Current: &storage.UserSession{
+			EnrollmentID:  enrollmentID,
+			UserShortName: shortName.String,
+			UserLongName:  longName.String,
+		}}
+		if t := timeFromUnix(startedAt); t != nil {
+			sessions.Current.StartedAt = *t
+		}
+		if t := timeFromUnix(lastSeenAt); t != nil {
+			sessions.Current.LastSeenAt = *t
+		}
+		if prevID.Valid {
+			sessions.Previous = &storage.UserSession{
+				EnrollmentID:  prevID.String,
+				UserShortName: prevShortName.String,
+				UserLongName:  prevLongName.String,
+			}
+			if t := timeFromUnix(prevStartedAt); t != nil {
+				sessions.Previous.StartedAt = *t
+			}
+			if t := timeFromUnix(prevLastSeenAt); t != nil {
+				sessions.Previous.LastSeenAt = *t
+			}
+		}
+		ret[deviceID] = sessions
+	}
+	return ret, rows.Err()
+}
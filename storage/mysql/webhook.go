@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *MySQLStorage) StoreWebhookDelivery(ctx context.Context, d *storage.WebhookDelivery) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO webhook_deliveries
+    (id, url, topic, version, body, attempts, last_error, next_attempt_at, dead)
+VALUES
+    (?, ?, ?, ?, ?, ?, ?, FROM_UNIXTIME(?), ?) AS new
+ON DUPLICATE KEY
+UPDATE
+    url = new.url,
+    topic = new.topic,
+    version = new.version,
+    body = new.body,
+    attempts = new.attempts,
+    last_error = new.last_error,
+    next_attempt_at = new.next_attempt_at,
+    dead = new.dead;`,
+		d.ID, d.URL, d.Topic, d.Version, string(d.Body),
+		d.Attempts, nullEmptyString(d.LastError), d.NextAttemptAt.Unix(), d.Dead,
+	)
+	return err
+}
+
+func (s *MySQLStorage) RetrieveWebhookDeliveries(ctx context.Context, filter *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error) {
+	if filter == nil {
+		filter = &storage.WebhookDeliveryFilter{}
+	}
+	where := []string{`dead = ?`}
+	args := []interface{}{filter.Dead}
+	if len(filter.IDs) > 0 {
+		where = append(where, `id IN (?`+strings.Repeat(", ?", len(filter.IDs)-1)+`)`)
+		for _, id := range filter.IDs {
+			args = append(args, id)
+		}
+	}
+	if !filter.DueBy.IsZero() {
+		where = append(where, `next_attempt_at <= FROM_UNIXTIME(?)`)
+		args = append(args, filter.DueBy.Unix())
+	}
+	var limit string
+	if filter.Limit > 0 {
+		limit = ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, url, topic, version, body, attempts, last_error, UNIX_TIMESTAMP(next_attempt_at), dead, UNIX_TIMESTAMP(created_at) FROM webhook_deliveries WHERE `+strings.Join(where, " AND ")+` ORDER BY next_attempt_at, id`+limit+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []*storage.WebhookDelivery
+	for rows.Next() {
+		d := new(storage.WebhookDelivery)
+		var body string
+		var lastError sql.NullString
+		var nextAttemptAt, createdAt sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.URL, &d.Topic, &d.Version, &body, &d.Attempts, &lastError, &nextAttemptAt, &d.Dead, &createdAt); err != nil {
+			return nil, err
+		}
+		d.Body, d.LastError = []byte(body), lastError.String
+		if t := timeFromUnix(nextAttemptAt); t != nil {
+			d.NextAttemptAt = t.UTC()
+		}
+		if t := timeFromUnix(createdAt); t != nil {
+			d.CreatedAt = t.UTC()
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *MySQLStorage) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = ?;`, id)
+	return err
+}
@@ -0,0 +1,112 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// reportItem is a command report to be written in a batch.
+type reportItem struct {
+	id     string
+	result *mdm.CommandResults
+}
+
+// upsertReports upserts non-Idle command reports in one statement.
+// An INSERT ... ON CONFLICT can not affect the same row twice so
+// reports must be for distinct enrollment and command UUID pairs.
+func upsertReports(ctx context.Context, tx *sql.Tx, reports []*reportItem) error {
+	var values []string
+	var args []interface{}
+	for _, report := range reports {
+		n := len(args)
+		p := func(i int) string { return "$" + strconv.Itoa(n+i) }
+		values = append(values, `(`+p(1)+`, `+p(2)+`, `+p(3)+`, `+p(4)+`, `+
+			`CASE WHEN `+p(3)+` = 'NotNow' THEN CURRENT_TIMESTAMP END, `+
+			`CASE WHEN `+p(3)+` = 'NotNow' THEN 1 ELSE 0 END)`)
+		args = append(args, report.id, report.result.CommandUUID, report.result.Status, report.result.Raw)
+	}
+	// note that due to the "ON CONFLICT ON CONSTRAINT command_results_pkey" we don't UPDATE the
+	// not_now_at field. thus it will only represent the first NotNow.
+	_, err := tx.ExecContext(
+		ctx, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    `+strings.Join(values, ", ")+`
+ON CONFLICT ON CONSTRAINT command_results_pkey DO UPDATE
+SET
+    status = EXCLUDED.status,
+    result = EXCLUDED.result,
+    not_now_tally = command_results.not_now_tally + EXCLUDED.not_now_tally;`,
+		args...,
+	)
+	return err
+}
+
+// flushReports writes a batch of command reports in one transaction.
+// The last seen time of all reporting enrollments is updated and the
+// (non-Idle) results are upserted.
+func (s *PgSQLStorage) flushReports(ctx context.Context, items []interface{}) error {
+	var ids []string
+	seen := make(map[string]struct{})
+	// split reports into groups with distinct keys
+	var groups [][]*reportItem
+	var keys map[string]struct{}
+	for _, item := range items {
+		report, ok := item.(*reportItem)
+		if !ok {
+			return errors.New("invalid batch item")
+		}
+		if _, ok := seen[report.id]; !ok {
+			seen[report.id] = struct{}{}
+			ids = append(ids, report.id)
+		}
+		if report.result.Status == "Idle" {
+			continue
+		}
+		key := report.id + "\x00" + report.result.CommandUUID
+		if _, ok := keys[key]; ok || len(groups) < 1 {
+			groups = append(groups, nil)
+			keys = make(map[string]struct{})
+		}
+		keys[key] = struct{}{}
+		groups[len(groups)-1] = append(groups[len(groups)-1], report)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	idArgs := make([]interface{}, len(ids))
+	params := make([]string, len(ids))
+	for i, id := range ids {
+		idArgs[i] = id
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`UPDATE enrollments SET last_seen_at = CURRENT_TIMESTAMP WHERE id IN (`+strings.Join(params, ", ")+`);`,
+		idArgs...,
+	)
+	if err != nil {
+		err = fmt.Errorf("updating last seen: %w", err)
+	}
+	for _, group := range groups {
+		if err != nil {
+			break
+		}
+		err = upsertReports(ctx, tx, group)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,36 @@
+package pgsql
+
+import "github.com/micromdm/nanomdm/storage"
+
+// Capabilities reports the capabilities of the backend as configured
+// with its options. Queue locking requires WithSerializedQueue and
+// results are not retained with WithDeleteCommands.
+func (s *PgSQLStorage) Capabilities() storage.Capabilities {
+	return storage.Capabilities{
+		storage.CapabilityQueueLocking:      s.lockTimeout > 0,
+		storage.CapabilityResultRetention:   !s.rm,
+		storage.CapabilityMetadata:          true,
+		storage.CapabilityCommandExpiration: true,
+		storage.CapabilityCommandScheduling: true,
+		storage.CapabilityCommandPriority:   true,
+		storage.CapabilityEviction:          true,
+		storage.CapabilityTombstones:        true,
+		storage.CapabilityWebhookDeliveries: true,
+		storage.CapabilityMessageTemplates:  true,
+		storage.CapabilityDeclarations:      true,
+		storage.CapabilityUnlockTokens:      true,
+		storage.CapabilityDDMStatus:         true,
+		storage.CapabilityQueuedEnrollments: true,
+		storage.CapabilityQueuedCommands:    true,
+		storage.CapabilityUserSessions:      true,
+		storage.CapabilityJobs:              true,
+		storage.CapabilityEventLog:          true,
+		storage.CapabilityMetricsRollups:    true,
+		storage.CapabilityAPIKeys:           true,
+		storage.CapabilityPendingCommands:   true,
+		storage.CapabilityFreeze:            true,
+		storage.CapabilityInventory:         true,
+		storage.CapabilitySupersession:      true,
+		storage.CapabilityCommandOwners:     true,
+	}
+}
@@ -0,0 +1,75 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *PgSQLStorage) StoreDDMStatusReport(ctx context.Context, id string, report, declarations []byte) error {
+	cols := `(id, report, reported_at) VALUES ($1, $2, CURRENT_TIMESTAMP)`
+	update := ``
+	args := []interface{}{id, string(report)}
+	if len(declarations) > 0 {
+		cols = `(id, report, reported_at, declarations, declarations_at) VALUES ($1, $2, CURRENT_TIMESTAMP, $3, CURRENT_TIMESTAMP)`
+		update = `,
+    declarations = EXCLUDED.declarations,
+    declarations_at = EXCLUDED.declarations_at`
+		args = append(args, string(declarations))
+	}
+	_, err := s.db.ExecContext(
+		ctx, `
+INSERT INTO ddm_status_reports
+    `+cols+`
+ON CONFLICT ON CONSTRAINT ddm_status_reports_pkey DO UPDATE
+SET
+    report = EXCLUDED.report,
+    reported_at = EXCLUDED.reported_at`+update+`;`,
+		args...,
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveDDMStatusReports(ctx context.Context, ids []string) (map[string]*storage.DDMStatusReport, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, report, reported_at, declarations, declarations_at FROM ddm_status_reports`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.DDMStatusReport)
+	for rows.Next() {
+		var id, report string
+		var declarations sql.NullString
+		var declarationsAt sql.NullTime
+		r := new(storage.DDMStatusReport)
+		if err := rows.Scan(&id, &report, &r.ReportedAt, &declarations, &declarationsAt); err != nil {
+			return nil, err
+		}
+		r.Report = []byte(report)
+		if declarations.Valid {
+			r.Declarations = []byte(declarations.String)
+		}
+		if declarationsAt.Valid {
+			r.DeclarationsAt = &declarationsAt.Time
+		}
+		ret[id] = r
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,45 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+)
+
+func (s *PgSQLStorage) StoreInventory(ctx context.Context, id, source string, values map[string]string) error {
+	b, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO inventory_snapshots
+    (id, source, snapshot)
+VALUES
+    ($1, $2, $3)
+ON CONFLICT ON CONSTRAINT inventory_snapshots_pkey DO
+UPDATE
+SET
+    snapshot = EXCLUDED.snapshot;`,
+		id, source, string(b),
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveInventory(ctx context.Context, id, source string) (map[string]string, error) {
+	var snapshot string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT snapshot FROM inventory_snapshots WHERE id = $1 AND source = $2;`,
+		id, source,
+	).Scan(&snapshot)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	return values, json.Unmarshal([]byte(snapshot), &values)
+}
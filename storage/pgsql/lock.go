@@ -0,0 +1,58 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/micromdm/nanolib/log/ctxlog"
+)
+
+// queueLockKey returns the advisory lock key of the command queue of
+// enrollment id. Hash collisions only serialize unrelated enrollments.
+func queueLockKey(id string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("nanomdm.queue:" + id))
+	return int64(h.Sum64())
+}
+
+// discardConn closes conn and removes it from the connection pool
+// which releases any advisory locks held by its session.
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
+
+// LockQueue locks the command queue of enrollment id with a session
+// advisory lock if serialized queue operations are enabled (see
+// WithSerializedQueue). The lock is held by a dedicated connection
+// until unlocked.
+func (s *PgSQLStorage) LockQueue(ctx context.Context, id string) (func(), error) {
+	if s.lockTimeout <= 0 {
+		return func() {}, nil
+	}
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key := queueLockKey(id)
+	lockCtx, cancel := context.WithTimeout(ctx, s.lockTimeout)
+	defer cancel()
+	if _, err = conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1);`, key); err != nil {
+		// the lock may have been acquired before a cancellation
+		discardConn(conn)
+		return nil, fmt.Errorf("locking queue: %s: %w", id, err)
+	}
+	return func() {
+		// the request context may be done by now
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, key)
+		if err != nil {
+			ctxlog.Logger(ctx, s.logger).Info("msg", "releasing queue lock", "id", id, "err", err)
+			discardConn(conn)
+			return
+		}
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,37 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+func (s *PgSQLStorage) StoreCommandOwner(ctx context.Context, uuid, owner string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO command_owners
+    (command_uuid, owner)
+VALUES
+    ($1, $2)
+ON CONFLICT ON CONSTRAINT command_owners_pkey DO
+UPDATE
+SET
+    owner = EXCLUDED.owner;`,
+		uuid, owner,
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveCommandOwner(ctx context.Context, uuid string) (string, error) {
+	var owner string
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT owner FROM command_owners WHERE command_uuid = $1;`,
+		uuid,
+	).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return owner, err
+}
@@ -0,0 +1,119 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *PgSQLStorage) StorePendingCommand(ctx context.Context, cmd *storage.PendingCommand) error {
+	var expiresAt, notBefore interface{}
+	if cmd.ExpiresAt != nil {
+		expiresAt = cmd.ExpiresAt.UTC()
+	}
+	if cmd.NotBefore != nil {
+		notBefore = cmd.NotBefore.UTC()
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO pending_commands
+    (command_uuid, request_type, targets, no_push, requested_by, command, expires_at, not_before, priority, template, locale, owner)
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+ON CONFLICT ON CONSTRAINT pending_commands_pkey DO
+UPDATE
+SET
+    request_type = EXCLUDED.request_type,
+    targets = EXCLUDED.targets,
+    no_push = EXCLUDED.no_push,
+    requested_by = EXCLUDED.requested_by,
+    command = EXCLUDED.command,
+    expires_at = EXCLUDED.expires_at,
+    not_before = EXCLUDED.not_before,
+    priority = EXCLUDED.priority,
+    template = EXCLUDED.template,
+    locale = EXCLUDED.locale,
+    owner = EXCLUDED.owner,
+    created_at = CURRENT_TIMESTAMP;`,
+		cmd.CommandUUID, cmd.RequestType, strings.Join(cmd.Targets, ","), cmd.NoPush, nullEmptyString(cmd.RequestedBy), cmd.Command,
+		expiresAt, notBefore, cmd.Priority,
+		nullEmptyString(cmd.Template), nullEmptyString(cmd.Locale), nullEmptyString(cmd.Owner),
+	)
+	return err
+}
+
+// scanPendingCommand scans a pending command row.
+func scanPendingCommand(row interface{ Scan(...interface{}) error }) (*storage.PendingCommand, error) {
+	cmd := new(storage.PendingCommand)
+	var targets string
+	var requestedBy, template, locale, owner sql.NullString
+	var expiresAt, notBefore, createdAt sql.NullTime
+	if err := row.Scan(
+		&cmd.CommandUUID, &cmd.RequestType, &targets, &cmd.NoPush, &requestedBy, &cmd.Command,
+		&expiresAt, &notBefore, &cmd.Priority, &template, &locale, &owner, &createdAt,
+	); err != nil {
+		return nil, err
+	}
+	cmd.Targets = strings.Split(targets, ",")
+	cmd.RequestedBy = requestedBy.String
+	cmd.Template, cmd.Locale, cmd.Owner = template.String, locale.String, owner.String
+	if expiresAt.Valid {
+		expires := expiresAt.Time.UTC()
+		cmd.ExpiresAt = &expires
+	}
+	if notBefore.Valid {
+		before := notBefore.Time.UTC()
+		cmd.NotBefore = &before
+	}
+	if createdAt.Valid {
+		cmd.CreatedAt = createdAt.Time.UTC()
+	}
+	return cmd, nil
+}
+
+const pendingColumns = `command_uuid, request_type, targets, no_push, requested_by, command, expires_at, not_before, priority, template, locale, owner, created_at`
+
+func (s *PgSQLStorage) RetrievePendingCommand(ctx context.Context, uuid string) (*storage.PendingCommand, error) {
+	cmd, err := scanPendingCommand(s.db.QueryRowContext(
+		ctx,
+		`SELECT `+pendingColumns+` FROM pending_commands WHERE command_uuid = $1;`,
+		uuid,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return cmd, err
+}
+
+func (s *PgSQLStorage) RetrievePendingCommands(ctx context.Context) ([]*storage.PendingCommand, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT `+pendingColumns+` FROM pending_commands ORDER BY created_at, command_uuid;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cmds []*storage.PendingCommand
+	for rows.Next() {
+		cmd, err := scanPendingCommand(rows)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, rows.Err()
+}
+
+func (s *PgSQLStorage) DeletePendingCommand(ctx context.Context, uuid string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM pending_commands WHERE command_uuid = $1;`, uuid)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
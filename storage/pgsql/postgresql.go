@@ -7,9 +7,13 @@ import (
 	_ "embed"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/cryptoutil/keyprovider"
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+	"github.com/micromdm/nanomdm/storage/batch"
 
 	"github.com/micromdm/nanolib/log"
 	"github.com/micromdm/nanolib/log/ctxlog"
@@ -23,17 +27,28 @@ var Schema string
 var ErrNoCert = errors.New("no certificate in MDM Request")
 
 type PgSQLStorage struct {
-	logger log.Logger
-	db     *sql.DB
-	rm     bool
+	logger  log.Logger
+	db      *sql.DB
+	rm      bool
+	part    bool
+	batcher *batch.Batcher
+	kp      keyprovider.KeyProvider
+
+	// lockTimeout enables serialized queue operations
+	lockTimeout time.Duration
 }
 
 type config struct {
-	driver string
-	dsn    string
-	db     *sql.DB
-	logger log.Logger
-	rm     bool
+	driver      string
+	dsn         string
+	db          *sql.DB
+	logger      log.Logger
+	rm          bool
+	part        bool
+	batchWindow time.Duration
+	batchMax    int
+	kp          keyprovider.KeyProvider
+	lockTimeout time.Duration
 }
 
 type Option func(*config)
@@ -68,6 +83,45 @@ func WithDeleteCommands() Option {
 	}
 }
 
+// WithPartitionedTables configures the storage for the partitioned
+// command and result tables of schema.partitioned.sql.
+func WithPartitionedTables() Option {
+	return func(c *config) {
+		c.part = true
+	}
+}
+
+// WithReportBatching batches command report writes. Reports arriving
+// within window of each other (up to max reports) are written together
+// in a single transaction. This trades a little latency for many fewer
+// transactions under load. Batching is not used with partitioned tables.
+func WithReportBatching(window time.Duration, max int) Option {
+	return func(c *config) {
+		c.batchWindow = window
+		c.batchMax = max
+	}
+}
+
+// WithKeyProvider encrypts push certificate private keys at rest with
+// kp. Keys stored before encryption was enabled can still be read and
+// are encrypted with MigratePushCertKeys.
+func WithKeyProvider(kp keyprovider.KeyProvider) Option {
+	return func(c *config) {
+		c.kp = kp
+	}
+}
+
+// WithSerializedQueue serializes the command queue operations of each
+// enrollment with per-enrollment database locks (see LockQueue). This
+// trades latency (and a database connection per locked enrollment) for
+// strictly ordered, exactly-once command delivery to enrollments making
+// concurrent requests. Acquiring a lock fails after timeout.
+func WithSerializedQueue(timeout time.Duration) Option {
+	return func(c *config) {
+		c.lockTimeout = timeout
+	}
+}
+
 func New(opts ...Option) (*PgSQLStorage, error) {
 	cfg := &config{logger: log.NopLogger, driver: "postgres"}
 	for _, opt := range opts {
@@ -83,7 +137,11 @@ func New(opts ...Option) (*PgSQLStorage, error) {
 	if err = cfg.db.Ping(); err != nil {
 		return nil, err
 	}
-	return &PgSQLStorage{db: cfg.db, logger: cfg.logger, rm: cfg.rm}, nil
+	s := &PgSQLStorage{db: cfg.db, logger: cfg.logger, rm: cfg.rm, part: cfg.part, kp: cfg.kp, lockTimeout: cfg.lockTimeout}
+	if cfg.batchWindow > 0 && !cfg.part {
+		s.batcher = batch.New(cfg.batchWindow, cfg.batchMax, s.flushReports)
+	}
+	return s, nil
 }
 
 // nullEmptyString returns a NULL string if s is empty.
@@ -113,7 +171,10 @@ UPDATE SET
     authenticate_at = CURRENT_TIMESTAMP;`,
 		r.ID, nullEmptyString(string(pemCert)), nullEmptyString(msg.SerialNumber), msg.Raw,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	return s.storeEnrollmentAliases(r, storage.AuthenticateAliases(msg))
 }
 
 func (s *PgSQLStorage) storeDeviceTokenUpdate(r *mdm.Request, msg *mdm.TokenUpdate) error {
@@ -253,13 +314,19 @@ SET
 
 // Disable can be called for an Authenticate or CheckOut message
 func (s *PgSQLStorage) Disable(r *mdm.Request) error {
+	return s.DisableWithReason(r, "")
+}
+
+// DisableWithReason disables like Disable recording reason.
+func (s *PgSQLStorage) DisableWithReason(r *mdm.Request, reason string) error {
 	if r.ParentID != "" {
 		return errors.New("can only disable a device channel")
 	}
 	_, err := s.db.ExecContext(
 		r.Context,
-		`UPDATE enrollments SET enabled = FALSE, token_update_tally = 0, last_seen_at = CURRENT_TIMESTAMP WHERE device_id = $1 AND enabled = TRUE;`,
+		`UPDATE enrollments SET enabled = FALSE, token_update_tally = 0, last_seen_at = CURRENT_TIMESTAMP, disable_reason = $2, disabled_at = CURRENT_TIMESTAMP WHERE device_id = $1 AND enabled = TRUE;`,
 		r.ID,
+		nullEmptyString(reason),
 	)
 	return err
 }
@@ -7,18 +7,28 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
 )
 
 func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) error {
 	if len(ids) < 1 {
 		return errors.New("no id(s) supplied to queue command to")
 	}
+	var expiresAt interface{}
+	if !cmd.ExpiresAt.IsZero() {
+		expiresAt = cmd.ExpiresAt.UTC()
+	}
+	var notBefore interface{}
+	if !cmd.NotBefore.IsZero() {
+		notBefore = cmd.NotBefore.UTC()
+	}
 	_, err := tx.ExecContext(
 		ctx,
-		`INSERT INTO commands (command_uuid, request_type, command) VALUES ($1, $2, $3);`,
-		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw,
+		`INSERT INTO commands (command_uuid, request_type, command, expires_at, not_before) VALUES ($1, $2, $3, $4, $5);`,
+		cmd.CommandUUID, cmd.Command.RequestType, cmd.Raw, expiresAt, notBefore,
 	)
 	if err != nil {
 		return err
@@ -26,23 +36,26 @@ func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) er
 
 	var query strings.Builder
 
-	query.WriteString(`INSERT INTO enrollment_queue (id, command_uuid) VALUES `)
-	args := make([]interface{}, len(ids)*2)
+	query.WriteString(`INSERT INTO enrollment_queue (id, command_uuid, priority) VALUES `)
+	args := make([]interface{}, len(ids)*3)
 	for i, id := range ids {
 		if i > 0 {
 			query.WriteString(",")
 		}
-		ind := i * 2
+		ind := i * 3
 
-		//previous: query += fmt.Sprintf("($%d, $%d)", ind+1, ind+2)
+		//previous: query += fmt.Sprintf("($%d, $%d, $%d)", ind+1, ind+2, ind+3)
 		query.WriteString("($")
 		query.WriteString(strconv.Itoa(ind + 1))
 		query.WriteString(", $")
 		query.WriteString(strconv.Itoa(ind + 2))
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 3))
 		query.WriteString(")")
 
 		args[ind] = id
 		args[ind+1] = cmd.CommandUUID
+		args[ind+2] = cmd.Priority
 	}
 	query.WriteString(";")
 
@@ -116,6 +129,9 @@ func (s *PgSQLStorage) deleteCommandTx(r *mdm.Request, result *mdm.CommandResult
 }
 
 func (s *PgSQLStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	if s.batcher != nil && (!s.rm || result.Status == "NotNow" || result.Status == "Idle") {
+		return s.batcher.Submit(r.Context, &reportItem{id: r.ID, result: result})
+	}
 	if err := s.updateLastSeen(r); err != nil {
 		return err
 	}
@@ -125,6 +141,9 @@ func (s *PgSQLStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandRes
 	if s.rm && result.Status != "NotNow" {
 		return s.deleteCommandTx(r, result)
 	}
+	if s.part {
+		return s.storePartitionedCommandReport(r, result)
+	}
 	notNowConstants := "NULL, 0"
 	notNowBumpTallySQL := ""
 	// note that due to the "ON CONFLICT ON CONSTRAINT command_results_pkey" we don't UPDATE the
@@ -151,24 +170,117 @@ SET
 	return err
 }
 
-func (s *PgSQLStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
-	statusWhere := "status IS NULL"
-	if !skipNotNow {
-		statusWhere = `(` + statusWhere + ` OR status = 'NotNow')`
+// storePartitionedCommandReport stores a command report in the
+// partitioned command_results table. Its primary key includes the
+// partition key (created_at) so we can't upsert on (id, command_uuid).
+// Instead we try to update an existing result first.
+func (s *PgSQLStorage) storePartitionedCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	notNowBumpTallySQL := ""
+	if result.Status == "NotNow" {
+		notNowBumpTallySQL = `, not_now_tally = not_now_tally + 1`
 	}
-	command := new(mdm.Command)
-	err := s.db.QueryRowContext(
+	res, err := s.db.ExecContext(
 		r.Context,
-		`SELECT command_uuid, request_type, command FROM view_queue WHERE id = $1 AND active = TRUE AND `+statusWhere+` LIMIT 1;`,
+		`UPDATE command_results SET status = $3, result = $4, updated_at = CURRENT_TIMESTAMP`+notNowBumpTallySQL+` WHERE id = $1 AND command_uuid = $2;`,
 		r.ID,
-	).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw)
+		result.CommandUUID,
+		result.Status,
+		result.Raw,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows > 0 {
+		return nil
+	}
+	notNowConstants := "NULL, 0"
+	if result.Status == "NotNow" {
+		notNowConstants = "CURRENT_TIMESTAMP, 1"
+	}
+	_, err = s.db.ExecContext(
+		r.Context, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    ($1, $2, $3, $4, `+notNowConstants+`);`,
+		r.ID,
+		result.CommandUUID,
+		result.Status,
+		result.Raw,
+	)
+	return err
+}
+
+// MaintainPartitions creates the monthly partitions of the partitioned
+// command and result tables through monthsAhead months in the future
+// and drops the partitions older than retainMonths months (if greater
+// than zero). The names of the dropped partitions are returned.
+// See schema.partitioned.sql.
+func (s *PgSQLStorage) MaintainPartitions(ctx context.Context, monthsAhead, retainMonths int) ([]string, error) {
+	var retain sql.NullInt64
+	if retainMonths > 0 {
+		retain = sql.NullInt64{Int64: int64(retainMonths), Valid: true}
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT nanomdm_maintain_partitions($1, $2);`, monthsAhead, retain)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
 		return nil, err
 	}
-	return command, nil
+	defer rows.Close()
+	var dropped []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		dropped = append(dropped, name)
+	}
+	return dropped, rows.Err()
+}
+
+// RetrieveNextCommand retrieves the next queued command for r. Expired
+// commands are deactivated and skipped. Commands scheduled in the future
+// are skipped but stay queued.
+func (s *PgSQLStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	statusWhere := "v.status IS NULL"
+	if !skipNotNow {
+		statusWhere = `(` + statusWhere + ` OR v.status = 'NotNow')`
+	}
+	for {
+		command := new(mdm.Command)
+		var expiresAt sql.NullTime
+		err := s.db.QueryRowContext(
+			r.Context,
+			`SELECT v.command_uuid, v.request_type, v.command, c.expires_at, v.priority FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.id = $1 AND v.active = TRUE AND (c.not_before IS NULL OR c.not_before <= $2) AND `+statusWhere+` ORDER BY v.priority DESC, v.created_at LIMIT 1;`,
+			r.ID, time.Now().UTC(),
+		).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw, &expiresAt, &command.Priority)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if expiresAt.Valid {
+			command.ExpiresAt = expiresAt.Time.UTC()
+		}
+		if !command.Expired(time.Now()) {
+			return command, nil
+		}
+		if err = s.expireCommand(r.Context, r.ID, command.CommandUUID); err != nil {
+			return nil, fmt.Errorf("expiring command: %w", err)
+		}
+	}
+}
+
+// expireCommand deactivates the expired command uuid in the queue of id.
+func (s *PgSQLStorage) expireCommand(ctx context.Context, id, uuid string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = FALSE WHERE id = $1 AND command_uuid = $2;`,
+		id, uuid,
+	)
+	return err
 }
 
 func (s *PgSQLStorage) ClearQueue(r *mdm.Request) error {
@@ -197,3 +309,114 @@ WHERE
 		r.ID)
 	return err
 }
+
+// RetrieveQueue retrieves the queued commands of id in queue order.
+func (s *PgSQLStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(ctx, `v.id = $1`, id)
+}
+
+// RetrieveQueuedCommands retrieves the active, unexpired commands of id
+// without a result (or with NotNow) in queue order.
+func (s *PgSQLStorage) RetrieveQueuedCommands(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(
+		ctx,
+		`v.id = $1 AND v.active = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND (c.expires_at IS NULL OR c.expires_at > $2)`,
+		id, time.Now().UTC(),
+	)
+}
+
+// CancelQueuedCommand deactivates the command uuid in the queue of id
+// if it is active and has no result (or NotNow).
+func (s *PgSQLStorage) CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = FALSE WHERE id = $1 AND command_uuid = $2 AND active = TRUE AND NOT EXISTS (SELECT 1 FROM command_results AS r WHERE r.id = enrollment_queue.id AND r.command_uuid = enrollment_queue.command_uuid AND r.status != 'NotNow');`,
+		id, uuid,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// queryQueue retrieves the queued commands matching the where clause
+// in queue order.
+func (s *PgSQLStorage) queryQueue(ctx context.Context, where string, args ...interface{}) ([]*storage.QueuedCommand, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT v.command_uuid, v.request_type, v.active, v.status, v.command, v.result, v.priority, v.created_at, c.expires_at, c.not_before FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE `+where+` ORDER BY v.priority DESC, v.created_at;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	var cmds []*storage.QueuedCommand
+	for rows.Next() {
+		cmd := new(storage.QueuedCommand)
+		var status sql.NullString
+		var expiresAt, notBefore sql.NullTime
+		if err = rows.Scan(&cmd.CommandUUID, &cmd.RequestType, &cmd.Active, &status, &cmd.Command, &cmd.Result, &cmd.Priority, &cmd.CreatedAt, &expiresAt, &notBefore); err != nil {
+			return nil, err
+		}
+		cmd.Status = status.String
+		if expiresAt.Valid {
+			t := expiresAt.Time.UTC()
+			cmd.SetExpiry(&t, now)
+		}
+		if notBefore.Valid {
+			t := notBefore.Time.UTC()
+			cmd.NotBefore = &t
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, rows.Err()
+}
+
+// RetrieveScheduledEnrollments retrieves the IDs of enrollments with
+// active queued commands scheduled after after and at or before until.
+func (s *PgSQLStorage) RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT v.id FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.active = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND c.not_before > $1 AND c.not_before <= $2;`,
+		after.UTC(), until.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RetrieveQueuedEnrollments retrieves the IDs of enabled enrollments
+// with due, unexpired commands without a result (or with NotNow).
+func (s *PgSQLStorage) RetrieveQueuedEnrollments(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT v.id FROM view_queue AS v INNER JOIN enrollments AS e ON e.id = v.id INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.active = TRUE AND e.enabled = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND (c.not_before IS NULL OR c.not_before <= $1) AND (c.expires_at IS NULL OR c.expires_at > $1) ORDER BY v.id;`,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
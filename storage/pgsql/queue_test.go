@@ -9,13 +9,18 @@ import (
 	"flag"
 	"io/ioutil"
 	"testing"
+	"time"
 
 	_ "github.com/lib/pq"
 	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage/conformance"
 	"github.com/micromdm/nanomdm/storage/test"
 )
 
-var flDSN = flag.String("dsn", "", "DSN of test PostgreSQL instance")
+var (
+	flDSN            = flag.String("dsn", "", "DSN of test PostgreSQL instance")
+	flConformanceDSN = flag.String("conformance-dsn", "", "DSN of empty PostgreSQL database for conformance tests")
+)
 
 func loadAuthMsg() (*mdm.Authenticate, error) {
 	b, err := ioutil.ReadFile("../../mdm/testdata/Authenticate.2.plist")
@@ -109,3 +114,48 @@ func TestQueue(t *testing.T) {
 		test.TestQueue(t, deviceUDID, storage)
 	})
 }
+
+func TestQueueLocker(t *testing.T) {
+	if *flDSN == "" {
+		t.Fatal("PostgreSQL DSN flag not provided to test")
+	}
+
+	storage, err := New(WithDSN(*flDSN), WithSerializedQueue(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	test.TestQueueLocker(t, storage)
+}
+
+func TestSchema(t *testing.T) {
+	if *flDSN == "" {
+		t.Fatal("PostgreSQL DSN flag not provided to test")
+	}
+
+	storage, err := New(WithDSN(*flDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := storage.CheckSchema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = report.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	if *flConformanceDSN == "" {
+		t.Skip("PostgreSQL conformance DSN flag not provided to test")
+	}
+
+	storage, err := New(WithDSN(*flConformanceDSN))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conformance.Run(t, storage)
+}
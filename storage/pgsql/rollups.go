@@ -0,0 +1,53 @@
+package pgsql
+
+import (
+	"context"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *PgSQLStorage) AddMetricsRollup(ctx context.Context, rollup *storage.MetricsRollup) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO metrics_rollups
+    (period, start_at, checkins, commands, errors)
+VALUES
+    ($1, $2, $3, $4, $5)
+ON CONFLICT ON CONSTRAINT metrics_rollups_pkey DO
+UPDATE
+SET
+    checkins = metrics_rollups.checkins + EXCLUDED.checkins,
+    commands = metrics_rollups.commands + EXCLUDED.commands,
+    errors = metrics_rollups.errors + EXCLUDED.errors;`,
+		rollup.Period,
+		rollup.Start.UTC(),
+		rollup.Checkins,
+		rollup.Commands,
+		rollup.Errors,
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveMetricsRollups(ctx context.Context, period string, since time.Time) ([]*storage.MetricsRollup, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT start_at, checkins, commands, errors FROM metrics_rollups WHERE period = $1 AND start_at >= $2 ORDER BY start_at;`,
+		period, since.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rollups []*storage.MetricsRollup
+	for rows.Next() {
+		rollup := &storage.MetricsRollup{Period: period}
+		if err = rows.Scan(&rollup.Start, &rollup.Checkins, &rollup.Commands, &rollup.Errors); err != nil {
+			return nil, err
+		}
+		rollup.Start = rollup.Start.UTC()
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
@@ -0,0 +1,56 @@
+package pgsql
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage/sqlschema"
+)
+
+// functionRe matches the CREATE FUNCTION statements of Schema.
+var functionRe = regexp.MustCompile(`(?ms)^CREATE FUNCTION (\w+)\(.*?^\$\$ language '\w+';`)
+
+// schemaChecker returns a checker of the database against Schema.
+func (s *PgSQLStorage) schemaChecker() *sqlschema.Checker {
+	return sqlschema.New(
+		s.db,
+		sqlschema.Parse(Schema),
+		`SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = current_schema();`,
+		`SELECT tablename, indexname FROM pg_indexes WHERE schemaname = current_schema();`,
+	)
+}
+
+// CheckSchema checks the database for the tables, columns, and indexes
+// of Schema.
+func (s *PgSQLStorage) CheckSchema(ctx context.Context) (*sqlschema.Report, error) {
+	return s.schemaChecker().Check(ctx)
+}
+
+// MigrateSchema creates the tables and adds the columns and indexes of
+// Schema that are missing from the database where possible. See
+// sqlschema.Checker.Migrate.
+// The functions of Schema are (re)created first as the triggers of
+// created tables depend on them.
+func (s *PgSQLStorage) MigrateSchema(ctx context.Context) (*sqlschema.Report, error) {
+	for _, m := range functionRe.FindAllStringSubmatch(Schema, -1) {
+		stmt := strings.Replace(m[0], "CREATE FUNCTION", "CREATE OR REPLACE FUNCTION", 1)
+		if _, err := s.db.ExecContext(ctx, stmt); err != nil {
+			return nil, fmt.Errorf("creating function %s: %w", m[1], err)
+		}
+	}
+	return s.schemaChecker().Migrate(ctx)
+}
+
+// SchemaEmpty reports whether none of the tables of Schema exist in the
+// database.
+func (s *PgSQLStorage) SchemaEmpty(ctx context.Context) (bool, error) {
+	return s.schemaChecker().Empty(ctx)
+}
+
+// DropSchema drops the tables of Schema and all of their data from the
+// database. It is intended for tearing down test databases.
+func (s *PgSQLStorage) DropSchema(ctx context.Context) error {
+	return s.schemaChecker().Drop(ctx)
+}
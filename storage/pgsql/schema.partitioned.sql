@@ -0,0 +1,152 @@
+/* Optional time-based partitioning of the command and result tables.
+ * Requires PostgreSQL 11 or later.
+ *
+ * Apply this file after schema.sql to a new (empty) database. It
+ * replaces the commands and command_results tables with tables that are
+ * range partitioned by month on created_at. Old history can then be
+ * pruned by dropping whole partitions (see nanomdm_maintain_partitions
+ * below) instead of with large DELETEs.
+ *
+ * Partitioned tables require the partition key in every unique
+ * constraint. Therefore command UUIDs are only unique per creation
+ * time and the foreign keys to the commands table are dropped. Use the
+ * "partitioned=1" storage option so that NanoMDM does not rely on the
+ * (id, command_uuid) primary key of command_results for upserts.
+ */
+
+DROP VIEW view_queue;
+
+ALTER TABLE enrollment_queue DROP CONSTRAINT enrollment_queue_command_uuid_fkey;
+DROP TABLE command_results;
+DROP TABLE commands;
+
+CREATE TABLE commands
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    -- Raw command Plist
+    command      TEXT         NOT NULL,
+    -- Commands are not sent once expired
+    expires_at   TIMESTAMP    NULL,
+    -- Commands are not sent before they are scheduled
+    not_before   TIMESTAMP    NULL,
+
+    created_at   TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid, created_at),
+
+    CHECK (command_uuid != ''),
+    CHECK (request_type != ''),
+    CHECK (SUBSTRING(command FROM 1 FOR 5) = '<?xml')
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX idx_commands_not_before ON commands (not_before);
+
+CREATE TABLE command_results
+(
+    id            VARCHAR(255) NOT NULL,
+    command_uuid  VARCHAR(127) NOT NULL,
+    status        VARCHAR(31)  NOT NULL,
+    result        TEXT         NOT NULL,
+
+    not_now_at    TIMESTAMP    NULL,
+    not_now_tally INTEGER      NOT NULL DEFAULT 0,
+
+    created_at    TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, command_uuid, created_at),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (status != ''),
+    CHECK (SUBSTRING(result FROM 1 FOR 5) = '<?xml')
+) PARTITION BY RANGE (created_at);
+CREATE INDEX idx_status ON command_results (status);
+
+CREATE INDEX idx_enrollment_queue_created_at ON enrollment_queue (created_at);
+
+CREATE OR REPLACE VIEW view_queue AS
+SELECT q.id,
+       q.created_at,
+       q.active,
+       q.priority,
+       c.command_uuid,
+       c.request_type,
+       c.command,
+       r.updated_at AS result_updated_at,
+       r.status,
+       r.result
+FROM enrollment_queue AS q
+
+         INNER JOIN commands AS c
+                    ON q.command_uuid = c.command_uuid
+
+         LEFT JOIN command_results r
+                   ON r.command_uuid = q.command_uuid AND r.id = q.id
+ORDER BY q.priority DESC,
+         q.created_at;
+
+/* nanomdm_maintain_partitions creates the monthly partitions of the
+ * commands and command_results tables from the current month through
+ * months_ahead months in the future. Partitions entirely older than
+ * retain_months months ago are dropped along with queue items older
+ * than that. A NULL retain_months drops nothing. Returns the names of
+ * the dropped partitions.
+ *
+ * Call it periodically (e.g. daily from cron or pg_cron):
+ *
+ *     SELECT nanomdm_maintain_partitions(3, 6);
+ */
+CREATE OR REPLACE FUNCTION nanomdm_maintain_partitions(months_ahead INTEGER, retain_months INTEGER)
+    RETURNS SETOF TEXT
+    LANGUAGE plpgsql
+AS
+$$
+DECLARE
+    tbl    TEXT;
+    month  DATE;
+    cutoff DATE;
+    part   RECORD;
+BEGIN
+    FOREACH tbl IN ARRAY ARRAY ['commands', 'command_results']
+        LOOP
+            FOR i IN 0..months_ahead
+                LOOP
+                    month := date_trunc('month', CURRENT_DATE)::DATE + make_interval(months => i);
+                    EXECUTE format(
+                            'CREATE TABLE IF NOT EXISTS %I PARTITION OF %I FOR VALUES FROM (%L) TO (%L)',
+                            tbl || '_p' || to_char(month, 'YYYYMM'),
+                            tbl,
+                            month,
+                            month + INTERVAL '1 month'
+                        );
+                END LOOP;
+        END LOOP;
+
+    IF retain_months IS NULL THEN
+        RETURN;
+    END IF;
+    cutoff := date_trunc('month', CURRENT_DATE)::DATE - make_interval(months => retain_months);
+
+    FOR part IN
+        SELECT c.relname AS name
+        FROM pg_inherits AS i
+                 INNER JOIN pg_class AS c ON i.inhrelid = c.oid
+                 INNER JOIN pg_class AS p ON i.inhparent = p.oid
+        WHERE p.relname IN ('commands', 'command_results')
+          AND c.relname ~ '_p[0-9]{6}$'
+          AND to_date(right(c.relname, 6), 'YYYYMM') + INTERVAL '1 month' <= cutoff
+        LOOP
+            EXECUTE format('DROP TABLE %I', part.name);
+            RETURN NEXT part.name;
+        END LOOP;
+
+    DELETE FROM enrollment_queue WHERE created_at < cutoff;
+END;
+$$;
+
+SELECT nanomdm_maintain_partitions(3, NULL);
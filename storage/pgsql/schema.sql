@@ -101,6 +101,10 @@ CREATE TABLE enrollments
     enabled            BOOLEAN      NOT NULL DEFAULT TRUE,
     token_update_tally INTEGER      NOT NULL DEFAULT 1,
 
+    -- Why and when the enrollment was last disabled, if ever.
+    disable_reason     VARCHAR(31)  NULL,
+    disabled_at        TIMESTAMP    NULL,
+
     last_seen_at       TIMESTAMP    NOT NULL, -- TODO: additional tests with real device and integration tests.
 
     created_at         TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
@@ -135,6 +139,10 @@ CREATE TABLE commands
     request_type VARCHAR(63)  NOT NULL,
     -- Raw command Plist
     command      TEXT         NOT NULL,
+    -- Commands are not sent once expired
+    expires_at   TIMESTAMP    NULL,
+    -- Commands are not sent before they are scheduled
+    not_before   TIMESTAMP    NULL,
 
     created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
@@ -146,6 +154,8 @@ CREATE TABLE commands
     CHECK (SUBSTRING(command FROM 1 FOR 5) = '<?xml')
 );
 
+CREATE INDEX idx_commands_not_before ON commands (not_before);
+
 
 /* Results are enrollment responses to device commands.
  *
@@ -278,6 +288,386 @@ CREATE TABLE cert_auth_associations
     CHECK (sha256 != '')
 );
 
+
+CREATE TABLE dm_enablements
+(
+    id                    VARCHAR(255) NOT NULL,
+
+    last_endpoint         VARCHAR(255) NOT NULL,
+    last_checkin_at       TIMESTAMP    NOT NULL,
+
+    declarations_token    VARCHAR(255) NULL,
+    declarations_token_at TIMESTAMP    NULL,
+
+    created_at            TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at            TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (declarations_token IS NULL OR declarations_token != '')
+);
+
+
+/* Operator-supplied enrollment metadata. Note there is no foreign key
+ * to the enrollments table so that metadata may be assigned before an
+ * enrollment exists.
+ */
+CREATE TABLE enrollment_metadata
+(
+    id          VARCHAR(255) NOT NULL,
+
+    tenant      VARCHAR(255) NULL,
+    tags        JSONB        NULL,
+    group_names JSONB        NULL,
+
+    created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    CHECK (id != ''),
+    CHECK (tenant IS NULL OR tenant != '')
+);
+
+
+/* Bulk enqueue and push operations tracked as jobs. Note there is no
+ * foreign key to the commands table as commands are deleted when
+ * complete and push-only jobs have no command.
+ */
+CREATE TABLE jobs
+(
+    id           VARCHAR(127) NOT NULL,
+
+    name         VARCHAR(255) NULL,
+    command_uuid VARCHAR(127) NULL,
+    request_type VARCHAR(63)  NULL,
+
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    CHECK (id != ''),
+    CHECK (command_uuid IS NULL OR command_uuid != '')
+);
+
+CREATE INDEX idx_command_uuid ON jobs (command_uuid);
+
+CREATE TABLE job_targets
+(
+    job_id     VARCHAR(127) NOT NULL,
+    id         VARCHAR(255) NOT NULL,
+
+    status     VARCHAR(15)  NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (job_id, id),
+
+    FOREIGN KEY (job_id)
+        REFERENCES jobs (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (status IN ('queued', 'delivered', 'acknowledged', 'errored'))
+);
+
+CREATE INDEX idx_job_target_id ON job_targets (id);
+
+
+CREATE TABLE event_log
+(
+    seq           BIGSERIAL    NOT NULL,
+
+    topic         VARCHAR(63)  NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    command_uuid  VARCHAR(127) NULL,
+    status        VARCHAR(31)  NULL,
+    raw_payload   BYTEA        NULL,
+    cert_hash     CHAR(64)     NULL,
+
+    created_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (seq),
+
+    CHECK (topic != '')
+);
+
+
+/* Serial numbers, UDIDs, and EnrollmentIDs from Authenticate messages
+ * that resolve to a device enrollment ID.
+ */
+CREATE TABLE enrollment_aliases
+(
+    alias      VARCHAR(255) NOT NULL,
+    id         VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (alias),
+
+    FOREIGN KEY (id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (alias != '')
+);
+
+/* The current and previous user (channel) sessions of a device, such
+ * as the users of a Shared iPad.
+ */
+CREATE TABLE user_sessions
+(
+    device_id            VARCHAR(255) NOT NULL,
+
+    enrollment_id        VARCHAR(255) NOT NULL,
+    user_short_name      VARCHAR(255) NULL,
+    user_long_name       VARCHAR(255) NULL,
+    started_at           TIMESTAMP    NOT NULL,
+    last_seen_at         TIMESTAMP    NOT NULL,
+
+    prev_enrollment_id   VARCHAR(255) NULL,
+    prev_user_short_name VARCHAR(255) NULL,
+    prev_user_long_name  VARCHAR(255) NULL,
+    prev_started_at      TIMESTAMP    NULL,
+    prev_last_seen_at    TIMESTAMP    NULL,
+
+    created_at           TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at           TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (device_id),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE metrics_rollups
+(
+    period   VARCHAR(7) NOT NULL,
+    start_at TIMESTAMP  NOT NULL,
+
+    checkins BIGINT NOT NULL DEFAULT 0,
+    commands BIGINT NOT NULL DEFAULT 0,
+    errors   BIGINT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (period, start_at),
+
+    CHECK (period IN ('hour', 'day'))
+);
+
+CREATE TABLE api_keys
+(
+    name        VARCHAR(255) NOT NULL,
+    role        VARCHAR(255) NOT NULL,
+    tenant      VARCHAR(255) NULL,
+    secret_hash CHAR(64)     NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name)
+);
+
+CREATE TABLE pending_commands
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    targets      TEXT         NOT NULL,
+    no_push      BOOLEAN      NOT NULL DEFAULT FALSE,
+    requested_by VARCHAR(255) NULL,
+    command      TEXT         NOT NULL,
+    expires_at   TIMESTAMP    NULL,
+    not_before   TIMESTAMP    NULL,
+    priority     SMALLINT     NOT NULL DEFAULT 0,
+    template     VARCHAR(255) NULL,
+    locale       VARCHAR(35)  NULL,
+    owner        VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
+CREATE TABLE enrollment_supersessions
+(
+    id            VARCHAR(255) NOT NULL,
+    superseded_by VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_freezes
+(
+    id        VARCHAR(255) NOT NULL,
+    reason    TEXT         NULL,
+    frozen_by VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_evictions
+(
+    id           VARCHAR(255) NOT NULL,
+    reason       TEXT         NULL,
+    evicted_by   VARCHAR(255) NULL,
+    delivered_at TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_tombstones
+(
+    id            VARCHAR(255) NOT NULL,
+    serial_number VARCHAR(127) NULL,
+    type          VARCHAR(31)  NULL,
+    reason        TEXT         NULL,
+    departed_by   VARCHAR(255) NULL,
+    enrolled_at   TIMESTAMP    NULL,
+    last_seen_at  TIMESTAMP    NULL,
+
+    departed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_tombstone_serial_number ON enrollment_tombstones (serial_number);
+
+CREATE TABLE webhook_deliveries
+(
+    id      VARCHAR(127) NOT NULL,
+    url     TEXT         NOT NULL,
+    topic   VARCHAR(255) NOT NULL,
+    version INTEGER      NOT NULL,
+    body    TEXT         NOT NULL,
+
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    last_error      TEXT      NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    dead            BOOLEAN   NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_webhook_delivery_due ON webhook_deliveries (dead, next_attempt_at);
+
+CREATE TABLE message_templates
+(
+    name         VARCHAR(255) NOT NULL,
+    group_name   VARCHAR(255) NOT NULL DEFAULT '',
+    locale       VARCHAR(35)  NOT NULL DEFAULT '',
+    message      TEXT         NULL,
+    phone_number VARCHAR(63)  NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name, group_name, locale)
+);
+
+CREATE TABLE declarations
+(
+    identifier   VARCHAR(255) NOT NULL,
+    type         VARCHAR(255) NOT NULL,
+    payload      TEXT         NOT NULL,
+    server_token VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE declaration_sets
+(
+    set_name               VARCHAR(255) NOT NULL,
+    declaration_identifier VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (set_name, declaration_identifier),
+
+    FOREIGN KEY (declaration_identifier)
+        REFERENCES declarations (identifier)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX idx_declaration_sets_identifier ON declaration_sets (declaration_identifier);
+
+CREATE TABLE enrollment_declaration_sets
+(
+    id       VARCHAR(255) NOT NULL,
+    set_name VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, set_name)
+);
+
+CREATE TABLE unlock_token_requests
+(
+    id            VARCHAR(127) NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    reason        TEXT         NULL,
+    requested_by  VARCHAR(255) NULL,
+    approved_by   VARCHAR(255) NULL,
+    approved_at   TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE ddm_status_reports
+(
+    id              VARCHAR(255) NOT NULL,
+    report          TEXT         NOT NULL,
+    reported_at     TIMESTAMP    NOT NULL,
+    declarations    TEXT         NULL,
+    declarations_at TIMESTAMP    NULL,
+
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE inventory_snapshots
+(
+    id       VARCHAR(255) NOT NULL,
+    source   VARCHAR(63)  NOT NULL,
+    snapshot TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, source)
+);
+
+CREATE TABLE command_owners
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    owner        VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
 /* creating function to update current_timestamp, works with triggers to tables
    same as MySQL functionality:
    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP*/
@@ -315,3 +705,36 @@ CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON push_certs
 
 CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON cert_auth_associations
     FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON dm_enablements
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON enrollment_metadata
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON jobs
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON job_targets
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON enrollment_aliases
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON user_sessions
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON inventory_snapshots
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON command_owners
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON webhook_deliveries
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON message_templates
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
+
+CREATE TRIGGER update_at_to_current_timestamp BEFORE UPDATE ON declarations
+    FOR EACH ROW EXECUTE PROCEDURE update_current_timestamp();
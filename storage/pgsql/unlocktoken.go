@@ -0,0 +1,92 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *PgSQLStorage) RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error) {
+	var token []byte
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT unlock_token FROM devices WHERE id = $1;`,
+		id,
+	).Scan(&token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return token, err
+}
+
+func (s *PgSQLStorage) StoreUnlockTokenRequest(ctx context.Context, req *storage.UnlockTokenRequest) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO unlock_token_requests (id, enrollment_id, reason, requested_by) VALUES ($1, $2, $3, $4);`,
+		req.ID, req.EnrollmentID, nullEmptyString(req.Reason), nullEmptyString(req.RequestedBy),
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveUnlockTokenRequests(ctx context.Context, ids []string) ([]*storage.UnlockTokenRequest, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		where = ` WHERE id IN (` + placeholders(1, len(ids)) + `)`
+		for i, id := range ids {
+			args[i] = id
+		}
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, enrollment_id, reason, requested_by, approved_by, approved_at, created_at FROM unlock_token_requests`+where+` ORDER BY created_at, id;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var reqs []*storage.UnlockTokenRequest
+	for rows.Next() {
+		req := new(storage.UnlockTokenRequest)
+		var reason, requestedBy, approvedBy sql.NullString
+		var approvedAt, createdAt sql.NullTime
+		if err := rows.Scan(&req.ID, &req.EnrollmentID, &reason, &requestedBy, &approvedBy, &approvedAt, &createdAt); err != nil {
+			return nil, err
+		}
+		req.Reason, req.RequestedBy, req.ApprovedBy = reason.String, requestedBy.String, approvedBy.String
+		if approvedAt.Valid {
+			approved := approvedAt.Time.UTC()
+			req.ApprovedAt = &approved
+		}
+		if createdAt.Valid {
+			req.CreatedAt = createdAt.Time.UTC()
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, rows.Err()
+}
+
+func (s *PgSQLStorage) ApproveUnlockTokenRequest(ctx context.Context, id, approvedBy string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE unlock_token_requests SET approved_by = $1, approved_at = CURRENT_TIMESTAMP WHERE id = $2 AND approved_at IS NULL;`,
+		nullEmptyString(approvedBy), id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *PgSQLStorage) DeleteUnlockTokenRequest(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM unlock_token_requests WHERE id = $1;`, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
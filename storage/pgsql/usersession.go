@@ -0,0 +1,89 @@
+package pgsql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *PgSQLStorage) StoreUserSession(r *mdm.Request, userShortName, userLongName string) error {
+	if r.ParentID == "" {
+		return errors.New("can only store a user session for a user channel")
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO user_sessions
+    (device_id, enrollment_id, user_short_name, user_long_name, started_at, last_seen_at)
+VALUES
+    ($1, $2, $3, $4, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+ON CONFLICT ON CONSTRAINT user_sessions_pkey DO UPDATE
+SET
+    prev_enrollment_id = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.prev_enrollment_id ELSE user_sessions.enrollment_id END,
+    prev_user_short_name = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.prev_user_short_name ELSE user_sessions.user_short_name END,
+    prev_user_long_name = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.prev_user_long_name ELSE user_sessions.user_long_name END,
+    prev_started_at = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.prev_started_at ELSE user_sessions.started_at END,
+    prev_last_seen_at = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.prev_last_seen_at ELSE user_sessions.last_seen_at END,
+    started_at = CASE WHEN user_sessions.enrollment_id = EXCLUDED.enrollment_id THEN user_sessions.started_at ELSE EXCLUDED.started_at END,
+    enrollment_id = EXCLUDED.enrollment_id,
+    user_short_name = EXCLUDED.user_short_name,
+    user_long_name = EXCLUDED.user_long_name,
+    last_seen_at = EXCLUDED.last_seen_at;`,
+		r.ParentID, r.ID, nullEmptyString(userShortName), nullEmptyString(userLongName),
+	)
+	return err
+}
+
+func (s *PgSQLStorage) RetrieveUserSessions(ctx context.Context, deviceIDs []string) (map[string]*storage.UserSessions, error) {
+	if len(deviceIDs) < 1 {
+		return nil, errors.New("no ids provided")
+	}
+	args := make([]interface{}, len(deviceIDs))
+	for i, id := range deviceIDs {
+		args[i] = id
+	}
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    device_id,
+    enrollment_id, user_short_name, user_long_name, started_at, last_seen_at,
+    prev_enrollment_id, prev_user_short_name, prev_user_long_name, prev_started_at, prev_last_seen_at
+FROM user_sessions
+WHERE device_id IN (`+placeholders(1, len(deviceIDs))+`);`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.UserSessions)
+	for rows.Next() {
+		var deviceID string
+		var shortName, longName, prevID, prevShortName, prevLongName sql.NullString
+		var prevStartedAt, prevLastSeenAt sql.NullTime
+		current := new(storage.UserSession)
+		if err := rows.Scan(
+			&deviceID,
+			&current.EnrollmentID, &shortName, &longName, &current.StartedAt, &current.LastSeenAt,
+			&prevID, &prevShortName, &prevLongName, &prevStartedAt, &prevLastSeenAt,
+		); err != nil {
+			return nil, err
+		}
+		current.UserShortName = shortName.String
+		current.UserLongName = longName.String
+		sessions := &storage.UserSessions{Current: current}
+		if prevID.Valid {
+			sessions.Previous = &storage.UserSession{
+				EnrollmentID:  prevID.String,
+				UserShortName: prevShortName.String,
+				UserLongName:  prevLongName.String,
+				StartedAt:     prevStartedAt.Time,
+				LastSeenAt:    prevLastSeenAt.Time,
+			}
+		}
+		ret[deviceID] = sessions
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"context"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+)
+
+// storeEnrollmentAliases points each of aliases at the enrollment in r.
+func (s *SQLiteStorage) storeEnrollmentAliases(r *mdm.Request, aliases []string) error {
+	if len(aliases) < 1 {
+		return nil
+	}
+	values := make([]string, len(aliases))
+	var args []interface{}
+	for i, alias := range aliases {
+		values[i] = "(" + placeholders(i*2+1, 2) + ")"
+		args = append(args, alias, r.ID)
+	}
+	_, err := s.db.ExecContext(
+		r.Context,
+		`INSERT INTO enrollment_aliases (alias, id) VALUES `+strings.Join(values, ", ")+` ON CONFLICT (alias) DO UPDATE SET id = EXCLUDED.id;`,
+		args...,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) ResolveEnrollmentIDs(ctx context.Context, aliases []string) (map[string]string, error) {
+	if len(aliases) < 1 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(aliases))
+	for i, alias := range aliases {
+		args[i] = alias
+	}
+	in := `(` + placeholders(1, len(aliases)) + `)`
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT id, id, TRUE FROM enrollments WHERE id IN `+in+`
+UNION ALL
+SELECT alias, id, FALSE FROM enrollment_aliases WHERE alias IN `+in+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	resolved := make(map[string]string)
+	isEnrollment := make(map[string]bool)
+	for rows.Next() {
+		var alias, id string
+		var enrollment bool
+		if err := rows.Scan(&alias, &id, &enrollment); err != nil {
+			return nil, err
+		}
+		if isEnrollment[alias] {
+			continue
+		}
+		resolved[alias] = id
+		isEnrollment[alias] = enrollment
+	}
+	return resolved, rows.Err()
+}
@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreAPIKey(ctx context.Context, key *storage.APIKey) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO api_keys
+    (name, role, tenant, secret_hash)
+VALUES
+    ($1, $2, $3, $4)
+ON CONFLICT (name) DO
+UPDATE
+SET
+    role = EXCLUDED.role,
+    tenant = EXCLUDED.tenant,
+    secret_hash = EXCLUDED.secret_hash,
+    created_at = CURRENT_TIMESTAMP;`,
+		key.Name, key.Role, nullEmptyString(key.Tenant), key.SecretHash,
+	)
+	return err
+}
+
+// scanAPIKey scans an API key row.
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*storage.APIKey, error) {
+	key := new(storage.APIKey)
+	var tenant sql.NullString
+	var createdAt sql.NullTime
+	if err := row.Scan(&key.Name, &key.Role, &tenant, &key.SecretHash, &createdAt); err != nil {
+		return nil, err
+	}
+	key.Tenant = tenant.String
+	if createdAt.Valid {
+		key.CreatedAt = createdAt.Time.UTC()
+	}
+	return key, nil
+}
+
+func (s *SQLiteStorage) RetrieveAPIKey(ctx context.Context, name string) (*storage.APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRowContext(
+		ctx,
+		`SELECT name, role, tenant, secret_hash, created_at FROM api_keys WHERE name = $1;`,
+		name,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return key, err
+}
+
+func (s *SQLiteStorage) RetrieveAPIKeys(ctx context.Context) ([]*storage.APIKey, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, role, tenant, secret_hash, created_at FROM api_keys ORDER BY name;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []*storage.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteAPIKey(ctx context.Context, name string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM api_keys WHERE name = $1;`, name)
+	return err
+}
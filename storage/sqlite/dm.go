@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreDMEnablement(r *mdm.Request, endpoint, declarationsToken string) error {
+	cols := `(id, last_endpoint, last_checkin_at) VALUES ($1, $2, CURRENT_TIMESTAMP)`
+	update := ``
+	args := []interface{}{r.ID, endpoint}
+	if declarationsToken != "" {
+		cols = `(id, last_endpoint, last_checkin_at, declarations_token, declarations_token_at) VALUES ($1, $2, CURRENT_TIMESTAMP, $3, CURRENT_TIMESTAMP)`
+		update = `,
+    declarations_token = EXCLUDED.declarations_token,
+    declarations_token_at = EXCLUDED.declarations_token_at`
+		args = append(args, declarationsToken)
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO dm_enablements
+    `+cols+`
+ON CONFLICT (id) DO UPDATE
+SET
+    last_endpoint = EXCLUDED.last_endpoint,
+    last_checkin_at = EXCLUDED.last_checkin_at`+update+`;`,
+		args...,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveDMEnablements(ctx context.Context, ids []string) (map[string]*storage.DMEnablement, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, last_endpoint, last_checkin_at, declarations_token, declarations_token_at FROM dm_enablements`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.DMEnablement)
+	for rows.Next() {
+		var id string
+		var token sql.NullString
+		var tokenAt sql.NullTime
+		dm := new(storage.DMEnablement)
+		if err := rows.Scan(&id, &dm.LastEndpoint, &dm.LastCheckinAt, &token, &tokenAt); err != nil {
+			return nil, err
+		}
+		dm.DeclarationsToken = token.String
+		if tokenAt.Valid {
+			dm.DeclarationsTokenAt = &tokenAt.Time
+		}
+		ret[id] = dm
+	}
+	return ret, rows.Err()
+}
@@ -0,0 +1,74 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// enrollmentsWhere builds the WHERE clause and arguments for filter.
+func enrollmentsWhere(filter *storage.EnrollmentFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	param := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if len(filter.IDs) > 0 {
+		params := make([]string, len(filter.IDs))
+		for i, id := range filter.IDs {
+			params[i] = param(id)
+		}
+		where = append(where, `id IN (`+strings.Join(params, ", ")+`)`)
+	}
+	if filter.DeviceID != "" {
+		where = append(where, `device_id = `+param(filter.DeviceID))
+	}
+	if filter.Enabled != nil {
+		where = append(where, `enabled = `+param(*filter.Enabled))
+	}
+	if len(where) < 1 {
+		return "", args
+	}
+	return ` WHERE ` + strings.Join(where, " AND "), args
+}
+
+func (s *SQLiteStorage) RetrieveEnrollments(ctx context.Context, filter *storage.EnrollmentFilter) ([]*storage.Enrollment, error) {
+	if filter == nil {
+		filter = &storage.EnrollmentFilter{}
+	}
+	where, args := enrollmentsWhere(filter)
+	var limit string
+	if filter.Limit > 0 {
+		limit = ` LIMIT ` + strconv.Itoa(filter.Limit) + ` OFFSET ` + strconv.Itoa(filter.Offset)
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, device_id, user_id, (SELECT user_short_name FROM users WHERE users.id = enrollments.user_id AND users.device_id = enrollments.device_id), type, topic, enabled, last_seen_at, disable_reason, disabled_at FROM enrollments`+where+` ORDER BY id`+limit+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var enrollments []*storage.Enrollment
+	for rows.Next() {
+		var userID, userShortName, reason sql.NullString
+		var disabledAt sql.NullTime
+		e := new(storage.Enrollment)
+		if err := rows.Scan(&e.ID, &e.DeviceID, &userID, &userShortName, &e.Type, &e.Topic, &e.Enabled, &e.LastSeenAt, &reason, &disabledAt); err != nil {
+			return nil, err
+		}
+		e.UserID = userID.String
+		e.UserShortName = userShortName.String
+		e.DisableReason = reason.String
+		if disabledAt.Valid {
+			e.DisabledAt = &disabledAt.Time
+		}
+		enrollments = append(enrollments, e)
+	}
+	return enrollments, rows.Err()
+}
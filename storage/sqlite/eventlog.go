@@ -0,0 +1,46 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreLogEvent(ctx context.Context, event *storage.LogEvent) error {
+	return s.db.QueryRowContext(
+		ctx,
+		`INSERT INTO event_log (topic, enrollment_id, command_uuid, status, raw_payload, cert_hash) VALUES ($1, $2, $3, $4, $5, $6) RETURNING seq;`,
+		event.Topic,
+		event.EnrollmentID,
+		nullEmptyString(event.CommandUUID),
+		nullEmptyString(event.Status),
+		event.RawPayload,
+		nullEmptyString(event.CertHash),
+	).Scan(&event.Seq)
+}
+
+func (s *SQLiteStorage) RetrieveLogEvents(ctx context.Context, after int64, limit int) ([]*storage.LogEvent, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT seq, topic, enrollment_id, command_uuid, status, raw_payload, cert_hash, created_at FROM event_log WHERE seq > $1 ORDER BY seq LIMIT $2;`,
+		after, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []*storage.LogEvent
+	for rows.Next() {
+		event := new(storage.LogEvent)
+		var commandUUID, status, certHash sql.NullString
+		if err = rows.Scan(&event.Seq, &event.Topic, &event.EnrollmentID, &commandUUID, &status, &event.RawPayload, &certHash, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.CommandUUID = commandUUID.String
+		event.Status = status.String
+		event.CertHash = certHash.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
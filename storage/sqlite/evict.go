@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreEnrollmentEviction(ctx context.Context, eviction *storage.EnrollmentEviction) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_evictions
+    (id, reason, evicted_by)
+VALUES
+    ($1, $2, $3)
+ON CONFLICT (id) DO
+UPDATE
+SET
+    reason = EXCLUDED.reason,
+    evicted_by = EXCLUDED.evicted_by,
+    delivered_at = NULL,
+    created_at = CURRENT_TIMESTAMP;`,
+		eviction.ID, nullEmptyString(eviction.Reason), nullEmptyString(eviction.EvictedBy),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentEviction, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, reason, evicted_by, created_at, delivered_at FROM enrollment_evictions`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentEviction)
+	for rows.Next() {
+		eviction := new(storage.EnrollmentEviction)
+		var reason, evictedBy sql.NullString
+		var createdAt, deliveredAt sql.NullTime
+		if err := rows.Scan(&eviction.ID, &reason, &evictedBy, &createdAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+		eviction.Reason, eviction.EvictedBy = reason.String, evictedBy.String
+		if createdAt.Valid {
+			eviction.CreatedAt = createdAt.Time.UTC()
+		}
+		if deliveredAt.Valid {
+			delivered := deliveredAt.Time.UTC()
+			eviction.DeliveredAt = &delivered
+		}
+		ret[eviction.ID] = eviction
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQLiteStorage) MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_evictions SET delivered_at = CURRENT_TIMESTAMP WHERE id = $1 AND delivered_at IS NULL;`,
+		id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQLiteStorage) DeleteEnrollmentEviction(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_evictions WHERE id = $1;`, id)
+	return err
+}
@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreEnrollmentFreeze(ctx context.Context, freeze *storage.EnrollmentFreeze) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_freezes
+    (id, reason, frozen_by)
+VALUES
+    ($1, $2, $3)
+ON CONFLICT (id) DO
+UPDATE
+SET
+    reason = EXCLUDED.reason,
+    frozen_by = EXCLUDED.frozen_by,
+    created_at = CURRENT_TIMESTAMP;`,
+		freeze.ID, nullEmptyString(freeze.Reason), nullEmptyString(freeze.FrozenBy),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveEnrollmentFreezes(ctx context.Context, ids []string) (map[string]*storage.EnrollmentFreeze, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, reason, frozen_by, created_at FROM enrollment_freezes`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentFreeze)
+	for rows.Next() {
+		freeze := new(storage.EnrollmentFreeze)
+		var reason, frozenBy sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&freeze.ID, &reason, &frozenBy, &createdAt); err != nil {
+			return nil, err
+		}
+		freeze.Reason, freeze.FrozenBy = reason.String, frozenBy.String
+		if createdAt.Valid {
+			freeze.CreatedAt = createdAt.Time.UTC()
+		}
+		ret[freeze.ID] = freeze
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteEnrollmentFreeze(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_freezes WHERE id = $1;`, id)
+	return err
+}
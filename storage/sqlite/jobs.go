@@ -0,0 +1,162 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// placeholders returns n comma-separated query placeholders starting at $start.
+func placeholders(start, n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = "$" + strconv.Itoa(start+i)
+	}
+	return strings.Join(params, ", ")
+}
+
+func (s *SQLiteStorage) StoreJob(ctx context.Context, job *storage.Job, ids []string) error {
+	if len(ids) < 1 {
+		return errors.New("no id(s) supplied for job")
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO jobs (id, name, command_uuid, request_type) VALUES ($1, $2, $3, $4);`,
+		job.ID, nullEmptyString(job.Name), nullEmptyString(job.CommandUUID), nullEmptyString(job.RequestType),
+	)
+	if err == nil {
+		var query strings.Builder
+		query.WriteString(`INSERT INTO job_targets (job_id, id, status) VALUES `)
+		args := make([]interface{}, len(ids)*3)
+		for i, id := range ids {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			ind := i * 3
+			query.WriteString("(" + placeholders(ind+1, 3) + ")")
+			args[ind] = job.ID
+			args[ind+1] = id
+			args[ind+2] = storage.JobStatusQueued
+		}
+		query.WriteString(";")
+		_, err = tx.ExecContext(ctx, query.String(), args...)
+	}
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) UpdateJobTargets(ctx context.Context, jobID, status string, ids []string) error {
+	if len(ids) < 1 {
+		return nil
+	}
+	args := []interface{}{status, jobID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE job_targets SET status = $1 WHERE job_id = $2 AND id IN (`+placeholders(3, len(ids))+`);`,
+		args...,
+	)
+	return err
+}
+
+// jobStatusesFrom returns the statuses from which status may progress.
+func jobStatusesFrom(status string) []interface{} {
+	if status == storage.JobStatusDelivered {
+		return []interface{}{storage.JobStatusQueued}
+	}
+	return []interface{}{storage.JobStatusQueued, storage.JobStatusDelivered}
+}
+
+func (s *SQLiteStorage) UpdateJobCommandTarget(ctx context.Context, id, commandUUID, status string) error {
+	from := jobStatusesFrom(status)
+	args := append([]interface{}{status, id, commandUUID}, from...)
+	_, err := s.db.ExecContext(
+		ctx, `
+UPDATE
+    job_targets AS t
+SET
+    status = $1
+FROM
+    jobs AS j
+WHERE
+    t.job_id = j.id AND
+    t.id = $2 AND
+    j.command_uuid = $3 AND
+    t.status IN (`+placeholders(4, len(from))+`);`,
+		args...,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveJob(ctx context.Context, jobID string) (*storage.Job, error) {
+	var name, commandUUID, requestType sql.NullString
+	job := &storage.Job{ID: jobID}
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT name, command_uuid, request_type, created_at FROM jobs WHERE id = $1;`,
+		jobID,
+	).Scan(&name, &commandUUID, &requestType, &job.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	job.Name = name.String
+	job.CommandUUID = commandUUID.String
+	job.RequestType = requestType.String
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT status, COUNT(*) FROM job_targets WHERE job_id = $1 GROUP BY status;`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var ct int
+		if err := rows.Scan(&status, &ct); err != nil {
+			return nil, err
+		}
+		job.Counts.Add(status, ct)
+	}
+	return job, rows.Err()
+}
+
+func (s *SQLiteStorage) RetrieveJobTargets(ctx context.Context, jobID string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, status FROM job_targets WHERE job_id = $1;`,
+		jobID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]string)
+	for rows.Next() {
+		var id, status string
+		if err := rows.Scan(&id, &status); err != nil {
+			return nil, err
+		}
+		ret[id] = status
+	}
+	return ret, rows.Err()
+}
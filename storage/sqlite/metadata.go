@@ -0,0 +1,72 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// marshalList JSON-encodes l or returns NULL for an empty list.
+// A string is used so that the value is stored as TEXT.
+func marshalList(l []string) (sql.NullString, error) {
+	if len(l) < 1 {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(l)
+	return sql.NullString{String: string(b), Valid: true}, err
+}
+
+func (s *SQLiteStorage) StoreEnrollmentMetadata(ctx context.Context, id string, meta *storage.EnrollmentMetadata) error {
+	tags, err := marshalList(meta.Tags)
+	if err != nil {
+		return err
+	}
+	groups, err := marshalList(meta.Groups)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(
+		ctx, `
+INSERT INTO enrollment_metadata
+    (id, tenant, tags, group_names)
+VALUES
+    ($1, $2, $3, $4)
+ON CONFLICT (id) DO UPDATE
+SET
+    tenant = EXCLUDED.tenant,
+    tags = EXCLUDED.tags,
+    group_names = EXCLUDED.group_names;`,
+		id, nullEmptyString(meta.Tenant), tags, groups,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveEnrollmentMetadata(ctx context.Context, id string) (*storage.EnrollmentMetadata, error) {
+	var tenant sql.NullString
+	var tags, groups []byte
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT tenant, tags, group_names FROM enrollment_metadata WHERE id = $1;`,
+		id,
+	).Scan(&tenant, &tags, &groups)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	meta := &storage.EnrollmentMetadata{Tenant: tenant.String}
+	if len(tags) > 0 {
+		if err = json.Unmarshal(tags, &meta.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if len(groups) > 0 {
+		if err = json.Unmarshal(groups, &meta.Groups); err != nil {
+			return nil, err
+		}
+	}
+	return meta, nil
+}
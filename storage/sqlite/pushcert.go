@@ -0,0 +1,140 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"strconv"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/cryptoutil/keyprovider"
+)
+
+func (s *SQLiteStorage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+	var certPEM, keyPEM []byte
+	var staleToken int
+	err := s.db.QueryRowContext(
+		ctx,
+		`SELECT cert_pem, key_pem, stale_token FROM push_certs WHERE topic = $1;`,
+		topic,
+	).Scan(&certPEM, &keyPEM, &staleToken)
+	if err != nil {
+		return nil, "", err
+	}
+	// the private key is only ever decrypted for the push provider
+	keyPEM, err = keyprovider.Open(ctx, s.kp, keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, "", err
+	}
+	return &cert, strconv.Itoa(staleToken), err
+}
+
+func (s *SQLiteStorage) IsPushCertStale(ctx context.Context, topic, staleToken string) (bool, error) {
+	var staleTokenInt, dbStaleToken int
+	staleTokenInt, err := strconv.Atoi(staleToken)
+	if err != nil {
+		return true, err
+	}
+	err = s.db.QueryRowContext(
+		ctx,
+		`SELECT stale_token FROM push_certs WHERE topic = $1;`,
+		topic,
+	).Scan(&dbStaleToken)
+	return dbStaleToken != staleTokenInt, err
+}
+
+func (s *SQLiteStorage) StorePushCert(ctx context.Context, pemCert, pemKey []byte) error {
+	topic, err := cryptoutil.TopicFromPEMCert(pemCert)
+	if err != nil {
+		return err
+	}
+	if s.kp != nil {
+		if pemKey, err = keyprovider.Seal(ctx, s.kp, pemKey); err != nil {
+			return err
+		}
+	}
+	_, err = s.db.ExecContext(
+		ctx, `
+INSERT INTO push_certs
+    (topic, cert_pem, key_pem, stale_token)
+VALUES
+    ($1, $2, $3, 0) 
+ON CONFLICT (topic) DO
+UPDATE SET
+    cert_pem = EXCLUDED.cert_pem,
+    key_pem = EXCLUDED.key_pem,
+    stale_token = push_certs.stale_token + 1;`,
+		topic, string(pemCert), string(pemKey),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrievePushCertTopics(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT topic FROM push_certs ORDER BY topic;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var topics []string
+	for rows.Next() {
+		var topic string
+		if err = rows.Scan(&topic); err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	return topics, rows.Err()
+}
+
+// MigratePushCertKeys encrypts the unencrypted push certificate private
+// keys with the configured key provider. It returns the number of keys
+// encrypted. The stale token is not changed as the decrypted key stays
+// the same.
+func (s *SQLiteStorage) MigratePushCertKeys(ctx context.Context) (int, error) {
+	if s.kp == nil {
+		return 0, errors.New("no key provider")
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT topic, key_pem FROM push_certs;`)
+	if err != nil {
+		return 0, err
+	}
+	keys := make(map[string][]byte)
+	for rows.Next() {
+		var topic string
+		var keyPEM []byte
+		if err = rows.Scan(&topic, &keyPEM); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !keyprovider.Sealed(keyPEM) {
+			keys[topic] = keyPEM
+		}
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+	var n int
+	for topic, keyPEM := range keys {
+		sealed, err := keyprovider.Seal(ctx, s.kp, keyPEM)
+		if err != nil {
+			return n, err
+		}
+		// only update the key if it has not changed since reading it
+		_, err = s.db.ExecContext(
+			ctx,
+			`UPDATE push_certs SET key_pem = $1 WHERE topic = $2 AND key_pem = $3;`,
+			string(sealed), topic, string(keyPEM),
+		)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,338 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func enqueue(ctx context.Context, tx *sql.Tx, ids []string, cmd *mdm.Command) error {
+	if len(ids) < 1 {
+		return errors.New("no id(s) supplied to queue command to")
+	}
+	var expiresAt interface{}
+	if !cmd.ExpiresAt.IsZero() {
+		expiresAt = cmd.ExpiresAt.UTC()
+	}
+	var notBefore interface{}
+	if !cmd.NotBefore.IsZero() {
+		notBefore = cmd.NotBefore.UTC()
+	}
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO commands (command_uuid, request_type, command, expires_at, not_before) VALUES ($1, $2, $3, $4, $5);`,
+		cmd.CommandUUID, cmd.Command.RequestType, string(cmd.Raw), expiresAt, notBefore,
+	)
+	if err != nil {
+		return err
+	}
+
+	var query strings.Builder
+
+	query.WriteString(`INSERT INTO enrollment_queue (id, command_uuid, priority) VALUES `)
+	args := make([]interface{}, len(ids)*3)
+	for i, id := range ids {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		ind := i * 3
+
+		//previous: query += fmt.Sprintf("($%d, $%d, $%d)", ind+1, ind+2, ind+3)
+		query.WriteString("($")
+		query.WriteString(strconv.Itoa(ind + 1))
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 2))
+		query.WriteString(", $")
+		query.WriteString(strconv.Itoa(ind + 3))
+		query.WriteString(")")
+
+		args[ind] = id
+		args[ind+1] = cmd.CommandUUID
+		args[ind+2] = cmd.Priority
+	}
+	query.WriteString(";")
+
+	_, err = tx.ExecContext(ctx, query.String(), args...)
+	return err
+}
+
+func (s *SQLiteStorage) EnqueueCommand(ctx context.Context, ids []string, cmd *mdm.Command) (map[string]error, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = enqueue(ctx, tx, ids, cmd); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+func (s *SQLiteStorage) deleteCommand(ctx context.Context, tx *sql.Tx, id, uuid string) error {
+	_, err := tx.ExecContext(ctx, `
+DELETE FROM enrollment_queue
+WHERE id =$1 AND command_uuid =$2;`, id, uuid)
+	if err != nil {
+		return err
+	}
+	// delete command result (i.e. NotNows) and this queued command
+	_, err = tx.ExecContext(ctx, `
+DELETE FROM command_results
+WHERE id =$1 AND command_uuid =$2;`, id, uuid)
+	if err != nil {
+		return err
+	}
+
+	// now delete the actual command if no enrollments have it queued
+	// nor are there any results for it.
+	_, err = tx.ExecContext(
+		ctx, `
+DELETE FROM commands
+WHERE
+    command_uuid = $1 AND
+    NOT EXISTS (SELECT 1 FROM enrollment_queue AS q WHERE q.command_uuid = commands.command_uuid) AND
+    NOT EXISTS (SELECT 1 FROM command_results AS r WHERE r.command_uuid = commands.command_uuid);
+`,
+		uuid,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) deleteCommandTx(r *mdm.Request, result *mdm.CommandResults) error {
+	tx, err := s.db.BeginTx(r.Context, nil)
+	if err != nil {
+		return err
+	}
+	if err = s.deleteCommand(r.Context, tx, r.ID, result.CommandUUID); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) StoreCommandReport(r *mdm.Request, result *mdm.CommandResults) error {
+	if err := s.updateLastSeen(r); err != nil {
+		return err
+	}
+	if result.Status == "Idle" {
+		return nil
+	}
+	if s.rm && result.Status != "NotNow" {
+		return s.deleteCommandTx(r, result)
+	}
+	notNowConstants := "NULL, 0"
+	notNowBumpTallySQL := ""
+	// note that due to the "ON CONFLICT (id, command_uuid)" we don't UPDATE the
+	// not_now_at field. thus it will only represent the first NotNow.
+	if result.Status == "NotNow" {
+		notNowConstants = "CURRENT_TIMESTAMP, 1"
+		notNowBumpTallySQL = `, not_now_tally = command_results.not_now_tally + 1`
+	}
+	_, err := s.db.ExecContext(
+		r.Context, `
+INSERT INTO command_results
+    (id, command_uuid, status, result, not_now_at, not_now_tally)
+VALUES
+    ($1, $2, $3, $4, `+notNowConstants+`)
+ON CONFLICT (id, command_uuid) DO UPDATE 
+SET
+    status = EXCLUDED.status,
+    result = EXCLUDED.result`+notNowBumpTallySQL+`;`,
+		r.ID,
+		result.CommandUUID,
+		result.Status,
+		string(result.Raw),
+	)
+	return err
+}
+
+// RetrieveNextCommand retrieves the next queued command for r. Expired
+// commands are deactivated and skipped. Commands scheduled in the future
+// are skipped but stay queued.
+func (s *SQLiteStorage) RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error) {
+	statusWhere := "v.status IS NULL"
+	if !skipNotNow {
+		statusWhere = `(` + statusWhere + ` OR v.status = 'NotNow')`
+	}
+	for {
+		command := new(mdm.Command)
+		var expiresAt sql.NullTime
+		err := s.db.QueryRowContext(
+			r.Context,
+			`SELECT v.command_uuid, v.request_type, v.command, c.expires_at, v.priority FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.id = $1 AND v.active = TRUE AND (c.not_before IS NULL OR c.not_before <= $2) AND `+statusWhere+` ORDER BY v.priority DESC, v.created_at, v.seq LIMIT 1;`,
+			r.ID, time.Now().UTC(),
+		).Scan(&command.CommandUUID, &command.Command.RequestType, &command.Raw, &expiresAt, &command.Priority)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if expiresAt.Valid {
+			command.ExpiresAt = expiresAt.Time.UTC()
+		}
+		if !command.Expired(time.Now()) {
+			return command, nil
+		}
+		if err = s.expireCommand(r.Context, r.ID, command.CommandUUID); err != nil {
+			return nil, fmt.Errorf("expiring command: %w", err)
+		}
+	}
+}
+
+// expireCommand deactivates the expired command uuid in the queue of id.
+func (s *SQLiteStorage) expireCommand(ctx context.Context, id, uuid string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = FALSE WHERE id = $1 AND command_uuid = $2;`,
+		id, uuid,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) ClearQueue(r *mdm.Request) error {
+	if r.ParentID != "" {
+		return errors.New("can only clear a device channel queue")
+	}
+	// SQLite does not support joins in UPDATE so match the queued
+	// commands with subqueries
+	_, err := s.db.ExecContext(
+		r.Context,
+		`
+UPDATE enrollment_queue
+SET active = FALSE
+WHERE
+    active = TRUE AND
+    id IN (SELECT id FROM enrollments WHERE device_id = $1) AND
+    NOT EXISTS (
+        SELECT 1 FROM command_results AS r
+        WHERE
+            r.id = enrollment_queue.id AND
+            r.command_uuid = enrollment_queue.command_uuid AND
+            r.status != 'NotNow'
+    );`,
+		r.ID)
+	return err
+}
+
+// RetrieveQueue retrieves the queued commands of id in queue order.
+func (s *SQLiteStorage) RetrieveQueue(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(ctx, `v.id = $1`, id)
+}
+
+// RetrieveQueuedCommands retrieves the active, unexpired commands of id
+// without a result (or with NotNow) in queue order.
+func (s *SQLiteStorage) RetrieveQueuedCommands(ctx context.Context, id string) ([]*storage.QueuedCommand, error) {
+	return s.queryQueue(
+		ctx,
+		`v.id = $1 AND v.active = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND (c.expires_at IS NULL OR c.expires_at > $2)`,
+		id, time.Now().UTC(),
+	)
+}
+
+// CancelQueuedCommand deactivates the command uuid in the queue of id
+// if it is active and has no result (or NotNow).
+func (s *SQLiteStorage) CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error) {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE enrollment_queue SET active = FALSE WHERE id = $1 AND command_uuid = $2 AND active = TRUE AND NOT EXISTS (SELECT 1 FROM command_results AS r WHERE r.id = enrollment_queue.id AND r.command_uuid = enrollment_queue.command_uuid AND r.status != 'NotNow');`,
+		id, uuid,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// queryQueue retrieves the queued commands matching the where clause
+// in queue order.
+func (s *SQLiteStorage) queryQueue(ctx context.Context, where string, args ...interface{}) ([]*storage.QueuedCommand, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT v.command_uuid, v.request_type, v.active, v.status, v.command, v.result, v.priority, v.created_at, c.expires_at, c.not_before FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE `+where+` ORDER BY v.priority DESC, v.created_at, v.seq;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	now := time.Now()
+	var cmds []*storage.QueuedCommand
+	for rows.Next() {
+		cmd := new(storage.QueuedCommand)
+		var status sql.NullString
+		var expiresAt, notBefore sql.NullTime
+		if err = rows.Scan(&cmd.CommandUUID, &cmd.RequestType, &cmd.Active, &status, &cmd.Command, &cmd.Result, &cmd.Priority, &cmd.CreatedAt, &expiresAt, &notBefore); err != nil {
+			return nil, err
+		}
+		cmd.Status = status.String
+		if expiresAt.Valid {
+			t := expiresAt.Time.UTC()
+			cmd.SetExpiry(&t, now)
+		}
+		if notBefore.Valid {
+			t := notBefore.Time.UTC()
+			cmd.NotBefore = &t
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, rows.Err()
+}
+
+// RetrieveScheduledEnrollments retrieves the IDs of enrollments with
+// active queued commands scheduled after after and at or before until.
+func (s *SQLiteStorage) RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT v.id FROM view_queue AS v INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.active = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND c.not_before > $1 AND c.not_before <= $2;`,
+		after.UTC(), until.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RetrieveQueuedEnrollments retrieves the IDs of enabled enrollments
+// with due, unexpired commands without a result (or with NotNow).
+func (s *SQLiteStorage) RetrieveQueuedEnrollments(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT DISTINCT v.id FROM view_queue AS v INNER JOIN enrollments AS e ON e.id = v.id INNER JOIN commands AS c ON c.command_uuid = v.command_uuid WHERE v.active = TRUE AND e.enabled = TRUE AND (v.status IS NULL OR v.status = 'NotNow') AND (c.not_before IS NULL OR c.not_before <= $1) AND (c.expires_at IS NULL OR c.expires_at > $1) ORDER BY v.id;`,
+		time.Now().UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage/sqlschema"
+)
+
+// schemaChecker returns a checker of the database against Schema.
+func (s *SQLiteStorage) schemaChecker() *sqlschema.Checker {
+	return sqlschema.New(
+		s.db,
+		sqlschema.Parse(Schema),
+		`SELECT m.name, p.name FROM sqlite_master AS m, pragma_table_info(m.name) AS p WHERE m.type = 'table';`,
+		`SELECT tbl_name, name FROM sqlite_master WHERE type = 'index';`,
+	)
+}
+
+// CheckSchema checks the database for the tables, columns, and indexes
+// of Schema.
+func (s *SQLiteStorage) CheckSchema(ctx context.Context) (*sqlschema.Report, error) {
+	return s.schemaChecker().Check(ctx)
+}
+
+// MigrateSchema creates the tables and adds the columns and indexes of
+// Schema that are missing from the database where possible. See
+// sqlschema.Checker.Migrate.
+func (s *SQLiteStorage) MigrateSchema(ctx context.Context) (*sqlschema.Report, error) {
+	return s.schemaChecker().Migrate(ctx)
+}
+
+// SchemaEmpty reports whether none of the tables of Schema exist in the
+// database.
+func (s *SQLiteStorage) SchemaEmpty(ctx context.Context) (bool, error) {
+	return s.schemaChecker().Empty(ctx)
+}
+
+// DropSchema drops the tables of Schema and all of their data from the
+// database. It is intended for tearing down test databases.
+func (s *SQLiteStorage) DropSchema(ctx context.Context) error {
+	return s.schemaChecker().Drop(ctx)
+}
@@ -0,0 +1,788 @@
+/* Requires SQLite 3.24 or later for upserts (ON CONFLICT ... DO UPDATE).
+ * Foreign keys must be enabled per connection (the SQLite backend does
+ * this with its DSN).
+ */
+
+CREATE TABLE devices
+(
+    id                  VARCHAR(255) NOT NULL,
+
+    identity_cert       TEXT         NULL,
+
+    serial_number       VARCHAR(127) NULL,
+
+    -- If the (iOS, iPadOS) device sent an UnlockToken in the TokenUpdate
+        unlock_token        BLOB          NULL,
+    unlock_token_at     TIMESTAMP    NULL,
+
+    -- The last raw Authenticate for this device
+    authenticate        TEXT         NOT NULL,
+    authenticate_at     TIMESTAMP    NOT NULL,
+    -- The last raw TokenUpdate for this device
+    token_update        TEXT         NULL,
+    token_update_at     TIMESTAMP    NULL,
+
+    bootstrap_token_b64 TEXT         NULL,
+    bootstrap_token_at  TIMESTAMP    NULL,
+
+    created_at          TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at          TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id),
+
+    CHECK (identity_cert IS NULL OR substr(identity_cert, 1, 27) = '-----BEGIN CERTIFICATE-----'),
+    CHECK (serial_number IS NULL OR serial_number != ''),
+    CHECK (unlock_token IS NULL OR LENGTH(unlock_token) > 0),
+    CHECK (authenticate != ''),
+    CHECK (token_update IS NULL OR token_update != ''),
+    CHECK (bootstrap_token_b64 IS NULL OR bootstrap_token_b64 != '')
+);
+CREATE INDEX serial_number ON devices (serial_number);
+
+CREATE TABLE users
+(
+    id                          VARCHAR(255) NOT NULL,
+    device_id                   VARCHAR(255) NOT NULL,
+
+    user_short_name             VARCHAR(255) NULL,
+    user_long_name              VARCHAR(255) NULL,
+
+    -- The last raw TokenUpdate for this user
+    token_update                TEXT         NULL,
+    token_update_at             TIMESTAMP    NULL,
+
+    -- The last raw UserAuthenticate (and optional digest) for this user
+    user_authenticate           TEXT         NULL,
+    user_authenticate_at        TIMESTAMP    NULL,
+    user_authenticate_digest    TEXT         NULL,
+    user_authenticate_digest_at TIMESTAMP    NULL,
+
+    created_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at                  TIMESTAMP DEFAULT CURRENT_TIMESTAMP, -- trigger
+
+    PRIMARY KEY (id, device_id),
+    UNIQUE (id),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (user_short_name IS NULL OR user_short_name != ''),
+    CHECK (user_long_name IS NULL OR user_long_name != ''),
+    CHECK (token_update IS NULL OR token_update != ''),
+    CHECK (user_authenticate IS NULL OR user_authenticate != ''),
+    CHECK (user_authenticate_digest IS NULL OR user_authenticate_digest != '')
+);
+
+/* This table represents enrollments which are an amalgamation of
+ * both device and user enrollments.
+ */
+CREATE TABLE enrollments
+(
+    -- The enrollment ID of this enrollment
+    id                 VARCHAR(255) NOT NULL,
+    -- The "device" enrollment ID of this enrollment. This will be
+    -- the same as the `id` field in the case of a "device" enrollment,
+    -- or will be the "parent" enrollment for a "user" enrollment.
+    device_id          VARCHAR(255) NOT NULL,
+    -- The "user" enrollment ID of this enrollment. This will be the
+    -- same as the `id` field in the case of a "user" enrollment or
+    -- NULL in the case of a device enrollment.
+    user_id            VARCHAR(255) NULL,
+
+    -- Textual representation of the type of device enrollment.
+    type               VARCHAR(31)  NOT NULL,
+
+    -- The MDM APNs push trifecta.
+    topic              VARCHAR(255) NOT NULL,
+    push_magic         VARCHAR(127) NOT NULL,
+    token_hex          VARCHAR(255) NOT NULL, -- TODO: Perhaps just CHAR(64)?
+
+    enabled            BOOLEAN      NOT NULL DEFAULT TRUE,
+    token_update_tally INTEGER      NOT NULL DEFAULT 1,
+
+    -- Why and when the enrollment was last disabled, if ever.
+    disable_reason     VARCHAR(31)  NULL,
+    disabled_at        TIMESTAMP    NULL,
+
+    last_seen_at       TIMESTAMP    NOT NULL, -- TODO: additional tests with real device and integration tests.
+
+    created_at         TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    updated_at         TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+    CHECK (id != ''),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (user_id)
+        REFERENCES users (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+    UNIQUE (user_id),
+
+    CHECK (type != ''),
+    CHECK (topic != ''),
+    CHECK (push_magic != ''),
+    CHECK (token_hex != '')
+);
+CREATE INDEX idx_type ON enrollments (type);
+
+/* Commands stand alone. By themselves they aren't associated with
+ * a device, a result (response), etc. Joining other tables is required
+ * for more context.
+ */
+CREATE TABLE commands
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    -- Raw command Plist
+    command      TEXT         NOT NULL,
+    -- Commands are not sent once expired
+    expires_at   TIMESTAMP    NULL,
+    -- Commands are not sent before they are scheduled
+    not_before   TIMESTAMP    NULL,
+
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid),
+
+    CHECK (command_uuid != ''),
+    CHECK (request_type != ''),
+    CHECK (substr(command, 1, 5) = '<?xml')
+);
+
+CREATE INDEX idx_commands_not_before ON commands (not_before);
+
+
+/* Results are enrollment responses to device commands.
+ *
+ * The choice for the PK being just the enrollment ID and command UUID
+ * was under consideration. The PK could have included for example the
+ * status in which case we could have separate status updates for
+ * a NotNow vs. an Acknowledge. However this might be non-intuitive to
+ * then query against to find if a given command had a response or not
+ * (i.e. the queue view would be more complicated). In the end this
+ * means we lose insight into when NotNows happen once a command is
+ * Acknowledged.
+ */
+CREATE TABLE command_results
+(
+    id            VARCHAR(255) NOT NULL,
+    command_uuid  VARCHAR(127) NOT NULL,
+    status        VARCHAR(31)  NOT NULL,
+    result        TEXT         NOT NULL,
+
+    not_now_at    TIMESTAMP    NULL,
+    not_now_tally INTEGER      NOT NULL DEFAULT 0,
+
+    created_at    TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    updated_at    TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (command_uuid)
+        REFERENCES commands (command_uuid)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- considering not enforcing these CHECKs to make sure we always
+    -- capture results in the case they're malformed.
+    CHECK (status != ''),
+    CHECK (substr(result, 1, 5) = '<?xml')
+);
+CREATE INDEX idx_status ON command_results (status);
+
+
+CREATE TABLE enrollment_queue
+(
+    id           VARCHAR(255) NOT NULL,
+    command_uuid VARCHAR(127) NOT NULL,
+
+    active       BOOLEAN      NOT NULL DEFAULT TRUE,
+    priority     SMALLINT     NOT NULL DEFAULT 0,
+
+    created_at   TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP             DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, command_uuid),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    FOREIGN KEY (command_uuid)
+        REFERENCES commands (command_uuid)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+/* An enrollment's queue is a view into commands, enrollment queued
+ * commands, and any results received. Outstanding queue items (i.e.
+ * those that have received no result yet) will have a status of NULL
+ * (due to the LEFT JOIN against results).
+ */
+CREATE VIEW view_queue AS
+SELECT q.id,
+       q.created_at,
+       q.rowid AS seq,
+       q.active,
+       q.priority,
+       c.command_uuid,
+       c.request_type,
+       c.command,
+       r.updated_at AS result_updated_at,
+       r.status,
+       r.result
+FROM enrollment_queue AS q
+
+         INNER JOIN commands AS c
+                    ON q.command_uuid = c.command_uuid
+
+         LEFT JOIN command_results r
+                   ON r.command_uuid = q.command_uuid AND r.id = q.id
+ORDER BY q.priority DESC,
+         q.created_at,
+         q.rowid;
+
+
+CREATE TABLE push_certs
+(
+    topic       VARCHAR(255) NOT NULL,
+
+    cert_pem    TEXT         NOT NULL,
+    key_pem     TEXT         NOT NULL,
+
+    /* stale_token is a simple value that coordinates push certificates
+     * across the SQL backend. The push service checks this value
+     * every time push info is requested. This value should be updated
+     * every time a push cert is updated (i.e. renewals) and so all
+     * push services using this table will know the certificate has
+     * changed and reload it. This is managed by the SQLite backend. */
+    stale_token INTEGER      NOT NULL,
+
+    created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (topic),
+    CHECK (topic != ''),
+
+    CHECK (substr(cert_pem, 1, 27) = '-----BEGIN CERTIFICATE-----'),
+    CHECK (substr(key_pem, 1, 5) = '-----')
+);
+
+
+CREATE TABLE cert_auth_associations
+(
+    id         VARCHAR(255) NOT NULL,
+    sha256     CHAR(64)     NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, sha256),
+
+    CHECK (id != ''),
+    CHECK (sha256 != '')
+);
+
+
+CREATE TABLE dm_enablements
+(
+    id                    VARCHAR(255) NOT NULL,
+
+    last_endpoint         VARCHAR(255) NOT NULL,
+    last_checkin_at       TIMESTAMP    NOT NULL,
+
+    declarations_token    VARCHAR(255) NULL,
+    declarations_token_at TIMESTAMP    NULL,
+
+    created_at            TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at            TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (declarations_token IS NULL OR declarations_token != '')
+);
+
+
+/* Operator-supplied enrollment metadata. Note there is no foreign key
+ * to the enrollments table so that metadata may be assigned before an
+ * enrollment exists.
+ */
+CREATE TABLE enrollment_metadata
+(
+    id          VARCHAR(255) NOT NULL,
+
+    tenant      VARCHAR(255) NULL,
+    tags        TEXT         NULL,
+    group_names TEXT         NULL,
+
+    created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    CHECK (id != ''),
+    CHECK (tenant IS NULL OR tenant != '')
+);
+
+
+/* Bulk enqueue and push operations tracked as jobs. Note there is no
+ * foreign key to the commands table as commands are deleted when
+ * complete and push-only jobs have no command.
+ */
+CREATE TABLE jobs
+(
+    id           VARCHAR(127) NOT NULL,
+
+    name         VARCHAR(255) NULL,
+    command_uuid VARCHAR(127) NULL,
+    request_type VARCHAR(63)  NULL,
+
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    CHECK (id != ''),
+    CHECK (command_uuid IS NULL OR command_uuid != '')
+);
+
+CREATE INDEX idx_command_uuid ON jobs (command_uuid);
+
+CREATE TABLE job_targets
+(
+    job_id     VARCHAR(127) NOT NULL,
+    id         VARCHAR(255) NOT NULL,
+
+    status     VARCHAR(15)  NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (job_id, id),
+
+    FOREIGN KEY (job_id)
+        REFERENCES jobs (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (status IN ('queued', 'delivered', 'acknowledged', 'errored'))
+);
+
+CREATE INDEX idx_job_target_id ON job_targets (id);
+
+
+CREATE TABLE event_log
+(
+    seq           INTEGER      PRIMARY KEY AUTOINCREMENT,
+
+    topic         VARCHAR(63)  NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    command_uuid  VARCHAR(127) NULL,
+    status        VARCHAR(31)  NULL,
+    raw_payload   BLOB         NULL,
+    cert_hash     CHAR(64)     NULL,
+
+    created_at    TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+
+    CHECK (topic != '')
+);
+
+
+/* Serial numbers, UDIDs, and EnrollmentIDs from Authenticate messages
+ * that resolve to a device enrollment ID.
+ */
+CREATE TABLE enrollment_aliases
+(
+    alias      VARCHAR(255) NOT NULL,
+    id         VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (alias),
+
+    FOREIGN KEY (id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE,
+
+    CHECK (alias != '')
+);
+
+/* The current and previous user (channel) sessions of a device, such
+ * as the users of a Shared iPad.
+ */
+CREATE TABLE user_sessions
+(
+    device_id            VARCHAR(255) NOT NULL,
+
+    enrollment_id        VARCHAR(255) NOT NULL,
+    user_short_name      VARCHAR(255) NULL,
+    user_long_name       VARCHAR(255) NULL,
+    started_at           TIMESTAMP    NOT NULL,
+    last_seen_at         TIMESTAMP    NOT NULL,
+
+    prev_enrollment_id   VARCHAR(255) NULL,
+    prev_user_short_name VARCHAR(255) NULL,
+    prev_user_long_name  VARCHAR(255) NULL,
+    prev_started_at      TIMESTAMP    NULL,
+    prev_last_seen_at    TIMESTAMP    NULL,
+
+    created_at           TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at           TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (device_id),
+
+    FOREIGN KEY (device_id)
+        REFERENCES devices (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE metrics_rollups
+(
+    period   VARCHAR(7) NOT NULL,
+    start_at TIMESTAMP  NOT NULL,
+
+    checkins BIGINT NOT NULL DEFAULT 0,
+    commands BIGINT NOT NULL DEFAULT 0,
+    errors   BIGINT NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (period, start_at),
+
+    CHECK (period IN ('hour', 'day'))
+);
+
+CREATE TABLE api_keys
+(
+    name        VARCHAR(255) NOT NULL,
+    role        VARCHAR(255) NOT NULL,
+    tenant      VARCHAR(255) NULL,
+    secret_hash CHAR(64)     NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name)
+);
+
+CREATE TABLE pending_commands
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    request_type VARCHAR(63)  NOT NULL,
+    targets      TEXT         NOT NULL,
+    no_push      BOOLEAN      NOT NULL DEFAULT FALSE,
+    requested_by VARCHAR(255) NULL,
+    command      TEXT         NOT NULL,
+    expires_at   TIMESTAMP    NULL,
+    not_before   TIMESTAMP    NULL,
+    priority     SMALLINT     NOT NULL DEFAULT 0,
+    template     VARCHAR(255) NULL,
+    locale       VARCHAR(35)  NULL,
+    owner        VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
+CREATE TABLE enrollment_supersessions
+(
+    id            VARCHAR(255) NOT NULL,
+    superseded_by VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_freezes
+(
+    id        VARCHAR(255) NOT NULL,
+    reason    TEXT         NULL,
+    frozen_by VARCHAR(255) NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_evictions
+(
+    id           VARCHAR(255) NOT NULL,
+    reason       TEXT         NULL,
+    evicted_by   VARCHAR(255) NULL,
+    delivered_at TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE enrollment_tombstones
+(
+    id            VARCHAR(255) NOT NULL,
+    serial_number VARCHAR(127) NULL,
+    type          VARCHAR(31)  NULL,
+    reason        TEXT         NULL,
+    departed_by   VARCHAR(255) NULL,
+    enrolled_at   TIMESTAMP    NULL,
+    last_seen_at  TIMESTAMP    NULL,
+
+    departed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_tombstone_serial_number ON enrollment_tombstones (serial_number);
+
+CREATE TABLE webhook_deliveries
+(
+    id      VARCHAR(127) NOT NULL,
+    url     TEXT         NOT NULL,
+    topic   VARCHAR(255) NOT NULL,
+    version INTEGER      NOT NULL,
+    body    TEXT         NOT NULL,
+
+    attempts        INTEGER   NOT NULL DEFAULT 0,
+    last_error      TEXT      NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    dead            BOOLEAN   NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+CREATE INDEX idx_webhook_delivery_due ON webhook_deliveries (dead, next_attempt_at);
+
+CREATE TABLE message_templates
+(
+    name         VARCHAR(255) NOT NULL,
+    group_name   VARCHAR(255) NOT NULL DEFAULT '',
+    locale       VARCHAR(35)  NOT NULL DEFAULT '',
+    message      TEXT         NULL,
+    phone_number VARCHAR(63)  NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (name, group_name, locale)
+);
+
+CREATE TABLE declarations
+(
+    identifier   VARCHAR(255) NOT NULL,
+    type         VARCHAR(255) NOT NULL,
+    payload      TEXT         NOT NULL,
+    server_token VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (identifier)
+);
+
+CREATE TABLE declaration_sets
+(
+    set_name               VARCHAR(255) NOT NULL,
+    declaration_identifier VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (set_name, declaration_identifier),
+
+    FOREIGN KEY (declaration_identifier)
+        REFERENCES declarations (identifier)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+CREATE INDEX idx_declaration_sets_identifier ON declaration_sets (declaration_identifier);
+
+CREATE TABLE enrollment_declaration_sets
+(
+    id       VARCHAR(255) NOT NULL,
+    set_name VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, set_name)
+);
+
+CREATE TABLE unlock_token_requests
+(
+    id            VARCHAR(127) NOT NULL,
+    enrollment_id VARCHAR(255) NOT NULL,
+    reason        TEXT         NULL,
+    requested_by  VARCHAR(255) NULL,
+    approved_by   VARCHAR(255) NULL,
+    approved_at   TIMESTAMP    NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id)
+);
+
+CREATE TABLE ddm_status_reports
+(
+    id              VARCHAR(255) NOT NULL,
+    report          TEXT         NOT NULL,
+    reported_at     TIMESTAMP    NOT NULL,
+    declarations    TEXT         NULL,
+    declarations_at TIMESTAMP    NULL,
+
+    created_at      TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id),
+
+    FOREIGN KEY (id)
+        REFERENCES enrollments (id)
+        ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE TABLE inventory_snapshots
+(
+    id       VARCHAR(255) NOT NULL,
+    source   VARCHAR(63)  NOT NULL,
+    snapshot TEXT         NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (id, source)
+);
+
+CREATE TABLE command_owners
+(
+    command_uuid VARCHAR(127) NOT NULL,
+    owner        VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (command_uuid)
+);
+
+/* SQLite has no ON UPDATE CURRENT_TIMESTAMP so update the updated_at
+ * column of each table that has one with a trigger. Recursive triggers
+ * are disabled by default so the trigger's own update does not fire it.
+ */
+CREATE TRIGGER devices_updated_at AFTER UPDATE ON devices
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE devices SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER users_updated_at AFTER UPDATE ON users
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER enrollments_updated_at AFTER UPDATE ON enrollments
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE enrollments SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER commands_updated_at AFTER UPDATE ON commands
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE commands SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER command_results_updated_at AFTER UPDATE ON command_results
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE command_results SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER enrollment_queue_updated_at AFTER UPDATE ON enrollment_queue
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE enrollment_queue SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER push_certs_updated_at AFTER UPDATE ON push_certs
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE push_certs SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER cert_auth_associations_updated_at AFTER UPDATE ON cert_auth_associations
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE cert_auth_associations SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER dm_enablements_updated_at AFTER UPDATE ON dm_enablements
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE dm_enablements SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER enrollment_metadata_updated_at AFTER UPDATE ON enrollment_metadata
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE enrollment_metadata SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER jobs_updated_at AFTER UPDATE ON jobs
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE jobs SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER job_targets_updated_at AFTER UPDATE ON job_targets
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE job_targets SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER enrollment_aliases_updated_at AFTER UPDATE ON enrollment_aliases
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE enrollment_aliases SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER user_sessions_updated_at AFTER UPDATE ON user_sessions
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE user_sessions SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER inventory_snapshots_updated_at AFTER UPDATE ON inventory_snapshots
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE inventory_snapshots SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER command_owners_updated_at AFTER UPDATE ON command_owners
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE command_owners SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER webhook_deliveries_updated_at AFTER UPDATE ON webhook_deliveries
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE webhook_deliveries SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER message_templates_updated_at AFTER UPDATE ON message_templates
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE message_templates SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
+
+CREATE TRIGGER declarations_updated_at AFTER UPDATE ON declarations
+    FOR EACH ROW WHEN NEW.updated_at = OLD.updated_at
+BEGIN
+    UPDATE declarations SET updated_at = CURRENT_TIMESTAMP WHERE rowid = NEW.rowid;
+END;
@@ -0,0 +1,162 @@
+package sqlite
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage/conformance"
+	"github.com/micromdm/nanomdm/storage/test"
+)
+
+// newStorage creates a new storage with a new database file.
+func newStorage(t *testing.T, opts ...Option) *SQLiteStorage {
+	t.Helper()
+	opts = append([]Option{WithDSN(filepath.Join(t.TempDir(), "nanomdm.db"))}, opts...)
+	storage, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { storage.db.Close() })
+	return storage
+}
+
+// enrollTestDevice stores the Authenticate and TokenUpdate test
+// messages of a device.
+func enrollTestDevice(t *testing.T, storage *SQLiteStorage) *mdm.Authenticate {
+	t.Helper()
+	var msgs []interface{}
+	for _, name := range []string{"Authenticate.2.plist", "TokenUpdate.2.plist"} {
+		b, err := ioutil.ReadFile("../../mdm/testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := mdm.DecodeCheckin(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, msg)
+	}
+	auth, ok := msgs[0].(*mdm.Authenticate)
+	if !ok {
+		t.Fatal("not an Authenticate message")
+	}
+	tu, ok := msgs[1].(*mdm.TokenUpdate)
+	if !ok {
+		t.Fatal("not a TokenUpdate message")
+	}
+	r := &mdm.Request{
+		Context:  context.Background(),
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: auth.UDID},
+	}
+	if err := storage.StoreAuthenticate(r, auth); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.StoreTokenUpdate(r, tu); err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+func TestQueue(t *testing.T) {
+	storage := newStorage(t, WithDeleteCommands())
+	auth := enrollTestDevice(t, storage)
+	t.Run("WithDeleteCommands()", func(t *testing.T) {
+		test.TestQueue(t, auth.UDID, storage)
+	})
+
+	storage = newStorage(t)
+	auth = enrollTestDevice(t, storage)
+	t.Run("normal", func(t *testing.T) {
+		test.TestQueue(t, auth.UDID, storage)
+		test.TestRetrieveQueue(t, auth.UDID, storage)
+		test.TestExpiringQueue(t, auth.UDID, storage)
+		test.TestScheduledQueue(t, auth.UDID, storage)
+		test.TestQueuedEnrollments(t, auth.UDID, storage)
+		test.TestPriorityQueue(t, auth.UDID, storage)
+		test.TestQueuedCommands(t, auth.UDID, storage)
+	})
+}
+
+func TestEnrollments(t *testing.T) {
+	storage := newStorage(t)
+	auth := enrollTestDevice(t, storage)
+	test.TestTopicStats(t, auth.UDID, storage)
+	test.TestEnrollments(t, auth.UDID, storage)
+	test.TestEnrollmentAliases(t, auth.UDID, auth.SerialNumber, storage)
+	test.TestUserSessions(t, auth.UDID, storage)
+	test.TestDDMStatusReports(t, auth.UDID, storage)
+}
+
+func TestEnrollmentTombstones(t *testing.T) {
+	storage := newStorage(t)
+	auth := enrollTestDevice(t, storage)
+	test.TestEnrollmentTombstones(t, auth.UDID, auth.SerialNumber, storage)
+}
+
+func TestStores(t *testing.T) {
+	storage := newStorage(t)
+	test.TestJobs(t, storage)
+	test.TestEventLog(t, storage)
+	test.TestMetricsRollups(t, storage)
+	test.TestAPIKeys(t, storage)
+	test.TestPendingCommands(t, storage)
+	test.TestEnrollmentFreezes(t, storage)
+	test.TestEnrollmentEvictions(t, storage)
+	test.TestEnrollmentSupersessions(t, storage)
+	test.TestInventory(t, storage)
+	test.TestCommandOwners(t, storage)
+	test.TestWebhookDeliveries(t, storage)
+	test.TestMessageTemplates(t, storage)
+	test.TestDeclarations(t, storage)
+	test.TestUnlockTokenRequests(t, storage)
+}
+
+func TestSchema(t *testing.T) {
+	storage := newStorage(t)
+	report, err := storage.CheckSchema(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = report.Err(); err != nil {
+		t.Error(err)
+	}
+
+	// missing indexes are reported and migrated
+	ctx := context.Background()
+	if _, err = storage.db.ExecContext(ctx, `DROP INDEX idx_commands_not_before;`); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = storage.CheckSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if have := report.MissingIndexes["commands"]; len(have) != 1 || have[0] != "idx_commands_not_before" {
+		t.Errorf("missing indexes: have %v, want [idx_commands_not_before]", report.MissingIndexes)
+	}
+	if report, err = storage.MigrateSchema(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = report.Err(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	storage := newStorage(t)
+	// the schema is applied when creating the storage
+	if err := storage.DropSchema(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if empty, err := storage.SchemaEmpty(context.Background()); err != nil {
+			t.Error(err)
+		} else if !empty {
+			t.Error("schema not dropped")
+		}
+	})
+	conformance.Run(t, storage)
+}
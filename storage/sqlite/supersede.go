@@ -0,0 +1,63 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreEnrollmentSupersession(ctx context.Context, ss *storage.EnrollmentSupersession) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_supersessions
+    (id, superseded_by)
+VALUES
+    ($1, $2)
+ON CONFLICT (id) DO
+UPDATE
+SET
+    superseded_by = EXCLUDED.superseded_by,
+    created_at = CURRENT_TIMESTAMP;`,
+		ss.ID, ss.SupersededBy,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*storage.EnrollmentSupersession, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, superseded_by, created_at FROM enrollment_supersessions`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentSupersession)
+	for rows.Next() {
+		ss := new(storage.EnrollmentSupersession)
+		var createdAt sql.NullTime
+		if err := rows.Scan(&ss.ID, &ss.SupersededBy, &createdAt); err != nil {
+			return nil, err
+		}
+		if createdAt.Valid {
+			ss.CreatedAt = createdAt.Time.UTC()
+		}
+		ret[ss.ID] = ss
+	}
+	return ret, rows.Err()
+}
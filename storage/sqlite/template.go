@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreMessageTemplate(ctx context.Context, t *storage.MessageTemplate) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO message_templates
+    (name, group_name, locale, message, phone_number)
+VALUES
+    ($1, $2, $3, $4, $5)
+ON CONFLICT (name, group_name, locale) DO
+UPDATE
+SET
+    message = EXCLUDED.message,
+    phone_number = EXCLUDED.phone_number;`,
+		t.Name, t.Group, t.Locale, nullEmptyString(t.Message), nullEmptyString(t.PhoneNumber),
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveMessageTemplates(ctx context.Context, name string) ([]*storage.MessageTemplate, error) {
+	var where string
+	var args []interface{}
+	if name != "" {
+		where = ` WHERE name = $1`
+		args = append(args, name)
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT name, group_name, locale, message, phone_number, updated_at FROM message_templates`+where+` ORDER BY name, group_name, locale;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var templates []*storage.MessageTemplate
+	for rows.Next() {
+		t := new(storage.MessageTemplate)
+		var message, phoneNumber sql.NullString
+		var updatedAt sql.NullTime
+		if err := rows.Scan(&t.Name, &t.Group, &t.Locale, &message, &phoneNumber, &updatedAt); err != nil {
+			return nil, err
+		}
+		t.Message, t.PhoneNumber = message.String, phoneNumber.String
+		if updatedAt.Valid {
+			t.UpdatedAt = updatedAt.Time.UTC()
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteMessageTemplate(ctx context.Context, name, group, locale string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`DELETE FROM message_templates WHERE name = $1 AND group_name = $2 AND locale = $3;`,
+		name, group, locale,
+	)
+	return err
+}
@@ -0,0 +1,184 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// tombstonePurgeTables are the tables keyed by enrollment ID without a
+// foreign key to the devices table. Their rows of departed enrollments
+// are purged explicitly.
+var tombstonePurgeTables = []string{
+	"cert_auth_associations",
+	"enrollment_metadata",
+	"inventory_snapshots",
+	"enrollment_freezes",
+	"enrollment_evictions",
+	"enrollment_supersessions",
+	"enrollment_declaration_sets",
+}
+
+// departEnrollment stores the tombstone of id in tx and purges id and
+// its user channel enrollments. It reports whether id is a device.
+func departEnrollment(ctx context.Context, tx *sql.Tx, tombstone *storage.EnrollmentTombstone) (bool, error) {
+	var serial, enrollType sql.NullString
+	var enrolledAt, lastSeenAt sql.NullTime
+	err := tx.QueryRowContext(
+		ctx,
+		`
+SELECT
+    d.serial_number,
+    e.type,
+    d.authenticate_at,
+    e.last_seen_at
+FROM
+    devices AS d
+    LEFT JOIN enrollments AS e
+        ON e.id = d.id
+WHERE
+    d.id = $1;`,
+		tombstone.ID,
+	).Scan(&serial, &enrollType, &enrolledAt, &lastSeenAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("selecting device: %w", err)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id FROM enrollments WHERE device_id = $1;`, tombstone.ID)
+	if err != nil {
+		return false, fmt.Errorf("selecting enrollments: %w", err)
+	}
+	ids := []interface{}{tombstone.ID}
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return false, err
+		}
+		if id != tombstone.ID {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(
+		ctx,
+		`
+INSERT INTO enrollment_tombstones
+    (id, serial_number, type, reason, departed_by, enrolled_at, last_seen_at)
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7)
+ON CONFLICT (id) DO
+UPDATE
+SET
+    serial_number = EXCLUDED.serial_number,
+    type = EXCLUDED.type,
+    reason = EXCLUDED.reason,
+    departed_by = EXCLUDED.departed_by,
+    enrolled_at = EXCLUDED.enrolled_at,
+    last_seen_at = EXCLUDED.last_seen_at,
+    departed_at = CURRENT_TIMESTAMP;`,
+		tombstone.ID, serial, enrollType,
+		nullEmptyString(tombstone.Reason), nullEmptyString(tombstone.DepartedBy),
+		enrolledAt, lastSeenAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("inserting tombstone: %w", err)
+	}
+	// the users, enrollments, queues, results, and so on cascade
+	if _, err = tx.ExecContext(ctx, `DELETE FROM devices WHERE id = $1;`, tombstone.ID); err != nil {
+		return false, fmt.Errorf("deleting device: %w", err)
+	}
+	params := make([]string, len(ids))
+	for i := range ids {
+		params[i] = "$" + strconv.Itoa(i+1)
+	}
+	in := `id IN (` + strings.Join(params, ", ") + `)`
+	for _, table := range tombstonePurgeTables {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+in+`;`, ids...); err != nil {
+			return false, fmt.Errorf("deleting from %s: %w", table, err)
+		}
+	}
+	return true, nil
+}
+
+func (s *SQLiteStorage) DepartEnrollment(ctx context.Context, tombstone *storage.EnrollmentTombstone) (*storage.EnrollmentTombstone, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	departed, err := departEnrollment(ctx, tx, tombstone)
+	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return nil, fmt.Errorf("rollback error: %w; while trying to handle error: %v", rbErr, err)
+		}
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil || !departed {
+		return nil, err
+	}
+	tombstones, err := s.RetrieveEnrollmentTombstones(ctx, []string{tombstone.ID})
+	if err != nil {
+		return nil, err
+	}
+	return tombstones[tombstone.ID], nil
+}
+
+func (s *SQLiteStorage) RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*storage.EnrollmentTombstone, error) {
+	var where string
+	args := make([]interface{}, len(ids))
+	if len(ids) > 0 {
+		params := make([]string, len(ids))
+		for i, v := range ids {
+			params[i] = "$" + strconv.Itoa(i+1)
+			args[i] = v
+		}
+		where = ` WHERE id IN (` + strings.Join(params, ", ") + `)`
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, serial_number, type, reason, departed_by, enrolled_at, last_seen_at, departed_at FROM enrollment_tombstones`+where+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]*storage.EnrollmentTombstone)
+	for rows.Next() {
+		t := new(storage.EnrollmentTombstone)
+		var serial, enrollType, reason, departedBy sql.NullString
+		var enrolledAt, lastSeenAt, departedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &serial, &enrollType, &reason, &departedBy, &enrolledAt, &lastSeenAt, &departedAt); err != nil {
+			return nil, err
+		}
+		t.SerialNumber, t.Type = serial.String, enrollType.String
+		t.Reason, t.DepartedBy = reason.String, departedBy.String
+		if enrolledAt.Valid {
+			enrolled := enrolledAt.Time.UTC()
+			t.EnrolledAt = &enrolled
+		}
+		if lastSeenAt.Valid {
+			lastSeen := lastSeenAt.Time.UTC()
+			t.LastSeenAt = &lastSeen
+		}
+		if departedAt.Valid {
+			t.DepartedAt = departedAt.Time.UTC()
+		}
+		ret[t.ID] = t
+	}
+	return ret, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteEnrollmentTombstone(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM enrollment_tombstones WHERE id = $1;`, id)
+	return err
+}
@@ -0,0 +1,44 @@
+package sqlite
+
+import (
+	"context"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) RetrieveTopicStats(ctx context.Context) ([]*storage.TopicStats, error) {
+	rows, err := s.db.QueryContext(
+		ctx, `
+SELECT
+    e.topic,
+    COUNT(*),
+    COUNT(CASE WHEN e.enabled THEN 1 END),
+    COALESCE(SUM(p.pending), 0)
+FROM enrollments AS e
+    LEFT JOIN (
+        SELECT q.id, COUNT(*) AS pending
+        FROM enrollment_queue AS q
+            LEFT JOIN command_results r
+                ON r.command_uuid = q.command_uuid AND r.id = q.id
+        WHERE q.active = TRUE
+            AND (r.status IS NULL OR r.status = 'NotNow')
+        GROUP BY q.id
+    ) AS p
+        ON p.id = e.id
+GROUP BY e.topic
+ORDER BY e.topic;`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []*storage.TopicStats
+	for rows.Next() {
+		ts := new(storage.TopicStats)
+		if err := rows.Scan(&ts.Topic, &ts.Enrollments, &ts.ActiveEnrollments, &ts.PendingCommands); err != nil {
+			return nil, err
+		}
+		stats = append(stats, ts)
+	}
+	return stats, rows.Err()
+}
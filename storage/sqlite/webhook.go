@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func (s *SQLiteStorage) StoreWebhookDelivery(ctx context.Context, d *storage.WebhookDelivery) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`
+INSERT INTO webhook_deliveries
+    (id, url, topic, version, body, attempts, last_error, next_attempt_at, dead)
+VALUES
+    ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (id) DO
+UPDATE
+SET
+    url = EXCLUDED.url,
+    topic = EXCLUDED.topic,
+    version = EXCLUDED.version,
+    body = EXCLUDED.body,
+    attempts = EXCLUDED.attempts,
+    last_error = EXCLUDED.last_error,
+    next_attempt_at = EXCLUDED.next_attempt_at,
+    dead = EXCLUDED.dead;`,
+		d.ID, d.URL, d.Topic, d.Version, string(d.Body),
+		d.Attempts, nullEmptyString(d.LastError), d.NextAttemptAt.UTC(), d.Dead,
+	)
+	return err
+}
+
+func (s *SQLiteStorage) RetrieveWebhookDeliveries(ctx context.Context, filter *storage.WebhookDeliveryFilter) ([]*storage.WebhookDelivery, error) {
+	if filter == nil {
+		filter = &storage.WebhookDeliveryFilter{}
+	}
+	where := []string{`dead = $1`}
+	args := []interface{}{filter.Dead}
+	if len(filter.IDs) > 0 {
+		params := make([]string, len(filter.IDs))
+		for i, id := range filter.IDs {
+			args = append(args, id)
+			params[i] = "$" + strconv.Itoa(len(args))
+		}
+		where = append(where, `id IN (`+strings.Join(params, ", ")+`)`)
+	}
+	if !filter.DueBy.IsZero() {
+		args = append(args, filter.DueBy.UTC())
+		where = append(where, `next_attempt_at <= $`+strconv.Itoa(len(args)))
+	}
+	var limit string
+	if filter.Limit > 0 {
+		args = append(args, filter.Limit)
+		limit = ` LIMIT $` + strconv.Itoa(len(args))
+	}
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT id, url, topic, version, body, attempts, last_error, next_attempt_at, dead, created_at FROM webhook_deliveries WHERE `+strings.Join(where, " AND ")+` ORDER BY next_attempt_at, id`+limit+`;`,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var deliveries []*storage.WebhookDelivery
+	for rows.Next() {
+		d := new(storage.WebhookDelivery)
+		var body string
+		var lastError sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&d.ID, &d.URL, &d.Topic, &d.Version, &body, &d.Attempts, &lastError, &d.NextAttemptAt, &d.Dead, &createdAt); err != nil {
+			return nil, err
+		}
+		d.Body, d.LastError = []byte(body), lastError.String
+		d.NextAttemptAt = d.NextAttemptAt.UTC()
+		if createdAt.Valid {
+			d.CreatedAt = createdAt.Time.UTC()
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (s *SQLiteStorage) DeleteWebhookDelivery(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = $1;`, id)
+	return err
+}
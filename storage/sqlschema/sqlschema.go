@@ -0,0 +1,349 @@
+// Package sqlschema checks that a SQL database has the tables, columns,
+// and indexes of a storage backend's schema.
+//
+// The schema is parsed from the backend's schema.sql so that checks
+// always match the binary. Only the names of tables, columns, and
+// indexes are checked: the types, constraints, and index definitions
+// of existing tables are not.
+package sqlschema
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	// tables match the CREATE TABLE statements of schema.sql files.
+	tableRe = regexp.MustCompile(`(?ms)^CREATE TABLE (\w+)\s*\((.*?)^\);`)
+
+	// columns are lowercase names followed by an uppercase type. This
+	// skips table constraints and their continuation lines.
+	columnRe = regexp.MustCompile(`^\s*([a-z_][a-z0-9_]*)\s+([A-Z].*?),?\s*(?:--.*)?$`)
+
+	// statements of tables after their creation (e.g. indexes and
+	// triggers). SQLite trigger bodies end with "END;".
+	extraRe = regexp.MustCompile(`(?ms)^CREATE (?:UNIQUE )?(?:INDEX|TRIGGER) \w+ .*?\bON (\w+)\b.*?;(?:\s*END;)?`)
+
+	// indexes created after their tables.
+	indexRe = regexp.MustCompile(`(?ms)^CREATE (?:UNIQUE )?INDEX (\w+) ON (\w+)\b.*?;`)
+
+	// indexes defined in CREATE TABLE statements (MySQL). Unnamed
+	// indexes are named after their first column.
+	inlineIndexRe = regexp.MustCompile(`^\s*(UNIQUE\s+)?(?:INDEX|KEY)\s+(?:(\w+)\s+)?\(([^)]*)\)`)
+)
+
+// Column is a column of a table.
+type Column struct {
+	Name string
+
+	// Definition is the column definition after the name
+	// (e.g. "VARCHAR(255) NOT NULL").
+	Definition string
+}
+
+// migratable reports whether c can be added to existing tables. Only
+// columns that are nullable or have a default value are.
+func (c *Column) migratable() bool {
+	return !strings.Contains(c.Definition, "NOT NULL") || strings.Contains(c.Definition, "DEFAULT")
+}
+
+// Index is an index of a table.
+type Index struct {
+	Name string
+
+	// Create is the statement creating the index on an existing table.
+	Create string
+}
+
+// Table is a table of a schema.
+type Table struct {
+	Name    string
+	Columns []*Column
+	Indexes []*Index
+
+	// Create is the CREATE TABLE statement followed by any statements
+	// of the table after its creation.
+	Create []string
+}
+
+// Parse parses the tables of schema.
+func Parse(schema string) []*Table {
+	var tables []*Table
+	byName := make(map[string]*Table)
+	for _, m := range tableRe.FindAllStringSubmatch(schema, -1) {
+		table := &Table{Name: m[1], Create: []string{m[0]}}
+		for _, line := range strings.Split(m[2], "\n") {
+			if cm := columnRe.FindStringSubmatch(line); cm != nil {
+				table.Columns = append(table.Columns, &Column{Name: cm[1], Definition: cm[2]})
+			} else if im := inlineIndexRe.FindStringSubmatch(line); im != nil {
+				table.Indexes = append(table.Indexes, inlineIndex(table, im[1] != "", im[2], im[3]))
+			}
+		}
+		tables = append(tables, table)
+		byName[table.Name] = table
+	}
+	for _, m := range extraRe.FindAllStringSubmatch(schema, -1) {
+		if table, ok := byName[m[1]]; ok {
+			table.Create = append(table.Create, m[0])
+		}
+	}
+	for _, m := range indexRe.FindAllStringSubmatch(schema, -1) {
+		if table, ok := byName[m[2]]; ok {
+			table.Indexes = append(table.Indexes, &Index{Name: m[1], Create: m[0]})
+		}
+	}
+	return tables
+}
+
+// inlineIndex returns the index of a CREATE TABLE statement of table.
+// Unnamed indexes are named like MySQL does: after their first column
+// with a numeric suffix for duplicates.
+func inlineIndex(table *Table, unique bool, name, columns string) *Index {
+	if name == "" {
+		name = strings.Fields(strings.Split(columns, ",")[0])[0]
+		base := name
+		for i := 2; table.index(name) != nil; i++ {
+			name = fmt.Sprintf("%s_%d", base, i)
+		}
+	}
+	kind := "INDEX"
+	if unique {
+		kind = "UNIQUE INDEX"
+	}
+	return &Index{
+		Name:   name,
+		Create: fmt.Sprintf("ALTER TABLE %s ADD %s %s (%s)", table.Name, kind, name, columns),
+	}
+}
+
+// index returns the index name of t or nil if it has none.
+func (t *Table) index(name string) *Index {
+	for _, index := range t.Indexes {
+		if index.Name == name {
+			return index
+		}
+	}
+	return nil
+}
+
+// Report is the result of checking a database against a schema.
+type Report struct {
+	MissingTables []string `json:"missing_tables,omitempty"`
+
+	// MissingColumns are the missing columns of existing tables keyed
+	// by table name.
+	MissingColumns map[string][]string `json:"missing_columns,omitempty"`
+
+	// MissingIndexes are the missing indexes of existing tables keyed
+	// by table name.
+	MissingIndexes map[string][]string `json:"missing_indexes,omitempty"`
+}
+
+// OK reports whether no tables, columns, or indexes are missing.
+func (r *Report) OK() bool {
+	return len(r.MissingTables) < 1 && len(r.MissingColumns) < 1 && len(r.MissingIndexes) < 1
+}
+
+// qualified returns the sorted table-qualified names of names.
+func qualified(names map[string][]string) string {
+	var ret []string
+	for table, tableNames := range names {
+		for _, name := range tableNames {
+			ret = append(ret, table+"."+name)
+		}
+	}
+	sort.Strings(ret)
+	return strings.Join(ret, ", ")
+}
+
+// Err returns an error describing the missing tables, columns, and
+// indexes or nil if none are missing.
+func (r *Report) Err() error {
+	if r.OK() {
+		return nil
+	}
+	var parts []string
+	if len(r.MissingTables) > 0 {
+		parts = append(parts, "missing tables: "+strings.Join(r.MissingTables, ", "))
+	}
+	if len(r.MissingColumns) > 0 {
+		parts = append(parts, "missing columns: "+qualified(r.MissingColumns))
+	}
+	if len(r.MissingIndexes) > 0 {
+		parts = append(parts, "missing indexes: "+qualified(r.MissingIndexes))
+	}
+	return fmt.Errorf("database schema does not match this version (apply the schema changes for this version): %s", strings.Join(parts, "; "))
+}
+
+// Checker checks a database against the tables of a schema.
+type Checker struct {
+	db     *sql.DB
+	tables []*Table
+
+	// query selects the table and column names of the database.
+	query string
+
+	// indexQuery selects the table and index names of the database.
+	indexQuery string
+}
+
+// New creates a new Checker of db against tables. The columns of the
+// database are selected by query which must return table and column
+// name rows. The indexes of the database are selected by indexQuery
+// which must return table and index name rows. Indexes are not checked
+// with an empty indexQuery.
+func New(db *sql.DB, tables []*Table, query, indexQuery string) *Checker {
+	return &Checker{db: db, tables: tables, query: query, indexQuery: indexQuery}
+}
+
+// columns returns the column names of the database keyed by table.
+func (c *Checker) columns(ctx context.Context) (map[string]map[string]bool, error) {
+	return c.names(ctx, c.query)
+}
+
+// indexes returns the index names of the database keyed by table.
+func (c *Checker) indexes(ctx context.Context) (map[string]map[string]bool, error) {
+	if c.indexQuery == "" {
+		return nil, nil
+	}
+	return c.names(ctx, c.indexQuery)
+}
+
+// names returns the (lowercase) names of the table and name rows of
+// query keyed by table.
+func (c *Checker) names(ctx context.Context, query string) (map[string]map[string]bool, error) {
+	rows, err := c.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[string]map[string]bool)
+	for rows.Next() {
+		var table, name string
+		if err = rows.Scan(&table, &name); err != nil {
+			return nil, err
+		}
+		table, name = strings.ToLower(table), strings.ToLower(name)
+		if ret[table] == nil {
+			ret[table] = make(map[string]bool)
+		}
+		ret[table][name] = true
+	}
+	return ret, rows.Err()
+}
+
+// Check checks the database for missing tables, columns, and indexes.
+func (c *Checker) Check(ctx context.Context) (*Report, error) {
+	columns, err := c.columns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving columns: %w", err)
+	}
+	indexes, err := c.indexes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("retrieving indexes: %w", err)
+	}
+	report := new(Report)
+	for _, table := range c.tables {
+		have, ok := columns[table.Name]
+		if !ok {
+			report.MissingTables = append(report.MissingTables, table.Name)
+			continue
+		}
+		for _, col := range table.Columns {
+			if !have[col.Name] {
+				if report.MissingColumns == nil {
+					report.MissingColumns = make(map[string][]string)
+				}
+				report.MissingColumns[table.Name] = append(report.MissingColumns[table.Name], col.Name)
+			}
+		}
+		if indexes == nil {
+			continue
+		}
+		for _, index := range table.Indexes {
+			if !indexes[table.Name][strings.ToLower(index.Name)] {
+				if report.MissingIndexes == nil {
+					report.MissingIndexes = make(map[string][]string)
+				}
+				report.MissingIndexes[table.Name] = append(report.MissingIndexes[table.Name], index.Name)
+			}
+		}
+	}
+	return report, nil
+}
+
+// Migrate creates missing tables, adds missing columns that are
+// nullable or have a default value, and creates missing indexes. The
+// constraints and existing indexes of existing tables are not changed.
+// The report of the database after migrating is returned.
+func (c *Checker) Migrate(ctx context.Context) (*Report, error) {
+	report, err := c.Check(ctx)
+	if err != nil || report.OK() {
+		return report, err
+	}
+	missing := make(map[string]bool)
+	for _, name := range report.MissingTables {
+		missing[name] = true
+	}
+	for _, table := range c.tables {
+		if missing[table.Name] {
+			for _, stmt := range table.Create {
+				if _, err = c.db.ExecContext(ctx, stmt); err != nil {
+					return nil, fmt.Errorf("creating table %s: %w", table.Name, err)
+				}
+			}
+			continue
+		}
+		cols := make(map[string]bool)
+		for _, name := range report.MissingColumns[table.Name] {
+			cols[name] = true
+		}
+		for _, col := range table.Columns {
+			if !cols[col.Name] || !col.migratable() {
+				continue
+			}
+			stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table.Name, col.Name, col.Definition)
+			if _, err = c.db.ExecContext(ctx, stmt); err != nil {
+				return nil, fmt.Errorf("adding column %s.%s: %w", table.Name, col.Name, err)
+			}
+		}
+		// indexes after columns as they may index added columns
+		for _, name := range report.MissingIndexes[table.Name] {
+			if _, err = c.db.ExecContext(ctx, table.index(name).Create); err != nil {
+				return nil, fmt.Errorf("creating index %s.%s: %w", table.Name, name, err)
+			}
+		}
+	}
+	return c.Check(ctx)
+}
+
+// Empty reports whether none of the tables exist in the database.
+func (c *Checker) Empty(ctx context.Context) (bool, error) {
+	columns, err := c.columns(ctx)
+	if err != nil {
+		return false, fmt.Errorf("retrieving columns: %w", err)
+	}
+	for _, table := range c.tables {
+		if _, ok := columns[table.Name]; ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Drop drops the tables (and with them their data) from the database.
+// Tables are dropped in the reverse order of their creation so that
+// tables are dropped before the tables they reference.
+func (c *Checker) Drop(ctx context.Context) error {
+	for i := len(c.tables) - 1; i >= 0; i-- {
+		name := c.tables[i].Name
+		if _, err := c.db.ExecContext(ctx, "DROP TABLE IF EXISTS "+name); err != nil {
+			return fmt.Errorf("dropping table %s: %w", name, err)
+		}
+	}
+	return nil
+}
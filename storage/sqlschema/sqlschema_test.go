@@ -0,0 +1,84 @@
+package sqlschema
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, path := range []string{"../mysql/schema.sql", "../pgsql/schema.sql", "../sqlite/schema.sql"} {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := string(b)
+		tables := Parse(schema)
+		if have, want := len(tables), strings.Count(schema, "CREATE TABLE "); have != want {
+			t.Errorf("%s: tables: have %d, want %d", path, have, want)
+		}
+		for _, table := range tables {
+			if table.Name != "devices" {
+				continue
+			}
+			cols := make(map[string]string)
+			for _, col := range table.Columns {
+				cols[col.Name] = col.Definition
+			}
+			if have, want := len(cols), 13; have != want {
+				t.Errorf("%s: devices columns: have %d, want %d: %v", path, have, want, cols)
+			}
+			if def := cols["authenticate"]; !strings.HasPrefix(def, "TEXT") || !strings.HasSuffix(def, "NOT NULL") {
+				t.Errorf("%s: unexpected definition: %q", path, def)
+			}
+			if (&Column{Definition: cols["authenticate"]}).migratable() || !(&Column{Definition: cols["updated_at"]}).migratable() {
+				t.Errorf("%s: unexpected migratable columns", path)
+			}
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := new(Report)
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	report.MissingTables = []string{"command_owners"}
+	report.MissingColumns = map[string][]string{"devices": {"serial_number"}}
+	report.MissingIndexes = map[string][]string{"commands": {"not_before"}}
+	err := report.Err()
+	if err == nil || !strings.Contains(err.Error(), "command_owners") || !strings.Contains(err.Error(), "devices.serial_number") || !strings.Contains(err.Error(), "commands.not_before") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseIndexes(t *testing.T) {
+	for _, test := range []struct {
+		path   string
+		table  string
+		name   string
+		create string
+	}{
+		{"../mysql/schema.sql", "commands", "not_before", "ALTER TABLE commands ADD INDEX not_before (not_before)"},
+		{"../mysql/schema.sql", "enrollment_queue", "priority", "ALTER TABLE enrollment_queue ADD INDEX priority (priority DESC, created_at)"},
+		{"../mysql/schema.sql", "enrollment_queue", "idx_queue_next", "ALTER TABLE enrollment_queue ADD INDEX idx_queue_next (id, active, priority DESC, created_at)"},
+		{"../pgsql/schema.sql", "commands", "idx_commands_not_before", "CREATE INDEX idx_commands_not_before ON commands (not_before);"},
+		{"../sqlite/schema.sql", "commands", "idx_commands_not_before", "CREATE INDEX idx_commands_not_before ON commands (not_before);"},
+	} {
+		b, err := os.ReadFile(test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index *Index
+		for _, table := range Parse(string(b)) {
+			if table.Name == test.table {
+				index = table.index(test.name)
+			}
+		}
+		if index == nil {
+			t.Errorf("%s: missing index %s.%s", test.path, test.table, test.name)
+		} else if index.Create != test.create {
+			t.Errorf("%s: create: have %q, want %q", test.path, index.Create, test.create)
+		}
+	}
+}
@@ -5,6 +5,9 @@ package storage
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
+	"reflect"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 )
@@ -13,6 +16,26 @@ type UserAuthenticateStore interface {
 	StoreUserAuthenticate(r *mdm.Request, msg *mdm.UserAuthenticate) error
 }
 
+// Reasons an enrollment may be disabled.
+const (
+	// DisableReasonCheckOut is for a device that sent a CheckOut message.
+	DisableReasonCheckOut = "CheckOut"
+	// DisableReasonAuthenticate is for an enrollment that is superseded
+	// by a new Authenticate message (i.e. a re-enrollment).
+	DisableReasonAuthenticate = "Authenticate"
+	// DisableReasonAdmin is for an enrollment disabled by an operator.
+	DisableReasonAdmin = "Admin"
+	// DisableReasonPushInvalid is for an enrollment whose push token
+	// was reported as invalid by APNs.
+	DisableReasonPushInvalid = "PushTokenInvalid"
+	// DisableReasonCleanup is for an enrollment disabled by a cleanup
+	// (e.g. inactivity pruning) job.
+	DisableReasonCleanup = "Cleanup"
+	// DisableReasonDuplicate is for an enrollment whose device (serial
+	// number) Authenticated as a different enrollment.
+	DisableReasonDuplicate = "Duplicate"
+)
+
 // CheckinStore stores MDM check-in data.
 type CheckinStore interface {
 	StoreAuthenticate(r *mdm.Request, msg *mdm.Authenticate) error
@@ -21,9 +44,37 @@ type CheckinStore interface {
 	UserAuthenticateStore
 }
 
+// DisableReasonStore disables enrollments recording why. Storage
+// decorators that override Disable should implement it too.
+type DisableReasonStore interface {
+	// DisableWithReason disables the device channel enrollment in r and
+	// any of its user channel enrollments like Disable. The reason (see
+	// the DisableReason constants) is recorded with the time of
+	// disablement.
+	DisableWithReason(r *mdm.Request, reason string) error
+}
+
+// DisableWithReason disables the enrollment in r recording reason if
+// store (or a storage it decorates, see As) is a DisableReasonStore.
+// Otherwise the enrollment is disabled with Disable.
+func DisableWithReason(store CheckinStore, r *mdm.Request, reason string) error {
+	var disabler DisableReasonStore
+	if As(store, &disabler) {
+		return disabler.DisableWithReason(r, reason)
+	}
+	return store.Disable(r)
+}
+
 // CommandAndReportResultsStore stores and retrieves MDM command queue data.
 type CommandAndReportResultsStore interface {
 	StoreCommandReport(r *mdm.Request, report *mdm.CommandResults) error
+
+	// RetrieveNextCommand retrieves the next queued command for r.
+	// Backends with CapabilityCommandExpiration skip expired commands
+	// and deactivate them in the queue (as ClearQueue does). Backends
+	// with CapabilityCommandScheduling skip commands that are not yet
+	// due but leave them in the queue. Backends with
+	// CapabilityCommandPriority retrieve higher priority commands first.
 	RetrieveNextCommand(r *mdm.Request, skipNotNow bool) (*mdm.Command, error)
 	ClearQueue(r *mdm.Request) error
 }
@@ -56,7 +107,15 @@ type PushCertStore interface {
 	StorePushCert(ctx context.Context, pemCert, pemKey []byte) error
 }
 
-// CommandEnqueuer is able to enqueue MDM commands.
+// PushCertLister lists the topics of stored APNs push certificates.
+type PushCertLister interface {
+	RetrievePushCertTopics(ctx context.Context) ([]string, error)
+}
+
+// CommandEnqueuer is able to enqueue MDM commands. Backends with
+// CapabilityCommandExpiration store the ExpiresAt of the command,
+// backends with CapabilityCommandScheduling its NotBefore, and backends
+// with CapabilityCommandPriority its Priority.
 type CommandEnqueuer interface {
 	EnqueueCommand(ctx context.Context, id []string, cmd *mdm.Command) (map[string]error, error)
 }
@@ -89,3 +148,1044 @@ type StoreMigrator interface {
 type TokenUpdateTallyStore interface {
 	RetrieveTokenUpdateTally(ctx context.Context, id string) (int, error)
 }
+
+// DMEnablement is the Declarative Management activity of an enrollment.
+type DMEnablement struct {
+	// LastEndpoint is the endpoint of the last DeclarativeManagement check-in.
+	LastEndpoint  string    `json:"last_endpoint"`
+	LastCheckinAt time.Time `json:"last_checkin_at"`
+
+	// DeclarationsToken is the last declarations sync token sent to
+	// the enrollment (in response to the "tokens" endpoint), if any.
+	DeclarationsToken   string     `json:"declarations_token,omitempty"`
+	DeclarationsTokenAt *time.Time `json:"declarations_token_at,omitempty"`
+}
+
+// DMEnablementStore tracks which enrollments have activated Declarative Management.
+type DMEnablementStore interface {
+	// StoreDMEnablement records a DeclarativeManagement check-in to
+	// endpoint for the enrollment in r. The declarationsToken is only
+	// updated if it is not empty.
+	StoreDMEnablement(r *mdm.Request, endpoint, declarationsToken string) error
+
+	// RetrieveDMEnablements retrieves the Declarative Management
+	// activity for ids. Enrollments that have never issued a
+	// DeclarativeManagement check-in are not returned. If ids is empty
+	// then all enrollments with Declarative Management activity are
+	// returned.
+	RetrieveDMEnablements(ctx context.Context, ids []string) (map[string]*DMEnablement, error)
+}
+
+// EnrollmentMetadata is operator-supplied metadata about an enrollment.
+type EnrollmentMetadata struct {
+	Tenant string   `json:"tenant,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// EnrollmentMetadataStore stores and retrieves enrollment metadata.
+type EnrollmentMetadataStore interface {
+	// StoreEnrollmentMetadata replaces the metadata for id.
+	StoreEnrollmentMetadata(ctx context.Context, id string, meta *EnrollmentMetadata) error
+
+	// RetrieveEnrollmentMetadata retrieves the metadata for id.
+	// A nil metadata and nil error are returned if id has no metadata.
+	RetrieveEnrollmentMetadata(ctx context.Context, id string) (*EnrollmentMetadata, error)
+}
+
+// Enrollment is a summary of an MDM enrollment.
+type Enrollment struct {
+	ID       string `json:"id"`
+	DeviceID string `json:"device_id"`
+	UserID   string `json:"user_id,omitempty"`
+	// UserShortName is the Managed Apple ID for Shared iPad.
+	UserShortName string `json:"user_short_name,omitempty"`
+	Type          string `json:"type"`
+	Topic         string `json:"topic"`
+	Enabled       bool   `json:"enabled"`
+
+	LastSeenAt time.Time `json:"last_seen_at"`
+
+	// DisableReason and DisabledAt are from the most recent time the
+	// enrollment was disabled, if ever. They are retained after an
+	// enrollment is re-enabled.
+	DisableReason string     `json:"disable_reason,omitempty"`
+	DisabledAt    *time.Time `json:"disabled_at,omitempty"`
+}
+
+// EnrollmentFilter selects enrollments. Empty fields are not filtered on.
+type EnrollmentFilter struct {
+	IDs      []string
+	DeviceID string // enrollments with this device (parent) ID
+	Enabled  *bool
+
+	Limit  int // 0 is unlimited
+	Offset int
+}
+
+// EnrollmentRetriever retrieves enrollments.
+type EnrollmentRetriever interface {
+	// RetrieveEnrollments retrieves enrollments matching filter ordered
+	// by enrollment ID. A nil filter selects all enrollments.
+	RetrieveEnrollments(ctx context.Context, filter *EnrollmentFilter) ([]*Enrollment, error)
+}
+
+// AuthenticateAliases returns the distinct non-empty serial number,
+// UDID, and EnrollmentID of msg. These are the aliases by which the enrollment
+// of an Authenticate message may be looked up.
+func AuthenticateAliases(msg *mdm.Authenticate) []string {
+	var aliases []string
+	seen := make(map[string]bool)
+	for _, alias := range []string{msg.SerialNumber, msg.UDID, msg.EnrollmentID} {
+		if alias != "" && !seen[alias] {
+			aliases = append(aliases, alias)
+			seen[alias] = true
+		}
+	}
+	return aliases
+}
+
+// EnrollmentAliasResolver resolves enrollment aliases to enrollment IDs.
+type EnrollmentAliasResolver interface {
+	// ResolveEnrollmentIDs resolves each of aliases to an enrollment
+	// ID. An alias may be an enrollment ID or the serial number, UDID,
+	// or EnrollmentID of a device from its Authenticate message.
+	// Enrollment IDs resolve to themselves and take precedence over
+	// other aliases. Aliases that do not resolve are not returned.
+	ResolveEnrollmentIDs(ctx context.Context, aliases []string) (map[string]string, error)
+}
+
+// UserSession is a user of a device's user channel, such as a user
+// logged in to a Shared iPad.
+type UserSession struct {
+	// EnrollmentID is the user channel enrollment ID.
+	EnrollmentID string `json:"enrollment_id"`
+	// UserShortName is the Managed Apple ID for Shared iPad.
+	UserShortName string `json:"user_short_name,omitempty"`
+	UserLongName  string `json:"user_long_name,omitempty"`
+
+	StartedAt  time.Time `json:"started_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+}
+
+// UserSessions are the current and previous user sessions of a device.
+type UserSessions struct {
+	Current  *UserSession `json:"current"`
+	Previous *UserSession `json:"previous,omitempty"`
+}
+
+// UserSessionStore stores and retrieves the user sessions of devices.
+type UserSessionStore interface {
+	// StoreUserSession records the user of the user channel enrollment
+	// in r as the current user of its device (r.ParentID). If the
+	// current session of the device is for a different enrollment then
+	// it becomes the previous session and a new session is started.
+	StoreUserSession(r *mdm.Request, userShortName, userLongName string) error
+
+	// RetrieveUserSessions retrieves the user sessions of deviceIDs.
+	// Devices without a user session are not returned.
+	RetrieveUserSessions(ctx context.Context, deviceIDs []string) (map[string]*UserSessions, error)
+}
+
+// TopicStats are the enrollment and command queue counts of an APNs topic.
+type TopicStats struct {
+	Topic             string `json:"topic"`
+	Enrollments       int    `json:"enrollments"`
+	ActiveEnrollments int    `json:"active_enrollments"`
+
+	// PendingCommands is the number of queued commands that have
+	// not received a result (or have received a NotNow result).
+	PendingCommands int `json:"pending_commands"`
+}
+
+// TopicStatsRetriever retrieves per-topic enrollment statistics.
+type TopicStatsRetriever interface {
+	// RetrieveTopicStats retrieves the statistics of every APNs topic
+	// with at least one enrollment ordered by topic.
+	RetrieveTopicStats(ctx context.Context) ([]*TopicStats, error)
+}
+
+// Statuses of an enrollment targeted by a job.
+const (
+	JobStatusQueued       = "queued"
+	JobStatusDelivered    = "delivered"
+	JobStatusAcknowledged = "acknowledged"
+	JobStatusErrored      = "errored"
+)
+
+// JobCounts are the number of targeted enrollments for each job status.
+type JobCounts struct {
+	Total        int `json:"total"`
+	Queued       int `json:"queued"`
+	Delivered    int `json:"delivered"`
+	Acknowledged int `json:"acknowledged"`
+	Errored      int `json:"errored"`
+}
+
+// Add adds n enrollments of status to the counts.
+func (c *JobCounts) Add(status string, n int) {
+	c.Total += n
+	switch status {
+	case JobStatusQueued:
+		c.Queued += n
+	case JobStatusDelivered:
+		c.Delivered += n
+	case JobStatusAcknowledged:
+		c.Acknowledged += n
+	case JobStatusErrored:
+		c.Errored += n
+	}
+}
+
+// Job is a tracked bulk enqueue or push operation.
+type Job struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+
+	// CommandUUID and RequestType are empty for push-only jobs.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	RequestType string `json:"request_type,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	Counts    JobCounts `json:"counts"`
+}
+
+// JobStore stores and retrieves jobs and the status of their targets.
+type JobStore interface {
+	// StoreJob creates job targeting ids, each with the queued status.
+	// The job CreatedAt and Counts are ignored.
+	StoreJob(ctx context.Context, job *Job, ids []string) error
+
+	// UpdateJobTargets sets the status of ids of job jobID.
+	UpdateJobTargets(ctx context.Context, jobID, status string, ids []string) error
+
+	// UpdateJobCommandTarget sets the status of enrollment id for any
+	// jobs of commandUUID. Statuses only progress: the delivered status
+	// is only set for queued targets and the acknowledged and errored
+	// statuses only for queued or delivered targets.
+	UpdateJobCommandTarget(ctx context.Context, id, commandUUID, status string) error
+
+	// RetrieveJob retrieves job jobID including its counts.
+	// A nil job and nil error are returned if the job is not found.
+	RetrieveJob(ctx context.Context, jobID string) (*Job, error)
+
+	// RetrieveJobTargets retrieves the status of each target of job jobID.
+	RetrieveJobTargets(ctx context.Context, jobID string) (map[string]string, error)
+}
+
+// LogEvent is an entry of the event log.
+type LogEvent struct {
+	// Seq is the sequence number of the event in the log. Sequence
+	// numbers always increase but may have gaps.
+	Seq int64 `json:"seq"`
+
+	// Topic is the kind of event using the same names as the webhook
+	// (e.g. "mdm.Authenticate" or "mdm.Connect").
+	Topic        string `json:"topic"`
+	EnrollmentID string `json:"enrollment_id"`
+
+	// CommandUUID and Status are set for command events.
+	CommandUUID string `json:"command_uuid,omitempty"`
+	Status      string `json:"status,omitempty"`
+
+	// RawPayload is the MDM check-in or command report plist, if any.
+	RawPayload []byte `json:"raw_payload,omitempty"`
+
+	// CertHash is the hash of the identity certificate of check-in
+	// events, if any.
+	CertHash string `json:"cert_hash,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// EventLogStore stores and retrieves the ordered event log.
+type EventLogStore interface {
+	// StoreLogEvent appends event to the event log. The event Seq and
+	// CreatedAt are ignored; Seq is set to the newly assigned sequence
+	// number when successfully stored.
+	StoreLogEvent(ctx context.Context, event *LogEvent) error
+
+	// RetrieveLogEvents retrieves up to limit events with a sequence
+	// number greater than after in sequence order.
+	RetrieveLogEvents(ctx context.Context, after int64, limit int) ([]*LogEvent, error)
+}
+
+// Metrics rollup periods.
+const (
+	RollupHour = "hour"
+	RollupDay  = "day"
+)
+
+// MetricsRollup is the MDM activity of one rollup period.
+type MetricsRollup struct {
+	// Period is RollupHour or RollupDay.
+	Period string `json:"period"`
+	// Start is the UTC start of the period.
+	Start time.Time `json:"start"`
+
+	// Checkins is the number of check-in messages.
+	Checkins int64 `json:"checkins"`
+	// Commands is the number of command results reported (excluding
+	// Idle) and Errors is how many of those had an Error status.
+	Commands int64 `json:"commands"`
+	Errors   int64 `json:"errors"`
+}
+
+// MetricsRollupStore stores and retrieves metrics rollups.
+type MetricsRollupStore interface {
+	// AddMetricsRollup adds the counts of rollup to the stored rollup
+	// of the same period and start, creating it if needed.
+	AddMetricsRollup(ctx context.Context, rollup *MetricsRollup) error
+
+	// RetrieveMetricsRollups retrieves the rollups of period starting
+	// at or after since ordered by start.
+	RetrieveMetricsRollups(ctx context.Context, period string, since time.Time) ([]*MetricsRollup, error)
+}
+
+// APIKey is a named API key with a role.
+type APIKey struct {
+	// Name is the API key name, used as the HTTP basic auth username.
+	Name string `json:"name"`
+	// Role is the name of the role of the API key.
+	Role string `json:"role"`
+	// Tenant limits tenant-scoped roles to enrollments of this tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// SecretHash is the hex SHA-256 hash of the API key secret.
+	SecretHash string `json:"secret_hash,omitempty"`
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// APIKeyStore stores and retrieves API keys.
+type APIKeyStore interface {
+	// StoreAPIKey stores key, replacing any key with the same name.
+	StoreAPIKey(ctx context.Context, key *APIKey) error
+
+	// RetrieveAPIKey retrieves the API key name.
+	// A nil key and nil error are returned if the key is not found.
+	RetrieveAPIKey(ctx context.Context, name string) (*APIKey, error)
+
+	// RetrieveAPIKeys retrieves all API keys ordered by name.
+	RetrieveAPIKeys(ctx context.Context) ([]*APIKey, error)
+
+	// DeleteAPIKey deletes the API key name.
+	DeleteAPIKey(ctx context.Context, name string) error
+}
+
+// PendingCommand is an enqueued command awaiting approval.
+type PendingCommand struct {
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type"`
+	// Targets are the enqueue targets (typically enrollment IDs).
+	Targets []string `json:"targets"`
+	// NoPush is true if no APNs push is to be sent once approved.
+	NoPush bool `json:"no_push,omitempty"`
+	// ExpiresAt, NotBefore, and Priority are the enqueue options of
+	// the command once approved.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Priority  int        `json:"priority,omitempty"`
+	// Template and Locale select the message template substituted into
+	// the command once approved.
+	Template string `json:"template,omitempty"`
+	Locale   string `json:"locale,omitempty"`
+	// Owner is the command owner receiving the command results.
+	Owner string `json:"owner,omitempty"`
+	// RequestedBy is the API user that enqueued the command.
+	RequestedBy string `json:"requested_by,omitempty"`
+	// Command is the raw command plist.
+	Command []byte `json:"command,omitempty"`
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// PendingCommandStore stores commands awaiting approval.
+type PendingCommandStore interface {
+	// StorePendingCommand stores cmd, replacing any pending command
+	// with the same command UUID.
+	StorePendingCommand(ctx context.Context, cmd *PendingCommand) error
+
+	// RetrievePendingCommand retrieves the pending command uuid.
+	// A nil command and nil error are returned if it is not found.
+	RetrievePendingCommand(ctx context.Context, uuid string) (*PendingCommand, error)
+
+	// RetrievePendingCommands retrieves all pending commands ordered
+	// by creation.
+	RetrievePendingCommands(ctx context.Context) ([]*PendingCommand, error)
+
+	// DeletePendingCommand deletes the pending command uuid. It
+	// reports whether the command was deleted so that concurrent
+	// approvals or rejections can only claim a command once.
+	DeletePendingCommand(ctx context.Context, uuid string) (bool, error)
+}
+
+// EnrollmentFreeze blocks new commands and pushes to an enrollment
+// (e.g. during a legal hold or investigation).
+type EnrollmentFreeze struct {
+	ID string `json:"id"`
+	// Reason is a free-form description of why the enrollment is frozen.
+	Reason string `json:"reason,omitempty"`
+	// FrozenBy is the API user that froze the enrollment.
+	FrozenBy string `json:"frozen_by,omitempty"`
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// EnrollmentFreezeStore stores enrollment freezes.
+type EnrollmentFreezeStore interface {
+	// StoreEnrollmentFreeze freezes the enrollment, replacing any
+	// existing freeze of the same enrollment.
+	StoreEnrollmentFreeze(ctx context.Context, freeze *EnrollmentFreeze) error
+
+	// RetrieveEnrollmentFreezes retrieves the freezes of the frozen
+	// enrollments among ids keyed by enrollment ID. All freezes are
+	// retrieved if ids is empty.
+	RetrieveEnrollmentFreezes(ctx context.Context, ids []string) (map[string]*EnrollmentFreeze, error)
+
+	// DeleteEnrollmentFreeze unfreezes the enrollment id.
+	DeleteEnrollmentFreeze(ctx context.Context, id string) error
+}
+
+// Inventory change types.
+const (
+	InventoryAdded   = "added"
+	InventoryChanged = "changed"
+	InventoryRemoved = "removed"
+)
+
+// InventoryChange is a change of an inventory value between snapshots.
+type InventoryChange struct {
+	// Source is the command request type of the inventory (e.g.
+	// "SecurityInfo").
+	Source string `json:"source"`
+	// Key is the flattened key of the value (e.g. "FDE_Enabled").
+	Key string `json:"key"`
+	// Change is the type of change (e.g. InventoryChanged).
+	Change   string `json:"change"`
+	Previous string `json:"previous,omitempty"`
+	Current  string `json:"current,omitempty"`
+}
+
+// InventoryStore stores the latest inventory snapshots of enrollments.
+// A snapshot is the flattened values of a command result keyed by
+// inventory key.
+type InventoryStore interface {
+	// StoreInventory replaces the snapshot of source (a command request
+	// type) for enrollment id with values.
+	StoreInventory(ctx context.Context, id, source string, values map[string]string) error
+
+	// RetrieveInventory retrieves the snapshot of source for id.
+	// A nil map and nil error are returned if there is none.
+	RetrieveInventory(ctx context.Context, id, source string) (map[string]string, error)
+}
+
+// QueuedCommand is a command in the queue of an enrollment with its
+// latest result, if any.
+type QueuedCommand struct {
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type"`
+
+	// Active is false for commands of cleared queues.
+	Active bool `json:"active"`
+
+	// Status is the status of the latest result (e.g. "NotNow"),
+	// StatusExpired if the command expired before it was completed, or
+	// empty if the command has no result.
+	Status string `json:"status,omitempty"`
+
+	// Command and Result are the raw command and result plists.
+	Command []byte `json:"command"`
+	Result  []byte `json:"result,omitempty"`
+
+	// CreatedAt is when the command was queued for the enrollment.
+	CreatedAt time.Time `json:"created_at"`
+
+	// ExpiresAt is when the command expires, if it does.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	// NotBefore is when the command is scheduled, if it is.
+	NotBefore *time.Time `json:"not_before,omitempty"`
+
+	// Priority is the priority of the command in the queue.
+	Priority int `json:"priority,omitempty"`
+}
+
+// StatusExpired is the status of queued commands that expired before
+// they were completed. It is not an MDM command result status.
+const StatusExpired = "Expired"
+
+// SetExpiry sets the expiry of c to expiresAt (if not nil) and sets its
+// status to StatusExpired if it expired at now without being completed.
+func (c *QueuedCommand) SetExpiry(expiresAt *time.Time, now time.Time) {
+	if expiresAt == nil {
+		return
+	}
+	c.ExpiresAt = expiresAt
+	if (c.Status == "" || c.Status == "NotNow") && !now.Before(*expiresAt) {
+		c.Status = StatusExpired
+	}
+}
+
+// QueueRetriever retrieves enrollment command queues.
+type QueueRetriever interface {
+	// RetrieveQueue retrieves the commands queued for enrollment id in
+	// queue order. Commands that have results are included for as long
+	// as the storage backend retains them.
+	RetrieveQueue(ctx context.Context, id string) ([]*QueuedCommand, error)
+}
+
+// CommandOwnerStore stores the owners of commands. Owners are the
+// names of external systems that enqueued commands.
+type CommandOwnerStore interface {
+	// StoreCommandOwner sets owner as the owner of the command uuid.
+	StoreCommandOwner(ctx context.Context, uuid, owner string) error
+
+	// RetrieveCommandOwner retrieves the owner of the command uuid.
+	// An empty owner and nil error are returned if there is none.
+	RetrieveCommandOwner(ctx context.Context, uuid string) (string, error)
+}
+
+// QueueLocker serializes the command queue operations of enrollments
+// (e.g. storing a command report and retrieving the next command) so
+// that concurrent requests of an enrollment are handled in order.
+type QueueLocker interface {
+	// LockQueue blocks until the command queue of enrollment id is
+	// locked and returns the function that unlocks it. Backends not
+	// configured to serialize queue operations return immediately.
+	LockQueue(ctx context.Context, id string) (unlock func(), err error)
+}
+
+// EnrollmentSupersession records that an enrollment is superseded by
+// another (e.g. a replaced or re-enrolled device) for continuity.
+type EnrollmentSupersession struct {
+	ID string `json:"id"`
+	// SupersededBy is the ID of the enrollment superseding ID.
+	SupersededBy string `json:"superseded_by"`
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// EnrollmentSupersessionStore stores enrollment supersessions.
+type EnrollmentSupersessionStore interface {
+	// StoreEnrollmentSupersession stores ss, replacing any existing
+	// supersession of the same enrollment.
+	StoreEnrollmentSupersession(ctx context.Context, ss *EnrollmentSupersession) error
+
+	// RetrieveEnrollmentSupersessions retrieves the supersessions of the
+	// superseded enrollments among ids keyed by enrollment ID.
+	RetrieveEnrollmentSupersessions(ctx context.Context, ids []string) (map[string]*EnrollmentSupersession, error)
+}
+
+// EnrollmentEviction marks an enrollment for eviction: its check-ins
+// and command polls are answered with an HTTP 410 which prompts the
+// device to remove its MDM enrollment profile.
+type EnrollmentEviction struct {
+	ID string `json:"id"`
+	// Reason is a free-form description of why the enrollment is evicted.
+	Reason string `json:"reason,omitempty"`
+	// EvictedBy is the API user that evicted the enrollment.
+	EvictedBy string `json:"evicted_by,omitempty"`
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+	// DeliveredAt is when the enrollment first received the HTTP 410.
+	// It is nil if the eviction has not yet been delivered.
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+}
+
+// EnrollmentEvictionStore stores enrollment evictions.
+type EnrollmentEvictionStore interface {
+	// StoreEnrollmentEviction evicts the enrollment, replacing any
+	// existing eviction (and its delivery) of the same enrollment.
+	StoreEnrollmentEviction(ctx context.Context, eviction *EnrollmentEviction) error
+
+	// RetrieveEnrollmentEvictions retrieves the evictions of the evicted
+	// enrollments among ids keyed by enrollment ID. All evictions are
+	// retrieved if ids is empty.
+	RetrieveEnrollmentEvictions(ctx context.Context, ids []string) (map[string]*EnrollmentEviction, error)
+
+	// MarkEnrollmentEvictionDelivered records the delivery of the
+	// eviction of id. It reports whether this was the first delivery
+	// so that the delivery of an eviction is only reported once.
+	MarkEnrollmentEvictionDelivered(ctx context.Context, id string) (bool, error)
+
+	// DeleteEnrollmentEviction deletes the eviction of id.
+	DeleteEnrollmentEviction(ctx context.Context, id string) error
+}
+
+// EnrollmentTombstone is the minimal record kept of a departed device
+// enrollment (e.g. a retired or evicted device) for auditing once its
+// stored data has been purged.
+type EnrollmentTombstone struct {
+	ID           string `json:"id"`
+	SerialNumber string `json:"serial_number,omitempty"`
+	Type         string `json:"type,omitempty"`
+	// Reason is a free-form description of why the enrollment departed.
+	Reason string `json:"reason,omitempty"`
+	// DepartedBy is the API user that departed the enrollment.
+	DepartedBy string `json:"departed_by,omitempty"`
+
+	// EnrolledAt is when the device last enrolled (Authenticated).
+	EnrolledAt *time.Time `json:"enrolled_at,omitempty"`
+	LastSeenAt *time.Time `json:"last_seen_at,omitempty"`
+	// DepartedAt is set by storage.
+	DepartedAt time.Time `json:"departed_at,omitempty"`
+}
+
+// EnrollmentTombstoneStore departs enrollments and stores their tombstones.
+type EnrollmentTombstoneStore interface {
+	// DepartEnrollment purges the stored data of the device enrollment
+	// of tombstone.ID and of its user channel enrollments and stores
+	// tombstone in their place, replacing any existing tombstone. The
+	// serial number, type, and dates of tombstone are taken from the
+	// purged enrollment. Commands, the event log, and job targets are
+	// retained. A nil tombstone is returned if tombstone.ID is not a
+	// device enrollment.
+	DepartEnrollment(ctx context.Context, tombstone *EnrollmentTombstone) (*EnrollmentTombstone, error)
+
+	// RetrieveEnrollmentTombstones retrieves the tombstones of the
+	// departed enrollments among ids keyed by enrollment ID. All
+	// tombstones are retrieved if ids is empty.
+	RetrieveEnrollmentTombstones(ctx context.Context, ids []string) (map[string]*EnrollmentTombstone, error)
+
+	// DeleteEnrollmentTombstone deletes the tombstone of id (e.g. at
+	// the end of its retention period).
+	DeleteEnrollmentTombstone(ctx context.Context, id string) error
+}
+
+// WebhookDelivery is a webhook event that failed to be delivered. It
+// is retried until it is delivered or runs out of attempts and is
+// dead-lettered.
+type WebhookDelivery struct {
+	ID      string `json:"id"`
+	URL     string `json:"url"`
+	Topic   string `json:"topic"`
+	Version int    `json:"version"`
+	// Body is the encoded JSON event.
+	Body json.RawMessage `json:"body"`
+
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	// Dead deliveries are out of attempts and are not retried.
+	Dead bool `json:"dead"`
+
+	// CreatedAt is set by storage.
+	CreatedAt time.Time `json:"created_at,omitempty"`
+}
+
+// WebhookDeliveryFilter selects webhook deliveries.
+type WebhookDeliveryFilter struct {
+	IDs []string
+	// Dead selects dead-lettered instead of pending deliveries.
+	Dead bool
+	// DueBy selects pending deliveries whose next attempt is due by
+	// this time. The zero time selects all pending deliveries.
+	DueBy time.Time
+
+	Limit int // 0 is unlimited
+}
+
+// WebhookDeliveryStore stores webhook deliveries for retrying.
+type WebhookDeliveryStore interface {
+	// StoreWebhookDelivery stores delivery, replacing the delivery with
+	// the same ID (but not its creation time) if any.
+	StoreWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error
+
+	// RetrieveWebhookDeliveries retrieves the deliveries matching
+	// filter ordered by their next attempt time.
+	RetrieveWebhookDeliveries(ctx context.Context, filter *WebhookDeliveryFilter) ([]*WebhookDelivery, error)
+
+	// DeleteWebhookDelivery deletes the delivery of id.
+	DeleteWebhookDelivery(ctx context.Context, id string) error
+}
+
+// MessageTemplate is a variant of a named DeviceLock or EraseDevice
+// message template. The variant with an empty Group and Locale is the
+// default variant of the template.
+type MessageTemplate struct {
+	Name string `json:"name"`
+	// Group is the enrollment metadata group the variant is for.
+	Group string `json:"group,omitempty"`
+	// Locale is the locale (e.g. "de" or "de-CH") the variant is for.
+	Locale string `json:"locale,omitempty"`
+
+	Message     string `json:"message,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+
+	UpdatedAt time.Time `json:"updated_at,omitempty"`
+}
+
+// MessageTemplateStore stores message templates.
+type MessageTemplateStore interface {
+	// StoreMessageTemplate stores the variant of template, replacing
+	// the variant of the same name, group, and locale if any.
+	StoreMessageTemplate(ctx context.Context, template *MessageTemplate) error
+
+	// RetrieveMessageTemplates retrieves the variants of the template
+	// name ordered by group and locale. If name is empty then the
+	// variants of all templates are retrieved ordered by name first.
+	RetrieveMessageTemplates(ctx context.Context, name string) ([]*MessageTemplate, error)
+
+	// DeleteMessageTemplate deletes the variant of the template name
+	// for group and locale.
+	DeleteMessageTemplate(ctx context.Context, name, group, locale string) error
+}
+
+// Declaration is a Declarative Management declaration. Its JSON
+// encoding is that of the declaration served to enrollments.
+// See https://developer.apple.com/documentation/devicemanagement/declarationbase
+type Declaration struct {
+	Identifier string          `json:"Identifier"`
+	Type       string          `json:"Type"`
+	Payload    json.RawMessage `json:"Payload"`
+	// ServerToken changes whenever the declaration changes.
+	ServerToken string `json:"ServerToken"`
+
+	UpdatedAt time.Time `json:"-"`
+}
+
+// DeclarationStore stores Declarative Management declarations and the
+// declaration sets which assign them to enrollments.
+type DeclarationStore interface {
+	// StoreDeclaration stores declaration, replacing the declaration
+	// with the same identifier if any.
+	StoreDeclaration(ctx context.Context, declaration *Declaration) error
+
+	// RetrieveDeclarations retrieves the declarations of identifiers
+	// ordered by identifier. If identifiers is empty then all
+	// declarations are retrieved.
+	RetrieveDeclarations(ctx context.Context, identifiers []string) ([]*Declaration, error)
+
+	// DeleteDeclaration deletes the declaration of identifier and
+	// removes it from any declaration sets.
+	DeleteDeclaration(ctx context.Context, identifier string) error
+
+	// StoreDeclarationSet replaces the declaration identifiers of the
+	// declaration set name. The declarations must exist. An empty
+	// identifiers deletes the set.
+	StoreDeclarationSet(ctx context.Context, name string, identifiers []string) error
+
+	// RetrieveDeclarationSets retrieves the declaration identifiers of
+	// the declaration sets names. If names is empty then all sets are
+	// retrieved. Sets without declarations do not exist.
+	RetrieveDeclarationSets(ctx context.Context, names []string) (map[string][]string, error)
+
+	// StoreEnrollmentDeclarationSets replaces the declaration sets
+	// assigned to enrollment id. An empty sets unassigns all sets.
+	StoreEnrollmentDeclarationSets(ctx context.Context, id string, sets []string) error
+
+	// RetrieveEnrollmentDeclarationSets retrieves the names of the
+	// declaration sets assigned to enrollment id.
+	RetrieveEnrollmentDeclarationSets(ctx context.Context, id string) ([]string, error)
+
+	// RetrieveEnrollmentDeclarations retrieves the declarations of the
+	// declaration sets assigned to enrollment id ordered by identifier.
+	RetrieveEnrollmentDeclarations(ctx context.Context, id string) ([]*Declaration, error)
+}
+
+// UnlockTokenRequest is a request to retrieve the escrowed UnlockToken
+// of an enrollment. It must be approved by a user other than the
+// requester before the token can be retrieved (once) by the requester.
+type UnlockTokenRequest struct {
+	ID           string     `json:"id"`
+	EnrollmentID string     `json:"enrollment_id"`
+	Reason       string     `json:"reason,omitempty"`
+	RequestedBy  string     `json:"requested_by,omitempty"`
+	ApprovedBy   string     `json:"approved_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ApprovedAt   *time.Time `json:"approved_at,omitempty"`
+}
+
+// UnlockTokenStore retrieves escrowed UnlockTokens and stores the
+// requests to retrieve them.
+type UnlockTokenStore interface {
+	// RetrieveUnlockToken retrieves the UnlockToken most recently sent
+	// by enrollment id in a TokenUpdate. A nil token is returned if
+	// the enrollment has not sent an UnlockToken.
+	RetrieveUnlockToken(ctx context.Context, id string) ([]byte, error)
+
+	// StoreUnlockTokenRequest stores a new unapproved request.
+	StoreUnlockTokenRequest(ctx context.Context, req *UnlockTokenRequest) error
+
+	// RetrieveUnlockTokenRequests retrieves the requests of ids ordered
+	// by creation time. If ids is empty then all requests are retrieved.
+	RetrieveUnlockTokenRequests(ctx context.Context, ids []string) ([]*UnlockTokenRequest, error)
+
+	// ApproveUnlockTokenRequest approves the unapproved request id by
+	// approvedBy. It reports whether the request was approved so
+	// that a request is only approved once.
+	ApproveUnlockTokenRequest(ctx context.Context, id, approvedBy string) (bool, error)
+
+	// DeleteUnlockTokenRequest deletes the request id. It reports
+	// whether the request was deleted so that a request can be claimed
+	// (e.g. by retrieving its token) only once.
+	DeleteUnlockTokenRequest(ctx context.Context, id string) (bool, error)
+}
+
+// DDMStatusReport is the latest Declarative Management status report
+// of an enrollment.
+type DDMStatusReport struct {
+	// Report is the JSON status report body.
+	Report     json.RawMessage `json:"report"`
+	ReportedAt time.Time       `json:"reported_at"`
+
+	// Declarations are the declaration statuses (a JSON array) of the
+	// latest report that contained any. Devices only report the status
+	// items that changed after their first report so the latest report
+	// may not contain them.
+	Declarations   json.RawMessage `json:"declarations,omitempty"`
+	DeclarationsAt *time.Time      `json:"declarations_at,omitempty"`
+}
+
+// DDMStatusStore stores the latest Declarative Management status
+// reports of enrollments.
+type DDMStatusStore interface {
+	// StoreDDMStatusReport stores report as the latest status report of
+	// enrollment id. The declarations (a JSON array) are only replaced
+	// if declarations is not empty.
+	StoreDDMStatusReport(ctx context.Context, id string, report, declarations []byte) error
+
+	// RetrieveDDMStatusReports retrieves the latest status reports of
+	// ids keyed by enrollment ID. Enrollments that have not sent a
+	// status report are not returned. If ids is empty then the reports
+	// of all enrollments are returned.
+	RetrieveDDMStatusReports(ctx context.Context, ids []string) (map[string]*DDMStatusReport, error)
+}
+
+// CommandScheduleStore retrieves the enrollments of scheduled commands
+// that became due so that they can be pushed.
+type CommandScheduleStore interface {
+	// RetrieveScheduledEnrollments retrieves the IDs of enrollments
+	// with active queued commands scheduled after after and at or
+	// before until.
+	RetrieveScheduledEnrollments(ctx context.Context, after, until time.Time) ([]string, error)
+}
+
+// QueuedEnrollmentRetriever retrieves the enrollments with commands
+// waiting to be sent (e.g. to push them again after an APNs outage).
+type QueuedEnrollmentRetriever interface {
+	// RetrieveQueuedEnrollments retrieves the IDs of enabled
+	// enrollments with active queued commands that have no result or a
+	// NotNow result, ordered by ID. Expired commands and commands that
+	// are not yet due are ignored.
+	RetrieveQueuedEnrollments(ctx context.Context) ([]string, error)
+}
+
+// QueuedCommandStore retrieves and cancels the commands waiting in the
+// queue of an enrollment.
+type QueuedCommandStore interface {
+	// RetrieveQueuedCommands retrieves the active commands queued for
+	// enrollment id that have no result or a NotNow result in queue
+	// order. Expired commands are not included.
+	RetrieveQueuedCommands(ctx context.Context, id string) ([]*QueuedCommand, error)
+
+	// CancelQueuedCommand deactivates the command uuid in the queue of
+	// enrollment id so that it is no longer sent. It reports whether
+	// the command was canceled: commands that are not queued for id,
+	// already deactivated, or that have a result other than NotNow
+	// are not.
+	CancelQueuedCommand(ctx context.Context, id, uuid string) (bool, error)
+}
+
+// Capability is an optional feature of a storage backend. A backend may
+// implement the interface of a feature without fully supporting it
+// (e.g. depending on its options) and backends written against older
+// versions of this package may not implement newer interfaces at all.
+type Capability string
+
+const (
+	// CapabilityQueueLocking is serializing the command queue operations
+	// of enrollments across NanoMDM instances with QueueLocker.
+	CapabilityQueueLocking Capability = "queue_locking"
+
+	// CapabilityResultRetention is retaining commands and their results
+	// once reported (e.g. for the queue API and command retries).
+	CapabilityResultRetention Capability = "result_retention"
+
+	// CapabilityMetadata is storing enrollment metadata with
+	// EnrollmentMetadataStore.
+	CapabilityMetadata Capability = "metadata"
+
+	// CapabilityCommandExpiration is storing the expiry of enqueued
+	// commands and skipping expired commands.
+	CapabilityCommandExpiration Capability = "command_expiration"
+
+	// CapabilityCommandScheduling is storing the schedule (not-before
+	// time) of enqueued commands, skipping commands that are not yet
+	// due, and retrieving the enrollments of commands that became due
+	// with CommandScheduleStore.
+	CapabilityCommandScheduling Capability = "command_scheduling"
+
+	// CapabilityCommandPriority is storing the priority of enqueued
+	// commands and retrieving higher priority commands first.
+	CapabilityCommandPriority Capability = "command_priority"
+
+	// CapabilityEviction is storing enrollment evictions with
+	// EnrollmentEvictionStore.
+	CapabilityEviction Capability = "eviction"
+
+	// CapabilityTombstones is departing enrollments with
+	// EnrollmentTombstoneStore.
+	CapabilityTombstones Capability = "tombstones"
+
+	// CapabilityWebhookDeliveries is storing failed webhook deliveries
+	// with WebhookDeliveryStore.
+	CapabilityWebhookDeliveries Capability = "webhook_deliveries"
+
+	// CapabilityMessageTemplates is storing message templates with
+	// MessageTemplateStore.
+	CapabilityMessageTemplates Capability = "message_templates"
+
+	// CapabilityDeclarations is storing Declarative Management
+	// declarations and sets with DeclarationStore.
+	CapabilityDeclarations Capability = "declarations"
+
+	// CapabilityUnlockTokens is retrieving UnlockTokens and storing
+	// their approval requests with UnlockTokenStore.
+	CapabilityUnlockTokens Capability = "unlock_tokens"
+
+	// CapabilityDDMStatus is storing Declarative Management status
+	// reports with DDMStatusStore.
+	CapabilityDDMStatus Capability = "ddm_status"
+
+	// CapabilityQueuedEnrollments is retrieving the enrollments with
+	// queued commands with QueuedEnrollmentRetriever.
+	CapabilityQueuedEnrollments Capability = "queued_enrollments"
+
+	// CapabilityQueuedCommands is retrieving and canceling the queued
+	// commands of enrollments with QueuedCommandStore.
+	CapabilityQueuedCommands Capability = "queued_commands"
+
+	// CapabilityUserSessions is storing the user sessions of shared
+	// iPads with UserSessionStore.
+	CapabilityUserSessions Capability = "user_sessions"
+
+	// CapabilityJobs is tracking enqueue and push jobs with JobStore.
+	CapabilityJobs Capability = "jobs"
+
+	// CapabilityEventLog is storing the events of enrollments with
+	// EventLogStore.
+	CapabilityEventLog Capability = "event_log"
+
+	// CapabilityMetricsRollups is storing metrics rollups with
+	// MetricsRollupStore.
+	CapabilityMetricsRollups Capability = "metrics_rollups"
+
+	// CapabilityAPIKeys is storing API keys with APIKeyStore.
+	CapabilityAPIKeys Capability = "api_keys"
+
+	// CapabilityPendingCommands is storing commands awaiting approval
+	// with PendingCommandStore.
+	CapabilityPendingCommands Capability = "pending_commands"
+
+	// CapabilityFreeze is storing enrollment freezes with
+	// EnrollmentFreezeStore.
+	CapabilityFreeze Capability = "freeze"
+
+	// CapabilityInventory is storing the inventory of enrollments with
+	// InventoryStore.
+	CapabilityInventory Capability = "inventory"
+
+	// CapabilitySupersession is storing enrollment supersessions with
+	// EnrollmentSupersessionStore.
+	CapabilitySupersession Capability = "supersession"
+
+	// CapabilityCommandOwners is storing the owners of enqueued
+	// commands with CommandOwnerStore.
+	CapabilityCommandOwners Capability = "command_owners"
+)
+
+// Capabilities are the capabilities of a storage backend. Missing
+// capabilities are not supported.
+type Capabilities map[Capability]bool
+
+// CapabilityReporter reports the capabilities of a storage backend.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// DiscoverCapabilities returns the capabilities of store. If store is a
+// CapabilityReporter it reports its own capabilities. Otherwise they
+// are discovered from the interfaces store implements. Queue locking
+// is then assumed to be unsupported as LockQueue may be a no-op.
+func DiscoverCapabilities(store interface{}) Capabilities {
+	if r, ok := store.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+	caps := make(Capabilities)
+	_, caps[CapabilityResultRetention] = store.(QueueRetriever)
+	_, caps[CapabilityMetadata] = store.(EnrollmentMetadataStore)
+	_, caps[CapabilityEviction] = store.(EnrollmentEvictionStore)
+	_, caps[CapabilityTombstones] = store.(EnrollmentTombstoneStore)
+	_, caps[CapabilityWebhookDeliveries] = store.(WebhookDeliveryStore)
+	_, caps[CapabilityMessageTemplates] = store.(MessageTemplateStore)
+	_, caps[CapabilityDeclarations] = store.(DeclarationStore)
+	_, caps[CapabilityUnlockTokens] = store.(UnlockTokenStore)
+	_, caps[CapabilityDDMStatus] = store.(DDMStatusStore)
+	_, caps[CapabilityQueuedEnrollments] = store.(QueuedEnrollmentRetriever)
+	_, caps[CapabilityQueuedCommands] = store.(QueuedCommandStore)
+	_, caps[CapabilityUserSessions] = store.(UserSessionStore)
+	_, caps[CapabilityJobs] = store.(JobStore)
+	_, caps[CapabilityEventLog] = store.(EventLogStore)
+	_, caps[CapabilityMetricsRollups] = store.(MetricsRollupStore)
+	_, caps[CapabilityAPIKeys] = store.(APIKeyStore)
+	_, caps[CapabilityPendingCommands] = store.(PendingCommandStore)
+	_, caps[CapabilityFreeze] = store.(EnrollmentFreezeStore)
+	_, caps[CapabilityInventory] = store.(InventoryStore)
+	_, caps[CapabilitySupersession] = store.(EnrollmentSupersessionStore)
+	_, caps[CapabilityCommandOwners] = store.(CommandOwnerStore)
+	return caps
+}
+
+// Unwrapper is implemented by storage decorators (which only implement
+// the AllStorage interfaces) to return the storage they decorate.
+type Unwrapper interface {
+	Unwrap() AllStorage
+}
+
+// As finds the first storage in the decorator chain of store that
+// implements the interface target points to, sets target to it, and
+// returns true. Decorators are unwrapped with Unwrapper. Use the
+// capabilities of store to check that an optional feature is actually
+// supported. As panics if target is not a non-nil pointer to an
+// interface type.
+func As(store interface{}, target interface{}) bool {
+	val := reflect.ValueOf(target)
+	if target == nil || val.Kind() != reflect.Ptr || val.IsNil() || val.Elem().Kind() != reflect.Interface {
+		panic("storage: target must be a non-nil pointer to an interface")
+	}
+	targetType := val.Type().Elem()
+	for store != nil {
+		if reflect.TypeOf(store).Implements(targetType) {
+			val.Elem().Set(reflect.ValueOf(store))
+			return true
+		}
+		u, ok := store.(Unwrapper)
+		if !ok {
+			return false
+		}
+		store = u.Unwrap()
+	}
+	return false
+}
+
+// CheckinTransactor makes the multi-step writes of check-in messages
+// atomic (e.g. the StoreAuthenticate, ClearQueue, and Disable of an
+// Authenticate message) so that a failure part way through does not
+// leave an enrollment half-disabled with a populated queue.
+type CheckinTransactor interface {
+	// InTransaction calls f with a context derived from ctx in which
+	// the writes of the storage are made in a single transaction. The
+	// transaction is committed if f returns nil and rolled back
+	// otherwise. Calls within a transaction join it.
+	InTransaction(ctx context.Context, f func(ctx context.Context) error) error
+}
+
+// InTransaction calls f in a transaction of store if it is a
+// CheckinTransactor. Otherwise f is called with ctx.
+func InTransaction(ctx context.Context, store interface{}, f func(ctx context.Context) error) error {
+	if t, ok := store.(CheckinTransactor); ok {
+		return t.InTransaction(ctx, f)
+	}
+	return f(ctx)
+}
@@ -0,0 +1,28 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestEnrollmentAliases tests resolving the (already enrolled) device
+// enrollment id by itself and by its serial number.
+func TestEnrollmentAliases(t *testing.T, id, serial string, store storage.EnrollmentAliasResolver) {
+	ctx := context.Background()
+
+	resolved, err := store.ResolveEnrollmentIDs(ctx, []string{id, serial, "not-an-alias"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := resolved[id], id; have != want {
+		t.Errorf("resolve id: have %q, want %q", have, want)
+	}
+	if have, want := resolved[serial], id; have != want {
+		t.Errorf("resolve serial: have %q, want %q", have, want)
+	}
+	if id, ok := resolved["not-an-alias"]; ok {
+		t.Errorf("unknown alias resolved to %q", id)
+	}
+}
@@ -0,0 +1,63 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestAPIKeys tests storing, retrieving, and deleting API keys of store.
+func TestAPIKeys(t *testing.T, store storage.APIKeyStore) {
+	ctx := context.Background()
+	hash := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	key, err := store.RetrieveAPIKey(ctx, "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != nil {
+		t.Errorf("expected no key, have %+v", key)
+	}
+
+	for _, key := range []*storage.APIKey{
+		{Name: "test-key", Role: "viewer", SecretHash: hash},
+		{Name: "test-key", Role: "tenant-admin", Tenant: "acme", SecretHash: hash},
+		{Name: "test-key-2", Role: "admin", SecretHash: hash},
+	} {
+		if err = store.StoreAPIKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key, err = store.RetrieveAPIKey(ctx, "test-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key == nil || key.Role != "tenant-admin" || key.Tenant != "acme" || key.SecretHash != hash || key.CreatedAt.IsZero() {
+		t.Errorf("unexpected key: %+v", key)
+	}
+
+	keys, err := store.RetrieveAPIKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found int
+	for _, key := range keys {
+		if key.Name == "test-key" || key.Name == "test-key-2" {
+			found++
+		}
+	}
+	if have, want := found, 2; have != want {
+		t.Errorf("keys: have %d, want %d", have, want)
+	}
+
+	for _, name := range []string{"test-key", "test-key-2"} {
+		if err = store.DeleteAPIKey(ctx, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if key, err = store.RetrieveAPIKey(ctx, "test-key"); err != nil || key != nil {
+		t.Errorf("deleted key: have %+v (%v)", key, err)
+	}
+}
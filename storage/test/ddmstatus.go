@@ -0,0 +1,59 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestDDMStatusReports tests storing and retrieving the Declarative
+// Management status reports of the (already enrolled) enrollment id.
+func TestDDMStatusReports(t *testing.T, id string, store storage.DDMStatusStore) {
+	ctx := context.Background()
+
+	first := []byte(`{"StatusItems":{"management":{"declarations":{}}}}`)
+	declarations := []byte(`[{"kind":"configurations","identifier":"com.example.config","active":true,"valid":"valid","server_token":"1"}]`)
+	if err := store.StoreDDMStatusReport(ctx, id, first, declarations); err != nil {
+		t.Fatal(err)
+	}
+
+	// later reports without declarations keep the declarations
+	latest := []byte(`{"StatusItems":{"device":{"operating-system":{"version":"17.0"}}}}`)
+	if err := store.StoreDDMStatusReport(ctx, id, latest, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reports, err := store.RetrieveDDMStatusReports(ctx, []string{id, "test-not-reported"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(reports), 1; have != want {
+		t.Fatalf("status reports: have %d, want %d", have, want)
+	}
+	r := reports[id]
+	if r == nil {
+		t.Fatalf("status report of %q not found", id)
+	}
+	if !bytes.Equal(r.Report, latest) {
+		t.Errorf("report: have %s, want %s", r.Report, latest)
+	}
+	if r.ReportedAt.IsZero() {
+		t.Error("reported at should be set")
+	}
+	if !bytes.Equal(r.Declarations, declarations) {
+		t.Errorf("declarations: have %s, want %s", r.Declarations, declarations)
+	}
+	if r.DeclarationsAt == nil {
+		t.Error("declarations at should be set")
+	}
+
+	reports, err = store.RetrieveDDMStatusReports(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reports[id] == nil {
+		t.Errorf("status report of %q not retrieved with all reports", id)
+	}
+}
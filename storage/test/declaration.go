@@ -0,0 +1,129 @@
+package test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestDeclarations tests storing declarations and declaration sets and
+// assigning sets to an enrollment of store.
+func TestDeclarations(t *testing.T, store storage.DeclarationStore) {
+	ctx := context.Background()
+	const id = "test-declarations-id"
+
+	for _, d := range []*storage.Declaration{
+		{Identifier: "test.config.a", Type: "com.apple.configuration.passcode.settings", Payload: []byte(`{"RequirePasscode":true}`), ServerToken: "a1"},
+		{Identifier: "test.config.b", Type: "com.apple.configuration.management.test", Payload: []byte(`{"Echo":"b"}`), ServerToken: "b1"},
+		{Identifier: "test.config.b", Type: "com.apple.configuration.management.test", Payload: []byte(`{"Echo":"b2"}`), ServerToken: "b2"},
+		{Identifier: "test.activation", Type: "com.apple.activation.simple", Payload: []byte(`{"StandardConfigurations":["test.config.a"]}`), ServerToken: "c1"},
+	} {
+		if err := store.StoreDeclaration(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	declarations, err := store.RetrieveDeclarations(ctx, []string{"test.config.b", "test.activation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(declarations), 2; have != want {
+		t.Fatalf("declarations: have %d, want %d", have, want)
+	}
+	// ordered by identifier
+	if d := declarations[0]; d.Identifier != "test.activation" || d.Type != "com.apple.activation.simple" || d.UpdatedAt.IsZero() {
+		t.Errorf("unexpected declaration: %+v", d)
+	}
+	if d := declarations[1]; d.ServerToken != "b2" || string(d.Payload) != `{"Echo":"b2"}` {
+		t.Errorf("declaration not replaced: %+v", d)
+	}
+
+	// sets must reference existing declarations
+	if err = store.StoreDeclarationSet(ctx, "test-set-bad", []string{"test.missing"}); err == nil {
+		t.Error("expected error storing set with missing declaration")
+	}
+	if err = store.StoreDeclarationSet(ctx, "test-set-1", []string{"test.config.a", "test.activation"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.StoreDeclarationSet(ctx, "test-set-2", []string{"test.config.b", "test.activation"}); err != nil {
+		t.Fatal(err)
+	}
+	sets, err := store.RetrieveDeclarationSets(ctx, []string{"test-set-1", "test-set-2", "test-set-bad"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := sets, map[string][]string{
+		"test-set-1": {"test.activation", "test.config.a"},
+		"test-set-2": {"test.activation", "test.config.b"},
+	}; !reflect.DeepEqual(have, want) {
+		t.Errorf("sets: have %v, want %v", have, want)
+	}
+
+	if err = store.StoreEnrollmentDeclarationSets(ctx, id, []string{"test-set-2", "test-set-1"}); err != nil {
+		t.Fatal(err)
+	}
+	names, err := store.RetrieveEnrollmentDeclarationSets(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := names, []string{"test-set-1", "test-set-2"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enrollment sets: have %v, want %v", have, want)
+	}
+
+	// declarations in multiple sets are retrieved once
+	if declarations, err = store.RetrieveEnrollmentDeclarations(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	var identifiers []string
+	for _, d := range declarations {
+		identifiers = append(identifiers, d.Identifier)
+	}
+	if have, want := identifiers, []string{"test.activation", "test.config.a", "test.config.b"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("enrollment declarations: have %v, want %v", have, want)
+	}
+
+	// deleting a declaration removes it from sets
+	if err = store.DeleteDeclaration(ctx, "test.config.a"); err != nil {
+		t.Fatal(err)
+	}
+	if sets, err = store.RetrieveDeclarationSets(ctx, []string{"test-set-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := sets["test-set-1"], []string{"test.activation"}; !reflect.DeepEqual(have, want) {
+		t.Errorf("set after delete: have %v, want %v", have, want)
+	}
+
+	// an empty set is deleted
+	if err = store.StoreDeclarationSet(ctx, "test-set-1", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.StoreEnrollmentDeclarationSets(ctx, id, []string{"test-set-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if declarations, err = store.RetrieveEnrollmentDeclarations(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(declarations), 0; have != want {
+		t.Errorf("declarations of deleted set: have %d, want %d", have, want)
+	}
+
+	if err = store.StoreEnrollmentDeclarationSets(ctx, id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if names, err = store.RetrieveEnrollmentDeclarationSets(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(names), 0; have != want {
+		t.Errorf("enrollment sets after unassigning: have %d, want %d", have, want)
+	}
+	if err = store.StoreDeclarationSet(ctx, "test-set-2", nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, identifier := range []string{"test.config.b", "test.activation"} {
+		if err = store.DeleteDeclaration(ctx, identifier); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
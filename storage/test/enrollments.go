@@ -0,0 +1,66 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// EnrollmentInterfaces are the storage interfaces needed for testing
+// enrollment retrieval and disablement.
+type EnrollmentInterfaces interface {
+	storage.CheckinStore
+	storage.EnrollmentRetriever
+}
+
+func retrieveEnrollment(t *testing.T, store EnrollmentInterfaces, ctx context.Context, id string) *storage.Enrollment {
+	enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(enrollments), 1; have != want {
+		t.Fatalf("enrollments count: have %d, want %d", have, want)
+	}
+	return enrollments[0]
+}
+
+// TestEnrollments tests the retrieval and disablement of the (already
+// enrolled and enabled) device enrollment id.
+func TestEnrollments(t *testing.T, id string, store EnrollmentInterfaces) {
+	ctx := context.Background()
+
+	e := retrieveEnrollment(t, store, ctx, id)
+	if !e.Enabled {
+		t.Error("enrollment should be enabled")
+	}
+	if have, want := e.DeviceID, id; have != want {
+		t.Errorf("device id: have %q, want %q", have, want)
+	}
+
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id}}
+	if err := storage.DisableWithReason(store, r, storage.DisableReasonAdmin); err != nil {
+		t.Fatal(err)
+	}
+
+	e = retrieveEnrollment(t, store, ctx, id)
+	if e.Enabled {
+		t.Error("enrollment should be disabled")
+	}
+	if have, want := e.DisableReason, storage.DisableReasonAdmin; have != want {
+		t.Errorf("disable reason: have %q, want %q", have, want)
+	}
+	if e.DisabledAt == nil {
+		t.Error("disabled at should be set")
+	}
+
+	enabled := true
+	enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}, Enabled: &enabled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(enrollments) != 0 {
+		t.Errorf("enabled enrollments: have %d, want 0", len(enrollments))
+	}
+}
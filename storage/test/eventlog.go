@@ -0,0 +1,61 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestEventLog tests appending to and reading from the event log of store.
+func TestEventLog(t *testing.T, store storage.EventLogStore) {
+	ctx := context.Background()
+
+	events := []*storage.LogEvent{
+		{Topic: "mdm.Authenticate", EnrollmentID: "eventlog-id", RawPayload: []byte("<plist/>"), CertHash: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"},
+		{Topic: "mdm.Connect", EnrollmentID: "eventlog-id", CommandUUID: "eventlog-cmd", Status: "Acknowledged"},
+		{Topic: "mdm.CheckOut", EnrollmentID: "eventlog-id"},
+	}
+	for _, event := range events {
+		if err := store.StoreLogEvent(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 1; i < len(events); i++ {
+		if events[i].Seq <= events[i-1].Seq {
+			t.Fatalf("sequence did not increase: %d then %d", events[i-1].Seq, events[i].Seq)
+		}
+	}
+
+	// the store may contain other events so read from just before ours
+	after := events[0].Seq - 1
+	retrieved, err := store.RetrieveLogEvents(ctx, after, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(retrieved), 2; have != want {
+		t.Fatalf("events: have %d, want %d", have, want)
+	}
+	for i, event := range retrieved {
+		want := events[i]
+		if event.Seq != want.Seq || event.Topic != want.Topic || event.EnrollmentID != want.EnrollmentID {
+			t.Errorf("event %d: have %+v, want %+v", i, event, want)
+		}
+		if event.CommandUUID != want.CommandUUID || event.Status != want.Status || !bytes.Equal(event.RawPayload, want.RawPayload) || event.CertHash != want.CertHash {
+			t.Errorf("event %d: have %+v, want %+v", i, event, want)
+		}
+		if event.CreatedAt.IsZero() {
+			t.Errorf("event %d: missing created at", i)
+		}
+	}
+
+	// resume from the cursor
+	retrieved, err = store.RetrieveLogEvents(ctx, retrieved[1].Seq, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retrieved) != 1 || retrieved[0].Seq != events[2].Seq {
+		t.Errorf("unexpected events after cursor: %+v", retrieved)
+	}
+}
@@ -0,0 +1,83 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestEnrollmentEvictions tests evicting enrollments of store and
+// recording the delivery of evictions.
+func TestEnrollmentEvictions(t *testing.T, store storage.EnrollmentEvictionStore) {
+	ctx := context.Background()
+
+	for _, eviction := range []*storage.EnrollmentEviction{
+		{ID: "test-evicted-1"},
+		{ID: "test-evicted-1", Reason: "stolen", EvictedBy: "alice"},
+		{ID: "test-evicted-2"},
+	} {
+		if err := store.StoreEnrollmentEviction(ctx, eviction); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evictions, err := store.RetrieveEnrollmentEvictions(ctx, []string{"test-evicted-1", "test-not-evicted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(evictions), 1; have != want {
+		t.Fatalf("evictions: have %d, want %d", have, want)
+	}
+	if eviction := evictions["test-evicted-1"]; eviction == nil || eviction.Reason != "stolen" || eviction.EvictedBy != "alice" || eviction.CreatedAt.IsZero() || eviction.DeliveredAt != nil {
+		t.Errorf("unexpected eviction: %+v", eviction)
+	}
+
+	for i, want := range []bool{true, false} {
+		delivered, err := store.MarkEnrollmentEvictionDelivered(ctx, "test-evicted-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delivered != want {
+			t.Errorf("delivery %d: have %v, want %v", i, delivered, want)
+		}
+	}
+	if delivered, err := store.MarkEnrollmentEvictionDelivered(ctx, "test-not-evicted"); err != nil {
+		t.Fatal(err)
+	} else if delivered {
+		t.Error("delivered eviction of enrollment that is not evicted")
+	}
+
+	if evictions, err = store.RetrieveEnrollmentEvictions(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if evictions["test-evicted-1"] == nil || evictions["test-evicted-2"] == nil {
+		t.Fatalf("expected all evictions, have %v", evictions)
+	}
+	if evictions["test-evicted-1"].DeliveredAt == nil {
+		t.Error("expected delivered eviction")
+	}
+
+	// evicting again resets the delivery
+	if err = store.StoreEnrollmentEviction(ctx, &storage.EnrollmentEviction{ID: "test-evicted-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if evictions, err = store.RetrieveEnrollmentEvictions(ctx, []string{"test-evicted-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if eviction := evictions["test-evicted-1"]; eviction == nil || eviction.DeliveredAt != nil {
+		t.Errorf("unexpected eviction: %+v", eviction)
+	}
+
+	for _, id := range []string{"test-evicted-1", "test-evicted-2"} {
+		if err = store.DeleteEnrollmentEviction(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if evictions, err = store.RetrieveEnrollmentEvictions(ctx, []string{"test-evicted-1", "test-evicted-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(evictions), 0; have != want {
+		t.Errorf("evictions after delete: have %d, want %d", have, want)
+	}
+}
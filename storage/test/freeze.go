@@ -0,0 +1,53 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestEnrollmentFreezes tests freezing and unfreezing enrollments of store.
+func TestEnrollmentFreezes(t *testing.T, store storage.EnrollmentFreezeStore) {
+	ctx := context.Background()
+
+	for _, freeze := range []*storage.EnrollmentFreeze{
+		{ID: "test-frozen-1"},
+		{ID: "test-frozen-1", Reason: "legal hold", FrozenBy: "alice"},
+		{ID: "test-frozen-2"},
+	} {
+		if err := store.StoreEnrollmentFreeze(ctx, freeze); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	freezes, err := store.RetrieveEnrollmentFreezes(ctx, []string{"test-frozen-1", "test-not-frozen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(freezes), 1; have != want {
+		t.Fatalf("freezes: have %d, want %d", have, want)
+	}
+	if freeze := freezes["test-frozen-1"]; freeze == nil || freeze.Reason != "legal hold" || freeze.FrozenBy != "alice" || freeze.CreatedAt.IsZero() {
+		t.Errorf("unexpected freeze: %+v", freeze)
+	}
+
+	if freezes, err = store.RetrieveEnrollmentFreezes(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if freezes["test-frozen-1"] == nil || freezes["test-frozen-2"] == nil {
+		t.Errorf("expected all freezes, have %v", freezes)
+	}
+
+	for _, id := range []string{"test-frozen-1", "test-frozen-2"} {
+		if err = store.DeleteEnrollmentFreeze(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if freezes, err = store.RetrieveEnrollmentFreezes(ctx, []string{"test-frozen-1", "test-frozen-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(freezes), 0; have != want {
+		t.Errorf("freezes after delete: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestInventory tests storing and retrieving inventory snapshots of store.
+func TestInventory(t *testing.T, store storage.InventoryStore) {
+	ctx := context.Background()
+
+	values, err := store.RetrieveInventory(ctx, "test-inventory-1", "SecurityInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values != nil {
+		t.Errorf("expected nil snapshot, have %v", values)
+	}
+
+	for _, values := range []map[string]string{
+		{"FDE_Enabled": "true", "PasscodePresent": "true"},
+		{"FDE_Enabled": "false"},
+	} {
+		if err = store.StoreInventory(ctx, "test-inventory-1", "SecurityInfo", values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = store.StoreInventory(ctx, "test-inventory-1", "ProfileList", map[string]string{"com.example": "uuid"}); err != nil {
+		t.Fatal(err)
+	}
+
+	values, err = store.RetrieveInventory(ctx, "test-inventory-1", "SecurityInfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(values), 1; have != want {
+		t.Fatalf("values: have %d, want %d", have, want)
+	}
+	if have, want := values["FDE_Enabled"], "false"; have != want {
+		t.Errorf("FDE_Enabled: have %q, want %q", have, want)
+	}
+
+	values, err = store.RetrieveInventory(ctx, "test-inventory-2", "ProfileList")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values != nil {
+		t.Errorf("expected nil snapshot for other enrollment, have %v", values)
+	}
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestJobs tests the job storage and status progression of store.
+func TestJobs(t *testing.T, store storage.JobStore) {
+	ctx := context.Background()
+
+	// unique IDs so that the test can be re-run against the same database
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 36)
+	job := &storage.Job{
+		ID:          "test-job-" + suffix,
+		Name:        "test",
+		CommandUUID: "test-job-cmd-" + suffix,
+		RequestType: "ProfileList",
+	}
+	ids := []string{"job-id-a", "job-id-b", "job-id-c"}
+	if err := store.StoreJob(ctx, job, ids); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.UpdateJobTargets(ctx, job.ID, storage.JobStatusErrored, []string{"job-id-c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, update := range []struct{ id, status string }{
+		{"job-id-a", storage.JobStatusDelivered},
+		{"job-id-a", storage.JobStatusAcknowledged},
+		{"job-id-a", storage.JobStatusDelivered}, // should not regress
+		{"job-id-b", storage.JobStatusDelivered},
+		{"job-id-c", storage.JobStatusDelivered}, // should not regress
+	} {
+		if err := store.UpdateJobCommandTarget(ctx, update.id, job.CommandUUID, update.status); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retrieved, err := store.RetrieveJob(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retrieved == nil {
+		t.Fatal("job not found")
+	}
+	if have, want := retrieved.RequestType, job.RequestType; have != want {
+		t.Errorf("request type: have %q, want %q", have, want)
+	}
+	want := storage.JobCounts{Total: 3, Delivered: 1, Acknowledged: 1, Errored: 1}
+	if have := retrieved.Counts; have != want {
+		t.Errorf("counts: have %+v, want %+v", have, want)
+	}
+
+	targets, err := store.RetrieveJobTargets(ctx, job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := targets["job-id-a"], storage.JobStatusAcknowledged; have != want {
+		t.Errorf("target status: have %q, want %q", have, want)
+	}
+
+	retrieved, err = store.RetrieveJob(ctx, "test-job-missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retrieved != nil {
+		t.Error("expected nil job")
+	}
+}
@@ -0,0 +1,52 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestQueueLocker tests that store serializes the queue locks of an
+// enrollment. The store must be configured to serialize queues.
+func TestQueueLocker(t *testing.T, store storage.QueueLocker) {
+	ctx := context.Background()
+
+	unlock, err := store.LockQueue(ctx, "test-lock-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// other enrollments are not blocked
+	unlock2, err := store.LockQueue(ctx, "test-lock-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock2()
+
+	locked := make(chan error)
+	go func() {
+		unlock, err := store.LockQueue(ctx, "test-lock-1")
+		if err == nil {
+			unlock()
+		}
+		locked <- err
+	}()
+
+	select {
+	case err = <-locked:
+		t.Fatalf("expected lock to block, have err %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case err = <-locked:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for lock")
+	}
+}
@@ -0,0 +1,35 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestCommandOwners tests storing and retrieving command owners of store.
+func TestCommandOwners(t *testing.T, store storage.CommandOwnerStore) {
+	ctx := context.Background()
+
+	owner, err := store.RetrieveCommandOwner(ctx, "test-owner-cmd-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != "" {
+		t.Errorf("expected no owner, have %q", owner)
+	}
+
+	for _, owner := range []string{"orchestrator", "patching"} {
+		if err = store.StoreCommandOwner(ctx, "test-owner-cmd-1", owner); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	owner, err = store.RetrieveCommandOwner(ctx, "test-owner-cmd-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := owner, "patching"; have != want {
+		t.Errorf("owner: have %q, want %q", have, want)
+	}
+}
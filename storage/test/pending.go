@@ -0,0 +1,78 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestPendingCommands tests storing, retrieving, and deleting pending
+// commands of store.
+func TestPendingCommands(t *testing.T, store storage.PendingCommandStore) {
+	ctx := context.Background()
+	raw := []byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0"><dict/></plist>`)
+	expiresAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cmd, err := store.RetrievePendingCommand(ctx, "test-pending-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd != nil {
+		t.Errorf("expected no pending command, have %+v", cmd)
+	}
+
+	for _, cmd := range []*storage.PendingCommand{
+		{CommandUUID: "test-pending-1", RequestType: "DeviceLock", Targets: []string{"ID1"}, Command: raw},
+		{CommandUUID: "test-pending-1", RequestType: "EraseDevice", Targets: []string{"ID1", "ID2"}, NoPush: true, RequestedBy: "alice", Command: raw, ExpiresAt: &expiresAt, Priority: 10, Template: "lost", Locale: "de", Owner: "helpdesk"},
+		{CommandUUID: "test-pending-2", RequestType: "DeviceLock", Targets: []string{"ID3"}, Command: raw},
+	} {
+		if err = store.StorePendingCommand(ctx, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd, err = store.RetrievePendingCommand(ctx, "test-pending-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd == nil || cmd.RequestType != "EraseDevice" || len(cmd.Targets) != 2 || cmd.Targets[1] != "ID2" || !cmd.NoPush || cmd.RequestedBy != "alice" || !bytes.Equal(cmd.Command, raw) || cmd.CreatedAt.IsZero() {
+		t.Errorf("unexpected pending command: %+v", cmd)
+	}
+	if cmd != nil && (cmd.ExpiresAt == nil || !cmd.ExpiresAt.Equal(expiresAt) || cmd.NotBefore != nil || cmd.Priority != 10 || cmd.Template != "lost" || cmd.Locale != "de" || cmd.Owner != "helpdesk") {
+		t.Errorf("unexpected pending command options: %+v", cmd)
+	}
+
+	cmds, err := store.RetrievePendingCommands(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found int
+	for _, cmd := range cmds {
+		if cmd.CommandUUID == "test-pending-1" || cmd.CommandUUID == "test-pending-2" {
+			found++
+		}
+	}
+	if have, want := found, 2; have != want {
+		t.Errorf("pending commands: have %d, want %d", have, want)
+	}
+
+	for _, uuid := range []string{"test-pending-1", "test-pending-2"} {
+		deleted, err := store.DeletePendingCommand(ctx, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !deleted {
+			t.Errorf("%s: expected deleted", uuid)
+		}
+	}
+	deleted, err := store.DeletePendingCommand(ctx, "test-pending-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted {
+		t.Error("expected already deleted pending command to not be deleted")
+	}
+}
@@ -2,7 +2,9 @@ package test
 
 import (
 	"context"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/micromdm/nanomdm/mdm"
 	"github.com/micromdm/nanomdm/storage"
@@ -43,10 +45,31 @@ func newCommand(cmd string) (*mdm.Command, error) {
 
 // enqueue queues a new command
 func enqueue(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string) {
+	enqueueExpiring(t, q, ctx, id, cmdStr, time.Time{})
+}
+
+// enqueueExpiring queues a new command that expires at expiresAt
+func enqueueExpiring(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string, expiresAt time.Time) {
 	cmd, err := newCommand(cmdStr)
 	if err != nil {
 		t.Fatal(err)
 	}
+	cmd.ExpiresAt = expiresAt
+	enqueueCommand(t, q, ctx, id, cmd)
+}
+
+// enqueueScheduled queues a new command that is scheduled at notBefore
+func enqueueScheduled(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string, notBefore time.Time) {
+	cmd, err := newCommand(cmdStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.NotBefore = notBefore
+	enqueueCommand(t, q, ctx, id, cmd)
+}
+
+// enqueueCommand queues cmd
+func enqueueCommand(t *testing.T, q QueueInterfaces, ctx context.Context, id string, cmd *mdm.Command) {
 	res, err := q.EnqueueCommand(ctx, []string{id}, cmd)
 	if err != nil {
 		t.Fatal(err)
@@ -153,3 +176,332 @@ func TestQueue(t *testing.T, id string, q QueueInterfaces) {
 		reportRetrieve(t, q, r, "", "Idle", "")
 	})
 }
+
+// TestRetrieveQueue tests retrieving the queue of id. Commands already
+// in the queue of id are ignored.
+func TestRetrieveQueue(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueueRetriever
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+
+	enqueue(t, q, ctx, id, "CMD4")
+	enqueue(t, q, ctx, id, "CMD5")
+	reportRetrieve(t, q, r, "", "Idle", "CMD4")
+	reportRetrieve(t, q, r, "CMD4", "NotNow", "CMD5")
+
+	cmds, err := q.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := make(map[string]*storage.QueuedCommand)
+	var order []string
+	for _, cmd := range cmds {
+		if cmd.CommandUUID == "CMD4" || cmd.CommandUUID == "CMD5" {
+			queued[cmd.CommandUUID] = cmd
+			order = append(order, cmd.CommandUUID)
+		}
+	}
+	if have, want := strings.Join(order, ","), "CMD4,CMD5"; have != want {
+		t.Fatalf("queue order: have %q, want %q", have, want)
+	}
+	if cmd := queued["CMD4"]; cmd.Status != "NotNow" || cmd.RequestType != "CMD4" || len(cmd.Result) < 1 || !cmd.Active {
+		t.Errorf("unexpected CMD4: %+v", cmd)
+	}
+	if cmd := queued["CMD5"]; cmd.Status != "" || len(cmd.Command) < 1 || cmd.Result != nil || !cmd.Active {
+		t.Errorf("unexpected CMD5: %+v", cmd)
+	}
+	for _, cmd := range queued {
+		if cmd.CreatedAt.IsZero() {
+			t.Errorf("%s: missing created at", cmd.CommandUUID)
+		}
+	}
+
+	reportRetrieve(t, q, r, "CMD5", "Acknowledged", "CMD4")
+	reportRetrieve(t, q, r, "CMD4", "Acknowledged", "")
+}
+
+// TestExpiringQueue tests that expired commands in the queue of id are
+// skipped and reported as expired. Commands already in the queue of id
+// are ignored.
+func TestExpiringQueue(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueueRetriever
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+
+	enqueueExpiring(t, q, ctx, id, "CMD6", time.Now().Add(-time.Minute))
+	enqueueExpiring(t, q, ctx, id, "CMD7", time.Now().Add(time.Hour))
+	reportRetrieve(t, q, r, "", "Idle", "CMD7")
+
+	cmds, err := q.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := make(map[string]*storage.QueuedCommand)
+	for _, cmd := range cmds {
+		queued[cmd.CommandUUID] = cmd
+	}
+	if cmd := queued["CMD6"]; cmd == nil || cmd.Status != storage.StatusExpired || cmd.Active || cmd.ExpiresAt == nil {
+		t.Errorf("unexpected CMD6: %+v", cmd)
+	}
+	if cmd := queued["CMD7"]; cmd == nil || cmd.Status != "" || !cmd.Active || cmd.ExpiresAt == nil {
+		t.Errorf("unexpected CMD7: %+v", cmd)
+	}
+
+	reportRetrieve(t, q, r, "CMD7", "Acknowledged", "")
+}
+
+// TestScheduledQueue tests that commands scheduled in the future in the
+// queue of id are skipped until they are due and that id is retrieved
+// as scheduled. Commands already in the queue of id are ignored. The
+// queue of id is cleared afterwards.
+func TestScheduledQueue(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueueRetriever
+	storage.CommandScheduleStore
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+
+	now := time.Now()
+	enqueueScheduled(t, q, ctx, id, "CMD8", now.Add(-time.Minute))
+	enqueueScheduled(t, q, ctx, id, "CMD9", now.Add(time.Hour))
+	reportRetrieve(t, q, r, "", "Idle", "CMD8")
+	reportRetrieve(t, q, r, "CMD8", "Acknowledged", "")
+
+	cmds, err := q.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var scheduled *storage.QueuedCommand
+	for _, cmd := range cmds {
+		if cmd.CommandUUID == "CMD9" {
+			scheduled = cmd
+		}
+	}
+	if scheduled == nil || scheduled.Status != "" || !scheduled.Active || scheduled.NotBefore == nil {
+		t.Errorf("unexpected CMD9: %+v", scheduled)
+	}
+
+	for _, tc := range []struct {
+		after, until time.Time
+		found        bool
+	}{
+		{now, now.Add(2 * time.Hour), true},
+		{now.Add(-2 * time.Minute), now, false},
+		{now.Add(2 * time.Hour), now.Add(3 * time.Hour), false},
+	} {
+		ids, err := q.RetrieveScheduledEnrollments(ctx, tc.after, tc.until)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, scheduledID := range ids {
+			if scheduledID == id {
+				found = true
+			}
+		}
+		if have, want := found, tc.found; have != want {
+			t.Errorf("scheduled %s to %s: have %v, want %v", tc.after, tc.until, have, want)
+		}
+	}
+
+	if err = q.ClearQueue(r); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := q.RetrieveScheduledEnrollments(ctx, now, now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, scheduledID := range ids {
+		if scheduledID == id {
+			t.Error("cleared queue still scheduled")
+		}
+	}
+}
+
+// TestQueuedEnrollments tests that id is retrieved as queued only while
+// it has due, unexpired commands without a result. Commands already in
+// the queue of id are ignored. The queue of id is cleared afterwards.
+func TestQueuedEnrollments(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueuedEnrollmentRetriever
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+	queued := func() bool {
+		t.Helper()
+		ids, err := q.RetrieveQueuedEnrollments(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, queuedID := range ids {
+			if queuedID == id {
+				return true
+			}
+		}
+		return false
+	}
+
+	now := time.Now()
+	enqueueScheduled(t, q, ctx, id, "CMD10", now.Add(time.Hour))
+	enqueueExpiring(t, q, ctx, id, "CMD11", now.Add(-time.Minute))
+	if queued() {
+		t.Error("queued with only scheduled and expired commands")
+	}
+
+	enqueue(t, q, ctx, id, "CMD12")
+	if !queued() {
+		t.Error("not queued with pending command")
+	}
+
+	reportRetrieve(t, q, r, "", "Idle", "CMD12")
+	reportRetrieve(t, q, r, "CMD12", "Acknowledged", "")
+	if queued() {
+		t.Error("queued after pending command acknowledged")
+	}
+
+	if err := q.ClearQueue(r); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// enqueuePriority queues a new command with priority
+func enqueuePriority(t *testing.T, q QueueInterfaces, ctx context.Context, id, cmdStr string, priority int) {
+	cmd, err := newCommand(cmdStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Priority = priority
+	enqueueCommand(t, q, ctx, id, cmd)
+}
+
+// TestPriorityQueue tests that higher priority commands are retrieved
+// first regardless of when they were queued.
+func TestPriorityQueue(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueueRetriever
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+
+	enqueue(t, q, ctx, id, "CMD13")
+	enqueuePriority(t, q, ctx, id, "CMD14", mdm.PriorityLow)
+	enqueuePriority(t, q, ctx, id, "CMD15", mdm.PriorityHigh)
+
+	cmds, err := q.RetrieveQueue(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	priorities := make(map[string]int)
+	for _, cmd := range cmds {
+		priorities[cmd.CommandUUID] = cmd.Priority
+	}
+	for uuid, want := range map[string]int{"CMD13": mdm.PriorityNormal, "CMD14": mdm.PriorityLow, "CMD15": mdm.PriorityHigh} {
+		if have := priorities[uuid]; have != want {
+			t.Errorf("priority of %s: have %d, want %d", uuid, have, want)
+		}
+	}
+
+	// the next command carries its priority
+	next, err := q.RetrieveNextCommand(r, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == nil || next.CommandUUID != "CMD15" || next.Priority != mdm.PriorityHigh {
+		t.Errorf("next command: have %v, want CMD15 with priority %d", next, mdm.PriorityHigh)
+	}
+
+	reportRetrieve(t, q, r, "", "Idle", "CMD15")
+	reportRetrieve(t, q, r, "CMD15", "Acknowledged", "CMD13")
+	reportRetrieve(t, q, r, "CMD13", "Acknowledged", "CMD14")
+	reportRetrieve(t, q, r, "CMD14", "Acknowledged", "")
+}
+
+// TestQueuedCommands tests retrieving and canceling the commands
+// waiting in the queue of id. Commands already in the queue of id are
+// ignored.
+func TestQueuedCommands(t *testing.T, id string, q interface {
+	QueueInterfaces
+	storage.QueuedCommandStore
+}) {
+	ctx := context.Background()
+	r := &mdm.Request{
+		EnrollID: &mdm.EnrollID{Type: mdm.Device, ID: id},
+		Context:  ctx,
+	}
+	queued := func() (uuids []string) {
+		t.Helper()
+		cmds, err := q.RetrieveQueuedCommands(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cmd := range cmds {
+			switch cmd.CommandUUID {
+			case "CMD16", "CMD17", "CMD18", "CMD19":
+				uuids = append(uuids, cmd.CommandUUID+cmd.Status)
+			}
+		}
+		return
+	}
+	cancel := func(uuid string, want bool) {
+		t.Helper()
+		canceled, err := q.CancelQueuedCommand(ctx, id, uuid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := canceled; have != want {
+			t.Errorf("cancel %s: have %v, want %v", uuid, have, want)
+		}
+	}
+
+	enqueue(t, q, ctx, id, "CMD16")
+	enqueuePriority(t, q, ctx, id, "CMD17", mdm.PriorityHigh)
+	enqueueExpiring(t, q, ctx, id, "CMD18", time.Now().Add(-time.Minute))
+	if have, want := strings.Join(queued(), ","), "CMD17,CMD16"; have != want {
+		t.Errorf("queued: have %s, want %s", have, want)
+	}
+
+	// canceling a command sent but not yet reported prevents neither
+	// its report nor canceling the rest of the queue
+	reportRetrieve(t, q, r, "", "Idle", "CMD17")
+	cancel("CMD16", true)
+	cancel("CMD16", false)
+	reportRetrieve(t, q, r, "CMD17", "Acknowledged", "")
+	cancel("CMD17", false)
+	cancel("CMD20", false)
+
+	enqueue(t, q, ctx, id, "CMD19")
+	reportRetrieve(t, q, r, "", "Idle", "CMD19")
+	reportRetrieve(t, q, r, "CMD19", "NotNow", "")
+	if have, want := strings.Join(queued(), ","), "CMD19NotNow"; have != want {
+		t.Errorf("queued: have %s, want %s", have, want)
+	}
+	cancel("CMD19", true)
+	reportRetrieve(t, q, r, "", "Idle", "")
+	if have := queued(); len(have) > 0 {
+		t.Errorf("queued after cancel: %v", have)
+	}
+
+	if err := q.ClearQueue(r); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,58 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestMetricsRollups tests adding to and retrieving metrics rollups of store.
+func TestMetricsRollups(t *testing.T, store storage.MetricsRollupStore) {
+	ctx := context.Background()
+
+	// use a far future period so other rollups in the store don't interfere
+	start := time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, rollup := range []*storage.MetricsRollup{
+		{Period: storage.RollupHour, Start: start, Checkins: 2, Commands: 3, Errors: 1},
+		{Period: storage.RollupHour, Start: start, Checkins: 1, Commands: 1},
+		{Period: storage.RollupHour, Start: start.Add(time.Hour), Checkins: 5},
+		{Period: storage.RollupDay, Start: start, Checkins: 8, Commands: 4, Errors: 1},
+	} {
+		if err := store.AddMetricsRollup(ctx, rollup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rollups, err := store.RetrieveMetricsRollups(ctx, storage.RollupHour, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(rollups), 2; have != want {
+		t.Fatalf("hourly rollups: have %d, want %d", have, want)
+	}
+	r := rollups[0]
+	if !r.Start.Equal(start) || r.Period != storage.RollupHour || r.Checkins != 3 || r.Commands != 4 || r.Errors != 1 {
+		t.Errorf("unexpected first rollup: %+v", r)
+	}
+	if r = rollups[1]; !r.Start.Equal(start.Add(time.Hour)) || r.Checkins != 5 {
+		t.Errorf("unexpected second rollup: %+v", r)
+	}
+
+	rollups, err = store.RetrieveMetricsRollups(ctx, storage.RollupHour, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(rollups), 1; have != want {
+		t.Errorf("hourly rollups since: have %d, want %d", have, want)
+	}
+
+	rollups, err = store.RetrieveMetricsRollups(ctx, storage.RollupDay, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].Checkins != 8 {
+		t.Errorf("unexpected daily rollups: %+v", rollups)
+	}
+}
@@ -0,0 +1,42 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestEnrollmentSupersessions tests storing and retrieving enrollment
+// supersessions of store.
+func TestEnrollmentSupersessions(t *testing.T, store storage.EnrollmentSupersessionStore) {
+	ctx := context.Background()
+
+	for _, ss := range []*storage.EnrollmentSupersession{
+		{ID: "test-superseded-1", SupersededBy: "test-superseding-0"},
+		{ID: "test-superseded-1", SupersededBy: "test-superseding-1"},
+		{ID: "test-superseded-2", SupersededBy: "test-superseding-2"},
+	} {
+		if err := store.StoreEnrollmentSupersession(ctx, ss); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	supersessions, err := store.RetrieveEnrollmentSupersessions(ctx, []string{"test-superseded-1", "test-not-superseded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(supersessions), 1; have != want {
+		t.Fatalf("supersessions: have %d, want %d", have, want)
+	}
+	if ss := supersessions["test-superseded-1"]; ss == nil || ss.SupersededBy != "test-superseding-1" || ss.CreatedAt.IsZero() {
+		t.Errorf("unexpected supersession: %+v", ss)
+	}
+
+	if supersessions, err = store.RetrieveEnrollmentSupersessions(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if supersessions["test-superseded-1"] == nil || supersessions["test-superseded-2"] == nil {
+		t.Errorf("expected all supersessions, have %v", supersessions)
+	}
+}
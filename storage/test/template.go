@@ -0,0 +1,73 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestMessageTemplates tests storing and deleting message template
+// variants of store.
+func TestMessageTemplates(t *testing.T, store storage.MessageTemplateStore) {
+	ctx := context.Background()
+
+	for _, template := range []*storage.MessageTemplate{
+		{Name: "test-lost", Message: "Lost"},
+		{Name: "test-lost", Locale: "de", Message: "Verloren"},
+		{Name: "test-lost", Group: "lab", Message: "Return to lab", PhoneNumber: "555-0100"},
+		{Name: "test-lost", Group: "lab", Message: "Return to the lab", PhoneNumber: "555-0101"},
+		{Name: "test-wipe", Message: "Wiped"},
+	} {
+		if err := store.StoreMessageTemplate(ctx, template); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	templates, err := store.RetrieveMessageTemplates(ctx, "test-lost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(templates), 3; have != want {
+		t.Fatalf("variants: have %d, want %d", have, want)
+	}
+	// ordered by group then locale
+	if v := templates[0]; v.Group != "" || v.Locale != "" || v.Message != "Lost" || v.UpdatedAt.IsZero() {
+		t.Errorf("unexpected default variant: %+v", v)
+	}
+	if v := templates[1]; v.Locale != "de" || v.Message != "Verloren" {
+		t.Errorf("unexpected locale variant: %+v", v)
+	}
+	if v := templates[2]; v.Group != "lab" || v.Message != "Return to the lab" || v.PhoneNumber != "555-0101" {
+		t.Errorf("unexpected group variant: %+v", v)
+	}
+
+	if templates, err = store.RetrieveMessageTemplates(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(templates), 4; have != want {
+		t.Errorf("all variants: have %d, want %d", have, want)
+	}
+
+	if err = store.DeleteMessageTemplate(ctx, "test-lost", "", "de"); err != nil {
+		t.Fatal(err)
+	}
+	if templates, err = store.RetrieveMessageTemplates(ctx, "test-lost"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(templates), 2; have != want {
+		t.Errorf("variants after delete: have %d, want %d", have, want)
+	}
+
+	for _, v := range []struct{ name, group string }{{"test-lost", ""}, {"test-lost", "lab"}, {"test-wipe", ""}} {
+		if err = store.DeleteMessageTemplate(ctx, v.name, v.group, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if templates, err = store.RetrieveMessageTemplates(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(templates), 0; have != want {
+		t.Errorf("variants after deleting all: have %d, want %d", have, want)
+	}
+}
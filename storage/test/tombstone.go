@@ -0,0 +1,91 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TombstoneInterfaces are the storage interfaces needed for testing
+// departing enrollments.
+type TombstoneInterfaces interface {
+	storage.EnrollmentRetriever
+	storage.EnrollmentAliasResolver
+	storage.EnrollmentEvictionStore
+	storage.EnrollmentTombstoneStore
+}
+
+// TestEnrollmentTombstones tests departing the (already enrolled)
+// device enrollment id with serial number serial.
+func TestEnrollmentTombstones(t *testing.T, id, serial string, store TombstoneInterfaces) {
+	ctx := context.Background()
+
+	err := store.StoreEnrollmentEviction(ctx, &storage.EnrollmentEviction{ID: id, Reason: "retired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tombstone, err := store.DepartEnrollment(ctx, &storage.EnrollmentTombstone{ID: "test-not-enrolled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone != nil {
+		t.Errorf("departed enrollment that is not enrolled: %+v", tombstone)
+	}
+
+	tombstone, err = store.DepartEnrollment(ctx, &storage.EnrollmentTombstone{ID: id, Reason: "retired", DepartedBy: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone == nil {
+		t.Fatal("nil tombstone")
+	}
+	if tombstone.ID != id || tombstone.SerialNumber != serial || tombstone.Reason != "retired" || tombstone.DepartedBy != "alice" {
+		t.Errorf("unexpected tombstone: %+v", tombstone)
+	}
+	if tombstone.Type == "" || tombstone.EnrolledAt == nil || tombstone.LastSeenAt == nil || tombstone.DepartedAt.IsZero() {
+		t.Errorf("tombstone missing type or dates: %+v", tombstone)
+	}
+
+	enrollments, err := store.RetrieveEnrollments(ctx, &storage.EnrollmentFilter{IDs: []string{id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(enrollments), 0; have != want {
+		t.Errorf("enrollments: have %d, want %d", have, want)
+	}
+	resolved, err := store.ResolveEnrollmentIDs(ctx, []string{serial})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := resolved[serial]; ok {
+		t.Errorf("departed serial resolved to %q", id)
+	}
+	evictions, err := store.RetrieveEnrollmentEvictions(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(evictions), 0; have != want {
+		t.Errorf("evictions: have %d, want %d", have, want)
+	}
+
+	tombstones, err := store.RetrieveEnrollmentTombstones(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tombstone := tombstones[id]; tombstone == nil || tombstone.SerialNumber != serial || tombstone.DepartedBy != "alice" {
+		t.Errorf("unexpected retrieved tombstone: %+v", tombstone)
+	}
+
+	if err = store.DeleteEnrollmentTombstone(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	tombstones, err = store.RetrieveEnrollmentTombstones(ctx, []string{id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(tombstones), 0; have != want {
+		t.Errorf("tombstones after delete: have %d, want %d", have, want)
+	}
+}
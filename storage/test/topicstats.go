@@ -0,0 +1,60 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TopicStatsInterfaces are the storage interfaces needed for testing
+// topic statistics.
+type TopicStatsInterfaces interface {
+	QueueInterfaces
+	EnrollmentInterfaces
+	storage.TopicStatsRetriever
+}
+
+func retrieveTopicStats(t *testing.T, store TopicStatsInterfaces, ctx context.Context, topic string) *storage.TopicStats {
+	stats, err := store.RetrieveTopicStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ts := range stats {
+		if i > 0 && stats[i-1].Topic >= ts.Topic {
+			t.Errorf("topic stats not ordered: %q before %q", stats[i-1].Topic, ts.Topic)
+		}
+		if ts.Topic == topic {
+			return ts
+		}
+	}
+	t.Fatalf("topic stats: topic %q not found", topic)
+	return nil
+}
+
+// TestTopicStats tests the topic statistics of the (already enrolled
+// and enabled) device enrollment id as a command is queued and
+// acknowledged.
+func TestTopicStats(t *testing.T, id string, store TopicStatsInterfaces) {
+	ctx := context.Background()
+
+	e := retrieveEnrollment(t, store, ctx, id)
+	before := retrieveTopicStats(t, store, ctx, e.Topic)
+	if before.Enrollments < 1 || before.ActiveEnrollments < 1 {
+		t.Errorf("enrollment counts: have %d (%d active), want at least 1", before.Enrollments, before.ActiveEnrollments)
+	}
+
+	enqueue(t, store, ctx, id, "TopicStatsCmd")
+	ts := retrieveTopicStats(t, store, ctx, e.Topic)
+	if have, want := ts.PendingCommands, before.PendingCommands+1; have != want {
+		t.Errorf("pending commands: have %d, want %d", have, want)
+	}
+
+	r := &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: id}}
+	report(t, store, r, "TopicStatsCmd", "Acknowledged")
+	ts = retrieveTopicStats(t, store, ctx, e.Topic)
+	if have, want := ts.PendingCommands, before.PendingCommands; have != want {
+		t.Errorf("pending commands: have %d, want %d", have, want)
+	}
+}
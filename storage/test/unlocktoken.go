@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestUnlockTokenRequests tests storing, approving, and deleting
+// UnlockToken requests of store.
+func TestUnlockTokenRequests(t *testing.T, store storage.UnlockTokenStore) {
+	ctx := context.Background()
+
+	token, err := store.RetrieveUnlockToken(ctx, "test-unlock-token-unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != nil {
+		t.Errorf("unexpected token of unknown enrollment: %x", token)
+	}
+
+	for _, req := range []*storage.UnlockTokenRequest{
+		{ID: "test-req-1", EnrollmentID: "test-id-1", Reason: "forgotten passcode", RequestedBy: "alice"},
+		{ID: "test-req-2", EnrollmentID: "test-id-2", RequestedBy: "alice"},
+	} {
+		if err = store.StoreUnlockTokenRequest(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqs, err := store.RetrieveUnlockTokenRequests(ctx, []string{"test-req-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(reqs), 1; have != want {
+		t.Fatalf("requests: have %d, want %d", have, want)
+	}
+	if r := reqs[0]; r.EnrollmentID != "test-id-1" || r.Reason != "forgotten passcode" || r.RequestedBy != "alice" || r.CreatedAt.IsZero() || r.ApprovedAt != nil {
+		t.Errorf("unexpected request: %+v", r)
+	}
+
+	// a request is only approved once
+	for i, want := range []bool{true, false} {
+		approved, err := store.ApproveUnlockTokenRequest(ctx, "test-req-1", "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if approved != want {
+			t.Errorf("approval %d: have %v, want %v", i, approved, want)
+		}
+	}
+	if approved, err := store.ApproveUnlockTokenRequest(ctx, "test-req-missing", "bob"); err != nil || approved {
+		t.Errorf("approved missing request: %v, %v", approved, err)
+	}
+
+	if reqs, err = store.RetrieveUnlockTokenRequests(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	var approved *storage.UnlockTokenRequest
+	for _, r := range reqs {
+		if r.ID == "test-req-1" {
+			approved = r
+		}
+	}
+	if approved == nil || approved.ApprovedBy != "bob" || approved.ApprovedAt == nil {
+		t.Errorf("unexpected approved request: %+v", approved)
+	}
+
+	// a request is only deleted once
+	for _, id := range []string{"test-req-1", "test-req-2"} {
+		for i, want := range []bool{true, false} {
+			deleted, err := store.DeleteUnlockTokenRequest(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != want {
+				t.Errorf("%s: deletion %d: have %v, want %v", id, i, deleted, want)
+			}
+		}
+	}
+	if reqs, err = store.RetrieveUnlockTokenRequests(ctx, []string{"test-req-1", "test-req-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(reqs), 0; have != want {
+		t.Errorf("requests after delete: have %d, want %d", have, want)
+	}
+}
@@ -0,0 +1,56 @@
+package test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/micromdm/nanomdm/mdm"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+func retrieveUserSessions(t *testing.T, store storage.UserSessionStore, ctx context.Context, deviceID string) *storage.UserSessions {
+	sessions, err := store.RetrieveUserSessions(ctx, []string{deviceID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions[deviceID] == nil || sessions[deviceID].Current == nil {
+		t.Fatalf("no current user session for %s", deviceID)
+	}
+	return sessions[deviceID]
+}
+
+// TestUserSessions tests user sessions of the (already enrolled)
+// device enrollment deviceID.
+func TestUserSessions(t *testing.T, deviceID string, store storage.UserSessionStore) {
+	ctx := context.Background()
+	newReq := func(user string) *mdm.Request {
+		return &mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{
+			Type:     mdm.User,
+			ID:       deviceID + ":" + user,
+			ParentID: deviceID,
+		}}
+	}
+
+	for _, user := range []string{"user1", "user2", "user2"} {
+		if err := store.StoreUserSession(newReq(user), user+"@example.com", "User"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := retrieveUserSessions(t, store, ctx, deviceID)
+	if have, want := sessions.Current.EnrollmentID, deviceID+":user2"; have != want {
+		t.Errorf("current enrollment id: have %q, want %q", have, want)
+	}
+	if have, want := sessions.Current.UserShortName, "user2@example.com"; have != want {
+		t.Errorf("current user short name: have %q, want %q", have, want)
+	}
+	if sessions.Previous == nil {
+		t.Fatal("no previous user session")
+	}
+	if have, want := sessions.Previous.EnrollmentID, deviceID+":user1"; have != want {
+		t.Errorf("previous enrollment id: have %q, want %q", have, want)
+	}
+
+	if err := store.StoreUserSession(&mdm.Request{Context: ctx, EnrollID: &mdm.EnrollID{ID: deviceID}}, "", ""); err == nil {
+		t.Error("expected error storing a device channel user session")
+	}
+}
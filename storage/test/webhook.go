@@ -0,0 +1,88 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// TestWebhookDeliveries tests storing, retrieving, dead-lettering, and
+// deleting webhook deliveries of store.
+func TestWebhookDeliveries(t *testing.T, store storage.WebhookDeliveryStore) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+
+	for _, d := range []*storage.WebhookDelivery{
+		{ID: "test-delivery-1", NextAttemptAt: now.Add(-time.Minute)},
+		{ID: "test-delivery-2", NextAttemptAt: now.Add(-2 * time.Minute), Attempts: 2, LastError: "timeout"},
+		{ID: "test-delivery-3", NextAttemptAt: now.Add(time.Hour)},
+		{ID: "test-delivery-4", NextAttemptAt: now.Add(-time.Hour), Dead: true},
+	} {
+		d.URL = "http://localhost/webhook"
+		d.Topic = "mdm.Connect"
+		d.Version = 1
+		d.Body = []byte(`{"topic":"mdm.Connect"}`)
+		if err := store.StoreWebhookDelivery(ctx, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due, err := store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{DueBy: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(due), 2; have != want {
+		t.Fatalf("due deliveries: have %d, want %d", have, want)
+	}
+	if have, want := due[0].ID, "test-delivery-2"; have != want {
+		t.Errorf("first due delivery: have %q, want %q", have, want)
+	}
+	if d := due[0]; d.Attempts != 2 || d.LastError != "timeout" || string(d.Body) != `{"topic":"mdm.Connect"}` || d.Topic != "mdm.Connect" || d.CreatedAt.IsZero() {
+		t.Errorf("unexpected delivery: %+v", d)
+	}
+
+	pending, err := store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pending), 2; have != want {
+		t.Errorf("limited pending deliveries: have %d, want %d", have, want)
+	}
+
+	// dead-letter a delivery
+	d := due[0]
+	d.Attempts++
+	d.Dead = true
+	if err = store.StoreWebhookDelivery(ctx, d); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{Dead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(dead), 2; have != want {
+		t.Fatalf("dead deliveries: have %d, want %d", have, want)
+	}
+	dead, err = store.RetrieveWebhookDeliveries(ctx, &storage.WebhookDeliveryFilter{IDs: []string{"test-delivery-2"}, Dead: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Attempts != 3 {
+		t.Errorf("unexpected dead deliveries: %+v", dead)
+	}
+
+	for _, id := range []string{"test-delivery-1", "test-delivery-2", "test-delivery-3", "test-delivery-4"} {
+		if err = store.DeleteWebhookDelivery(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pending, err = store.RetrieveWebhookDeliveries(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(pending), 0; have != want {
+		t.Errorf("deliveries after delete: have %d, want %d", have, want)
+	}
+}
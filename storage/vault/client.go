@@ -0,0 +1,174 @@
+// Package vault stores and retrieves APNs push certificates and other
+// secrets in a HashiCorp Vault KV version 2 secrets engine.
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrNotFound is returned when a secret does not exist.
+var ErrNotFound = errors.New("vault: secret not found")
+
+// Client is a minimal Vault KV version 2 HTTP API client.
+type Client struct {
+	addr      string
+	token     string
+	mount     string
+	namespace string
+	client    *http.Client
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*Client)
+
+// WithMount sets the mount path of the KV secrets engine.
+// The default is "secret".
+func WithMount(mount string) ClientOption {
+	return func(c *Client) {
+		c.mount = strings.Trim(mount, "/")
+	}
+}
+
+// WithNamespace sets the Vault Enterprise namespace.
+func WithNamespace(namespace string) ClientOption {
+	return func(c *Client) {
+		c.namespace = namespace
+	}
+}
+
+// WithHTTPClient sets the HTTP client used to talk to Vault.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// NewClient creates a new Vault client for the Vault server at addr
+// (e.g. "https://vault.example.com:8200") authenticating with token.
+func NewClient(addr, token string, opts ...ClientOption) (*Client, error) {
+	if addr == "" {
+		return nil, errors.New("vault: empty address")
+	}
+	if token == "" {
+		return nil, errors.New("vault: empty token")
+	}
+	c := &Client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		mount:  "secret",
+		client: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// Secret is a version of a KV secret.
+type Secret struct {
+	Data    map[string]string
+	Version int
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.addr+"/v1/"+c.mount+"/"+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&errResp)
+		return fmt.Errorf("vault: %s %s: HTTP status %d: %s", method, path, resp.StatusCode, strings.Join(errResp.Errors, "; "))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Read reads the latest version of the secret at path.
+func (c *Client) Read(ctx context.Context, path string) (*Secret, error) {
+	var resp struct {
+		Data struct {
+			Data     map[string]string `json:"data"`
+			Metadata struct {
+				Version int `json:"version"`
+			} `json:"metadata"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "data/"+path, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Data == nil {
+		// deleted (but not destroyed) secrets have no data
+		return nil, ErrNotFound
+	}
+	return &Secret{Data: resp.Data.Data, Version: resp.Data.Metadata.Version}, nil
+}
+
+// Version returns the latest version of the secret at path without
+// reading the secret data.
+func (c *Client) Version(ctx context.Context, path string) (int, error) {
+	var resp struct {
+		Data struct {
+			CurrentVersion int `json:"current_version"`
+		} `json:"data"`
+	}
+	if err := c.do(ctx, http.MethodGet, "metadata/"+path, nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Data.CurrentVersion, nil
+}
+
+// Write writes data as a new version of the secret at path.
+func (c *Client) Write(ctx context.Context, path string, data map[string]string) error {
+	return c.do(ctx, http.MethodPost, "data/"+path, map[string]interface{}{"data": data}, nil)
+}
+
+// List lists the secret names below path.
+func (c *Client) List(ctx context.Context, path string) ([]string, error) {
+	var resp struct {
+		Data struct {
+			Keys []string `json:"keys"`
+		} `json:"data"`
+	}
+	err := c.do(ctx, http.MethodGet, "metadata/"+path+"?list=true", nil, &resp)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return resp.Data.Keys, nil
+}
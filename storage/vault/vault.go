@@ -0,0 +1,183 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+	"github.com/micromdm/nanomdm/storage"
+)
+
+// Push certificate secret fields.
+const (
+	FieldCertificate = "certificate"
+	FieldPrivateKey  = "private_key"
+)
+
+// DefaultStaleCheckInterval is the default minimum time between checking
+// Vault for a new version of a push certificate.
+const DefaultStaleCheckInterval = time.Minute
+
+// PushCertStore stores APNs push certificates in Vault. Each push
+// certificate is a secret named after its topic below a path prefix
+// with the PEM certificate and private key in the "certificate" and
+// "private_key" fields. Rotating a push certificate is writing a new
+// version of its secret: the secret version is the stale token.
+type PushCertStore struct {
+	client   *Client
+	prefix   string
+	interval time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	checked map[string]time.Time
+}
+
+// PushCertOption configures a PushCertStore.
+type PushCertOption func(*PushCertStore)
+
+// WithStaleCheckInterval sets the minimum time between checking Vault
+// for a new version of a push certificate. The push certificate is
+// otherwise checked every time a push is sent.
+func WithStaleCheckInterval(interval time.Duration) PushCertOption {
+	return func(s *PushCertStore) {
+		s.interval = interval
+	}
+}
+
+// NewPushCertStore creates a new push certificate store for the
+// secrets below prefix (e.g. "nanomdm/pushcerts").
+func NewPushCertStore(client *Client, prefix string, opts ...PushCertOption) *PushCertStore {
+	s := &PushCertStore{
+		client:   client,
+		prefix:   strings.Trim(prefix, "/"),
+		interval: DefaultStaleCheckInterval,
+		now:      time.Now,
+		checked:  make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *PushCertStore) path(topic string) string {
+	return path.Join(s.prefix, topic)
+}
+
+// RetrievePushCert reads the push certificate for topic from Vault.
+func (s *PushCertStore) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+	secret, err := s.client.Read(ctx, s.path(topic))
+	if err != nil {
+		return nil, "", err
+	}
+	cert, err := tls.X509KeyPair([]byte(secret.Data[FieldCertificate]), []byte(secret.Data[FieldPrivateKey]))
+	if err != nil {
+		return nil, "", fmt.Errorf("vault: push cert for topic %q: %w", topic, err)
+	}
+	s.mu.Lock()
+	s.checked[topic] = s.now()
+	s.mu.Unlock()
+	return &cert, strconv.Itoa(secret.Version), nil
+}
+
+// IsPushCertStale checks whether a new version of the push certificate
+// secret for topic has been written to Vault.
+func (s *PushCertStore) IsPushCertStale(ctx context.Context, topic string, staleToken string) (bool, error) {
+	now := s.now()
+	s.mu.Lock()
+	recent := now.Sub(s.checked[topic]) < s.interval
+	s.mu.Unlock()
+	if recent {
+		return false, nil
+	}
+	version, err := s.client.Version(ctx, s.path(topic))
+	if err != nil {
+		return true, err
+	}
+	s.mu.Lock()
+	s.checked[topic] = now
+	s.mu.Unlock()
+	return strconv.Itoa(version) != staleToken, nil
+}
+
+// StorePushCert writes a new version of the push certificate secret.
+func (s *PushCertStore) StorePushCert(ctx context.Context, pemCert, pemKey []byte) error {
+	topic, err := cryptoutil.TopicFromPEMCert(pemCert)
+	if err != nil {
+		return err
+	}
+	if _, err = tls.X509KeyPair(pemCert, pemKey); err != nil {
+		return err
+	}
+	return s.client.Write(ctx, s.path(topic), map[string]string{
+		FieldCertificate: string(pemCert),
+		FieldPrivateKey:  string(pemKey),
+	})
+}
+
+// RetrievePushCertTopics lists the push certificate topics in Vault.
+func (s *PushCertStore) RetrievePushCertTopics(ctx context.Context) ([]string, error) {
+	keys, err := s.client.List(ctx, s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	var topics []string
+	for _, key := range keys {
+		// skip "sub-directories"
+		if !strings.HasSuffix(key, "/") {
+			topics = append(topics, key)
+		}
+	}
+	return topics, nil
+}
+
+// Storage is a storage decorator which stores push certificates in
+// Vault instead of the wrapped storage.
+type Storage struct {
+	storage.AllStorage
+	certs *PushCertStore
+}
+
+// New wraps store storing push certificates in certs.
+func New(store storage.AllStorage, certs *PushCertStore) *Storage {
+	return &Storage{AllStorage: store, certs: certs}
+}
+
+func (s *Storage) RetrievePushCert(ctx context.Context, topic string) (*tls.Certificate, string, error) {
+	return s.certs.RetrievePushCert(ctx, topic)
+}
+
+func (s *Storage) IsPushCertStale(ctx context.Context, topic string, staleToken string) (bool, error) {
+	return s.certs.IsPushCertStale(ctx, topic, staleToken)
+}
+
+func (s *Storage) StorePushCert(ctx context.Context, pemCert, pemKey []byte) error {
+	return s.certs.StorePushCert(ctx, pemCert, pemKey)
+}
+
+func (s *Storage) RetrievePushCertTopics(ctx context.Context) ([]string, error) {
+	return s.certs.RetrievePushCertTopics(ctx)
+}
+
+// Unwrap returns the wrapped storage.
+func (s *Storage) Unwrap() storage.AllStorage {
+	return s.AllStorage
+}
+
+// Capabilities reports the capabilities of the wrapped storage.
+func (s *Storage) Capabilities() storage.Capabilities {
+	return storage.DiscoverCapabilities(s.AllStorage)
+}
+
+// InTransaction calls f in a transaction of the wrapped storage if it
+// supports transactions.
+func (s *Storage) InTransaction(ctx context.Context, f func(ctx context.Context) error) error {
+	return storage.InTransaction(ctx, s.AllStorage, f)
+}
@@ -0,0 +1,167 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/micromdm/nanomdm/cryptoutil"
+)
+
+func newTopicCertPEM(t *testing.T, topic string) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{ExtraNames: []pkix.AttributeTypeAndValue{
+			{Type: asn1.ObjectIdentifier{0, 9, 2342, 19200300, 100, 1, 1}, Value: topic},
+		}},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cryptoutil.PEMCertificate(der), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// fakeKV is an in-memory Vault KV version 2 secrets engine.
+type fakeKV struct {
+	mu      sync.Mutex
+	secrets map[string][]map[string]string // versions
+}
+
+func (kv *fakeKV) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Vault-Token") != "token" {
+		http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/v1/secret/data/") && r.Method == http.MethodPost:
+		var body struct {
+			Data map[string]string `json:"data"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		name := strings.TrimPrefix(p, "/v1/secret/data/")
+		kv.secrets[name] = append(kv.secrets[name], body.Data)
+	case strings.HasPrefix(p, "/v1/secret/data/"):
+		versions := kv.secrets[strings.TrimPrefix(p, "/v1/secret/data/")]
+		if len(versions) < 1 {
+			http.NotFound(w, r)
+			return
+		}
+		resp := map[string]interface{}{"data": map[string]interface{}{
+			"data":     versions[len(versions)-1],
+			"metadata": map[string]int{"version": len(versions)},
+		}}
+		json.NewEncoder(w).Encode(resp)
+	case strings.HasPrefix(p, "/v1/secret/metadata/") && r.URL.Query().Get("list") == "true":
+		prefix := strings.TrimPrefix(p, "/v1/secret/metadata/") + "/"
+		var keys []string
+		for name := range kv.secrets {
+			if strings.HasPrefix(name, prefix) {
+				keys = append(keys, strings.TrimPrefix(name, prefix))
+			}
+		}
+		if len(keys) < 1 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+	case strings.HasPrefix(p, "/v1/secret/metadata/"):
+		versions := kv.secrets[strings.TrimPrefix(p, "/v1/secret/metadata/")]
+		if len(versions) < 1 {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"current_version": len(versions)}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestPushCertStore(t *testing.T) {
+	srv := httptest.NewServer(&fakeKV{secrets: make(map[string][]map[string]string)})
+	defer srv.Close()
+	client, err := NewClient(srv.URL, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewPushCertStore(client, "/nanomdm/pushcerts/", WithStaleCheckInterval(time.Minute))
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, _, err = s.RetrievePushCert(ctx, "com.apple.mgmt.test"); err != ErrNotFound {
+		t.Errorf("missing cert: have %v, want %v", err, ErrNotFound)
+	}
+	topics, err := s.RetrievePushCertTopics(ctx)
+	if err != nil || len(topics) != 0 {
+		t.Errorf("topics: have %v (%v), want none", topics, err)
+	}
+
+	certPEM, keyPEM := newTopicCertPEM(t, "com.apple.mgmt.test")
+	if err = s.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	cert, staleToken, err := s.RetrievePushCert(ctx, "com.apple.mgmt.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.PrivateKey == nil {
+		t.Error("expected private key")
+	}
+	if have, want := staleToken, "1"; have != want {
+		t.Errorf("stale token: have %q, want %q", have, want)
+	}
+	if topics, err = s.RetrievePushCertTopics(ctx); err != nil || len(topics) != 1 || topics[0] != "com.apple.mgmt.test" {
+		t.Errorf("topics: have %v (%v)", topics, err)
+	}
+
+	// rotate the push certificate
+	certPEM, keyPEM = newTopicCertPEM(t, "com.apple.mgmt.test")
+	if err = s.StorePushCert(ctx, certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	stale, err := s.IsPushCertStale(ctx, "com.apple.mgmt.test", staleToken)
+	if err != nil || stale {
+		t.Errorf("stale within check interval: have %v (%v), want false", stale, err)
+	}
+	now = now.Add(time.Minute)
+	stale, err = s.IsPushCertStale(ctx, "com.apple.mgmt.test", staleToken)
+	if err != nil || !stale {
+		t.Errorf("stale after check interval: have %v (%v), want true", stale, err)
+	}
+	if _, staleToken, err = s.RetrievePushCert(ctx, "com.apple.mgmt.test"); err != nil || staleToken != "2" {
+		t.Errorf("rotated stale token: have %q (%v), want %q", staleToken, err, "2")
+	}
+
+	bad, _ := NewClient(srv.URL, "bad")
+	if _, _, err = NewPushCertStore(bad, "nanomdm/pushcerts").RetrievePushCert(ctx, "com.apple.mgmt.test"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("bad token: have %v", err)
+	}
+}